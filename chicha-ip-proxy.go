@@ -3,40 +3,167 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"os"
+	"os/signal"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/matveynator/chicha-ip-proxy/pkg/activation"
+	"github.com/matveynator/chicha-ip-proxy/pkg/admin"
 	"github.com/matveynator/chicha-ip-proxy/pkg/branding"
+	"github.com/matveynator/chicha-ip-proxy/pkg/certstore"
 	"github.com/matveynator/chicha-ip-proxy/pkg/config"
 	"github.com/matveynator/chicha-ip-proxy/pkg/limits"
 	"github.com/matveynator/chicha-ip-proxy/pkg/logging"
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+	"github.com/matveynator/chicha-ip-proxy/pkg/netns"
 	"github.com/matveynator/chicha-ip-proxy/pkg/proxy"
 	"github.com/matveynator/chicha-ip-proxy/pkg/setup"
 	"github.com/matveynator/chicha-ip-proxy/pkg/version"
 )
 
+// openFilesOverhead covers listeners for the admin API, log files, and the runtime beyond the per-route estimate.
+const openFilesOverhead = 64
+
+// maxMaintenanceReply keeps -maintenance-file to a page that fits in socket buffers, so refused clients are answered at once.
+const maxMaintenanceReply = 64 << 10
+
 func main() {
 	localFlag := flag.String("local", "", "Local port to listen on")
 	remoteFlag := flag.String("remote", "", "Remote target IP or IP:PORT")
-	protoFlag := flag.String("proto", "tcp", "Protocol to proxy: tcp or udp")
+	protoFlag := flag.String("proto", "tcp", "Protocol to proxy: tcp, udp, or both (one route on each transport)")
 	allowFlags := repeatedFlag{}
+	configFile := flag.String("config", "", "JSON or YAML file with \"tcp\" and \"udp\" route lists in -routes syntax and optional \"log\" and \"rotation\" settings, or a directory of such files merged in name order (- reads stdin)")
+	configKeyFile := flag.String("config-key-file", "", "Base64 AES-256 key that opens an encrypted -config (default: $"+config.KeyEnv+")")
+	configEncrypt := flag.Bool("config-encrypt", false, "Encrypt the config read from stdin with the config key, write it to stdout, and exit")
+	configURL := flag.String("config-url", "", "URL serving a JSON route array like -routes-json, polled for changes (ETag aware) instead of -config")
+	configReloadInterval := flag.Duration("config-reload-interval", 0, "Poll -config at this interval and apply changed routes (0 disables; -config-url defaults to 30s)")
+	egressPolicy := flag.String("egress-policy", "", "Refuse backend dials whose route breaks these rules (Linux): via=IFACE[+IFACE] and/or no-loop, comma separated")
+	egressIPPool := flag.String("egress-ip-pool", "", "Comma-separated local source IPs that backend TCP dials rotate through")
+	udpEgressIPPool := flag.String("udp-egress-ip-pool", "", "Comma-separated local source IPs that new UDP sessions rotate through")
+	upstreamMaxDials := flag.Int("upstream-max-dials", 0, "Simultaneous TCP dials allowed to each backend; more queue within the 10s dial timeout (0 is unlimited)")
+	setupFormat := flag.String("setup-format", setup.FormatColor, "Setup wizard output: color for people, plain for scripts (PROMPT:key lines)")
+	socketActivation := flag.Bool("socket-activation", false, "Generate systemd .socket units during setup so systemd binds the route ports")
+	systemdNotify := flag.Bool("systemd-notify", false, "Generate a Type=notify systemd unit during setup, so dependent units wait until the proxy is ready")
+	systemdWatchdog := flag.Duration("systemd-watchdog", 0, "WatchdogSec= of the -systemd-notify unit; systemd restarts a proxy that stops pinging. 0 leaves it off")
+	unitOutput := flag.String("unit-output", "", "Write the setup wizard's systemd unit to this path (- for stdout) instead of installing it, then exit")
+	initOutput := flag.String("init-output", "", "Write the setup wizard's init script to this path (- for stdout) instead of installing it, then exit")
+	listenAddrFlag := flag.String("listen-addr", "", "Local IP every route binds instead of all interfaces, e.g. a management address")
+	forwardFlag := flag.String("forward", "", "Routes with protocol prefixes, e.g. tcp/8080:10.0.0.1:80,udp/5353:10.0.0.2:53")
+	routesJSONFlag := flag.String("routes-json", "", `Routes as a JSON array, e.g. [{"local":"8080","remote_ip":"::1","remote_port":"80","proto":"tcp"}]`)
 	flag.Var(&allowFlags, "allow", "Client IP or CIDR allowed to use the proxy. Repeat for multiple sources.")
 	logFile := flag.String("log", "chicha-ip-proxy.log", "Path to the log file")
 	rotationFrequency := flag.Duration("rotation", 24*time.Hour, "Log rotation frequency (e.g. 24h, 1h, etc.)")
+	rotationMinSize := flag.Int64("rotation-min-size", 0, "Skip a scheduled -rotation while the log holds fewer bytes than this; empty logs are never rotated on schedule")
+	logMode := flag.String("log-mode", logging.ModeDated, "Log rotation mode: dated (time and size, dated archives) or ring (size only, numbered archives)")
+	logMaxSizeMB := flag.Int64("log-max-size", logging.DefaultMaxSizeBytes/(1024*1024), "Rotate the log file once it reaches this many megabytes")
+	logRingFiles := flag.Int("log-ring-files", 5, "Numbered archives kept by -log-mode=ring")
+	logFormat := flag.String("log-format", logging.FormatText, "Log line format: text, json, or logfmt")
+	logTimezone := flag.String("log-timezone", "local", "Log timestamp timezone: local or utc")
+	logMicroseconds := flag.Bool("log-microseconds", false, "Add microseconds to log timestamps")
+	logMkdir := flag.Bool("log-mkdir", false, "Create the directory of -log and -http-access-log when it is missing")
+	logCaller := flag.Bool("log-caller", false, "Add the source file:line of each log call, for debugging the proxy itself")
+	logRemote := flag.String("log-remote", "", "Also send every log line as JSON to a collector at tcp://host:port or udp://host:port; lines are dropped while it is down")
+	logBuffer := flag.Int("log-buffer", 0, "Batch log writes in a buffer of this many bytes, flushed every second (0 writes immediately)")
+	logRateLimit := flag.Int("log-rate-limit", logging.DefaultRateLimit, "Lines per second each repeated error (e.g. failed dials to one backend) may log before the rest are summarized every 10s; 0 disables")
 	versionFlag := flag.Bool("version", false, "Print the version of the proxy and exit")
+	quiet := flag.Bool("quiet", false, "Do not print the banner and route summary on startup")
+	instanceName := flag.String("instance-name", "", "Name of this proxy in json and logfmt log lines, the startup event, and metric labels (default: the hostname)")
+	startupEvent := flag.Bool("startup-event", false, "Log one structured \"started\" event once every listener is bound and the proxy is ready")
+	adminAddr := flag.String("admin-addr", "", "Address for the admin HTTP API (e.g. 9090 or 127.0.0.1:9090); empty disables it")
+	adminToken := flag.String("admin-token", "", "Token required by every admin endpoint (Bearer header or basic auth password)")
+	readinessDelay := flag.Duration("readiness-delay", 0, "Keep the admin /healthz probe at 503 this long after start, on top of binding and first failover checks")
+	adminExpose := flag.Bool("admin-expose", false, "Allow the admin API to bind a non-loopback address")
+	dashboardAddr := flag.String("dashboard-addr", "", "Address for the read-only web dashboard (e.g. 9091); uses -admin-token and -admin-expose like the admin API")
+	tlsCertFile := flag.String("tls-cert", "", "PEM certificate for terminating client TLS on TCP routes (reloaded on SIGHUP)")
+	tlsKeyFile := flag.String("tls-key", "", "PEM private key matching -tls-cert")
+	tlsKeyPassphrase := flag.String("tls-key-passphrase", "", "Passphrase of an encrypted PKCS#8 -tls-key (visible in ps; prefer -tls-key-passphrase-file)")
+	tlsKeyPassphraseFile := flag.String("tls-key-passphrase-file", "", "File whose first line is the -tls-key passphrase, re-read on SIGHUP")
+	drainOnSighup := flag.Bool("drain-on-sighup", false, "On SIGHUP, close every live connection after -shutdown-grace so clients reconnect")
+	pidFile := flag.String("pid-file", "", "Write the process ID here once routes are serving, for 'chicha-ip-proxy reload -pid-file PATH'")
+	shutdownGrace := flag.Duration("shutdown-grace", 10*time.Second, "Time live connections get to finish before they are closed")
+	onBindError := flag.String("on-bind-error", bindErrorSkip, "When a route port cannot be bound at startup: skip serves the other ports, fatal exits, retry keeps binding it in the background with backoff")
+	shutdownDrainFirst := flag.String("shutdown-drain-first", "", "On SIGTERM, drain tcp or udp within -shutdown-grace while the other stops at once, or both under one deadline (empty exits at once)")
+	httpAccessLog := flag.String("http-access-log", "", "Write Combined Log Format lines for TCP routes marked ;http to this file")
+	httpXFF := flag.Bool("http-xff", false, "Add X-Forwarded-For and X-Forwarded-Proto to requests on TCP routes marked ;http")
+	minConnLogDuration := flag.Duration("min-connection-log-duration", 0, "Leave out open/close lines of TCP connections shorter than this that moved no bytes, such as port scans; 0 logs all")
+	logSNI := flag.Bool("log-sni", false, "Log the TLS server name requested by TCP clients without changing forwarding")
+	peekBufferMax := flag.Int("peek-buffer-max", proxy.DefaultPeekBufferMax, "Bytes a client preface such as the TLS ClientHello peeked by -log-sni may take; larger or incomplete ones close the connection")
+	maxRoutes := flag.Int("max-routes", defaultMaxRoutes, "Refuse to start, or to reload, with more routes than this; 0 removes the cap")
+	maxConns := flag.Int("max-conns", proxy.DefaultMaxTCPConnectionsPerRoute, "TCP clients each route serves at once; more are reset (or tarpitted)")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 0, "TCP connections one client IP may hold open on each route; more are reset (or tarpitted); 0 is unlimited")
+	udpDialRetries := flag.Int("udp-dial-retries", 3, "Redial an unreachable UDP backend this many times, queueing the new client's packets, before dropping them")
+	udpDialBackoff := flag.Duration("udp-dial-backoff", proxy.DefaultUDPDialBackoff, "Delay before the first UDP redial; each further retry waits twice as long")
+	udpRejectMode := flag.String("udp-reject-mode", proxy.UDPRejectSilent, "Answer to new UDP clients whose packets are dropped: silent, icmp (port unreachable), or payload")
+	udpRejectPayload := flag.String("udp-reject-payload", "rejected", "Datagram sent to refused UDP clients with -udp-reject-mode=payload")
+	udpNewSessionRate := flag.Int("udp-new-session-rate", 0, "Start at most this many new UDP sessions per second across all routes; packets of further new clients are dropped (0 is unlimited)")
+	udpBatchReplies := flag.Int("udp-batch-replies", 0, "Linux only: send up to this many queued replies of one UDP session with a single sendmmsg call, waiting at most 500µs for a batch to fill (0 sends each reply on its own)")
+	udpBatchRecv := flag.Int("udp-batch-recv", 0, "Linux only: read up to this many client datagrams of a UDP route with a single recvmmsg call, using 64 KiB of memory per slot (0 reads each datagram on its own)")
+	udpPMTUD := flag.Bool("udp-pmtud", false, "Linux only: set don't-fragment on UDP backend sockets and log datagrams above the path MTU with the discovered MTU instead of fragmenting them")
+	sessionStateFile := flag.String("session-state-file", "", "Save each live UDP session's upstream here on SIGTERM or SIGINT and reuse it for returning clients after the restart (default off)")
+	udpMaxSessionLifetime := flag.Duration("udp-max-session-lifetime", 0, "Close a UDP session this long after it started, even while its client keeps sending; 0 is unlimited")
+	scheduleTimezone := flag.String("schedule-timezone", "", "Time zone of route ;schedule= windows that name none, such as Europe/Moscow (default local time)")
+	maintenanceFile := flag.String("maintenance-file", "", "File whose contents are sent to TCP clients of a ;schedule= route outside its windows before the connection closes, e.g. an HTTP 503 response (default reset)")
+	tarpitDuration := flag.Duration("tarpit-duration", 0, "Hold denied and over-limit TCP clients silently this long before resetting them; 0 resets at once")
+	clientFamily := flag.String("client-family", "any", "Serve only ipv4 or only ipv6 clients on dual-stack listeners (any serves both)")
+	singleShot := flag.Bool("single-shot", false, "Proxy the first TCP client accepted on any route, then exit once it closes")
+	metricsFile := flag.String("metrics-file", "", "Write per-route byte, flow, and drop counters in Prometheus text format to this file")
+	metricsAddr := flag.String("metrics-addr", "", "Address serving the -metrics-file counters at /metrics for Prometheus (e.g. 9464); uses -admin-token and -admin-expose like the admin API")
+	memPressurePause := flag.String("mem-pressure-pause", "", "Pause new TCP connections and UDP sessions while the process uses at least HIGH memory, resuming below LOW, e.g. 1GB,800MB (LOW defaults to 80% of HIGH)")
+	routeQuotaFlag := flag.String("route-quota", "", "Bytes each route may forward per window, both directions together, e.g. 10GB/24h; then new clients are refused until the next window")
+	metricsInterval := flag.Duration("metrics-interval", 15*time.Second, "How often -metrics-file is rewritten")
+	tcpIdleTimeout := flag.Duration("tcp-idle-timeout", proxy.DefaultTCPIdleTimeout, "Close a TCP connection once either direction sends nothing for this long")
+	tcpClientIdle := flag.Duration("tcp-client-idle", 0, "Idle limit for data from the client; 0 uses -tcp-idle-timeout")
+	tcpServerIdle := flag.Duration("tcp-server-idle", 0, "Idle limit for data from the backend; 0 uses -tcp-idle-timeout")
+	tcpFastOpen := flag.Bool("tcp-fastopen", false, "Linux only: enable TCP Fast Open on listeners and backend dials; needs net.ipv4.tcp_fastopen=3")
+	tcpUserTimeout := flag.Duration("tcp-user-timeout", 0, "Linux only: drop a TCP connection once sent data goes unacknowledged this long (TCP_USER_TIMEOUT) on client and backend sockets; 0 keeps the kernel default")
+	healthInterval := flag.Duration("health-interval", proxy.DefaultHealthCheckInterval, "How often routes with backup= targets, targets joined by |, or a synthetic check probe each target")
+	syntheticCheckTimeout := flag.Duration("synthetic-check-timeout", proxy.DefaultSyntheticCheckTimeout, "Time a ;check-send/;check-expect probe gets from dial to the expected reply")
+	failoverRedial := flag.Bool("failover-redial", false, "When a TCP connection's target fails before any data is relayed, try the route's next target and replay the client bytes already read")
+	poolEmptyPolicy := flag.String("pool-empty-policy", proxy.PoolEmptyRetryAny, "When every target of a health-checked route is down: retry-any keeps dialing the last healthy one, reject resets new connections and drops packets of new UDP clients")
+	healthTransitionsOnly := flag.Bool("health-log-transitions-only", true, "Log health checks only when a target goes down or comes back; false logs every probe for troubleshooting")
+	backendFirstByteTimeout := flag.Duration("backend-first-byte-timeout", 0, "Close connections on ;server-first routes when the backend sends no greeting within this window after connecting; 0 disables")
+	experimentalCompression := flag.Bool("experimental-compression", false, "Allow ;compress=backend and ;compress=client routes, which deflate TCP streams between two chicha-ip-proxy instances")
+	useOriginalDst := flag.Bool("use-original-dst", false, "Linux only: forward each TCP connection to its destination before an iptables REDIRECT/DNAT (SO_ORIGINAL_DST) instead of the route target")
+	numaAware := flag.Bool("numa-aware", false, "Experimental, Linux only: give each NUMA node its own TCP accept loop and connection workers, pinned to the node's CPUs")
+	experimentalBridge := flag.Bool("experimental-bridge", false, "Allow ;target=udp on TCP routes and ;target=tcp on UDP routes, relaying length-prefixed frames as datagrams")
+	netnsFlag := flag.String("netns", "", "Linux only: run inside this network namespace, given as an ip netns name, a PID, or a path such as /var/run/netns/NAME")
+	parseRoutesFlag := flag.String("parse-routes", "", "Print how a route string parses, entry by entry, and exit without starting anything (- reads stdin)")
+	parseRoutesJSON := flag.Bool("parse-routes-json", false, "Print -parse-routes output as JSON instead of a table")
+	printConfig := flag.Bool("print-config", false, "Print the routes merged from every flag and -config source, and every flag value in effect, as a JSON -config file and exit")
+	handshakeTimeout := flag.Duration("handshake-timeout", 0, "Close TCP clients that send nothing (and backends that answer nothing) within this window; 0 disables")
 
 	// Legacy route flags stay registered for compatibility but are intentionally absent from help output.
-	routesFlag := flag.String("routes", "", "legacy TCP routes in LOCALPORT:REMOTEIP:REMOTEPORT format")
-	udpRoutesFlag := flag.String("udp-routes", "", "legacy UDP routes in LOCALPORT:REMOTEIP:REMOTEPORT format")
+	routesFlag := flag.String("routes", "", "legacy TCP routes in LOCALPORT:REMOTEIP:REMOTEPORT format (- reads stdin)")
+	udpRoutesFlag := flag.String("udp-routes", "", "legacy UDP routes in LOCALPORT:REMOTEIP:REMOTEPORT format (- reads stdin)")
 
 	flag.Usage = showFlagHelp
+	// reload is the one subcommand: it signals a running proxy and exits, so none of the proxy's flags apply to it.
+	if len(os.Args) > 1 && os.Args[1] == "reload" {
+		if err := runReloadCommand(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
 	flag.Parse()
 
+	// A log on stdout or stderr piped into a reader that went away would otherwise kill the process with SIGPIPE;
+	// ignored, the write fails with EPIPE and the proxy keeps forwarding.
+	signal.Ignore(syscall.SIGPIPE)
+
 	// Resolve the build version once so every subsystem prints a consistent identifier.
 	appVersion := version.Resolve()
 
@@ -44,11 +171,310 @@ func main() {
 		fmt.Printf("chicha-ip-proxy version %s\n", appVersion)
 		return
 	}
+	if *parseRoutesJSON && *parseRoutesFlag == "" {
+		log.Fatal("Error: -parse-routes-json needs -parse-routes")
+	}
+	if *parseRoutesFlag != "" {
+		routeString := *parseRoutesFlag
+		if routeString == "-" {
+			routeList, err := config.ReadRouteList(os.Stdin)
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			routeString = routeList
+		}
+		if !printParsedRoutes(os.Stdout, os.Stderr, parseRouteEntries(routeString), *parseRoutesJSON) {
+			os.Exit(1)
+		}
+		return
+	}
+	// Entering re-executes the binary, so it comes before anything reads stdin or starts work that would be lost.
+	netnsPath := ""
+	if *netnsFlag != "" {
+		path, err := netns.Path(*netnsFlag)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if err := netns.Enter(path); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		netnsPath = path
+	}
+	configKey, err := loadConfigKey(*configKeyFile)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if *configEncrypt {
+		if err := encryptConfig(os.Stdin, os.Stdout, configKey); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+	// Piped input is read before anything could prompt, and it rules out the setup wizard because stdin is used up.
+	stdinFlag, stdinContent, err := readStdinFlag(os.Stdin, map[string]*string{
+		"-routes":      routesFlag,
+		"-udp-routes":  udpRoutesFlag,
+		"-routes-json": routesJSONFlag,
+		"-config":      configFile,
+	})
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if stdinFlag == "-routes-json" {
+		*routesJSONFlag = string(stdinContent)
+	}
+	if stdinFlag == "-routes" || stdinFlag == "-udp-routes" {
+		routeList, err := config.ReadRouteList(bytes.NewReader(stdinContent))
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if routeList == "" {
+			log.Fatalf("Error: %s=- read no routes from stdin", stdinFlag)
+		}
+		if stdinFlag == "-routes" {
+			*routesFlag = routeList
+		} else {
+			*udpRoutesFlag = routeList
+		}
+	}
+
+	// Settings in a -config file stand in for the logging flags the command line left out, before any of them is checked.
+	var configSettings []string
+	if *configFile != "" {
+		configSettings, err = applyConfigSettings(flag.CommandLine, *configFile, stdinContent, configKey)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	if err := logging.ValidateMode(*logMode); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if *logRateLimit < 0 {
+		log.Fatal("Error: -log-rate-limit cannot be negative")
+	}
+	if *logMaxSizeMB <= 0 || *logRingFiles < 1 {
+		log.Fatal("Error: -log-max-size and -log-ring-files must be positive")
+	}
+	if *rotationMinSize < 0 {
+		log.Fatal("Error: -rotation-min-size cannot be negative")
+	}
 	if err := validateRotationFrequency(*rotationFrequency); err != nil {
 		log.Fatalf("Error: %v", err)
 	}
+	logUTC, err := logging.ParseTimezone(*logTimezone)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	prompts, err := setup.NewPrompter(os.Stdin, strings.ToLower(*setupFormat))
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	// Fleets aggregate logs and metrics centrally, so every instance names itself; the hostname is right unless several run on one host.
+	if *instanceName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("Error: cannot read the hostname for -instance-name: %v; set -instance-name explicitly", err)
+		}
+		*instanceName = hostname
+	}
+	if !metrics.ValidInstanceName(*instanceName) {
+		log.Fatalf("Error: -instance-name '%s' must be 1 to 253 letters, digits, '.', '-' or '_'", *instanceName)
+	}
+
+	logOptions := logging.Options{Format: strings.ToLower(*logFormat), UTC: logUTC, Microseconds: *logMicroseconds, BufferSize: *logBuffer, CreateDir: *logMkdir, Caller: *logCaller, Remote: *logRemote, Instance: *instanceName}
+	if err := logOptions.Validate(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if *shutdownGrace < 0 {
+		log.Fatal("Error: -shutdown-grace cannot be negative")
+	}
+	switch *shutdownDrainFirst {
+	case "", "tcp", "udp", "both":
+	default:
+		log.Fatalf("Error: -shutdown-drain-first must be tcp, udp, or both, got %q", *shutdownDrainFirst)
+	}
+	switch *onBindError {
+	case bindErrorSkip, bindErrorFatal, bindErrorRetry:
+	default:
+		log.Fatalf("Error: -on-bind-error must be %s, %s, or %s, got %q", bindErrorSkip, bindErrorFatal, bindErrorRetry, *onBindError)
+	}
+	scheduleZone := time.Local
+	if *scheduleTimezone != "" {
+		zone, err := time.LoadLocation(*scheduleTimezone)
+		if err != nil {
+			log.Fatalf("Error: -schedule-timezone must name a time zone such as Europe/Moscow or UTC, got %q", *scheduleTimezone)
+		}
+		scheduleZone = zone
+	}
+	var maintenanceReply []byte
+	if *maintenanceFile != "" {
+		content, err := os.ReadFile(*maintenanceFile)
+		if err != nil {
+			log.Fatalf("Error: failed to read -maintenance-file: %v", err)
+		}
+		if len(content) == 0 || len(content) > maxMaintenanceReply {
+			log.Fatalf("Error: -maintenance-file must hold 1 to %d bytes, got %d", maxMaintenanceReply, len(content))
+		}
+		maintenanceReply = content
+	}
+	if *handshakeTimeout < 0 {
+		log.Fatal("Error: -handshake-timeout cannot be negative")
+	}
+	if *backendFirstByteTimeout < 0 {
+		log.Fatal("Error: -backend-first-byte-timeout cannot be negative")
+	}
+	if *useOriginalDst && !proxy.OriginalDestinationSupported {
+		log.Fatalf("Error: -use-original-dst needs Linux, where conntrack keeps the pre-NAT destination (SO_ORIGINAL_DST); this build is for %s", runtime.GOOS)
+	}
+	if *egressPolicy != "" && !proxy.EgressPolicySupported {
+		log.Fatalf("Error: -egress-policy needs Linux, where the kernel answers route lookups over netlink; this build is for %s", runtime.GOOS)
+	}
+	if *minConnLogDuration < 0 {
+		log.Fatal("Error: -min-connection-log-duration cannot be negative")
+	}
+	if *tarpitDuration < 0 {
+		log.Fatal("Error: -tarpit-duration cannot be negative")
+	}
+	if *udpDialRetries < 0 || *udpDialBackoff < 0 {
+		log.Fatal("Error: -udp-dial-retries and -udp-dial-backoff cannot be negative")
+	}
+	if *udpBatchReplies < 0 || *udpBatchReplies > proxy.MaxUDPReplyBatch {
+		log.Fatalf("Error: -udp-batch-replies must be between 0 and %d", proxy.MaxUDPReplyBatch)
+	}
+	if *udpBatchRecv < 0 || *udpBatchRecv > proxy.MaxUDPRecvBatch {
+		log.Fatalf("Error: -udp-batch-recv must be between 0 and %d", proxy.MaxUDPRecvBatch)
+	}
+	memoryHigh, memoryLow, err := config.ParseMemoryPressure(*memPressurePause)
+	if err != nil {
+		log.Fatalf("Error: -mem-pressure-pause: %v", err)
+	}
+	routeQuota, err := config.ParseQuota(*routeQuotaFlag)
+	if err != nil {
+		log.Fatalf("Error: -route-quota: %v", err)
+	}
+	if *poolEmptyPolicy != proxy.PoolEmptyRetryAny && *poolEmptyPolicy != proxy.PoolEmptyReject {
+		log.Fatalf("Error: -pool-empty-policy must be %s or %s, got '%s'", proxy.PoolEmptyRetryAny, proxy.PoolEmptyReject, *poolEmptyPolicy)
+	}
+	if *udpMaxSessionLifetime < 0 {
+		log.Fatal("Error: -udp-max-session-lifetime cannot be negative")
+	}
+	if *udpNewSessionRate < 0 {
+		log.Fatal("Error: -udp-new-session-rate cannot be negative")
+	}
+	switch *udpRejectMode {
+	case proxy.UDPRejectSilent, proxy.UDPRejectICMP, proxy.UDPRejectPayload:
+	default:
+		log.Fatalf("Error: -udp-reject-mode must be %s, %s, or %s, got '%s'", proxy.UDPRejectSilent, proxy.UDPRejectICMP, proxy.UDPRejectPayload, *udpRejectMode)
+	}
+	switch *clientFamily {
+	case "any", "ipv4", "ipv6":
+	default:
+		log.Fatalf("Error: -client-family must be any, ipv4, or ipv6, got %q", *clientFamily)
+	}
+	if *tcpIdleTimeout <= 0 {
+		log.Fatal("Error: -tcp-idle-timeout must be positive")
+	}
+	if *tcpClientIdle < 0 || *tcpServerIdle < 0 {
+		log.Fatal("Error: -tcp-client-idle and -tcp-server-idle cannot be negative")
+	}
+	if *tcpUserTimeout < 0 {
+		log.Fatal("Error: -tcp-user-timeout cannot be negative")
+	}
+	// A missing socket option only loses early dead-peer detection, so other systems run on with a warning instead of refusing to start.
+	if *tcpUserTimeout > 0 && !proxy.TCPUserTimeoutSupported {
+		log.Print("WARNING: -tcp-user-timeout is only supported on Linux; ignoring it")
+		*tcpUserTimeout = 0
+	}
+	if *numaAware && !proxy.NUMASupported {
+		log.Print("WARNING: -numa-aware is only supported on Linux; ignoring it")
+		*numaAware = false
+	}
+	if *udpBatchReplies > 1 && !proxy.UDPBatchRepliesSupported {
+		log.Print("WARNING: -udp-batch-replies is only supported on Linux; ignoring it")
+		*udpBatchReplies = 0
+	}
+	if *udpBatchRecv > 1 && !proxy.UDPBatchRecvSupported {
+		log.Print("WARNING: -udp-batch-recv is only supported on Linux; ignoring it")
+		*udpBatchRecv = 0
+	}
+	if *udpPMTUD && !proxy.UDPPMTUDSupported {
+		log.Print("WARNING: -udp-pmtud is only supported on Linux; ignoring it")
+		*udpPMTUD = false
+	}
+	if *tcpFastOpen && !proxy.TCPFastOpenSupported {
+		log.Print("WARNING: -tcp-fastopen is only supported on Linux; ignoring it")
+		*tcpFastOpen = false
+	}
+	if *healthInterval <= 0 {
+		log.Fatal("Error: -health-interval must be positive")
+	}
+	if *syntheticCheckTimeout <= 0 {
+		log.Fatal("Error: -synthetic-check-timeout must be positive")
+	}
+	if *metricsInterval <= 0 {
+		log.Fatal("Error: -metrics-interval must be positive")
+	}
+	if *maxConns < 1 {
+		log.Fatal("Error: -max-conns must be at least 1")
+	}
+	if *maxConnsPerIP < 0 {
+		log.Fatal("Error: -max-conns-per-ip cannot be negative")
+	}
+	if (*tlsCertFile == "") != (*tlsKeyFile == "") {
+		log.Fatal("Error: -tls-cert and -tls-key must be used together")
+	}
+	if *tlsKeyPassphrase != "" && *tlsKeyPassphraseFile != "" {
+		log.Fatal("Error: use either -tls-key-passphrase or -tls-key-passphrase-file, not both")
+	}
+	listenHost, err := config.ParseListenHost(*listenAddrFlag)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := checkListenHost(listenHost); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	adminListenAddr := ""
+	if *adminAddr != "" {
+		resolved, err := admin.ResolveListenAddr(*adminAddr, *adminExpose)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		adminListenAddr = resolved
+	}
+	dashboardListenAddr := ""
+	if *dashboardAddr != "" {
+		resolved, err := admin.ResolveListenAddr(*dashboardAddr, *adminExpose)
+		if err != nil {
+			log.Fatalf("Error: -dashboard-addr: %v", err)
+		}
+		if resolved == adminListenAddr {
+			log.Fatalf("Error: -dashboard-addr %s is already the -admin-addr; give the dashboard its own port", resolved)
+		}
+		dashboardListenAddr = resolved
+	}
+	metricsListenAddr := ""
+	if *metricsAddr != "" {
+		resolved, err := admin.ResolveListenAddr(*metricsAddr, *adminExpose)
+		if err != nil {
+			log.Fatalf("Error: -metrics-addr: %v", err)
+		}
+		if resolved == adminListenAddr || resolved == dashboardListenAddr {
+			log.Fatalf("Error: -metrics-addr %s is already the -admin-addr or -dashboard-addr; give /metrics its own port", resolved)
+		}
+		metricsListenAddr = resolved
+	}
+	if *readinessDelay < 0 {
+		log.Fatal("Error: -readiness-delay cannot be negative")
+	}
+	if *systemdWatchdog < 0 || (*systemdWatchdog > 0 && !*systemdNotify) {
+		log.Fatal("Error: -systemd-watchdog must not be negative and needs -systemd-notify")
+	}
+	if *readinessDelay > 0 && adminListenAddr == "" {
+		log.Fatal("Error: -readiness-delay needs -admin-addr, which serves /healthz")
+	}
 
-	tcpRoutes, udpRoutes, err := parseRoutesFromFlags(*routesFlag, *udpRoutesFlag, config.SimpleRouteFlags{
+	tcpRoutes, udpRoutes, err := parseRoutesFromFlags(*routesFlag, *udpRoutesFlag, *forwardFlag, *routesJSONFlag, config.SimpleRouteFlags{
 		Local:  *localFlag,
 		Remote: *remoteFlag,
 		Proto:  *protoFlag,
@@ -60,13 +486,100 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error parsing allowed client sources: %v", err)
 	}
+	if *configFile != "" && *configURL != "" {
+		log.Fatal("Error: -config and -config-url are alternatives; use one of them")
+	}
+	if *configReloadInterval < 0 || (*configReloadInterval > 0 && *configURL == "" && (*configFile == "" || *configFile == "-")) {
+		log.Fatal("Error: -config-reload-interval must not be negative and needs a -config file (not stdin) or -config-url")
+	}
+	var configTCPRoutes, configUDPRoutes []config.Route
+	// configDirFiles stays nil unless -config names a directory, whose file list is logged once logging is set up.
+	var configDirFiles []string
+	// configProvider serves a -config file or directory, or -config-url; stdin is read once and has none.
+	var configProvider config.Provider
+	configSource := *configFile
+	if *configFile == "-" {
+		content, err := config.Unseal(stdinContent, configKey)
+		if err != nil {
+			log.Fatalf("Error: config from stdin: %v", err)
+		}
+		configTCPRoutes, configUDPRoutes, err = config.ParseFile(content)
+		if err != nil {
+			log.Fatalf("Error: config from stdin: %v", err)
+		}
+	} else if *configFile != "" {
+		fileProvider := &config.FileProvider{Path: *configFile, Key: configKey, Interval: *configReloadInterval}
+		configTCPRoutes, configUDPRoutes, err = fileProvider.Load()
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		configDirFiles = fileProvider.DirFiles()
+		configProvider = fileProvider
+	} else if *configURL != "" {
+		if *configReloadInterval == 0 {
+			*configReloadInterval = config.DefaultHTTPProviderInterval
+		}
+		urlProvider := &config.HTTPProvider{URL: *configURL, Key: configKey, Interval: *configReloadInterval}
+		configTCPRoutes, configUDPRoutes, err = urlProvider.Load()
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		configSource = urlProvider.String()
+		configProvider = urlProvider
+	}
+	if *peekBufferMax <= 0 {
+		log.Fatal("Error: -peek-buffer-max must be positive")
+	}
+	if *maxRoutes < 0 {
+		log.Fatal("Error: -max-routes cannot be negative")
+	}
+	// Reloads check the new config routes against the same rules; the flag routes around them never change.
+	validateConfigRoutes := func(configTCP, configUDP []config.Route) error {
+		if err := checkCompressedRoutes(*experimentalCompression, configTCP, configUDP); err != nil {
+			return err
+		}
+		if err := checkBridgedRoutes(*experimentalBridge, configTCP, configUDP); err != nil {
+			return err
+		}
+		if err := checkTCPOnlyOptions(configUDP); err != nil {
+			return err
+		}
+		return checkRouteCount(*maxRoutes, len(tcpRoutes)+len(udpRoutes)+len(configTCP)+len(configUDP))
+	}
+	if err := checkCompressedRoutes(*experimentalCompression, tcpRoutes, udpRoutes); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := checkBridgedRoutes(*experimentalBridge, tcpRoutes, udpRoutes); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := checkTCPOnlyOptions(udpRoutes); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if _, err := config.StartupOrder(tcpRoutes, udpRoutes); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := validateConfigRoutes(configTCPRoutes, configUDPRoutes); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if *printConfig {
+		if err := printEffectiveConfig(os.Stdout, append(tcpRoutes, configTCPRoutes...), append(udpRoutes, configUDPRoutes...), flag.CommandLine); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
 
 	actualLogFile := *logFile
 	var autostartResult *setup.SystemdResult
 
+	runWizard := len(tcpRoutes) == 0 && len(udpRoutes) == 0 && *configFile == "" && *configURL == ""
+	serviceOutput := *unitOutput != "" || *initOutput != ""
+	if serviceOutput && !runWizard {
+		log.Fatal("Error: -unit-output and -init-output only apply to the setup wizard; run without route flags")
+	}
+
 	// Fall back to interactive setup when no routes are provided.
-	if len(tcpRoutes) == 0 && len(udpRoutes) == 0 {
-		interactiveResult, err := setup.RunInteractiveSetup("chicha-ip-proxy")
+	if runWizard {
+		interactiveResult, err := setup.RunInteractiveSetup("chicha-ip-proxy", prompts)
 		if err != nil {
 			if errors.Is(err, setup.ErrSetupCancelled) {
 				fmt.Println("Setup cancelled.")
@@ -79,20 +592,52 @@ func main() {
 		udpRoutes = interactiveResult.UDPRoutes
 		allowList = interactiveResult.AllowList
 		actualLogFile = interactiveResult.LogFile
+		// The wizard picked this path itself, so its directory is created rather than reported missing.
+		logOptions.CreateDir = true
+		interactiveResult.SocketActivation = *socketActivation
+		interactiveResult.Notify = *systemdNotify
+		interactiveResult.Watchdog = *systemdWatchdog
+		interactiveResult.UnitOutput = *unitOutput
+		interactiveResult.InitOutput = *initOutput
 
-		autostartResult, err = setup.OfferAutostartSetup("chicha-ip-proxy", interactiveResult, *rotationFrequency)
+		autostartResult, err = setup.OfferAutostartSetup("chicha-ip-proxy", interactiveResult, *rotationFrequency, prompts)
+		// A service run as the sudo user logs into a directory it owns, so this run follows it there.
+		actualLogFile = interactiveResult.LogFile
+		// Generated files are for review or a later install, so the proxy does not start serving from this run.
+		if serviceOutput {
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		}
 		if err != nil {
 			log.Printf("Autostart setup encountered an issue: %v", err)
 		}
 	}
 
-	if len(tcpRoutes) == 0 && len(udpRoutes) == 0 {
-		log.Fatal("Error: provide -local and -remote, use legacy -routes/-udp-routes, or run without route flags for interactive setup.")
+	if len(tcpRoutes) == 0 && len(udpRoutes) == 0 && len(configTCPRoutes) == 0 && len(configUDPRoutes) == 0 {
+		log.Fatal("Error: provide -local and -remote, use -forward or legacy -routes/-udp-routes, or run without route flags for interactive setup.")
+	}
+
+	// A UDP session has no end the client controls, so single-shot would only ever exit on the idle timeout.
+	if *singleShot && len(udpRoutes)+len(configUDPRoutes) > 0 {
+		log.Fatal("Error: -single-shot only supports TCP routes")
 	}
 
-	printStartupSummary(tcpRoutes, udpRoutes, allowList, actualLogFile)
+	if !*quiet {
+		printStartupSummary(listenHost, append(tcpRoutes, configTCPRoutes...), append(udpRoutes, configUDPRoutes...), allowList, actualLogFile)
+	}
+
+	sockets, err := activation.FromEnvironment()
+	if err != nil {
+		log.Fatalf("Error: systemd socket activation: %v", err)
+	}
+	notifier := activation.NotifierFromEnvironment()
 
-	logger, file, err := logging.SetupLogger(actualLogFile)
+	logger, file, err := logging.SetupLogger(actualLogFile, logOptions)
+	if errors.Is(err, logging.ErrLogDirMissing) {
+		log.Fatalf("Error: %v; create it or pass -log-mkdir", err)
+	}
 	if err != nil {
 		log.Fatalf("Error setting up logger: %v", err)
 	}
@@ -100,6 +645,16 @@ func main() {
 	if err := limits.SetupLimits(logger); err != nil {
 		logger.Printf("System limit tuning encountered an issue: %v", err)
 	}
+	if openFiles, ok := limits.OpenFilesLimit(); ok {
+		logger.Printf("Effective open files limit (RLIMIT_NOFILE soft): %d", openFiles)
+		needed := openFilesNeeded(len(tcpRoutes)+len(configTCPRoutes), len(udpRoutes)+len(configUDPRoutes), *maxConns, *tarpitDuration > 0)
+		if openFiles < needed {
+			warning := fmt.Sprintf("WARNING: open files limit %d is below the %d descriptors these routes can need at -max-conns=%d; "+
+				"raise the limit (ulimit -n, LimitNOFILE= in systemd) or lower -max-conns, or connections will fail under load", openFiles, needed, *maxConns)
+			logger.Print(warning)
+			log.Print(warning)
+		}
+	}
 
 	log.Printf("Starting chicha-ip-proxy version %s", appVersion)
 
@@ -107,21 +662,428 @@ func main() {
 	runtime.GOMAXPROCS(numCPUs)
 	logger.Printf("Using %d CPU cores", numCPUs)
 	log.Printf("Using %d CPU cores", numCPUs)
+	if netnsPath != "" {
+		logger.Printf("Running in network namespace %s", netnsPath)
+	}
+	if configDirFiles != nil {
+		logger.Printf("Loaded config directory %s: %s", *configFile, describeConfigFiles(configDirFiles))
+	}
+	if len(configSettings) > 0 {
+		logger.Printf("Settings from %s: %s", configSource, strings.Join(configSettings, " "))
+	}
 
-	go logging.RotateLogs(actualLogFile, file, logger, *rotationFrequency, logging.DefaultMaxSizeBytes)
+	if *logMode == logging.ModeRing {
+		go logging.RotateRing(actualLogFile, file, logger, *logMaxSizeMB*1024*1024, *logRingFiles)
+	} else {
+		go logging.RotateLogs(actualLogFile, file, logger, *rotationFrequency, *logMaxSizeMB*1024*1024, *rotationMinSize)
+	}
 
-	for _, route := range tcpRoutes {
-		listenAddr := ":" + route.LocalPort
-		targetAddr := route.RemoteAddress()
-		logger.Printf("Starting TCP proxy for route: local=%s remote=%s", listenAddr, targetAddr)
-		go proxy.StartTCPProxy(listenAddr, targetAddr, allowList, logger)
+	if *tcpFastOpen {
+		*tcpFastOpen = probeTCPFastOpen(logger)
 	}
 
-	for _, route := range udpRoutes {
-		listenAddr := ":" + route.LocalPort
+	proxyOptions := proxy.Options{LogSNI: *logSNI, PeekBufferMax: *peekBufferMax, ForwardedFor: *httpXFF, TarpitDuration: *tarpitDuration, MaxConnections: *maxConns, MaxConnectionsPerIP: *maxConnsPerIP,
+		UDPDialRetries: *udpDialRetries, UDPDialBackoff: *udpDialBackoff, UDPMaxSessionLifetime: *udpMaxSessionLifetime, UDPPMTUD: *udpPMTUD, UDPBatchReplies: *udpBatchReplies, UDPBatchRecv: *udpBatchRecv, HealthCheckInterval: *healthInterval, HealthLogProbes: !*healthTransitionsOnly, PoolEmptyPolicy: *poolEmptyPolicy, FailoverRedial: *failoverRedial, ClientFamily: *clientFamily,
+		ClientIdleTimeout: durationOr(*tcpClientIdle, *tcpIdleTimeout), ServerIdleTimeout: durationOr(*tcpServerIdle, *tcpIdleTimeout), TCPUserTimeout: *tcpUserTimeout, TCPFastOpen: *tcpFastOpen,
+		BackendFirstByteTimeout: *backendFirstByteTimeout, OriginalDestination: *useOriginalDst, ListenHost: listenHost,
+		SyntheticCheckTimeout: *syntheticCheckTimeout, MinConnectionLogDuration: *minConnLogDuration, MaintenanceReply: maintenanceReply}
+	if *logRateLimit > 0 {
+		proxyOptions.LogLimiter = logging.NewRateLimiter(*logRateLimit, logging.RateLimitSummaryInterval)
+	}
+	if *httpAccessLog != "" {
+		accessLog, accessFile, err := logging.SetupAccessLog(*httpAccessLog, logOptions.CreateDir)
+		if errors.Is(err, logging.ErrLogDirMissing) {
+			logger.Fatalf("Error setting up HTTP access log: %v; create it or pass -log-mkdir", err)
+		}
+		if err != nil {
+			logger.Fatalf("Error setting up HTTP access log: %v", err)
+		}
+		go logging.RotateAccessLog(*httpAccessLog, accessFile, accessLog, logger, *logMode, *rotationFrequency, *logMaxSizeMB*1024*1024, *rotationMinSize, *logRingFiles)
+		proxyOptions.AccessLog = accessLog
+		logger.Printf("Writing HTTP access log for ;http routes to %s", *httpAccessLog)
+	}
+	if *egressIPPool != "" {
+		pool, err := proxy.NewEgressPool([]string{*egressIPPool})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		proxyOptions.EgressPool = pool
+		logger.Printf("Backend TCP dials rotate through egress IPs: %v", pool.Addrs())
+	}
+	if *udpEgressIPPool != "" {
+		pool, err := proxy.NewUDPEgressPool([]string{*udpEgressIPPool})
+		if err != nil {
+			log.Fatalf("Error: -udp-egress-ip-pool: %v", err)
+		}
+		proxyOptions.UDPEgressPool = pool
+		logger.Printf("New UDP sessions rotate through egress IPs: %v", pool.Addrs())
+	}
+	if *egressPolicy != "" {
+		policy, err := proxy.ParseEgressPolicy(*egressPolicy)
+		if err != nil {
+			log.Fatalf("Error: -egress-policy: %v", err)
+		}
+		proxyOptions.EgressPolicy = policy
+		logger.Printf("Backend dials must satisfy the egress policy %s; refused ones are logged as failed connects", policy)
+	}
+	if *upstreamMaxDials < 0 {
+		log.Fatal("Error: -upstream-max-dials must not be negative")
+	}
+	if *upstreamMaxDials > 0 {
+		proxyOptions.DialLimiter = proxy.NewDialLimiter(*upstreamMaxDials)
+		logger.Printf("Backend TCP dials limited to %d in flight per backend", *upstreamMaxDials)
+	}
+	if *metricsFile != "" || metricsListenAddr != "" {
+		proxyOptions.Metrics = metrics.NewSet(*instanceName)
+	}
+	if *metricsFile != "" {
+		go metrics.WriteFilePeriodically(*metricsFile, *metricsInterval, proxyOptions.Metrics, logger)
+		logger.Printf("Writing route metrics to %s every %s", *metricsFile, *metricsInterval)
+	}
+	if routeQuota.Bytes > 0 {
+		// The quota reads the metrics byte counters, so they are kept even without -metrics-file.
+		if proxyOptions.Metrics == nil {
+			proxyOptions.Metrics = metrics.NewSet(*instanceName)
+		}
+		proxyOptions.Quota = routeQuota
+		logger.Printf("Route quota: each route may forward %d bytes per %s window", routeQuota.Bytes, routeQuota.Window)
+	}
+	// Raw ICMP sockets need root or CAP_NET_RAW; without them refused clients keep getting silence rather than stopping the proxy.
+	if rejecter, err := proxy.NewUDPRejecter(*udpRejectMode, []byte(*udpRejectPayload)); err != nil {
+		logger.Printf("WARNING: -udp-reject-mode=%s is unavailable: %v; refused UDP packets are dropped silently", *udpRejectMode, err)
+	} else if rejecter != nil {
+		proxyOptions.UDPReject = rejecter
+		logger.Printf("Refused new UDP clients get an answer: %s", *udpRejectMode)
+	}
+	if *udpNewSessionRate > 0 {
+		proxyOptions.UDPSessionRate = proxy.NewUDPSessionRate(*udpNewSessionRate)
+		logger.Printf("New UDP sessions limited to %d per second across all routes", *udpNewSessionRate)
+	}
+	if *sessionStateFile != "" {
+		// A table that cannot be read costs affinity, not service, so the proxy starts with an empty one.
+		restored, err := proxy.LoadUDPSessionState(*sessionStateFile, time.Now())
+		if err != nil {
+			logger.Printf("WARNING: starting without saved UDP sessions: %v", err)
+		}
+		proxyOptions.SessionState = proxy.NewUDPSessionState(restored)
+		logger.Printf("Restored %d UDP session mappings from %s", len(restored), *sessionStateFile)
+	}
+	if *numaAware {
+		// One node has nothing to balance, and pinning its threads would only take CPUs away from the Go scheduler.
+		nodes, err := proxy.NUMANodes()
+		switch {
+		case err != nil:
+			logger.Printf("WARNING: -numa-aware found no NUMA nodes, so TCP accept loops stay unpinned: %v", err)
+		case len(nodes) < 2:
+			logger.Printf("-numa-aware: this host has a single NUMA node (%s), so TCP accept loops stay unpinned", proxy.DescribeNUMANodes(nodes))
+		default:
+			proxyOptions.NUMANodes = nodes
+			logger.Printf("NUMA-aware TCP: one pinned accept loop and worker pool per node (%s)", proxy.DescribeNUMANodes(nodes))
+		}
+	}
+	if *udpBatchReplies > 1 {
+		logger.Printf("UDP replies are sent in batches of up to %d per session with sendmmsg", *udpBatchReplies)
+	}
+	if *udpBatchRecv > 1 {
+		logger.Printf("UDP routes read up to %d client datagrams per recvmmsg call", *udpBatchRecv)
+	}
+	if *udpPMTUD {
+		logger.Print("UDP backend sockets set don't-fragment; datagrams above the path MTU are logged and dropped")
+	}
+	if memoryHigh > 0 {
+		proxyOptions.MemoryGuard = proxy.NewMemoryGuard(memoryHigh, memoryLow, logger)
+		logger.Printf("Memory pressure pause: new clients wait once memory use reaches %s and resume below %s", proxy.MiB(memoryHigh), proxy.MiB(memoryLow))
+	}
+	if *singleShot {
+		proxyOptions.SingleShot = proxy.NewSingleShot()
+	}
+	var hangupActions []func()
+	if *tlsCertFile != "" {
+		certStore, err := certstore.Load(*tlsCertFile, *tlsKeyFile, certstore.KeyPassphrase{Value: *tlsKeyPassphrase, File: *tlsKeyPassphraseFile})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		proxyOptions.TLSConfig = certStore.ServerConfig()
+		logger.Printf("TLS termination enabled for TCP routes with certificate %s", *tlsCertFile)
+		hangupActions = append(hangupActions, func() {
+			reloadCertificates(certStore, *tlsCertFile, logger)
+		})
+	}
+
+	// The registry only exists when a feature needs live flows, so plain runs skip the bookkeeping.
+	if adminListenAddr != "" || dashboardListenAddr != "" || *drainOnSighup || *shutdownDrainFirst != "" {
+		proxyOptions.Registry = proxy.NewRegistry()
+	}
+	if *drainOnSighup {
+		hangupActions = append(hangupActions, func() {
+			cycled := proxyOptions.Registry.Drain(*shutdownGrace)
+			logger.Printf("SIGHUP: cycling %d live connections within %s; listeners stay up", cycled, *shutdownGrace)
+		})
+	}
+	// SIGHUP re-reads -config or -config-url; the watcher started with the supervisor takes the request, so reloads never overlap.
+	configHangups := make(chan struct{}, 1)
+	if configProvider != nil {
+		hangupActions = append(hangupActions, func() {
+			select {
+			case configHangups <- struct{}{}:
+			default:
+				// A reload is already waiting and will read the newest version anyway.
+			}
+		})
+	}
+	// Without any action SIGHUP would still end the process, which is not what an operator asking for a reload expects.
+	if len(hangupActions) == 0 {
+		hangupActions = append(hangupActions, func() {
+			logger.Print("SIGHUP: nothing to reload; routes from flags or -config=- change only with a restart")
+		})
+	}
+	runOnSignal(syscall.SIGHUP, hangupActions)
+
+	// Readiness backs both /healthz and the READY=1 a Type=notify unit waits for, so either one turns it on.
+	if adminListenAddr != "" || notifier != nil {
+		healthChecked := countFailoverRoutes(append(tcpRoutes, configTCPRoutes...)) + countFailoverRoutes(append(udpRoutes, configUDPRoutes...))
+		proxyOptions.Readiness = proxy.NewReadiness(*readinessDelay, healthChecked, logger)
+	}
+	if adminListenAddr != "" || dashboardListenAddr != "" {
+		proxyOptions.Status = proxy.NewStatusBoard()
+		// GET /stats.csv and /status read the route counters, so they are kept even without -metrics-file.
+		if proxyOptions.Metrics == nil {
+			proxyOptions.Metrics = metrics.NewSet(*instanceName)
+		}
+	}
+	if adminListenAddr != "" {
+		if *adminToken == "" {
+			logger.Printf("Admin API on %s has no -admin-token; anyone who can reach it can close connections", adminListenAddr)
+		}
+		handler := admin.Protect(admin.NewHandler(proxyOptions.Registry, proxyOptions.Readiness, proxyOptions.Status, proxyOptions.Metrics, logger), *adminToken)
+		go admin.Serve(adminListenAddr, handler, logger)
+	}
+	if dashboardListenAddr != "" {
+		if *adminToken == "" {
+			logger.Printf("Dashboard on %s has no -admin-token; anyone who can reach it can see every route", dashboardListenAddr)
+		}
+		handler := admin.Protect(admin.NewDashboardHandler(proxyOptions.Registry, proxyOptions.Status, proxyOptions.Metrics), *adminToken)
+		go admin.ServeDashboard(dashboardListenAddr, handler, logger)
+	}
+	if metricsListenAddr != "" {
+		if *adminToken == "" {
+			logger.Printf("Metrics endpoint on %s has no -admin-token; anyone who can reach it can read the route counters", metricsListenAddr)
+		}
+		go admin.ServeMetrics(metricsListenAddr, admin.Protect(admin.NewMetricsHandler(proxyOptions.Metrics), *adminToken), logger)
+	}
+
+	// listenerStops closes each protocol's listeners on shutdown, whether main or the supervisor bound them.
+	listenerStops := make(map[string][]func())
+
+	// Config routes run under the supervisor so reloads can add, change, and remove them live.
+	if *configFile != "" || *configURL != "" {
+		supervisor := proxy.NewSupervisor(allowList, logger, func(route config.Route) proxy.Options {
+			return routeProxyOptions(proxyOptions, route, *handshakeTimeout, scheduleZone)
+		})
+		warnUnorderedConfigRoutes(configSource, append(configTCPRoutes, configUDPRoutes...), logger)
+		if _, err := supervisor.Apply(configTCPRoutes, configUDPRoutes); err != nil {
+			logger.Fatalf("Error starting routes from %s: %v", configSource, err)
+		}
+		for _, protocol := range []string{"tcp", "udp"} {
+			protocol := protocol
+			listenerStops[protocol] = append(listenerStops[protocol], func() { supervisor.Stop(protocol) })
+		}
+		if configProvider != nil {
+			if *configReloadInterval > 0 && configDirFiles != nil {
+				logger.Printf("Polling directory %s every %s for route changes", configSource, *configReloadInterval)
+			} else if *configReloadInterval > 0 {
+				logger.Printf("Polling %s every %s for route changes", configSource, *configReloadInterval)
+			}
+			go watchConfigProvider(supervisor, configProvider, configSource, configHangups, validateConfigRoutes, logger)
+		}
+	}
+
+	// Every port of a batch binds before any of its routes starts, so a route on both transports never runs with only one of them.
+	// Without after= and wait-for-upstream= there is one batch; a port that waits for its upstream first starts the routes bound before it.
+	// The setup wizard may have supplied the routes since they were checked, so the order is planned here.
+	startup, err := config.StartupOrder(tcpRoutes, udpRoutes)
+	if err != nil {
+		logger.Fatalf("Error: %v", err)
+	}
+	tcpListeners := make([]net.Listener, len(tcpRoutes))
+	udpConns := make([]net.PacketConn, len(udpRoutes))
+	activated := make(map[string]bool)
+	bound := make(map[string]bool)
+	var bindFailures []bindFailure
+	var batchTCP, batchUDP []int
+	served := 0
+	// serveTCP and serveUDP start the route of a bound socket; a port bound later by -on-bind-error=retry starts the same way.
+	serveTCP := func(i int, listener net.Listener, fromSystemd bool) {
+		route := tcpRoutes[i]
+		targetAddr := route.RemoteAddress()
+		options := routeProxyOptions(proxyOptions, route, *handshakeTimeout, scheduleZone)
+		options.RouteID = route.ID("tcp")
+		routeLogger := logging.RouteLogger(logger, options.RouteID)
+		if fromSystemd {
+			routeLogger.Printf("Starting TCP proxy for route: systemd socket %s remote=%s", activation.RouteName("tcp", route.LocalPort), route.Targets())
+		} else {
+			routeLogger.Printf("Starting TCP proxy for route: local=%s remote=%s", net.JoinHostPort(listenHost, route.LocalPort), route.Targets())
+		}
+		go proxy.ServeTCPProxy(listener, targetAddr, allowList, routeLogger, options)
+	}
+	serveUDP := func(i int, conn net.PacketConn, fromSystemd bool) {
+		route := udpRoutes[i]
 		targetAddr := route.RemoteAddress()
-		logger.Printf("Starting UDP proxy for route: local=%s remote=%s", listenAddr, targetAddr)
-		go proxy.StartUDPProxy(listenAddr, targetAddr, allowList, logger)
+		options := routeProxyOptions(proxyOptions, route, *handshakeTimeout, scheduleZone)
+		options.RouteID = route.ID("udp")
+		routeLogger := logging.RouteLogger(logger, options.RouteID)
+		if fromSystemd {
+			routeLogger.Printf("Starting UDP proxy for route: systemd socket %s remote=%s", activation.RouteName("udp", route.LocalPort), route.Targets())
+		} else {
+			routeLogger.Printf("Starting UDP proxy for route: local=%s remote=%s", net.JoinHostPort(listenHost, route.LocalPort), route.Targets())
+		}
+		go proxy.ServeUDPProxy(conn, targetAddr, allowList, routeLogger, options)
+	}
+	// retryFailedBind binds one failed transport in the background; its stop is registered now, so shutdown reaches it whether or not it ever bound.
+	retryFailedBind := func(failure bindFailure) {
+		stop := make(chan struct{}, 1)
+		listenerStops[failure.protocol] = append(listenerStops[failure.protocol], func() {
+			select {
+			case stop <- struct{}{}:
+			default:
+			}
+		})
+		addr := net.JoinHostPort(listenHost, failure.port)
+		go retryBind(failure.protocol+" :"+failure.port, func() (func(), error) {
+			if failure.protocol == "tcp" {
+				listener, err := proxy.ListenTCP(addr, *tcpFastOpen)
+				if err != nil {
+					return nil, err
+				}
+				serveTCP(failure.index, listener, false)
+				return func() { listener.Close() }, nil
+			}
+			conn, err := net.ListenPacket("udp", addr)
+			if err != nil {
+				return nil, err
+			}
+			serveUDP(failure.index, conn, false)
+			return func() { conn.Close() }, nil
+		}, bindRetryFirstDelay, bindRetryMaxDelay, stop, logger)
+	}
+	startBatch := func() {
+		if len(bindFailures) > 0 {
+			skipped, err := handleBindFailures(*onBindError, bindFailures, bound, retryFailedBind, logger)
+			if err != nil {
+				logger.Fatalf("Error: %v", err)
+			}
+			batchTCP = dropSkippedPorts(batchTCP, tcpRoutes, skipped, func(i int) { tcpListeners[i].Close() })
+			batchUDP = dropSkippedPorts(batchUDP, udpRoutes, skipped, func(i int) { udpConns[i].Close() })
+			bindFailures = nil
+		}
+		for _, i := range batchTCP {
+			listener := tcpListeners[i]
+			listenerStops["tcp"] = append(listenerStops["tcp"], func() { listener.Close() })
+			serveTCP(i, listener, activated["tcp/"+tcpRoutes[i].LocalPort])
+		}
+		for _, i := range batchUDP {
+			conn := udpConns[i]
+			listenerStops["udp"] = append(listenerStops["udp"], func() { conn.Close() })
+			serveUDP(i, conn, activated["udp/"+udpRoutes[i].LocalPort])
+		}
+		served += len(batchTCP) + len(batchUDP)
+		batchTCP, batchUDP = nil, nil
+	}
+	for _, step := range startup {
+		if len(step.After) > 0 {
+			logger.Printf("Route port %s starts after port %s", step.Port, strings.Join(step.After, ", "))
+		}
+		if step.Wait > 0 {
+			startBatch()
+			for _, upstream := range step.Upstreams {
+				logger.Printf("Waiting up to %s for upstream %s before binding port %s", step.Wait, upstream, step.Port)
+				if waited, ok := proxy.WaitForUpstream(upstream, step.Wait); ok {
+					logger.Printf("Upstream %s answered after %s; binding port %s", upstream, waited.Round(time.Millisecond), step.Port)
+				} else {
+					logger.Printf("Upstream %s did not answer within %s; binding port %s anyway", upstream, step.Wait, step.Port)
+				}
+			}
+		}
+		for _, i := range step.TCP {
+			route := tcpRoutes[i]
+			listener, fromSystemd, err := sockets.TCPListener(route.LocalPort)
+			if err != nil {
+				logger.Fatalf("Error: %v", err)
+			}
+			if !fromSystemd {
+				if listener, err = proxy.ListenTCP(net.JoinHostPort(listenHost, route.LocalPort), *tcpFastOpen); err != nil {
+					bindFailures = append(bindFailures, bindFailure{protocol: "tcp", port: route.LocalPort, index: i, err: err})
+					continue
+				}
+			}
+			tcpListeners[i] = listener
+			activated["tcp/"+route.LocalPort], bound["tcp/"+route.LocalPort] = fromSystemd, true
+			batchTCP = append(batchTCP, i)
+		}
+		for _, i := range step.UDP {
+			route := udpRoutes[i]
+			conn, fromSystemd, err := sockets.UDPConn(route.LocalPort)
+			if err != nil {
+				logger.Fatalf("Error: %v", err)
+			}
+			if !fromSystemd {
+				if conn, err = net.ListenPacket("udp", net.JoinHostPort(listenHost, route.LocalPort)); err != nil {
+					bindFailures = append(bindFailures, bindFailure{protocol: "udp", port: route.LocalPort, index: i, err: err})
+					continue
+				}
+			}
+			udpConns[i] = conn
+			activated["udp/"+route.LocalPort], bound["udp/"+route.LocalPort] = fromSystemd, true
+			batchUDP = append(batchUDP, i)
+		}
+	}
+	startBatch()
+	if served == 0 && len(tcpRoutes)+len(udpRoutes) > 0 && configProvider == nil && *onBindError == bindErrorSkip {
+		logger.Fatalf("Error: no route port could be bound, so there is nothing left to serve")
+	}
+
+	proxyOptions.Readiness.Bound()
+	if notifier != nil {
+		go notifySystemd(notifier, proxyOptions.Readiness, logger)
+	}
+	if *startupEvent {
+		go logStartupEvent(proxyOptions.Readiness, listenHost, append(tcpRoutes, configTCPRoutes...), append(udpRoutes, configUDPRoutes...), appVersion, *instanceName, logger)
+	}
+
+	for _, name := range sockets.Unclaimed() {
+		logger.Printf("systemd passed socket %s but no route uses that name; it stays unused", name)
+	}
+
+	// The file appears only once SIGHUP is handled and the routes serve, so a reload sent through it always finds a ready proxy.
+	if *pidFile != "" {
+		if err := writePIDFile(*pidFile); err != nil {
+			logger.Fatalf("Error: %v", err)
+		}
+	}
+
+	if *logBuffer > 0 || *shutdownDrainFirst != "" || notifier != nil || *sessionStateFile != "" || *pidFile != "" {
+		go shutdownOnSignal(logger, func() {
+			// A stale file could later send a reload to whatever process reuses the ID, so it goes first.
+			if *pidFile != "" {
+				if err := os.Remove(*pidFile); err != nil {
+					logger.Printf("Error removing -pid-file: %v", err)
+				}
+			}
+			if err := notifier.Stopping(); err != nil {
+				logger.Printf("Error: %v", err)
+			}
+			// The table is saved before the drain, which would otherwise forget every session it closes.
+			if *sessionStateFile != "" {
+				saveSessionState(proxyOptions.SessionState, *sessionStateFile, logger)
+			}
+			if *shutdownDrainFirst != "" {
+				drainOnShutdown(proxyOptions.Registry, listenerStops, *shutdownDrainFirst, *shutdownGrace, logger)
+			}
+		})
+	}
+
+	if proxyOptions.SingleShot != nil {
+		go exitAfterSingleShot(proxyOptions.SingleShot, listenerStops, logger)
 	}
 
 	if autostartResult != nil && autostartResult.FollowLogs {
@@ -132,20 +1094,97 @@ func main() {
 	select {}
 }
 
-func printStartupSummary(tcpRoutes, udpRoutes []config.Route, allowList config.AllowList, logFile string) {
+// warnUnorderedConfigRoutes names -config routes with after= or wait-for-upstream=, which only order routes from flags.
+// Config routes start and reload together under the supervisor, so the options are kept for -print-config dumps but do nothing there.
+func warnUnorderedConfigRoutes(configFile string, routes []config.Route, logger *log.Logger) {
+	warned := make(map[string]bool)
+	for _, route := range routes {
+		if (route.After == "" && route.WaitForUpstream == 0) || warned[route.LocalPort] {
+			continue
+		}
+		warned[route.LocalPort] = true
+		logger.Printf("Route port %s in %s: after= and wait-for-upstream= only order routes from flags; it starts with the other config routes", route.LocalPort, configFile)
+	}
+}
+
+// notifySystemd sends READY=1 once the proxy is ready and then keeps the unit's watchdog fed, if it has one.
+// READY=1 waits for the same conditions as /healthz, so units ordered after this one never meet unbound ports or unchecked backups.
+func notifySystemd(notifier *activation.Notifier, readiness *proxy.Readiness, logger *log.Logger) {
+	<-readiness.Done()
+	if err := notifier.Ready(); err != nil {
+		logger.Printf("Error: %v", err)
+	} else {
+		logger.Printf("Told systemd the proxy is ready")
+	}
+	if interval := activation.WatchdogInterval(); interval > 0 {
+		logger.Printf("Pinging the systemd watchdog every %s", interval/2)
+		notifier.RunWatchdog(interval, nil, func(err error) {
+			logger.Printf("systemd watchdog ping failed: %v", err)
+		})
+	}
+}
+
+// logStartupEvent logs the single "started" line automation waits for, after readiness when -readiness-delay or failover delays it.
+func logStartupEvent(readiness *proxy.Readiness, listenHost string, tcpRoutes, udpRoutes []config.Route, appVersion, instance string, logger *log.Logger) {
+	<-readiness.Done()
+	logging.Event(logger, "started",
+		logging.Field{Key: "instance", Value: instance},
+		logging.Field{Key: "routes", Value: len(tcpRoutes) + len(udpRoutes)},
+		logging.Field{Key: "tcp", Value: routeListenAddrs(listenHost, tcpRoutes)},
+		logging.Field{Key: "udp", Value: routeListenAddrs(listenHost, udpRoutes)},
+		logging.Field{Key: "version", Value: appVersion},
+		logging.Field{Key: "pid", Value: os.Getpid()})
+}
+
+// routeListenAddrs lists the addresses routes listen on, ":PORT" without -listen-addr, never null so JSON consumers always get a list.
+func routeListenAddrs(listenHost string, routes []config.Route) []string {
+	addrs := make([]string, 0, len(routes))
+	for _, route := range routes {
+		addrs = append(addrs, net.JoinHostPort(listenHost, route.LocalPort))
+	}
+	return addrs
+}
+
+func printStartupSummary(listenHost string, tcpRoutes, udpRoutes []config.Route, allowList config.AllowList, logFile string) {
 	fmt.Print(branding.Banner)
 	for _, route := range tcpRoutes {
-		fmt.Printf("tcp  :%s -> %s\n", route.LocalPort, route.RemoteAddress())
+		fmt.Printf("tcp  %s -> %s\n", net.JoinHostPort(listenHost, route.LocalPort), route.Targets())
 	}
 	for _, route := range udpRoutes {
-		fmt.Printf("udp  :%s -> %s\n", route.LocalPort, route.RemoteAddress())
+		fmt.Printf("udp  %s -> %s\n", net.JoinHostPort(listenHost, route.LocalPort), route.Targets())
 	}
 	fmt.Printf("allow %s\n", allowListSummary(allowList))
 	fmt.Printf("log   %s\n\n", logFile)
 }
 
-func parseRoutesFromFlags(legacyTCPRoutes, legacyUDPRoutes string, simpleFlags config.SimpleRouteFlags) ([]config.Route, []config.Route, error) {
-	if legacyTCPRoutes != "" || legacyUDPRoutes != "" {
+// readStdinFlag finds the one route source set to "-" and reads stdin for it.
+// Only one flag may claim stdin because the first reader would leave nothing for the others.
+func readStdinFlag(stdin io.Reader, flags map[string]*string) (string, []byte, error) {
+	claimed := ""
+	for name, value := range flags {
+		if *value != "-" {
+			continue
+		}
+		if claimed != "" {
+			return "", nil, fmt.Errorf("only one of -routes, -udp-routes, -routes-json, and -config can read stdin")
+		}
+		claimed = name
+	}
+	if claimed == "" {
+		return "", nil, nil
+	}
+
+	content, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s from stdin: %v", claimed, err)
+	}
+	return claimed, content, nil
+}
+
+// parseRoutesFromFlags merges the multi-route flags and falls back to the simple -local/-remote form.
+// -forward and then -routes-json entries are appended to the legacy -routes/-udp-routes lists so all styles can be mixed.
+func parseRoutesFromFlags(legacyTCPRoutes, legacyUDPRoutes, forwardRoutes, routesJSON string, simpleFlags config.SimpleRouteFlags) ([]config.Route, []config.Route, error) {
+	if legacyTCPRoutes != "" || legacyUDPRoutes != "" || forwardRoutes != "" || routesJSON != "" {
 		tcpRoutes, err := config.ParseRoutes(legacyTCPRoutes)
 		if err != nil {
 			return nil, nil, fmt.Errorf("legacy TCP routes: %v", err)
@@ -154,6 +1193,16 @@ func parseRoutesFromFlags(legacyTCPRoutes, legacyUDPRoutes string, simpleFlags c
 		if err != nil {
 			return nil, nil, fmt.Errorf("legacy UDP routes: %v", err)
 		}
+		forwardTCPRoutes, forwardUDPRoutes, err := config.ParseForwardRoutes(forwardRoutes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("-forward routes: %v", err)
+		}
+		jsonTCPRoutes, jsonUDPRoutes, err := config.ParseRoutesJSON(routesJSON)
+		if err != nil {
+			return nil, nil, fmt.Errorf("-routes-json: %v", err)
+		}
+		tcpRoutes = append(append(tcpRoutes, forwardTCPRoutes...), jsonTCPRoutes...)
+		udpRoutes = append(append(udpRoutes, forwardUDPRoutes...), jsonUDPRoutes...)
 		return tcpRoutes, udpRoutes, nil
 	}
 
@@ -161,6 +1210,687 @@ func parseRoutesFromFlags(legacyTCPRoutes, legacyUDPRoutes string, simpleFlags c
 	return tcpRoutes, udpRoutes, err
 }
 
+// -on-bind-error policies for route ports that cannot be bound at startup.
+const (
+	bindErrorSkip  = "skip"
+	bindErrorFatal = "fatal"
+	bindErrorRetry = "retry"
+)
+
+const (
+	bindRetryFirstDelay = time.Second
+	bindRetryMaxDelay   = 30 * time.Second
+)
+
+// bindFailure records a route port that could not be bound at startup.
+type bindFailure struct {
+	protocol string
+	port     string
+	index    int // index is the route's position in the TCP or UDP route list of its protocol.
+	err      error
+}
+
+// handleBindFailures applies the -on-bind-error policy to the ports of one startup batch that failed to bind.
+// fatal returns an error. skip returns the failed ports, whose other transport must not run on its own either.
+// retry hands every failed transport to retry and keeps the transports that bound, since the rest should follow shortly.
+func handleBindFailures(policy string, failures []bindFailure, bound map[string]bool, retry func(bindFailure), logger *log.Logger) (map[string]bool, error) {
+	description := describeBindFailures(failures, bound)
+	switch policy {
+	case bindErrorFatal:
+		return nil, fmt.Errorf("failed to start routes: %s", description)
+	case bindErrorRetry:
+		logger.Printf("WARNING: routes failed to bind; retrying in the background: %s", description)
+		for _, failure := range failures {
+			retry(failure)
+		}
+		return nil, nil
+	default:
+		logger.Printf("WARNING: skipping routes that failed to bind, on both transports of their port: %s", description)
+		skipped := make(map[string]bool)
+		for _, failure := range failures {
+			skipped[failure.port] = true
+		}
+		return skipped, nil
+	}
+}
+
+// dropSkippedPorts removes the routes on skipped ports from a batch and closes the sockets they had bound.
+func dropSkippedPorts(batch []int, routes []config.Route, skipped map[string]bool, closeSocket func(int)) []int {
+	kept := batch[:0]
+	for _, i := range batch {
+		if skipped[routes[i].LocalPort] {
+			closeSocket(i)
+			continue
+		}
+		kept = append(kept, i)
+	}
+	return kept
+}
+
+// retryBind calls bind until it succeeds, waiting firstDelay and then twice as long each time up to maxDelay.
+// Once bound, the returned close function runs on stop, which also ends the retries of a port that never bound.
+func retryBind(name string, bind func() (func(), error), firstDelay, maxDelay time.Duration, stop <-chan struct{}, logger *log.Logger) {
+	delay := firstDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+		closeRoute, err := bind()
+		if err == nil {
+			logger.Printf("Bound %s on retry %d", name, attempt)
+			<-stop
+			closeRoute()
+			return
+		}
+		if delay = delay * 2; delay > maxDelay {
+			delay = maxDelay
+		}
+		logger.Printf("Retry %d to bind %s failed: %v; next attempt in %s", attempt, name, err, delay)
+	}
+}
+
+// describeBindFailures names each port that failed and says when the other transport of the same port bound,
+// because for a route on both protocols that half-open state is what the operator needs to see.
+func describeBindFailures(failures []bindFailure, bound map[string]bool) string {
+	parts := make([]string, 0, len(failures))
+	for _, failure := range failures {
+		part := fmt.Sprintf("%s :%s: %v", failure.protocol, failure.port, failure.err)
+		other := "udp"
+		if failure.protocol == "udp" {
+			other = "tcp"
+		}
+		if bound[other+"/"+failure.port] {
+			part += fmt.Sprintf(" (%s :%s bound fine)", other, failure.port)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// openFilesNeeded estimates the descriptors the routes hold open when every one of them is full.
+// A TCP connection costs two (client and backend), a UDP session one backend socket, and a tarpitted client one.
+func openFilesNeeded(tcpRoutes, udpRoutes, maxConns int, tarpit bool) uint64 {
+	perTCPRoute := uint64(2*maxConns) + 1
+	if tarpit {
+		perTCPRoute += proxy.MaxTarpittedConnectionsPerRoute
+	}
+	perUDPRoute := uint64(proxy.MaxUDPSessionsPerRoute) + 1
+	return uint64(tcpRoutes)*perTCPRoute + uint64(udpRoutes)*perUDPRoute + openFilesOverhead
+}
+
+// countFailoverRoutes counts the routes, of either protocol, whose first health check readiness waits for.
+func countFailoverRoutes(routes []config.Route) int {
+	count := 0
+	for _, route := range routes {
+		if route.Backups != "" || route.Balance != "" || route.CheckExpect != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// checkListenHost binds a throwaway port on the -listen-addr IP, so an address this host does not own fails once at startup
+// with one clear error instead of once per route listener.
+func checkListenHost(listenHost string) error {
+	if listenHost == "" {
+		return nil
+	}
+	probe, err := net.Listen("tcp", net.JoinHostPort(listenHost, "0"))
+	if err != nil {
+		return fmt.Errorf("-listen-addr %s cannot be bound; is it assigned to this host? (%v)", listenHost, err)
+	}
+	return probe.Close()
+}
+
+// durationOr returns value, or fallback when value is zero, so per-direction flags inherit the shared one.
+// probeTCPFastOpen logs whether -tcp-fastopen can take effect and reports whether to keep it on.
+// Sides the sysctl leaves off only lose the saved round trip, but refused socket options would fail every bind, so those turn the flag off.
+func probeTCPFastOpen(logger *log.Logger) bool {
+	server, client, err := proxy.ProbeTCPFastOpen()
+	switch {
+	case err != nil:
+		logger.Printf("WARNING: -tcp-fastopen: %v; continuing without TCP Fast Open", err)
+		return false
+	case server && client:
+		logger.Print("TCP Fast Open is on for listeners and backend dials")
+	case server:
+		logger.Print("WARNING: -tcp-fastopen: net.ipv4.tcp_fastopen enables listeners only; set it to 3 to use Fast Open on backend dials too")
+	case client:
+		logger.Print("WARNING: -tcp-fastopen: net.ipv4.tcp_fastopen enables backend dials only; set it to 3 to accept Fast Open from clients too")
+	default:
+		logger.Print("WARNING: -tcp-fastopen: net.ipv4.tcp_fastopen is 0, so the kernel ignores Fast Open; set it to 3")
+	}
+	return true
+}
+
+func durationOr(value, fallback time.Duration) time.Duration {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+// checkCompressedRoutes keeps ;compress= behind -experimental-compression and off UDP routes, which have no stream to wrap.
+// Both proxies of a pair must opt in, so a route copied to a plain setup fails at startup instead of sending deflate data to a real backend.
+func checkCompressedRoutes(enabled bool, tcpRoutes, udpRoutes []config.Route) error {
+	for _, route := range udpRoutes {
+		if route.Compress != "" {
+			return fmt.Errorf("UDP route on port %s: ;compress= applies to TCP routes only", route.LocalPort)
+		}
+	}
+	for _, route := range tcpRoutes {
+		if route.Compress != "" && !enabled {
+			return fmt.Errorf("TCP route on port %s uses ;compress=%s, which is experimental; add -experimental-compression", route.LocalPort, route.Compress)
+		}
+	}
+	return nil
+}
+
+// checkBridgedRoutes keeps ;target= bridges behind -experimental-bridge and away from options that assume one protocol end to end.
+func checkBridgedRoutes(enabled bool, tcpRoutes, udpRoutes []config.Route) error {
+	check := func(protocol string, route config.Route) error {
+		if route.TargetProtocol == "" || route.TargetProtocol == protocol {
+			return nil
+		}
+		if !enabled {
+			return fmt.Errorf("%s route on port %s uses ;target=%s, which is experimental; add -experimental-bridge", strings.ToUpper(protocol), route.LocalPort, route.TargetProtocol)
+		}
+		if route.Compress != "" || route.Backups != "" || route.Balance != "" || route.AcceptProxy || route.SendProxy != "" {
+			return fmt.Errorf("%s route on port %s: ;target=%s cannot be combined with ;compress=, ;backup=, targets joined by |, or the PROXY protocol options", strings.ToUpper(protocol), route.LocalPort, route.TargetProtocol)
+		}
+		return nil
+	}
+	for _, route := range tcpRoutes {
+		if err := check("tcp", route); err != nil {
+			return err
+		}
+	}
+	for _, route := range udpRoutes {
+		if err := check("udp", route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTCPOnlyOptions keeps upstream rules on TCP routes, since UDP sessions are not tied to one upstream chosen at connect time,
+// and the PROXY protocol too, whose header opens a stream that UDP datagrams do not have.
+// A UDP synthetic check needs ;check-send=, because no UDP backend greets first and a reply only follows a request.
+func checkTCPOnlyOptions(udpRoutes []config.Route) error {
+	for _, route := range udpRoutes {
+		if route.CheckExpect != "" && route.CheckSend == "" {
+			return fmt.Errorf("UDP route on port %s: ;check-expect= needs ;check-send=, since a UDP backend only answers a request", route.LocalPort)
+		}
+		if route.Rules != "" {
+			return fmt.Errorf("UDP route on port %s: ;rule= applies to TCP routes only", route.LocalPort)
+		}
+		if route.AcceptProxy || route.SendProxy != "" {
+			return fmt.Errorf("UDP route on port %s: ;accept-proxy and ;send-proxy= apply to TCP routes only", route.LocalPort)
+		}
+	}
+	return nil
+}
+
+// defaultMaxRoutes is far above hand-written configs, yet low enough that a runaway generator fails before opening thousands of listeners.
+const defaultMaxRoutes = 1024
+
+// checkRouteCount rejects route sets larger than -max-routes; a route on both protocols counts once per listener.
+func checkRouteCount(maxRoutes, routes int) error {
+	if maxRoutes > 0 && routes > maxRoutes {
+		return fmt.Errorf("%d routes exceed -max-routes=%d; raise -max-routes if this many listeners are intended", routes, maxRoutes)
+	}
+	return nil
+}
+
+// parsedRoute is one listener from -parse-routes; an entry that fails to parse keeps its error instead.
+type parsedRoute struct {
+	Entry      int      `json:"entry"`
+	Text       string   `json:"text"`
+	Protocol   string   `json:"protocol,omitempty"`
+	LocalPort  string   `json:"local_port,omitempty"`
+	RemoteIP   string   `json:"remote_ip,omitempty"`
+	RemotePort string   `json:"remote_port,omitempty"`
+	Balance    []string `json:"balance,omitempty"` // Balance lists the targets after the first of a route joined by |.
+	Options    string   `json:"options,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// parseRouteEntries explains a route string one comma-separated entry at a time, so a single typo does not hide how the rest parsed.
+// Entries with a tcp/, udp/, or both/ prefix follow -forward; bare entries are TCP routes as -routes reads them.
+func parseRouteEntries(routeString string) []parsedRoute {
+	var results []parsedRoute
+	for i, entry := range strings.Split(routeString, ",") {
+		entry = strings.TrimSpace(entry)
+		var tcpRoutes, udpRoutes []config.Route
+		var err error
+		if spec, _, _ := strings.Cut(entry, ";"); strings.Contains(spec, "/") {
+			tcpRoutes, udpRoutes, err = config.ParseForwardRoutes(entry)
+		} else {
+			tcpRoutes, err = config.ParseRoutes(entry)
+		}
+		// The flags only gate experimental options, so they are assumed on here and just the combinations are checked.
+		if err == nil {
+			err = checkCompressedRoutes(true, tcpRoutes, udpRoutes)
+		}
+		if err == nil {
+			err = checkBridgedRoutes(true, tcpRoutes, udpRoutes)
+		}
+		if err == nil {
+			err = checkTCPOnlyOptions(udpRoutes)
+		}
+		if err == nil && len(tcpRoutes)+len(udpRoutes) == 0 {
+			err = errors.New("empty route entry")
+		}
+		if err != nil {
+			results = append(results, parsedRoute{Entry: i + 1, Text: entry, Error: err.Error()})
+			continue
+		}
+		for _, route := range tcpRoutes {
+			results = append(results, describeParsedRoute(i+1, entry, "tcp", route))
+		}
+		for _, route := range udpRoutes {
+			results = append(results, describeParsedRoute(i+1, entry, "udp", route))
+		}
+	}
+	return results
+}
+
+func describeParsedRoute(entry int, text, protocol string, route config.Route) parsedRoute {
+	return parsedRoute{
+		Entry:      entry,
+		Text:       text,
+		Protocol:   protocol,
+		LocalPort:  route.LocalPort,
+		RemoteIP:   route.RemoteIP,
+		RemotePort: route.RemotePort,
+		Balance:    route.BalanceAddresses(),
+		Options:    strings.Join(route.Options(), ";"),
+	}
+}
+
+// printParsedRoutes writes the -parse-routes result and reports whether every entry parsed.
+// The table goes to stdout with errors on stderr; JSON keeps both in one document so scripts read a single stream.
+func printParsedRoutes(stdout, stderr io.Writer, routes []parsedRoute, asJSON bool) bool {
+	ok := true
+	for _, route := range routes {
+		if route.Error != "" {
+			ok = false
+		}
+	}
+	if asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(struct {
+			Valid  bool          `json:"valid"`
+			Routes []parsedRoute `json:"routes"`
+		}{ok, routes}); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return false
+		}
+		return ok
+	}
+
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ENTRY\tPROTO\tLISTEN\tTARGET\tOPTIONS")
+	for _, route := range routes {
+		if route.Error != "" {
+			fmt.Fprintf(stderr, "Error: entry %d '%s': %s\n", route.Entry, route.Text, route.Error)
+			continue
+		}
+		targets := strings.Join(append([]string{net.JoinHostPort(route.RemoteIP, route.RemotePort)}, route.Balance...), "|")
+		fmt.Fprintf(table, "%d\t%s\t:%s\t%s\t%s\n", route.Entry, route.Protocol, route.LocalPort, targets, route.Options)
+	}
+	_ = table.Flush()
+	return ok
+}
+
+// printConfigSkippedFlags name the route sources, whose routes the dump already lists, and the flags that only pick what a run does.
+var printConfigSkippedFlags = map[string]bool{
+	"routes": true, "udp-routes": true, "forward": true, "routes-json": true, "local": true, "remote": true, "proto": true, "config": true,
+	"print-config": true, "parse-routes": true, "parse-routes-json": true, "config-encrypt": true, "version": true,
+}
+
+// printConfigSecretFlags are masked in the dump, which tends to be pasted into tickets.
+var printConfigSecretFlags = map[string]bool{"admin-token": true, "tls-key-passphrase": true}
+
+// printEffectiveConfig writes the merged routes as a -config file, so the dump can be loaded back, with every flag value it ran with.
+// Defaults are included because the point is to see what the proxy would really use, not only what was typed.
+func printEffectiveConfig(output io.Writer, tcpRoutes, udpRoutes []config.Route, flags *flag.FlagSet) error {
+	// Both stays empty: a route on both transports is listed under tcp and udp, which loads the same.
+	file := config.File{TCP: []string{}, UDP: []string{}, Both: []string{}, Flags: make(map[string]string)}
+	for _, route := range tcpRoutes {
+		file.TCP = append(file.TCP, route.String())
+	}
+	for _, route := range udpRoutes {
+		file.UDP = append(file.UDP, route.String())
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch {
+		case printConfigSkippedFlags[f.Name]:
+			return
+		case printConfigSecretFlags[f.Name] && value != "":
+			value = "REDACTED"
+		}
+		file.Flags[f.Name] = value
+	})
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(file)
+}
+
+// routeProxyOptions layers per-route settings over the process-wide defaults.
+// Routes without their own value inherit the global flag so simple setups need only one switch.
+func routeProxyOptions(base proxy.Options, route config.Route, handshakeTimeout time.Duration, scheduleZone *time.Location) proxy.Options {
+	options := base
+	options.HandshakeTimeout = handshakeTimeout
+	if route.HandshakeTimeout > 0 {
+		options.HandshakeTimeout = route.HandshakeTimeout
+	}
+	// Only routes marked ;http carry HTTP/1.x, so other routes never pay for request parsing or rewriting.
+	options.Balance = route.BalanceAddresses()
+	options.Backups = route.BackupAddresses()
+	options.SyntheticCheck = nil
+	if route.CheckExpect != "" {
+		options.SyntheticCheck = &proxy.SyntheticCheck{Send: []byte(route.CheckSend), Expect: []byte(route.CheckExpect)}
+	}
+	options.Rules = route.RuleList()
+	// A route's schedule is parsed again with the default zone, which only main knows; the text was checked with the route.
+	options.Schedule = route.ScheduleIn(scheduleZone)
+	options.ServerFirst = route.ServerFirst
+	options.CompressBackend = route.Compress == "backend"
+	options.CompressClients = route.Compress == "client"
+	options.AcceptProxyProtocol = route.AcceptProxy
+	options.SendProxyProtocol = 0
+	switch route.SendProxy {
+	case "v1":
+		options.SendProxyProtocol = 1
+	case "v2":
+		options.SendProxyProtocol = 2
+	}
+	// Each worker reads only the flag for the other protocol, so ;target= naming the listener's own protocol changes nothing.
+	options.TargetUDP = route.TargetProtocol == "udp"
+	options.TargetTCP = route.TargetProtocol == "tcp"
+	if !route.HTTP {
+		options.AccessLog = nil
+		options.ForwardedFor = false
+	}
+	return options
+}
+
+// loadConfigKey reads the key for encrypted configs from -config-key-file, or else from the environment; plain configs need none.
+func loadConfigKey(keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		key, exposed, err := config.ReadKeyFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if exposed {
+			log.Printf("WARNING: config key file %s is readable by every user; restrict it with chmod 600", keyFile)
+		}
+		return key, nil
+	}
+	if value := os.Getenv(config.KeyEnv); value != "" {
+		key, err := config.ParseKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", config.KeyEnv, err)
+		}
+		return key, nil
+	}
+	return nil, nil
+}
+
+// encryptConfig seals a plain config for -config-encrypt, checking it first so a broken file is never encrypted.
+func encryptConfig(input io.Reader, output io.Writer, key []byte) error {
+	if key == nil {
+		return fmt.Errorf("-config-encrypt needs -config-key-file or %s", config.KeyEnv)
+	}
+	plain, err := io.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf("failed to read config from stdin: %v", err)
+	}
+	if _, _, err := config.ParseFile(plain); err != nil {
+		return fmt.Errorf("config from stdin: %v", err)
+	}
+	sealed, err := config.Seal(plain, key)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(sealed)
+	return err
+}
+
+// watchConfigProvider applies each new version a provider sends, whether it comes from a file, a directory, or a URL,
+// and re-reads the source for each SIGHUP that hangups carries.
+// A version that fails to read, parse, or validate is rejected as a whole, so a half-written edit can never take running routes down.
+func watchConfigProvider(supervisor *proxy.Supervisor, provider config.Provider, source string, hangups <-chan struct{}, validate func(tcpRoutes, udpRoutes []config.Route) error, logger *log.Logger) {
+	updates := make(chan config.Update)
+	go provider.Watch(updates)
+	for {
+		select {
+		case update := <-updates:
+			if update.Files != nil {
+				logger.Printf("Config directory %s changed: %s", source, describeConfigFiles(update.Files))
+			}
+			applyReloadedRoutes(supervisor, source, update.TCP, update.UDP, update.Err, validate, logger)
+		case <-hangups:
+			update := provider.Reread()
+			if update.Files != nil {
+				logger.Printf("SIGHUP: reloading routes from directory %s: %s", source, describeConfigFiles(update.Files))
+			} else {
+				logger.Printf("SIGHUP: reloading routes from %s", source)
+			}
+			applyReloadedRoutes(supervisor, source, update.TCP, update.UDP, update.Err, validate, logger)
+		}
+	}
+}
+
+// applyReloadedRoutes hands reloaded routes to the supervisor unless parsing or validation failed.
+func applyReloadedRoutes(supervisor *proxy.Supervisor, configFile string, tcpRoutes, udpRoutes []config.Route, err error, validate func(tcpRoutes, udpRoutes []config.Route) error, logger *log.Logger) {
+	if err == nil {
+		err = validate(tcpRoutes, udpRoutes)
+	}
+	if err != nil {
+		logger.Printf("Config reload of %s rejected; running routes unchanged: %v", configFile, err)
+		return
+	}
+
+	changes, err := supervisor.Apply(tcpRoutes, udpRoutes)
+	if len(changes) > 0 {
+		logger.Printf("Config reload of %s applied: %s", configFile, strings.Join(changes, "; "))
+	} else if err == nil {
+		logger.Printf("Config reload of %s: routes unchanged", configFile)
+	}
+	if err != nil {
+		logger.Printf("Config reload of %s: %v", configFile, err)
+	}
+}
+
+// applyConfigSettings sets each flag the config file has a setting for, unless the command line set it, and lists what it set.
+// The flags' own parsing and the checks after it then treat a file setting exactly like the flag.
+func applyConfigSettings(flags *flag.FlagSet, configFile string, stdinContent, key []byte) ([]string, error) {
+	var settings map[string]string
+	if configFile == "-" {
+		content, err := config.Unseal(stdinContent, key)
+		if err != nil {
+			return nil, fmt.Errorf("config from stdin: %v", err)
+		}
+		if settings, err = config.ParseSettings(content); err != nil {
+			return nil, fmt.Errorf("config from stdin: %v", err)
+		}
+	} else {
+		var err error
+		if settings, err = config.LoadSettings(configFile, key); err != nil {
+			return nil, err
+		}
+	}
+	fromCommandLine := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { fromCommandLine[f.Name] = true })
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var applied []string
+	for _, name := range names {
+		if fromCommandLine[name] {
+			continue
+		}
+		if err := flags.Set(name, settings[name]); err != nil {
+			return nil, fmt.Errorf("config setting for -%s: %v", name, err)
+		}
+		applied = append(applied, "-"+name+"="+settings[name])
+	}
+	return applied, nil
+}
+
+// describeConfigFiles lists the files a config directory was read from, naming the empty case so the log line never ends blank.
+func describeConfigFiles(names []string) string {
+	if len(names) == 0 {
+		return "no " + strings.Join(config.DirExtensions, ", ") + " files"
+	}
+	return strings.Join(names, ", ")
+}
+
+// runOnSignal performs every registered action each time the signal arrives.
+// Keeping one receiver per signal lets independent features share SIGHUP without racing each other.
+// The signal is caught before runOnSignal returns, so one sent right after can no longer end the process.
+func runOnSignal(sig os.Signal, actions []func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sig)
+	go func() {
+		for range signals {
+			runAll(actions)
+		}
+	}()
+}
+
+// writePIDFile records this process for the reload subcommand.
+func writePIDFile(path string) error {
+	if err := os.WriteFile(path, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0o644); err != nil {
+		return fmt.Errorf("failed to write -pid-file: %v", err)
+	}
+	return nil
+}
+
+// runReloadCommand sends SIGHUP to the proxy named by a -pid-file, which re-reads -config and applies changed routes
+// while unchanged routes keep their connections. The result lands in the proxy's log, not here.
+func runReloadCommand(args []string, output io.Writer) error {
+	flags := flag.NewFlagSet("reload", flag.ContinueOnError)
+	pidFile := flags.String("pid-file", "", "The -pid-file of the running proxy")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *pidFile == "" || flags.NArg() > 0 {
+		return errors.New("usage: chicha-ip-proxy reload -pid-file PATH")
+	}
+	content, err := os.ReadFile(*pidFile)
+	if err != nil {
+		return fmt.Errorf("failed to read -pid-file: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("-pid-file %s holds no process ID", *pidFile)
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("process %d: %v", pid, err)
+	}
+	if err := process.Signal(syscall.SIGHUP); err != nil {
+		return fmt.Errorf("failed to send SIGHUP to process %d: %v", pid, err)
+	}
+	fmt.Fprintf(output, "Sent SIGHUP to process %d; its log shows the routes reloaded\n", pid)
+	return nil
+}
+
+// shutdownOnSignal drains live flows, then writes buffered log lines before the service manager stops the process.
+// Without it the last second of connection logs would vanish on every restart.
+func shutdownOnSignal(logger *log.Logger, drain func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	logger.Printf("Received %s; shutting down", sig)
+	drain()
+	flushLogsAndExit(logger)
+}
+
+// saveSessionState writes the UDP session table for the next start; a failed write is logged and the shutdown goes on.
+func saveSessionState(state *proxy.UDPSessionState, path string, logger *log.Logger) {
+	sessions := state.Snapshot()
+	if err := proxy.SaveUDPSessionState(path, sessions); err != nil {
+		logger.Printf("Error saving UDP sessions: %v", err)
+		return
+	}
+	logger.Printf("Saved %d UDP session mappings to %s", len(sessions), path)
+}
+
+// exitAfterSingleShot stops every route once the single-shot client is taken and exits when it is done.
+// Other routes close their listeners right away, so later clients get a refused connection instead of a reset.
+func exitAfterSingleShot(shot *proxy.SingleShot, listenerStops map[string][]func(), logger *log.Logger) {
+	<-shot.Claimed()
+	runAll(listenerStops["tcp"])
+	<-shot.Done()
+	logger.Printf("Single-shot connection finished; shutting down")
+	flushLogsAndExit(logger)
+}
+
+// flushLogsAndExit writes out buffered log lines before a clean exit, because os.Exit skips deferred flushes.
+func flushLogsAndExit(logger *log.Logger) {
+	if err := logging.Flush(logger); err != nil {
+		log.Printf("Error flushing logs: %v", err)
+	}
+	os.Exit(0)
+}
+
+// drainOnShutdown stops new clients and drains TCP and UDP side by side, each with its own deadline.
+// The protocol named by drainFirst (or both) gets grace to finish; the other is closed at once so it cannot hold up the exit.
+func drainOnShutdown(registry *proxy.Registry, listenerStops map[string][]func(), drainFirst string, grace time.Duration, logger *log.Logger) {
+	results := make(chan proxy.DrainResult, 2)
+	for _, protocol := range []string{"tcp", "udp"} {
+		protocolGrace := time.Duration(0)
+		if drainFirst == protocol || drainFirst == "both" {
+			protocolGrace = grace
+		}
+		go func(protocol string, grace time.Duration) {
+			// Accepted TCP connections outlive their listener, but UDP sessions reply through the route socket, so it closes last.
+			if protocol == "tcp" {
+				runAll(listenerStops[protocol])
+			}
+			result := registry.DrainProtocol(protocol, grace)
+			if protocol == "udp" {
+				runAll(listenerStops[protocol])
+			}
+			results <- result
+		}(protocol, protocolGrace)
+	}
+
+	for i := 0; i < 2; i++ {
+		result := <-results
+		logger.Printf("Shutdown drain %s: %d finished on their own, %d closed, took %s",
+			result.Protocol, result.Finished, result.Closed, result.Elapsed.Round(time.Millisecond))
+	}
+}
+
+// runAll runs actions in order; listener stops and SIGHUP handlers both use it.
+func runAll(actions []func()) {
+	for _, action := range actions {
+		action()
+	}
+}
+
+// reloadCertificates re-reads TLS material so renewed certificates apply without a restart.
+// A failed reload keeps serving the previous certificate because dropping TLS would break every new client.
+func reloadCertificates(certStore *certstore.Store, certFile string, logger *log.Logger) {
+	if err := certStore.Reload(); err != nil {
+		logger.Printf("TLS certificate reload failed; keeping the previous certificate: %v", err)
+		return
+	}
+	logger.Printf("TLS certificate reloaded from %s", certFile)
+}
+
 func validateRotationFrequency(rotation time.Duration) error {
 	if rotation <= 0 {
 		return fmt.Errorf("-rotation must be positive")
@@ -197,18 +1927,91 @@ func showFlagHelp() {
 	fmt.Println("Usage:")
 	fmt.Println("  chicha-ip-proxy -local=PORT -remote=IP|IP:PORT|[IPv6]:PORT [options]")
 	fmt.Println("  chicha-ip-proxy        # setup wizard")
+	fmt.Println("  chicha-ip-proxy reload -pid-file PATH  # re-read -config in a running proxy")
 	fmt.Println()
 	fmt.Println("Flags:")
 	fmt.Println("  -local PORT")
 	fmt.Println("  -remote IP|IP:PORT|[IPv6]:PORT")
-	fmt.Println("  -proto tcp|udp")
+	fmt.Println("  -proto tcp|udp|both")
+	fmt.Println("  -forward tcp/PORT:IP:PORT,udp/PORT:IP:PORT,both/PORT:IP:PORT")
+	fmt.Println("  -routes-json JSON|-    # routes as a JSON array of {\"local\",\"remote_ip\",\"remote_port\",\"proto\"} objects")
+	fmt.Println("  -config FILE|DIR|- [-config-reload-interval 30s]  # JSON or YAML routes, log and rotation settings")
+	fmt.Println("  -config-url URL [-config-reload-interval 30s]  # JSON route array, polled with ETags")
+	fmt.Println("  -config-key-file FILE  # opens encrypted -config; -config-encrypt < plain > sealed")
+	fmt.Println("  -listen-addr IP        # bind every route to one local IP instead of all interfaces")
+	fmt.Println("  -netns NAME|PID|PATH   # Linux: run inside a network namespace")
 	fmt.Println("  -allow IP|CIDR")
-	fmt.Println("  -log PATH")
-	fmt.Println("  -rotation 24h")
+	fmt.Println("  -client-family any|ipv4|ipv6")
+	fmt.Println("  -log PATH [-log-mkdir]")
+	fmt.Println("  -rotation 24h [-rotation-min-size BYTES]  # scheduled rotation skips smaller and empty logs")
+	fmt.Println("  -log-mode dated|ring -log-max-size 100 -log-ring-files 5")
+	fmt.Println("  -log-format text|json|logfmt -log-timezone local|utc -log-microseconds")
+	fmt.Println("  -log-buffer 65536")
+	fmt.Println("  -log-caller            # add file:line of the code that logged each line")
+	fmt.Println("  -log-remote tcp://HOST:PORT|udp://HOST:PORT  # also send JSON lines to a collector")
+	fmt.Println("  -log-rate-limit 20     # lines/s per repeated error before summarizing; 0 disables")
+	fmt.Println("  -admin-addr 9090 [-admin-token TOKEN] [-admin-expose] [-readiness-delay 10s]")
+	fmt.Println("  -dashboard-addr 9091  # browser page with live route stats, behind the same token")
+	fmt.Println("  -tls-cert FILE -tls-key FILE [-tls-key-passphrase-file FILE]")
+	fmt.Println("  -handshake-timeout 10s")
+	fmt.Println("  -backend-first-byte-timeout 5s  # greeting deadline for routes marked ;server-first")
+	fmt.Println("  -parse-routes ROUTES|- [-parse-routes-json]  # show how a route string parses, then exit")
+	fmt.Println("  -print-config          # print the merged routes and settings as a JSON -config file, then exit")
+	fmt.Println("  -max-routes 1024  # refuse configs with more routes; 0 removes the cap")
+	fmt.Println("  -use-original-dst     # Linux: forward TCP to the pre-REDIRECT destination")
+	fmt.Println("  -numa-aware  # experimental, Linux: one pinned TCP accept loop and worker pool per NUMA node")
+	fmt.Println("  -experimental-bridge  # allow ;target=udp on TCP routes and ;target=tcp on UDP routes")
+	fmt.Println("  -experimental-compression  # allow ;compress=backend|client between two chicha-ip-proxy instances")
+	fmt.Println("  -tcp-idle-timeout 5m [-tcp-client-idle 1h] [-tcp-server-idle 5m]")
+	fmt.Println("  -tcp-user-timeout 30s  # Linux: drop connections whose sent data stays unacknowledged")
+	fmt.Println("  -tcp-fastopen          # Linux: TCP Fast Open on listeners and backend dials")
+	fmt.Println("  -max-conns 1024 [-max-conns-per-ip 16]")
+	fmt.Println("  -health-interval 5s    # probes for routes with ;backup=IP:PORT or IP:PORT|IP:PORT targets, TCP and UDP")
+	fmt.Println("  -synthetic-check-timeout 5s  # for routes with ;check-send=PING\\r\\n;check-expect=PONG")
+	fmt.Println("  -health-log-transitions-only=false  # log every probe, not just up/down")
+	fmt.Println("  -pool-empty-policy reject  # reset clients while every target is down (default retry-any)")
+	fmt.Println("  -failover-redial  # move a connection to the next backup when its target fails to connect")
+	fmt.Println("  -tarpit-duration 30s")
+	fmt.Println("  -udp-dial-retries 3 -udp-dial-backoff 100ms")
+	fmt.Println("  -udp-max-session-lifetime 1h  # reopen long-lived UDP sessions")
+	fmt.Println("  -udp-new-session-rate 500  # cap new UDP sessions per second against spoofed floods")
+	fmt.Println("  -udp-batch-replies 16  # Linux: send bursts of small UDP replies with one sendmmsg call")
+	fmt.Println("  -udp-batch-recv 32  # Linux: read client datagrams in recvmmsg batches")
+	fmt.Println("  -udp-pmtud  # log UDP datagrams too large for the backend path instead of fragmenting them")
+	fmt.Println("  -udp-reject-mode silent|icmp|payload [-udp-reject-payload TEXT]  # answer refused UDP clients")
+	fmt.Println("  -egress-ip-pool IP,IP")
+	fmt.Println("  -udp-egress-ip-pool IP,IP  # spread UDP sessions over several source IPs")
+	fmt.Println("  -egress-policy via=wg0,no-loop  # refuse dials the routing table would send elsewhere (Linux)")
+	fmt.Println("  -upstream-max-dials 32 # queue TCP dials beyond this many per backend")
+	fmt.Println("  -metrics-file PATH -metrics-interval 15s")
+	fmt.Println("  -metrics-addr 9464     # serve the same counters at /metrics for Prometheus")
+	fmt.Println("  -route-quota 10GB/24h  # refuse new clients once a route forwarded this much in the window")
+	fmt.Println("  -mem-pressure-pause 1GB,800MB  # hold back new clients while memory use is high")
+	fmt.Println("  -http-access-log PATH  # Combined Log Format for routes marked ;http")
+	fmt.Println("  -http-xff              # X-Forwarded-For on routes marked ;http")
+	fmt.Println("  -forward=\"tcp/443:10.0.0.1:443;send-proxy=v2\"  # PROXY protocol to the backend; ;accept-proxy reads it from a balancer")
+	fmt.Println("  -log-sni [-peek-buffer-max BYTES]  # larger or stalled ClientHellos close the connection")
+	fmt.Println("  -min-connection-log-duration 500ms  # skip open/close lines of brief connections that sent nothing")
+	fmt.Println("  -drain-on-sighup -shutdown-grace 10s")
+	fmt.Println("  -pid-file PATH         # then 'chicha-ip-proxy reload -pid-file PATH' re-reads -config like SIGHUP")
+	fmt.Println("  -shutdown-drain-first tcp|udp|both")
+	fmt.Println("  -session-state-file PATH  # keep UDP clients on their upstream across a restart")
+	fmt.Println("  -on-bind-error skip|fatal|retry  # what to do with a route port that is already taken")
+	fmt.Println("  -schedule-timezone Europe/Moscow -maintenance-file PATH  # zone and refusal page of ;schedule= routes")
+	fmt.Println("  -single-shot           # exit after the first TCP client closes")
+	fmt.Println("  -socket-activation     # setup wizard writes systemd .socket units")
+	fmt.Println("  -systemd-notify [-systemd-watchdog 30s]  # setup wizard writes a Type=notify unit")
+	fmt.Println("  -unit-output PATH|- -init-output PATH|-  # setup wizard writes files instead of installing")
+	fmt.Println("  -setup-format plain    # setup wizard prints PROMPT:key lines for scripts")
+	fmt.Println("  -quiet -startup-event  # no banner; log one structured started line")
+	fmt.Println("  -instance-name edge-fra-1  # name this proxy in structured logs and metric labels (default hostname)")
 	fmt.Println("  -version")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  chicha-ip-proxy -local=8080 -remote=203.0.113.10 -allow=198.51.100.7")
 	fmt.Println("  chicha-ip-proxy -local=5353 -remote=203.0.113.20:53 -proto=udp")
 	fmt.Println("  chicha-ip-proxy -local=8443 -remote=[2001:db8::10]:443")
+	fmt.Println("  chicha-ip-proxy -forward=tcp/8080:203.0.113.10:80,udp/5353:203.0.113.20:53")
+	fmt.Println("  chicha-ip-proxy -forward=\"both/53:203.0.113.20:53|203.0.113.21:53\"  # round-robin over both targets")
+	fmt.Println("  generate-config | chicha-ip-proxy -config=-")
 }
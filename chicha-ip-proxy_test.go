@@ -1,17 +1,26 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"io"
+	"log"
+	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+	"github.com/matveynator/chicha-ip-proxy/pkg/proxy"
 )
 
 func TestParseRoutesFromFlagsUsesSimpleFlags(t *testing.T) {
-	tcpRoutes, udpRoutes, err := parseRoutesFromFlags("", "", config.SimpleRouteFlags{
+	tcpRoutes, udpRoutes, err := parseRoutesFromFlags("", "", "", "", config.SimpleRouteFlags{
 		Local:  "8080",
 		Remote: "203.0.113.10",
 		Proto:  "tcp",
@@ -28,7 +37,7 @@ func TestParseRoutesFromFlagsUsesSimpleFlags(t *testing.T) {
 }
 
 func TestParseRoutesFromFlagsKeepsLegacyPrecedence(t *testing.T) {
-	tcpRoutes, udpRoutes, err := parseRoutesFromFlags("9000:203.0.113.9:90", "", config.SimpleRouteFlags{
+	tcpRoutes, udpRoutes, err := parseRoutesFromFlags("9000:203.0.113.9:90", "", "", "", config.SimpleRouteFlags{
 		Local:  "8080",
 		Remote: "203.0.113.10",
 	})
@@ -46,6 +55,19 @@ func TestParseRoutesFromFlagsKeepsLegacyPrecedence(t *testing.T) {
 	}
 }
 
+func TestParseRoutesFromFlagsMergesForwardWithLegacyRoutes(t *testing.T) {
+	tcpRoutes, udpRoutes, err := parseRoutesFromFlags("9000:203.0.113.9:90", "", "udp/5353:203.0.113.20:53,tcp/8080:203.0.113.10:80", "", config.SimpleRouteFlags{})
+	if err != nil {
+		t.Fatalf("parseRoutesFromFlags returned error: %v", err)
+	}
+	if len(tcpRoutes) != 2 || len(udpRoutes) != 1 {
+		t.Fatalf("route counts = tcp %d udp %d, want 2 and 1", len(tcpRoutes), len(udpRoutes))
+	}
+	if tcpRoutes[0].LocalPort != "9000" || tcpRoutes[1].LocalPort != "8080" {
+		t.Fatalf("TCP routes = %#v", tcpRoutes)
+	}
+}
+
 func TestValidateRotationFrequencyRejectsNonPositive(t *testing.T) {
 	if err := validateRotationFrequency(time.Hour); err != nil {
 		t.Fatalf("validateRotationFrequency rejected positive duration: %v", err)
@@ -60,12 +82,13 @@ func TestValidateRotationFrequencyRejectsNonPositive(t *testing.T) {
 
 func TestShowFlagHelpHidesLegacyRouteFlags(t *testing.T) {
 	helpOutput := captureStdout(t, showFlagHelp)
-	for _, want := range []string{"-local", "-remote", "-proto", "-allow"} {
+	for _, want := range []string{"-local", "-remote", "-proto", "-allow", "-routes-json"} {
 		if !strings.Contains(helpOutput, want) {
 			t.Fatalf("help output missing %q:\n%s", want, helpOutput)
 		}
 	}
-	for _, hidden := range []string{"-routes", "-udp-routes"} {
+	// The spaces around each name match the flags themselves, not -max-routes or -routes-json, which the help does list.
+	for _, hidden := range []string{" -routes ", " -routes=", " -udp-routes ", " -udp-routes="} {
 		if strings.Contains(helpOutput, hidden) {
 			t.Fatalf("help output should hide %q:\n%s", hidden, helpOutput)
 		}
@@ -93,3 +116,435 @@ func captureStdout(t *testing.T, fn func()) string {
 	reader.Close()
 	return string(output)
 }
+
+func TestReadStdinFlagReadsOnlyTheClaimingFlag(t *testing.T) {
+	routes, udpRoutes, configPath := "-", "", "routes.json"
+	name, content, err := readStdinFlag(strings.NewReader("8080:203.0.113.10:80\n"), map[string]*string{
+		"-routes":     &routes,
+		"-udp-routes": &udpRoutes,
+		"-config":     &configPath,
+	})
+	if err != nil {
+		t.Fatalf("readStdinFlag returned error: %v", err)
+	}
+	if name != "-routes" || string(content) != "8080:203.0.113.10:80\n" {
+		t.Fatalf("readStdinFlag = %q, %q", name, content)
+	}
+}
+
+func TestReadStdinFlagRejectsSeveralClaims(t *testing.T) {
+	routes, udpRoutes := "-", "-"
+	_, _, err := readStdinFlag(strings.NewReader(""), map[string]*string{
+		"-routes":     &routes,
+		"-udp-routes": &udpRoutes,
+	})
+	if err == nil {
+		t.Fatal("readStdinFlag returned nil error for two stdin flags")
+	}
+}
+
+func TestReadStdinFlagLeavesStdinAloneWithoutClaims(t *testing.T) {
+	routes := "8080:203.0.113.10:80"
+	name, _, err := readStdinFlag(failingReader{}, map[string]*string{"-routes": &routes})
+	if err != nil || name != "" {
+		t.Fatalf("readStdinFlag = %q, %v; want no claim", name, err)
+	}
+}
+
+// failingReader proves stdin was never touched, since reading it would block an interactive terminal.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestRouteProxyOptionsKeepsHTTPFeaturesForHTTPRoutesOnly(t *testing.T) {
+	base := proxy.Options{AccessLog: log.New(io.Discard, "", 0), ForwardedFor: true}
+	if options := routeProxyOptions(base, config.Route{HTTP: true}, 0, time.Local); options.AccessLog == nil || !options.ForwardedFor {
+		t.Fatal("HTTP route lost its HTTP options")
+	}
+	if options := routeProxyOptions(base, config.Route{}, 0, time.Local); options.AccessLog != nil || options.ForwardedFor {
+		t.Fatal("plain route kept HTTP options")
+	}
+}
+
+func TestRouteProxyOptionsEvaluatesSchedulesInTheDefaultZone(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	if options := routeProxyOptions(proxy.Options{}, config.Route{Schedule: "09:00-18:00"}, 0, tokyo); options.Schedule.Location() != tokyo {
+		t.Fatal("schedule without a zone ignored -schedule-timezone")
+	}
+	if options := routeProxyOptions(proxy.Options{}, config.Route{Schedule: "09:00-18:00 UTC"}, 0, tokyo); options.Schedule.Location() != time.UTC {
+		t.Fatal("schedule lost its own zone")
+	}
+	if options := routeProxyOptions(proxy.Options{}, config.Route{}, 0, tokyo); options.Schedule != nil {
+		t.Fatal("route without a schedule got one")
+	}
+}
+
+func TestCheckCompressedRoutesNeedsTheExperimentalFlag(t *testing.T) {
+	compressed := []config.Route{{LocalPort: "8080", Compress: "backend"}}
+	if err := checkCompressedRoutes(false, compressed, nil); err == nil {
+		t.Fatal("compressed route accepted without -experimental-compression")
+	}
+	if err := checkCompressedRoutes(true, compressed, nil); err != nil {
+		t.Fatalf("checkCompressedRoutes returned error: %v", err)
+	}
+	if err := checkCompressedRoutes(true, nil, []config.Route{{LocalPort: "53", Compress: "client"}}); err == nil {
+		t.Fatal("compressed UDP route accepted")
+	}
+	if options := routeProxyOptions(proxy.Options{}, compressed[0], 0, time.Local); !options.CompressBackend || options.CompressClients {
+		t.Fatalf("routeProxyOptions = backend %v, clients %v; want backend only", options.CompressBackend, options.CompressClients)
+	}
+}
+
+func TestProxyProtocolOptionsStayOnTCPRoutes(t *testing.T) {
+	route := config.Route{LocalPort: "443", AcceptProxy: true, SendProxy: "v2"}
+	if options := routeProxyOptions(proxy.Options{SendProxyProtocol: 1}, route, 0, time.Local); !options.AcceptProxyProtocol || options.SendProxyProtocol != 2 {
+		t.Fatalf("routeProxyOptions = accept %v, send %d; want accept and v2", options.AcceptProxyProtocol, options.SendProxyProtocol)
+	}
+	if options := routeProxyOptions(proxy.Options{SendProxyProtocol: 1}, config.Route{}, 0, time.Local); options.AcceptProxyProtocol || options.SendProxyProtocol != 0 {
+		t.Fatalf("plain route kept PROXY protocol options: accept %v, send %d", options.AcceptProxyProtocol, options.SendProxyProtocol)
+	}
+	if err := checkTCPOnlyOptions([]config.Route{{LocalPort: "53", SendProxy: "v1"}}); err == nil {
+		t.Fatal("UDP route accepted with ;send-proxy=")
+	}
+	if err := checkBridgedRoutes(true, []config.Route{{LocalPort: "5353", TargetProtocol: "udp", AcceptProxy: true}}, nil); err == nil {
+		t.Fatal("bridged route accepted with ;accept-proxy")
+	}
+}
+
+func TestBalancedRoutesTakeHealthChecksButNotBridges(t *testing.T) {
+	routes := parseRouteEntries("8080:10.0.0.1:80|10.0.0.2:80;backup=10.0.0.3:80,udp/53:10.0.0.1:53|10.0.0.2:53;check-send=x;check-expect=y,udp/5353:10.0.0.1:53|10.0.0.2:53;target=tcp")
+	if len(routes) != 3 || routes[0].Error != "" || !reflect.DeepEqual(routes[0].Balance, []string{"10.0.0.2:80"}) {
+		t.Fatalf("balanced row = %#v", routes)
+	}
+	if routes[1].Error != "" {
+		t.Fatalf("balanced UDP route with a synthetic check = %#v, want it accepted", routes[1])
+	}
+	if !strings.Contains(routes[2].Error, "targets joined by |") {
+		t.Fatalf("balanced bridge = %#v, want an error", routes[2])
+	}
+	if err := checkTCPOnlyOptions([]config.Route{{LocalPort: "53", CheckExpect: "y"}}); err == nil || !strings.Contains(err.Error(), "needs ;check-send=") {
+		t.Fatalf("UDP check without a request returned %v, want it rejected", err)
+	}
+	if options := routeProxyOptions(proxy.Options{}, config.Route{Balance: "10.0.0.2:80 10.0.0.3:80"}, 0, time.Local); len(options.Balance) != 2 {
+		t.Fatalf("routeProxyOptions Balance = %v, want both extra targets", options.Balance)
+	}
+	if count := countFailoverRoutes([]config.Route{{Balance: "10.0.0.2:53"}, {Backups: "10.0.0.3:53"}, {}}); count != 2 {
+		t.Fatalf("countFailoverRoutes = %d, want the balanced and the backed-up route", count)
+	}
+}
+
+func TestCheckBridgedRoutesNeedsTheExperimentalFlag(t *testing.T) {
+	bridged := []config.Route{{LocalPort: "5353", TargetProtocol: "udp"}}
+	if err := checkBridgedRoutes(false, bridged, nil); err == nil {
+		t.Fatal("bridged route accepted without -experimental-bridge")
+	}
+	if err := checkBridgedRoutes(true, bridged, nil); err != nil {
+		t.Fatalf("checkBridgedRoutes returned error: %v", err)
+	}
+	if err := checkBridgedRoutes(false, nil, []config.Route{{LocalPort: "53", TargetProtocol: "udp"}}); err != nil {
+		t.Fatalf("UDP route targeting UDP is not a bridge, got error: %v", err)
+	}
+	if err := checkBridgedRoutes(true, []config.Route{{LocalPort: "53", TargetProtocol: "udp", Backups: "203.0.113.11:53"}}, nil); err == nil {
+		t.Fatal("bridged route accepted with TCP failover backups")
+	}
+}
+
+func TestParseRouteEntriesReportsEachEntry(t *testing.T) {
+	routes := parseRouteEntries("8080:10.0.0.1:80;http;backup=10.0.0.2:80, both/53:[2001:db8::1]:53,9000:300.1.1.1:80")
+	if len(routes) != 4 {
+		t.Fatalf("parseRouteEntries returned %d rows, want 4: %#v", len(routes), routes)
+	}
+	if routes[0].Protocol != "tcp" || routes[0].LocalPort != "8080" || routes[0].Options != "http;backup=10.0.0.2:80" {
+		t.Fatalf("first row = %#v", routes[0])
+	}
+	if routes[1].Entry != 2 || routes[2].Entry != 2 || routes[1].Protocol != "tcp" || routes[2].Protocol != "udp" || routes[2].RemoteIP != "2001:db8::1" {
+		t.Fatalf("both/ rows = %#v, %#v", routes[1], routes[2])
+	}
+	if routes[3].Entry != 3 || !strings.Contains(routes[3].Error, "300.1.1.1") {
+		t.Fatalf("bad entry = %#v, want its IP error", routes[3])
+	}
+}
+
+func TestPrintParsedRoutesFailsOnAnyError(t *testing.T) {
+	var stdout, stderr strings.Builder
+	if printParsedRoutes(&stdout, &stderr, parseRouteEntries("8080:10.0.0.1:80,udp/53:10.0.0.1:53;compress=backend"), false) {
+		t.Fatal("printParsedRoutes reported success with a UDP route using ;compress=")
+	}
+	if !strings.Contains(stdout.String(), "10.0.0.1:80") || !strings.Contains(stderr.String(), "entry 2") {
+		t.Fatalf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	if !printParsedRoutes(&stdout, &stderr, parseRouteEntries("8080:10.0.0.1:80"), true) {
+		t.Fatal("printParsedRoutes reported failure for a valid route")
+	}
+	if !strings.Contains(stdout.String(), `"valid": true`) || !strings.Contains(stdout.String(), `"local_port": "8080"`) {
+		t.Fatalf("JSON output = %s", stdout.String())
+	}
+}
+
+func TestConfigSettingsFillOnlyFlagsLeftOffTheCommandLine(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	logFile := flags.String("log", "chicha-ip-proxy.log", "")
+	logFormat := flags.String("log-format", "text", "")
+	rotation := flags.Duration("rotation", 24*time.Hour, "")
+	if err := flags.Parse([]string{"-log-format=logfmt"}); err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	content := []byte("tcp: [8080:10.0.0.1:80]\nlog:\n  file: /var/log/proxy.log\n  format: json\nrotation:\n  frequency: 1h\n")
+
+	applied, err := applyConfigSettings(flags, "-", content, nil)
+	if err != nil {
+		t.Fatalf("applyConfigSettings returned error: %v", err)
+	}
+	if *logFile != "/var/log/proxy.log" || *logFormat != "logfmt" || *rotation != time.Hour {
+		t.Fatalf("flags = -log=%s -log-format=%s -rotation=%s; want the file's log and rotation and the command line's format", *logFile, *logFormat, *rotation)
+	}
+	if want := []string{"-log=/var/log/proxy.log", "-rotation=1h"}; !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applied = %v, want %v", applied, want)
+	}
+
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Duration("rotation", 24*time.Hour, "")
+	if _, err := applyConfigSettings(flags, "-", []byte("rotation:\n  frequency: daily\n"), nil); err == nil || !strings.Contains(err.Error(), "config setting for -rotation") {
+		t.Fatalf("applyConfigSettings error = %v, want the flag named", err)
+	}
+}
+
+func TestReloadCommandSignalsTheProcessInThePIDFile(t *testing.T) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	path := filepath.Join(t.TempDir(), "proxy.pid")
+	if err := writePIDFile(path); err != nil {
+		t.Fatalf("writePIDFile returned error: %v", err)
+	}
+	var output strings.Builder
+	if err := runReloadCommand([]string{"-pid-file", path}, &output); err != nil {
+		t.Fatalf("runReloadCommand returned error: %v", err)
+	}
+	select {
+	case <-hangups:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no SIGHUP arrived")
+	}
+	if !strings.Contains(output.String(), "Sent SIGHUP") {
+		t.Fatalf("output = %q", output.String())
+	}
+
+	if err := os.WriteFile(path, []byte("not a pid\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runReloadCommand([]string{"-pid-file", path}, io.Discard); err == nil || !strings.Contains(err.Error(), "holds no process ID") {
+		t.Fatalf("runReloadCommand error = %v, want the bad file named", err)
+	}
+	if err := runReloadCommand(nil, io.Discard); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Fatalf("runReloadCommand without -pid-file error = %v, want the usage", err)
+	}
+}
+
+func TestPrintEffectiveConfigLoadsBackAsAConfigFile(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("routes", "", "")
+	flags.String("admin-token", "", "")
+	flags.Duration("tcp-idle-timeout", 5*time.Minute, "")
+	if err := flags.Parse([]string{"-routes=8080:10.0.0.1:80", "-admin-token=s3cret"}); err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	tcpRoutes, udpRoutes, err := config.ParseForwardRoutes("tcp/8080+8081:10.0.0.1:80;check-send=PING\\r\\n;check-expect=PONG,both/53:10.0.0.2:53")
+	if err != nil {
+		t.Fatalf("ParseForwardRoutes returned error: %v", err)
+	}
+
+	var output strings.Builder
+	if err := printEffectiveConfig(&output, tcpRoutes, udpRoutes, flags); err != nil {
+		t.Fatalf("printEffectiveConfig returned error: %v", err)
+	}
+	loadedTCP, loadedUDP, err := config.ParseFile([]byte(output.String()))
+	if err != nil {
+		t.Fatalf("ParseFile of the dump returned error: %v\n%s", err, output.String())
+	}
+	if !reflect.DeepEqual(loadedTCP, tcpRoutes) || !reflect.DeepEqual(loadedUDP, udpRoutes) {
+		t.Fatalf("dump loaded as tcp %#v udp %#v, want tcp %#v udp %#v", loadedTCP, loadedUDP, tcpRoutes, udpRoutes)
+	}
+	if !strings.Contains(output.String(), `"tcp-idle-timeout": "5m0s"`) || !strings.Contains(output.String(), `"admin-token": "REDACTED"`) {
+		t.Fatalf("dump does not show defaults with secrets masked:\n%s", output.String())
+	}
+	if strings.Contains(output.String(), "s3cret") || strings.Contains(output.String(), `"routes"`) {
+		t.Fatalf("dump shows the token or the route flag:\n%s", output.String())
+	}
+}
+
+func TestCheckListenHostRejectsForeignAddress(t *testing.T) {
+	if err := checkListenHost("127.0.0.1"); err != nil {
+		t.Fatalf("checkListenHost returned error for loopback: %v", err)
+	}
+	// 192.0.2.0/24 is reserved for documentation, so no test host owns it.
+	if err := checkListenHost("192.0.2.1"); err == nil || !strings.Contains(err.Error(), "-listen-addr 192.0.2.1") {
+		t.Fatalf("checkListenHost error = %v, want the unassigned address named", err)
+	}
+}
+
+func TestCheckRouteCountNamesTheCount(t *testing.T) {
+	if err := checkRouteCount(defaultMaxRoutes, defaultMaxRoutes); err != nil {
+		t.Fatalf("checkRouteCount returned error at the cap: %v", err)
+	}
+	err := checkRouteCount(10, 2000)
+	if err == nil || !strings.Contains(err.Error(), "2000 routes") || !strings.Contains(err.Error(), "-max-routes=10") {
+		t.Fatalf("checkRouteCount error = %v, want the count and the cap", err)
+	}
+	if err := checkRouteCount(0, 100000); err != nil {
+		t.Fatalf("checkRouteCount with no cap returned error: %v", err)
+	}
+}
+
+func TestOpenFilesNeededCountsEveryRouteAtCapacity(t *testing.T) {
+	got := openFilesNeeded(2, 1, 1000, false)
+	want := uint64(2*2001 + proxy.MaxUDPSessionsPerRoute + 1 + openFilesOverhead)
+	if got != want {
+		t.Fatalf("openFilesNeeded = %d, want %d", got, want)
+	}
+	if tarpit := openFilesNeeded(2, 1, 1000, true); tarpit != want+2*proxy.MaxTarpittedConnectionsPerRoute {
+		t.Fatalf("openFilesNeeded with tarpit = %d, want %d", tarpit, want+2*proxy.MaxTarpittedConnectionsPerRoute)
+	}
+}
+
+func TestDescribeBindFailuresPointsOutHalfBoundPorts(t *testing.T) {
+	got := describeBindFailures([]bindFailure{
+		{protocol: "udp", port: "53", err: errors.New("address already in use")},
+		{protocol: "tcp", port: "8080", err: errors.New("permission denied")},
+	}, map[string]bool{"tcp/53": true})
+	want := "udp :53: address already in use (tcp :53 bound fine); tcp :8080: permission denied"
+	if got != want {
+		t.Fatalf("describeBindFailures = %q, want %q", got, want)
+	}
+}
+
+// preBoundFailure holds a TCP port and returns the bind failure a route on that port meets, as startup would record it.
+func preBoundFailure(t *testing.T) (net.Listener, bindFailure) {
+	t.Helper()
+	holder, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { holder.Close() })
+	_, port, _ := net.SplitHostPort(holder.Addr().String())
+	listener, err := proxy.ListenTCP(net.JoinHostPort("127.0.0.1", port), false)
+	if err == nil {
+		listener.Close()
+		t.Fatalf("binding held port %s succeeded", port)
+	}
+	return holder, bindFailure{protocol: "tcp", port: port, err: err}
+}
+
+func TestOnBindErrorFatalNamesTheFailedPort(t *testing.T) {
+	_, failure := preBoundFailure(t)
+	logger := log.New(io.Discard, "", 0)
+	_, err := handleBindFailures(bindErrorFatal, []bindFailure{failure}, nil, func(bindFailure) {
+		t.Fatal("fatal policy retried a bind")
+	}, logger)
+	if err == nil || !strings.Contains(err.Error(), "tcp :"+failure.port) {
+		t.Fatalf("handleBindFailures error = %v, want one naming tcp :%s", err, failure.port)
+	}
+}
+
+func TestOnBindErrorSkipDropsBothHalvesOfTheFailedPort(t *testing.T) {
+	_, failure := preBoundFailure(t)
+	var logged strings.Builder
+	skipped, err := handleBindFailures(bindErrorSkip, []bindFailure{failure}, map[string]bool{"udp/" + failure.port: true}, func(bindFailure) {
+		t.Fatal("skip policy retried a bind")
+	}, log.New(&logged, "", 0))
+	if err != nil {
+		t.Fatalf("handleBindFailures returned error: %v", err)
+	}
+	if !strings.Contains(logged.String(), "WARNING: skipping routes") {
+		t.Fatalf("log = %q, want a skip warning", logged.String())
+	}
+
+	routes := []config.Route{{LocalPort: failure.port}, {LocalPort: "9"}}
+	var closed []int
+	kept := dropSkippedPorts([]int{0, 1}, routes, skipped, func(i int) { closed = append(closed, i) })
+	if !reflect.DeepEqual(kept, []int{1}) || !reflect.DeepEqual(closed, []int{0}) {
+		t.Fatalf("dropSkippedPorts kept %v and closed %v, want [1] and [0]", kept, closed)
+	}
+}
+
+func TestOnBindErrorRetryBindsOnceThePortIsFree(t *testing.T) {
+	holder, failure := preBoundFailure(t)
+	var retried []bindFailure
+	skipped, err := handleBindFailures(bindErrorRetry, []bindFailure{failure}, nil, func(f bindFailure) {
+		retried = append(retried, f)
+	}, log.New(io.Discard, "", 0))
+	if err != nil || skipped != nil {
+		t.Fatalf("handleBindFailures = %v, %v; want nothing skipped and no error", skipped, err)
+	}
+	if len(retried) != 1 || retried[0].port != failure.port {
+		t.Fatalf("retried %v, want port %s", retried, failure.port)
+	}
+
+	var logged strings.Builder
+	logger := log.New(&logged, "", 0)
+	addr := net.JoinHostPort("127.0.0.1", failure.port)
+	attempts := 0
+	bound := make(chan net.Listener, 1)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		retryBind("tcp :"+failure.port, func() (func(), error) {
+			attempts++
+			if attempts == 2 {
+				holder.Close()
+			}
+			listener, err := proxy.ListenTCP(addr, false)
+			if err != nil {
+				return nil, err
+			}
+			bound <- listener
+			return func() { listener.Close() }, nil
+		}, time.Millisecond, 4*time.Millisecond, stop, logger)
+	}()
+
+	var listener net.Listener
+	select {
+	case listener = <-bound:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retryBind never bound the freed port")
+	}
+	close(stop)
+	<-done
+	if attempts != 2 {
+		t.Fatalf("attempts = %d, want 2", attempts)
+	}
+	if _, err := listener.Accept(); err == nil {
+		t.Fatal("listener still open after stop")
+	}
+	lines := strings.Split(strings.TrimSpace(logged.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "Retry 1 to bind tcp :"+failure.port) || lines[1] != "Bound tcp :"+failure.port+" on retry 2" {
+		t.Fatalf("log = %q, want one failed retry, then the bind", logged.String())
+	}
+}
+
+func TestRetryBindStopsWithoutBinding(t *testing.T) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		retryBind("tcp :1", func() (func(), error) {
+			return nil, errors.New("address already in use")
+		}, time.Millisecond, time.Millisecond, stop, log.New(io.Discard, "", 0))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retryBind kept retrying after stop")
+	}
+}
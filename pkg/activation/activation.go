@@ -0,0 +1,137 @@
+// Package activation picks up sockets that systemd opened on the proxy's behalf.
+// Matching sockets to routes by name lets systemd bind privileged ports while the proxy runs unprivileged.
+package activation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first descriptor systemd passes, right after stdin, stdout and stderr.
+const listenFDsStart = 3
+
+// Sockets holds the named descriptors passed through LISTEN_FDS until routes claim them.
+// It is used from main's startup goroutine only, so the map needs no synchronization.
+type Sockets struct {
+	files map[string]*os.File
+}
+
+// RouteName is the FileDescriptorName a .socket unit must use for a route.
+// Naming by protocol and local port keeps the pairing stable no matter in which order systemd passes descriptors.
+func RouteName(protocol, localPort string) string {
+	return protocol + "-" + localPort
+}
+
+// FromEnvironment collects the sockets systemd passed to this process.
+// It returns an empty set when the process was not socket activated, and clears the variables so children do not inherit them.
+func FromEnvironment() (*Sockets, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	descriptors, err := parseListenEnv(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"), os.Getpid())
+	if err != nil {
+		return nil, err
+	}
+
+	sockets := &Sockets{files: make(map[string]*os.File, len(descriptors))}
+	for name, fd := range descriptors {
+		sockets.files[name] = os.NewFile(uintptr(fd), name)
+	}
+	return sockets, nil
+}
+
+// parseListenEnv maps descriptor names to numbers following the sd_listen_fds protocol.
+// Names are required because fd order depends on unit file order, which is easy to change by accident.
+func parseListenEnv(pidValue, fdsValue, namesValue string, selfPID int) (map[string]int, error) {
+	descriptors := make(map[string]int)
+	if fdsValue == "" {
+		return descriptors, nil
+	}
+
+	pid, err := strconv.Atoi(pidValue)
+	if err != nil || pid != selfPID {
+		// The variables belong to another process, for example a shell that exec'd the proxy manually.
+		return descriptors, nil
+	}
+
+	count, err := strconv.Atoi(fdsValue)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS '%s'", fdsValue)
+	}
+	if count == 0 {
+		return descriptors, nil
+	}
+
+	names := strings.Split(namesValue, ":")
+	if namesValue == "" || len(names) != count {
+		return nil, fmt.Errorf("LISTEN_FDNAMES must name all %d sockets (set FileDescriptorName= in each .socket unit)", count)
+	}
+
+	for i, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("socket %d has an empty FileDescriptorName", i)
+		}
+		if _, exists := descriptors[name]; exists {
+			return nil, fmt.Errorf("duplicate FileDescriptorName '%s'", name)
+		}
+		descriptors[name] = listenFDsStart + i
+	}
+	return descriptors, nil
+}
+
+// TCPListener claims the stream socket passed for a TCP route's local port.
+// The boolean is false when systemd passed no socket for the route, so the caller binds the port itself.
+func (sockets *Sockets) TCPListener(localPort string) (net.Listener, bool, error) {
+	file, ok := sockets.claim(RouteName("tcp", localPort))
+	if !ok {
+		return nil, false, nil
+	}
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, true, fmt.Errorf("socket %s is not a TCP listener: %v", file.Name(), err)
+	}
+	return listener, true, nil
+}
+
+// UDPConn claims the datagram socket passed for a UDP route's local port.
+func (sockets *Sockets) UDPConn(localPort string) (net.PacketConn, bool, error) {
+	file, ok := sockets.claim(RouteName("udp", localPort))
+	if !ok {
+		return nil, false, nil
+	}
+	defer file.Close()
+
+	conn, err := net.FilePacketConn(file)
+	if err != nil {
+		return nil, true, fmt.Errorf("socket %s is not a UDP socket: %v", file.Name(), err)
+	}
+	return conn, true, nil
+}
+
+// Unclaimed lists passed sockets no route asked for, which usually means a .socket unit and the routes drifted apart.
+func (sockets *Sockets) Unclaimed() []string {
+	names := make([]string, 0, len(sockets.files))
+	for name := range sockets.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (sockets *Sockets) claim(name string) (*os.File, bool) {
+	if sockets == nil {
+		return nil, false
+	}
+	file, ok := sockets.files[name]
+	if ok {
+		delete(sockets.files, name)
+	}
+	return file, ok
+}
@@ -0,0 +1,45 @@
+package activation
+
+import "testing"
+
+func TestParseListenEnvMapsNamesToDescriptors(t *testing.T) {
+	descriptors, err := parseListenEnv("42", "2", "udp-53:tcp-443", 42)
+	if err != nil {
+		t.Fatalf("parseListenEnv returned error: %v", err)
+	}
+	if descriptors["udp-53"] != 3 || descriptors["tcp-443"] != 4 {
+		t.Fatalf("descriptors = %#v, want udp-53=3 and tcp-443=4", descriptors)
+	}
+}
+
+func TestParseListenEnvIgnoresOtherProcesses(t *testing.T) {
+	descriptors, err := parseListenEnv("41", "1", "tcp-443", 42)
+	if err != nil {
+		t.Fatalf("parseListenEnv returned error: %v", err)
+	}
+	if len(descriptors) != 0 {
+		t.Fatalf("descriptors = %#v, want none for another PID", descriptors)
+	}
+}
+
+func TestParseListenEnvRejectsMissingOrDuplicateNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		fds   string
+		names string
+	}{
+		{name: "no names", fds: "1", names: ""},
+		{name: "count mismatch", fds: "2", names: "tcp-443"},
+		{name: "duplicate", fds: "2", names: "tcp-443:tcp-443"},
+		{name: "empty name", fds: "2", names: "tcp-443:"},
+		{name: "bad count", fds: "x", names: "tcp-443"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseListenEnv("42", tt.fds, tt.names, 42); err == nil {
+				t.Fatalf("parseListenEnv(%q, %q) accepted invalid input", tt.fds, tt.names)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Type=notify units wait for the service to say it is ready, so units ordered after the proxy start only once its routes serve.
+// The sd_notify protocol is one datagram per message on the socket named by NOTIFY_SOCKET, which needs no cgo or libsystemd.
+package activation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notifier sends state changes to systemd; a nil Notifier means the process is not run by a Type=notify unit and ignores them.
+type Notifier struct {
+	socket string
+}
+
+// NotifierFromEnvironment returns a Notifier for NOTIFY_SOCKET, or nil when systemd did not set it.
+// The variable is cleared, like the LISTEN_ ones, so children never report on the proxy's behalf.
+func NotifierFromEnvironment() *Notifier {
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	return newNotifier(os.Getenv("NOTIFY_SOCKET"))
+}
+
+func newNotifier(socket string) *Notifier {
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the Linux abstract namespace, which Go spells with a NUL byte.
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	return &Notifier{socket: socket}
+}
+
+// Notify sends one message such as READY=1; several assignments go on separate lines.
+func (notifier *Notifier) Notify(state string) error {
+	if notifier == nil {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: notifier.socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to reach systemd notify socket: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd of %s: %v", state, err)
+	}
+	return nil
+}
+
+// Ready tells systemd that startup finished, which releases the units ordered after this one.
+func (notifier *Notifier) Ready() error {
+	return notifier.Notify("READY=1\nSTATUS=Forwarding")
+}
+
+// Stopping tells systemd the proxy is draining on purpose, so the time until exit is not mistaken for a hang.
+func (notifier *Notifier) Stopping() error {
+	return notifier.Notify("STOPPING=1\nSTATUS=Shutting down")
+}
+
+// WatchdogInterval returns how often systemd expects WATCHDOG=1 when the unit sets WatchdogSec=, or zero without a watchdog.
+func WatchdogInterval() time.Duration {
+	return parseWatchdogEnv(os.Getenv("WATCHDOG_USEC"), os.Getenv("WATCHDOG_PID"), os.Getpid())
+}
+
+// parseWatchdogEnv follows sd_watchdog_enabled: the timeout applies only when WATCHDOG_PID is unset or names this process.
+func parseWatchdogEnv(usecValue, pidValue string, selfPID int) time.Duration {
+	usec, err := strconv.ParseInt(usecValue, 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pidValue != "" {
+		if pid, err := strconv.Atoi(pidValue); err != nil || pid != selfPID {
+			return 0
+		}
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog pings systemd at half the watchdog timeout, as sd_watchdog_enabled recommends, until stop closes.
+// A process whose goroutines no longer get scheduled misses the pings, and systemd restarts it.
+// report sees the first failure of a run of failed pings only, so a broken socket does not flood the log.
+func (notifier *Notifier) RunWatchdog(timeout time.Duration, stop <-chan struct{}, report func(error)) {
+	if notifier == nil || timeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	failing := false
+	for {
+		err := notifier.Notify("WATCHDOG=1")
+		if err != nil && !failing {
+			report(err)
+		}
+		failing = err != nil
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package activation
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// listenNotifySocket stands in for systemd's notify socket and returns the messages it receives.
+func listenNotifySocket(t *testing.T) (*Notifier, <-chan string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets are not available: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	messages := make(chan string, 16)
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				return
+			}
+			messages <- string(buffer[:n])
+		}
+	}()
+	return newNotifier(path), messages
+}
+
+func waitForNotifyMessage(t *testing.T, messages <-chan string) string {
+	t.Helper()
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(2 * time.Second):
+		t.Fatal("the notify socket received nothing")
+		return ""
+	}
+}
+
+func TestNotifierSendsReadyAndStopping(t *testing.T) {
+	notifier, messages := listenNotifySocket(t)
+
+	if err := notifier.Ready(); err != nil {
+		t.Fatalf("Ready returned error: %v", err)
+	}
+	if message := waitForNotifyMessage(t, messages); message != "READY=1\nSTATUS=Forwarding" {
+		t.Fatalf("ready message = %q", message)
+	}
+	if err := notifier.Stopping(); err != nil {
+		t.Fatalf("Stopping returned error: %v", err)
+	}
+	if message := waitForNotifyMessage(t, messages); message != "STOPPING=1\nSTATUS=Shutting down" {
+		t.Fatalf("stopping message = %q", message)
+	}
+}
+
+func TestNotifierPingsWatchdogUntilStopped(t *testing.T) {
+	notifier, messages := listenNotifySocket(t)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		notifier.RunWatchdog(20*time.Millisecond, stop, func(err error) { t.Errorf("watchdog ping failed: %v", err) })
+		close(done)
+	}()
+
+	for i := 0; i < 3; i++ {
+		if message := waitForNotifyMessage(t, messages); message != "WATCHDOG=1" {
+			t.Fatalf("watchdog message = %q", message)
+		}
+	}
+	close(stop)
+	<-done
+}
+
+func TestNotifierReportsOnlyTheFirstOfRepeatedFailures(t *testing.T) {
+	notifier := newNotifier(filepath.Join(t.TempDir(), "missing"))
+	reports := make(chan error, 16)
+	stop := make(chan struct{})
+	go notifier.RunWatchdog(10*time.Millisecond, stop, func(err error) { reports <- err })
+	defer close(stop)
+
+	if err := <-reports; err == nil {
+		t.Fatal("report received a nil error")
+	}
+	select {
+	case err := <-reports:
+		t.Fatalf("second report of the same outage: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNilNotifierIgnoresMessages(t *testing.T) {
+	var notifier *Notifier
+	if err := notifier.Ready(); err != nil {
+		t.Fatalf("nil Notifier returned %v", err)
+	}
+	if newNotifier("") != nil {
+		t.Fatal("an empty NOTIFY_SOCKET produced a Notifier")
+	}
+	if abstract := newNotifier("@/org/freedesktop/systemd1/notify"); abstract.socket != "\x00/org/freedesktop/systemd1/notify" {
+		t.Fatalf("abstract socket = %q", abstract.socket)
+	}
+}
+
+func TestParseWatchdogEnv(t *testing.T) {
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"30000000", "", 30 * time.Second},
+		{"30000000", "42", 30 * time.Second},
+		{"30000000", "41", 0},
+		{"", "", 0},
+		{"soon", "", 0},
+		{"-5", "", 0},
+	}
+	for _, test := range tests {
+		if got := parseWatchdogEnv(test.usec, test.pid, 42); got != test.want {
+			t.Fatalf("parseWatchdogEnv(%q, %q) = %v, want %v", test.usec, test.pid, got, test.want)
+		}
+	}
+}
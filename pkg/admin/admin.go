@@ -0,0 +1,188 @@
+// Package admin serves the optional HTTP control plane for a running proxy.
+// Keeping HTTP handling here leaves the proxy package focused on forwarding.
+package admin
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+	"github.com/matveynator/chicha-ip-proxy/pkg/proxy"
+)
+
+const (
+	connectionsPath = "/connections"
+	healthzPath     = "/healthz"
+	statusPath      = "/status"
+)
+
+// statusResponse is the /status body: how many flows are open and what state each route is in.
+type statusResponse struct {
+	Connections int           `json:"connections"`
+	Routes      []routeStatus `json:"routes"`
+}
+
+// routeStatus adds a route's counters to its state, named like the /stats.csv columns, so one request feeds the dashboard.
+type routeStatus struct {
+	proxy.RouteStatus
+	Active   int64  `json:"active_connections"`
+	Opened   uint64 `json:"total_connections"`
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+	Drops    uint64 `json:"drops"`
+}
+
+// NewHandler exposes live connections for listing and surgical termination, plus a readiness probe and route status.
+// GET /connections lists flows, optionally of one ?route=ID, DELETE /connections/{id} force-closes one of them and
+// DELETE /connections?route=ID every flow of a route, GET /healthz answers 503 until readiness is met,
+// GET /status reports each route's listener, target in use, and last upstream error, and GET /stats.csv snapshots the route counters.
+func NewHandler(registry *proxy.Registry, readiness *proxy.Readiness, status *proxy.StatusBoard, stats *metrics.Set, logger *log.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(healthzPath, func(writer http.ResponseWriter, request *http.Request) {
+		if !readiness.Ready() {
+			http.Error(writer, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = writer.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc(connectionsPath, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeJSON(writer, http.StatusOK, routeConnections(registry.List(), request.URL.Query().Get("route")))
+		case http.MethodDelete:
+			closeRouteConnections(writer, request, registry, logger)
+		default:
+			writer.Header().Set("Allow", http.MethodGet+", "+http.MethodDelete)
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc(statusPath, serveStatus(registry, status, stats))
+	mux.HandleFunc(statsCSVPath, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			writer.Header().Set("Allow", http.MethodGet)
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writer.Header().Set("Content-Disposition", `attachment; filename="chicha-ip-proxy-stats.csv"`)
+		if err := writeStatsCSV(writer, stats.Routes(), status.Routes()); err != nil {
+			log.Printf("Failed to write stats CSV: %v", err)
+		}
+	})
+	mux.HandleFunc(connectionsPath+"/", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodDelete {
+			writer.Header().Set("Allow", http.MethodDelete)
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		closeConnection(writer, request, registry, logger)
+	})
+	return mux
+}
+
+// Serve runs the admin HTTP server until it fails.
+// Running it in its own goroutine keeps forwarding independent of the control plane.
+func Serve(addr string, handler http.Handler, logger *log.Logger) {
+	serve("Admin API", addr, handler, logger)
+}
+
+func serve(name, addr string, handler http.Handler, logger *log.Logger) {
+	logger.Printf("%s listening on %s", name, addr)
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		logger.Printf("%s on %s stopped: %v", name, addr, err)
+	}
+}
+
+// serveStatus answers GET /status for both the admin API and the dashboard.
+func serveStatus(registry *proxy.Registry, status *proxy.StatusBoard, stats *metrics.Set) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			writer.Header().Set("Allow", http.MethodGet)
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(writer, http.StatusOK, statusResponse{Connections: len(registry.List()), Routes: statusRoutes(status.Routes(), stats.Routes())})
+	}
+}
+
+// statusRoutes joins each route's state with its counters; a route without counters yet reports zeros, and none is [] rather than null.
+func statusRoutes(statuses []proxy.RouteStatus, counters []metrics.RouteCounters) []routeStatus {
+	byRoute := make(map[string]metrics.RouteCounters, len(counters))
+	for _, route := range counters {
+		byRoute[routeKey(route.Protocol, route.Listen, route.Target)] = route
+	}
+	routes := make([]routeStatus, 0, len(statuses))
+	for _, status := range statuses {
+		route := byRoute[routeKey(status.Protocol, status.Listen, status.Target)]
+		routes = append(routes, routeStatus{
+			RouteStatus: status,
+			Active:      route.Active,
+			Opened:      route.Opened,
+			BytesIn:     route.ClientBytes,
+			BytesOut:    route.ServerBytes,
+			Drops:       route.Drops,
+		})
+	}
+	return routes
+}
+
+// closeConnection terminates one flow and records who asked for it and why.
+func closeConnection(writer http.ResponseWriter, request *http.Request, registry *proxy.Registry, logger *log.Logger) {
+	id := strings.TrimPrefix(request.URL.Path, connectionsPath+"/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(writer, "connection id required", http.StatusNotFound)
+		return
+	}
+
+	info, ok := registry.Close(id)
+	if !ok {
+		http.Error(writer, "unknown connection id", http.StatusNotFound)
+		return
+	}
+
+	reason := request.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "no reason given"
+	}
+	logger.Printf("Admin closed %s connection %s of route %s: %s -> %s (requested by %s: %s)", info.Protocol, info.ID, info.Route, info.Client, info.Target, requester(request), reason)
+	writeJSON(writer, http.StatusOK, info)
+}
+
+// routeConnections keeps the flows of one route ID, or all of them when route is empty; an empty result is [] rather than null.
+func routeConnections(connections []proxy.ConnectionInfo, route string) []proxy.ConnectionInfo {
+	kept := make([]proxy.ConnectionInfo, 0, len(connections))
+	for _, info := range connections {
+		if route == "" || info.Route == route {
+			kept = append(kept, info)
+		}
+	}
+	return kept
+}
+
+// closeRouteConnections terminates every flow of the ?route= ID, so an operator can cut off a route without knowing its flow IDs.
+// A route without flows answers 200 with an empty list, because the result the caller wanted already holds.
+func closeRouteConnections(writer http.ResponseWriter, request *http.Request, registry *proxy.Registry, logger *log.Logger) {
+	route := request.URL.Query().Get("route")
+	if route == "" {
+		http.Error(writer, "route required", http.StatusBadRequest)
+		return
+	}
+	closed := routeConnections(registry.CloseRoute(route), "")
+	reason := request.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "no reason given"
+	}
+	logger.Printf("Admin closed %d connections of route %s (requested by %s: %s)", len(closed), route, requester(request), reason)
+	writeJSON(writer, http.StatusOK, closed)
+}
+
+func writeJSON(writer http.ResponseWriter, status int, payload interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(payload); err != nil {
+		log.Printf("Failed to encode admin response: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+	"github.com/matveynator/chicha-ip-proxy/pkg/proxy"
+)
+
+func TestDeleteConnectionClosesKnownID(t *testing.T) {
+	registry := proxy.NewRegistry()
+	closed := make(chan struct{})
+	id := registry.Register(proxy.ConnectionInfo{
+		Protocol: "tcp",
+		Client:   "198.51.100.7:40000",
+		Target:   "203.0.113.10:80",
+		Started:  time.Now(),
+	}, func() { close(closed) })
+
+	handler := NewHandler(registry, nil, nil, nil, log.New(io.Discard, "", 0))
+	request := httptest.NewRequest(http.MethodDelete, "/connections/"+id+"?reason=abuse", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("DELETE status = %d, want 200", recorder.Code)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close handle was not invoked")
+	}
+	if remaining := registry.List(); len(remaining) != 0 {
+		t.Fatalf("registry still lists %d connections", len(remaining))
+	}
+}
+
+func TestDeleteConnectionReturnsNotFoundForUnknownID(t *testing.T) {
+	handler := NewHandler(proxy.NewRegistry(), nil, nil, nil, log.New(io.Discard, "", 0))
+	request := httptest.NewRequest(http.MethodDelete, "/connections/tcp-404", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("DELETE status = %d, want 404", recorder.Code)
+	}
+}
+
+func TestDeleteRouteConnectionsClosesOnlyThatRoute(t *testing.T) {
+	registry := proxy.NewRegistry()
+	closed := make(chan string, 3)
+	for _, info := range []proxy.ConnectionInfo{
+		{Route: "dns", Protocol: "udp", Client: "198.51.100.7:5353"},
+		{Route: "dns", Protocol: "tcp", Client: "198.51.100.8:40000"},
+		{Route: "tcp/8080", Protocol: "tcp", Client: "198.51.100.9:40000"},
+	} {
+		info.Started = time.Now()
+		client := info.Client
+		registry.Register(info, func() { closed <- client })
+	}
+	handler := NewHandler(registry, nil, nil, nil, log.New(io.Discard, "", 0))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/connections?route=dns", nil))
+	var listed []proxy.ConnectionInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &listed); err != nil || len(listed) != 2 {
+		t.Fatalf("GET /connections?route=dns = %s, %v, want the two dns flows", recorder.Body.String(), err)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/connections?route=dns&reason=abuse", nil))
+	var killed []proxy.ConnectionInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &killed); recorder.Code != http.StatusOK || err != nil || len(killed) != 2 {
+		t.Fatalf("DELETE /connections?route=dns = %d %s", recorder.Code, recorder.Body.String())
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case client := <-closed:
+			if client == "198.51.100.9:40000" {
+				t.Fatal("a flow of another route was closed")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("close handle was not invoked")
+		}
+	}
+	if remaining := registry.List(); len(remaining) != 1 || remaining[0].Route != "tcp/8080" {
+		t.Fatalf("registry lists %+v, want only the tcp/8080 flow", remaining)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/connections", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("DELETE /connections without a route = %d, want 400", recorder.Code)
+	}
+}
+
+func TestListConnectionsReturnsRegisteredFlows(t *testing.T) {
+	registry := proxy.NewRegistry()
+	registry.Register(proxy.ConnectionInfo{Protocol: "udp", Client: "198.51.100.7:5353", Started: time.Now()}, func() {})
+
+	handler := NewHandler(registry, nil, nil, nil, log.New(io.Discard, "", 0))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/connections", nil))
+
+	var connections []proxy.ConnectionInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &connections); err != nil {
+		t.Fatalf("json.Unmarshal returned error: %v", err)
+	}
+	if len(connections) != 1 || connections[0].Protocol != "udp" || connections[0].ID == "" {
+		t.Fatalf("connections = %#v", connections)
+	}
+}
+
+func TestHealthzReportsReadinessWithoutToken(t *testing.T) {
+	readiness := proxy.NewReadiness(0, 0, log.New(io.Discard, "", 0))
+	handler := Protect(NewHandler(proxy.NewRegistry(), readiness, nil, nil, log.New(io.Discard, "", 0)), "secret")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("GET /healthz before bind status = %d, want 503", recorder.Code)
+	}
+
+	readiness.Bound()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if recorder.Code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GET /healthz after bind status = %d, want 200", recorder.Code)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/connections", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /connections without token status = %d, want 401", recorder.Code)
+	}
+}
+
+func TestStatusReportsRoutesAndConnectionCount(t *testing.T) {
+	registry := proxy.NewRegistry()
+	registry.Register(proxy.ConnectionInfo{Protocol: "tcp", Client: "198.51.100.7:40000", Started: time.Now()}, func() {})
+
+	handler := NewHandler(registry, nil, proxy.NewStatusBoard(), nil, log.New(io.Discard, "", 0))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	var status statusResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("json.Unmarshal returned error: %v", err)
+	}
+	if status.Connections != 1 || status.Routes == nil {
+		t.Fatalf("status = %#v, want one connection and an empty route list", status)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/status", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /status status = %d, want 405", recorder.Code)
+	}
+}
+
+func TestStatsCSVListsRouteCounters(t *testing.T) {
+	counters := []metrics.RouteCounters{
+		{Route: "dns", Protocol: "udp", Listen: "[::]:53", Target: "8.8.8.8:53", ClientBytes: 60, ServerBytes: 240, Opened: 9, Active: 2},
+		{Route: "tcp/8443", Protocol: "tcp", Listen: "[::]:8443", Target: "10.0.0.1:443", Opened: 1},
+		{Route: "tcp/8080", Protocol: "tcp", Listen: "[::]:8080", Target: "10.0.0.1:80", ClientBytes: 100, ServerBytes: 900, Opened: 5, Active: 1, Drops: 3},
+	}
+	statuses := []proxy.RouteStatus{{Protocol: "tcp", Listen: ":8080", Target: "10.0.0.1:80", CurrentTarget: "10.0.0.2:80"}}
+
+	var out strings.Builder
+	if err := writeStatsCSV(&out, counters, statuses); err != nil {
+		t.Fatalf("writeStatsCSV returned error: %v", err)
+	}
+	want := "local_port,protocol,remote,active_connections,total_connections,bytes_in,bytes_out,drops,current_upstream,route\n" +
+		"8080,tcp,10.0.0.1:80,1,5,100,900,3,10.0.0.2:80,tcp/8080\n" +
+		"8443,tcp,10.0.0.1:443,0,1,0,0,0,10.0.0.1:443,tcp/8443\n" +
+		"53,udp,8.8.8.8:53,2,9,60,240,0,8.8.8.8:53,dns\n"
+	if out.String() != want {
+		t.Fatalf("stats CSV =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestStatsCSVEndpointServesMetrics(t *testing.T) {
+	set := metrics.NewSet("")
+	set.Route("tcp/8080", "tcp", "127.0.0.1:8080", "203.0.113.10:80").AddBytes("client", 42)
+
+	handler := NewHandler(proxy.NewRegistry(), nil, nil, set, log.New(io.Discard, "", 0))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stats.csv", nil))
+
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("GET /stats.csv = %d %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(recorder.Body.String(), "\n8080,tcp,203.0.113.10:80,0,0,42,0,0,203.0.113.10:80,tcp/8080\n") {
+		t.Fatalf("GET /stats.csv body = %q", recorder.Body.String())
+	}
+}
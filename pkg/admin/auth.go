@@ -0,0 +1,86 @@
+// Access control for the admin endpoints lives beside the handlers it protects.
+// The endpoints expose internals and can close traffic, so they default to loopback and a shared token.
+package admin
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ResolveListenAddr turns the -admin-addr value into a bind address.
+// A bare port binds loopback, and any non-loopback bind needs an explicit expose opt-in.
+func ResolveListenAddr(addr string, expose bool) (string, error) {
+	trimmed := strings.TrimSpace(addr)
+	if trimmed == "" {
+		return "", fmt.Errorf("admin address cannot be empty")
+	}
+	if !strings.Contains(trimmed, ":") {
+		trimmed = ":" + trimmed
+	}
+
+	host, port, err := net.SplitHostPort(trimmed)
+	if err != nil {
+		return "", fmt.Errorf("invalid admin address '%s': %v", addr, err)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	resolved := net.JoinHostPort(host, port)
+	if isLoopbackHost(host) || expose {
+		return resolved, nil
+	}
+	return "", fmt.Errorf("admin address '%s' is not loopback; pass -admin-expose to serve it externally", resolved)
+}
+
+// Protect rejects requests that do not carry the admin token.
+// The token is accepted as a Bearer credential or as the basic auth password so both scrapers and curl -u work.
+// /healthz stays open because orchestrator probes rarely carry credentials and it reveals nothing but readiness.
+func Protect(next http.Handler, token string) http.Handler {
+	if token == "" {
+		return next
+	}
+	expected := []byte(token)
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != healthzPath && !tokenMatches(request, expected) {
+			writer.Header().Set("WWW-Authenticate", `Basic realm="chicha-ip-proxy admin"`)
+			http.Error(writer, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func tokenMatches(request *http.Request, expected []byte) bool {
+	presented := ""
+	if header := request.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		presented = strings.TrimPrefix(header, "Bearer ")
+	} else if _, password, ok := request.BasicAuth(); ok {
+		presented = password
+	}
+	if presented == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(presented), expected) == 1
+}
+
+// requester names the caller in audit log lines, preferring the basic auth user when one was sent.
+func requester(request *http.Request) string {
+	if user, _, ok := request.BasicAuth(); ok && user != "" {
+		return user + "@" + request.RemoteAddr
+	}
+	return request.RemoteAddr
+}
+
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	addr, err := netip.ParseAddr(strings.Trim(host, "[]"))
+	return err == nil && addr.IsLoopback()
+}
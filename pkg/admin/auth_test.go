@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveListenAddrDefaultsToLoopback(t *testing.T) {
+	tests := []struct {
+		name   string
+		addr   string
+		expose bool
+		want   string
+		ok     bool
+	}{
+		{name: "bare port", addr: "9090", want: "127.0.0.1:9090", ok: true},
+		{name: "empty host", addr: ":9090", want: "127.0.0.1:9090", ok: true},
+		{name: "IPv6 loopback", addr: "[::1]:9090", want: "[::1]:9090", ok: true},
+		{name: "public without expose", addr: "0.0.0.0:9090", ok: false},
+		{name: "public with expose", addr: "0.0.0.0:9090", expose: true, want: "0.0.0.0:9090", ok: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ResolveListenAddr(test.addr, test.expose)
+			if test.ok && err != nil {
+				t.Fatalf("ResolveListenAddr returned error: %v", err)
+			}
+			if !test.ok && err == nil {
+				t.Fatalf("ResolveListenAddr(%q) accepted a non-loopback address", test.addr)
+			}
+			if got != test.want {
+				t.Fatalf("ResolveListenAddr(%q) = %q, want %q", test.addr, got, test.want)
+			}
+		})
+	}
+}
+
+func TestProtectRequiresToken(t *testing.T) {
+	handler := Protect(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNoContent)
+	}), "s3cret")
+
+	tests := []struct {
+		name      string
+		authorize func(*http.Request)
+		want      int
+	}{
+		{name: "missing", authorize: func(*http.Request) {}, want: http.StatusUnauthorized},
+		{name: "wrong bearer", authorize: func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, want: http.StatusUnauthorized},
+		{name: "bearer", authorize: func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, want: http.StatusNoContent},
+		{name: "basic auth", authorize: func(r *http.Request) { r.SetBasicAuth("ops", "s3cret") }, want: http.StatusNoContent},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/connections", nil)
+			test.authorize(request)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.want {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// The dashboard is one embedded HTML page that polls /status, for a glance at the routes without Grafana.
+// It loads nothing from outside the binary, so it works on air-gapped hosts.
+package admin
+
+import (
+	_ "embed"
+	"log"
+	"net/http"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+	"github.com/matveynator/chicha-ip-proxy/pkg/proxy"
+)
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// dashboardPolicy keeps the page self-contained: its inline script and style run, and it may only fetch from its own origin.
+const dashboardPolicy = "default-src 'none'; connect-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'"
+
+// NewDashboardHandler serves the dashboard page at / and the /status JSON it refreshes from.
+// It is read-only: the endpoints that close connections stay on the admin API.
+func NewDashboardHandler(registry *proxy.Registry, status *proxy.StatusBoard, stats *metrics.Set) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(statusPath, serveStatus(registry, status, stats))
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/" {
+			http.NotFound(writer, request)
+			return
+		}
+		if request.Method != http.MethodGet && request.Method != http.MethodHead {
+			writer.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writer.Header().Set("Content-Security-Policy", dashboardPolicy)
+		writer.Header().Set("Cache-Control", "no-store")
+		_, _ = writer.Write(dashboardPage)
+	})
+	return mux
+}
+
+// ServeDashboard runs the dashboard HTTP server until it fails, apart from the admin API so either can be left off.
+func ServeDashboard(addr string, handler http.Handler, logger *log.Logger) {
+	serve("Dashboard", addr, handler, logger)
+}
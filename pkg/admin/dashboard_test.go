@@ -0,0 +1,75 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+	"github.com/matveynator/chicha-ip-proxy/pkg/proxy"
+)
+
+func TestDashboardServesASelfContainedPage(t *testing.T) {
+	handler := Protect(NewDashboardHandler(proxy.NewRegistry(), nil, nil), "secret")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("GET / without token status = %d, want 401", recorder.Code)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.SetBasicAuth("ops", "secret")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	page := recorder.Body.String()
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("GET / = %d %q, want the HTML page", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(page, `<noscript>`) || !strings.Contains(page, `<a href="status">raw status JSON</a>`) {
+		t.Fatal("page has no raw status link for browsers without JavaScript")
+	}
+	// Air-gapped hosts cannot reach a CDN, so the page must not reference anything outside the binary.
+	for _, external := range []string{"http://", "https://", "//cdn", "src="} {
+		if strings.Contains(page, external) {
+			t.Fatalf("page references %q", external)
+		}
+	}
+	if policy := recorder.Header().Get("Content-Security-Policy"); !strings.Contains(policy, "default-src 'none'") {
+		t.Fatalf("Content-Security-Policy = %q", policy)
+	}
+
+	for _, path := range []string{"/connections", "/stats.csv", "/missing"} {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.SetBasicAuth("ops", "secret")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusNotFound {
+			t.Fatalf("GET %s on the dashboard = %d, want 404", path, recorder.Code)
+		}
+	}
+}
+
+func TestStatusJoinsRouteCounters(t *testing.T) {
+	statuses := []proxy.RouteStatus{
+		{Route: "tcp/8080", Protocol: "tcp", Listen: "[::]:8080", Target: "10.0.0.1:80", ListenerUp: true},
+		{Route: "udp/53", Protocol: "udp", Listen: ":53", Target: "8.8.8.8:53"},
+	}
+	counters := []metrics.RouteCounters{
+		{Route: "tcp/8080", Protocol: "tcp", Listen: ":8080", Target: "10.0.0.1:80", ClientBytes: 100, ServerBytes: 900, Opened: 5, Active: 1, Drops: 3},
+	}
+
+	encoded, err := json.Marshal(statusRoutes(statuses, counters))
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	want := `[{"route":"tcp/8080","protocol":"tcp","listen":"[::]:8080","target":"10.0.0.1:80","listener_up":true,"current_target":"","maintenance":false,` +
+		`"active_connections":1,"total_connections":5,"bytes_in":100,"bytes_out":900,"drops":3},` +
+		`{"route":"udp/53","protocol":"udp","listen":":53","target":"8.8.8.8:53","listener_up":false,"current_target":"","maintenance":false,` +
+		`"active_connections":0,"total_connections":0,"bytes_in":0,"bytes_out":0,"drops":0}]`
+	if string(encoded) != want {
+		t.Fatalf("status routes =\n%s\nwant\n%s", encoded, want)
+	}
+}
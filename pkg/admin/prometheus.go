@@ -0,0 +1,42 @@
+// The metrics endpoint lets Prometheus scrape the per-route counters -metrics-file writes, one target per proxy.
+// It serves nothing else, so it can face a monitoring network while the admin API stays on loopback.
+package admin
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+)
+
+const metricsPath = "/metrics"
+
+// prometheusContentType names version 0.0.4 of the text exposition format, which every Prometheus release reads.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// NewMetricsHandler serves the route counters at /metrics in the Prometheus text format.
+func NewMetricsHandler(stats *metrics.Set) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet && request.Method != http.MethodHead {
+			writer.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// Rendering into a buffer first keeps a failed render from reaching the scraper as a truncated, valid-looking page.
+		var body bytes.Buffer
+		if err := stats.WriteText(&body); err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", prometheusContentType)
+		_, _ = writer.Write(body.Bytes())
+	})
+	return mux
+}
+
+// ServeMetrics runs the metrics HTTP server until it fails, apart from the admin API so scrapers reach nothing else.
+func ServeMetrics(addr string, handler http.Handler, logger *log.Logger) {
+	serve("Metrics endpoint", addr, handler, logger)
+}
@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+)
+
+func TestMetricsEndpointServesPrometheusText(t *testing.T) {
+	stats := metrics.NewSet("")
+	route := stats.Route("udp/53", "udp", ":53", "203.0.113.20:53")
+	route.Opened()
+	route.AddBytes("client", 64)
+	route.DialFailed()
+	handler := Protect(NewMetricsHandler(stats), "secret")
+
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != prometheusContentType {
+		t.Fatalf("GET /metrics = %d %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	labels := `route="udp/53",protocol="udp",listen=":53",target="203.0.113.20:53"`
+	for _, want := range []string{
+		"chicha_ip_proxy_flows_active{" + labels + "} 1\n",
+		"chicha_ip_proxy_bytes_total{" + labels + `,sender="client"} 64` + "\n",
+		"chicha_ip_proxy_dial_errors_total{" + labels + "} 1\n",
+	} {
+		if !strings.Contains(recorder.Body.String(), want) {
+			t.Fatalf("GET /metrics is missing %q:\n%s", want, recorder.Body.String())
+		}
+	}
+
+	for _, path := range []string{"/status", "/connections"} {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Authorization", "Bearer secret")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusNotFound {
+			t.Fatalf("GET %s on the metrics endpoint = %d, want 404", path, recorder.Code)
+		}
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /metrics without token = %d, want 401", recorder.Code)
+	}
+}
@@ -0,0 +1,73 @@
+// The stats CSV is a route table snapshot for capacity reviews in a spreadsheet, built from the same counters as the metrics file.
+// Its columns only ever grow at the end, so spreadsheets that reference them by position keep working.
+package admin
+
+import (
+	"encoding/csv"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+	"github.com/matveynator/chicha-ip-proxy/pkg/proxy"
+)
+
+const statsCSVPath = "/stats.csv"
+
+// statsCSVHeader names the columns of GET /stats.csv; bytes_in came from clients and bytes_out went back to them.
+var statsCSVHeader = []string{"local_port", "protocol", "remote", "active_connections", "total_connections", "bytes_in", "bytes_out", "drops", "current_upstream", "route"}
+
+// writeStatsCSV writes one row per route, ordered by protocol and local port.
+// current_upstream comes from the route status and falls back to the configured remote when status is not tracked.
+func writeStatsCSV(w io.Writer, counters []metrics.RouteCounters, statuses []proxy.RouteStatus) error {
+	upstreams := make(map[string]string, len(statuses))
+	for _, status := range statuses {
+		upstreams[routeKey(status.Protocol, status.Listen, status.Target)] = status.CurrentTarget
+	}
+
+	rows := make([][]string, 0, len(counters))
+	for _, route := range counters {
+		port := localPort(route.Listen)
+		upstream, ok := upstreams[routeKey(route.Protocol, route.Listen, route.Target)]
+		if !ok {
+			upstream = route.Target
+		}
+		rows = append(rows, []string{
+			port, route.Protocol, route.Target,
+			strconv.FormatInt(route.Active, 10), strconv.FormatUint(route.Opened, 10),
+			strconv.FormatUint(route.ClientBytes, 10), strconv.FormatUint(route.ServerBytes, 10),
+			strconv.FormatUint(route.Drops, 10), upstream, route.Route,
+		})
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i][1] != rows[j][1] {
+			return rows[i][1] < rows[j][1]
+		}
+		left, _ := strconv.Atoi(rows[i][0])
+		right, _ := strconv.Atoi(rows[j][0])
+		return left < right
+	})
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(statsCSVHeader); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// routeKey matches a route's counters to its status; the port, not the full listen address, names it, as on the status board.
+func routeKey(protocol, listen, target string) string {
+	return protocol + " " + localPort(listen) + " " + target
+}
+
+// localPort takes the port out of a listen address, keeping the address itself if it has none.
+func localPort(listen string) string {
+	if _, port, err := net.SplitHostPort(listen); err == nil {
+		return port
+	}
+	return listen
+}
@@ -0,0 +1,105 @@
+// Package certstore keeps the TLS certificate used for termination reloadable at runtime.
+// Handshakes read the current certificate through GetCertificate, so renewed files apply without a restart.
+package certstore
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// KeyPassphrase says where the passphrase of an encrypted PKCS#8 key comes from.
+// The zero value means the key is stored unencrypted.
+type KeyPassphrase struct {
+	Value string // Value is the passphrase itself.
+	File  string // File holds the passphrase on its first line; it is re-read on every reload so rotated secrets apply.
+}
+
+// Store holds the active certificate and the file paths it was loaded from.
+// An atomic pointer swap keeps the handshake path lock-free while reloads happen.
+type Store struct {
+	certFile   string
+	keyFile    string
+	passphrase KeyPassphrase
+	current    atomic.Pointer[tls.Certificate]
+}
+
+// Load reads the certificate pair once and fails fast when it is unusable at startup.
+func Load(certFile, keyFile string, passphrase KeyPassphrase) (*Store, error) {
+	store := &Store{certFile: certFile, keyFile: keyFile, passphrase: passphrase}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload re-reads the certificate pair and swaps it in only when parsing succeeds.
+// Existing connections keep their negotiated session; only new handshakes see the new certificate.
+func (store *Store) Reload() error {
+	certificate, err := store.load()
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate '%s' with key '%s': %w", store.certFile, store.keyFile, err)
+	}
+	store.current.Store(&certificate)
+	return nil
+}
+
+// load reads both files and decrypts an "ENCRYPTED PRIVATE KEY" block before handing the pair to crypto/tls.
+func (store *Store) load() (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(store.certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := os.ReadFile(store.keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block != nil && block.Type == "ENCRYPTED PRIVATE KEY" {
+		passphrase, err := store.passphrase.read()
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		plain, err := decryptPKCS8(block.Bytes, passphrase)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: plain})
+	} else if block != nil && block.Headers["Proc-Type"] == "4,ENCRYPTED" {
+		return tls.Certificate{}, fmt.Errorf("key uses legacy PEM encryption; convert it with 'openssl pkcs8 -topk8 -v2 aes256'")
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// read returns the configured passphrase, failing when an encrypted key has none.
+func (passphrase KeyPassphrase) read() ([]byte, error) {
+	if passphrase.File != "" {
+		content, err := os.ReadFile(passphrase.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key passphrase file: %v", err)
+		}
+		line, _, _ := strings.Cut(string(content), "\n")
+		return []byte(strings.TrimSuffix(line, "\r")), nil
+	}
+	if passphrase.Value == "" {
+		return nil, fmt.Errorf("key is encrypted but no passphrase was given")
+	}
+	return []byte(passphrase.Value), nil
+}
+
+// GetCertificate satisfies tls.Config.GetCertificate with the latest loaded certificate.
+func (store *Store) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return store.current.Load(), nil
+}
+
+// ServerConfig builds the termination config that always asks the store for the certificate.
+func (store *Store) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: store.GetCertificate,
+	}
+}
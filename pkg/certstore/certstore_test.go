@@ -0,0 +1,92 @@
+package certstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloadSwapsCertificateAndKeepsOldOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "proxy.crt")
+	keyFile := filepath.Join(dir, "proxy.key")
+	writeSelfSignedPair(t, certFile, keyFile, "first.example")
+
+	store, err := Load(certFile, keyFile, KeyPassphrase{})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if got := commonName(t, store); got != "first.example" {
+		t.Fatalf("initial certificate CN = %q", got)
+	}
+
+	writeSelfSignedPair(t, certFile, keyFile, "second.example")
+	if err := store.Reload(); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if got := commonName(t, store); got != "second.example" {
+		t.Fatalf("reloaded certificate CN = %q", got)
+	}
+
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("os.WriteFile returned error: %v", err)
+	}
+	if err := store.Reload(); err == nil {
+		t.Fatal("Reload accepted a broken certificate")
+	}
+	if got := commonName(t, store); got != "second.example" {
+		t.Fatalf("certificate after failed reload CN = %q, want the previous one", got)
+	}
+}
+
+func commonName(t *testing.T, store *Store) string {
+	t.Helper()
+
+	certificate, err := store.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate returned error: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate returned error: %v", err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func writeSelfSignedPair(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey returned error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate returned error: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey returned error: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("os.WriteFile returned error: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("os.WriteFile returned error: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+// Encrypted PKCS#8 keys ("ENCRYPTED PRIVATE KEY") are decrypted here because crypto/tls only loads plain keys.
+// Only PBES2 with PBKDF2 is supported, which is what OpenSSL and most CAs have produced for years.
+package certstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// ErrWrongPassphrase reports an encrypted key that does not decrypt with the given passphrase.
+var ErrWrongPassphrase = errors.New("wrong passphrase for encrypted TLS key")
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 returns the plain PKCS#8 DER inside an EncryptedPrivateKeyInfo.
+// CBC padding and the decrypted structure are both checked, so a wrong passphrase is reported instead of a garbled key.
+func decryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("invalid encrypted private key: %v", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption %v (only PBES2 is supported)", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid PBES2 parameters: %v", err)
+	}
+
+	newCipher, keyLength, err := pbes2Cipher(params.EncryptionScheme.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("invalid cipher IV: %v", err)
+	}
+	key, err := pbes2Key(params.KeyDerivationFunc, passphrase, keyLength)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data := info.EncryptedData
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, fmt.Errorf("invalid encrypted private key length")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	plain, ok := trimPKCS7Padding(plain, block.BlockSize())
+	if !ok {
+		return nil, ErrWrongPassphrase
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(plain); err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plain, nil
+}
+
+// pbes2Cipher maps the PBES2 encryption scheme to a block cipher and its key length.
+func pbes2Cipher(scheme asn1.ObjectIdentifier) (func([]byte) (cipher.Block, error), int, error) {
+	switch {
+	case scheme.Equal(oidAES128CBC):
+		return aes.NewCipher, 16, nil
+	case scheme.Equal(oidAES192CBC):
+		return aes.NewCipher, 24, nil
+	case scheme.Equal(oidAES256CBC):
+		return aes.NewCipher, 32, nil
+	case scheme.Equal(oidDESEDE3CBC):
+		return des.NewTripleDESCipher, 24, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported key cipher %v (expected AES-CBC or DES-EDE3-CBC)", scheme)
+	}
+}
+
+// pbes2Key derives the cipher key with PBKDF2, which is the only key derivation PBES2 keys use in practice.
+func pbes2Key(kdf pkix.AlgorithmIdentifier, passphrase []byte, keyLength int) ([]byte, error) {
+	if !kdf.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %v (only PBKDF2 is supported)", kdf.Algorithm)
+	}
+	var params pbkdf2Params
+	if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid PBKDF2 parameters: %v", err)
+	}
+	if params.IterationCount < 1 {
+		return nil, fmt.Errorf("invalid PBKDF2 iteration count %d", params.IterationCount)
+	}
+	if params.KeyLength != 0 && params.KeyLength != keyLength {
+		return nil, fmt.Errorf("PBKDF2 key length %d does not match the cipher", params.KeyLength)
+	}
+
+	var newHash func() hash.Hash
+	switch prf := params.PRF.Algorithm; {
+	case len(prf) == 0 || prf.Equal(oidHMACWithSHA1):
+		newHash = sha1.New
+	case prf.Equal(oidHMACWithSHA256):
+		newHash = sha256.New
+	case prf.Equal(oidHMACWithSHA384):
+		newHash = sha512.New384
+	case prf.Equal(oidHMACWithSHA512):
+		newHash = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 hash %v", prf)
+	}
+	return pbkdf2(passphrase, params.Salt, params.IterationCount, keyLength, newHash), nil
+}
+
+// pbkdf2 implements RFC 8018 section 5.2; the standard library only gained it after the Go version this module targets.
+func pbkdf2(password, salt []byte, iterations, keyLength int, newHash func() hash.Hash) []byte {
+	prf := hmac.New(newHash, password)
+	derived := make([]byte, 0, keyLength)
+	counter := make([]byte, 4)
+	for blockIndex := uint32(1); len(derived) < keyLength; blockIndex++ {
+		binary.BigEndian.PutUint32(counter, blockIndex)
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(counter)
+		u := prf.Sum(nil)
+		block := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range block {
+				block[j] ^= u[j]
+			}
+		}
+		derived = append(derived, block...)
+	}
+	return derived[:keyLength]
+}
+
+// trimPKCS7Padding strips CBC padding and reports whether it was well formed.
+func trimPKCS7Padding(data []byte, blockSize int) ([]byte, bool) {
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > blockSize || padding > len(data) {
+		return nil, false
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, false
+		}
+	}
+	return data[:len(data)-padding], true
+}
@@ -0,0 +1,130 @@
+package certstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPBKDF2MatchesRFC6070(t *testing.T) {
+	for _, vector := range []struct {
+		iterations int
+		want       string
+	}{
+		{1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{4096, "4b007901b765489abead49d926f721d065a429c1"},
+	} {
+		got := hex.EncodeToString(pbkdf2([]byte("password"), []byte("salt"), vector.iterations, 20, sha1.New))
+		if got != vector.want {
+			t.Fatalf("pbkdf2 with %d iterations = %s, want %s", vector.iterations, got, vector.want)
+		}
+	}
+}
+
+func TestLoadDecryptsPKCS8KeyAndRejectsWrongPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "proxy.crt")
+	keyFile := filepath.Join(dir, "proxy.key")
+	passphraseFile := filepath.Join(dir, "passphrase")
+	writeSelfSignedPair(t, certFile, keyFile, "encrypted.example")
+	encryptKeyFile(t, keyFile, "correct horse")
+	if err := os.WriteFile(passphraseFile, []byte("correct horse\n"), 0600); err != nil {
+		t.Fatalf("os.WriteFile returned error: %v", err)
+	}
+
+	store, err := Load(certFile, keyFile, KeyPassphrase{File: passphraseFile})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if got := commonName(t, store); got != "encrypted.example" {
+		t.Fatalf("certificate CN = %q", got)
+	}
+
+	if _, err := Load(certFile, keyFile, KeyPassphrase{Value: "battery staple"}); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("Load with wrong passphrase returned %v, want ErrWrongPassphrase", err)
+	}
+	if _, err := Load(certFile, keyFile, KeyPassphrase{}); err == nil {
+		t.Fatal("Load accepted an encrypted key without a passphrase")
+	}
+
+	// A renewed key under a rotated passphrase is picked up by the same reload SIGHUP triggers.
+	writeSelfSignedPair(t, certFile, keyFile, "renewed.example")
+	encryptKeyFile(t, keyFile, "new secret")
+	if err := os.WriteFile(passphraseFile, []byte("new secret\n"), 0600); err != nil {
+		t.Fatalf("os.WriteFile returned error: %v", err)
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if got := commonName(t, store); got != "renewed.example" {
+		t.Fatalf("reloaded certificate CN = %q", got)
+	}
+}
+
+// encryptKeyFile rewrites an EC key file as PBES2 PBKDF2-HMAC-SHA256 AES-256-CBC, the layout OpenSSL's pkcs8 -v2 aes256 writes.
+func encryptKeyFile(t *testing.T, keyFile, passphrase string) {
+	t.Helper()
+
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatalf("os.ReadFile returned error: %v", err)
+	}
+	block, _ := pem.Decode(content)
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("x509.ParseECPrivateKey returned error: %v", err)
+	}
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalPKCS8PrivateKey returned error: %v", err)
+	}
+
+	salt, iv := make([]byte, 16), make([]byte, aes.BlockSize)
+	rand.Read(salt)
+	rand.Read(iv)
+	derived := pbkdf2([]byte(passphrase), salt, 2048, 32, sha256.New)
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	for i := 0; i < padding; i++ {
+		plain = append(plain, byte(padding))
+	}
+	cipherBlock, err := aes.NewCipher(derived)
+	if err != nil {
+		t.Fatalf("aes.NewCipher returned error: %v", err)
+	}
+	encrypted := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(cipherBlock, iv).CryptBlocks(encrypted, plain)
+
+	kdfParams := marshalASN1(t, pbkdf2Params{Salt: salt, IterationCount: 2048, PRF: pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue}})
+	ivParams := marshalASN1(t, iv)
+	schemeParams := marshalASN1(t, pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	der := marshalASN1(t, encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: schemeParams}},
+		EncryptedData: encrypted,
+	})
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("os.WriteFile returned error: %v", err)
+	}
+}
+
+func marshalASN1(t *testing.T, value interface{}) []byte {
+	t.Helper()
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		t.Fatalf("asn1.Marshal returned error: %v", err)
+	}
+	return der
+}
@@ -0,0 +1,162 @@
+// A config directory splits one route config into files that different owners edit, like nginx conf.d.
+// Its files are merged in name order into a single config, so a directory behaves exactly like one file that lists every route.
+package config
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DirExtensions select the files of a config directory, along with sealed ones that add ".enc" as -config-encrypt examples do.
+// Anything else in the directory, such as a README or an editor backup, is ignored.
+var DirExtensions = []string{".json", ".yaml", ".yml"}
+
+// Part is one file of a config directory.
+type Part struct {
+	Name    string // Name is the file name without the directory.
+	Content []byte
+}
+
+// ReadDir reads the config files of dir in name order; hidden files are skipped because editors keep swap files there.
+func ReadDir(dir string) ([]Part, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config directory '%s': %v", dir, err)
+	}
+	var parts []Part
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !isConfigFileName(name) {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file '%s': %v", filepath.Join(dir, name), err)
+		}
+		parts = append(parts, Part{Name: name, Content: content})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Name < parts[j].Name })
+	return parts, nil
+}
+
+// isConfigFileName reports whether name has one of DirExtensions, sealed or not.
+func isConfigFileName(name string) bool {
+	name = strings.TrimSuffix(name, ".enc")
+	for _, extension := range DirExtensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadDir reads and merges every config file of dir, returning the TCP and UDP routes and the names of the files read.
+func LoadDir(dir string, key []byte) ([]Route, []Route, []string, error) {
+	parts, err := ReadDir(dir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tcpRoutes, udpRoutes, err := ParseParts(parts, key)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("config directory '%s': %v", dir, err)
+	}
+	return tcpRoutes, udpRoutes, PartNames(parts), nil
+}
+
+// ParseParts validates every part and concatenates their route lists in order; one bad file rejects the whole directory.
+// Each part may be sealed with key. A local port claimed by two files is an error naming both, never a silent override.
+func ParseParts(parts []Part, key []byte) ([]Route, []Route, error) {
+	var tcpRoutes, udpRoutes []Route
+	tcpOwners := make(map[string]string)
+	udpOwners := make(map[string]string)
+	for _, part := range parts {
+		content, err := Unseal(part.Content, key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", part.Name, err)
+		}
+		partTCP, partUDP, err := ParseFile(content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", part.Name, err)
+		}
+		if tcpRoutes, err = mergeRoutes(tcpRoutes, partTCP, tcpOwners, part.Name); err != nil {
+			return nil, nil, fmt.Errorf("tcp: %v", err)
+		}
+		if udpRoutes, err = mergeRoutes(udpRoutes, partUDP, udpOwners, part.Name); err != nil {
+			return nil, nil, fmt.Errorf("udp: %v", err)
+		}
+	}
+	return tcpRoutes, udpRoutes, nil
+}
+
+// PartNames lists the file names of parts, for logging which files a config came from.
+func PartNames(parts []Part) []string {
+	names := make([]string, 0, len(parts))
+	for _, part := range parts {
+		names = append(names, part.Name)
+	}
+	return names
+}
+
+// mergeRoutes appends routes from the file name, recording in owners which file holds each local port.
+func mergeRoutes(merged, routes []Route, owners map[string]string, name string) ([]Route, error) {
+	for _, route := range routes {
+		if owner, taken := owners[route.LocalPort]; taken {
+			return nil, fmt.Errorf("local port %s is used by both %s and %s", route.LocalPort, owner, name)
+		}
+		owners[route.LocalPort] = name
+		merged = append(merged, route)
+	}
+	return merged, nil
+}
+
+// WatchDir polls dir every interval and calls onChange with its config files once they have settled.
+// Like WatchFile, a change must look the same on two consecutive polls, so a file being copied in is not read half-written.
+// Every poll reads the files whole, which is cheap for the few small files a config directory holds. It runs until the process exits.
+func WatchDir(dir string, interval time.Duration, onChange func([]Part)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	watcher := dirWatcher{dir: dir}
+	watcher.poll()
+	watcher.applied = watcher.pending
+	for range ticker.C {
+		if parts, ok := watcher.poll(); ok {
+			onChange(parts)
+		}
+	}
+}
+
+// dirWatcher remembers the last seen and last delivered directory contents; only the WatchDir goroutine touches it.
+type dirWatcher struct {
+	dir     string
+	pending [sha256.Size]byte
+	applied [sha256.Size]byte
+}
+
+// poll returns settled files whose names or contents differ from the last delivered version.
+func (watcher *dirWatcher) poll() ([]Part, bool) {
+	parts, err := ReadDir(watcher.dir)
+	if err != nil {
+		return nil, false
+	}
+	hash := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(hash, "%s\x00%d\x00", part.Name, len(part.Content))
+		hash.Write(part.Content)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], hash.Sum(nil))
+	settled := sum == watcher.pending
+	watcher.pending = sum
+
+	if !settled || sum == watcher.applied {
+		return nil, false
+	}
+	watcher.applied = sum
+	return parts, true
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDirMergesFilesInNameOrder(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "20-web.json"), `{"tcp": ["8443:203.0.113.11:443"], "both": ["53:203.0.113.20:53"]}`)
+	writeConfig(t, filepath.Join(dir, "10-db.json"), `{"tcp": ["5432:203.0.113.10:5432"], "udp": ["5353:203.0.113.21:53"]}`)
+	writeConfig(t, filepath.Join(dir, "README.md"), `not a config`)
+	writeConfig(t, filepath.Join(dir, ".20-web.json.swp"), `not a config`)
+
+	tcpRoutes, udpRoutes, files, err := LoadDir(dir, nil)
+	if err != nil {
+		t.Fatalf("LoadDir returned error: %v", err)
+	}
+	if strings.Join(files, ",") != "10-db.json,20-web.json" {
+		t.Fatalf("files = %q, want the two .json files in name order", files)
+	}
+	var tcpPorts, udpPorts []string
+	for _, route := range tcpRoutes {
+		tcpPorts = append(tcpPorts, route.LocalPort)
+	}
+	for _, route := range udpRoutes {
+		udpPorts = append(udpPorts, route.LocalPort)
+	}
+	if strings.Join(tcpPorts, ",") != "5432,8443,53" || strings.Join(udpPorts, ",") != "5353,53" {
+		t.Fatalf("ports = tcp %v udp %v", tcpPorts, udpPorts)
+	}
+}
+
+func TestLoadDirRejectsPortClaimedByTwoFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "a.json"), `{"udp": ["53:203.0.113.10:53"]}`)
+	writeConfig(t, filepath.Join(dir, "b.json"), `{"both": ["53:203.0.113.11:53"]}`)
+
+	_, _, _, err := LoadDir(dir, nil)
+	if err == nil || !strings.Contains(err.Error(), "local port 53 is used by both a.json and b.json") {
+		t.Fatalf("LoadDir error = %v, want the port and both file names", err)
+	}
+}
+
+func TestLoadDirNamesTheInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "a.json"), `{"tcp": ["8080:203.0.113.10:80"]}`)
+	writeConfig(t, filepath.Join(dir, "b.json"), `{"tcp": ["8081:not-an-ip:80"]}`)
+
+	_, _, _, err := LoadDir(dir, nil)
+	if err == nil || !strings.Contains(err.Error(), "b.json: tcp:") {
+		t.Fatalf("LoadDir error = %v, want it to name b.json", err)
+	}
+}
+
+func TestLoadDirOpensSealedFiles(t *testing.T) {
+	key := testConfigKey(t)
+	sealed, err := Seal([]byte(`{"tcp": ["8080:203.0.113.10:80"]}`), key)
+	if err != nil {
+		t.Fatalf("Seal returned error: %v", err)
+	}
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "sealed.json.enc"), string(sealed))
+	writeConfig(t, filepath.Join(dir, "plain.json"), `{"tcp": ["8081:203.0.113.11:80"]}`)
+
+	tcpRoutes, _, _, err := LoadDir(dir, key)
+	if err != nil {
+		t.Fatalf("LoadDir returned error: %v", err)
+	}
+	if len(tcpRoutes) != 2 {
+		t.Fatalf("TCP routes = %#v, want one from each file", tcpRoutes)
+	}
+}
+
+func TestDirWatcherDeliversAddedFileOnceSettled(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "a.json"), `{"tcp": ["8080:203.0.113.10:80"]}`)
+
+	watcher := dirWatcher{dir: dir}
+	watcher.poll()
+	watcher.applied = watcher.pending
+	if _, changed := watcher.poll(); changed {
+		t.Fatal("unchanged directory was delivered")
+	}
+
+	writeConfig(t, filepath.Join(dir, "b.json"), `{"tcp": ["8081:203.0.113.11:80"]}`)
+	if _, changed := watcher.poll(); changed {
+		t.Fatal("change was delivered before it settled")
+	}
+	parts, changed := watcher.poll()
+	if !changed || strings.Join(PartNames(parts), ",") != "a.json,b.json" {
+		t.Fatalf("settled change = %v, %v", PartNames(parts), changed)
+	}
+	if _, changed := watcher.poll(); changed {
+		t.Fatal("the same change was delivered twice")
+	}
+}
@@ -0,0 +1,97 @@
+// Config files hold route lists that outgrow a single flag and can be reloaded while the proxy runs, in JSON or YAML.
+// Entries reuse the -routes syntax, including ;options, so flags and files never drift apart.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// File is the layout of a -config file; a YAML file uses the same keys.
+type File struct {
+	TCP  []string `json:"tcp"`  // TCP lists routes as LOCALPORT:REMOTEIP:REMOTEPORT[;option=value].
+	UDP  []string `json:"udp"`  // UDP uses the same syntax as TCP.
+	Both []string `json:"both"` // Both lists routes served on TCP and UDP alike, as DNS usually is.
+	// Log and Rotation stand in for the logging flags the command line leaves out; they are read once, at startup.
+	Log      *LogSettings      `json:"log,omitempty"`
+	Rotation *RotationSettings `json:"rotation,omitempty"`
+	// Flags records the command-line settings a -print-config dump ran with, for auditing.
+	// Loading ignores it, so a dump reads back as a plain route file; settings still come from the command line.
+	Flags map[string]string `json:"flags,omitempty"`
+}
+
+// LoadFile reads and validates a config file into TCP and UDP routes; key opens a sealed file and may be nil for plain ones.
+func LoadFile(path string, key []byte) ([]Route, []Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file '%s': %v", path, err)
+	}
+	if data, err = Unseal(data, key); err != nil {
+		return nil, nil, fmt.Errorf("config file '%s': %v", path, err)
+	}
+	tcpRoutes, udpRoutes, err := ParseFile(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config file '%s': %v", path, err)
+	}
+	return tcpRoutes, udpRoutes, nil
+}
+
+// ParseFile validates config content completely before returning, so a reload either applies whole or not at all.
+// Unknown fields are rejected because a misspelled key would otherwise silently drop routes.
+func ParseFile(data []byte) ([]Route, []Route, error) {
+	file, err := decodeFile(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Both entries join each list before the duplicate check, so they cannot collide with a tcp or udp entry on the same port.
+	tcpRoutes, err := parseRouteList(append(append([]string(nil), file.TCP...), file.Both...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("tcp: %v", err)
+	}
+	udpRoutes, err := parseRouteList(append(append([]string(nil), file.UDP...), file.Both...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("udp: %v", err)
+	}
+	return tcpRoutes, udpRoutes, nil
+}
+
+// decodeFile reads JSON, or YAML turned into JSON, with the same strict field checks.
+func decodeFile(data []byte) (File, error) {
+	format := "JSON"
+	if !isJSONContent(data) {
+		converted, err := yamlToJSON(data)
+		if err != nil {
+			return File{}, fmt.Errorf("invalid YAML: %v", err)
+		}
+		data, format = converted, "YAML"
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var file File
+	if err := decoder.Decode(&file); err != nil {
+		return File{}, fmt.Errorf("invalid %s: %v", format, err)
+	}
+	return file, nil
+}
+
+func parseRouteList(entries []string) ([]Route, error) {
+	routes := make([]Route, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		expanded, err := parseLegacyRoute(entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range expanded {
+			if seen[route.LocalPort] {
+				return nil, fmt.Errorf("local port %s is used by more than one route", route.LocalPort)
+			}
+			seen[route.LocalPort] = true
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFileReadsRouteLists(t *testing.T) {
+	tcpRoutes, udpRoutes, err := ParseFile([]byte(`{
+		"tcp": ["8080:203.0.113.10:80;handshake-timeout=5s", "8443:[2001:db8::10]:443"],
+		"udp": ["5353:203.0.113.20:53"]
+	}`))
+	if err != nil {
+		t.Fatalf("ParseFile returned error: %v", err)
+	}
+	if len(tcpRoutes) != 2 || len(udpRoutes) != 1 {
+		t.Fatalf("route counts = tcp %d udp %d, want 2 and 1", len(tcpRoutes), len(udpRoutes))
+	}
+	if tcpRoutes[0].HandshakeTimeout != 5*time.Second || tcpRoutes[1].RemoteIP != "2001:db8::10" {
+		t.Fatalf("TCP routes = %#v", tcpRoutes)
+	}
+}
+
+func TestParseFileReadsYAMLLikeJSON(t *testing.T) {
+	jsonTCP, jsonUDP, err := ParseFile([]byte(`{"tcp": ["8080:203.0.113.10:80;name=web", "8443:[2001:db8::10]:443"], "both": ["53:203.0.113.20:53"]}`))
+	if err != nil {
+		t.Fatalf("ParseFile(JSON) returned error: %v", err)
+	}
+	yamlTCP, yamlUDP, err := ParseFile([]byte(`# checked into git
+tcp:
+  - 8080:203.0.113.10:80;name=web  # public site
+  - "8443:[2001:db8::10]:443"
+both: [53:203.0.113.20:53]
+log:
+  format: json
+`))
+	if err != nil {
+		t.Fatalf("ParseFile(YAML) returned error: %v", err)
+	}
+	if !reflect.DeepEqual(yamlTCP, jsonTCP) || !reflect.DeepEqual(yamlUDP, jsonUDP) {
+		t.Fatalf("YAML routes = tcp %#v udp %#v, want the JSON ones", yamlTCP, yamlUDP)
+	}
+
+	// A misspelled key is an error in YAML too, not a silently empty route list.
+	if _, _, err := ParseFile([]byte("tpc:\n  - 8080:203.0.113.10:80\n")); err == nil || !strings.Contains(err.Error(), `invalid YAML: json: unknown field "tpc"`) {
+		t.Fatalf("ParseFile error = %v, want the unknown field", err)
+	}
+
+	// A bare dash is an empty item, so it fails as a route instead of crashing the parser or a reload.
+	for _, content := range []string{"tcp:\n  - 8080:10.0.0.1:80\n  -   # next\n", "tcp:\n  -\n"} {
+		if _, _, err := ParseFile([]byte(content)); err == nil || !strings.Contains(err.Error(), "invalid route format: ''") {
+			t.Fatalf("ParseFile(%q) error = %v, want the empty route reported", content, err)
+		}
+	}
+}
+
+func TestParseFileAddsBothRoutesToEachList(t *testing.T) {
+	tcpRoutes, udpRoutes, err := ParseFile([]byte(`{"tcp": ["8080:203.0.113.10:80"], "both": ["53:203.0.113.20:53"]}`))
+	if err != nil {
+		t.Fatalf("ParseFile returned error: %v", err)
+	}
+	if len(tcpRoutes) != 2 || len(udpRoutes) != 1 || udpRoutes[0].LocalPort != "53" {
+		t.Fatalf("routes = tcp %#v udp %#v", tcpRoutes, udpRoutes)
+	}
+}
+
+func TestParseFileRejectsInvalidContent(t *testing.T) {
+	tests := map[string]string{
+		"malformed JSON":       `{"tcp": ["8080:203.0.113.10:80"`,
+		"unknown field":        `{"tcp": [], "routes": ["8080:203.0.113.10:80"]}`,
+		"bad route":            `{"udp": ["5353:not-an-ip:53"]}`,
+		"duplicate port":       `{"tcp": ["8080:203.0.113.10:80", "8080:203.0.113.11:80"]}`,
+		"both collides":        `{"udp": ["53:203.0.113.10:53"], "both": ["53:203.0.113.11:53"]}`,
+		"joined port collides": `{"tcp": ["8080+8081:203.0.113.10:80", "8081:203.0.113.11:80"]}`,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := ParseFile([]byte(content)); err == nil {
+				t.Fatalf("ParseFile accepted %s", content)
+			}
+		})
+	}
+}
+
+func TestFileWatcherDeliversSettledChangesOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	writeConfig(t, path, `{"tcp": ["8080:203.0.113.10:80"]}`)
+
+	watcher := fileWatcher{path: path}
+	watcher.poll()
+	watcher.applied = watcher.pending
+	if _, changed := watcher.poll(); changed {
+		t.Fatal("unchanged file was delivered")
+	}
+
+	writeConfig(t, path, `{"tcp": ["8443:203.0.113.11:443"]}`)
+	if _, changed := watcher.poll(); changed {
+		t.Fatal("change was delivered before it settled")
+	}
+	content, changed := watcher.poll()
+	if !changed || string(content) != `{"tcp": ["8443:203.0.113.11:443"]}` {
+		t.Fatalf("settled change = %q, %v", content, changed)
+	}
+	if _, changed := watcher.poll(); changed {
+		t.Fatal("the same change was delivered twice")
+	}
+}
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("os.WriteFile returned error: %v", err)
+	}
+}
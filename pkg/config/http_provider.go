@@ -0,0 +1,139 @@
+// An HTTP provider polls a URL that serves the -routes-json array, the simplest face a Consul or etcd gateway, or any service, can put on a KV store.
+// ETags turn an unchanged poll into a bodiless 304, and a content hash covers servers that send no ETag.
+package config
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// DefaultHTTPProviderInterval is how often a config URL is polled unless HTTPProvider.Interval says otherwise.
+	DefaultHTTPProviderInterval = 30 * time.Second
+	// maxHTTPConfigSize bounds a config response, so a misconfigured URL serving a large file cannot exhaust memory.
+	maxHTTPConfigSize   = 16 << 20
+	httpProviderTimeout = 10 * time.Second
+)
+
+// HTTPProvider serves the routes of a URL that returns the JSON route array -routes-json reads; key opens sealed bodies and may be nil.
+// Only one goroutine may use it at a time: Load first, then Watch. Reread works on a copy and may run alongside Watch.
+type HTTPProvider struct {
+	URL      string
+	Key      []byte
+	Interval time.Duration // Interval is the poll period; zero means DefaultHTTPProviderInterval.
+	Client   *http.Client  // Client sends the requests; nil uses one with a 10 second timeout.
+	etag     string
+	applied  [sha256.Size]byte
+	lastErr  string
+}
+
+// Load fetches and parses the routes once.
+func (provider *HTTPProvider) Load() ([]Route, []Route, error) {
+	content, _, err := provider.fetch()
+	if err != nil {
+		return nil, nil, err
+	}
+	return provider.parse(content)
+}
+
+// Watch polls the URL and sends each changed version; an unchanged or 304 response sends nothing.
+// A failing poll is sent as an Err update once, not on every poll while the server stays down.
+func (provider *HTTPProvider) Watch(updates chan<- Update) {
+	interval := provider.Interval
+	if interval <= 0 {
+		interval = DefaultHTTPProviderInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		content, changed, err := provider.fetch()
+		if err != nil {
+			if err.Error() != provider.lastErr {
+				provider.lastErr = err.Error()
+				updates <- Update{Err: err}
+			}
+			continue
+		}
+		provider.lastErr = ""
+		if changed {
+			tcpRoutes, udpRoutes, err := provider.parse(content)
+			updates <- Update{TCP: tcpRoutes, UDP: udpRoutes, Err: err}
+		}
+	}
+}
+
+// Reread fetches the URL without the ETag Watch holds, so the server sends the full body even when nothing changed.
+func (provider *HTTPProvider) Reread() Update {
+	fresh := &HTTPProvider{URL: provider.URL, Key: provider.Key, Client: provider.Client}
+	tcpRoutes, udpRoutes, err := fresh.Load()
+	return Update{TCP: tcpRoutes, UDP: udpRoutes, Err: err}
+}
+
+// String names the source in log lines, without any password the URL carries.
+func (provider *HTTPProvider) String() string {
+	if parsed, err := url.Parse(provider.URL); err == nil {
+		return parsed.Redacted()
+	}
+	return provider.URL
+}
+
+// fetch returns the body and whether it differs from the last one fetched; a 304 or an identical body is no change.
+func (provider *HTTPProvider) fetch() ([]byte, bool, error) {
+	request, err := http.NewRequest(http.MethodGet, provider.URL, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("config URL '%s': %v", provider, err)
+	}
+	if provider.etag != "" {
+		request.Header.Set("If-None-Match", provider.etag)
+	}
+	client := provider.Client
+	if client == nil {
+		client = &http.Client{Timeout: httpProviderTimeout}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		// The client error repeats the URL, password included, so only its cause is kept.
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, false, fmt.Errorf("config URL '%s': %v", provider, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotModified {
+		return nil, false, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("config URL '%s' answered %s", provider, response.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(response.Body, maxHTTPConfigSize+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("config URL '%s': %v", provider, err)
+	}
+	if len(content) > maxHTTPConfigSize {
+		return nil, false, fmt.Errorf("config URL '%s' served more than %d bytes", provider, maxHTTPConfigSize)
+	}
+
+	provider.etag = response.Header.Get("ETag")
+	sum := sha256.Sum256(content)
+	if sum == provider.applied {
+		return nil, false, nil
+	}
+	provider.applied = sum
+	return content, true, nil
+}
+
+func (provider *HTTPProvider) parse(content []byte) ([]Route, []Route, error) {
+	content, err := Unseal(content, provider.Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config URL '%s': %v", provider, err)
+	}
+	tcpRoutes, udpRoutes, err := ParseRoutesJSON(string(content))
+	if err != nil {
+		return nil, nil, fmt.Errorf("config URL '%s': %v", provider, err)
+	}
+	return tcpRoutes, udpRoutes, nil
+}
@@ -0,0 +1,34 @@
+// Memory pressure marks say when the proxy stops admitting new clients to protect itself and when it starts again.
+// They share the byte sizes of route quotas, so -mem-pressure-pause=1GB,800MB reads like the rest of the flags.
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultMemoryLowPercent places the low-water mark when only the high one is given.
+const DefaultMemoryLowPercent = 80
+
+// ParseMemoryPressure reads HIGH or HIGH,LOW, such as 1GiB,768MiB; an empty string turns the pause off.
+func ParseMemoryPressure(raw string) (uint64, uint64, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, 0, nil
+	}
+	highText, lowText, hasLow := strings.Cut(raw, ",")
+	high, err := parseByteSize(strings.TrimSpace(highText))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid memory pressure marks '%s': %v", raw, err)
+	}
+	low := high / 100 * DefaultMemoryLowPercent
+	if hasLow {
+		if low, err = parseByteSize(strings.TrimSpace(lowText)); err != nil {
+			return 0, 0, fmt.Errorf("invalid memory pressure marks '%s': %v", raw, err)
+		}
+	}
+	if low >= high {
+		return 0, 0, fmt.Errorf("invalid memory pressure marks '%s': the low-water mark must be below the high one", raw)
+	}
+	return high, low, nil
+}
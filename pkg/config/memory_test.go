@@ -0,0 +1,25 @@
+package config
+
+import "testing"
+
+func TestParseMemoryPressureReadsBothMarks(t *testing.T) {
+	tests := map[string][2]uint64{
+		"1GiB,768MiB": {1 << 30, 768 << 20},
+		"1GB":         {1e9, 8e8},
+		"":            {0, 0},
+	}
+	for raw, want := range tests {
+		high, low, err := ParseMemoryPressure(raw)
+		if err != nil {
+			t.Fatalf("ParseMemoryPressure(%q) returned error: %v", raw, err)
+		}
+		if high != want[0] || low != want[1] {
+			t.Fatalf("ParseMemoryPressure(%q) = %d, %d; want %d, %d", raw, high, low, want[0], want[1])
+		}
+	}
+	for _, raw := range []string{"1GB,1GB", "512MB,1GB", "1GB,", "lots", "1GB,xMB"} {
+		if _, _, err := ParseMemoryPressure(raw); err == nil {
+			t.Fatalf("ParseMemoryPressure(%q) accepted invalid marks", raw)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// A provider is where config routes come from: a file or directory by default, or a URL that serves them.
+// main loads routes from it once at startup and hands every later version to the route supervisor, whatever the source.
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// Update is one new version of a provider's routes.
+// Err means the source changed but could not be read or parsed, and the routes already running stay as they are.
+type Update struct {
+	TCP []Route
+	UDP []Route
+	Err error
+	// Files names the files a directory update merged, for the log line; it is nil for any other source.
+	Files []string
+}
+
+// Provider is a source of config routes.
+// Load reads the routes once; Watch then sends each later version to updates until the process exits,
+// or returns at once when the provider has nothing to watch. Watch must not start before Load returns.
+// Reread reads the source again on demand, for SIGHUP, and may run while Watch polls.
+type Provider interface {
+	Load() ([]Route, []Route, error)
+	Watch(updates chan<- Update)
+	Reread() Update
+}
+
+// FileProvider serves a -config file or directory; key opens sealed files and may be nil.
+// Interval polls for changes the way WatchFile and WatchDir do; zero loads once and never watches.
+type FileProvider struct {
+	Path     string
+	Key      []byte
+	Interval time.Duration
+	dirFiles []string
+}
+
+// Load reads a file, or merges a directory in name order.
+func (provider *FileProvider) Load() ([]Route, []Route, error) {
+	if info, err := os.Stat(provider.Path); err == nil && info.IsDir() {
+		tcpRoutes, udpRoutes, files, err := LoadDir(provider.Path, provider.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		provider.dirFiles = files
+		return tcpRoutes, udpRoutes, nil
+	}
+	return LoadFile(provider.Path, provider.Key)
+}
+
+// DirFiles names the files the last Load merged, or returns nil when Path is a single file.
+func (provider *FileProvider) DirFiles() []string {
+	return provider.dirFiles
+}
+
+// Watch polls the file or directory Load read and sends settled changes only, so a half-written edit is never applied.
+func (provider *FileProvider) Watch(updates chan<- Update) {
+	if provider.Interval <= 0 {
+		return
+	}
+	if provider.dirFiles != nil {
+		WatchDir(provider.Path, provider.Interval, func(parts []Part) {
+			tcpRoutes, udpRoutes, err := ParseParts(parts, provider.Key)
+			updates <- Update{TCP: tcpRoutes, UDP: udpRoutes, Err: err, Files: PartNames(parts)}
+		})
+		return
+	}
+	WatchFile(provider.Path, provider.Interval, func(content []byte) {
+		content, err := Unseal(content, provider.Key)
+		if err != nil {
+			updates <- Update{Err: err}
+			return
+		}
+		tcpRoutes, udpRoutes, err := ParseFile(content)
+		updates <- Update{TCP: tcpRoutes, UDP: udpRoutes, Err: err}
+	})
+}
+
+// Reread loads the file or directory into a fresh provider, so the state Watch compares against stays its own.
+func (provider *FileProvider) Reread() Update {
+	fresh := &FileProvider{Path: provider.Path, Key: provider.Key}
+	tcpRoutes, udpRoutes, err := fresh.Load()
+	return Update{TCP: tcpRoutes, UDP: udpRoutes, Err: err, Files: fresh.DirFiles()}
+}
+
+// String names the source in log lines.
+func (provider *FileProvider) String() string {
+	return provider.Path
+}
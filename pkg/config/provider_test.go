@@ -0,0 +1,107 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	_ Provider = (*FileProvider)(nil)
+	_ Provider = (*HTTPProvider)(nil)
+)
+
+// nextUpdate waits for one update, so a provider that never sends fails the test instead of hanging it.
+func nextUpdate(t *testing.T, updates <-chan Update) Update {
+	t.Helper()
+	select {
+	case update := <-updates:
+		return update
+	case <-time.After(2 * time.Second):
+		t.Fatal("provider sent no update")
+		return Update{}
+	}
+}
+
+func TestFileProviderWatchSendsChangedRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	writeConfig(t, path, `{"tcp": ["8080:203.0.113.10:80"]}`)
+
+	provider := &FileProvider{Path: path, Interval: 5 * time.Millisecond}
+	tcpRoutes, _, err := provider.Load()
+	if err != nil || len(tcpRoutes) != 1 {
+		t.Fatalf("Load = %#v, %v", tcpRoutes, err)
+	}
+	if provider.DirFiles() != nil {
+		t.Fatalf("DirFiles = %v for a single file", provider.DirFiles())
+	}
+	updates := make(chan Update)
+	go provider.Watch(updates)
+	// Watch takes its own baseline on the first poll, so the edit waits until that has happened.
+	time.Sleep(20 * time.Millisecond)
+
+	writeConfig(t, path, `{"udp": ["5353:203.0.113.20:53"]}`)
+	update := nextUpdate(t, updates)
+	if update.Err != nil || len(update.TCP) != 0 || len(update.UDP) != 1 || update.UDP[0].LocalPort != "5353" {
+		t.Fatalf("update = %#v, want the UDP route only", update)
+	}
+	if update.Files != nil {
+		t.Fatalf("update names files %v for a single file", update.Files)
+	}
+}
+
+func TestFileProviderWatchNamesDirectoryFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "a.json"), `{"tcp": ["8080:203.0.113.10:80"]}`)
+
+	provider := &FileProvider{Path: dir, Interval: 5 * time.Millisecond}
+	if _, _, err := provider.Load(); err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	updates := make(chan Update)
+	go provider.Watch(updates)
+	// Watch takes its own baseline on the first poll, so the edit waits until that has happened.
+	time.Sleep(20 * time.Millisecond)
+
+	writeConfig(t, filepath.Join(dir, "b.json"), `{"tcp": ["8081:not-an-ip:80"]}`)
+	update := nextUpdate(t, updates)
+	if update.Err == nil || len(update.Files) != 2 {
+		t.Fatalf("update = %#v, want the parse error and both file names", update)
+	}
+}
+
+func TestRereadReturnsTheWholeSourceEvenWhenUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "a.json"), `{"tcp": ["8080:203.0.113.10:80"]}`)
+	fileProvider := &FileProvider{Path: dir}
+	if _, _, err := fileProvider.Load(); err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	writeConfig(t, filepath.Join(dir, "b.yaml"), "udp: [5353:203.0.113.20:53]\n")
+	update := fileProvider.Reread()
+	if update.Err != nil || len(update.TCP) != 1 || len(update.UDP) != 1 || len(update.Files) != 2 {
+		t.Fatalf("Reread = %#v, want both files merged", update)
+	}
+	if len(fileProvider.DirFiles()) != 1 {
+		t.Fatalf("DirFiles = %v, want Reread to leave the provider alone", fileProvider.DirFiles())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`[{"local": 8080, "remote_ip": "203.0.113.10", "remote_port": 80}]`))
+	}))
+	defer server.Close()
+	urlProvider := &HTTPProvider{URL: server.URL}
+	if _, _, err := urlProvider.Load(); err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if update := urlProvider.Reread(); update.Err != nil || len(update.TCP) != 1 {
+		t.Fatalf("Reread = %#v, want the routes even though the ETag matches", update)
+	}
+}
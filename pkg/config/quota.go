@@ -0,0 +1,64 @@
+// Route quotas cap the bytes a route forwards per time window, for links billed by traffic.
+// The flag reads like a tariff, e.g. 10GB/24h, so the limit and its window are parsed together.
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Quota is a byte limit per window; the zero Quota means no limit.
+type Quota struct {
+	Bytes  uint64
+	Window time.Duration
+}
+
+// quotaUnits are checked longest suffix first, so "GiB" is not read as a number ending in "B".
+// Decimal units match how metered links are billed; binary ones are accepted for people who think in them.
+var quotaUnits = []struct {
+	suffix string
+	size   float64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// ParseQuota reads SIZE/WINDOW, such as 10GB/24h or 500MiB/1h; an empty string is no quota.
+func ParseQuota(raw string) (Quota, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return Quota{}, nil
+	}
+	sizeText, windowText, ok := strings.Cut(raw, "/")
+	if !ok {
+		return Quota{}, fmt.Errorf("invalid quota '%s' (expected SIZE/WINDOW, e.g. 10GB/24h)", raw)
+	}
+	size, err := parseByteSize(strings.TrimSpace(sizeText))
+	if err != nil {
+		return Quota{}, fmt.Errorf("invalid quota '%s': %v", raw, err)
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(windowText))
+	if err != nil || window <= 0 {
+		return Quota{}, fmt.Errorf("invalid quota '%s': window must be a positive duration such as 24h", raw)
+	}
+	return Quota{Bytes: size, Window: window}, nil
+}
+
+func parseByteSize(text string) (uint64, error) {
+	upper := strings.ToUpper(text)
+	for _, unit := range quotaUnits {
+		if !strings.HasSuffix(upper, strings.ToUpper(unit.suffix)) {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(text[:len(text)-len(unit.suffix)]), 64)
+		if err != nil || !(number > 0) || number*unit.size >= math.MaxUint64 {
+			return 0, fmt.Errorf("size '%s' must be a positive number of B, KB, MB, GB, TB, KiB, MiB, GiB or TiB", text)
+		}
+		return uint64(math.Ceil(number * unit.size)), nil
+	}
+	return 0, fmt.Errorf("size '%s' needs a unit: B, KB, MB, GB, TB, KiB, MiB, GiB or TiB", text)
+}
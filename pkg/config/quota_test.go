@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuotaReadsSizeAndWindow(t *testing.T) {
+	tests := map[string]Quota{
+		"10GB/24h":    {Bytes: 10e9, Window: 24 * time.Hour},
+		"500MiB/1h":   {Bytes: 500 << 20, Window: time.Hour},
+		"1.5 kb / 1m": {Bytes: 1500, Window: time.Minute},
+		"16B/24h":     {Bytes: 16, Window: 24 * time.Hour},
+		"":            {},
+	}
+	for raw, want := range tests {
+		got, err := ParseQuota(raw)
+		if err != nil {
+			t.Fatalf("ParseQuota(%q) returned error: %v", raw, err)
+		}
+		if got != want {
+			t.Fatalf("ParseQuota(%q) = %+v, want %+v", raw, got, want)
+		}
+	}
+}
+
+func TestParseQuotaRejectsInvalidQuotas(t *testing.T) {
+	for _, raw := range []string{"10GB", "10/24h", "0GB/24h", "-1GB/24h", "10XB/24h", "10GB/0s", "10GB/-1h", "10GB/daily", "NaNGB/1h", "1e30TB/1h"} {
+		if _, err := ParseQuota(raw); err == nil {
+			t.Fatalf("ParseQuota(%q) accepted an invalid quota", raw)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+// Per-route options extend the legacy route syntax without changing its LOCALPORT:REMOTEIP:REMOTEPORT core.
+// Options follow the route after semicolons, e.g. 8080:10.0.0.1:80;handshake-timeout=5s;http;backup=10.0.0.2:80 or 2525:10.0.0.1:25;server-first.
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/rules"
+	"github.com/matveynator/chicha-ip-proxy/pkg/schedule"
+)
+
+// routeOptionSeparator never appears in ports or IP literals, so it can split options from the route safely.
+const routeOptionSeparator = ";"
+
+// splitRouteOptions separates the route itself from its trailing option list.
+func splitRouteOptions(raw string) (string, string) {
+	spec, options, _ := strings.Cut(strings.TrimSpace(raw), routeOptionSeparator)
+	return strings.TrimSpace(spec), options
+}
+
+// ruleTargetSeparator ends a rule's expression; the upstream after it is a name from upstream= or a HOST:PORT.
+const ruleTargetSeparator = "->"
+
+// applyRouteOptions parses key=value pairs into the route and rejects unknown keys so typos fail at startup.
+func applyRouteOptions(route *Route, raw string) error {
+	// Upstream names resolve once every option is read, so a rule may name an upstream defined after it.
+	upstreams := make(map[string]string)
+	var pendingRules []string
+	for _, option := range strings.Split(raw, routeOptionSeparator) {
+		key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "":
+			continue
+		case "handshake-timeout":
+			timeout, err := parseNonNegativeDuration(key, value)
+			if err != nil {
+				return err
+			}
+			route.HandshakeTimeout = timeout
+		case "http":
+			if value != "" {
+				return fmt.Errorf("route option 'http' takes no value")
+			}
+			route.HTTP = true
+		case "server-first":
+			if value != "" {
+				return fmt.Errorf("route option 'server-first' takes no value")
+			}
+			route.ServerFirst = true
+		case "compress":
+			// Only the link between two proxies is compressed, so the option names which side is the other proxy.
+			if value != "backend" && value != "client" {
+				return fmt.Errorf("route option 'compress' must be backend or client, got '%s'", value)
+			}
+			route.Compress = value
+		case "accept-proxy":
+			if value != "" {
+				return fmt.Errorf("route option 'accept-proxy' takes no value")
+			}
+			route.AcceptProxy = true
+		case "send-proxy":
+			if value != "v1" && value != "v2" {
+				return fmt.Errorf("route option 'send-proxy' must be v1 or v2, got '%s'", value)
+			}
+			route.SendProxy = value
+		case "target":
+			// Whether this bridges anything depends on the list the route lands in, so main checks that against the listener.
+			if value != "tcp" && value != "udp" {
+				return fmt.Errorf("route option 'target' must be tcp or udp, got '%s'", value)
+			}
+			route.TargetProtocol = value
+		case "check-send", "check-expect":
+			// Escapes such as \r\n, and \x3b or \x2c for the ; and , that separate options and routes, spell out protocol payloads.
+			payload, err := unescapePayload(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s '%s': %v", key, value, err)
+			}
+			if key == "check-send" {
+				route.CheckSend = payload
+			} else {
+				route.CheckExpect = payload
+			}
+		case "backup":
+			// Each backup= adds one standby, so their order on the line is their failover priority.
+			host, port, err := parseLegacyRemoteTarget(value)
+			if err != nil {
+				return fmt.Errorf("invalid backup '%s': %v", value, err)
+			}
+			route.Backups = strings.TrimSpace(route.Backups + " " + net.JoinHostPort(host, port))
+		case "upstream":
+			name, target, ok := strings.Cut(value, "@")
+			if !ok || !validUpstreamName(name) {
+				return fmt.Errorf("route option 'upstream' must be NAME@HOST:PORT with a name of letters, digits, - and _, got '%s'", value)
+			}
+			host, port, err := parseLegacyRemoteTarget(target)
+			if err != nil {
+				return fmt.Errorf("invalid upstream '%s': %v", value, err)
+			}
+			if _, exists := upstreams[name]; exists {
+				return fmt.Errorf("upstream '%s' is defined twice", name)
+			}
+			upstreams[name] = net.JoinHostPort(host, port)
+		case "rule":
+			pendingRules = append(pendingRules, value)
+		case "name":
+			if !validRouteName(value) {
+				return fmt.Errorf("route option 'name' must start with a letter and hold only letters, digits, - and _, got '%s'", value)
+			}
+			route.Name = value
+		case "after":
+			// Ports join with + as in LOCALPORT, because a comma would end the route; StartupOrder resolves names to ports.
+			for _, port := range strings.Split(value, "+") {
+				port = strings.TrimSpace(port)
+				if err := ValidatePort(port); err != nil && !validRouteName(port) {
+					return fmt.Errorf("invalid after '%s': %v", value, err)
+				}
+				if !containsField(route.After, port) {
+					route.After = strings.TrimSpace(route.After + " " + port)
+				}
+			}
+		case "wait-for-upstream":
+			route.WaitForUpstream = DefaultUpstreamWait
+			if value != "" {
+				timeout, err := parseNonNegativeDuration(key, value)
+				if err != nil {
+					return err
+				}
+				route.WaitForUpstream = timeout
+			}
+		case "schedule":
+			// Spaces separate the days, ranges, and zone, and neither ; nor , appears in them, so the value stays one option.
+			parsed, err := schedule.Parse(value, time.UTC)
+			if err != nil {
+				return fmt.Errorf("invalid schedule: %v", err)
+			}
+			route.Schedule = parsed.String()
+		default:
+			return fmt.Errorf("unknown route option '%s'", key)
+		}
+	}
+	if route.CheckSend != "" && route.CheckExpect == "" {
+		return fmt.Errorf("route option 'check-send' needs 'check-expect' with the reply to look for")
+	}
+	for _, value := range pendingRules {
+		line, err := parseRouteRule(value, upstreams)
+		if err != nil {
+			return err
+		}
+		route.Rules = strings.TrimPrefix(route.Rules+"\n"+line, "\n")
+	}
+	return nil
+}
+
+// parseRouteRule checks one rule=EXPR->UPSTREAM option and returns it in the canonical form Route.Rules keeps.
+func parseRouteRule(value string, upstreams map[string]string) (string, error) {
+	text, upstream, ok := strings.Cut(value, ruleTargetSeparator)
+	if !ok {
+		return "", fmt.Errorf("route option 'rule' must be EXPR%sUPSTREAM, got '%s'", ruleTargetSeparator, value)
+	}
+	expr, err := rules.Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid rule '%s': %v", value, err)
+	}
+	upstream = strings.TrimSpace(upstream)
+	target, named := upstreams[upstream]
+	if !named {
+		host, port, err := parseLegacyRemoteTarget(upstream)
+		if err != nil {
+			return "", fmt.Errorf("rule '%s' sends clients to '%s', which is neither an upstream= name nor HOST:PORT", value, upstream)
+		}
+		target = net.JoinHostPort(host, port)
+	}
+	return expr.String() + " " + ruleTargetSeparator + " " + target, nil
+}
+
+// validUpstreamName keeps names apart from addresses, so a rule's upstream is never ambiguous.
+func validUpstreamName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, char := range name {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' || char == '-' || char == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// validRouteName accepts upstream-style names that start with a letter, so after= can tell a name from a port.
+func validRouteName(name string) bool {
+	return validUpstreamName(name) && (name[0] >= 'a' && name[0] <= 'z' || name[0] >= 'A' && name[0] <= 'Z')
+}
+
+// String writes the route back in LOCALPORT:REMOTEIP:REMOTEPORT[;option=value] form, which ParseRoutes reads as the same route.
+func (route Route) String() string {
+	// Targets brackets IPv6 targets the way the route syntax expects them.
+	text := route.LocalPort + ":" + route.Targets()
+	for _, option := range route.Options() {
+		text += routeOptionSeparator + option
+	}
+	return text
+}
+
+// Options lists the route's options as option=value strings, in the order a route string would give them.
+// Upstream names do not survive parsing, so rules name their upstream by address, which the parser accepts as well.
+func (route Route) Options() []string {
+	var options []string
+	if route.Name != "" {
+		options = append(options, "name="+route.Name)
+	}
+	if route.HandshakeTimeout > 0 {
+		options = append(options, "handshake-timeout="+route.HandshakeTimeout.String())
+	}
+	if route.HTTP {
+		options = append(options, "http")
+	}
+	if route.ServerFirst {
+		options = append(options, "server-first")
+	}
+	if route.Compress != "" {
+		options = append(options, "compress="+route.Compress)
+	}
+	if route.AcceptProxy {
+		options = append(options, "accept-proxy")
+	}
+	if route.SendProxy != "" {
+		options = append(options, "send-proxy="+route.SendProxy)
+	}
+	if route.TargetProtocol != "" {
+		options = append(options, "target="+route.TargetProtocol)
+	}
+	for _, backup := range route.BackupAddresses() {
+		options = append(options, "backup="+backup)
+	}
+	if route.CheckSend != "" {
+		options = append(options, "check-send="+escapePayload(route.CheckSend))
+	}
+	if route.CheckExpect != "" {
+		options = append(options, "check-expect="+escapePayload(route.CheckExpect))
+	}
+	if route.Rules != "" {
+		for _, line := range strings.Split(route.Rules, "\n") {
+			options = append(options, "rule="+line)
+		}
+	}
+	if route.After != "" {
+		options = append(options, "after="+strings.Join(route.AfterPorts(), "+"))
+	}
+	if route.WaitForUpstream > 0 {
+		options = append(options, "wait-for-upstream="+route.WaitForUpstream.String())
+	}
+	if route.Schedule != "" {
+		options = append(options, "schedule="+route.Schedule)
+	}
+	return options
+}
+
+// escapePayload is the inverse of unescapePayload: it spells out control bytes, the ; and , that separate options and routes,
+// and spaces at either end, which option parsing would otherwise trim away.
+func escapePayload(payload string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(payload); {
+		char, size := utf8.DecodeRuneInString(payload[i:])
+		switch {
+		case char == '\\':
+			escaped.WriteString(`\\`)
+		case char == '\r':
+			escaped.WriteString(`\r`)
+		case char == '\n':
+			escaped.WriteString(`\n`)
+		case char == '\t':
+			escaped.WriteString(`\t`)
+		case char == ';' || char == ',' || char == ' ' && (i == 0 || i == len(payload)-1):
+			fmt.Fprintf(&escaped, `\x%02x`, char)
+		case char == utf8.RuneError && size == 1, char < ' ', char == 0x7f, char >= utf8.RuneSelf && !strconv.IsPrint(char):
+			for _, b := range []byte(payload[i : i+size]) {
+				fmt.Fprintf(&escaped, `\x%02x`, b)
+			}
+		default:
+			escaped.WriteString(payload[i : i+size])
+		}
+		i += size
+	}
+	return escaped.String()
+}
+
+// unescapePayload decodes Go string escapes so binary and line-based requests fit in a route string.
+func unescapePayload(value string) (string, error) {
+	var payload strings.Builder
+	for value != "" {
+		char, multibyte, rest, err := strconv.UnquoteChar(value, 0)
+		if err != nil {
+			return "", err
+		}
+		if char < utf8.RuneSelf || !multibyte {
+			payload.WriteByte(byte(char))
+		} else {
+			payload.WriteRune(char)
+		}
+		value = rest
+	}
+	return payload.String(), nil
+}
+
+func parseNonNegativeDuration(key, value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s': %v", key, value, err)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("%s cannot be negative", key)
+	}
+	return duration, nil
+}
@@ -3,20 +3,59 @@
 package config
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"net"
 	"net/netip"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/rules"
+	"github.com/matveynator/chicha-ip-proxy/pkg/schedule"
 )
 
 // Route describes a single forwarding rule.
 // Keeping it small keeps the configuration payload easy to pass across channels.
 type Route struct {
-	LocalPort  string // LocalPort is the port that should be opened locally.
-	RemoteIP   string // RemoteIP is the target host for forwarded traffic.
-	RemotePort string // RemotePort is the port on the target host.
+	Name             string        // Name replaces the derived ID in logs, metrics, and the admin API when set.
+	LocalPort        string        // LocalPort is the port that should be opened locally.
+	RemoteIP         string        // RemoteIP is the target host for forwarded traffic.
+	RemotePort       string        // RemotePort is the port on the target host.
+	HandshakeTimeout time.Duration // HandshakeTimeout overrides the global first-bytes deadline; zero keeps the default.
+	HTTP             bool          // HTTP marks a TCP route as plaintext HTTP/1.x so it can be access logged.
+	ServerFirst      bool          // ServerFirst marks a TCP route whose backend greets first, like SMTP, FTP, or MySQL.
+	Compress         string        // Compress is "backend" or "client": the side of a TCP route that is a paired proxy speaking deflate.
+	AcceptProxy      bool          // AcceptProxy makes a TCP route read the client address from a PROXY protocol header its load balancer sends.
+	SendProxy        string        // SendProxy is "v1" or "v2", the PROXY protocol header a TCP route sends its backend; empty sends none.
+	TargetProtocol   string        // TargetProtocol is "tcp" or "udp" when the backend speaks a different protocol than the listener; empty means the same.
+	CheckSend        string        // CheckSend is the request a synthetic health check sends; it may be empty for backends that greet first.
+	CheckExpect      string        // CheckExpect is what the synthetic check's reply must contain; empty means no synthetic check.
+	// Balance lists, space separated, the targets after RemoteIP:RemotePort that take new connections and sessions in turn with it.
+	// A string keeps Route comparable like Backups.
+	Balance string
+	// Backups lists standby TCP targets in priority order, space separated; a string keeps Route comparable for reloads.
+	Backups string
+	// Rules holds "EXPR -> HOST:PORT" lines, first match first, that send matching TCP clients to another upstream.
+	// They are canonical and already checked, so RuleList never fails; a string keeps Route comparable like Backups.
+	Rules string
+	// After lists, space separated, the local ports or route names whose routes must be serving before this one binds.
+	After string
+	// WaitForUpstream holds the bind until the backend accepts a TCP connection or this much time passes; zero binds at once.
+	WaitForUpstream time.Duration
+	// Schedule holds the canonical time windows the route accepts new clients in; empty serves at all times.
+	Schedule string
+}
+
+// ID names the route the same way in log lines, metric labels, /status, and /connections: its name= if set, else PROTOCOL/PORT.
+// Both halves of a named both route share the name, and the protocol field beside the ID tells them apart.
+func (route Route) ID(protocol string) string {
+	if route.Name != "" {
+		return route.Name
+	}
+	return protocol + "/" + route.LocalPort
 }
 
 // RemoteAddress returns the dialable remote endpoint for TCP and UDP workers.
@@ -25,6 +64,56 @@ func (route Route) RemoteAddress() string {
 	return net.JoinHostPort(route.RemoteIP, route.RemotePort)
 }
 
+// BalanceAddresses returns the targets that share a balanced route with RemoteAddress, in the order they take turns.
+func (route Route) BalanceAddresses() []string {
+	return strings.Fields(route.Balance)
+}
+
+// Targets writes the route's targets the way a route string gives them, joined by | when the route is balanced.
+func (route Route) Targets() string {
+	return strings.Join(append([]string{route.RemoteAddress()}, route.BalanceAddresses()...), targetSeparator)
+}
+
+// BackupAddresses returns the standby targets in the order they take over from the primary.
+func (route Route) BackupAddresses() []string {
+	return strings.Fields(route.Backups)
+}
+
+// AfterPorts returns the local ports and route names this route starts after.
+func (route Route) AfterPorts() []string {
+	return strings.Fields(route.After)
+}
+
+// ScheduleIn returns the route's schedule, evaluated in location unless it names its own zone, or nil when the route has none.
+// The text was checked when the route was parsed, so it does not fail here.
+func (route Route) ScheduleIn(location *time.Location) *schedule.Schedule {
+	if route.Schedule == "" {
+		return nil
+	}
+	parsed, err := schedule.Parse(route.Schedule, location)
+	if err != nil {
+		return nil
+	}
+	return parsed
+}
+
+// RuleList returns the route's upstream rules in the order they are tried.
+func (route Route) RuleList() []rules.Rule {
+	var list []rules.Rule
+	for _, line := range strings.Split(route.Rules, "\n") {
+		text, target, ok := strings.Cut(line, ruleTargetSeparator)
+		if !ok {
+			continue
+		}
+		expr, err := rules.Parse(text)
+		if err != nil {
+			continue
+		}
+		list = append(list, rules.Rule{Expr: expr, Target: strings.TrimSpace(target)})
+	}
+	return list
+}
+
 // SimpleRouteFlags carries the short public CLI form for one forwarding rule.
 type SimpleRouteFlags struct {
 	Local  string
@@ -38,7 +127,9 @@ type AllowList struct {
 	Prefixes []netip.Prefix
 }
 
-// ParseRoutes splits a flag string in the form LOCALPORT:REMOTEIP:REMOTEPORT into Route values.
+// ParseRoutes splits a flag string in the form LOCALPORT:REMOTEIP:REMOTEPORT[;option=value] into Route values.
+// LOCALPORT may list several ports joined by +, e.g. 8080+8081:10.0.0.1:80, which yields one route per port with the same target.
+// The target may list several backends joined by |, e.g. 8080:10.0.0.1:80|10.0.0.2:80, which balances the route over them.
 // Returning a slice keeps the main package free from parsing details while following Go's preference for simple data flows.
 func ParseRoutes(routesFlag string) ([]Route, error) {
 	if routesFlag == "" {
@@ -49,16 +140,52 @@ func ParseRoutes(routesFlag string) ([]Route, error) {
 	routes := make([]Route, 0, len(parts))
 
 	for _, part := range parts {
-		route, err := parseLegacyRoute(part)
+		expanded, err := parseLegacyRoute(part)
 		if err != nil {
 			return nil, err
 		}
-		routes = append(routes, route)
+		routes = append(routes, expanded...)
 	}
 
 	return routes, nil
 }
 
+// ParseForwardRoutes parses the unified -forward syntax where every entry names its protocol,
+// e.g. tcp/8080:10.0.0.1:80,udp/5353:10.0.0.2:53, and splits the result into TCP and UDP slices.
+// A both/ entry lands in both slices, which suits protocols such as DNS that use either transport on one port.
+// It layers a protocol prefix over the legacy route parser so both syntaxes accept the same targets and options.
+func ParseForwardRoutes(forwardFlag string) ([]Route, []Route, error) {
+	if forwardFlag == "" {
+		return nil, nil, nil
+	}
+
+	var tcpRoutes, udpRoutes []Route
+	for _, part := range strings.Split(forwardFlag, ",") {
+		protocol, rawRoute, ok := strings.Cut(strings.TrimSpace(part), "/")
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid forward entry '%s' (expected tcp/, udp/, or both/ prefix)", part)
+		}
+
+		expanded, err := parseLegacyRoute(rawRoute)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch strings.ToLower(protocol) {
+		case "tcp":
+			tcpRoutes = append(tcpRoutes, expanded...)
+		case "udp":
+			udpRoutes = append(udpRoutes, expanded...)
+		case "both":
+			tcpRoutes = append(tcpRoutes, expanded...)
+			udpRoutes = append(udpRoutes, expanded...)
+		default:
+			return nil, nil, fmt.Errorf("invalid protocol '%s' in forward entry '%s' (expected tcp, udp, or both)", protocol, part)
+		}
+	}
+	return tcpRoutes, udpRoutes, nil
+}
+
 // ParseSimpleRoute converts -local, -remote, and -proto into TCP or UDP route slices.
 // The short form intentionally covers the common one-port setup while legacy route flags keep multi-route compatibility.
 func ParseSimpleRoute(flags SimpleRouteFlags) ([]Route, []Route, bool, error) {
@@ -82,8 +209,8 @@ func ParseSimpleRoute(flags SimpleRouteFlags) ([]Route, []Route, bool, error) {
 	if protocol == "" {
 		protocol = "tcp"
 	}
-	if protocol != "tcp" && protocol != "udp" {
-		return nil, nil, true, fmt.Errorf("-proto must be tcp or udp")
+	if protocol != "tcp" && protocol != "udp" && protocol != "both" {
+		return nil, nil, true, fmt.Errorf("-proto must be tcp, udp, or both")
 	}
 
 	remoteIP, remotePort, err := parseRemoteTarget(flags.Remote, flags.Local)
@@ -92,8 +219,11 @@ func ParseSimpleRoute(flags SimpleRouteFlags) ([]Route, []Route, bool, error) {
 	}
 
 	route := Route{LocalPort: flags.Local, RemoteIP: remoteIP, RemotePort: remotePort}
-	if protocol == "udp" {
+	switch protocol {
+	case "udp":
 		return nil, []Route{route}, true, nil
+	case "both":
+		return []Route{route}, []Route{route}, true, nil
 	}
 	return []Route{route}, nil, true, nil
 }
@@ -110,6 +240,20 @@ func ValidatePort(port string) error {
 	return nil
 }
 
+// ParseListenHost validates the -listen-addr IP that routes bind; brackets around IPv6 are optional and empty means all interfaces.
+// Only literal IPs are accepted because a hostname could resolve to an address this host does not own.
+func ParseListenHost(raw string) (string, error) {
+	host := strings.Trim(strings.TrimSpace(raw), "[]")
+	if host == "" {
+		return "", nil
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address '%s': %v", raw, err)
+	}
+	return addr.String(), nil
+}
+
 // ParseAllowList accepts exact IP addresses and CIDR ranges from repeated -allow flags.
 // Normalizing here lets proxy workers make fast yes/no decisions without parsing per packet.
 func ParseAllowList(values []string) (AllowList, error) {
@@ -183,20 +327,66 @@ func parseAllowPrefix(raw string) (netip.Prefix, error) {
 	return netip.PrefixFrom(addr, addr.BitLen()), nil
 }
 
-func parseLegacyRoute(raw string) (Route, error) {
-	localPort, remoteTarget, ok := strings.Cut(strings.TrimSpace(raw), ":")
-	if !ok || localPort == "" || remoteTarget == "" {
-		return Route{}, fmt.Errorf("invalid route format: '%s' (expected LOCALPORT:REMOTEIP:REMOTEPORT)", raw)
+// localPortSeparator joins several local ports of one route entry; a comma would end the entry in -routes and -forward.
+const localPortSeparator = "+"
+
+// targetSeparator joins the targets of a balanced route; it never appears in a port or an IP literal, IPv6 included.
+const targetSeparator = "|"
+
+// parseLegacyRoute parses one route entry into one route per local port, all sharing the target and options.
+func parseLegacyRoute(raw string) ([]Route, error) {
+	spec, options := splitRouteOptions(raw)
+	localPorts, remoteTarget, ok := strings.Cut(spec, ":")
+	if !ok || localPorts == "" || remoteTarget == "" {
+		return nil, fmt.Errorf("invalid route format: '%s' (expected LOCALPORT:REMOTEIP:REMOTEPORT)", raw)
 	}
-	if err := ValidatePort(localPort); err != nil {
-		return Route{}, fmt.Errorf("invalid local port in route '%s': %v", raw, err)
+	ports := strings.Split(localPorts, localPortSeparator)
+	seen := make(map[string]bool, len(ports))
+	for _, port := range ports {
+		if err := ValidatePort(port); err != nil {
+			return nil, fmt.Errorf("invalid local port in route '%s': %v", raw, err)
+		}
+		if seen[port] {
+			return nil, fmt.Errorf("local port %s is listed twice in route '%s'", port, raw)
+		}
+		seen[port] = true
 	}
 
-	remoteIP, remotePort, err := parseLegacyRemoteTarget(remoteTarget)
+	targets := strings.Split(remoteTarget, targetSeparator)
+	remoteIP, remotePort, err := parseLegacyRemoteTarget(targets[0])
 	if err != nil {
-		return Route{}, fmt.Errorf("invalid remote target in route '%s': %v", raw, err)
+		return nil, fmt.Errorf("invalid remote target in route '%s': %v", raw, err)
+	}
+
+	route := Route{RemoteIP: remoteIP, RemotePort: remotePort}
+	// Balanced targets are stored canonical, so a route string and its String() compare equal on reload.
+	balanced := map[string]bool{route.RemoteAddress(): true}
+	var balance []string
+	for _, target := range targets[1:] {
+		ip, port, err := parseLegacyRemoteTarget(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote target in route '%s': %v", raw, err)
+		}
+		address := net.JoinHostPort(ip, port)
+		if balanced[address] {
+			return nil, fmt.Errorf("target %s is listed twice in route '%s'", address, raw)
+		}
+		balanced[address] = true
+		balance = append(balance, address)
+	}
+	route.Balance = strings.Join(balance, " ")
+	if err := applyRouteOptions(&route, options); err != nil {
+		return nil, fmt.Errorf("invalid options in route '%s': %v", raw, err)
 	}
-	return Route{LocalPort: localPort, RemoteIP: remoteIP, RemotePort: remotePort}, nil
+	if route.Name != "" && len(ports) > 1 {
+		return nil, fmt.Errorf("invalid options in route '%s': name=%s needs a single local port, because each port is its own route", raw, route.Name)
+	}
+	routes := make([]Route, 0, len(ports))
+	for _, port := range ports {
+		route.LocalPort = port
+		routes = append(routes, route)
+	}
+	return routes, nil
 }
 
 func parseLegacyRemoteTarget(remoteTarget string) (string, string, error) {
@@ -278,3 +468,25 @@ func validateRemoteIP(remoteIP string) error {
 	}
 	return nil
 }
+
+// ReadRouteList turns piped route entries into the comma-separated -routes syntax.
+// Entries may be separated by newlines or commas; blank lines and # comments are skipped so generators can annotate output.
+func ReadRouteList(reader io.Reader) (string, error) {
+	var entries []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, entry := range strings.Split(line, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read routes: %v", err)
+	}
+	return strings.Join(entries, ","), nil
+}
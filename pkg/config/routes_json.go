@@ -0,0 +1,107 @@
+// -routes-json takes routes as a JSON array for programs that start the proxy, since generating the colon syntax safely is awkward.
+// Each field is a separate value, so IPv6 targets need no brackets and nothing has to be escaped.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// JSONRoute is one element of the -routes-json array.
+type JSONRoute struct {
+	Local      jsonPort `json:"local"`
+	RemoteIP   string   `json:"remote_ip"`
+	RemotePort jsonPort `json:"remote_port"`
+	Proto      string   `json:"proto"` // Proto is tcp, udp, or both; empty means tcp, as with -proto.
+	Name       string   `json:"name"`  // Name is the optional route name, as with ;name=.
+}
+
+// jsonPort accepts a port as a JSON string or number, because callers serialize ports either way.
+type jsonPort string
+
+func (port *jsonPort) UnmarshalJSON(data []byte) error {
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err == nil {
+		*port = jsonPort(number.String())
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("port must be a string or a number, got %s", data)
+	}
+	*port = jsonPort(text)
+	return nil
+}
+
+// ParseRoutesJSON decodes a -routes-json array into TCP and UDP routes; a both route lands in each.
+// Unknown fields are rejected like in config files, and every element is checked before any route is returned.
+func ParseRoutesJSON(raw string) ([]Route, []Route, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	decoder.DisallowUnknownFields()
+	var entries []JSONRoute
+	if err := decoder.Decode(&entries); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if decoder.More() {
+		return nil, nil, fmt.Errorf("invalid JSON: unexpected data after the route array")
+	}
+
+	var tcpRoutes, udpRoutes []Route
+	seen := map[string]bool{}
+	for i, entry := range entries {
+		route, protocol, err := entry.route()
+		if err != nil {
+			return nil, nil, fmt.Errorf("route %d: %v", i, err)
+		}
+		for _, network := range []string{"tcp", "udp"} {
+			if protocol != network && protocol != "both" {
+				continue
+			}
+			if seen[network+"/"+route.LocalPort] {
+				return nil, nil, fmt.Errorf("route %d: %s local port %s is used by more than one route", i, network, route.LocalPort)
+			}
+			seen[network+"/"+route.LocalPort] = true
+			if network == "tcp" {
+				tcpRoutes = append(tcpRoutes, route)
+			} else {
+				udpRoutes = append(udpRoutes, route)
+			}
+		}
+	}
+	return tcpRoutes, udpRoutes, nil
+}
+
+// route validates the entry with the same rules as the colon syntax and returns it with its lowercased protocol.
+func (entry JSONRoute) route() (Route, string, error) {
+	for _, field := range []struct{ name, value string }{{"local", string(entry.Local)}, {"remote_ip", entry.RemoteIP}, {"remote_port", string(entry.RemotePort)}} {
+		if strings.TrimSpace(field.value) == "" {
+			return Route{}, "", fmt.Errorf("missing %s", field.name)
+		}
+	}
+	if err := ValidatePort(string(entry.Local)); err != nil {
+		return Route{}, "", fmt.Errorf("invalid local port '%s': %v", entry.Local, err)
+	}
+	if err := ValidatePort(string(entry.RemotePort)); err != nil {
+		return Route{}, "", fmt.Errorf("invalid remote_port '%s': %v", entry.RemotePort, err)
+	}
+	if err := validateRemoteIP(entry.RemoteIP); err != nil {
+		return Route{}, "", err
+	}
+	protocol := strings.ToLower(strings.TrimSpace(entry.Proto))
+	switch protocol {
+	case "":
+		protocol = "tcp"
+	case "tcp", "udp", "both":
+	default:
+		return Route{}, "", fmt.Errorf("invalid proto '%s' (expected tcp, udp, or both)", entry.Proto)
+	}
+	if entry.Name != "" && !validRouteName(entry.Name) {
+		return Route{}, "", fmt.Errorf("invalid name '%s' (expected a letter followed by letters, digits, - and _)", entry.Name)
+	}
+	return Route{Name: entry.Name, LocalPort: string(entry.Local), RemoteIP: entry.RemoteIP, RemotePort: string(entry.RemotePort)}, protocol, nil
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRoutesJSONReadsEachProtocol(t *testing.T) {
+	tcpRoutes, udpRoutes, err := ParseRoutesJSON(`[
+		{"local": "8080", "remote_ip": "::1", "remote_port": "80", "proto": "tcp"},
+		{"local": 5353, "remote_ip": "203.0.113.20", "remote_port": 53, "proto": "udp"},
+		{"local": "53", "remote_ip": "203.0.113.53", "remote_port": "53", "proto": "both"},
+		{"local": "9000", "remote_ip": "203.0.113.9", "remote_port": "90", "name": "api"}
+	]`)
+	if err != nil {
+		t.Fatalf("ParseRoutesJSON returned error: %v", err)
+	}
+	if len(tcpRoutes) != 3 || len(udpRoutes) != 2 {
+		t.Fatalf("route counts = tcp %d udp %d, want 3 and 2", len(tcpRoutes), len(udpRoutes))
+	}
+	if tcpRoutes[0] != (Route{LocalPort: "8080", RemoteIP: "::1", RemotePort: "80"}) || tcpRoutes[2].LocalPort != "9000" || tcpRoutes[2].Name != "api" {
+		t.Fatalf("TCP routes = %#v", tcpRoutes)
+	}
+	if udpRoutes[0].LocalPort != "5353" || udpRoutes[0].RemotePort != "53" || udpRoutes[1].LocalPort != "53" {
+		t.Fatalf("UDP routes = %#v", udpRoutes)
+	}
+}
+
+func TestParseRoutesJSONRejectsInvalidContent(t *testing.T) {
+	tests := map[string]struct{ content, want string }{
+		"malformed JSON":    {`[{"local": "8080"`, "invalid JSON"},
+		"not an array":      {`{"local": "8080", "remote_ip": "::1", "remote_port": "80"}`, "invalid JSON"},
+		"trailing data":     {`[] []`, "unexpected data"},
+		"unknown field":     {`[{"local": "8080", "remote_ip": "::1", "remote_port": "80", "target": "x"}]`, "unknown field"},
+		"missing local":     {`[{"remote_ip": "::1", "remote_port": "80"}]`, "route 0: missing local"},
+		"missing remote_ip": {`[{"local": "8080", "remote_ip": "::1", "remote_port": "80"}, {"local": "8081", "remote_port": "80"}]`, "route 1: missing remote_ip"},
+		"missing port":      {`[{"local": "8080", "remote_ip": "::1"}]`, "route 0: missing remote_port"},
+		"bad port":          {`[{"local": "70000", "remote_ip": "::1", "remote_port": "80"}]`, "invalid local port"},
+		"bad port type":     {`[{"local": true, "remote_ip": "::1", "remote_port": "80"}]`, "string or a number"},
+		"bad remote":        {`[{"local": "8080", "remote_ip": "example.com", "remote_port": "80"}]`, "route 0"},
+		"bad name":          {`[{"local": "8080", "remote_ip": "::1", "remote_port": "80", "name": "8080"}]`, "invalid name"},
+		"bad proto":         {`[{"local": "8080", "remote_ip": "::1", "remote_port": "80", "proto": "sctp"}]`, "invalid proto"},
+		"duplicate port":    {`[{"local": "53", "remote_ip": "::1", "remote_port": "53", "proto": "udp"}, {"local": "53", "remote_ip": "::2", "remote_port": "53", "proto": "both"}]`, "udp local port 53"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := ParseRoutesJSON(test.content)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("ParseRoutesJSON(%s) error = %v, want one containing %q", test.content, err, test.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,10 @@ package config
 
 import (
 	"net/netip"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseAllowListAcceptsIPsAndCIDRs(t *testing.T) {
@@ -50,6 +53,18 @@ func TestParseAllowListRejectsInvalidEntries(t *testing.T) {
 	}
 }
 
+func TestParseListenHostNormalizesIPs(t *testing.T) {
+	for raw, want := range map[string]string{"": "", " 10.0.0.5 ": "10.0.0.5", "[2001:db8::5]": "2001:db8::5", "::ffff:10.0.0.5": "::ffff:10.0.0.5"} {
+		got, err := ParseListenHost(raw)
+		if err != nil || got != want {
+			t.Fatalf("ParseListenHost(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+	if _, err := ParseListenHost("mgmt.example.com"); err == nil {
+		t.Fatal("ParseListenHost accepted a hostname")
+	}
+}
+
 func TestParseSimpleRouteCreatesDefaultTCPRoute(t *testing.T) {
 	tcpRoutes, udpRoutes, used, err := ParseSimpleRoute(SimpleRouteFlags{
 		Local:  "8080",
@@ -189,3 +204,315 @@ func TestParseSimpleRouteIgnoresOnlyProto(t *testing.T) {
 		t.Fatal("ParseSimpleRoute should not treat -proto alone as a route")
 	}
 }
+
+func TestParseRoutesAcceptsRouteOptions(t *testing.T) {
+	routes, err := ParseRoutes("8080:203.0.113.10:80;handshake-timeout=5s;http,2525:203.0.113.10:25;server-first")
+	if err != nil {
+		t.Fatalf("ParseRoutes returned error: %v", err)
+	}
+	if routes[0].HandshakeTimeout != 5*time.Second {
+		t.Fatalf("first route handshake timeout = %v, want 5s", routes[0].HandshakeTimeout)
+	}
+	if routes[0].RemotePort != "80" || !routes[0].HTTP {
+		t.Fatalf("first route = %#v", routes[0])
+	}
+	if routes[1].HandshakeTimeout != 0 || routes[1].HTTP {
+		t.Fatalf("second route handshake timeout = %v, want 0", routes[1].HandshakeTimeout)
+	}
+	if routes[0].ServerFirst || !routes[1].ServerFirst {
+		t.Fatalf("server-first = %v, %v; want only the second route", routes[0].ServerFirst, routes[1].ServerFirst)
+	}
+}
+
+func TestParseRoutesKeepsBackupsInPriorityOrder(t *testing.T) {
+	routes, err := ParseRoutes("8080:203.0.113.10:80;backup=203.0.113.11:80;backup=[2001:db8::12]:8080")
+	if err != nil {
+		t.Fatalf("ParseRoutes returned error: %v", err)
+	}
+	want := []string{"203.0.113.11:80", "[2001:db8::12]:8080"}
+	if got := routes[0].BackupAddresses(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("BackupAddresses = %#v, want %#v", got, want)
+	}
+}
+
+func TestParseRoutesReadsBalancedTargets(t *testing.T) {
+	routes, err := ParseRoutes("8080+8081:10.0.0.1:80|[2001:db8::2]:8080|10.0.0.3:80;http")
+	if err != nil {
+		t.Fatalf("ParseRoutes returned error: %v", err)
+	}
+	want := []string{"[2001:db8::2]:8080", "10.0.0.3:80"}
+	for _, route := range routes {
+		if route.RemoteAddress() != "10.0.0.1:80" || !reflect.DeepEqual(route.BalanceAddresses(), want) || !route.HTTP {
+			t.Fatalf("balanced route = %#v", route)
+		}
+	}
+	if got := routes[1].String(); got != "8081:10.0.0.1:80|[2001:db8::2]:8080|10.0.0.3:80;http" {
+		t.Fatalf("String = %q", got)
+	}
+}
+
+func TestParseRoutesReadsCompressSide(t *testing.T) {
+	routes, err := ParseRoutes("8080:203.0.113.10:9000;compress=backend,9000:127.0.0.1:80;compress=client,8081:203.0.113.10:80")
+	if err != nil {
+		t.Fatalf("ParseRoutes returned error: %v", err)
+	}
+	if routes[0].Compress != "backend" || routes[1].Compress != "client" || routes[2].Compress != "" {
+		t.Fatalf("compress = %q, %q, %q", routes[0].Compress, routes[1].Compress, routes[2].Compress)
+	}
+}
+
+func TestParseRoutesReadsProxyProtocolOptions(t *testing.T) {
+	routes, err := ParseRoutes("443:203.0.113.10:443;accept-proxy;send-proxy=v2,8443:203.0.113.10:443;send-proxy=v1,8080:203.0.113.10:80")
+	if err != nil {
+		t.Fatalf("ParseRoutes returned error: %v", err)
+	}
+	if !routes[0].AcceptProxy || routes[0].SendProxy != "v2" || routes[1].AcceptProxy || routes[1].SendProxy != "v1" || routes[2].SendProxy != "" {
+		t.Fatalf("PROXY protocol options = %#v", routes)
+	}
+}
+
+func TestParseRoutesReadsTargetProtocol(t *testing.T) {
+	routes, err := ParseRoutes("5353:203.0.113.10:53;target=udp,5354:203.0.113.10:53")
+	if err != nil {
+		t.Fatalf("ParseRoutes returned error: %v", err)
+	}
+	if routes[0].TargetProtocol != "udp" || routes[1].TargetProtocol != "" {
+		t.Fatalf("target protocols = %q, %q", routes[0].TargetProtocol, routes[1].TargetProtocol)
+	}
+}
+
+func TestParseRoutesDecodesSyntheticCheckPayloads(t *testing.T) {
+	routes, err := ParseRoutes(`6379:203.0.113.10:6379;check-send=PING\r\n;check-expect=+PONG,2525:203.0.113.10:25;check-expect=220\x20`)
+	if err != nil {
+		t.Fatalf("ParseRoutes returned error: %v", err)
+	}
+	if routes[0].CheckSend != "PING\r\n" || routes[0].CheckExpect != "+PONG" {
+		t.Fatalf("first route check = %q / %q", routes[0].CheckSend, routes[0].CheckExpect)
+	}
+	if routes[1].CheckSend != "" || routes[1].CheckExpect != "220 " {
+		t.Fatalf("greeting check = %q / %q, want nothing sent and \"220 \" expected", routes[1].CheckSend, routes[1].CheckExpect)
+	}
+}
+
+func TestParseRoutesExpandsJoinedLocalPorts(t *testing.T) {
+	routes, err := ParseRoutes("8080+8081+8082:10.0.0.1:80;http,9000:10.0.0.2:90")
+	if err != nil {
+		t.Fatalf("ParseRoutes returned error: %v", err)
+	}
+	if len(routes) != 4 {
+		t.Fatalf("route count = %d, want 4", len(routes))
+	}
+	for i, port := range []string{"8080", "8081", "8082"} {
+		route := routes[i]
+		if route.LocalPort != port || route.RemoteIP != "10.0.0.1" || route.RemotePort != "80" || !route.HTTP {
+			t.Fatalf("route %d = %#v, want %s to 10.0.0.1:80 with http", i, route, port)
+		}
+	}
+	if routes[3].LocalPort != "9000" || routes[3].HTTP {
+		t.Fatalf("last route = %#v", routes[3])
+	}
+}
+
+func TestParseRoutesRejectsInvalidJoinedLocalPorts(t *testing.T) {
+	for _, raw := range []string{
+		"8080++8081:10.0.0.1:80",
+		"+8080:10.0.0.1:80",
+		"8080+:10.0.0.1:80",
+		"8080+8080:10.0.0.1:80",
+		"8080+70000:10.0.0.1:80",
+	} {
+		if _, err := ParseRoutes(raw); err == nil {
+			t.Fatalf("ParseRoutes(%q) accepted invalid local ports", raw)
+		}
+	}
+}
+
+func TestParseRoutesReadsUpstreamRulesInOrder(t *testing.T) {
+	routes, err := ParseRoutes("8080:10.0.0.1:80;rule=ip in 10.1.0.0/16 -> office;upstream=office@10.0.0.9:80;rule=hour in 22-6->[2001:db8::1]:8080")
+	if err != nil {
+		t.Fatalf("ParseRoutes returned error: %v", err)
+	}
+	want := "ip in 10.1.0.0/16 -> 10.0.0.9:80\nhour in 22-6 -> [2001:db8::1]:8080"
+	if routes[0].Rules != want {
+		t.Fatalf("Rules = %q, want %q", routes[0].Rules, want)
+	}
+	list := routes[0].RuleList()
+	if len(list) != 2 || list[0].Target != "10.0.0.9:80" || list[1].Target != "[2001:db8::1]:8080" || list[1].Expr.String() != "hour in 22-6" {
+		t.Fatalf("RuleList = %#v", list)
+	}
+
+	plain, err := ParseRoutes("8080:10.0.0.1:80;upstream=unused@10.0.0.9:80")
+	if err != nil {
+		t.Fatalf("ParseRoutes returned error: %v", err)
+	}
+	if plain[0].Rules != "" || len(plain[0].RuleList()) != 0 {
+		t.Fatalf("route without rules = %#v", plain[0])
+	}
+}
+
+func TestRouteStringParsesBackToTheSameRoute(t *testing.T) {
+	routes, err := ParseRoutes("8080:[2001:db8::10]:80;handshake-timeout=5s;http;backup=10.0.0.2:80;backup=10.0.0.3:80;rule=port < 1024 -> edge;upstream=edge@10.0.0.9:80," +
+		`2525:10.0.0.1:25;name=mail;server-first;check-send=\x20HELO a\x2cb\x3b\r\n\\\x00;check-expect=250 \xff,` +
+		"443:10.0.0.4:443|10.0.0.6:443;accept-proxy;send-proxy=v1,9000:10.0.0.5:90;name=api;after=8080+mail;wait-for-upstream=5s;schedule=MON-FRI 09:00-18:00  Europe/Moscow")
+	if err != nil {
+		t.Fatalf("ParseRoutes returned error: %v", err)
+	}
+	for _, route := range routes {
+		again, err := ParseRoutes(route.String())
+		if err != nil {
+			t.Fatalf("ParseRoutes(%q) returned error: %v", route.String(), err)
+		}
+		if len(again) != 1 || again[0] != route {
+			t.Fatalf("%q parsed as %#v, want %#v", route.String(), again, route)
+		}
+	}
+}
+
+func TestRouteScheduleKeepsItsZoneOrUsesTheDefault(t *testing.T) {
+	routes, err := ParseRoutes("8080:10.0.0.1:80;schedule=Mon-Fri 09:00-18:00 Europe/Moscow,8081:10.0.0.1:80;schedule=09:00-18:00,8082:10.0.0.1:80")
+	if err != nil {
+		t.Fatalf("ParseRoutes returned error: %v", err)
+	}
+	if routes[0].Schedule != "mon-fri 09:00-18:00 Europe/Moscow" {
+		t.Fatalf("Schedule = %q, want the canonical text", routes[0].Schedule)
+	}
+	if zone := routes[0].ScheduleIn(time.UTC).Location().String(); zone != "Europe/Moscow" {
+		t.Fatalf("zoned schedule evaluates in %s, want Europe/Moscow", zone)
+	}
+	if routes[1].ScheduleIn(time.UTC).Location() != time.UTC {
+		t.Fatal("schedule without a zone ignored the default")
+	}
+	if routes[2].ScheduleIn(time.UTC) != nil {
+		t.Fatal("route without a schedule returned one")
+	}
+}
+
+func TestRouteIDPrefersNameOverProtocolAndPort(t *testing.T) {
+	routes, err := ParseRoutes("8080:10.0.0.1:80,53:10.0.0.2:53;name=dns-eu")
+	if err != nil {
+		t.Fatalf("ParseRoutes returned error: %v", err)
+	}
+	if id := routes[0].ID("tcp"); id != "tcp/8080" {
+		t.Fatalf("unnamed ID = %q, want tcp/8080", id)
+	}
+	if id := routes[0].ID("udp"); id != "udp/8080" {
+		t.Fatalf("unnamed UDP ID = %q, want udp/8080", id)
+	}
+	if routes[1].Name != "dns-eu" || routes[1].ID("udp") != "dns-eu" {
+		t.Fatalf("named route = %#v, want ID dns-eu", routes[1])
+	}
+}
+
+func TestParseRoutesRejectsInvalidRouteOptions(t *testing.T) {
+	for _, raw := range []string{
+		"8080:203.0.113.10:80;handshake-timeout=soon",
+		"8080:203.0.113.10:80;handshake-timeout=-1s",
+		"8080:203.0.113.10:80;no-such-option=1",
+		"8080:203.0.113.10:80;http=yes",
+		"2525:203.0.113.10:25;server-first=true",
+		"8080:203.0.113.10:80;backup=203.0.113.11",
+		"8080:203.0.113.10:80;compress",
+		"8080:203.0.113.10:80;compress=zstd",
+		"8080:203.0.113.10:80|",
+		"8080:203.0.113.10:80|203.0.113.11",
+		"8080:203.0.113.10:80|203.0.113.11:80|203.0.113.10:80",
+		"8080:203.0.113.10:80;accept-proxy=v2",
+		"8080:203.0.113.10:80;send-proxy",
+		"8080:203.0.113.10:80;send-proxy=v3",
+		"5353:203.0.113.10:53;target=sctp",
+		"6379:203.0.113.10:6379;check-send=PING",
+		"6379:203.0.113.10:6379;check-expect=\\q",
+		"8080:203.0.113.10:80;rule=ip in 10.0.0.0/8",
+		"8080:203.0.113.10:80;rule=ip in 10.0.0.0/8 -> missing",
+		"8080:203.0.113.10:80;rule=country == nl -> 203.0.113.11:80",
+		"8080:203.0.113.10:80;upstream=203.0.113.11:80",
+		"8080:203.0.113.10:80;upstream=a.b@203.0.113.11:80",
+		"8080:203.0.113.10:80;upstream=office@203.0.113.11:80;upstream=office@203.0.113.12:80",
+		"8080:203.0.113.10:80;after=70000",
+		"8080:203.0.113.10:80;after=9000+",
+		"8080:203.0.113.10:80;wait-for-upstream=-1s",
+		"8080:203.0.113.10:80;schedule=",
+		"8080:203.0.113.10:80;schedule=mon-fri 09:00",
+		"8080:203.0.113.10:80;schedule=09:00-18:00 Nowhere/City",
+		"8080:203.0.113.10:80;name=",
+		"8080:203.0.113.10:80;name=8080",
+		"8080:203.0.113.10:80;name=web.eu",
+		"8080+8081:203.0.113.10:80;name=web",
+	} {
+		if _, err := ParseRoutes(raw); err == nil {
+			t.Fatalf("ParseRoutes(%q) accepted invalid options", raw)
+		}
+	}
+}
+
+func TestParseForwardRoutesSplitsMixedProtocols(t *testing.T) {
+	tcpRoutes, udpRoutes, err := ParseForwardRoutes("tcp/8080:10.0.0.1:80, udp/5353:10.0.0.2:53,TCP/8443:[2001:db8::10]:443")
+	if err != nil {
+		t.Fatalf("ParseForwardRoutes returned error: %v", err)
+	}
+	if len(tcpRoutes) != 2 || len(udpRoutes) != 1 {
+		t.Fatalf("route counts = tcp %d udp %d, want 2 and 1", len(tcpRoutes), len(udpRoutes))
+	}
+	if tcpRoutes[0].LocalPort != "8080" || tcpRoutes[1].RemoteIP != "2001:db8::10" {
+		t.Fatalf("TCP routes = %#v", tcpRoutes)
+	}
+	if udpRoutes[0].LocalPort != "5353" || udpRoutes[0].RemotePort != "53" {
+		t.Fatalf("UDP route = %#v", udpRoutes[0])
+	}
+}
+
+func TestBothProtocolRoutesLandOnEachTransport(t *testing.T) {
+	tcpRoutes, udpRoutes, err := ParseForwardRoutes("both/53:10.0.0.2:53")
+	if err != nil {
+		t.Fatalf("ParseForwardRoutes returned error: %v", err)
+	}
+	if len(tcpRoutes) != 1 || len(udpRoutes) != 1 || tcpRoutes[0] != udpRoutes[0] {
+		t.Fatalf("both/ routes = tcp %#v udp %#v, want the same route once each", tcpRoutes, udpRoutes)
+	}
+
+	tcpRoutes, udpRoutes, _, err = ParseSimpleRoute(SimpleRouteFlags{Local: "53", Remote: "10.0.0.2", Proto: "both"})
+	if err != nil {
+		t.Fatalf("ParseSimpleRoute returned error: %v", err)
+	}
+	if len(tcpRoutes) != 1 || len(udpRoutes) != 1 {
+		t.Fatalf("-proto=both routes = tcp %d udp %d, want 1 and 1", len(tcpRoutes), len(udpRoutes))
+	}
+}
+
+func TestParseForwardRoutesExpandsJoinedLocalPortsOnBothTransports(t *testing.T) {
+	tcpRoutes, udpRoutes, err := ParseForwardRoutes("both/53+5353:10.0.0.1:53")
+	if err != nil {
+		t.Fatalf("ParseForwardRoutes returned error: %v", err)
+	}
+	if len(tcpRoutes) != 2 || len(udpRoutes) != 2 {
+		t.Fatalf("route counts = tcp %d udp %d, want 2 and 2", len(tcpRoutes), len(udpRoutes))
+	}
+	if tcpRoutes[1].LocalPort != "5353" || udpRoutes[0].LocalPort != "53" {
+		t.Fatalf("routes = %#v / %#v", tcpRoutes, udpRoutes)
+	}
+}
+
+func TestParseForwardRoutesRejectsInvalidProtocolPrefix(t *testing.T) {
+	for _, raw := range []string{
+		"sctp/8080:10.0.0.1:80",
+		"8080:10.0.0.1:80",
+		"tcp/8080:not-an-ip:80",
+	} {
+		if _, _, err := ParseForwardRoutes(raw); err == nil {
+			t.Fatalf("ParseForwardRoutes(%q) accepted invalid input", raw)
+		}
+	}
+}
+
+func TestReadRouteListAcceptsNewlinesCommasAndComments(t *testing.T) {
+	routes, err := ReadRouteList(strings.NewReader("# generated\n8080:203.0.113.10:80\n\n 8443:203.0.113.10:443;handshake-timeout=5s, 9000:203.0.113.9:90\n"))
+	if err != nil {
+		t.Fatalf("ReadRouteList returned error: %v", err)
+	}
+	if want := "8080:203.0.113.10:80,8443:203.0.113.10:443;handshake-timeout=5s,9000:203.0.113.9:90"; routes != want {
+		t.Fatalf("ReadRouteList = %q, want %q", routes, want)
+	}
+	if _, err := ParseRoutes(routes); err != nil {
+		t.Fatalf("ParseRoutes rejected piped routes: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+// Sealed config files keep route lists, and any secrets added to them later, encrypted at rest.
+// They use AES-256-GCM from the standard library, so the proxy stays free of third-party crypto and a tampered file fails to open.
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// KeyEnv names the environment variable that may carry the config key instead of -config-key-file.
+const KeyEnv = "CHICHA_IP_PROXY_CONFIG_KEY"
+
+// sealedHeader starts every sealed file; it is also authenticated, so it cannot be swapped for another version's.
+const sealedHeader = "chicha-ip-proxy sealed config v1\n"
+
+// ErrConfigKeyMissing reports a sealed config read without a key.
+var ErrConfigKeyMissing = errors.New("config is encrypted; pass -config-key-file or set " + KeyEnv)
+
+// ParseKey decodes a base64 key, such as the output of `head -c 32 /dev/urandom | base64`, into the 32 bytes AES-256 needs.
+func ParseKey(text string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("config key is not base64: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("config key is %d bytes, want 32", len(key))
+	}
+	return key, nil
+}
+
+// ReadKeyFile loads the key and reports whether the file is readable by every user, which the caller should warn about.
+func ReadKeyFile(path string) ([]byte, bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read config key file '%s': %v", path, err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read config key file '%s': %v", path, err)
+	}
+	key, err := ParseKey(string(content))
+	if err != nil {
+		return nil, false, fmt.Errorf("config key file '%s': %v", path, err)
+	}
+	// Windows reports no owner-only permission bits, so only Unix modes are judged.
+	exposed := runtime.GOOS != "windows" && info.Mode().Perm()&0o004 != 0
+	return key, exposed, nil
+}
+
+// Seal encrypts config content for storing at rest.
+func Seal(plain, key []byte) ([]byte, error) {
+	aead, err := newConfigAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	sealed := aead.Seal(nonce, nonce, plain, []byte(sealedHeader))
+	return []byte(sealedHeader + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+// Unseal returns the plaintext of sealed content and plain content unchanged, so callers need not know which they read.
+func Unseal(data, key []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(sealedHeader)) {
+		return data, nil
+	}
+	if key == nil {
+		return nil, ErrConfigKeyMissing
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data[len(sealedHeader):])))
+	if err != nil {
+		return nil, fmt.Errorf("encrypted config is corrupted: %v", err)
+	}
+	aead, err := newConfigAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted config is truncated")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(sealedHeader))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt config: wrong key or modified file")
+	}
+	return plain, nil
+}
+
+func newConfigAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config key: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func testConfigKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := ParseKey(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	if err != nil {
+		t.Fatalf("ParseKey returned error: %v", err)
+	}
+	return key
+}
+
+func TestSealRoundTrips(t *testing.T) {
+	key := testConfigKey(t)
+	plain := []byte(`{"tcp": ["8080:203.0.113.10:80"]}`)
+
+	sealed, err := Seal(plain, key)
+	if err != nil {
+		t.Fatalf("Seal returned error: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("203.0.113.10")) {
+		t.Fatalf("sealed content leaks the route: %q", sealed)
+	}
+	opened, err := Unseal(sealed, key)
+	if err != nil {
+		t.Fatalf("Unseal returned error: %v", err)
+	}
+	if !bytes.Equal(opened, plain) {
+		t.Fatalf("Unseal = %q, want %q", opened, plain)
+	}
+}
+
+func TestUnsealPassesPlainContentThrough(t *testing.T) {
+	plain := []byte(`{"udp": ["5353:203.0.113.20:53"]}`)
+	opened, err := Unseal(plain, nil)
+	if err != nil {
+		t.Fatalf("Unseal returned error: %v", err)
+	}
+	if !bytes.Equal(opened, plain) {
+		t.Fatalf("Unseal = %q, want the input unchanged", opened)
+	}
+}
+
+func TestUnsealRejectsMissingWrongKeyAndTampering(t *testing.T) {
+	key := testConfigKey(t)
+	sealed, err := Seal([]byte(`{"tcp": []}`), key)
+	if err != nil {
+		t.Fatalf("Seal returned error: %v", err)
+	}
+
+	if _, err := Unseal(sealed, nil); !errors.Is(err, ErrConfigKeyMissing) {
+		t.Fatalf("Unseal without key error = %v, want ErrConfigKeyMissing", err)
+	}
+
+	wrongKey := bytes.Repeat([]byte{8}, 32)
+	if _, err := Unseal(sealed, wrongKey); err == nil {
+		t.Fatalf("Unseal with the wrong key returned no error")
+	}
+
+	body, _ := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sealed[len(sealedHeader):])))
+	body[len(body)-1] ^= 1
+	tampered := []byte(sealedHeader + base64.StdEncoding.EncodeToString(body) + "\n")
+	if _, err := Unseal(tampered, key); err == nil {
+		t.Fatalf("Unseal of a modified file returned no error")
+	}
+}
+
+func TestParseKeyRejectsWrongLength(t *testing.T) {
+	for _, text := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(make([]byte, 16))} {
+		if _, err := ParseKey(text); err == nil {
+			t.Fatalf("ParseKey(%q) returned no error", text)
+		}
+	}
+}
+
+func TestReadKeyFileReportsWorldReadableFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not checked on Windows")
+	}
+	dir := t.TempDir()
+	text := []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)) + "\n")
+
+	for name, mode := range map[string]os.FileMode{"private.key": 0o600, "shared.key": 0o644} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, text, mode); err != nil {
+			t.Fatalf("WriteFile returned error: %v", err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatalf("Chmod returned error: %v", err)
+		}
+		_, exposed, err := ReadKeyFile(path)
+		if err != nil {
+			t.Fatalf("ReadKeyFile returned error: %v", err)
+		}
+		if exposed != (mode == 0o644) {
+			t.Fatalf("ReadKeyFile(%s) exposed = %v", name, exposed)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Settings let a -config file carry the log and rotation policy next to its routes, so one checked-in file describes a proxy.
+// Each setting stands for a command-line flag; main applies only those the command line did not set.
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// LogSettings is the "log" section of a config file.
+type LogSettings struct {
+	File         string `json:"file"`         // File is the -log path.
+	Format       string `json:"format"`       // Format is -log-format: text, json, or logfmt.
+	Timezone     string `json:"timezone"`     // Timezone is -log-timezone: local or utc.
+	Microseconds *bool  `json:"microseconds"` // Microseconds is -log-microseconds.
+}
+
+// RotationSettings is the "rotation" section of a config file.
+type RotationSettings struct {
+	Mode      string `json:"mode"`        // Mode is -log-mode: dated or ring.
+	Frequency string `json:"frequency"`   // Frequency is -rotation, a duration such as 24h.
+	MaxSizeMB *int64 `json:"max_size_mb"` // MaxSizeMB is -log-max-size.
+	MinSize   *int64 `json:"min_size"`    // MinSize is -rotation-min-size, in bytes.
+	RingFiles *int   `json:"ring_files"`  // RingFiles is -log-ring-files.
+}
+
+// FlagSettings lists the settings the file gives a value, keyed by flag name without the dash; the flags check the values.
+func (file File) FlagSettings() map[string]string {
+	settings := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" {
+			settings[name] = value
+		}
+	}
+	if log := file.Log; log != nil {
+		set("log", log.File)
+		set("log-format", log.Format)
+		set("log-timezone", log.Timezone)
+		if log.Microseconds != nil {
+			set("log-microseconds", strconv.FormatBool(*log.Microseconds))
+		}
+	}
+	if rotation := file.Rotation; rotation != nil {
+		set("log-mode", rotation.Mode)
+		set("rotation", rotation.Frequency)
+		if rotation.MaxSizeMB != nil {
+			set("log-max-size", strconv.FormatInt(*rotation.MaxSizeMB, 10))
+		}
+		if rotation.MinSize != nil {
+			set("rotation-min-size", strconv.FormatInt(*rotation.MinSize, 10))
+		}
+		if rotation.RingFiles != nil {
+			set("log-ring-files", strconv.Itoa(*rotation.RingFiles))
+		}
+	}
+	return settings
+}
+
+// ParseSettings reads the settings of one config file's content, which must already be unsealed.
+func ParseSettings(data []byte) (map[string]string, error) {
+	file, err := decodeFile(data)
+	if err != nil {
+		return nil, err
+	}
+	return file.FlagSettings(), nil
+}
+
+// LoadSettings reads the settings of a config file, or of every file in a config directory.
+// In a directory a setting may come from one file only, like a local port, so no file silently overrides another.
+func LoadSettings(path string, key []byte) (map[string]string, error) {
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file '%s': %v", path, err)
+		}
+		if data, err = Unseal(data, key); err != nil {
+			return nil, fmt.Errorf("config file '%s': %v", path, err)
+		}
+		settings, err := ParseSettings(data)
+		if err != nil {
+			return nil, fmt.Errorf("config file '%s': %v", path, err)
+		}
+		return settings, nil
+	}
+
+	parts, err := ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	settings := make(map[string]string)
+	owners := make(map[string]string)
+	for _, part := range parts {
+		content, err := Unseal(part.Content, key)
+		if err != nil {
+			return nil, fmt.Errorf("config directory '%s': %s: %v", path, part.Name, err)
+		}
+		partSettings, err := ParseSettings(content)
+		if err != nil {
+			return nil, fmt.Errorf("config directory '%s': %s: %v", path, part.Name, err)
+		}
+		names := make([]string, 0, len(partSettings))
+		for name := range partSettings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if owner, taken := owners[name]; taken {
+				return nil, fmt.Errorf("config directory '%s': the -%s setting is in both %s and %s", path, name, owner, part.Name)
+			}
+			owners[name] = part.Name
+			settings[name] = partSettings[name]
+		}
+	}
+	return settings, nil
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSettingsNamesTheFlags(t *testing.T) {
+	settings, err := ParseSettings([]byte(`
+tcp: [8080:203.0.113.10:80]
+log:
+  file: /var/log/chicha-ip-proxy/proxy.log
+  format: json
+  timezone: utc
+  microseconds: false
+rotation:
+  mode: ring
+  frequency: 1h
+  max_size_mb: 50
+  min_size: 0
+  ring_files: 7
+`))
+	if err != nil {
+		t.Fatalf("ParseSettings returned error: %v", err)
+	}
+	want := map[string]string{
+		"log":               "/var/log/chicha-ip-proxy/proxy.log",
+		"log-format":        "json",
+		"log-timezone":      "utc",
+		"log-microseconds":  "false",
+		"log-mode":          "ring",
+		"rotation":          "1h",
+		"log-max-size":      "50",
+		"rotation-min-size": "0",
+		"log-ring-files":    "7",
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Fatalf("settings = %v, want %v", settings, want)
+	}
+
+	if settings, err := ParseSettings([]byte(`{"tcp": [], "log": {}}`)); err != nil || len(settings) != 0 {
+		t.Fatalf("empty log section gave %v, %v; want no settings", settings, err)
+	}
+	if _, err := ParseSettings([]byte("rotation:\n  max_size_mb: lots\n")); err == nil {
+		t.Fatal("ParseSettings accepted a size that is not a number")
+	}
+}
+
+func TestLoadSettingsRefusesOneSettingInTwoFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "10-routes.yaml"), "tcp:\n  - 8080:203.0.113.10:80\nlog:\n  format: json\n")
+	writeConfig(t, filepath.Join(dir, "20-logging.yml"), "rotation:\n  frequency: 6h\n")
+
+	settings, err := LoadSettings(dir, nil)
+	if err != nil {
+		t.Fatalf("LoadSettings returned error: %v", err)
+	}
+	if settings["log-format"] != "json" || settings["rotation"] != "6h" {
+		t.Fatalf("settings = %v, want one from each file", settings)
+	}
+	tcpRoutes, _, files, err := LoadDir(dir, nil)
+	if err != nil || len(tcpRoutes) != 1 || len(files) != 2 {
+		t.Fatalf("LoadDir = %v, %v, %v; want the YAML files read", tcpRoutes, files, err)
+	}
+
+	writeConfig(t, filepath.Join(dir, "30-override.json"), `{"log": {"format": "text"}}`)
+	if _, err := LoadSettings(dir, nil); err == nil || !strings.Contains(err.Error(), "the -log-format setting is in both 10-routes.yaml and 30-override.json") {
+		t.Fatalf("LoadSettings error = %v, want both file names", err)
+	}
+}
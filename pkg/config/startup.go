@@ -0,0 +1,153 @@
+// Routes normally bind together and start in parse order; after= and wait-for-upstream= let a route wait for other routes or for its backend.
+// The plan works on local ports, because the TCP and UDP halves of a both route start as one.
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultUpstreamWait is how long a bare wait-for-upstream option waits for the backend before binding anyway.
+const DefaultUpstreamWait = 30 * time.Second
+
+// StartupStep is one local port in start order, with the indexes of its routes in the TCP and UDP lists.
+type StartupStep struct {
+	Port  string
+	TCP   []int
+	UDP   []int
+	After []string // After names the ports that must be serving first.
+	// Wait is the longest wait-for-upstream of the port's routes, and Upstreams the TCP backends it waits for.
+	Wait      time.Duration
+	Upstreams []string
+}
+
+// StartupOrder sorts local ports so each starts after the ports its routes name in after=, keeping parse order otherwise.
+// Unknown ports and cycles are errors, because either would leave a route that never starts.
+// after= may name a route instead of its port, so it also checks that every name= belongs to one port.
+func StartupOrder(tcpRoutes, udpRoutes []Route) ([]StartupStep, error) {
+	names, err := routeNamePorts(tcpRoutes, udpRoutes)
+	if err != nil {
+		return nil, err
+	}
+	var steps []*StartupStep
+	byPort := make(map[string]*StartupStep)
+	add := func(route Route, index int, protocol string) error {
+		step := byPort[route.LocalPort]
+		if step == nil {
+			step = &StartupStep{Port: route.LocalPort}
+			byPort[route.LocalPort] = step
+			steps = append(steps, step)
+		}
+		if protocol == "tcp" {
+			step.TCP = append(step.TCP, index)
+		} else {
+			step.UDP = append(step.UDP, index)
+		}
+		for _, port := range route.AfterPorts() {
+			if named, ok := names[port]; ok {
+				port = named
+			}
+			if !containsField(strings.Join(step.After, " "), port) { // a both route names its ports twice
+				step.After = append(step.After, port)
+			}
+		}
+		if route.WaitForUpstream > 0 {
+			// A UDP backend cannot be probed without speaking its protocol, so only TCP dials count.
+			backend := protocol
+			if route.TargetProtocol != "" {
+				backend = route.TargetProtocol
+			}
+			// The UDP half of a both route rides on the wait of its TCP half.
+			if backend != "tcp" {
+				if protocol == "udp" && len(step.Upstreams) > 0 {
+					return nil
+				}
+				return fmt.Errorf("route on port %s: wait-for-upstream needs a TCP backend to dial", route.LocalPort)
+			}
+			step.Wait = max(step.Wait, route.WaitForUpstream)
+			step.Upstreams = append(step.Upstreams, route.RemoteAddress())
+		}
+		return nil
+	}
+	for i, route := range tcpRoutes {
+		if err := add(route, i, "tcp"); err != nil {
+			return nil, err
+		}
+	}
+	for i, route := range udpRoutes {
+		if err := add(route, i, "udp"); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, step := range steps {
+		for _, port := range step.After {
+			if port == step.Port {
+				return nil, fmt.Errorf("route on port %s: after=%s names the route itself", step.Port, port)
+			}
+			if byPort[port] == nil {
+				return nil, fmt.Errorf("route on port %s: after=%s names no route", step.Port, port)
+			}
+		}
+	}
+
+	// Each pass starts the first port, in parse order, whose dependencies have all started, so unrelated routes keep their order.
+	ordered := make([]StartupStep, 0, len(steps))
+	started := make(map[string]bool, len(steps))
+	for len(ordered) < len(steps) {
+		var next *StartupStep
+		for _, step := range steps {
+			if !started[step.Port] && allStarted(step.After, started) {
+				next = step
+				break
+			}
+		}
+		if next == nil {
+			var waiting []string
+			for _, step := range steps {
+				if !started[step.Port] {
+					waiting = append(waiting, step.Port)
+				}
+			}
+			return nil, fmt.Errorf("after= options form a cycle between ports %s", strings.Join(waiting, ", "))
+		}
+		started[next.Port] = true
+		ordered = append(ordered, *next)
+	}
+	return ordered, nil
+}
+
+// routeNamePorts maps each name= to its local port; the TCP and UDP halves of a both route share one name and one port.
+func routeNamePorts(tcpRoutes, udpRoutes []Route) (map[string]string, error) {
+	names := make(map[string]string)
+	for _, route := range append(append([]Route(nil), tcpRoutes...), udpRoutes...) {
+		if route.Name == "" {
+			continue
+		}
+		if port, ok := names[route.Name]; ok && port != route.LocalPort {
+			return nil, fmt.Errorf("route name '%s' is used on ports %s and %s", route.Name, port, route.LocalPort)
+		}
+		names[route.Name] = route.LocalPort
+	}
+	return names, nil
+}
+
+func allStarted(ports []string, started map[string]bool) bool {
+	for _, port := range ports {
+		if !started[port] {
+			return false
+		}
+	}
+	return true
+}
+
+// containsField reports whether the space-separated list holds value.
+func containsField(list, value string) bool {
+	for _, field := range strings.Fields(list) {
+		if field == value {
+			return true
+		}
+	}
+	return false
+}
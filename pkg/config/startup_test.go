@@ -0,0 +1,104 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartupOrderKeepsParseOrderWithoutDependencies(t *testing.T) {
+	tcpRoutes, udpRoutes, err := ParseForwardRoutes("tcp/8080:10.0.0.1:80,both/53:10.0.0.2:53,udp/5353:10.0.0.3:53")
+	if err != nil {
+		t.Fatalf("ParseForwardRoutes returned error: %v", err)
+	}
+	steps, err := StartupOrder(tcpRoutes, udpRoutes)
+	if err != nil {
+		t.Fatalf("StartupOrder returned error: %v", err)
+	}
+	var ports []string
+	for _, step := range steps {
+		ports = append(ports, step.Port)
+	}
+	if !reflect.DeepEqual(ports, []string{"8080", "53", "5353"}) {
+		t.Fatalf("ports = %v, want parse order", ports)
+	}
+	if !reflect.DeepEqual(steps[1].TCP, []int{1}) || !reflect.DeepEqual(steps[1].UDP, []int{0}) {
+		t.Fatalf("both route step = %#v, want TCP index 1 and UDP index 0", steps[1])
+	}
+}
+
+func TestStartupOrderStartsDependenciesFirst(t *testing.T) {
+	tcpRoutes, udpRoutes, err := ParseForwardRoutes("tcp/8080:10.0.0.1:80;after=5432;wait-for-upstream," +
+		"tcp/9000:10.0.0.1:90;after=8080+53;wait-for-upstream=5s,tcp/5432:10.0.0.5:5432,both/53:10.0.0.2:53")
+	if err != nil {
+		t.Fatalf("ParseForwardRoutes returned error: %v", err)
+	}
+	steps, err := StartupOrder(tcpRoutes, udpRoutes)
+	if err != nil {
+		t.Fatalf("StartupOrder returned error: %v", err)
+	}
+	var ports []string
+	for _, step := range steps {
+		ports = append(ports, step.Port)
+	}
+	if !reflect.DeepEqual(ports, []string{"5432", "8080", "53", "9000"}) {
+		t.Fatalf("ports = %v, want dependencies before their dependents", ports)
+	}
+	if steps[1].Wait != DefaultUpstreamWait || !reflect.DeepEqual(steps[1].Upstreams, []string{"10.0.0.1:80"}) {
+		t.Fatalf("8080 step = %#v, want the default wait for 10.0.0.1:80", steps[1])
+	}
+	if steps[3].Wait != 5*time.Second || !reflect.DeepEqual(steps[3].After, []string{"8080", "53"}) {
+		t.Fatalf("9000 step = %#v", steps[3])
+	}
+}
+
+func TestStartupOrderRejectsUnstartableRoutes(t *testing.T) {
+	tests := map[string]struct{ forward, want string }{
+		"unknown port": {"tcp/8080:10.0.0.1:80;after=9000", "after=9000 names no route"},
+		"itself":       {"tcp/8080:10.0.0.1:80;after=8080", "names the route itself"},
+		"cycle":        {"tcp/8080:10.0.0.1:80;after=9000,tcp/9000:10.0.0.1:90;after=8080,tcp/22:10.0.0.1:22", "cycle between ports 8080, 9000"},
+		"udp backend":  {"udp/5353:10.0.0.1:53;wait-for-upstream", "needs a TCP backend"},
+		"unknown name": {"tcp/8080:10.0.0.1:80;after=db", "after=db names no route"},
+		"shared name":  {"tcp/8080:10.0.0.1:80;name=web,udp/8081:10.0.0.1:81;name=web", "route name 'web' is used on ports 8080 and 8081"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			tcpRoutes, udpRoutes, err := ParseForwardRoutes(test.forward)
+			if err != nil {
+				t.Fatalf("ParseForwardRoutes returned error: %v", err)
+			}
+			if _, err := StartupOrder(tcpRoutes, udpRoutes); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("StartupOrder error = %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestStartupOrderLetsBothRoutesWaitOnTheirTCPHalf(t *testing.T) {
+	tcpRoutes, udpRoutes, err := ParseForwardRoutes("both/53:10.0.0.2:53;wait-for-upstream=2s")
+	if err != nil {
+		t.Fatalf("ParseForwardRoutes returned error: %v", err)
+	}
+	steps, err := StartupOrder(tcpRoutes, udpRoutes)
+	if err != nil {
+		t.Fatalf("StartupOrder returned error: %v", err)
+	}
+	if len(steps) != 1 || steps[0].Wait != 2*time.Second || len(steps[0].Upstreams) != 1 {
+		t.Fatalf("steps = %#v, want one step waiting for the TCP backend", steps)
+	}
+}
+
+func TestStartupOrderResolvesRouteNames(t *testing.T) {
+	tcpRoutes, udpRoutes, err := ParseForwardRoutes("tcp/8080:10.0.0.1:80;after=db+dns,tcp/5432:10.0.0.5:5432;name=db,both/53:10.0.0.2:53;name=dns")
+	if err != nil {
+		t.Fatalf("ParseForwardRoutes returned error: %v", err)
+	}
+	steps, err := StartupOrder(tcpRoutes, udpRoutes)
+	if err != nil {
+		t.Fatalf("StartupOrder returned error: %v", err)
+	}
+	if len(steps) != 3 || steps[2].Port != "8080" || !reflect.DeepEqual(steps[2].After, []string{"5432", "53"}) {
+		t.Fatalf("steps = %#v, want 8080 last, after ports 5432 and 53", steps)
+	}
+}
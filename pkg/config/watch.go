@@ -0,0 +1,61 @@
+// File watching lets orchestrators that mount config files (for example Kubernetes ConfigMaps) update routes without signals.
+// Polling keeps it portable: no inotify or kqueue, just stat and a content hash.
+package config
+
+import (
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+// WatchFile polls path every interval and calls onChange with the new content once it has settled.
+// A change must look the same on two consecutive polls before it is delivered, which debounces editors and
+// ConfigMap symlink swaps that write a file in several steps. It runs until the process exits.
+func WatchFile(path string, interval time.Duration, onChange func([]byte)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	watcher := fileWatcher{path: path}
+	watcher.poll()
+	watcher.applied = watcher.pending
+	for range ticker.C {
+		if content, ok := watcher.poll(); ok {
+			onChange(content)
+		}
+	}
+}
+
+// fileWatcher remembers what was last seen and last delivered; only the WatchFile goroutine touches it.
+type fileWatcher struct {
+	path    string
+	modTime time.Time
+	size    int64
+	pending [sha256.Size]byte
+	stable  bool
+	applied [sha256.Size]byte
+}
+
+// poll returns settled content that differs from the last delivered version.
+func (watcher *fileWatcher) poll() ([]byte, bool) {
+	info, err := os.Stat(watcher.path)
+	if err != nil {
+		return nil, false
+	}
+	if info.ModTime().Equal(watcher.modTime) && info.Size() == watcher.size && watcher.stable {
+		return nil, false
+	}
+
+	content, err := os.ReadFile(watcher.path)
+	if err != nil {
+		return nil, false
+	}
+	sum := sha256.Sum256(content)
+	settled := sum == watcher.pending
+	watcher.modTime, watcher.size, watcher.pending, watcher.stable = info.ModTime(), info.Size(), sum, settled
+
+	if !settled || sum == watcher.applied {
+		return nil, false
+	}
+	watcher.applied = sum
+	return content, true
+}
@@ -0,0 +1,370 @@
+// Config files may be written in YAML, which is easier to keep in git and review than JSON once routes carry comments.
+// Only the subset a config file needs is read, and it is turned into JSON so both formats share one strict decoder.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is one meaningful line of a YAML document: comments and blank lines are already gone.
+type yamlLine struct {
+	number int // number is the 1-based line number for error messages.
+	indent int
+	text   string
+}
+
+// yamlParser walks the lines once; block structure comes from indentation alone, as in YAML.
+type yamlParser struct {
+	lines []yamlLine
+	next  int
+}
+
+// isJSONContent tells a JSON config from a YAML one: every JSON config is an object, and YAML rarely starts with a brace.
+// Empty content stays JSON, so it keeps failing the way an empty config always has.
+func isJSONContent(data []byte) bool {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), " \t\r\n")
+	return len(trimmed) == 0 || trimmed[0] == '{'
+}
+
+// yamlToJSON reads block mappings, block lists, plain and quoted scalars, and [a, b] lists of scalars.
+// Anchors, tags, multi-line scalars, and several documents in one file are refused rather than misread.
+func yamlToJSON(data []byte) ([]byte, error) {
+	lines, err := yamlLines(string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	if err != nil {
+		return nil, err
+	}
+	parser := &yamlParser{lines: lines}
+	var value interface{} = map[string]interface{}{}
+	if len(lines) > 0 {
+		if lines[0].indent != 0 {
+			return nil, fmt.Errorf("line %d: the document must start at column 1", lines[0].number)
+		}
+		if value, err = parser.block(0); err != nil {
+			return nil, err
+		}
+		if parser.next < len(lines) {
+			return nil, fmt.Errorf("line %d: unexpected indentation", lines[parser.next].number)
+		}
+	}
+	return json.Marshal(value)
+}
+
+// yamlLines drops comments, blank lines, and a leading "---", and measures each line's indentation.
+func yamlLines(document string) ([]yamlLine, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(document, "\n") {
+		number := i + 1
+		raw = strings.TrimRight(raw, "\r")
+		content := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", number)
+		}
+		text, err := stripYAMLComment(content)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", number, err)
+		}
+		text = strings.TrimRight(text, " \t")
+		if text == "" {
+			continue
+		}
+		if text == "---" || text == "..." {
+			if len(lines) > 0 && text == "---" {
+				return nil, fmt.Errorf("line %d: only one YAML document per file is supported", number)
+			}
+			continue
+		}
+		lines = append(lines, yamlLine{number: number, indent: len(raw) - len(content), text: text})
+	}
+	return lines, nil
+}
+
+// stripYAMLComment cuts a # that starts the line or follows whitespace, outside quotes.
+func stripYAMLComment(text string) (string, error) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\', quote == '\'' && c == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			// Quotes only open a scalar at its start; an apostrophe inside a plain word is just a character.
+			if i == 0 || strings.ContainsRune(" [,:-", rune(text[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i], nil
+		}
+	}
+	if quote != 0 {
+		return "", fmt.Errorf("unterminated %c quote", quote)
+	}
+	return text, nil
+}
+
+// block reads the mapping or list whose lines start at column indent.
+func (parser *yamlParser) block(indent int) (interface{}, error) {
+	if isYAMLListItem(parser.lines[parser.next].text) {
+		return parser.list(indent)
+	}
+	return parser.mapping(indent)
+}
+
+func (parser *yamlParser) mapping(indent int) (interface{}, error) {
+	mapping := make(map[string]interface{})
+	for parser.next < len(parser.lines) {
+		line := parser.lines[parser.next]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+		if isYAMLListItem(line.text) {
+			return nil, fmt.Errorf("line %d: a list item where a key was expected", line.number)
+		}
+		key, rest, ok, err := splitYAMLKey(line.text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line.number, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected 'key: value', got '%s'", line.number, line.text)
+		}
+		if _, duplicate := mapping[key]; duplicate {
+			return nil, fmt.Errorf("line %d: key '%s' appears twice", line.number, key)
+		}
+		parser.next++
+		value, err := parser.value(rest, indent, line.number)
+		if err != nil {
+			return nil, err
+		}
+		mapping[key] = value
+	}
+	return mapping, nil
+}
+
+func (parser *yamlParser) list(indent int) (interface{}, error) {
+	list := make([]interface{}, 0)
+	for parser.next < len(parser.lines) {
+		line := parser.lines[parser.next]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+		if !isYAMLListItem(line.text) {
+			break
+		}
+		rest := strings.TrimLeft(line.text[1:], " ")
+		// A bare dash, maybe followed by a comment, leaves rest empty; value then reads the block below it or null.
+		if _, _, isKey, _ := splitYAMLKey(rest); rest != "" && isKey && !strings.HasPrefix(rest, "[") {
+			// "- key: value" opens a mapping whose keys line up with the first one, as in "- name: web".
+			offset := len(line.text) - len(rest)
+			parser.lines[parser.next] = yamlLine{number: line.number, indent: indent + offset, text: rest}
+			value, err := parser.mapping(indent + offset)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+			continue
+		}
+		parser.next++
+		value, err := parser.value(rest, indent, line.number)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+// value reads what follows a key or a list dash: a scalar on the same line, or a nested block on the lines below.
+// A list may sit at the same indentation as its key, as many YAML writers emit it.
+func (parser *yamlParser) value(rest string, indent, number int) (interface{}, error) {
+	if rest != "" {
+		value, err := yamlScalarOrFlow(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", number, err)
+		}
+		return value, nil
+	}
+	if parser.next < len(parser.lines) {
+		next := parser.lines[parser.next]
+		if next.indent > indent || next.indent == indent && isYAMLListItem(next.text) && !parser.insideList(indent) {
+			return parser.block(next.indent)
+		}
+	}
+	return nil, nil
+}
+
+// insideList reports whether indent belongs to a list already, where a dash at the same column is the next item, not a value.
+func (parser *yamlParser) insideList(indent int) bool {
+	for i := parser.next - 1; i >= 0; i-- {
+		if parser.lines[i].indent < indent {
+			return false
+		}
+		if parser.lines[i].indent == indent {
+			return isYAMLListItem(parser.lines[i].text)
+		}
+	}
+	return false
+}
+
+func isYAMLListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits "key: value" at the first colon followed by a space or the end of the line, outside quotes.
+// Route entries such as 8080:10.0.0.1:80 have no such colon and stay plain scalars.
+func splitYAMLKey(text string) (string, string, bool, error) {
+	if text == "" {
+		return "", "", false, nil
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		end := closingQuote(text)
+		if end < 0 || end+1 >= len(text) || text[end+1] != ':' || end+2 < len(text) && text[end+2] != ' ' {
+			return "", "", false, nil
+		}
+		key, err := yamlQuoted(text[:end+1])
+		if err != nil {
+			return "", "", false, err
+		}
+		return key, strings.TrimLeft(text[end+2:], " "), true, nil
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return text[:i], strings.TrimLeft(text[i+1:], " "), true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// yamlScalarOrFlow reads a value written on one line: a scalar, [a, b], or an empty {}.
+func yamlScalarOrFlow(text string) (interface{}, error) {
+	switch {
+	case text == "{}":
+		return map[string]interface{}{}, nil
+	case strings.HasPrefix(text, "{"):
+		return nil, fmt.Errorf("{...} mappings are not supported; write one key per line")
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("unterminated [ list")
+		}
+		items, err := splitYAMLFlow(text[1 : len(text)-1])
+		if err != nil {
+			return nil, err
+		}
+		list := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			if strings.HasPrefix(item, "[") || strings.HasPrefix(item, "{") {
+				return nil, fmt.Errorf("nested [ ] or { } lists are not supported")
+			}
+			value, err := yamlScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	}
+	return yamlScalar(text)
+}
+
+// splitYAMLFlow splits the inside of [a, "b, c"] at commas outside quotes; a trailing comma is allowed.
+func splitYAMLFlow(text string) ([]string, error) {
+	var items []string
+	start := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && strings.TrimSpace(text[start:i]) == "":
+			quote = c
+		case c == ',':
+			items = append(items, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(text[start:]); last != "" || len(items) == 0 {
+		items = append(items, last)
+	}
+	if len(items) == 1 && items[0] == "" {
+		return nil, nil
+	}
+	for _, item := range items {
+		if item == "" {
+			return nil, fmt.Errorf("empty item in [ ] list")
+		}
+	}
+	return items, nil
+}
+
+// yamlScalar types a scalar the way YAML 1.2 does for the values a config uses: booleans, null, numbers, and strings.
+// Quoting forces a string, so "8080" stays text where a field needs it.
+func yamlScalar(text string) (interface{}, error) {
+	switch text[0] {
+	case '"', '\'':
+		if closingQuote(text) != len(text)-1 {
+			return nil, fmt.Errorf("unexpected text after the closing quote in %s", text)
+		}
+		return yamlQuoted(text)
+	case '&', '*', '!':
+		return nil, fmt.Errorf("anchors, aliases, and tags are not supported: %s", text)
+	case '|', '>':
+		return nil, fmt.Errorf("multi-line scalars are not supported; write the value on one line")
+	}
+	switch text {
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	}
+	if (text[0] == '-' || text[0] >= '0' && text[0] <= '9') && json.Valid([]byte(text)) {
+		return json.Number(text), nil
+	}
+	return text, nil
+}
+
+// closingQuote finds the quote that ends the scalar opening text, or -1.
+func closingQuote(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// yamlQuoted unquotes a scalar: double quotes take backslash escapes, and single quotes only a doubled quote.
+func yamlQuoted(text string) (string, error) {
+	if text[0] == '\'' {
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+	unquoted, err := strconv.Unquote(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid double-quoted string %s", text)
+	}
+	return unquoted, nil
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestYAMLToJSON(t *testing.T) {
+	tests := map[string]struct {
+		yaml string
+		want string
+	}{
+		"route lists": {
+			yaml: "# edge proxy\ntcp:\n  - 8080:203.0.113.10:80;name=web   # public site\n  - \"8443:203.0.113.10:443\"\nudp: [5353:203.0.113.20:53, '53:203.0.113.20:53']\n",
+			want: `{"tcp":["8080:203.0.113.10:80;name=web","8443:203.0.113.10:443"],"udp":["5353:203.0.113.20:53","53:203.0.113.20:53"]}`,
+		},
+		"list at the key's indentation": {
+			yaml: "---\ntcp:\n- 8080:203.0.113.10:80\n- 8081:203.0.113.10:81\nboth: []\n",
+			want: `{"both":[],"tcp":["8080:203.0.113.10:80","8081:203.0.113.10:81"]}`,
+		},
+		"nested sections and scalar types": {
+			yaml: "log:\n  file: /var/log/chicha ip.log\n  microseconds: true\n  format: null\nrotation:\n    max_size_mb: 100\n    frequency: 24h\n    ratio: -1.5e2\n    zero: \"0\"\n",
+			want: `{"log":{"file":"/var/log/chicha ip.log","format":null,"microseconds":true},"rotation":{"frequency":"24h","max_size_mb":100,"ratio":-1.5e2,"zero":"0"}}`,
+		},
+		"mappings in a list": {
+			yaml: "routes:\n  - name: web\n    port: 80\n  - name: 'it''s # not a comment'\n",
+			want: `{"routes":[{"name":"web","port":80},{"name":"it's # not a comment"}]}`,
+		},
+		"bare dashes": {
+			yaml: "items:\n  -\n  -   # filled in later\n  -\n    - nested\n",
+			want: `{"items":[null,null,["nested"]]}`,
+		},
+		"empty document": {
+			yaml: "# nothing yet\n\n",
+			want: `{}`,
+		},
+		"quoted keys and escapes": {
+			yaml: "\"tcp\": [\"a\\tb\"]\n",
+			want: `{"tcp":["a\tb"]}`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := yamlToJSON([]byte(test.yaml))
+			if err != nil {
+				t.Fatalf("yamlToJSON returned error: %v", err)
+			}
+			if string(got) != test.want {
+				t.Fatalf("yamlToJSON =\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestYAMLToJSONRejectsWhatItCannotRead(t *testing.T) {
+	tests := map[string]struct {
+		yaml string
+		want string
+	}{
+		"tab indentation":   {"tcp:\n\t- 8080:203.0.113.10:80\n", "line 2: indent with spaces, not tabs"},
+		"duplicate key":     {"tcp: []\ntcp: []\n", "line 2: key 'tcp' appears twice"},
+		"stray indentation": {"tcp:\n  - a\n    - b\n", "line 3: unexpected indentation"},
+		"not a mapping":     {"tcp:\n  udp\n", "line 2: expected 'key: value'"},
+		"block scalar":      {"tcp: |\n  a\n", "multi-line scalars are not supported"},
+		"anchor":            {"tcp: &routes []\n", "anchors, aliases, and tags are not supported"},
+		"flow mapping":      {"log: {file: a}\n", "{...} mappings are not supported"},
+		"unterminated":      {"tcp: [\"a]\n", "line 1: unterminated \" quote"},
+		"empty list item":   {"tcp: [a, , b]\n", "empty item"},
+		"two documents":     {"tcp: []\n---\nudp: []\n", "line 2: only one YAML document"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := yamlToJSON([]byte(test.yaml))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("yamlToJSON error = %v, want it to mention %q", err, test.want)
+			}
+		})
+	}
+}
@@ -6,7 +6,10 @@
 // even when platform helpers vary by operating system.
 package limits
 
-import "log"
+import (
+	"log"
+	"syscall"
+)
 
 // collectLimitRequests delegates to the platform-specific implementation.
 // Keeping this wrapper separate avoids duplicate symbol definitions when
@@ -14,3 +17,13 @@ import "log"
 func collectLimitRequests(logger *log.Logger) []limitRequest {
 	return platformLimitRequests(logger)
 }
+
+// OpenFilesLimit reads back the RLIMIT_NOFILE soft limit in effect after SetupLimits.
+// The soft limit is what accept and dial fail against, so it is the number capacity planning must use.
+func OpenFilesLimit() (uint64, bool) {
+	var current syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &current); err != nil {
+		return 0, false
+	}
+	return uint64(current.Cur), true
+}
@@ -75,12 +75,23 @@ func buildTargetRequestFreeBSD(label string, resource int, target int64, logger
 				desired.Cur = desired.Max
 			}
 
+			// A low kern.maxfiles caps open files no matter what setrlimit is asked for, so name the sysctl up front.
+			advice := ""
+			if resource == syscall.RLIMIT_NOFILE {
+				advice = openFilesCeilingAdvice(uint64(target))
+				if advice != "" {
+					logger.Printf("%s", advice)
+				}
+			}
+
 			if current.Cur >= desired.Cur && current.Max >= desired.Max {
 				return nil
 			}
 
 			if err := syscall.Setrlimit(resource, desired); err != nil {
-				logger.Printf("Adjusting %s hit %v; trying best-effort with existing max", label, err)
+				if advice == "" {
+					logger.Printf("Adjusting %s hit %v; trying best-effort with existing max", label, err)
+				}
 				fallback := &syscall.Rlimit{Cur: desired.Cur, Max: current.Max}
 				if fallback.Cur > fallback.Max {
 					fallback.Cur = fallback.Max
@@ -93,3 +104,12 @@ func buildTargetRequestFreeBSD(label string, resource int, target int64, logger
 		},
 	}
 }
+
+// openFilesSysctls lists the FreeBSD ceilings on open files; the per-process one is usually the lower.
+var openFilesSysctls = []string{"kern.maxfilesperproc", "kern.maxfiles"}
+
+// runningInJail reports whether the process is jailed, where only the host can change kern.maxfiles.
+func runningInJail() bool {
+	jailed, err := syscall.SysctlUint32("security.jail.jailed")
+	return err == nil && jailed == 1
+}
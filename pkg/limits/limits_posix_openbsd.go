@@ -76,12 +76,23 @@ func buildTargetRequestOpenBSD(label string, resource int, target uint64, logger
 				desired.Cur = desired.Max
 			}
 
+			// A low kern.maxfiles caps open files no matter what setrlimit is asked for, so name the sysctl up front.
+			advice := ""
+			if resource == syscall.RLIMIT_NOFILE {
+				advice = openFilesCeilingAdvice(target)
+				if advice != "" {
+					logger.Printf("%s", advice)
+				}
+			}
+
 			if current.Cur >= desired.Cur && current.Max >= desired.Max {
 				return nil
 			}
 
 			if err := syscall.Setrlimit(resource, desired); err != nil {
-				logger.Printf("Adjusting %s hit %v; trying best-effort with existing max", label, err)
+				if advice == "" {
+					logger.Printf("Adjusting %s hit %v; trying best-effort with existing max", label, err)
+				}
 				fallback := &syscall.Rlimit{Cur: desired.Cur, Max: current.Max}
 				if fallback.Cur > fallback.Max {
 					fallback.Cur = fallback.Max
@@ -94,3 +105,11 @@ func buildTargetRequestOpenBSD(label string, resource int, target uint64, logger
 		},
 	}
 }
+
+// openFilesSysctls lists the OpenBSD ceiling on open files; per-process limits come from login.conf instead.
+var openFilesSysctls = []string{"kern.maxfiles"}
+
+// runningInJail is always false because OpenBSD has no jails.
+func runningInJail() bool {
+	return false
+}
@@ -0,0 +1,37 @@
+//go:build freebsd || openbsd
+// +build freebsd openbsd
+
+// Package limits reads the BSD system-wide open file ceilings so a low kern.maxfiles is reported plainly.
+// Without this the RLIMIT_NOFILE fallback only says the kernel refused, which hides the sysctl the operator must raise.
+package limits
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// openFilesCeilingAdvice explains which sysctl keeps RLIMIT_NOFILE below target, or returns "" when none does.
+func openFilesCeilingAdvice(target uint64) string {
+	found := false
+	var ceiling uint64
+	ceilingName := ""
+	for _, name := range openFilesSysctls {
+		value, err := syscall.SysctlUint32(name)
+		if err != nil {
+			continue
+		}
+		if !found || uint64(value) < ceiling {
+			found, ceiling, ceilingName = true, uint64(value), name
+		}
+	}
+	if !found || ceiling >= target {
+		return ""
+	}
+
+	where := "raise it"
+	if runningInJail() {
+		where = "this process runs in a jail, so raise it on the host"
+	}
+	return fmt.Sprintf("Open files target %d is above the system-wide %s=%d; %s with 'sysctl %s=%d' and add it to /etc/sysctl.conf to keep it after reboot",
+		target, ceilingName, ceiling, where, ceilingName, target)
+}
@@ -11,3 +11,8 @@ func collectLimitRequests(logger *log.Logger) []limitRequest {
 	logger.Printf("Windows relies on dynamic kernel limits; no explicit RLIMIT tuning applied")
 	return nil
 }
+
+// OpenFilesLimit reports no limit because Windows sizes handle tables dynamically.
+func OpenFilesLimit() (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,29 @@
+// Access logs hold lines in a format other tools parse, such as Apache Combined Log Format.
+// They skip the timestamp prefix and structured formatting of the main log, but rotate the same way.
+package logging
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// SetupAccessLog opens an access log whose lines are written exactly as given.
+// createDir follows the main log's Options.CreateDir so one flag governs both files.
+func SetupAccessLog(logFile string, createDir bool) (*log.Logger, *os.File, error) {
+	file, err := openLogFile(logFile, createDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return log.New(newResilientOutput(logFile, file), "", 0), file, nil
+}
+
+// RotateAccessLog rotates an access log by the main log's mode and limits.
+// Rotation notices and errors go to logger so the access log only ever holds access lines.
+func RotateAccessLog(logFile string, file *os.File, accessLog, logger *log.Logger, mode string, frequency time.Duration, maxSizeBytes, minSizeBytes int64, keep int) {
+	if mode == ModeRing {
+		rotateRing(logFile, file, accessLog, logger, maxSizeBytes, keep)
+		return
+	}
+	rotateDated(logFile, file, accessLog, logger, frequency, maxSizeBytes, minSizeBytes)
+}
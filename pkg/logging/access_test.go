@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogRotationKeepsNoticesOutOfTheAccessLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "access.log")
+	accessLog, file, err := SetupAccessLog(logPath, false)
+	if err != nil {
+		t.Fatalf("SetupAccessLog returned error: %v", err)
+	}
+
+	var status bytes.Buffer
+	accessLog.Print(`198.51.100.7 - - [16/Oct/2026:10:00:00 +0000] "GET / HTTP/1.1" 200 5 "-" "curl/8.0"`)
+	file, err = rotateRingOnce(logPath, file, accessLog, log.New(&status, "", 0), 1)
+	if err != nil {
+		t.Fatalf("rotateRingOnce returned error: %v", err)
+	}
+	accessLog.Print(`198.51.100.7 - - [16/Oct/2026:10:00:01 +0000] "GET /next HTTP/1.1" 404 - "-" "curl/8.0"`)
+	file.Close()
+
+	archived, err := os.ReadFile(logPath + ".1")
+	if err != nil {
+		t.Fatalf("os.ReadFile returned error: %v", err)
+	}
+	current, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("os.ReadFile returned error: %v", err)
+	}
+	if !strings.HasPrefix(string(archived), "198.51.100.7 - - [") || strings.Count(string(archived), "\n") != 1 {
+		t.Fatalf("archived access log = %q", archived)
+	}
+	if !strings.Contains(string(current), `"GET /next HTTP/1.1" 404`) || strings.Count(string(current), "\n") != 1 {
+		t.Fatalf("current access log = %q", current)
+	}
+	if !strings.Contains(status.String(), "Log file rotated") {
+		t.Fatalf("rotation notice missing from status log: %q", status.String())
+	}
+}
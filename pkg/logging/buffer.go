@@ -0,0 +1,112 @@
+// Buffered output batches log lines so busy proxies do not pay one write syscall per connection event.
+// One goroutine owns the pending bytes, which keeps periodic flushes and rotation swaps free of mutexes.
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// BufferFlushInterval bounds how long a buffered line can wait before it reaches the file.
+const BufferFlushInterval = time.Second
+
+// bufferedOutput forwards every line to its owner goroutine and returns without waiting for the disk.
+type bufferedOutput struct {
+	requests chan bufferRequest
+}
+
+type bufferRequest struct {
+	line  []byte
+	swap  io.Writer
+	reply chan error
+}
+
+func newBufferedOutput(output io.Writer, size int, flushInterval time.Duration) *bufferedOutput {
+	buffered := &bufferedOutput{requests: make(chan bufferRequest, 256)}
+	go buffered.run(output, size, flushInterval)
+	return buffered
+}
+
+func (buffered *bufferedOutput) run(output io.Writer, size int, flushInterval time.Duration) {
+	var pending bytes.Buffer
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	// flush drops the pending lines when the file refuses them so one bad write cannot grow the buffer without bound.
+	flush := func() error {
+		if pending.Len() == 0 {
+			return nil
+		}
+		_, err := output.Write(pending.Bytes())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Log buffer flush failed; dropping %d buffered bytes: %v\n", pending.Len(), err)
+		}
+		pending.Reset()
+		return err
+	}
+
+	for {
+		select {
+		case request := <-buffered.requests:
+			switch {
+			case request.line != nil:
+				pending.Write(request.line)
+				if pending.Len() >= size {
+					flush()
+				}
+			case request.swap != nil:
+				// Rotation flushes before closing the old file, so anything still pending was logged afterwards and belongs to the new one.
+				output = request.swap
+			default:
+				request.reply <- flush()
+			}
+
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Write copies the line because log.Logger reuses its buffer after Write returns.
+func (buffered *bufferedOutput) Write(payload []byte) (int, error) {
+	line := make([]byte, len(payload))
+	copy(line, payload)
+	buffered.requests <- bufferRequest{line: line}
+	return len(payload), nil
+}
+
+// flush writes every queued line to the current file and reports the first error.
+func (buffered *bufferedOutput) flush() error {
+	reply := make(chan error, 1)
+	buffered.requests <- bufferRequest{reply: reply}
+	return <-reply
+}
+
+// swap points the buffer at a new file; callers flush first if pending lines must reach the old one.
+// The request queue is ordered, so every line written after swap returns goes to the new file.
+func (buffered *bufferedOutput) swap(output io.Writer) {
+	buffered.requests <- bufferRequest{swap: output}
+}
+
+// Flush writes buffered log lines to disk; it is a no-op when -log-buffer is off.
+// Call it before exiting because buffered lines are otherwise lost with the process.
+func Flush(logger *log.Logger) error {
+	if buffered := loggerBuffer(logger); buffered != nil {
+		return buffered.flush()
+	}
+	return nil
+}
+
+// loggerBuffer finds the buffer behind the logger, looking through a structured formatter if there is one.
+func loggerBuffer(logger *log.Logger) *bufferedOutput {
+	output := logger.Writer()
+	if formatter, ok := output.(*lineFormatter); ok {
+		output = formatter.output
+	}
+	buffered, _ := output.(*bufferedOutput)
+	return buffered
+}
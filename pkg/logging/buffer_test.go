@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lineSink hands every write to the test goroutine so nothing is read while the buffer owner writes.
+type lineSink chan string
+
+func (sink lineSink) Write(payload []byte) (int, error) {
+	sink <- string(payload)
+	return len(payload), nil
+}
+
+func TestBufferedOutputHoldsLinesUntilFlush(t *testing.T) {
+	sink := make(lineSink, 4)
+	logger := newLogger(newBufferedOutput(sink, 64*1024, time.Hour), Options{})
+	logger.Print("first")
+	logger.Print("second")
+
+	select {
+	case line := <-sink:
+		t.Fatalf("buffered line %q reached the file before a flush", line)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := Flush(logger); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if batch := <-sink; !strings.Contains(batch, "first") || !strings.Contains(batch, "second") {
+		t.Fatalf("flushed batch = %q, want both lines in one write", batch)
+	}
+}
+
+func TestBufferedOutputFlushesPeriodically(t *testing.T) {
+	sink := make(lineSink, 1)
+	logger := newLogger(newBufferedOutput(sink, 64*1024, 20*time.Millisecond), Options{Format: FormatLogfmt})
+	logger.Print("tick")
+
+	select {
+	case batch := <-sink:
+		if !strings.Contains(batch, `msg="tick"`) {
+			t.Fatalf("periodic flush wrote %q", batch)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("buffered line was never flushed")
+	}
+}
+
+func TestBufferedOutputWritesLinesAfterRedirectToNewFile(t *testing.T) {
+	oldFile := make(lineSink, 1)
+	newFile := make(lineSink, 1)
+	logger := newLogger(newBufferedOutput(oldFile, 64*1024, time.Hour), Options{})
+
+	logger.Print("before rotation")
+	if err := Flush(logger); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	redirectOutput(logger, newFile)
+	logger.Print("after rotation")
+	if err := Flush(logger); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+
+	if line := <-oldFile; !strings.Contains(line, "before rotation") {
+		t.Fatalf("old file got %q", line)
+	}
+	if line := <-newFile; !strings.Contains(line, "after rotation") {
+		t.Fatalf("new file got %q", line)
+	}
+}
+
+func TestFlushIsNoOpWithoutBuffer(t *testing.T) {
+	if err := Flush(log.New(&bytes.Buffer{}, "", 0)); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+// Events are single log lines that automation keys on, such as the startup event.
+// They follow the log format: json puts the fields beside time, logfmt adds them as pairs, and text logs them as one JSON object.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field is one key and value of an event; fields keep their order so every line reads the same.
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Event writes one line with "event" set to name followed by fields, in the logger's format.
+// With Options.Caller the line names Event's caller rather than this file.
+func Event(logger *log.Logger, name string, fields ...Field) {
+	fields = append([]Field{{Key: "event", Value: name}}, fields...)
+	formatter, ok := logger.Writer().(*lineFormatter)
+	if !ok {
+		_ = logger.Output(2, string(encodeJSONObject(fields)))
+		return
+	}
+	if formatter.options.Caller {
+		if _, file, line, ok := runtime.Caller(1); ok {
+			fields = append([]Field{{Key: "caller", Value: filepath.Base(file) + ":" + strconv.Itoa(line)}}, fields...)
+		}
+	}
+	now := time.Now()
+	if formatter.remote != nil {
+		formatter.remote.send(formatter.renderEvent(FormatJSON, now, fields))
+	}
+	_, _ = formatter.output.Write(formatter.renderEvent(formatter.options.Format, now, fields))
+}
+
+// renderEvent builds the event line in format; text puts the JSON object after the timestamp, caller field included.
+func (formatter *lineFormatter) renderEvent(format string, now time.Time, fields []Field) []byte {
+	stamp := formatter.stamp(format, now)
+	if isText(format) {
+		return append([]byte(stamp+" "), append(encodeJSONObject(fields), '\n')...)
+	}
+	if format == FormatLogfmt {
+		line := "time=" + stamp
+		for _, field := range fields {
+			line += " " + field.Key + "=" + logfmtValue(field.Value)
+		}
+		return []byte(line + "\n")
+	}
+	return append(encodeJSONObject(append([]Field{{Key: "time", Value: stamp}}, fields...)), '\n')
+}
+
+// encodeJSONObject renders fields as a JSON object in their given order, which a map would not keep.
+func encodeJSONObject(fields []Field) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.Key)
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(field.Value))
+		}
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes()
+}
+
+// logfmtValue renders lists comma-separated and quotes any value a logfmt parser would otherwise split.
+func logfmtValue(value any) string {
+	var text string
+	switch value := value.(type) {
+	case string:
+		text = value
+	case []string:
+		text = strings.Join(value, ",")
+	default:
+		text = fmt.Sprint(value)
+	}
+	if text == "" || strings.ContainsAny(text, " =\"") {
+		return strconv.Quote(text)
+	}
+	return text
+}
@@ -0,0 +1,195 @@
+// Log formats let fleets ship lines to aggregators without regex parsing.
+// The standard *log.Logger stays the only logging type; structured modes only change how each line is rendered.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported values for Options.Format.
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+const microsecondRFC3339 = "2006-01-02T15:04:05.000000Z07:00"
+
+// Options controls how log lines are rendered.
+// The zero value keeps the historical local-time text format.
+type Options struct {
+	Format       string // Format is text, json, or logfmt; empty means text.
+	UTC          bool   // UTC stamps lines in UTC instead of local time.
+	Microseconds bool   // Microseconds adds sub-second precision to timestamps.
+	// BufferSize batches writes in a buffer of this many bytes; zero writes every line immediately.
+	BufferSize int
+	// CreateDir creates a missing log directory; otherwise SetupLogger fails with ErrLogDirMissing.
+	CreateDir bool
+	// Caller adds the file:line that logged each line; it costs a runtime.Caller lookup per line, so it is off by default.
+	Caller bool
+	// Remote also sends every line as JSON to a collector at tcp://host:port or udp://host:port; empty sends nothing.
+	Remote string
+	// Instance names this proxy in every json and logfmt line, remote lines included, so aggregated logs tell instances apart.
+	// Text lines keep their layout, and events carry it only as a field their caller adds.
+	Instance string
+}
+
+// Validate rejects unknown formats before the log file is touched.
+func (options Options) Validate() error {
+	if options.BufferSize < 0 {
+		return fmt.Errorf("log buffer size must not be negative")
+	}
+	if options.Remote != "" {
+		if _, _, err := ParseRemote(options.Remote); err != nil {
+			return err
+		}
+	}
+	switch options.Format {
+	case "", FormatText, FormatJSON, FormatLogfmt:
+		return nil
+	default:
+		return fmt.Errorf("unknown log format '%s' (expected text, json, or logfmt)", options.Format)
+	}
+}
+
+// ParseTimezone maps the -log-timezone flag to the UTC switch.
+func ParseTimezone(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "local":
+		return false, nil
+	case "utc":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown log timezone '%s' (expected local or utc)", value)
+	}
+}
+
+// newLogger builds a logger whose lines follow the requested format.
+// Text mode keeps log flags so existing parsers see the same layout as before.
+func newLogger(output io.Writer, options Options) *log.Logger {
+	return newTeeLogger(output, nil, options)
+}
+
+// newTeeLogger is newLogger that also queues every line for remote.
+// With a remote, text lines go through the formatter as well, which renders them the way log.Logger would.
+func newTeeLogger(output io.Writer, remote *remoteOutput, options Options) *log.Logger {
+	if remote == nil && isText(options.Format) {
+		flags := log.LstdFlags
+		if options.UTC {
+			flags |= log.LUTC
+		}
+		if options.Microseconds {
+			flags |= log.Lmicroseconds
+		}
+		if options.Caller {
+			flags |= log.Lshortfile
+		}
+		return log.New(output, "", flags)
+	}
+	// log.Logger still looks up the caller in structured modes; the formatter moves its "file:line: " prefix into a field.
+	flags := 0
+	if options.Caller {
+		flags = log.Lshortfile
+	}
+	return log.New(&lineFormatter{output: output, options: options, remote: remote}, "", flags)
+}
+
+func isText(format string) bool {
+	return format == "" || format == FormatText
+}
+
+// redirectOutput swaps the destination file while keeping any structured formatter and buffer in front of it.
+func redirectOutput(logger *log.Logger, output io.Writer) {
+	if buffered := loggerBuffer(logger); buffered != nil {
+		buffered.swap(output)
+		return
+	}
+	if formatter, ok := logger.Writer().(*lineFormatter); ok {
+		logger.SetOutput(&lineFormatter{output: output, options: formatter.options, remote: formatter.remote})
+		return
+	}
+	logger.SetOutput(output)
+}
+
+// lineFormatter receives one message per Write from log.Logger and re-renders it as a structured line.
+type lineFormatter struct {
+	output  io.Writer
+	options Options
+	remote  *remoteOutput // remote is nil unless Options.Remote is set.
+}
+
+func (formatter *lineFormatter) Write(payload []byte) (int, error) {
+	message := strings.TrimSuffix(string(payload), "\n")
+	var caller string
+	if formatter.options.Caller {
+		// File names never contain ": ", so the first one ends the prefix log.Lshortfile wrote.
+		if prefix, rest, ok := strings.Cut(message, ": "); ok {
+			caller, message = prefix, rest
+		}
+	}
+	now := time.Now()
+	if formatter.remote != nil {
+		formatter.remote.send(formatter.render(FormatJSON, now, caller, message))
+	}
+	if _, err := formatter.output.Write(formatter.render(formatter.options.Format, now, caller, message)); err != nil {
+		return 0, err
+	}
+	return len(payload), nil
+}
+
+// render builds one line in format; an empty caller leaves the caller field out.
+func (formatter *lineFormatter) render(format string, now time.Time, caller, message string) []byte {
+	stamp := formatter.stamp(format, now)
+
+	if isText(format) {
+		if caller != "" {
+			return []byte(stamp + " " + caller + ": " + message + "\n")
+		}
+		return []byte(stamp + " " + message + "\n")
+	}
+	if format == FormatLogfmt {
+		line := "time=" + stamp
+		if formatter.options.Instance != "" {
+			line += " instance=" + logfmtValue(formatter.options.Instance)
+		}
+		if caller != "" {
+			line += " caller=" + logfmtValue(caller)
+		}
+		return []byte(line + " msg=" + strconv.Quote(message) + "\n")
+	}
+
+	encoded, err := json.Marshal(struct {
+		Time     string `json:"time"`
+		Instance string `json:"instance,omitempty"`
+		Caller   string `json:"caller,omitempty"`
+		Message  string `json:"msg"`
+	}{Time: stamp, Instance: formatter.options.Instance, Caller: caller, Message: message})
+	if err != nil {
+		return []byte(strconv.Quote(message) + "\n")
+	}
+	return append(encoded, '\n')
+}
+
+// stamp formats now for format in the configured timezone and precision; text keeps log.Logger's layout.
+func (formatter *lineFormatter) stamp(format string, now time.Time) string {
+	if formatter.options.UTC {
+		now = now.UTC()
+	}
+	layout := time.RFC3339
+	if formatter.options.Microseconds {
+		layout = microsecondRFC3339
+	}
+	if isText(format) {
+		layout = "2006/01/02 15:04:05"
+		if formatter.options.Microseconds {
+			layout += ".000000"
+		}
+	}
+	return now.Format(layout)
+}
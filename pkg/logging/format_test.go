@@ -0,0 +1,211 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONFormatUsesUTCRFC3339(t *testing.T) {
+	var output bytes.Buffer
+	logger := newLogger(&output, Options{Format: FormatJSON, UTC: true})
+	logger.Printf("New TCP connection: %s", `198.51.100.7:40000 "quoted"`)
+
+	var line struct {
+		Time    string `json:"time"`
+		Message string `json:"msg"`
+	}
+	if err := json.Unmarshal(output.Bytes(), &line); err != nil {
+		t.Fatalf("json.Unmarshal(%q) returned error: %v", output.String(), err)
+	}
+	stamp, err := time.Parse(time.RFC3339, line.Time)
+	if err != nil {
+		t.Fatalf("time %q is not RFC3339: %v", line.Time, err)
+	}
+	if _, offset := stamp.Zone(); offset != 0 || !strings.HasSuffix(line.Time, "Z") {
+		t.Fatalf("time %q is not UTC", line.Time)
+	}
+	if line.Message != `New TCP connection: 198.51.100.7:40000 "quoted"` {
+		t.Fatalf("msg = %q", line.Message)
+	}
+}
+
+func TestLogfmtFormatQuotesMessage(t *testing.T) {
+	var output bytes.Buffer
+	logger := newLogger(&output, Options{Format: FormatLogfmt, UTC: true, Microseconds: true})
+	logger.Print("TCP connection closed")
+
+	line := output.String()
+	if !strings.HasPrefix(line, "time=") || !strings.HasSuffix(line, ` msg="TCP connection closed"`+"\n") {
+		t.Fatalf("logfmt line = %q", line)
+	}
+	if !strings.Contains(line, ".") {
+		t.Fatalf("logfmt line lacks microseconds: %q", line)
+	}
+}
+
+func TestStructuredFormatsNameTheInstance(t *testing.T) {
+	var jsonOutput, logfmtOutput, textOutput bytes.Buffer
+	newLogger(&jsonOutput, Options{Format: FormatJSON, Instance: "edge-1"}).Print("ready")
+	newLogger(&logfmtOutput, Options{Format: FormatLogfmt, Instance: "edge-1"}).Print("ready")
+	newLogger(&textOutput, Options{Instance: "edge-1"}).Print("ready")
+
+	var line struct {
+		Instance string `json:"instance"`
+	}
+	if err := json.Unmarshal(jsonOutput.Bytes(), &line); err != nil || line.Instance != "edge-1" {
+		t.Fatalf("json line %q has instance %q (%v)", jsonOutput.String(), line.Instance, err)
+	}
+	if !strings.Contains(logfmtOutput.String(), " instance=edge-1 msg=") {
+		t.Fatalf("logfmt line = %q", logfmtOutput.String())
+	}
+	if strings.Contains(textOutput.String(), "edge-1") {
+		t.Fatalf("text line = %q, want the usual layout", textOutput.String())
+	}
+}
+
+func TestTextFormatKeepsStandardFlagsByDefault(t *testing.T) {
+	logger := newLogger(&bytes.Buffer{}, Options{})
+	if logger.Flags() != log.LstdFlags {
+		t.Fatalf("text flags = %d, want log.LstdFlags", logger.Flags())
+	}
+
+	utcLogger := newLogger(&bytes.Buffer{}, Options{UTC: true, Microseconds: true})
+	if utcLogger.Flags() != log.LstdFlags|log.LUTC|log.Lmicroseconds {
+		t.Fatalf("text flags with UTC = %d", utcLogger.Flags())
+	}
+}
+
+func TestRedirectOutputKeepsStructuredFormat(t *testing.T) {
+	logger := newLogger(&bytes.Buffer{}, Options{Format: FormatJSON})
+	var rotated bytes.Buffer
+	redirectOutput(logger, &rotated)
+	logger.Print("after rotation")
+
+	if !strings.HasPrefix(rotated.String(), `{"time":`) {
+		t.Fatalf("rotated output lost JSON format: %q", rotated.String())
+	}
+}
+
+func TestOptionsRejectUnknownFormatAndTimezone(t *testing.T) {
+	if err := (Options{Format: "xml"}).Validate(); err == nil {
+		t.Fatal("Validate accepted an unknown format")
+	}
+	if _, err := ParseTimezone("mars"); err == nil {
+		t.Fatal("ParseTimezone accepted an unknown timezone")
+	}
+	if utc, err := ParseTimezone("UTC"); err != nil || !utc {
+		t.Fatalf("ParseTimezone(UTC) = %v, %v", utc, err)
+	}
+}
+
+func TestEventFollowsLogFormat(t *testing.T) {
+	fields := []Field{{Key: "routes", Value: 2}, {Key: "tcp", Value: []string{":8080", ":8443"}}}
+
+	var jsonOutput bytes.Buffer
+	Event(newLogger(&jsonOutput, Options{Format: FormatJSON, UTC: true}), "started", fields...)
+	var line struct {
+		Time   string   `json:"time"`
+		Event  string   `json:"event"`
+		Routes int      `json:"routes"`
+		TCP    []string `json:"tcp"`
+	}
+	if err := json.Unmarshal(jsonOutput.Bytes(), &line); err != nil {
+		t.Fatalf("json.Unmarshal(%q) returned error: %v", jsonOutput.String(), err)
+	}
+	if line.Time == "" || line.Event != "started" || line.Routes != 2 || len(line.TCP) != 2 || strings.Count(jsonOutput.String(), "\n") != 1 {
+		t.Fatalf("json event = %q", jsonOutput.String())
+	}
+
+	var logfmtOutput bytes.Buffer
+	Event(newLogger(&logfmtOutput, Options{Format: FormatLogfmt}), "started", fields...)
+	if !strings.HasSuffix(logfmtOutput.String(), " event=started routes=2 tcp=:8080,:8443\n") {
+		t.Fatalf("logfmt event = %q", logfmtOutput.String())
+	}
+
+	var textOutput bytes.Buffer
+	Event(newLogger(&textOutput, Options{}), "started", fields...)
+	if !strings.HasSuffix(textOutput.String(), ` {"event":"started","routes":2,"tcp":[":8080",":8443"]}`+"\n") {
+		t.Fatalf("text event = %q", textOutput.String())
+	}
+}
+
+func TestCallerNamesTheCallSiteInEveryFormat(t *testing.T) {
+	var textOutput bytes.Buffer
+	logger := newLogger(&textOutput, Options{Caller: true})
+	logger.Print("text line")
+	want := callerHere(-1)
+	if !strings.HasSuffix(textOutput.String(), " "+want+": text line\n") {
+		t.Fatalf("text line = %q, want caller %s", textOutput.String(), want)
+	}
+
+	var jsonOutput bytes.Buffer
+	logger = newLogger(&jsonOutput, Options{Format: FormatJSON, Caller: true})
+	logger.Printf("json %s", "line")
+	want = callerHere(-1)
+	var line struct {
+		Caller  string `json:"caller"`
+		Message string `json:"msg"`
+	}
+	if err := json.Unmarshal(jsonOutput.Bytes(), &line); err != nil {
+		t.Fatalf("json.Unmarshal(%q) returned error: %v", jsonOutput.String(), err)
+	}
+	if line.Caller != want || line.Message != "json line" {
+		t.Fatalf("json line = %q, want caller %s", jsonOutput.String(), want)
+	}
+
+	var logfmtOutput bytes.Buffer
+	logger = newLogger(&logfmtOutput, Options{Format: FormatLogfmt, Caller: true})
+	logger.Print("logfmt line")
+	want = callerHere(-1)
+	if !strings.HasSuffix(logfmtOutput.String(), " caller="+want+` msg="logfmt line"`+"\n") {
+		t.Fatalf("logfmt line = %q, want caller %s", logfmtOutput.String(), want)
+	}
+}
+
+func TestCallerSkipsLoggingWrappers(t *testing.T) {
+	var output bytes.Buffer
+	logger := newLogger(&output, Options{Format: FormatJSON, Caller: true})
+
+	Event(logger, "started")
+	wantEvent := callerHere(-1)
+	var limiter *RateLimiter
+	limiter.Printf(logger, "key", "unlimited")
+	wantUnlimited := callerHere(-1)
+	limited := NewRateLimiter(5, time.Hour)
+	limited.Printf(logger, "key", "limited")
+	wantLimited := callerHere(-1)
+
+	var callers []string
+	for _, raw := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var line struct {
+			Caller string `json:"caller"`
+		}
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("json.Unmarshal(%q) returned error: %v", raw, err)
+		}
+		callers = append(callers, line.Caller)
+	}
+	want := []string{wantEvent, wantUnlimited, wantLimited}
+	if strings.Join(callers, " ") != strings.Join(want, " ") {
+		t.Fatalf("callers = %v, want %v", callers, want)
+	}
+
+	var textOutput bytes.Buffer
+	Event(newLogger(&textOutput, Options{Caller: true}), "started")
+	if want := callerHere(-1); !strings.Contains(textOutput.String(), " "+want+": {") {
+		t.Fatalf("text event = %q, want caller %s", textOutput.String(), want)
+	}
+}
+
+// callerHere returns file:line of its caller's source, moved by offset lines, in log.Lshortfile form.
+func callerHere(offset int) string {
+	_, file, line, _ := runtime.Caller(1)
+	return fmt.Sprintf("%s:%d", filepath.Base(file), line+offset)
+}
@@ -3,7 +3,9 @@
 package logging
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -14,31 +16,60 @@ import (
 // Keeping it exported lets the caller opt into consistent sizing without redefining the constant.
 const DefaultMaxSizeBytes int64 = 100 * 1024 * 1024
 
+// ErrLogDirMissing reports a log path whose directory does not exist and was not to be created.
+// Callers match it to suggest creating the directory, which reads better than the raw open failure.
+var ErrLogDirMissing = errors.New("log directory does not exist")
+
 // SetupLogger opens the target file and returns a standard logger alongside the underlying file handle.
 // Returning the file lets the caller manage its lifecycle without hidden global state.
-func SetupLogger(logFile string) (*log.Logger, *os.File, error) {
-	if err := validateSafeLogPath(logFile); err != nil {
+func SetupLogger(logFile string, options Options) (*log.Logger, *os.File, error) {
+	if err := options.Validate(); err != nil {
+		return nil, nil, err
+	}
+	file, err := openLogFile(logFile, options.CreateDir)
+	if err != nil {
 		return nil, nil, err
 	}
 
+	var output io.Writer = newResilientOutput(logFile, file)
+	if options.BufferSize > 0 {
+		output = newBufferedOutput(output, options.BufferSize, BufferFlushInterval)
+	}
+	var remote *remoteOutput
+	if options.Remote != "" {
+		network, address, _ := ParseRemote(options.Remote)
+		remote = newRemoteOutput(network, address, RemoteQueueLines)
+	}
+	logger := newTeeLogger(output, remote, options)
+	return logger, file, nil
+}
+
+// openLogFile checks the log directory, creating it when createDir allows, and opens the file for appending.
+// The path is checked before and after creating the directory so a symlink planted in between is still caught.
+func openLogFile(logFile string, createDir bool) (*os.File, error) {
+	if err := validateSafeLogPath(logFile); err != nil {
+		return nil, err
+	}
+
 	logDir := filepath.Dir(logFile)
-	if logDir != "." {
+	if _, err := os.Stat(logDir); errors.Is(err, os.ErrNotExist) {
+		if !createDir {
+			return nil, fmt.Errorf("%w: '%s'", ErrLogDirMissing, logDir)
+		}
 		if err := os.MkdirAll(logDir, 0750); err != nil {
-			return nil, nil, fmt.Errorf("failed to create log directory '%s': %v", logDir, err)
+			return nil, fmt.Errorf("failed to create log directory '%s': %v", logDir, err)
 		}
 	}
 
 	if err := validateSafeLogPath(logFile); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	file, err := openLogFileForAppend(logFile)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open log file '%s': %v", logFile, err)
+		return nil, fmt.Errorf("failed to open log file '%s': %v", logFile, err)
 	}
-
-	logger := log.New(file, "", log.LstdFlags)
-	return logger, file, nil
+	return file, nil
 }
 
 // validateSafeLogPath rejects symlinked log files so privileged runs cannot be tricked into appending to arbitrary files.
@@ -59,7 +90,14 @@ func validateSafeLogPath(logFile string) error {
 
 // RotateLogs performs periodic rotation and keeps the logs uncompressed.
 // Running in its own goroutine keeps the rest of the application non-blocking.
-func RotateLogs(logFile string, file *os.File, logger *log.Logger, frequency time.Duration, maxSizeBytes int64) {
+// Scheduled rotations skip a file smaller than minSizeBytes, and always an empty one, so quiet proxies keep no empty archives.
+func RotateLogs(logFile string, file *os.File, logger *log.Logger, frequency time.Duration, maxSizeBytes, minSizeBytes int64) {
+	rotateDated(logFile, file, logger, logger, frequency, maxSizeBytes, minSizeBytes)
+}
+
+// rotateDated runs the dated rotation loop for output and reports its own trouble to status.
+// Keeping the two loggers apart lets raw-line logs rotate without rotation notices mixed into them.
+func rotateDated(logFile string, file *os.File, output, status *log.Logger, frequency time.Duration, maxSizeBytes, minSizeBytes int64) {
 	if maxSizeBytes <= 0 {
 		maxSizeBytes = DefaultMaxSizeBytes
 	}
@@ -74,69 +112,131 @@ func RotateLogs(logFile string, file *os.File, logger *log.Logger, frequency tim
 	for {
 		select {
 		case <-rotationTicker.C:
-			nextFile, err := rotateOnce(logFile, currentFile, logger)
-			if err == nil {
-				currentFile = nextFile
+			if currentFile == nil {
+				currentFile = reopenLogFile(logFile, output, status)
+				continue
 			}
+			currentFile = rotateScheduled(logFile, currentFile, output, status, minSizeBytes)
 
 		case <-sizeTicker.C:
+			// A file that could not be reopened, e.g. on a full disk, is retried every minute until space returns.
+			if currentFile == nil {
+				currentFile = reopenLogFile(logFile, output, status)
+				continue
+			}
 			info, err := currentFile.Stat()
 			if err != nil {
-				logger.Printf("Error stating log file for rotation: %v", err)
+				status.Printf("Error stating log file for rotation: %v", err)
 				continue
 			}
 
 			if info.Size() >= maxSizeBytes {
-				nextFile, err := rotateOnce(logFile, currentFile, logger)
-				if err == nil {
-					currentFile = nextFile
-				}
+				currentFile, _ = rotateOnce(logFile, currentFile, output, status)
 			}
 		}
 	}
 }
 
+// rotateScheduled rotates at the -rotation interval unless the file holds less than minSizeBytes.
+// Skipped content stays in the live file and goes into the next archive, so nothing is lost, only an empty or tiny archive avoided.
+func rotateScheduled(logFile string, currentFile *os.File, output, status *log.Logger, minSizeBytes int64) *os.File {
+	// Buffered lines count toward the size, or a busy log with a large buffer would look empty.
+	if err := Flush(output); err != nil {
+		status.Printf("Error flushing buffered logs before rotation: %v", err)
+	}
+	info, err := currentFile.Stat()
+	if err != nil {
+		status.Printf("Error stating log file for rotation: %v", err)
+		return currentFile
+	}
+	if info.Size() == 0 || info.Size() < minSizeBytes {
+		return currentFile
+	}
+	rotated, _ := rotateOnce(logFile, currentFile, output, status)
+	return rotated
+}
+
 // rotateOnce handles closing, renaming, and reopening the log file without compression.
 // Returning the newly opened file keeps the caller in control of the active handle while
 // leaving the rotated file intact for external tools that may prefer raw text.
-func rotateOnce(logFile string, currentFile *os.File, logger *log.Logger) (*os.File, error) {
+// A nil file means logging fell back to dropping lines; the caller retries with reopenLogFile.
+func rotateOnce(logFile string, currentFile *os.File, output, status *log.Logger) (*os.File, error) {
+	// Buffered lines belong to the file being rotated, so they must land before it is closed.
+	if err := Flush(output); err != nil {
+		status.Printf("Error flushing buffered logs before rotation: %v", err)
+	}
 	if err := currentFile.Sync(); err != nil {
-		logger.Printf("Error syncing log file before rotation: %v", err)
+		status.Printf("Error syncing log file before rotation: %v", err)
 	}
 	if err := currentFile.Close(); err != nil {
-		logger.Printf("Error closing log file before rotation: %v", err)
+		status.Printf("Error closing log file before rotation: %v", err)
 	}
 
 	rotatedFile := logFile + "." + time.Now().Format("2006-01-02")
 	if err := os.Rename(logFile, rotatedFile); err != nil {
-		logger.Printf("Error rotating logs: %v", err)
+		status.Printf("Error rotating logs: %v", err)
 
 		if safeErr := validateSafeLogPath(logFile); safeErr != nil {
-			logger.Printf("Refusing to reopen unsafe log path after rotation error: %v", safeErr)
+			abandonLogFile(logFile, output, fmt.Errorf("refusing to reopen unsafe log path after rotation error: %v", safeErr))
 			return nil, safeErr
 		}
 
-		reopened, reopenErr := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		reopened, reopenErr := openLogFileForAppend(logFile)
 		if reopenErr != nil {
-			logger.Printf("Failed to reopen log file after rotation error: %v", reopenErr)
+			abandonLogFile(logFile, output, fmt.Errorf("failed to reopen log file after rotation error: %v", reopenErr))
 			return nil, reopenErr
 		}
 
-		logger.SetOutput(reopened)
+		redirectOutput(output, newResilientOutput(logFile, reopened))
 		return reopened, err
 	}
 
 	if safeErr := validateSafeLogPath(logFile); safeErr != nil {
-		logger.Printf("Refusing to create unsafe log path after rotation: %v", safeErr)
+		abandonLogFile(logFile, output, fmt.Errorf("refusing to create unsafe log path after rotation: %v", safeErr))
 		return nil, safeErr
 	}
 
-	newFile, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	newFile, err := openLogFileForAppend(logFile)
 	if err != nil {
-		logger.Printf("Failed to create new log file after rotation: %v", err)
+		abandonLogFile(logFile, output, fmt.Errorf("failed to create new log file after rotation: %v", err))
 		return nil, err
 	}
-	logger.SetOutput(newFile)
-	logger.Println("Log file rotated successfully; compression skipped to keep raw text accessible.")
+	redirectOutput(output, newResilientOutput(logFile, newFile))
+	status.Println("Log file rotated successfully; compression skipped to keep raw text accessible.")
 	return newFile, nil
 }
+
+// abandonLogFile points output at a sink that drops lines and warns on stderr, because the file cannot be opened.
+// Staying up without logs beats exiting, which would take every forwarded connection down with the log.
+func abandonLogFile(logFile string, output *log.Logger, reason error) {
+	redirectOutput(output, unavailableOutput(logFile, reason))
+}
+
+// reopenLogFile retries a log file that rotation could not reopen and returns nil while it still fails.
+func reopenLogFile(logFile string, output, status *log.Logger) *os.File {
+	if err := validateSafeLogPath(logFile); err != nil {
+		return nil
+	}
+	file, err := openLogFileForAppend(logFile)
+	if err != nil {
+		return nil
+	}
+	redirectOutput(output, newResilientOutput(logFile, file))
+	status.Printf("Log file %s reopened; logging resumed", logFile)
+	return file
+}
+
+// RouteLogger prefixes each line with the route ID after the timestamp, so one grep finds everything a route logged.
+// It writes through logger's current output on every line, because rotation swaps that output underneath it.
+func RouteLogger(logger *log.Logger, id string) *log.Logger {
+	return log.New(parentOutput{logger}, "["+id+"] ", logger.Flags()|log.Lmsgprefix)
+}
+
+// parentOutput forwards to whatever writer the parent logger has at the moment of the write.
+type parentOutput struct {
+	parent *log.Logger
+}
+
+func (output parentOutput) Write(payload []byte) (int, error) {
+	return output.parent.Writer().Write(payload)
+}
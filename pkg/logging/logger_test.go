@@ -1,8 +1,12 @@
 package logging
 
 import (
+	"errors"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -18,7 +22,7 @@ func TestSetupLoggerRejectsSymlinkPath(t *testing.T) {
 		t.Skipf("symlink creation unavailable: %v", err)
 	}
 
-	_, _, err := SetupLogger(linkPath)
+	_, _, err := SetupLogger(linkPath, Options{})
 	if err == nil {
 		t.Fatal("SetupLogger accepted a symlink log path")
 	}
@@ -26,7 +30,7 @@ func TestSetupLoggerRejectsSymlinkPath(t *testing.T) {
 
 func TestSetupLoggerCreatesPrivateLogFile(t *testing.T) {
 	logPath := filepath.Join(t.TempDir(), "proxy.log")
-	_, file, err := SetupLogger(logPath)
+	_, file, err := SetupLogger(logPath, Options{})
 	if err != nil {
 		t.Fatalf("SetupLogger returned error: %v", err)
 	}
@@ -40,3 +44,82 @@ func TestSetupLoggerCreatesPrivateLogFile(t *testing.T) {
 		t.Fatalf("log file permissions = %v, want 0600", got)
 	}
 }
+
+func TestSetupLoggerReportsMissingDirectoryUnlessCreating(t *testing.T) {
+	logDir := filepath.Join(t.TempDir(), "chicha", "proxy")
+	logPath := filepath.Join(logDir, "proxy.log")
+
+	_, _, err := SetupLogger(logPath, Options{})
+	if !errors.Is(err, ErrLogDirMissing) {
+		t.Fatalf("SetupLogger returned %v, want ErrLogDirMissing", err)
+	}
+	if !strings.Contains(err.Error(), logDir) {
+		t.Fatalf("SetupLogger error %q does not name the missing directory", err)
+	}
+
+	_, file, err := SetupLogger(logPath, Options{CreateDir: true})
+	if err != nil {
+		t.Fatalf("SetupLogger returned error: %v", err)
+	}
+	defer file.Close()
+	info, err := os.Stat(logDir)
+	if err != nil {
+		t.Fatalf("os.Stat returned error: %v", err)
+	}
+	if got := info.Mode().Perm(); got != 0750 {
+		t.Fatalf("log directory permissions = %v, want 0750", got)
+	}
+}
+
+func TestScheduledRotationSkipsEmptyAndSmallLogs(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "proxy.log")
+	logger, file, err := SetupLogger(logPath, Options{BufferSize: 4096})
+	if err != nil {
+		t.Fatalf("SetupLogger returned error: %v", err)
+	}
+	status := log.New(io.Discard, "", 0)
+
+	if next := rotateScheduled(logPath, file, logger, status, 0); next != file {
+		t.Fatal("rotateScheduled replaced an empty log")
+	}
+	logger.Print("one buffered line")
+	if next := rotateScheduled(logPath, file, logger, status, 1024); next != file {
+		t.Fatal("rotateScheduled replaced a log under -rotation-min-size")
+	}
+	if archives, _ := filepath.Glob(logPath + ".*"); len(archives) != 0 {
+		t.Fatalf("skipped rotations left archives %v", archives)
+	}
+
+	// The buffered line is flushed before the size check, so it counts toward a rotation.
+	next := rotateScheduled(logPath, file, logger, status, 0)
+	if next == file || next == nil {
+		t.Fatalf("rotateScheduled = %v, want a fresh log file", next)
+	}
+	defer next.Close()
+	archives, _ := filepath.Glob(logPath + ".*")
+	if len(archives) != 1 {
+		t.Fatalf("archives = %v, want one", archives)
+	}
+	content, err := os.ReadFile(archives[0])
+	if err != nil || !strings.Contains(string(content), "one buffered line") {
+		t.Fatalf("archive = %q, %v", content, err)
+	}
+}
+
+func TestRouteLoggerFollowsParentOutput(t *testing.T) {
+	var first, second strings.Builder
+	parent := log.New(&first, "", 0)
+	routeLogger := RouteLogger(parent, "tcp/8080")
+
+	routeLogger.Printf("Starting TCP proxy")
+	parent.SetOutput(&second)
+	routeLogger.Printf("after rotation")
+
+	if first.String() != "[tcp/8080] Starting TCP proxy\n" {
+		t.Fatalf("first output = %q", first.String())
+	}
+	if second.String() != "[tcp/8080] after rotation\n" {
+		t.Fatalf("second output = %q, want the line in the parent's new output", second.String())
+	}
+}
@@ -0,0 +1,137 @@
+// Rate-limited logging keeps error storms, such as a flapping backend failing every dial, from filling the disk.
+// Lines share a token bucket per key; suppressed ones are coalesced into one "repeated N times" line per key and interval.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// DefaultRateLimit lets 20 lines per second through for each key, far above what a healthy proxy logs for one route.
+const DefaultRateLimit = 20
+
+// RateLimitSummaryInterval is how often suppressed lines are reported, so a storm costs one summary line per key per interval.
+const RateLimitSummaryInterval = 10 * time.Second
+
+// rateLimitMaxKeys bounds the tracked keys; lines for further keys are logged unlimited rather than growing the map without end.
+const rateLimitMaxKeys = 4096
+
+// RateLimiter limits log lines per key; a nil *RateLimiter logs every line.
+// One goroutine owns the buckets, so callers only exchange messages with it.
+type RateLimiter struct {
+	perSecond float64
+	requests  chan rateRequest
+}
+
+type rateRequest struct {
+	key     string
+	message string
+	logger  *log.Logger
+	reply   chan bool // reply is nil for a summary request, which uses done instead.
+	done    chan struct{}
+}
+
+// rateBucket is one key's tokens and the suppressed lines it has not reported yet.
+type rateBucket struct {
+	tokens     float64
+	refilledAt time.Time
+	suppressed int
+	since      time.Time   // since is when the first unreported line was suppressed.
+	last       string      // last is the newest suppressed line, which the summary repeats.
+	logger     *log.Logger // logger is where the summary goes, the same logger as the suppressed lines.
+}
+
+// NewRateLimiter allows perSecond lines per key, with bursts of the same size, and reports suppressed lines every summaryInterval.
+func NewRateLimiter(perSecond int, summaryInterval time.Duration) *RateLimiter {
+	limiter := &RateLimiter{perSecond: float64(perSecond), requests: make(chan rateRequest)}
+	go limiter.run(summaryInterval)
+	return limiter
+}
+
+func (limiter *RateLimiter) run(summaryInterval time.Duration) {
+	buckets := make(map[string]*rateBucket)
+	ticker := time.NewTicker(summaryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case request := <-limiter.requests:
+			if request.reply == nil {
+				limiter.summarize(buckets, time.Now())
+				close(request.done)
+				continue
+			}
+			request.reply <- limiter.allow(buckets, request, time.Now())
+		case now := <-ticker.C:
+			limiter.summarize(buckets, now)
+		}
+	}
+}
+
+// allow takes a token from the key's bucket, or records the line as suppressed when the bucket is empty.
+func (limiter *RateLimiter) allow(buckets map[string]*rateBucket, request rateRequest, now time.Time) bool {
+	bucket, ok := buckets[request.key]
+	if !ok {
+		if len(buckets) >= rateLimitMaxKeys {
+			return true
+		}
+		bucket = &rateBucket{tokens: limiter.perSecond, refilledAt: now}
+		buckets[request.key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.refilledAt).Seconds() * limiter.perSecond
+	if bucket.tokens > limiter.perSecond {
+		bucket.tokens = limiter.perSecond
+	}
+	bucket.refilledAt = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true
+	}
+
+	if bucket.suppressed == 0 {
+		bucket.since = now
+	}
+	bucket.suppressed++
+	bucket.last = request.message
+	bucket.logger = request.logger
+	return false
+}
+
+// summarize logs one line per key with suppressed lines and forgets keys whose bucket has refilled, which keeps the map small.
+func (limiter *RateLimiter) summarize(buckets map[string]*rateBucket, now time.Time) {
+	for key, bucket := range buckets {
+		if bucket.suppressed > 0 {
+			bucket.logger.Printf("%s (repeated %d times in the last %s)", bucket.last, bucket.suppressed, now.Sub(bucket.since).Round(time.Second))
+			bucket.suppressed = 0
+			continue
+		}
+		if bucket.tokens+now.Sub(bucket.refilledAt).Seconds()*limiter.perSecond >= limiter.perSecond {
+			delete(buckets, key)
+		}
+	}
+}
+
+// Printf logs the formatted line unless its key has used up its rate; a suppressed line is counted for the next summary.
+// Keys name the kind of event and its scope, such as a backend address, so one storm does not silence unrelated lines.
+// Lines go out through Output with a depth of 2, so a logger with Options.Caller names the call site, not this wrapper.
+func (limiter *RateLimiter) Printf(logger *log.Logger, key, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if limiter == nil {
+		_ = logger.Output(2, message)
+		return
+	}
+	reply := make(chan bool, 1)
+	limiter.requests <- rateRequest{key: key, message: message, logger: logger, reply: reply}
+	if <-reply {
+		_ = logger.Output(2, message)
+	}
+}
+
+// flushSummaries reports suppressed lines now instead of at the next interval.
+func (limiter *RateLimiter) flushSummaries() {
+	done := make(chan struct{})
+	limiter.requests <- rateRequest{done: done}
+	<-done
+}
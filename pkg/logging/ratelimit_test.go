@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSummarizesSuppressedLines(t *testing.T) {
+	var output bytes.Buffer
+	logger := log.New(&output, "", 0)
+	limiter := NewRateLimiter(3, time.Hour)
+
+	for i := 0; i < 50; i++ {
+		limiter.Printf(logger, "tcp dial 10.0.0.5:80", "Failed to connect to TCP server 10.0.0.5:80: attempt %d", i)
+	}
+	limiter.Printf(logger, "tcp dial 10.0.0.6:80", "Failed to connect to TCP server 10.0.0.6:80")
+	limiter.flushSummaries()
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("logged %d lines, want 3 allowed, 1 other key, 1 summary:\n%s", len(lines), output.String())
+	}
+	if !strings.Contains(lines[4], "attempt 49 (repeated 47 times in the last ") {
+		t.Fatalf("summary = %q", lines[4])
+	}
+
+	// A summarized key that has gone quiet reports nothing more.
+	output.Reset()
+	limiter.flushSummaries()
+	if output.Len() != 0 {
+		t.Fatalf("second summary logged %q", output.String())
+	}
+}
+
+func TestRateLimiterRefillsOverTime(t *testing.T) {
+	var output bytes.Buffer
+	logger := log.New(&output, "", 0)
+	limiter := NewRateLimiter(100, time.Hour)
+
+	for i := 0; i < 100; i++ {
+		limiter.Printf(logger, "udp limit", "dropped")
+	}
+	output.Reset()
+	limiter.Printf(logger, "udp limit", "dropped")
+	if output.Len() != 0 {
+		t.Fatal("line logged with an empty bucket")
+	}
+	time.Sleep(50 * time.Millisecond)
+	limiter.Printf(logger, "udp limit", "dropped again")
+	if !strings.Contains(output.String(), "dropped again") {
+		t.Fatal("bucket did not refill after 50ms at 100 lines per second")
+	}
+}
+
+func TestNilRateLimiterLogsEveryLine(t *testing.T) {
+	var output bytes.Buffer
+	logger := log.New(&output, "", 0)
+	var limiter *RateLimiter
+	for i := 0; i < 3; i++ {
+		limiter.Printf(logger, "key", "line %d", i)
+	}
+	if got := strings.Count(output.String(), "\n"); got != 3 {
+		t.Fatalf("nil limiter logged %d lines, want 3", got)
+	}
+}
@@ -0,0 +1,101 @@
+// A remote sink copies every log line as JSON to a collector such as Vector or Fluent Bit, next to the log file.
+// The collector is best effort: its lines queue in memory while it is away and are dropped and counted once the queue fills, so logging never waits on the network.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RemoteQueueLines is how many lines wait for a slow or unreachable collector before new ones are dropped.
+const RemoteQueueLines = 4096
+
+const (
+	remoteDialTimeout  = 5 * time.Second
+	remoteWriteTimeout = 5 * time.Second
+	// remoteRetryMin and remoteRetryMax bound the doubling delay between dials while the collector is down.
+	remoteRetryMin = 250 * time.Millisecond
+	remoteRetryMax = 30 * time.Second
+)
+
+// ParseRemote splits a -log-remote value such as tcp://127.0.0.1:5170 into its network and address.
+func ParseRemote(spec string) (string, string, error) {
+	network, address, ok := strings.Cut(strings.TrimSpace(spec), "://")
+	if !ok || (network != "tcp" && network != "udp") {
+		return "", "", fmt.Errorf("invalid log remote '%s' (expected tcp://host:port or udp://host:port)", spec)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", "", fmt.Errorf("invalid log remote '%s': %v", spec, err)
+	}
+	return network, address, nil
+}
+
+// remoteOutput hands lines to the goroutine that owns the collector connection.
+// Only that goroutine dials and writes; callers touch the queue and the atomic drop counter alone.
+type remoteOutput struct {
+	name     string
+	lines    chan []byte
+	dropped  atomic.Uint64
+	warnings io.Writer
+}
+
+func newRemoteOutput(network, address string, queue int) *remoteOutput {
+	remote := &remoteOutput{name: network + "://" + address, lines: make(chan []byte, queue), warnings: logWarnings}
+	go remote.run(network, address)
+	return remote
+}
+
+// send queues line without blocking; a full queue drops it and counts the drop.
+func (remote *remoteOutput) send(line []byte) {
+	if remote == nil {
+		return
+	}
+	select {
+	case remote.lines <- line:
+	default:
+		remote.dropped.Add(1)
+	}
+}
+
+// run delivers queued lines, redialing with a growing delay after the collector refuses a dial or a write.
+// A line whose write failed is counted as dropped, because a stream cut mid-line cannot say how much of it arrived.
+func (remote *remoteOutput) run(network, address string) {
+	var conn net.Conn
+	retry := remoteRetryMin
+	down := false
+
+	for line := range remote.lines {
+		for conn == nil {
+			dialed, err := net.DialTimeout(network, address, remoteDialTimeout)
+			if err == nil {
+				conn, retry = dialed, remoteRetryMin
+				if down {
+					fmt.Fprintf(remote.warnings, "Log collector %s is reachable again; %d lines were dropped so far\n", remote.name, remote.dropped.Load())
+					down = false
+				}
+				break
+			}
+			if !down {
+				fmt.Fprintf(remote.warnings, "Log collector %s is unreachable: %v; queueing up to %d lines\n", remote.name, err, cap(remote.lines))
+				down = true
+			}
+			time.Sleep(retry)
+			retry = min(retry*2, remoteRetryMax)
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(remoteWriteTimeout))
+		if _, err := conn.Write(line); err != nil {
+			conn.Close()
+			conn = nil
+			remote.dropped.Add(1)
+			if !down {
+				fmt.Fprintf(remote.warnings, "Log collector %s failed: %v; reconnecting\n", remote.name, err)
+				down = true
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// warningLines collects the warnings of a remote sink, which its goroutine writes while the test reads.
+type warningLines chan string
+
+func (lines warningLines) Write(payload []byte) (int, error) {
+	lines <- string(payload)
+	return len(payload), nil
+}
+
+func (lines warningLines) wait(t *testing.T, want string) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case line := <-lines:
+			if strings.Contains(line, want) {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("no warning containing %q", want)
+		}
+	}
+}
+
+// startCollector accepts connections on listener and passes every line it reads to the returned channel.
+func startCollector(t *testing.T, listener net.Listener) (<-chan string, func()) {
+	t.Helper()
+	lines := make(chan string, RemoteQueueLines)
+	conns := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	stop := func() {
+		listener.Close()
+		for {
+			select {
+			case conn := <-conns:
+				conn.Close()
+			default:
+				return
+			}
+		}
+	}
+	t.Cleanup(stop)
+	return lines, stop
+}
+
+func waitForCollectedMessage(t *testing.T, lines <-chan string, want string) map[string]any {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case line := <-lines:
+			var decoded map[string]any
+			if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+				t.Fatalf("collector received %q, want a JSON line: %v", line, err)
+			}
+			if decoded["msg"] == want {
+				return decoded
+			}
+		case <-deadline:
+			t.Fatalf("collector never received %q", want)
+		}
+	}
+}
+
+func TestRemoteLogReconnectsAfterCollectorRestart(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	address := listener.Addr().String()
+	collected, stopCollector := startCollector(t, listener)
+
+	warnings := make(warningLines, 64)
+	originalWarnings := logWarnings
+	t.Cleanup(func() { logWarnings = originalWarnings })
+	logWarnings = warnings
+
+	logPath := filepath.Join(t.TempDir(), "proxy.log")
+	logger, file, err := SetupLogger(logPath, Options{Remote: "tcp://" + address, UTC: true})
+	if err != nil {
+		t.Fatalf("SetupLogger returned error: %v", err)
+	}
+	defer file.Close()
+
+	logger.Print("before the outage")
+	if line := waitForCollectedMessage(t, collected, "before the outage"); line["time"] == nil {
+		t.Fatalf("collected line %v has no time", line)
+	}
+
+	stopCollector()
+	remote := logger.Writer().(*lineFormatter).remote
+	deadline := time.Now().Add(5 * time.Second)
+	for remote.dropped.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no line was dropped while the collector was down")
+		}
+		logger.Print("during the outage")
+		time.Sleep(time.Millisecond)
+	}
+	warnings.wait(t, "reconnecting")
+
+	listener, err = net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("net.Listen on the old address returned error: %v", err)
+	}
+	collected, _ = startCollector(t, listener)
+	done := make(chan struct{})
+	go func() {
+		// The queue is full of outage lines, so this one is retried until it fits.
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(50 * time.Millisecond):
+				logger.Print("after the restart")
+			}
+		}
+	}()
+	waitForCollectedMessage(t, collected, "after the restart")
+	close(done)
+	warnings.wait(t, "is reachable again")
+
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("os.ReadFile returned error: %v", err)
+	}
+	if !strings.Contains(string(content), " before the outage\n") || strings.Contains(string(content), `"msg"`) {
+		t.Fatalf("log file = %q, want text lines unaffected by the remote", content)
+	}
+}
+
+func TestRemoteLogDropsInsteadOfBlockingWhileCollectorIsDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	originalWarnings := logWarnings
+	t.Cleanup(func() { logWarnings = originalWarnings })
+	logWarnings = make(warningLines, 64)
+
+	remote := newRemoteOutput("tcp", address, 8)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		remote.send([]byte("lost\n"))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("sending to a down collector took %v", elapsed)
+	}
+	// One line may sit in the sink goroutine and eight in the queue; everything else is counted.
+	if dropped := remote.dropped.Load(); dropped < 91 {
+		t.Fatalf("dropped = %d, want at least 91", dropped)
+	}
+}
+
+func TestParseRemote(t *testing.T) {
+	network, address, err := ParseRemote("udp://127.0.0.1:5170")
+	if err != nil || network != "udp" || address != "127.0.0.1:5170" {
+		t.Fatalf("ParseRemote = %q, %q, %v", network, address, err)
+	}
+	for _, spec := range []string{"127.0.0.1:5170", "http://127.0.0.1:5170", "tcp://127.0.0.1"} {
+		if _, _, err := ParseRemote(spec); err == nil {
+			t.Fatalf("ParseRemote(%q) returned no error", spec)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Log writes can fail for reasons such as ENOSPC on a full disk, and log.Logger hides those errors.
+// A forwarding daemon must keep forwarding regardless, so failed lines are dropped and the failure is reported to stderr.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// logWriteWarningInterval rate-limits the stderr warnings while the log file keeps refusing lines.
+const logWriteWarningInterval = time.Minute
+
+// logWarnings receives the drop warnings; tests swap it to observe them.
+var logWarnings io.Writer = os.Stderr
+
+// openLogFileForAppend is the single place log files are opened, so tests can simulate a full or broken disk.
+var openLogFileForAppend = func(logFile string) (*os.File, error) {
+	return os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+}
+
+// resilientOutput writes to the log file and drops lines the file refuses instead of returning errors.
+// Every write is serialized by log.Logger or the buffer goroutine, so its counters need no locking.
+type resilientOutput struct {
+	name        string
+	file        io.Writer // file is nil while the log could not be reopened after rotation.
+	openErr     error     // openErr explains why file is nil.
+	dropped     int
+	lastWarning time.Time
+}
+
+func newResilientOutput(name string, file io.Writer) *resilientOutput {
+	return &resilientOutput{name: name, file: file}
+}
+
+// unavailableOutput drops every line until rotation manages to reopen the file.
+func unavailableOutput(name string, openErr error) *resilientOutput {
+	return &resilientOutput{name: name, openErr: openErr}
+}
+
+func (output *resilientOutput) Write(payload []byte) (int, error) {
+	err := output.openErr
+	if output.file != nil {
+		_, err = output.file.Write(payload)
+	}
+
+	if err == nil {
+		if output.dropped > 0 {
+			fmt.Fprintf(logWarnings, "Log %s is writable again; %d lines were dropped\n", output.name, output.dropped)
+			output.dropped = 0
+		}
+		return len(payload), nil
+	}
+
+	output.dropped++
+	if output.dropped == 1 || time.Since(output.lastWarning) >= logWriteWarningInterval {
+		fmt.Fprintf(logWarnings, "Dropping log lines for %s: %v (%d dropped so far)\n", output.name, err, output.dropped)
+		output.lastWarning = time.Now()
+	}
+	return len(payload), nil
+}
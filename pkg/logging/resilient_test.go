@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestRotationSurvivesReopenFailureAndResumes(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "proxy.log")
+	logger, file, err := SetupLogger(logPath, Options{})
+	if err != nil {
+		t.Fatalf("SetupLogger returned error: %v", err)
+	}
+
+	var warnings bytes.Buffer
+	originalWarnings, originalOpen := logWarnings, openLogFileForAppend
+	t.Cleanup(func() { logWarnings, openLogFileForAppend = originalWarnings, originalOpen })
+	logWarnings = &warnings
+	openLogFileForAppend = func(string) (*os.File, error) { return nil, syscall.ENOSPC }
+
+	if next, err := rotateOnce(logPath, file, logger, logger); next != nil || err == nil {
+		t.Fatalf("rotateOnce = %v, %v; want a failed reopen", next, err)
+	}
+	logger.Print("lost while the disk is full")
+	logger.Print("also lost")
+	if !strings.Contains(warnings.String(), "no space left on device") || strings.Count(warnings.String(), "Dropping log lines") != 1 {
+		t.Fatalf("warnings = %q, want one rate-limited ENOSPC warning", warnings.String())
+	}
+	if reopened := reopenLogFile(logPath, logger, logger); reopened != nil {
+		t.Fatal("reopenLogFile succeeded while the disk was still full")
+	}
+
+	openLogFileForAppend = originalOpen
+	reopened := reopenLogFile(logPath, logger, logger)
+	if reopened == nil {
+		t.Fatal("reopenLogFile did not resume logging once space returned")
+	}
+	defer reopened.Close()
+	logger.Print("back on disk")
+
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("os.ReadFile returned error: %v", err)
+	}
+	if !strings.Contains(string(content), "back on disk") || strings.Contains(string(content), "lost") {
+		t.Fatalf("log after recovery = %q", content)
+	}
+}
@@ -0,0 +1,107 @@
+// Ring rotation keeps a fixed number of numbered archives and rotates on size alone.
+// It suits hosts where disk usage must stay bounded and dates in file names carry no meaning.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Supported values for the -log-mode flag.
+const (
+	ModeDated = "dated"
+	ModeRing  = "ring"
+)
+
+// ValidateMode rejects unknown rotation modes before any file is touched.
+func ValidateMode(mode string) error {
+	switch mode {
+	case "", ModeDated, ModeRing:
+		return nil
+	default:
+		return fmt.Errorf("unknown log mode '%s' (expected dated or ring)", mode)
+	}
+}
+
+// RotateRing checks the file size every minute and shifts app.log into app.log.1 ... app.log.N once it is full.
+// Running in its own goroutine mirrors RotateLogs so main can start either one the same way.
+func RotateRing(logFile string, file *os.File, logger *log.Logger, maxSizeBytes int64, keep int) {
+	rotateRing(logFile, file, logger, logger, maxSizeBytes, keep)
+}
+
+// rotateRing is the ring counterpart of rotateDated, with the same split between output and status.
+func rotateRing(logFile string, file *os.File, output, status *log.Logger, maxSizeBytes int64, keep int) {
+	if maxSizeBytes <= 0 {
+		maxSizeBytes = DefaultMaxSizeBytes
+	}
+
+	sizeTicker := time.NewTicker(time.Minute)
+	defer sizeTicker.Stop()
+
+	currentFile := file
+	for range sizeTicker.C {
+		if currentFile == nil {
+			currentFile = reopenLogFile(logFile, output, status)
+			continue
+		}
+		info, err := currentFile.Stat()
+		if err != nil {
+			status.Printf("Error stating log file for rotation: %v", err)
+			continue
+		}
+		if info.Size() < maxSizeBytes {
+			continue
+		}
+
+		currentFile, _ = rotateRingOnce(logFile, currentFile, output, status, keep)
+	}
+}
+
+// rotateRingOnce drops the oldest archive, shifts the rest up by one, and starts a fresh log file.
+// Shifting from the oldest end means no rename ever overwrites an archive that is still needed.
+func rotateRingOnce(logFile string, currentFile *os.File, output, status *log.Logger, keep int) (*os.File, error) {
+	if keep < 1 {
+		keep = 1
+	}
+
+	if err := Flush(output); err != nil {
+		status.Printf("Error flushing buffered logs before rotation: %v", err)
+	}
+	if err := currentFile.Sync(); err != nil {
+		status.Printf("Error syncing log file before rotation: %v", err)
+	}
+	if err := currentFile.Close(); err != nil {
+		status.Printf("Error closing log file before rotation: %v", err)
+	}
+
+	if err := os.Remove(ringArchiveName(logFile, keep)); err != nil && !os.IsNotExist(err) {
+		status.Printf("Error removing oldest log archive: %v", err)
+	}
+	for index := keep - 1; index >= 1; index-- {
+		if err := os.Rename(ringArchiveName(logFile, index), ringArchiveName(logFile, index+1)); err != nil && !os.IsNotExist(err) {
+			status.Printf("Error shifting log archive %d: %v", index, err)
+		}
+	}
+	if err := os.Rename(logFile, ringArchiveName(logFile, 1)); err != nil {
+		status.Printf("Error rotating logs: %v", err)
+	}
+
+	if safeErr := validateSafeLogPath(logFile); safeErr != nil {
+		abandonLogFile(logFile, output, fmt.Errorf("refusing to create unsafe log path after rotation: %v", safeErr))
+		return nil, safeErr
+	}
+	newFile, err := openLogFileForAppend(logFile)
+	if err != nil {
+		abandonLogFile(logFile, output, fmt.Errorf("failed to create new log file after rotation: %v", err))
+		return nil, err
+	}
+	redirectOutput(output, newResilientOutput(logFile, newFile))
+	status.Printf("Log file rotated; keeping %d numbered archives.", keep)
+	return newFile, nil
+}
+
+func ringArchiveName(logFile string, index int) string {
+	return fmt.Sprintf("%s.%d", logFile, index)
+}
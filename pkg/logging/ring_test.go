@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotateRingOnceShiftsArchivesAndKeepsN(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	logger, file, err := SetupLogger(logPath, Options{})
+	if err != nil {
+		t.Fatalf("SetupLogger returned error: %v", err)
+	}
+
+	for _, generation := range []string{"first", "second", "third"} {
+		logger.Print(generation)
+		file, err = rotateRingOnce(logPath, file, logger, logger, 2)
+		if err != nil {
+			t.Fatalf("rotateRingOnce returned error: %v", err)
+		}
+	}
+	defer file.Close()
+
+	for path, want := range map[string]string{logPath + ".1": "third", logPath + ".2": "second"} {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("os.ReadFile(%s) returned error: %v", path, err)
+		}
+		if !strings.HasSuffix(string(content), want+"\n") {
+			t.Fatalf("%s = %q, want it to end with %q", filepath.Base(path), content, want)
+		}
+	}
+	if _, err := os.Stat(logPath + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("ring kept more than 2 archives: %v", err)
+	}
+}
+
+func TestValidateModeRejectsUnknownMode(t *testing.T) {
+	if err := ValidateMode("weekly"); err == nil {
+		t.Fatal("ValidateMode accepted an unknown mode")
+	}
+	if err := ValidateMode(ModeRing); err != nil {
+		t.Fatalf("ValidateMode(ring) returned error: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+// Metrics files let a node-exporter textfile collector pick up the counters where no scrape port may be opened.
+// Each snapshot is written to a temporary file and renamed over the target, so the collector never reads half a file.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WriteFile atomically replaces path with the current snapshot.
+// The temporary file lives in the same directory because rename is only atomic within one filesystem.
+func WriteFile(path string, set *Set) error {
+	var content bytes.Buffer
+	if err := set.WriteText(&content); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary metrics file: %v", err)
+	}
+	// Removing after a successful rename fails harmlessly, so one deferred cleanup covers every error path.
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(content.Bytes()); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write metrics file: %v", err)
+	}
+	// CreateTemp makes the file private, but the collector usually runs as another user.
+	if err := temp.Chmod(0644); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to set metrics file permissions: %v", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics file: %v", err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace metrics file: %v", err)
+	}
+	return nil
+}
+
+// WriteFilePeriodically writes a snapshot right away and then every interval, for the life of the process.
+// A failure is logged once until the error changes or writing recovers, so a full disk does not flood the log.
+func WriteFilePeriodically(path string, interval time.Duration, set *Set, logger *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastError := ""
+	for {
+		err := WriteFile(path, set)
+		switch {
+		case err != nil && err.Error() != lastError:
+			logger.Printf("Metrics file %s not updated: %v", path, err)
+			lastError = err.Error()
+		case err == nil && lastError != "":
+			logger.Printf("Metrics file %s is being written again", path)
+			lastError = ""
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,321 @@
+// Package metrics counts forwarded bytes, flows, and drops per route and renders them in the Prometheus text format.
+// Hot-path counters are atomics owned by each route; the route table itself belongs to one goroutine, like the proxy registry.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// DropReason says why a client or packet was refused before it became a flow.
+type DropReason int
+
+const (
+	DropNotAllowed  DropReason = iota // DropNotAllowed is a source IP outside -allow.
+	DropLimit                         // DropLimit is a client refused at the route's connection or session limit.
+	DropQueueFull                     // DropQueueFull is a UDP packet dropped because a queue was full.
+	DropDialFailed                    // DropDialFailed is a UDP packet dropped because the backend could not be dialed.
+	DropQuota                         // DropQuota is a client refused because the route used up its byte quota for the window.
+	DropMemory                        // DropMemory is a UDP packet of a new client dropped while memory pressure pauses new sessions.
+	DropSessionRate                   // DropSessionRate is a UDP packet of a new client dropped over -udp-new-session-rate.
+	DropSchedule                      // DropSchedule is a client refused outside the time windows of the route's schedule.
+	dropReasonCount
+)
+
+var dropReasonLabels = [dropReasonCount]string{"not_allowed", "limit", "queue_full", "dial_failed", "quota", "memory", "session_rate", "schedule"}
+
+// Route holds the counters of one route ID, protocol, listen address, and target.
+// A nil Route ignores every call, so forwarding code never checks whether metrics are on.
+type Route struct {
+	instance    string
+	id          string
+	protocol    string
+	listen      string
+	target      string
+	clientBytes atomic.Uint64
+	serverBytes atomic.Uint64
+	opened      atomic.Uint64
+	active      atomic.Int64
+	drops       [dropReasonCount]atomic.Uint64
+	dialErrors  atomic.Uint64
+	closed      chan<- closeEvent
+}
+
+// Set is the table of routes that have served traffic since start.
+// A nil Set hands out nil Routes, which keeps metrics opt-in.
+type Set struct {
+	instance string // instance labels every series when set, so a dashboard can tell proxies apart.
+	requests chan setRequest
+	closes   chan closeEvent
+}
+
+type setRequest struct {
+	id, protocol, listen, target string
+	snapshot                     bool
+	reply                        chan setReply
+}
+
+type setReply struct {
+	route  *Route
+	routes []routeSnapshot
+}
+
+type closeEvent struct {
+	route  *Route
+	reason string
+}
+
+// routeSnapshot is what rendering needs from a route, copied out by the owner goroutine.
+type routeSnapshot struct {
+	route  *Route
+	closed map[string]uint64
+}
+
+// NewSet starts the goroutine that owns the route table and the per-reason close counts.
+// A non-empty instance becomes the first label of every series; check it with ValidInstanceName first.
+func NewSet(instance string) *Set {
+	set := &Set{instance: instance, requests: make(chan setRequest), closes: make(chan closeEvent, 256)}
+	go set.run()
+	return set
+}
+
+func (set *Set) run() {
+	routes := make(map[string]*Route)
+	closed := make(map[*Route]map[string]uint64)
+
+	for {
+		select {
+		case event := <-set.closes:
+			if closed[event.route] == nil {
+				closed[event.route] = make(map[string]uint64)
+			}
+			closed[event.route][event.reason]++
+
+		case request := <-set.requests:
+			if !request.snapshot {
+				key := request.id + " " + request.protocol + " " + request.listen + " " + request.target
+				route, ok := routes[key]
+				if !ok {
+					route = &Route{instance: set.instance, id: request.id, protocol: request.protocol, listen: request.listen, target: request.target, closed: set.closes}
+					routes[key] = route
+				}
+				request.reply <- setReply{route: route}
+				continue
+			}
+
+			snapshots := make([]routeSnapshot, 0, len(routes))
+			for _, route := range routes {
+				reasons := make(map[string]uint64, len(closed[route]))
+				for reason, count := range closed[route] {
+					reasons[reason] = count
+				}
+				snapshots = append(snapshots, routeSnapshot{route: route, closed: reasons})
+			}
+			sort.Slice(snapshots, func(i, j int) bool {
+				return snapshots[i].route.labels() < snapshots[j].route.labels()
+			})
+			request.reply <- setReply{routes: snapshots}
+		}
+	}
+}
+
+// Route returns the counters for a route, creating them on first use.
+// A route that is stopped and started again gets its old counters back, so totals never go backwards.
+func (set *Set) Route(id, protocol, listen, target string) *Route {
+	if set == nil {
+		return nil
+	}
+	reply := make(chan setReply, 1)
+	set.requests <- setRequest{id: id, protocol: protocol, listen: listen, target: target, reply: reply}
+	return (<-reply).route
+}
+
+// AddBytes counts bytes sent by the client ("client") or by the backend ("server").
+func (route *Route) AddBytes(sender string, n int) {
+	if route == nil || n <= 0 {
+		return
+	}
+	if sender == "client" {
+		route.clientBytes.Add(uint64(n))
+		return
+	}
+	route.serverBytes.Add(uint64(n))
+}
+
+// Bytes returns everything the route has forwarded in both directions since it was first created.
+func (route *Route) Bytes() uint64 {
+	if route == nil {
+		return 0
+	}
+	return route.clientBytes.Load() + route.serverBytes.Load()
+}
+
+// Opened counts a new TCP connection or UDP session.
+func (route *Route) Opened() {
+	if route == nil {
+		return
+	}
+	route.opened.Add(1)
+	route.active.Add(1)
+}
+
+// Closed counts a finished flow under its close reason.
+// The per-reason count goes through the set's goroutine; closes are rare next to bytes, so the channel costs little.
+func (route *Route) Closed(reason string) {
+	if route == nil {
+		return
+	}
+	route.active.Add(-1)
+	route.closed <- closeEvent{route: route, reason: reason}
+}
+
+// Dropped counts a refused client or packet.
+func (route *Route) Dropped(reason DropReason) {
+	if route == nil {
+		return
+	}
+	route.drops[reason].Add(1)
+}
+
+// DialFailed counts one failed attempt to reach the backend, so a redial that fails again counts twice.
+func (route *Route) DialFailed() {
+	if route == nil {
+		return
+	}
+	route.dialErrors.Add(1)
+}
+
+// WriteText renders every route in the Prometheus text exposition format, one family at a time.
+func (set *Set) WriteText(w io.Writer) error {
+	if set == nil {
+		return nil
+	}
+	reply := make(chan setReply, 1)
+	set.requests <- setRequest{snapshot: true, reply: reply}
+	routes := (<-reply).routes
+
+	var out strings.Builder
+	family := func(name, kind, help string, samples func(add func(labels string, value string))) {
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		samples(func(labels, value string) {
+			fmt.Fprintf(&out, "%s{%s} %s\n", name, labels, value)
+		})
+	}
+
+	family("chicha_ip_proxy_route_info", "gauge", "Routes that have served since start; the value is always 1.", func(add func(string, string)) {
+		for _, snapshot := range routes {
+			add(snapshot.route.labels(), "1")
+		}
+	})
+	family("chicha_ip_proxy_bytes_total", "counter", "Bytes forwarded, by the side that sent them.", func(add func(string, string)) {
+		for _, snapshot := range routes {
+			add(snapshot.route.labels()+`,sender="client"`, fmt.Sprint(snapshot.route.clientBytes.Load()))
+			add(snapshot.route.labels()+`,sender="server"`, fmt.Sprint(snapshot.route.serverBytes.Load()))
+		}
+	})
+	family("chicha_ip_proxy_flows_opened_total", "counter", "TCP connections and UDP sessions opened.", func(add func(string, string)) {
+		for _, snapshot := range routes {
+			add(snapshot.route.labels(), fmt.Sprint(snapshot.route.opened.Load()))
+		}
+	})
+	family("chicha_ip_proxy_flows_active", "gauge", "TCP connections and UDP sessions open right now.", func(add func(string, string)) {
+		for _, snapshot := range routes {
+			add(snapshot.route.labels(), fmt.Sprint(snapshot.route.active.Load()))
+		}
+	})
+	family("chicha_ip_proxy_flows_closed_total", "counter", "Finished TCP connections and UDP sessions, by close reason.", func(add func(string, string)) {
+		for _, snapshot := range routes {
+			reasons := make([]string, 0, len(snapshot.closed))
+			for reason := range snapshot.closed {
+				reasons = append(reasons, reason)
+			}
+			sort.Strings(reasons)
+			for _, reason := range reasons {
+				add(snapshot.route.labels()+`,reason="`+escapeLabel(reason)+`"`, fmt.Sprint(snapshot.closed[reason]))
+			}
+		}
+	})
+	family("chicha_ip_proxy_drops_total", "counter", "Clients and packets refused before they became flows, by reason.", func(add func(string, string)) {
+		for _, snapshot := range routes {
+			for reason := DropReason(0); reason < dropReasonCount; reason++ {
+				add(snapshot.route.labels()+`,reason="`+dropReasonLabels[reason]+`"`, fmt.Sprint(snapshot.route.drops[reason].Load()))
+			}
+		}
+	})
+
+	family("chicha_ip_proxy_dial_errors_total", "counter", "Failed attempts to connect to the backend, TCP dials and UDP session dials alike.", func(add func(string, string)) {
+		for _, snapshot := range routes {
+			add(snapshot.route.labels(), fmt.Sprint(snapshot.route.dialErrors.Load()))
+		}
+	})
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// RouteCounters is a copy of one route's counters, for reports other than the Prometheus text.
+type RouteCounters struct {
+	Route       string // Route is the route ID, as in logs and the admin API.
+	Protocol    string
+	Listen      string
+	Target      string
+	ClientBytes uint64 // ClientBytes were sent by clients toward the backend.
+	ServerBytes uint64 // ServerBytes were sent by the backend back to clients.
+	Opened      uint64
+	Active      int64
+	Drops       uint64 // Drops sums every drop reason.
+	DialErrors  uint64
+}
+
+// Routes copies the counters of every route, in the same order WriteText renders them.
+func (set *Set) Routes() []RouteCounters {
+	if set == nil {
+		return nil
+	}
+	reply := make(chan setReply, 1)
+	set.requests <- setRequest{snapshot: true, reply: reply}
+
+	snapshots := (<-reply).routes
+	counters := make([]RouteCounters, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		route := snapshot.route
+		copied := RouteCounters{Route: route.id, Protocol: route.protocol, Listen: route.listen, Target: route.target,
+			ClientBytes: route.clientBytes.Load(), ServerBytes: route.serverBytes.Load(), Opened: route.opened.Load(), Active: route.active.Load(),
+			DialErrors: route.dialErrors.Load()}
+		for reason := DropReason(0); reason < dropReasonCount; reason++ {
+			copied.Drops += route.drops[reason].Load()
+		}
+		counters = append(counters, copied)
+	}
+	return counters
+}
+
+func (route *Route) labels() string {
+	labels := fmt.Sprintf(`route="%s",protocol="%s",listen="%s",target="%s"`, escapeLabel(route.id), escapeLabel(route.protocol), escapeLabel(route.listen), escapeLabel(route.target))
+	if route.instance != "" {
+		labels = `instance="` + route.instance + `",` + labels
+	}
+	return labels
+}
+
+// ValidInstanceName accepts 1 to 253 letters, digits, dots, dashes, and underscores, which every hostname fits.
+// Such a name needs no escaping as a label value or a log field, so it reads the same in a dashboard and in grep.
+func ValidInstanceName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// escapeLabel applies the exposition format's escapes for label values.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTextRendersRouteCounters(t *testing.T) {
+	set := NewSet("")
+	route := set.Route("web", "tcp", "[::]:8080", "203.0.113.10:80")
+	route.Opened()
+	route.Opened()
+	route.AddBytes("client", 100)
+	route.AddBytes("server", 250)
+	route.Closed("client_eof")
+	route.Dropped(DropNotAllowed)
+	route.DialFailed()
+
+	if set.Route("web", "tcp", "[::]:8080", "203.0.113.10:80") != route {
+		t.Fatal("Route returned new counters for a known route")
+	}
+
+	// Closes are counted by the set's goroutine, so poll until the snapshot includes them.
+	var text string
+	deadline := time.Now().Add(time.Second)
+	for {
+		var out strings.Builder
+		if err := set.WriteText(&out); err != nil {
+			t.Fatalf("WriteText returned error: %v", err)
+		}
+		text = out.String()
+		if strings.Contains(text, "chicha_ip_proxy_flows_closed_total{") || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	labels := `route="web",protocol="tcp",listen="[::]:8080",target="203.0.113.10:80"`
+	for _, want := range []string{
+		"# TYPE chicha_ip_proxy_bytes_total counter\n",
+		"chicha_ip_proxy_route_info{" + labels + "} 1\n",
+		"chicha_ip_proxy_bytes_total{" + labels + `,sender="client"} 100` + "\n",
+		"chicha_ip_proxy_bytes_total{" + labels + `,sender="server"} 250` + "\n",
+		"chicha_ip_proxy_flows_opened_total{" + labels + "} 2\n",
+		"chicha_ip_proxy_flows_active{" + labels + "} 1\n",
+		"chicha_ip_proxy_flows_closed_total{" + labels + `,reason="client_eof"} 1` + "\n",
+		"chicha_ip_proxy_drops_total{" + labels + `,reason="not_allowed"} 1` + "\n",
+		"chicha_ip_proxy_drops_total{" + labels + `,reason="dial_failed"} 0` + "\n",
+		"chicha_ip_proxy_dial_errors_total{" + labels + "} 1\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("WriteText output is missing %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestInstanceLabelsEverySeries(t *testing.T) {
+	set := NewSet("edge-1.example")
+	set.Route("udp/53", "udp", ":53", "203.0.113.20:53").Opened()
+
+	var out strings.Builder
+	if err := set.WriteText(&out); err != nil {
+		t.Fatalf("WriteText returned error: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if !strings.HasPrefix(line, "#") && !strings.Contains(line, `{instance="edge-1.example",route="udp/53",`) {
+			t.Fatalf("series without the instance label: %q", line)
+		}
+	}
+}
+
+func TestValidInstanceNameRejectsLabelBreakingCharacters(t *testing.T) {
+	for _, name := range []string{"edge-1", "edge_1.example.com", "EU1"} {
+		if !ValidInstanceName(name) {
+			t.Fatalf("ValidInstanceName(%q) = false", name)
+		}
+	}
+	for _, name := range []string{"", `edge"1`, `edge\1`, "edge 1", "edge\n1", "edge=1", strings.Repeat("a", 254)} {
+		if ValidInstanceName(name) {
+			t.Fatalf("ValidInstanceName(%q) = true", name)
+		}
+	}
+}
+
+func TestNilRouteIgnoresCounters(t *testing.T) {
+	var set *Set
+	route := set.Route("udp/53", "udp", ":53", "203.0.113.20:53")
+	if route != nil {
+		t.Fatal("nil Set returned a Route")
+	}
+	route.Opened()
+	route.AddBytes("client", 10)
+	route.Dropped(DropLimit)
+	route.Closed("idle_timeout")
+}
+
+func TestWriteFileReplacesTargetAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "chicha-ip-proxy.prom")
+	if err := os.WriteFile(path, []byte("stale\n"), 0600); err != nil {
+		t.Fatalf("os.WriteFile returned error: %v", err)
+	}
+
+	set := NewSet("")
+	set.Route("udp/53", "udp", ":53", "203.0.113.20:53").AddBytes("server", 42)
+	if err := WriteFile(path, set); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile returned error: %v", err)
+	}
+	if !strings.Contains(string(content), `sender="server"} 42`) {
+		t.Fatalf("metrics file does not contain the snapshot:\n%s", content)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat returned error: %v", err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("metrics file mode = %v, want 0644", info.Mode().Perm())
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("os.ReadDir returned error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("directory holds %d entries after WriteFile, want only the metrics file", len(entries))
+	}
+}
@@ -0,0 +1,33 @@
+// Package netns moves the whole proxy into a Linux network namespace before it binds or dials anything.
+// Namespaces belong to threads, not processes, so entering one takes a re-exec; the details live in netns_linux.go.
+package netns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// namedDir is where ip netns add creates its namespaces.
+const namedDir = "/var/run/netns/"
+
+// Path turns the -netns value into the namespace file to open: a name made by ip netns, a process ID, or a path.
+func Path(spec string) (string, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "":
+		return "", fmt.Errorf("network namespace is empty")
+	case strings.Contains(spec, "/"):
+		return spec, nil
+	}
+	if pid, err := strconv.Atoi(spec); err == nil {
+		if pid <= 0 {
+			return "", fmt.Errorf("invalid process ID '%s' for a network namespace", spec)
+		}
+		return "/proc/" + spec + "/ns/net", nil
+	}
+	if spec == "." || spec == ".." {
+		return "", fmt.Errorf("invalid network namespace name '%s'", spec)
+	}
+	return namedDir + spec, nil
+}
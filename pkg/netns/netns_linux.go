@@ -0,0 +1,45 @@
+//go:build linux
+// +build linux
+
+// setns(2) moves only the calling thread, and the Go scheduler runs goroutines on many threads it starts itself.
+// So the namespace is entered on a locked thread that then re-executes the binary: exec keeps only that thread, and every thread the new runtime starts inherits its namespace.
+package netns
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// Enter re-executes the process inside the network namespace at path and so only returns when it is already inside, or on failure.
+// It must run before anything that should live in the namespace, such as listeners or log sockets, and needs CAP_SYS_ADMIN.
+func Enter(path string) error {
+	target, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot open network namespace %s: %v", path, err)
+	}
+	current, err := os.Stat("/proc/self/ns/net")
+	if err != nil {
+		return fmt.Errorf("cannot read the current network namespace: %v", err)
+	}
+	// The re-executed process lands here again and finds itself inside, which ends the loop.
+	if os.SameFile(target, current) {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open network namespace %s: %v", path, err)
+	}
+	defer file.Close()
+
+	// The locked thread is never unlocked once it has moved: Go must not run other goroutines on it, or they would see a different network.
+	runtime.LockOSThread()
+	if _, _, errno := syscall.RawSyscall(sysSetns, file.Fd(), syscall.CLONE_NEWNET, 0); errno != 0 {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("cannot enter network namespace %s: %v", path, errno)
+	}
+	err = syscall.Exec("/proc/self/exe", os.Args, os.Environ())
+	return fmt.Errorf("cannot restart inside network namespace %s: %v", path, err)
+}
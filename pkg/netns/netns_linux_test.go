@@ -0,0 +1,22 @@
+//go:build linux
+// +build linux
+
+package netns
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEnterReturnsAtOnceInsideTheNamespace(t *testing.T) {
+	// The process's own namespace is the case a re-executed proxy hits, so it must return without another exec.
+	if err := Enter("/proc/self/ns/net"); err != nil {
+		t.Fatalf("Enter returned error: %v", err)
+	}
+}
+
+func TestEnterReportsAMissingNamespace(t *testing.T) {
+	if err := Enter(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("Enter accepted a namespace that does not exist")
+	}
+}
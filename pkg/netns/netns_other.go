@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+// Network namespaces are a Linux feature; elsewhere -netns fails before any listener starts.
+// Keeping the stub here lets main call Enter unconditionally and report the same error on every platform.
+package netns
+
+import "errors"
+
+// Enter always fails outside Linux.
+func Enter(path string) error {
+	return errors.New("-netns is only supported on Linux")
+}
@@ -0,0 +1,27 @@
+package netns
+
+import "testing"
+
+func TestPathResolvesNamesPIDsAndPaths(t *testing.T) {
+	tests := map[string]string{
+		"blue":                  "/var/run/netns/blue",
+		"1234":                  "/proc/1234/ns/net",
+		"/run/netns/green":      "/run/netns/green",
+		" /proc/self/ns/net ":   "/proc/self/ns/net",
+		"./relative/to/workdir": "./relative/to/workdir",
+	}
+	for spec, want := range tests {
+		got, err := Path(spec)
+		if err != nil || got != want {
+			t.Fatalf("Path(%q) = %q, %v; want %q", spec, got, err, want)
+		}
+	}
+}
+
+func TestPathRejectsEmptyAndInvalidValues(t *testing.T) {
+	for _, spec := range []string{"", "  ", "0", "-5", ".", ".."} {
+		if path, err := Path(spec); err == nil {
+			t.Fatalf("Path(%q) = %q, want an error", spec, path)
+		}
+	}
+}
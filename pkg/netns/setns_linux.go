@@ -0,0 +1,9 @@
+//go:build linux && !amd64 && !386
+// +build linux,!amd64,!386
+
+// Every other Linux architecture's syscall table has setns.
+package netns
+
+import "syscall"
+
+const sysSetns = syscall.SYS_SETNS
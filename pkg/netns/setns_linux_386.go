@@ -0,0 +1,4 @@
+// The syscall package lists no SYS_SETNS for 386, whose tables predate setns, so the number is spelled out here.
+package netns
+
+const sysSetns = 346
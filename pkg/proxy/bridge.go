@@ -0,0 +1,129 @@
+// Bridged routes listen on one protocol and reach the backend on the other, for legacy systems split between TCP and UDP.
+// On the TCP side every datagram travels as one frame: a 2-byte big-endian payload length followed by the payload, as in DNS over TCP.
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	bridgeFrameHeader = 2
+	// maxBridgeFrame is the largest payload a 2-byte length can describe, which also covers any UDP datagram.
+	maxBridgeFrame = 1<<16 - 1
+)
+
+// framedConn turns a TCP stream into datagrams: each Read returns exactly one frame's payload and each Write sends one frame.
+// The buffered reader holds a partly received frame across read deadlines, so an idle check never splits a message.
+type framedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func newFramedConn(conn net.Conn) *framedConn {
+	return &framedConn{Conn: conn, reader: bufio.NewReaderSize(conn, bridgeFrameHeader+maxBridgeFrame)}
+}
+
+// Read returns the next frame, or io.ErrShortBuffer without consuming it when p cannot hold the whole payload.
+func (c *framedConn) Read(p []byte) (int, error) {
+	header, err := c.reader.Peek(bridgeFrameHeader)
+	if err != nil {
+		if err == io.EOF && c.reader.Buffered() > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	size := int(binary.BigEndian.Uint16(header))
+	if size > len(p) {
+		return 0, io.ErrShortBuffer
+	}
+	frame, err := c.reader.Peek(bridgeFrameHeader + size)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	n := copy(p, frame[bridgeFrameHeader:])
+	_, _ = c.reader.Discard(bridgeFrameHeader + size)
+	return n, nil
+}
+
+// Write sends p as one frame; header and payload go out in a single write so concurrent frames cannot interleave on the wire.
+func (c *framedConn) Write(p []byte) (int, error) {
+	if len(p) > maxBridgeFrame {
+		return 0, fmt.Errorf("bridged message of %d bytes exceeds the %d byte frame limit", len(p), maxBridgeFrame)
+	}
+	frame := make([]byte, bridgeFrameHeader+len(p))
+	binary.BigEndian.PutUint16(frame, uint16(len(p)))
+	copy(frame[bridgeFrameHeader:], p)
+	if err := writeFull(c.Conn, frame); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// dialStreamTarget connects a TCP client's flow to its backend: a TCP stream normally, or a datagram socket on a bridged route.
+// client is nil for health probes; the egress policy only checks it for loops.
+func dialStreamTarget(targetAddr string, client net.Addr, options Options) (net.Conn, error) {
+	targetAddr, err := options.EgressPolicy.check(targetAddr, client)
+	if err != nil {
+		return nil, err
+	}
+	if !options.TargetUDP {
+		return dialTCPTarget(targetAddr, options.EgressPool, options.DialLimiter, options.TCPFastOpen)
+	}
+	// Returning the error before the conversion keeps a nil *net.UDPConn from becoming a non-nil net.Conn.
+	conn, err := dialUDPTarget(targetAddr, options.UDPEgressPool)
+	if err != nil {
+		return nil, err
+	}
+	if options.UDPPMTUD {
+		if err := setUDPDontFragment(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("set don't-fragment: %v", err)
+		}
+	}
+	return conn, nil
+}
+
+// dialSessionTarget connects a UDP session to its backend: a datagram socket normally, or a framed TCP stream on a bridged route.
+func dialSessionTarget(targetAddr string, client net.Addr, options Options) (net.Conn, error) {
+	targetAddr, err := options.EgressPolicy.check(targetAddr, client)
+	if err != nil {
+		return nil, err
+	}
+	if options.TargetTCP {
+		conn, err := dialTCPTarget(targetAddr, options.EgressPool, options.DialLimiter, options.TCPFastOpen)
+		if err != nil {
+			return nil, err
+		}
+		return newFramedConn(conn), nil
+	}
+	conn, err := dialUDPTarget(targetAddr, options.UDPEgressPool)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// copyBufferSize lets datagram-shaped sources return a whole message in one read; plain streams keep the smaller buffer.
+func copyBufferSize(src net.Conn) int {
+	switch src.(type) {
+	case *framedConn, *net.UDPConn:
+		return maxBridgeFrame
+	default:
+		return 32 * 1024
+	}
+}
+
+// bridgeTargetProtocol names the backend protocol of a TCP route for its log lines.
+func bridgeTargetProtocol(options Options) string {
+	if options.TargetUDP {
+		return "UDP"
+	}
+	return "TCP"
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+func frame(payload string) []byte {
+	framed := make([]byte, 2, 2+len(payload))
+	binary.BigEndian.PutUint16(framed, uint16(len(payload)))
+	return append(framed, payload...)
+}
+
+func TestTCPToUDPBridgeRelaysFramesAsDatagrams(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer backend.Close()
+	received := make(chan string, 4)
+	go func() {
+		buffer := make([]byte, 64*1024)
+		for {
+			n, addr, err := backend.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			received <- string(buffer[:n])
+			backend.WriteTo(append([]byte("re:"), buffer[:n]...), addr)
+		}
+	}()
+	proxyAddr := serveTCPForTest(t, backend.LocalAddr().String(), Options{TargetUDP: true})
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Two frames in one write must still arrive as two datagrams.
+	if _, err := conn.Write(append(frame("first"), frame("second")...)); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	for _, want := range []string{"first", "second"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("backend datagram = %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("backend never received %q", want)
+		}
+	}
+
+	replies := newFramedConn(conn)
+	buffer := make([]byte, maxBridgeFrame)
+	for _, want := range []string{"re:first", "re:second"} {
+		n, err := replies.Read(buffer)
+		if err != nil {
+			t.Fatalf("reading framed reply returned error: %v", err)
+		}
+		if string(buffer[:n]) != want {
+			t.Fatalf("framed reply = %q, want %q", buffer[:n], want)
+		}
+	}
+}
+
+func TestUDPToTCPBridgeRelaysDatagramsAsFrames(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		framed := newFramedConn(conn)
+		buffer := make([]byte, maxBridgeFrame)
+		for {
+			n, err := framed.Read(buffer)
+			if err != nil {
+				return
+			}
+			framed.Write(bytes.ToUpper(buffer[:n]))
+		}
+	}()
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	go ServeUDPProxy(listener, backend.Addr().String(), config.AllowList{}, log.New(io.Discard, "", 0), Options{TargetTCP: true})
+	defer listener.Close()
+
+	client, err := net.Dial("udp", listener.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer client.Close()
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	buffer := make([]byte, 1024)
+	for _, message := range []string{"query one", "query two"} {
+		if _, err := client.Write([]byte(message)); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+		n, err := client.Read(buffer)
+		if err != nil {
+			t.Fatalf("Read returned error: %v", err)
+		}
+		if want := string(bytes.ToUpper([]byte(message))); string(buffer[:n]) != want {
+			t.Fatalf("reply = %q, want %q", buffer[:n], want)
+		}
+	}
+}
+
+func TestFramedConnKeepsPartialFrameAcrossDeadline(t *testing.T) {
+	near, far := net.Pipe()
+	defer near.Close()
+	defer far.Close()
+	framed := newFramedConn(near)
+
+	whole := frame("split message")
+	go far.Write(whole[:5])
+	buffer := make([]byte, 64)
+	_ = framed.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := framed.Read(buffer); err == nil {
+		t.Fatal("Read returned a frame before all of it arrived")
+	}
+
+	go far.Write(whole[5:])
+	_ = framed.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := framed.Read(buffer)
+	if err != nil {
+		t.Fatalf("Read returned error: %v", err)
+	}
+	if string(buffer[:n]) != "split message" {
+		t.Fatalf("Read = %q, want the whole frame", buffer[:n])
+	}
+
+	go far.Write(frame("too long for the buffer"))
+	if _, err := framed.Read(make([]byte, 4)); err != io.ErrShortBuffer {
+		t.Fatalf("Read into a short buffer error = %v, want io.ErrShortBuffer", err)
+	}
+}
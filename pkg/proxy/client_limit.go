@@ -0,0 +1,83 @@
+// Per-IP limits stop one client from holding a route's whole connection budget while others are shed.
+// One goroutine owns the counts, so the accept loop and the connection goroutines that release slots never share memory.
+package proxy
+
+import "net/netip"
+
+// clientLimiter counts open TCP connections per client IP on one route; a nil *clientLimiter allows everything.
+type clientLimiter struct {
+	maxPerIP int
+	acquires chan clientAcquire
+	releases chan netip.Addr
+	stop     chan struct{}
+}
+
+type clientAcquire struct {
+	ip    netip.Addr
+	reply chan bool
+}
+
+// newClientLimiter returns nil for a zero limit, so routes without one skip the goroutine entirely.
+func newClientLimiter(maxPerIP int) *clientLimiter {
+	if maxPerIP <= 0 {
+		return nil
+	}
+	limiter := &clientLimiter{maxPerIP: maxPerIP, acquires: make(chan clientAcquire), releases: make(chan netip.Addr), stop: make(chan struct{})}
+	go limiter.run()
+	return limiter
+}
+
+// run deletes an IP once its last connection closes, so the map only holds clients that are connected now.
+// After close it keeps serving releases until the connections that outlive the listener are gone.
+func (limiter *clientLimiter) run() {
+	open := make(map[netip.Addr]int)
+	stop := limiter.stop
+	for {
+		if stop == nil && len(open) == 0 {
+			return
+		}
+		select {
+		case <-stop:
+			stop = nil
+		case request := <-limiter.acquires:
+			if open[request.ip] >= limiter.maxPerIP {
+				request.reply <- false
+				continue
+			}
+			open[request.ip]++
+			request.reply <- true
+		case ip := <-limiter.releases:
+			if open[ip] <= 1 {
+				delete(open, ip)
+				continue
+			}
+			open[ip]--
+		}
+	}
+}
+
+// acquire takes a slot for ip and reports false when the client already holds its limit.
+// IPv4 clients of a dual-stack socket arrive as IPv4-mapped addresses and count with their plain IPv4 form.
+func (limiter *clientLimiter) acquire(ip netip.Addr) bool {
+	if limiter == nil {
+		return true
+	}
+	reply := make(chan bool, 1)
+	limiter.acquires <- clientAcquire{ip: ip.Unmap(), reply: reply}
+	return <-reply
+}
+
+// close ends the limiter once its last connection is released; the listener calls it when it stops accepting.
+func (limiter *clientLimiter) close() {
+	if limiter != nil {
+		close(limiter.stop)
+	}
+}
+
+// release frees a slot taken by acquire.
+func (limiter *clientLimiter) release(ip netip.Addr) {
+	if limiter == nil {
+		return
+	}
+	limiter.releases <- ip.Unmap()
+}
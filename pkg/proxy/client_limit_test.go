@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// proxiedFrom dials the proxy from localIP and reports whether a line made it to the backend and back.
+func proxiedFrom(t *testing.T, proxyAddr, localIP string) (net.Conn, bool) {
+	t.Helper()
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(localIP)}}
+	conn, err := dialer.Dial("tcp", proxyAddr)
+	if err != nil {
+		// A reset can beat the handshake on loopback, which is a rejection all the same.
+		return nil, false
+	}
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		conn.Close()
+		return nil, false
+	}
+	if _, err := conn.Read(make([]byte, 16)); err != nil {
+		conn.Close()
+		return nil, false
+	}
+	return conn, true
+}
+
+func TestMaxConnectionsPerIPRejectsOnlyTheBusyClient(t *testing.T) {
+	proxyAddr := serveTCPForTest(t, startLineBackend(t, "pong\n"), Options{MaxConnectionsPerIP: 2})
+
+	var held []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, ok := proxiedFrom(t, proxyAddr, "127.0.0.1")
+		if !ok {
+			t.Fatalf("connection %d from 127.0.0.1 was refused below the limit", i+1)
+		}
+		held = append(held, conn)
+	}
+	if conn, ok := proxiedFrom(t, proxyAddr, "127.0.0.1"); ok {
+		conn.Close()
+		t.Fatal("third connection from 127.0.0.1 was proxied past -max-conns-per-ip=2")
+	}
+
+	other, ok := proxiedFrom(t, proxyAddr, "127.0.0.2")
+	if !ok {
+		t.Fatal("connection from 127.0.0.2 was refused while only 127.0.0.1 was at its limit")
+	}
+	other.Close()
+
+	// Closing one connection frees its slot once the proxy has seen the close.
+	held[0].Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, ok := proxiedFrom(t, proxyAddr, "127.0.0.1")
+		if ok {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("127.0.0.1 stayed at its limit after closing a connection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	held[1].Close()
+}
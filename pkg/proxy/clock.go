@@ -0,0 +1,36 @@
+// The UDP session manager reads time through a clock so tests can move it by hand instead of sleeping.
+// Production code always uses realClock, which is a thin wrapper over the time package.
+package proxy
+
+import "time"
+
+// clock supplies the current time and tickers to the UDP session manager and its sessions.
+type clock interface {
+	Now() time.Time
+	NewTicker(interval time.Duration) ticker
+}
+
+// ticker is the part of *time.Ticker the session manager needs, so a test clock can fire ticks on demand.
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock is the wall clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(interval time.Duration) ticker {
+	return realTicker{time.NewTicker(interval)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -0,0 +1,30 @@
+// Close reasons give every finished TCP connection and UDP session one standard explanation.
+// Sharing the enum between logs and the Observer keeps post-mortems and metrics in agreement.
+package proxy
+
+// CloseReason names why the proxy stopped forwarding a flow.
+type CloseReason string
+
+const (
+	CloseClientEOF      CloseReason = "client EOF"
+	CloseServerEOF      CloseReason = "server EOF"
+	CloseClientReset    CloseReason = "client reset"
+	CloseServerReset    CloseReason = "server reset"
+	CloseIdleTimeout    CloseReason = "idle timeout"
+	CloseMaxLifetime    CloseReason = "max lifetime"
+	CloseLimitShed      CloseReason = "limit shed"
+	CloseManualKill     CloseReason = "manual kill"
+	CloseHealthEjection CloseReason = "health ejection"
+	CloseError          CloseReason = "error"
+)
+
+// Observer is told exactly once about every flow the proxy stops forwarding.
+// It runs on the forwarding goroutine, so slow observers should hand work off to their own goroutine.
+type Observer func(info ConnectionInfo, reason CloseReason)
+
+// closed reports a finished flow and tolerates a nil observer so the hook stays opt-in.
+func (observer Observer) closed(info ConnectionInfo, reason CloseReason) {
+	if observer != nil {
+		observer(info, reason)
+	}
+}
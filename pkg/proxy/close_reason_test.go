@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHandleTCPConnectionReportsCloseReason(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend func(net.Conn)
+		options Options
+		client  func(*testing.T, net.Conn, *Registry)
+		want    CloseReason
+	}{
+		{
+			name:    "client EOF",
+			backend: func(conn net.Conn) { io.Copy(io.Discard, conn) },
+			client:  func(t *testing.T, conn net.Conn, _ *Registry) { conn.Close() },
+			want:    CloseClientEOF,
+		},
+		{
+			name:    "server EOF",
+			backend: func(conn net.Conn) {},
+			client:  func(*testing.T, net.Conn, *Registry) {},
+			want:    CloseServerEOF,
+		},
+		{
+			name:    "idle timeout",
+			backend: func(conn net.Conn) { io.Copy(io.Discard, conn) },
+			options: Options{HandshakeTimeout: 50 * time.Millisecond},
+			client:  func(*testing.T, net.Conn, *Registry) {},
+			want:    CloseIdleTimeout,
+		},
+		{
+			name:    "manual kill",
+			backend: func(conn net.Conn) { io.Copy(io.Discard, conn) },
+			options: Options{Registry: NewRegistry()},
+			client: func(t *testing.T, _ net.Conn, registry *Registry) {
+				connections := waitForConnections(t, registry, 1)
+				registry.Close(connections[0].ID)
+			},
+			want: CloseManualKill,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("net.Listen returned error: %v", err)
+			}
+			defer backend.Close()
+			serveBackend := tt.backend
+			go func() {
+				conn, err := backend.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				serveBackend(conn)
+			}()
+
+			reasons := make(chan CloseReason, 1)
+			options := tt.options
+			options.Observer = func(_ ConnectionInfo, reason CloseReason) { reasons <- reason }
+			clientConn, _ := startHandledConnection(t, backend.Addr().String(), options)
+			defer clientConn.Close()
+			tt.client(t, clientConn, options.Registry)
+
+			if got := waitForCloseReason(t, reasons); got != tt.want {
+				t.Fatalf("close reason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShedTCPConnectionReportsLimitShed(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	var observed ConnectionInfo
+	reasons := make(chan CloseReason, 1)
+	shedTCPConnection(serverConn, "127.0.0.1:8080", "203.0.113.10:80", "connection limit reached", log.New(io.Discard, "", 0), Options{
+		Observer: func(info ConnectionInfo, reason CloseReason) {
+			observed = info
+			reasons <- reason
+		},
+	}, nil)
+
+	if got := waitForCloseReason(t, reasons); got != CloseLimitShed {
+		t.Fatalf("close reason = %q, want %q", got, CloseLimitShed)
+	}
+	if observed.Protocol != "tcp" || observed.Target != "203.0.113.10:80" {
+		t.Fatalf("observed info = %#v", observed)
+	}
+}
+
+func TestManageUDPSessionsReportsManualKill(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer backend.Close()
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer responder.Close()
+
+	registry := NewRegistry()
+	reasons := make(chan CloseReason, 1)
+	msgChan := make(chan udpMessage, 1)
+	go manageUDPSessions(responder.LocalAddr().String(), backend.LocalAddr().String(), responder, log.New(io.Discard, "", 0), msgChan, Options{
+		Registry: registry,
+		Observer: func(_ ConnectionInfo, reason CloseReason) { reasons <- reason },
+	}, realClock{})
+
+	msgChan <- udpMessage{data: []byte("ping"), addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}}
+	connections := waitForConnections(t, registry, 1)
+	registry.Close(connections[0].ID)
+
+	if got := waitForCloseReason(t, reasons); got != CloseManualKill {
+		t.Fatalf("close reason = %q, want %q", got, CloseManualKill)
+	}
+}
+
+func TestShedUDPSessionReportsLimitShed(t *testing.T) {
+	reasons := make(chan CloseReason, 1)
+	shedUDPSession("198.51.100.7:5353", "127.0.0.1:53", "203.0.113.20:53", log.New(io.Discard, "", 0), Options{
+		Observer: func(_ ConnectionInfo, reason CloseReason) { reasons <- reason },
+	})
+
+	if got := waitForCloseReason(t, reasons); got != CloseLimitShed {
+		t.Fatalf("close reason = %q, want %q", got, CloseLimitShed)
+	}
+}
+
+func waitForCloseReason(t *testing.T, reasons <-chan CloseReason) CloseReason {
+	t.Helper()
+
+	select {
+	case reason := <-reasons:
+		return reason
+	case <-time.After(2 * time.Second):
+		t.Fatal("observer was not called")
+		return ""
+	}
+}
@@ -0,0 +1,96 @@
+// Paired proxies can deflate the TCP stream between them, which helps on slow or metered links between two sites.
+// A short hello and acknowledgement open every compressed link, so a plain backend or client is refused instead of fed deflate data.
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// compressionHello is sent by the proxy that compresses toward its backend; it names the format so future versions can differ.
+	compressionHello = "chicha-ip-proxy deflate/1\n"
+	// compressionAck is the paired proxy's answer; nothing compressed is sent before it arrives.
+	compressionAck = "chicha-ip-proxy deflate ok\n"
+	// compressionHandshakeTimeout bounds both halves of the exchange so a silent peer cannot hold a worker.
+	compressionHandshakeTimeout = 10 * time.Second
+)
+
+// compressedConn deflates writes and inflates reads while deadlines and Close still act on the socket underneath.
+type compressedConn struct {
+	net.Conn
+	reader io.ReadCloser
+	writer *flate.Writer
+}
+
+func newCompressedConn(conn net.Conn) (*compressedConn, error) {
+	// BestSpeed keeps per-connection CPU low; the gain on text-heavy streams is most of what higher levels reach.
+	writer, err := flate.NewWriter(conn, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedConn{Conn: conn, reader: flate.NewReader(conn), writer: writer}, nil
+}
+
+// Read returns inflated bytes; a peer closing its socket ends the stream between flushed blocks, which is its normal EOF.
+func (c *compressedConn) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Write flushes after every call so interactive protocols see each message at once instead of when a block fills.
+func (c *compressedConn) Write(p []byte) (int, error) {
+	if _, err := c.writer.Write(p); err != nil {
+		return 0, err
+	}
+	if err := c.writer.Flush(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// startCompressedBackend announces compression to a freshly dialed backend and waits for the paired proxy to accept it.
+func startCompressedBackend(conn net.Conn) (net.Conn, error) {
+	if err := writeFullWithDeadline(conn, []byte(compressionHello), compressionHandshakeTimeout); err != nil {
+		return nil, fmt.Errorf("sending compression hello: %v", err)
+	}
+	if err := expectCompressionLine(conn, compressionAck); err != nil {
+		return nil, fmt.Errorf("backend did not accept compression (is it chicha-ip-proxy with ;compress=client?): %v", err)
+	}
+	return newCompressedConn(conn)
+}
+
+// acceptCompressedClient checks that a client is the paired proxy before anything it sends is inflated.
+func acceptCompressedClient(conn net.Conn) (net.Conn, error) {
+	if err := expectCompressionLine(conn, compressionHello); err != nil {
+		return nil, fmt.Errorf("client did not open compression (is it chicha-ip-proxy with ;compress=backend?): %v", err)
+	}
+	if err := writeFullWithDeadline(conn, []byte(compressionAck), compressionHandshakeTimeout); err != nil {
+		return nil, fmt.Errorf("sending compression acknowledgement: %v", err)
+	}
+	return newCompressedConn(conn)
+}
+
+// expectCompressionLine reads exactly len(want) bytes, so no byte of the compressed stream behind it is consumed.
+func expectCompressionLine(conn net.Conn, want string) error {
+	if err := conn.SetReadDeadline(time.Now().Add(compressionHandshakeTimeout)); err != nil {
+		return err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		return err
+	}
+	if !bytes.Equal(got, []byte(want)) {
+		return fmt.Errorf("unexpected preface %q", got)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+func serveTCPForTest(t *testing.T, targetAddr string, options Options) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	go ServeTCPProxy(listener, targetAddr, config.AllowList{}, log.New(io.Discard, "", 0), options)
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+func TestCompressedPairRoundTripsThroughTwoProxies(t *testing.T) {
+	backendAddr := startEchoBackend(t)
+	farAddr := serveTCPForTest(t, backendAddr, Options{CompressClients: true})
+	nearAddr := serveTCPForTest(t, farAddr, Options{CompressBackend: true})
+
+	conn, err := net.Dial("tcp", nearAddr)
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	for _, message := range []string{"hello\n", strings.Repeat("compressible line\n", 2000)} {
+		if _, err := conn.Write([]byte(message)); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+		reply := make([]byte, len(message))
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			t.Fatalf("ReadFull returned error: %v", err)
+		}
+		if string(reply) != message {
+			t.Fatalf("echo of %d bytes came back different", len(message))
+		}
+	}
+}
+
+func TestCompressedConnShrinksRepetitiveStreams(t *testing.T) {
+	near, far := net.Pipe()
+	defer near.Close()
+	defer far.Close()
+
+	payload := bytes.Repeat([]byte("GET /index.html HTTP/1.1\r\n"), 1000)
+	raw := make(chan int, 1)
+	go func() {
+		n, _ := io.Copy(io.Discard, far)
+		raw <- int(n)
+	}()
+
+	compressed, err := newCompressedConn(near)
+	if err != nil {
+		t.Fatalf("newCompressedConn returned error: %v", err)
+	}
+	if n, err := compressed.Write(payload); err != nil || n != len(payload) {
+		t.Fatalf("Write = %d, %v; want %d, nil", n, err, len(payload))
+	}
+	near.Close()
+	if sent := <-raw; sent*10 > len(payload) {
+		t.Fatalf("%d bytes on the wire for %d bytes of payload, want under a tenth", sent, len(payload))
+	}
+}
+
+func TestCompressBackendRefusesPlainBackend(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// A plain backend answers with its own greeting, which is not the paired proxy's acknowledgement.
+		conn.Write([]byte("220 smtp.example.test ESMTP ready, not a proxy\r\n"))
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+	nearAddr := serveTCPForTest(t, listener.Addr().String(), Options{CompressBackend: true})
+
+	// On loopback the refusal can reset the client before its own connect returns, which is a refusal too.
+	if conn, err := net.Dial("tcp", nearAddr); err == nil {
+		defer conn.Close()
+		conn.Write([]byte("secret"))
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("client read data from a refused compressed link")
+		}
+	}
+	if got := <-received; got != compressionHello {
+		t.Fatalf("plain backend received %q, want only the compression hello", got)
+	}
+}
+
+func TestCompressClientsRefusesPlainClient(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	accepted := make(chan struct{}, 1)
+	go func() {
+		if conn, err := backend.Accept(); err == nil {
+			accepted <- struct{}{}
+			conn.Close()
+		}
+	}()
+	farAddr := serveTCPForTest(t, backend.Addr().String(), Options{CompressClients: true})
+
+	conn, err := net.Dial("tcp", farAddr)
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.test\r\n\r\n"))
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("plain client read data from a compressed route")
+	}
+	select {
+	case <-accepted:
+		t.Fatal("backend was dialed for a client that never opened compression")
+	default:
+	}
+}
@@ -0,0 +1,70 @@
+// Dial limits keep a storm of new clients from reaching a fragile backend as a burst of simultaneous connects.
+// Excess dials queue for a free slot, but the queue wait counts against the dial timeout, so a client never waits longer than an unlimited dial would allow.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errDialQueueTimeout reports a dial that spent its whole timeout waiting for a slot.
+var errDialQueueTimeout = errors.New("no dial slot became free before the dial timeout")
+
+// DialLimiter bounds in-flight TCP dials per backend address; one limiter is shared by every route so routes to the same backend queue together.
+type DialLimiter struct {
+	maxDials int
+	requests chan dialSlotRequest
+}
+
+// dialSlotRequest asks the limiter goroutine for the semaphore of one backend address.
+type dialSlotRequest struct {
+	addr  string
+	reply chan chan struct{}
+}
+
+// NewDialLimiter allows maxDials concurrent dials to each backend address.
+func NewDialLimiter(maxDials int) *DialLimiter {
+	limiter := &DialLimiter{maxDials: maxDials, requests: make(chan dialSlotRequest)}
+	go limiter.run()
+	return limiter
+}
+
+// run owns the per-backend semaphores, creating each on first use; the set only grows with the configured targets.
+func (limiter *DialLimiter) run() {
+	semaphores := make(map[string]chan struct{})
+	for request := range limiter.requests {
+		semaphore, ok := semaphores[request.addr]
+		if !ok {
+			semaphore = make(chan struct{}, limiter.maxDials)
+			semaphores[request.addr] = semaphore
+		}
+		request.reply <- semaphore
+	}
+}
+
+// acquire waits until a dial to addr may start, or fails once deadline passes; the returned func frees the slot.
+// Waiting senders on a channel are served in arrival order, so queued dials start first come, first served.
+func (limiter *DialLimiter) acquire(addr string, deadline time.Time) (func(), error) {
+	if limiter == nil {
+		return func() {}, nil
+	}
+	reply := make(chan chan struct{}, 1)
+	limiter.requests <- dialSlotRequest{addr: addr, reply: reply}
+	semaphore := <-reply
+	release := func() { <-semaphore }
+
+	select {
+	case semaphore <- struct{}{}:
+		return release, nil
+	default:
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case semaphore <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%w (%d dials to %s already in flight)", errDialQueueTimeout, limiter.maxDials, addr)
+	}
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDialLimiterQueuesDialsPerBackend(t *testing.T) {
+	limiter := NewDialLimiter(1)
+	deadline := time.Now().Add(time.Minute)
+	release, err := limiter.acquire("203.0.113.10:80", deadline)
+	if err != nil {
+		t.Fatalf("acquire returned error: %v", err)
+	}
+
+	queued := make(chan func())
+	go func() {
+		queuedRelease, err := limiter.acquire("203.0.113.10:80", deadline)
+		if err != nil {
+			t.Errorf("queued acquire returned error: %v", err)
+		}
+		queued <- queuedRelease
+	}()
+	select {
+	case <-queued:
+		t.Fatal("second dial to the same backend started while the only slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	otherRelease, err := limiter.acquire("203.0.113.20:80", deadline)
+	if err != nil {
+		t.Fatalf("acquire for another backend returned error: %v", err)
+	}
+	otherRelease()
+
+	release()
+	select {
+	case queuedRelease := <-queued:
+		queuedRelease()
+	case <-time.After(2 * time.Second):
+		t.Fatal("queued dial did not start after the slot was released")
+	}
+}
+
+func TestDialLimiterQueuedDialRespectsDeadline(t *testing.T) {
+	limiter := NewDialLimiter(1)
+	release, err := limiter.acquire("203.0.113.10:80", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("acquire returned error: %v", err)
+	}
+	defer release()
+
+	started := time.Now()
+	if _, err := limiter.acquire("203.0.113.10:80", started.Add(50*time.Millisecond)); !errors.Is(err, errDialQueueTimeout) {
+		t.Fatalf("acquire past the deadline returned %v, want %v", err, errDialQueueTimeout)
+	}
+	if waited := time.Since(started); waited > time.Second {
+		t.Fatalf("queued dial waited %s, want it to give up at its deadline", waited)
+	}
+}
+
+func TestDialTCPTargetBoundsInFlightDials(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	originalDial := tcpDial
+	tcpDial = func(dialer *net.Dialer, address string) (net.Conn, error) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := peak.Load()
+			if current <= seen || peak.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil, errors.New("fake dialer")
+	}
+	defer func() { tcpDial = originalDial }()
+
+	limiter := NewDialLimiter(2)
+	done := make(chan struct{})
+	for i := 0; i < 6; i++ {
+		go func() {
+			dialTCPTarget("203.0.113.10:80", nil, limiter, false)
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 6; i++ {
+		<-done
+	}
+	if got := peak.Load(); got != 2 {
+		t.Fatalf("peak in-flight dials = %d, want 2", got)
+	}
+}
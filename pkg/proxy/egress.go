@@ -0,0 +1,138 @@
+// Egress pools spread outbound TCP dials and UDP session sockets over several source IPs.
+// Each source IP brings its own ephemeral port range, so high-fanout routes to one backend stop running out of ports.
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// EgressPool hands out source IPs round-robin to outbound dials.
+// IPv4 and IPv6 rotate separately so a mixed pool stays even for routes of either family.
+type EgressPool struct {
+	addrs []netip.Addr
+	ipv4  egressRotation
+	ipv6  egressRotation
+}
+
+// egressRotation is one family's share of the pool; the atomic counter keeps selection lock-free on the hot path.
+type egressRotation struct {
+	addrs []netip.Addr
+	next  atomic.Uint64
+}
+
+// tcpDial opens the backend connection; tests replace it to observe the chosen source address without real sockets.
+var tcpDial = func(dialer *net.Dialer, address string) (net.Conn, error) {
+	return dialer.Dial("tcp", address)
+}
+
+// NewEgressPool parses the -egress-ip-pool values and checks that every IP is assigned to this host.
+// Failing at startup beats discovering an unbindable address on the first connection that happens to pick it.
+func NewEgressPool(values []string) (*EgressPool, error) {
+	return newEgressPool(values, "tcp")
+}
+
+// NewUDPEgressPool parses the -udp-egress-ip-pool values, checking each IP with a UDP bind as the sessions will use it.
+func NewUDPEgressPool(values []string) (*EgressPool, error) {
+	return newEgressPool(values, "udp")
+}
+
+func newEgressPool(values []string, network string) (*EgressPool, error) {
+	pool := &EgressPool{}
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid egress IP '%s': %v", part, err)
+			}
+			addr = addr.Unmap()
+			if err := checkAssignable(addr, network); err != nil {
+				return nil, err
+			}
+			pool.add(addr)
+		}
+	}
+	if len(pool.addrs) == 0 {
+		return nil, fmt.Errorf("egress IP pool is empty")
+	}
+	return pool, nil
+}
+
+// checkAssignable binds an ephemeral port on the address, which the kernel only allows for local addresses.
+func checkAssignable(addr netip.Addr, network string) error {
+	address := netip.AddrPortFrom(addr, 0).String()
+	var listener io.Closer
+	var err error
+	if network == "udp" {
+		listener, err = net.ListenPacket("udp", address)
+	} else {
+		listener, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("egress IP %s is not assignable on this host: %v", addr, err)
+	}
+	return listener.Close()
+}
+
+func (pool *EgressPool) add(addr netip.Addr) {
+	pool.addrs = append(pool.addrs, addr)
+	if addr.Is4() {
+		pool.ipv4.addrs = append(pool.ipv4.addrs, addr)
+	} else {
+		pool.ipv6.addrs = append(pool.ipv6.addrs, addr)
+	}
+}
+
+// Addrs returns the pool in selection order for startup logging.
+func (pool *EgressPool) Addrs() []netip.Addr {
+	return append([]netip.Addr(nil), pool.addrs...)
+}
+
+// pick returns the next source IP of the target's address family.
+// A target family missing from the pool falls back to the kernel's choice.
+func (pool *EgressPool) pick(target netip.Addr) (netip.Addr, bool) {
+	if pool == nil {
+		return netip.Addr{}, false
+	}
+	rotation := &pool.ipv6
+	if target.Is4() {
+		rotation = &pool.ipv4
+	}
+	if len(rotation.addrs) == 0 {
+		return netip.Addr{}, false
+	}
+	index := (rotation.next.Add(1) - 1) % uint64(len(rotation.addrs))
+	return rotation.addrs[index], true
+}
+
+// dialTCPTarget dials the backend, binding the next pool IP as the source when a pool is configured.
+// The dial timeout covers any wait for a limiter slot too, so queuing only uses time an unlimited dial would have had.
+// fastOpen sends the client's first bytes in the SYN when the kernel holds a Fast Open cookie for the backend.
+func dialTCPTarget(targetAddr string, pool *EgressPool, limiter *DialLimiter, fastOpen bool) (net.Conn, error) {
+	deadline := time.Now().Add(tcpDialTimeout)
+	release, err := limiter.acquire(targetAddr, deadline)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	dialer := &net.Dialer{Deadline: deadline}
+	if fastOpen {
+		dialer.Control = fastOpenDialControl
+	}
+	if target, err := netip.ParseAddrPort(targetAddr); err == nil {
+		if source, ok := pool.pick(target.Addr().Unmap()); ok {
+			dialer.LocalAddr = &net.TCPAddr{IP: source.AsSlice()}
+		}
+	}
+	return tcpDial(dialer, targetAddr)
+}
@@ -0,0 +1,113 @@
+// An egress policy asks the kernel which interface a backend dial would leave through and refuses the dial when that breaks the rule.
+// Split-tunnel hosts use it so a wrong route cannot loop traffic back out where clients came from or leak it past the tunnel.
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// EgressPolicy is the parsed -egress-policy; a nil *EgressPolicy allows every dial without a route lookup.
+type EgressPolicy struct {
+	// via lists the interfaces a target may route through; empty allows any interface.
+	via []string
+	// noLoop refuses targets that route out the interface the route back to the client uses.
+	noLoop bool
+}
+
+// routeInterface names the interface the kernel would send packets for ip through; tests replace it to fake a routing table.
+var routeInterface = lookupRouteInterface
+
+// ParseEgressPolicy reads rules such as "via=wg0+eth1,no-loop" and checks that this host can look routes up at all.
+func ParseEgressPolicy(spec string) (*EgressPolicy, error) {
+	policy := &EgressPolicy{}
+	for _, rule := range strings.Split(spec, ",") {
+		rule = strings.TrimSpace(rule)
+		name, value, hasValue := strings.Cut(rule, "=")
+		switch {
+		case rule == "":
+		case name == "no-loop" && !hasValue:
+			policy.noLoop = true
+		case name == "via" && hasValue:
+			for _, iface := range strings.Split(value, "+") {
+				if iface == "" {
+					return nil, fmt.Errorf("egress policy rule '%s' names an empty interface", rule)
+				}
+				if _, err := net.InterfaceByName(iface); err != nil {
+					return nil, fmt.Errorf("egress policy rule '%s': %v", rule, err)
+				}
+				policy.via = append(policy.via, iface)
+			}
+		default:
+			return nil, fmt.Errorf("unknown egress policy rule '%s' (expected via=IFACE[+IFACE] or no-loop)", rule)
+		}
+	}
+	if len(policy.via) == 0 && !policy.noLoop {
+		return nil, fmt.Errorf("egress policy '%s' has no rules", spec)
+	}
+	if _, err := routeInterface(netip.MustParseAddr("127.0.0.1")); err != nil {
+		return nil, fmt.Errorf("route lookups are unavailable: %v", err)
+	}
+	return policy, nil
+}
+
+// String spells the policy the way -egress-policy accepts it, for the startup log.
+func (policy *EgressPolicy) String() string {
+	var rules []string
+	if len(policy.via) > 0 {
+		rules = append(rules, "via="+strings.Join(policy.via, "+"))
+	}
+	if policy.noLoop {
+		rules = append(rules, "no-loop")
+	}
+	return strings.Join(rules, ",")
+}
+
+// check resolves targetAddr and returns the address to dial, or an error naming the interface that broke the policy.
+// The resolved address is dialed as is, so a name that resolves differently a moment later cannot slip past the lookup.
+// client is nil for health probes, which have no route back to check a loop against.
+func (policy *EgressPolicy) check(targetAddr string, client net.Addr) (string, error) {
+	if policy == nil {
+		return targetAddr, nil
+	}
+	host, port, err := net.SplitHostPort(targetAddr)
+	if err != nil {
+		return "", err
+	}
+	target, err := netip.ParseAddr(host)
+	if err != nil {
+		addrs, err := net.DefaultResolver.LookupNetIP(context.Background(), "ip", host)
+		if err != nil {
+			return "", fmt.Errorf("resolve: %v", err)
+		}
+		target = addrs[0]
+	}
+	target = target.Unmap()
+	egress, err := routeInterface(target)
+	if err != nil {
+		return "", fmt.Errorf("egress policy cannot look up the route to %s: %v", target, err)
+	}
+	if len(policy.via) > 0 && !containsString(policy.via, egress) {
+		return "", fmt.Errorf("egress policy refuses %s: it routes via %s, not %s", target, egress, strings.Join(policy.via, " or "))
+	}
+	if policy.noLoop && client != nil {
+		if clientIP, ok := remoteAddrIP(client); ok {
+			if ingress, err := routeInterface(clientIP.Unmap()); err == nil && ingress == egress {
+				return "", fmt.Errorf("egress policy refuses %s: it routes via %s, the interface client %s came in on", target, egress, clientIP)
+			}
+		}
+	}
+	return net.JoinHostPort(target.String(), port), nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+// fakeRoutes replaces the kernel route lookup with a table keyed by address for the rest of the test.
+func fakeRoutes(t *testing.T, table map[string]string) {
+	t.Helper()
+	original := routeInterface
+	t.Cleanup(func() { routeInterface = original })
+	routeInterface = func(ip netip.Addr) (string, error) {
+		if iface, ok := table[ip.String()]; ok {
+			return iface, nil
+		}
+		return "eth0", nil
+	}
+}
+
+func TestEgressPolicyCheck(t *testing.T) {
+	fakeRoutes(t, map[string]string{"10.8.0.5": "wg0", "198.51.100.7": "wg0"})
+	tunnelClient := &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 50000}
+	lanClient := &net.TCPAddr{IP: net.ParseIP("192.0.2.9"), Port: 50000}
+
+	tests := []struct {
+		name    string
+		policy  *EgressPolicy
+		target  string
+		client  net.Addr
+		refused string
+	}{
+		{name: "nil policy", target: "10.8.0.5:443", client: tunnelClient},
+		{name: "allowed interface", policy: &EgressPolicy{via: []string{"eth1", "wg0"}}, target: "10.8.0.5:443"},
+		{name: "wrong interface", policy: &EgressPolicy{via: []string{"wg0"}}, target: "203.0.113.1:443", refused: "routes via eth0, not wg0"},
+		{name: "loop back to the client", policy: &EgressPolicy{noLoop: true}, target: "10.8.0.5:443", client: tunnelClient, refused: "the interface client 198.51.100.7 came in on"},
+		{name: "client on another interface", policy: &EgressPolicy{noLoop: true}, target: "10.8.0.5:443", client: lanClient},
+		{name: "health probe has no client", policy: &EgressPolicy{noLoop: true}, target: "10.8.0.5:443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialAddr, err := tt.policy.check(tt.target, tt.client)
+			if tt.refused == "" {
+				if err != nil || dialAddr != tt.target {
+					t.Fatalf("check = %q, %v, want %q allowed", dialAddr, err, tt.target)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.refused) {
+				t.Fatalf("check error = %v, want one containing %q", err, tt.refused)
+			}
+		})
+	}
+}
+
+func TestEgressPolicyDialsTheCheckedAddressOfANamedTarget(t *testing.T) {
+	fakeRoutes(t, nil)
+	dialAddr, err := (&EgressPolicy{via: []string{"eth0"}}).check("localhost:8080", nil)
+	if err != nil {
+		t.Fatalf("check returned error: %v", err)
+	}
+	if host, port, _ := net.SplitHostPort(dialAddr); !netip.MustParseAddr(host).IsLoopback() || port != "8080" {
+		t.Fatalf("dial address = %q, want a resolved loopback address", dialAddr)
+	}
+}
+
+func TestParseEgressPolicy(t *testing.T) {
+	fakeRoutes(t, nil)
+	policy, err := ParseEgressPolicy("via=lo, no-loop")
+	if err != nil {
+		t.Fatalf("ParseEgressPolicy returned error: %v", err)
+	}
+	if policy.String() != "via=lo,no-loop" {
+		t.Fatalf("policy = %s, want via=lo,no-loop", policy)
+	}
+	for _, spec := range []string{"", "via=", "via=lo+", "via=no-such-interface0", "loop", "no-loop=yes"} {
+		if _, err := ParseEgressPolicy(spec); err == nil {
+			t.Fatalf("ParseEgressPolicy(%q) returned no error", spec)
+		}
+	}
+}
+
+func TestTCPProxyResetsClientWhenEgressPolicyRefusesTarget(t *testing.T) {
+	fakeRoutes(t, nil)
+	backendAddr := startEchoBackend(t)
+	lines := make(logLines, 16)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer listener.Close()
+	go ServeTCPProxy(listener, backendAddr, config.AllowList{}, log.New(lines, "", 0), Options{EgressPolicy: &EgressPolicy{via: []string{"wg0"}}})
+
+	// The reset can beat the end of the handshake on loopback, so a failed dial counts as refused too.
+	if client, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		defer client.Close()
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := client.Read(make([]byte, 1)); err == nil {
+			t.Fatal("client read data through a refused route")
+		}
+	}
+	host, _, _ := net.SplitHostPort(backendAddr)
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case line := <-lines:
+			if strings.Contains(line, "egress policy refuses "+host+": it routes via eth0, not wg0") {
+				return
+			}
+		case <-deadline:
+			t.Fatal("the refused dial was not logged")
+		}
+	}
+}
@@ -0,0 +1,82 @@
+//go:build linux
+// +build linux
+
+// Linux answers "which route would this packet take" over rtnetlink with RTM_GETROUTE, the same question `ip route get` asks.
+// The reply names the output interface, including policy routing and tunnel routes, without sending anything to the target.
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// EgressPolicySupported reports whether Options.EgressPolicy can look routes up on this platform.
+const EgressPolicySupported = true
+
+// lookupRouteInterface asks the kernel for the route to ip and returns the name of its output interface.
+func lookupRouteInterface(ip netip.Addr) (string, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return "", fmt.Errorf("netlink socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	family, bits := syscall.AF_INET, 32
+	if ip.Is6() {
+		family, bits = syscall.AF_INET6, 128
+	}
+	dst := ip.AsSlice()
+	attrLen := syscall.SizeofRtAttr + len(dst)
+	request := make([]byte, syscall.NLMSG_HDRLEN+syscall.SizeofRtMsg+attrLen)
+	binary.NativeEndian.PutUint32(request[0:4], uint32(len(request)))
+	binary.NativeEndian.PutUint16(request[4:6], syscall.RTM_GETROUTE)
+	binary.NativeEndian.PutUint16(request[6:8], syscall.NLM_F_REQUEST)
+	binary.NativeEndian.PutUint32(request[8:12], 1)
+	message := request[syscall.NLMSG_HDRLEN:]
+	message[0], message[1] = byte(family), byte(bits)
+	attr := message[syscall.SizeofRtMsg:]
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(attrLen))
+	binary.NativeEndian.PutUint16(attr[2:4], syscall.RTA_DST)
+	copy(attr[syscall.SizeofRtAttr:], dst)
+
+	if err := syscall.Sendto(fd, request, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return "", fmt.Errorf("netlink request: %v", err)
+	}
+	reply := make([]byte, 4096)
+	n, _, err := syscall.Recvfrom(fd, reply, 0)
+	if err != nil {
+		return "", fmt.Errorf("netlink reply: %v", err)
+	}
+	messages, err := syscall.ParseNetlinkMessage(reply[:n])
+	if err != nil {
+		return "", fmt.Errorf("netlink reply: %v", err)
+	}
+	for _, msg := range messages {
+		switch msg.Header.Type {
+		case syscall.NLMSG_ERROR:
+			if len(msg.Data) >= 4 {
+				if errno := -int32(binary.NativeEndian.Uint32(msg.Data[0:4])); errno != 0 {
+					return "", syscall.Errno(errno)
+				}
+			}
+		case syscall.RTM_NEWROUTE:
+			attrs, err := syscall.ParseNetlinkRouteAttr(&msg)
+			if err != nil {
+				return "", fmt.Errorf("netlink route: %v", err)
+			}
+			for _, attr := range attrs {
+				if attr.Attr.Type == syscall.RTA_OIF && len(attr.Value) >= 4 {
+					iface, err := net.InterfaceByIndex(int(binary.NativeEndian.Uint32(attr.Value)))
+					if err != nil {
+						return "", err
+					}
+					return iface.Name, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("the kernel named no output interface for %s", ip)
+}
@@ -0,0 +1,24 @@
+//go:build linux
+// +build linux
+
+package proxy
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestLookupRouteInterfaceFindsLoopback(t *testing.T) {
+	loopback, err := net.InterfaceByIndex(1)
+	if err != nil || loopback.Flags&net.FlagLoopback == 0 {
+		t.Skip("no loopback interface at index 1")
+	}
+	iface, err := lookupRouteInterface(netip.MustParseAddr("127.0.0.1"))
+	if err != nil {
+		t.Fatalf("lookupRouteInterface returned error: %v", err)
+	}
+	if iface != loopback.Name {
+		t.Fatalf("route to 127.0.0.1 leaves via %s, want %s", iface, loopback.Name)
+	}
+}
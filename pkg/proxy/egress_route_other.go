@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+// Route lookups for the egress policy are only wired up on Linux.
+// main refuses -egress-policy elsewhere, because silently allowing every dial would defeat a safety check.
+package proxy
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// EgressPolicySupported reports whether Options.EgressPolicy can look routes up on this platform.
+const EgressPolicySupported = false
+
+func lookupRouteInterface(netip.Addr) (string, error) {
+	return "", errors.New("route lookups need Linux")
+}
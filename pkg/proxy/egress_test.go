@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestDialTCPTargetRoundRobinsPoolByFamily(t *testing.T) {
+	var sources []string
+	originalDial := tcpDial
+	tcpDial = func(dialer *net.Dialer, address string) (net.Conn, error) {
+		source := "kernel"
+		if dialer.LocalAddr != nil {
+			source = dialer.LocalAddr.(*net.TCPAddr).IP.String()
+		}
+		sources = append(sources, source)
+		return nil, errors.New("fake dialer")
+	}
+	defer func() { tcpDial = originalDial }()
+
+	pool := &EgressPool{}
+	for _, addr := range []string{"198.51.100.10", "2001:db8::10", "198.51.100.11"} {
+		pool.add(netip.MustParseAddr(addr))
+	}
+	for i := 0; i < 3; i++ {
+		dialTCPTarget("203.0.113.10:80", pool, nil, false)
+	}
+	dialTCPTarget("[2001:db8::80]:80", pool, nil, false)
+	dialTCPTarget("203.0.113.10:80", nil, nil, false)
+
+	want := []string{"198.51.100.10", "198.51.100.11", "198.51.100.10", "2001:db8::10", "kernel"}
+	if len(sources) != len(want) {
+		t.Fatalf("dial sources = %v, want %v", sources, want)
+	}
+	for i := range want {
+		if sources[i] != want[i] {
+			t.Fatalf("dial sources = %v, want %v", sources, want)
+		}
+	}
+}
+
+func TestNewEgressPoolValidatesAddresses(t *testing.T) {
+	pool, err := NewEgressPool([]string{"127.0.0.1, 127.0.0.2"})
+	if err != nil {
+		t.Fatalf("NewEgressPool returned error: %v", err)
+	}
+	if addrs := pool.Addrs(); len(addrs) != 2 || addrs[1].String() != "127.0.0.2" {
+		t.Fatalf("pool addresses = %v", addrs)
+	}
+
+	for _, values := range [][]string{{"not-an-ip"}, {"192.0.2.1"}, {""}} {
+		if _, err := NewEgressPool(values); err == nil {
+			t.Fatalf("NewEgressPool(%q) accepted an unusable pool", values)
+		}
+	}
+}
+
+func TestManageUDPSessionsRoundRobinsTheUDPEgressPool(t *testing.T) {
+	pool, err := NewUDPEgressPool([]string{"127.0.0.1,127.0.0.2,127.0.0.3"})
+	if err != nil {
+		t.Fatalf("NewUDPEgressPool returned error: %v", err)
+	}
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer backend.Close()
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer responder.Close()
+	msgChan := runUDPManager(t, backend.LocalAddr().String(), responder, Options{UDPEgressPool: pool})
+
+	// Each datagram is read before the next is sent, so the backend sees the sessions in the order they started.
+	sourceOf := func(port int, payload string) string {
+		t.Helper()
+		msgChan <- udpMessage{data: []byte(payload), addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}}
+		buffer := make([]byte, 64)
+		_ = backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, source, err := backend.ReadFrom(buffer)
+		if err != nil || string(buffer[:n]) != payload {
+			t.Fatalf("backend read %q, %v; want %q", buffer[:n], err, payload)
+		}
+		return source.(*net.UDPAddr).IP.String()
+	}
+	counts := make(map[string]int)
+	firstSources := make(map[int]string)
+	for port := 40030; port < 40036; port++ {
+		source := sourceOf(port, "hello")
+		counts[source]++
+		firstSources[port] = source
+	}
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		if counts[ip] != 2 {
+			t.Fatalf("six sessions egressed as %v, want two per pool IP", counts)
+		}
+	}
+	for port, first := range firstSources {
+		if source := sourceOf(port, "again"); source != first {
+			t.Fatalf("client on port %d moved from egress IP %s to %s mid-session", port, first, source)
+		}
+	}
+}
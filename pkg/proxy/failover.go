@@ -0,0 +1,242 @@
+// Failover keeps a route on its primary target, or its balanced targets in turn, and moves new flows to a backup only while they are down.
+// A health checker per route probes every target on a fixed interval; connections and sessions already running are never moved.
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// DefaultHealthCheckInterval is how often failover targets are probed unless Options.HealthCheckInterval says otherwise.
+const DefaultHealthCheckInterval = 5 * time.Second
+
+const healthCheckTimeout = 2 * time.Second
+
+// Pool empty policies decide what new connections do while every target of a health-checked route is down.
+const (
+	// PoolEmptyRetryAny keeps dialing the target that was healthy most recently, in case the health check itself is wrong.
+	PoolEmptyRetryAny = "retry-any"
+	// PoolEmptyReject resets new connections at once, so clients fail fast and can try elsewhere.
+	PoolEmptyReject = "reject"
+)
+
+// healthCheckDial probes one target; tests replace it to flip targets up and down without real backends.
+var healthCheckDial = func(address string) error {
+	conn, err := net.DialTimeout("tcp", address, healthCheckTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// failover owns the health of a route's targets in one goroutine, the same way the registry owns live flows.
+type failover struct {
+	requests chan chan string // requests are answered with the target to dial, or "" when the pool is down and rejects.
+	stop     chan struct{}
+}
+
+type healthResult struct {
+	index int
+	err   error
+}
+
+// startFailover begins probing targets, listed primary first, and serves target() until stop is closed.
+// The first balanced targets take new flows in turn while healthy and the rest are backups; balanced is one for a route without |.
+// flows names what the route forwards, "TCP connections" or "UDP sessions", in the lines it logs.
+// probe checks one target, and nil means no probing, so every target stays healthy; a route with a synthetic check but no backups runs with one target.
+// Every target starts out healthy so the first clients are not refused before the first probe finishes; firstRound runs once it has.
+// Only up and down transitions are logged unless logProbes asks for every probe result; state learns of them and of target switches.
+// rejectWhenDown applies PoolEmptyReject; otherwise a fully down pool follows PoolEmptyRetryAny.
+func startFailover(targets []string, balanced int, flows string, interval time.Duration, probe func(string) error, logProbes, rejectWhenDown bool, logger *log.Logger, firstRound func(), state *routeState) *failover {
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	checker := &failover{requests: make(chan chan string), stop: make(chan struct{})}
+	go checker.run(targets, balanced, flows, interval, probe, logProbes, rejectWhenDown, logger, firstRound, state)
+	return checker
+}
+
+// startRouteFailover starts the health checker of a route served with options, or returns nil when the route has one target and no synthetic check.
+func startRouteFailover(targetAddr, flows string, probe func(string) error, logger *log.Logger, options Options) *failover {
+	if len(options.Balance) == 0 && len(options.Backups) == 0 && options.SyntheticCheck == nil {
+		return nil
+	}
+	return startFailover(routeTargets(targetAddr, options), len(options.Balance)+1, flows, options.HealthCheckInterval, probe, options.HealthLogProbes, options.PoolEmptyPolicy == PoolEmptyReject, logger, options.Readiness.healthChecked, options.state)
+}
+
+// routeTargets lists every target of a route in the order failover considers them: the route target, its Balance targets, then its Backups.
+func routeTargets(targetAddr string, options Options) []string {
+	return append(append([]string{targetAddr}, options.Balance...), options.Backups...)
+}
+
+func (checker *failover) run(targets []string, balanced int, flows string, interval time.Duration, dial func(string) error, logProbes, rejectWhenDown bool, logger *log.Logger, firstRound func(), state *routeState) {
+	healthy := make([]bool, len(targets))
+	lastUp := make([]time.Time, len(targets))
+	started := time.Now()
+	for i := range healthy {
+		healthy[i] = true
+		lastUp[i] = started
+	}
+	current := 0
+	// turn is the balanced target whose turn comes next; targets found down are skipped until a probe passes again.
+	turn := 0
+	poolDown := false
+	label := func(index int) string {
+		switch {
+		case len(targets) == 1:
+			return "Target"
+		case index < balanced && balanced > 1:
+			return "Balanced target"
+		}
+		return "Failover target"
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	results := make(chan healthResult, len(targets))
+	probing := 0
+	probe := func() {
+		// A slow probe round is not stacked on top of itself; the next tick simply tries again.
+		if probing > 0 || dial == nil {
+			return
+		}
+		probing = len(targets)
+		for i, target := range targets {
+			go func(index int, address string) {
+				results <- healthResult{index: index, err: dial(address)}
+			}(i, target)
+		}
+	}
+	probe()
+
+	for {
+		select {
+		case <-checker.stop:
+			return
+
+		case reply := <-checker.requests:
+			if poolDown && rejectWhenDown {
+				reply <- ""
+			} else if index, ok := nextTurn(healthy, balanced, turn); ok {
+				turn = index + 1
+				reply <- targets[index]
+			} else {
+				reply <- targets[current]
+			}
+
+		case <-ticker.C:
+			probe()
+
+		case result := <-results:
+			probing--
+			up := result.err == nil
+			if up {
+				lastUp[result.index] = time.Now()
+			}
+			if logProbes {
+				if up {
+					logger.Printf("Health check of %s passed", targets[result.index])
+				} else {
+					logger.Printf("Health check of %s failed: %v", targets[result.index], result.err)
+				}
+			}
+			if healthy[result.index] != up {
+				healthy[result.index] = up
+				if up {
+					logger.Printf("%s %s is up", label(result.index), targets[result.index])
+				} else {
+					logger.Printf("%s %s is down: %v", label(result.index), targets[result.index], result.err)
+					state.failed(fmt.Errorf("health check of %s failed: %v", targets[result.index], result.err))
+				}
+			}
+			if down := !anyHealthy(healthy); down != poolDown {
+				poolDown = down
+				switch {
+				case down && rejectWhenDown:
+					logger.Printf("WARNING: every target of %s is down; rejecting new %s until one is back up", targets[0], flows)
+				case down:
+					logger.Printf("WARNING: every target of %s is down; new %s still try %s, the last one healthy", targets[0], flows, targets[preferredTarget(healthy, lastUp)])
+				default:
+					logger.Printf("Targets of %s are reachable again", targets[0])
+				}
+			}
+			if next := preferredTarget(healthy, lastUp); next != current {
+				// Moving between balanced targets only changes whose turns are skipped, which the up and down lines already say.
+				if next >= balanced || current >= balanced {
+					logger.Printf("New %s for %s now go to %s", flows, targets[0], targets[next])
+				}
+				current = next
+				state.using(targets[current])
+			}
+			state.checked(healthy[current])
+			if probing == 0 && firstRound != nil {
+				firstRound()
+				firstRound = nil
+			}
+		}
+	}
+}
+
+// preferredTarget picks the first healthy target in priority order.
+// With every target down it picks the one that passed a check most recently, since its failure is the freshest and likeliest to be brief;
+// targets that never passed fall back to priority order, so a pool down from the start keeps trying the primary.
+func preferredTarget(healthy []bool, lastUp []time.Time) int {
+	best := 0
+	for i, up := range healthy {
+		if up {
+			return i
+		}
+		if lastUp[i].After(lastUp[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// nextTurn picks the first healthy balanced target from turn on, wrapping around, and false when none is healthy
+// or the route is not balanced, in which case the preferred target serves.
+func nextTurn(healthy []bool, balanced, turn int) (int, bool) {
+	if balanced <= 1 {
+		return 0, false
+	}
+	for i := 0; i < balanced; i++ {
+		if index := (turn + i) % balanced; healthy[index] {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+func anyHealthy(healthy []bool) bool {
+	for _, up := range healthy {
+		if up {
+			return true
+		}
+	}
+	return false
+}
+
+// target returns where a new connection should be dialed, and false when every target is down under PoolEmptyReject.
+// A nil failover means the route has one target and no health checks, so the primary always serves.
+func (checker *failover) target(primary string) (string, bool) {
+	if checker == nil {
+		return primary, true
+	}
+	reply := make(chan string, 1)
+	select {
+	case checker.requests <- reply:
+		target := <-reply
+		return target, target != ""
+	case <-checker.stop:
+		return primary, true
+	}
+}
+
+// close stops probing once the route's listener is gone.
+func (checker *failover) close() {
+	if checker != nil {
+		close(checker.stop)
+	}
+}
@@ -0,0 +1,336 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+func TestFailoverPrefersPrimaryAgainAfterRecovery(t *testing.T) {
+	const primary, backup, standby = "192.0.2.1:80", "192.0.2.2:80", "192.0.2.3:80"
+	var primaryDown atomic.Bool
+	originalDial := healthCheckDial
+	healthCheckDial = func(address string) error {
+		if address == primary && primaryDown.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	checker := startFailover([]string{primary, backup, standby}, 1, "TCP connections", 10*time.Millisecond, healthCheckDial, false, false, log.New(io.Discard, "", 0), nil, nil)
+	defer checker.close()
+	// The checker captured the stub when it started, so the global can be restored right away.
+	healthCheckDial = originalDial
+
+	waitForTarget(t, checker, primary)
+	primaryDown.Store(true)
+	waitForTarget(t, checker, backup)
+	primaryDown.Store(false)
+	waitForTarget(t, checker, primary)
+}
+
+func TestBalancedFailoverSkipsDownTargetsThenUsesBackups(t *testing.T) {
+	const first, second, third, backup = "192.0.2.1:80", "192.0.2.2:80", "192.0.2.3:80", "192.0.2.9:80"
+	var down sync.Map
+	originalDial := healthCheckDial
+	healthCheckDial = func(address string) error {
+		if _, ok := down.Load(address); ok {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	lines := make(logLines, 64)
+	checker := startFailover([]string{first, second, third, backup}, 3, "UDP sessions", 5*time.Millisecond, healthCheckDial, false, false, log.New(lines, "", 0), nil, nil)
+	defer checker.close()
+	healthCheckDial = originalDial
+
+	turns := func() string {
+		var picked []string
+		for i := 0; i < 4; i++ {
+			target, _ := checker.target(first)
+			picked = append(picked, target)
+		}
+		return strings.Join(picked, " ")
+	}
+	if got := turns(); got != first+" "+second+" "+third+" "+first {
+		t.Fatalf("turns = %s, want the balanced targets in order", got)
+	}
+
+	down.Store(second, true)
+	waitForLogLine(t, lines, "Balanced target 192.0.2.2:80 is down: connection refused\n")
+	if got := turns(); strings.Contains(got, second) || strings.Contains(got, backup) {
+		t.Fatalf("turns = %s, want the healthy balanced targets only", got)
+	}
+
+	down.Store(first, true)
+	down.Store(third, true)
+	waitForLogLine(t, lines, "New UDP sessions for 192.0.2.1:80 now go to 192.0.2.9:80\n")
+	if got := turns(); got != strings.Repeat(backup+" ", 3)+backup {
+		t.Fatalf("turns = %s, want the backup while every balanced target is down", got)
+	}
+}
+
+func TestPreferredTargetFallsBackToLastHealthyWhenAllAreDown(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	if got := preferredTarget([]bool{false, false, true}, []time.Time{start, start, start}); got != 2 {
+		t.Fatalf("preferredTarget = %d, want the first healthy target 2", got)
+	}
+	if got := preferredTarget([]bool{false, false}, []time.Time{start, start}); got != 0 {
+		t.Fatalf("preferredTarget = %d, want the primary when every target went down together", got)
+	}
+	if got := preferredTarget([]bool{false, false, false}, []time.Time{start, start.Add(time.Minute), start.Add(time.Second)}); got != 1 {
+		t.Fatalf("preferredTarget = %d, want 1, the target healthy most recently", got)
+	}
+}
+
+func TestFailoverPoolEmptyPolicies(t *testing.T) {
+	const primary, backup = "192.0.2.1:80", "192.0.2.2:80"
+	for _, test := range []struct {
+		reject     bool
+		wantTarget string
+		wantOK     bool
+		wantLog    string
+	}{
+		{reject: false, wantTarget: backup, wantOK: true, wantLog: "WARNING: every target of 192.0.2.1:80 is down; new TCP connections still try 192.0.2.2:80, the last one healthy\n"},
+		{reject: true, wantTarget: "", wantOK: false, wantLog: "WARNING: every target of 192.0.2.1:80 is down; rejecting new TCP connections until one is back up\n"},
+	} {
+		var primaryDown, backupDown atomic.Bool
+		primaryDown.Store(true)
+		originalDial := healthCheckDial
+		healthCheckDial = func(address string) error {
+			if address == primary && primaryDown.Load() || address == backup && backupDown.Load() {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+		lines := make(logLines, 64)
+		checker := startFailover([]string{primary, backup}, 1, "TCP connections", 5*time.Millisecond, healthCheckDial, false, test.reject, log.New(lines, "", 0), nil, nil)
+		healthCheckDial = originalDial
+
+		waitForTarget(t, checker, backup)
+		backupDown.Store(true)
+		waitForLogLine(t, lines, test.wantLog)
+		if target, ok := checker.target(primary); target != test.wantTarget || ok != test.wantOK {
+			t.Fatalf("reject=%v: target = %q, %v; want %q, %v", test.reject, target, ok, test.wantTarget, test.wantOK)
+		}
+
+		backupDown.Store(false)
+		waitForLogLine(t, lines, "Targets of 192.0.2.1:80 are reachable again\n")
+		waitForTarget(t, checker, backup)
+		checker.close()
+	}
+}
+
+func TestRejectPolicyResetsClientsWhilePoolIsDown(t *testing.T) {
+	originalDial := healthCheckDial
+	healthCheckDial = func(string) error { return errors.New("connection refused") }
+	lines := make(logLines, 64)
+	checker := startFailover([]string{"192.0.2.1:80"}, 1, "TCP connections", 5*time.Millisecond, healthCheckDial, false, true, log.New(lines, "", 0), nil, nil)
+	defer checker.close()
+	healthCheckDial = originalDial
+	waitForLogLine(t, lines, "WARNING: every target of 192.0.2.1:80 is down; rejecting new TCP connections until one is back up\n")
+
+	// A pipe stands in for the client, because a reset this early can fail the client's own connect on loopback.
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	var reason CloseReason
+	release := make(chan struct{}, 1)
+	release <- struct{}{}
+	handleTCPConnection(tcpConnJob{conn: serverConn, release: release}, "127.0.0.1:8080", "192.0.2.1:80", log.New(io.Discard, "", 0), Options{
+		failover: checker,
+		Observer: func(_ ConnectionInfo, closed CloseReason) { reason = closed },
+	})
+	if reason != CloseHealthEjection {
+		t.Fatalf("close reason = %s, want %s", reason, CloseHealthEjection)
+	}
+	if _, err := clientConn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("rejected client read returned %v, want the connection closed", err)
+	}
+}
+
+func waitForTarget(t *testing.T, checker *failover, want string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got, _ := checker.target("unused")
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("failover target = %s, want %s", got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitForLogLine reads log lines until want arrives, failing after two seconds.
+func waitForLogLine(t *testing.T, lines logLines, want string) {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case line := <-lines:
+			if line == want {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("log line %q was not written", want)
+		}
+	}
+}
+
+// logLines hands each log line to the test over a channel, so reading it cannot race the checker's writes.
+type logLines chan string
+
+func (lines logLines) Write(payload []byte) (int, error) {
+	lines <- string(payload)
+	return len(payload), nil
+}
+
+func TestFailoverLogsNothingWhileTargetsStayHealthy(t *testing.T) {
+	var probes atomic.Int32
+	originalDial := healthCheckDial
+	healthCheckDial = func(string) error {
+		probes.Add(1)
+		return nil
+	}
+
+	lines := make(logLines, 64)
+	checker := startFailover([]string{"192.0.2.1:80", "192.0.2.2:80"}, 1, "TCP connections", 5*time.Millisecond, healthCheckDial, false, false, log.New(lines, "", 0), nil, nil)
+	healthCheckDial = originalDial
+	for deadline := time.Now().Add(2 * time.Second); probes.Load() < 10; {
+		if time.Now().After(deadline) {
+			t.Fatal("health checker did not keep probing")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	checker.close()
+
+	select {
+	case line := <-lines:
+		t.Fatalf("steady healthy probes logged %q, want nothing", line)
+	default:
+	}
+}
+
+func TestFailoverLogsEveryProbeWhenAsked(t *testing.T) {
+	originalDial := healthCheckDial
+	healthCheckDial = func(string) error { return nil }
+
+	lines := make(logLines, 64)
+	checker := startFailover([]string{"192.0.2.1:80"}, 1, "TCP connections", time.Hour, healthCheckDial, true, false, log.New(lines, "", 0), nil, nil)
+	defer checker.close()
+	healthCheckDial = originalDial
+
+	select {
+	case line := <-lines:
+		if line != "Health check of 192.0.2.1:80 passed\n" {
+			t.Fatalf("probe log line = %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("healthy probe was not logged with probe logging on")
+	}
+}
+
+func TestFailoverRedialReplaysPeekedPrefaceToNextTarget(t *testing.T) {
+	originalDial := healthCheckDial
+	healthCheckDial = func(string) error { return nil }
+	defer func() { healthCheckDial = originalDial }()
+
+	// The primary passed its last probe but is gone by the time the client arrives.
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	primary := dead.Addr().String()
+	dead.Close()
+	backup := startEchoBackend(t)
+
+	lines := make(logLines, 16)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer listener.Close()
+	go ServeTCPProxy(listener, primary, config.AllowList{}, log.New(lines, "", 0), Options{
+		LogSNI: true, Backups: []string{backup}, FailoverRedial: true, HealthCheckInterval: time.Hour,
+	})
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer client.Close()
+	// A whole TLS handshake record is peeked for -log-sni before any target is dialed.
+	record := []byte{0x16, 0x03, 0x01, 0x00, 0x05, 'h', 'e', 'l', 'l', 'o'}
+	if _, err := client.Write(append(record, " live"...)); err != nil {
+		t.Fatalf("client write returned error: %v", err)
+	}
+	want := string(record) + " live"
+	reply := make([]byte, len(want))
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("reading the echo returned error: %v", err)
+	}
+	if string(reply) != want {
+		t.Fatalf("backup echoed %q, want the peeked record followed by the live bytes", reply)
+	}
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, "Redialing "+backup+" for ") && strings.HasSuffix(line, "replaying 10 buffered bytes\n") {
+				return
+			}
+		case <-deadline:
+			t.Fatal("the redial to the backup was not logged")
+		}
+	}
+}
+
+func TestBalancedTCPRouteTakesTargetsInTurn(t *testing.T) {
+	// Each backend answers with its own name, so the client can tell which one the proxy chose.
+	var backends []string
+	for _, name := range []string{"a", "b", "c"} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("net.Listen returned error: %v", err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		go func(name string) {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.Write([]byte(name))
+				conn.Close()
+			}
+		}(name)
+		backends = append(backends, listener.Addr().String())
+	}
+	proxyAddr := serveTCPForTest(t, backends[0], Options{Balance: backends[1:]})
+
+	var order string
+	for i := 0; i < 6; i++ {
+		conn, err := net.Dial("tcp", proxyAddr)
+		if err != nil {
+			t.Fatalf("net.Dial returned error: %v", err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+		reply, _ := io.ReadAll(conn)
+		conn.Close()
+		order += string(reply)
+	}
+	if order != "abcabc" {
+		t.Fatalf("backends answered in order %q, want abcabc", order)
+	}
+}
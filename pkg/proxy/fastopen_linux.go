@@ -0,0 +1,82 @@
+//go:build linux
+// +build linux
+
+// TCP Fast Open carries the first data in the SYN, saving a round trip for short connections to clients and backends that saw each other before.
+// The kernel only honors it when net.ipv4.tcp_fastopen enables the side in question, so main probes that at startup and logs the result.
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// TCPFastOpenSupported reports whether Options.TCPFastOpen takes effect on this platform.
+const TCPFastOpenSupported = true
+
+const (
+	// tcpFastOpenOption and tcpFastOpenConnectOption are TCP_FASTOPEN and TCP_FASTOPEN_CONNECT, which the syscall package does not name.
+	tcpFastOpenOption        = 0x17
+	tcpFastOpenConnectOption = 0x1e
+	// tcpFastOpenQueue bounds the Fast Open requests a listener holds before the handshake completes, like a listen backlog.
+	tcpFastOpenQueue = 256
+)
+
+// tcpFastOpenSysctl holds the kernel switch: bit 1 enables the client side and bit 2 the server side.
+const tcpFastOpenSysctl = "/proc/sys/net/ipv4/tcp_fastopen"
+
+// ListenTCP binds a TCP listener, enabling Fast Open on it when fastOpen is set.
+func ListenTCP(listenAddr string, fastOpen bool) (net.Listener, error) {
+	if !fastOpen {
+		return net.Listen("tcp", listenAddr)
+	}
+	config := net.ListenConfig{Control: func(_, _ string, raw syscall.RawConn) error {
+		return setSocketOption(raw, tcpFastOpenOption, tcpFastOpenQueue)
+	}}
+	return config.Listen(context.Background(), "tcp", listenAddr)
+}
+
+// fastOpenDialControl asks the kernel to send the first write in the SYN of a backend dial.
+func fastOpenDialControl(_, _ string, raw syscall.RawConn) error {
+	return setSocketOption(raw, tcpFastOpenConnectOption, 1)
+}
+
+func setSocketOption(raw syscall.RawConn, option, value int) error {
+	var optErr error
+	if err := raw.Control(func(fd uintptr) {
+		optErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, option, value)
+	}); err != nil {
+		return err
+	}
+	return optErr
+}
+
+// ProbeTCPFastOpen checks that the kernel accepts both socket options and reports which sides net.ipv4.tcp_fastopen enables.
+// An error means the options themselves are refused, so enabling Fast Open would break listening or dialing.
+func ProbeTCPFastOpen() (server, client bool, err error) {
+	listener, err := ListenTCP("127.0.0.1:0", true)
+	if err != nil {
+		return false, false, fmt.Errorf("the kernel refused TCP_FASTOPEN on a listener: %v", err)
+	}
+	defer listener.Close()
+	dialer := net.Dialer{Control: fastOpenDialControl}
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return false, false, fmt.Errorf("the kernel refused TCP_FASTOPEN_CONNECT on a dial: %v", err)
+	}
+	conn.Close()
+
+	content, err := os.ReadFile(tcpFastOpenSysctl)
+	if err != nil {
+		return false, false, fmt.Errorf("failed to read net.ipv4.tcp_fastopen: %v", err)
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(string(content)), 0, 64)
+	if err != nil {
+		return false, false, fmt.Errorf("unexpected net.ipv4.tcp_fastopen value %q", strings.TrimSpace(string(content)))
+	}
+	return value&2 != 0, value&1 != 0, nil
+}
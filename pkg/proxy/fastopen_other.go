@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+// TCP Fast Open is only wired up on Linux; elsewhere Options.TCPFastOpen does nothing.
+// main warns about that at startup, so listeners and dials here are plain ones.
+package proxy
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// TCPFastOpenSupported reports whether Options.TCPFastOpen takes effect on this platform.
+const TCPFastOpenSupported = false
+
+// ListenTCP binds a TCP listener; fastOpen is ignored on this platform.
+func ListenTCP(listenAddr string, _ bool) (net.Listener, error) {
+	return net.Listen("tcp", listenAddr)
+}
+
+func fastOpenDialControl(string, string, syscall.RawConn) error {
+	return nil
+}
+
+// ProbeTCPFastOpen always fails here, since the socket options are Linux ones.
+func ProbeTCPFastOpen() (server, client bool, err error) {
+	return false, false, errors.New("TCP Fast Open is only supported on Linux")
+}
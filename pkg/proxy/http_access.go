@@ -0,0 +1,233 @@
+// HTTP access logging reads copies of both directions of a route marked as HTTP and writes Combined Log Format lines.
+// The parsers only ever see tapped copies, so traffic they cannot understand ends logging for that connection, never forwarding.
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxPendingAccessRequests bounds pipelined requests still waiting for their response.
+	maxPendingAccessRequests = 64
+	// maxTapBuffered bounds the copied bytes a parser may fall behind by; past it the connection is no longer logged.
+	maxTapBuffered = 256 * 1024
+	// maxTapChunks bounds the reads queued for a parser, so many tiny reads cannot queue more slices than bytes.
+	maxTapChunks = 256
+)
+
+// accessRequest is a parsed request waiting for the response that completes its log line.
+type accessRequest struct {
+	request  *http.Request
+	received time.Time
+}
+
+// httpAccessTap feeds the request and response parsers of one connection.
+// Writing to its streams never waits: a parser stuck on one direction, such as a 408 for a request still arriving,
+// cannot hold back the bytes being forwarded.
+type httpAccessTap struct {
+	client *tapStream
+	server *tapStream
+}
+
+// startHTTPAccessTap starts the two parsers for a connection from clientAddr.
+func startHTTPAccessTap(clientAddr string, accessLog *log.Logger) *httpAccessTap {
+	clientHost := remoteHost(clientAddr)
+	client, server := newTapStream(), newTapStream()
+	requests := make(chan accessRequest, maxPendingAccessRequests)
+	go parseHTTPRequests(client, requests)
+	go parseHTTPResponses(server, requests, clientHost, accessLog)
+	return &httpAccessTap{client: client, server: server}
+}
+
+// close ends both streams so the parsers finish once the connection is done.
+func (tap *httpAccessTap) close() {
+	tap.client.Close()
+	tap.server.Close()
+}
+
+// tapStream queues copies of one direction for its parser, up to maxTapBuffered bytes.
+// A parser that falls further behind gets the end of its stream instead of making the copy loop wait, so only logging stops.
+// Write and Close come from one goroutine at a time: the handler before the copy starts, one copy loop, then the handler again.
+type tapStream struct {
+	chunks   chan []byte
+	buffered atomic.Int64
+	closed   bool
+	pending  []byte // pending is the rest of the chunk the parser is reading.
+}
+
+func newTapStream() *tapStream {
+	return &tapStream{chunks: make(chan []byte, maxTapChunks)}
+}
+
+func (stream *tapStream) Write(data []byte) (int, error) {
+	if stream.closed {
+		return len(data), nil
+	}
+	if stream.buffered.Load()+int64(len(data)) > maxTapBuffered {
+		stream.Close()
+		return len(data), nil
+	}
+	select {
+	case stream.chunks <- append([]byte(nil), data...):
+		stream.buffered.Add(int64(len(data)))
+	default:
+		stream.Close()
+	}
+	return len(data), nil
+}
+
+// Close ends the stream once the parser has read what is queued.
+func (stream *tapStream) Close() error {
+	if !stream.closed {
+		stream.closed = true
+		close(stream.chunks)
+	}
+	return nil
+}
+
+func (stream *tapStream) Read(buffer []byte) (int, error) {
+	if len(stream.pending) == 0 {
+		chunk, ok := <-stream.chunks
+		if !ok {
+			return 0, io.EOF
+		}
+		stream.pending = chunk
+	}
+	n := copy(buffer, stream.pending)
+	stream.pending = stream.pending[n:]
+	stream.buffered.Add(-int64(n))
+	return n, nil
+}
+
+// tappedConn copies every byte read from the connection into a parser stream.
+type tappedConn struct {
+	net.Conn
+	tap io.Writer
+}
+
+func (conn tappedConn) Read(buffer []byte) (int, error) {
+	n, err := conn.Conn.Read(buffer)
+	if n > 0 {
+		_, _ = conn.tap.Write(buffer[:n])
+	}
+	return n, err
+}
+
+// parseHTTPRequests hands each request on the client stream to the response parser.
+// Request bodies are read through so the next request starts at the right byte.
+func parseHTTPRequests(stream io.Reader, requests chan<- accessRequest) {
+	// The deferred drain runs after requests is closed, so the response parser never waits on a stream that went opaque.
+	defer io.Copy(io.Discard, stream)
+	defer close(requests)
+
+	reader := bufio.NewReader(stream)
+	for {
+		request, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		select {
+		case requests <- accessRequest{request: request, received: time.Now()}:
+		default:
+			return
+		}
+		if _, err := io.Copy(io.Discard, request.Body); err != nil {
+			return
+		}
+		if request.Method == http.MethodConnect || request.Header.Get("Upgrade") != "" {
+			return
+		}
+	}
+}
+
+// parseHTTPResponses pairs each response with the oldest unanswered request and logs the pair.
+// Requests still unanswered when the connection closes are not logged because they have no status.
+func parseHTTPResponses(stream io.Reader, requests <-chan accessRequest, clientHost string, accessLog *log.Logger) {
+	defer io.Copy(io.Discard, stream)
+
+	reader := bufio.NewReader(stream)
+	for pending := range requests {
+		response, err := readFinalResponse(reader, pending.request)
+		if err != nil {
+			return
+		}
+		size, err := io.Copy(io.Discard, response.Body)
+		accessLog.Print(combinedLogLine(clientHost, pending, response.StatusCode, size))
+		if err != nil {
+			return
+		}
+		// After a protocol switch or an open tunnel the stream is no longer HTTP.
+		if response.StatusCode == http.StatusSwitchingProtocols || (pending.request.Method == http.MethodConnect && response.StatusCode/100 == 2) {
+			return
+		}
+	}
+}
+
+// readFinalResponse skips informational responses such as 100 Continue, which share their request with the final one.
+func readFinalResponse(reader *bufio.Reader, request *http.Request) (*http.Response, error) {
+	for {
+		response, err := http.ReadResponse(reader, request)
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode/100 != 1 || response.StatusCode == http.StatusSwitchingProtocols {
+			return response, nil
+		}
+	}
+}
+
+// combinedLogLine renders one Apache Combined Log Format line.
+// The size is the decoded body length, so chunked responses count their payload rather than the chunk framing.
+func combinedLogLine(clientHost string, pending accessRequest, status int, size int64) string {
+	request := pending.request
+	sizeField := "-"
+	if size > 0 {
+		sizeField = strconv.FormatInt(size, 10)
+	}
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s "%s" "%s"`,
+		clientHost,
+		pending.received.Format("02/Jan/2006:15:04:05 -0700"),
+		escapeLogField(request.Method),
+		escapeLogField(request.RequestURI),
+		escapeLogField(request.Proto),
+		status,
+		sizeField,
+		quotedLogField(request.Referer()),
+		quotedLogField(request.UserAgent()),
+	)
+}
+
+// quotedLogField fills empty header fields with "-" as Apache does.
+func quotedLogField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return escapeLogField(value)
+}
+
+// escapeLogField escapes quotes, backslashes, and control bytes so a client cannot forge extra fields or lines.
+func escapeLogField(value string) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		b := value[i]
+		switch {
+		case b == '"' || b == '\\':
+			builder.WriteByte('\\')
+			builder.WriteByte(b)
+		case b < 0x20 || b == 0x7f:
+			fmt.Fprintf(&builder, "\\x%02x", b)
+		default:
+			builder.WriteByte(b)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleTCPConnectionWritesCombinedAccessLog(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	go http.Serve(backend, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", "5")
+		io.WriteString(w, "hello")
+	}))
+
+	lines := make(accessLines, 4)
+	clientConn, _ := startHandledConnection(t, backend.Addr().String(), Options{AccessLog: log.New(lines, "", 0)})
+	defer clientConn.Close()
+
+	reader := bufio.NewReader(clientConn)
+	fmt.Fprint(clientConn, "GET /index.html?q=1 HTTP/1.1\r\nHost: example.test\r\nUser-Agent: curl/8.0\r\n\r\n")
+	readTestResponse(t, reader, "GET")
+	fmt.Fprint(clientConn, "HEAD /missing HTTP/1.1\r\nHost: example.test\r\nReferer: http://example.test/\r\nUser-Agent: say \"hi\"\r\n\r\n")
+	readTestResponse(t, reader, "HEAD")
+
+	first := lines.wait(t)
+	if !regexp.MustCompile(`^127\.0\.0\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /index.html\?q=1 HTTP/1.1" 200 5 "-" "curl/8.0"$`).MatchString(first) {
+		t.Fatalf("first access line = %q", first)
+	}
+	second := lines.wait(t)
+	if !strings.HasSuffix(second, `"HEAD /missing HTTP/1.1" 404 - "http://example.test/" "say \"hi\""`) {
+		t.Fatalf("second access line = %q", second)
+	}
+}
+
+func TestHandleTCPConnectionForwardsNonHTTPOnHTTPRoute(t *testing.T) {
+	backendAddr := startEchoBackend(t)
+	lines := make(accessLines, 1)
+	clientConn, _ := startHandledConnection(t, backendAddr, Options{AccessLog: log.New(lines, "", 0)})
+	defer clientConn.Close()
+
+	payload := strings.Repeat("not http at all\n", 8*1024)
+	go io.WriteString(clientConn, payload)
+	echoed := make([]byte, len(payload))
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(clientConn, echoed); err != nil {
+		t.Fatalf("io.ReadFull returned error: %v", err)
+	}
+	if string(echoed) != payload {
+		t.Fatal("echoed payload differs from the one sent")
+	}
+	if len(lines) != 0 {
+		t.Fatalf("non-HTTP traffic produced access line %q", <-lines)
+	}
+}
+
+func TestAccessLogNeverHoldsBackAResponseWithoutARequest(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// The request head never completes, so the response parser has no request to pair the 408 with.
+		conn.Read(make([]byte, 64))
+		io.WriteString(conn, "HTTP/1.1 408 Request Timeout\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")
+	}()
+
+	clientConn, finished := startHandledConnection(t, backend.Addr().String(), Options{AccessLog: log.New(make(accessLines, 1), "", 0)})
+	defer clientConn.Close()
+	io.WriteString(clientConn, "GET / HTTP/1.1\r\nHost: x\r\n")
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	status, err := bufio.NewReader(clientConn).ReadString('\n')
+	if err != nil || !strings.HasPrefix(status, "HTTP/1.1 408") {
+		t.Fatalf("client read %q, %v; want the backend's 408", status, err)
+	}
+	clientConn.Close()
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the connection handler did not return after both sides closed")
+	}
+}
+
+func TestEscapeLogFieldBlocksForgedFields(t *testing.T) {
+	if got := escapeLogField("a\"b\\c\nd"); got != `a\"b\\c\x0ad` {
+		t.Fatalf("escapeLogField = %q", got)
+	}
+}
+
+// accessLines collects access log lines so tests can wait for them without sleeping.
+type accessLines chan string
+
+func (lines accessLines) Write(p []byte) (int, error) {
+	lines <- strings.TrimSuffix(string(p), "\n")
+	return len(p), nil
+}
+
+func (lines accessLines) wait(t *testing.T) string {
+	t.Helper()
+
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(2 * time.Second):
+		t.Fatal("access log line was not written")
+		return ""
+	}
+}
+
+func readTestResponse(t *testing.T, reader *bufio.Reader, method string) {
+	t.Helper()
+
+	response, err := http.ReadResponse(reader, &http.Request{Method: method})
+	if err != nil {
+		t.Fatalf("http.ReadResponse returned error: %v", err)
+	}
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+}
+
+func startEchoBackend(t *testing.T) string {
+	t.Helper()
+
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	return backend.Addr().String()
+}
@@ -0,0 +1,379 @@
+// X-Forwarded-For injection rewrites the head of every HTTP/1.x request a client sends and passes bodies through byte for byte.
+// Request framing is followed exactly, so keep-alive and pipelined requests each get their own headers.
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	forwardedReaderSize  = 16 * 1024
+	maxForwardedHeadSize = 64 * 1024
+)
+
+// errForwardedFraming closes a connection whose HTTP framing the rewriter lost, since passing the rest on would also pass the client's own X-Forwarded-For.
+var errForwardedFraming = errors.New("cannot follow HTTP request framing to add X-Forwarded-For")
+
+// forwardedState says what the next bytes on the client stream are.
+type forwardedState int
+
+const (
+	forwardedHead forwardedState = iota
+	forwardedFixedBody
+	forwardedChunkSize
+	forwardedChunkData
+	forwardedTrailers
+	forwardedPassthrough
+)
+
+// forwardedHeaderConn returns the client's request stream with X-Forwarded-For and X-Forwarded-Proto set on every request.
+// A first line that is not HTTP/1.x switches it to passthrough for the rest of the connection, so other traffic is never altered;
+// once a stream started as HTTP, only an upgrade the backend accepted does that.
+type forwardedHeaderConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	clientIP   string
+	proto      string
+	state      forwardedState
+	remaining  int64 // remaining counts the bytes left in a fixed-length body or the current chunk.
+	pending    []byte
+	pendingErr error
+	buffer     []byte
+
+	// requests, switched, and responses follow the backend's answers; they stay nil unless watchResponses was called.
+	requests  chan forwardedRequest
+	switched  chan bool
+	responses *tapStream
+	stopped   chan struct{}
+	upgrading bool // upgrading is set while the last request asked for an upgrade the backend has not answered yet.
+}
+
+// forwardedRequest is what the response watcher needs to pair a response with the request it answers.
+type forwardedRequest struct {
+	method  string
+	upgrade bool
+}
+
+// newForwardedHeaderConn wraps conn; preface holds client bytes that were already read and still need rewriting.
+func newForwardedHeaderConn(conn net.Conn, preface []byte, clientIP, proto string) *forwardedHeaderConn {
+	var source io.Reader = conn
+	if len(preface) > 0 {
+		source = io.MultiReader(bytes.NewReader(preface), conn)
+	}
+	return &forwardedHeaderConn{
+		Conn:     conn,
+		reader:   bufio.NewReaderSize(source, forwardedReaderSize),
+		clientIP: clientIP,
+		proto:    proto,
+		buffer:   make([]byte, forwardedReaderSize),
+	}
+}
+
+// watchResponses follows the backend's responses read through the returned conn, so an upgrade ends the rewriting only once it was accepted.
+func (conn *forwardedHeaderConn) watchResponses(server net.Conn) net.Conn {
+	conn.requests = make(chan forwardedRequest, maxPendingAccessRequests)
+	conn.switched = make(chan bool, 1)
+	conn.responses = newTapStream()
+	conn.stopped = make(chan struct{})
+	go watchForwardedResponses(conn.responses, conn.requests, conn.switched)
+	return tappedConn{Conn: server, tap: conn.responses}
+}
+
+// stop releases a Read still waiting for the backend's answer to an upgrade once the connection is closing.
+func (conn *forwardedHeaderConn) stop() {
+	if conn != nil && conn.stopped != nil {
+		close(conn.stopped)
+	}
+}
+
+// close ends the response watcher; it runs after both copy loops finished, since the server one writes the stream.
+func (conn *forwardedHeaderConn) close() {
+	if conn != nil && conn.responses != nil {
+		conn.responses.Close()
+	}
+}
+
+func (conn *forwardedHeaderConn) Read(buffer []byte) (int, error) {
+	for len(conn.pending) == 0 {
+		if conn.pendingErr != nil {
+			err := conn.pendingErr
+			conn.pendingErr = nil
+			return 0, err
+		}
+		conn.pending, conn.pendingErr = conn.advance()
+	}
+	n := copy(buffer, conn.pending)
+	conn.pending = conn.pending[n:]
+	return n, nil
+}
+
+// advance reads the next piece of the client stream and returns it, rewritten if it was a request head.
+func (conn *forwardedHeaderConn) advance() ([]byte, error) {
+	switch conn.state {
+	case forwardedHead:
+		if conn.upgrading {
+			conn.upgrading = false
+			switched, err := conn.awaitUpgrade()
+			if err != nil {
+				return nil, err
+			}
+			if switched {
+				conn.state = forwardedPassthrough
+				return conn.advance()
+			}
+		}
+		return conn.readHead()
+	case forwardedFixedBody, forwardedChunkData:
+		return conn.readCounted()
+	case forwardedChunkSize:
+		line, err := conn.reader.ReadSlice('\n')
+		if err != nil {
+			return conn.lostFraming(line, err)
+		}
+		size, ok := parseChunkSize(line)
+		switch {
+		case !ok:
+			return nil, errForwardedFraming
+		case size == 0:
+			conn.state = forwardedTrailers
+		default:
+			conn.state = forwardedChunkData
+			conn.remaining = size + 2 // The chunk data is followed by CRLF.
+		}
+		return line, nil
+	case forwardedTrailers:
+		line, err := conn.reader.ReadSlice('\n')
+		if err != nil {
+			return conn.lostFraming(line, err)
+		}
+		if isBlankLine(line) {
+			conn.state = forwardedHead
+		}
+		return line, nil
+	default:
+		n, err := conn.reader.Read(conn.buffer)
+		return conn.buffer[:n], err
+	}
+}
+
+// readCounted forwards the rest of a fixed-length body or chunk without looking at it.
+func (conn *forwardedHeaderConn) readCounted() ([]byte, error) {
+	limit := int64(len(conn.buffer))
+	if conn.remaining < limit {
+		limit = conn.remaining
+	}
+	n, err := conn.reader.Read(conn.buffer[:limit])
+	conn.remaining -= int64(n)
+	if conn.remaining == 0 {
+		if conn.state == forwardedChunkData {
+			conn.state = forwardedChunkSize
+		} else {
+			conn.state = forwardedHead
+		}
+	}
+	return conn.buffer[:n], err
+}
+
+// readHead collects one request head and returns it with the forwarding headers added.
+func (conn *forwardedHeaderConn) readHead() ([]byte, error) {
+	var raw []byte
+	for {
+		line, err := conn.reader.ReadSlice('\n')
+		raw = append(raw, line...)
+		if len(raw) == len(line) && err != nil {
+			// A first line that does not fit the buffer or never ends is not HTTP we can follow.
+			if errors.Is(err, bufio.ErrBufferFull) {
+				err = nil
+			}
+			return conn.passthrough(raw, err)
+		}
+		if err != nil || len(raw) > maxForwardedHeadSize {
+			return conn.lostFraming(nil, err)
+		}
+		if len(raw) == len(line) {
+			// Some clients send a stray CRLF between keep-alive requests; it is forwarded and the head starts after it.
+			if isBlankLine(line) {
+				return raw, nil
+			}
+			if !isHTTPRequestLine(line) {
+				return conn.passthrough(raw, nil)
+			}
+			continue
+		}
+		if isBlankLine(line) {
+			break
+		}
+	}
+
+	head, next := rewriteRequestHead(raw, conn.clientIP, conn.proto)
+	conn.state, conn.remaining = next.state, next.remaining
+	conn.expectResponse(string(bytes.Fields(raw)[0]), next.upgrade)
+	return head, nil
+}
+
+// expectResponse queues a request for the response watcher before its head reaches the backend, so the answer always finds it.
+func (conn *forwardedHeaderConn) expectResponse(method string, upgrade bool) {
+	if conn.requests == nil {
+		return
+	}
+	select {
+	case conn.requests <- forwardedRequest{method: method, upgrade: upgrade}:
+		conn.upgrading = upgrade
+	default:
+		// Too many unanswered requests to pair responses with; no later upgrade is trusted, so rewriting simply goes on.
+		conn.requests = nil
+	}
+}
+
+// awaitUpgrade holds the client's next bytes until the backend answered the upgrade request.
+// Only a 101, or a 2xx for CONNECT, turns the rest of the stream opaque; a pipelined request behind a refused upgrade is still rewritten.
+func (conn *forwardedHeaderConn) awaitUpgrade() (bool, error) {
+	select {
+	case switched := <-conn.switched:
+		return switched, nil
+	case <-conn.stopped:
+		return false, net.ErrClosed
+	}
+}
+
+// passthrough hands the bytes over unchanged and stops rewriting for the rest of the connection.
+// It is only for streams whose first line was not HTTP.
+func (conn *forwardedHeaderConn) passthrough(raw []byte, err error) ([]byte, error) {
+	conn.state = forwardedPassthrough
+	return append([]byte(nil), raw...), err
+}
+
+// lostFraming ends an HTTP stream the rewriter can no longer follow.
+// A line or head too long to check closes the connection; a read error ends it as usual after raw, the bytes still safe to pass on.
+func (conn *forwardedHeaderConn) lostFraming(raw []byte, err error) ([]byte, error) {
+	if err == nil || errors.Is(err, bufio.ErrBufferFull) {
+		return nil, errForwardedFraming
+	}
+	return append([]byte(nil), raw...), err
+}
+
+type forwardedFraming struct {
+	state     forwardedState
+	remaining int64
+	upgrade   bool
+}
+
+// rewriteRequestHead drops client-sent forwarding headers, appends ours, and works out how the body is framed.
+// Earlier X-Forwarded-For values are kept in front of the client IP so chains of proxies stay intact.
+func rewriteRequestHead(raw []byte, clientIP, proto string) ([]byte, forwardedFraming) {
+	lines := strings.SplitAfter(string(raw), "\n")
+	requestLine := lines[0]
+	var kept []string
+	var forwardedFor []string
+	framing := forwardedFraming{state: forwardedHead}
+	chunked, upgrade := false, strings.HasPrefix(requestLine, "CONNECT ")
+	contentLength := int64(-1)
+
+	for _, line := range lines[1:] {
+		if isBlankLine([]byte(line)) || line == "" {
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found || line[0] == ' ' || line[0] == '\t' {
+			kept = append(kept, line)
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "x-forwarded-for":
+			forwardedFor = append(forwardedFor, value)
+			continue
+		case "x-forwarded-proto":
+			continue
+		case "transfer-encoding":
+			codings := strings.Split(strings.ToLower(value), ",")
+			chunked = strings.TrimSpace(codings[len(codings)-1]) == "chunked"
+		case "content-length":
+			if length, err := strconv.ParseInt(value, 10, 64); err == nil && length >= 0 {
+				contentLength = length
+			}
+		case "upgrade":
+			upgrade = true
+		}
+		kept = append(kept, line)
+	}
+
+	forwardedFor = append(forwardedFor, clientIP)
+	var head strings.Builder
+	head.WriteString(requestLine)
+	for _, line := range kept {
+		head.WriteString(line)
+	}
+	head.WriteString("X-Forwarded-For: " + strings.Join(forwardedFor, ", ") + "\r\n")
+	head.WriteString("X-Forwarded-Proto: " + proto + "\r\n")
+	head.WriteString("\r\n")
+
+	// Upgraded connections and tunnels stop speaking HTTP only once the backend agreed, which the response watcher reports.
+	framing.upgrade = upgrade
+	switch {
+	case chunked:
+		framing.state = forwardedChunkSize
+	case contentLength > 0:
+		framing = forwardedFraming{state: forwardedFixedBody, remaining: contentLength}
+	}
+	return []byte(head.String()), framing
+}
+
+// watchForwardedResponses pairs the backend's responses with the requests sent to it and reports how each upgrade request was answered.
+// It stops at an accepted upgrade or at anything it cannot parse; switched is closed then, which a waiting upgrade reads as refused.
+func watchForwardedResponses(stream io.Reader, requests <-chan forwardedRequest, switched chan<- bool) {
+	defer io.Copy(io.Discard, stream)
+	defer close(switched)
+
+	reader := bufio.NewReader(stream)
+	for {
+		if _, err := reader.Peek(1); err != nil {
+			return
+		}
+		var request forwardedRequest
+		select {
+		case request = <-requests:
+		default:
+			// A response no request is waiting for, such as a 408, means the pairing is lost.
+			return
+		}
+		response, err := readFinalResponse(reader, &http.Request{Method: request.method})
+		if err != nil {
+			return
+		}
+		accepted := response.StatusCode == http.StatusSwitchingProtocols || (request.method == http.MethodConnect && response.StatusCode/100 == 2)
+		if request.upgrade {
+			switched <- accepted
+		}
+		if accepted {
+			return
+		}
+		if _, err := io.Copy(io.Discard, response.Body); err != nil {
+			return
+		}
+	}
+}
+
+// isHTTPRequestLine accepts "METHOD target HTTP/1.x" so that other protocols on the route are left alone.
+func isHTTPRequestLine(line []byte) bool {
+	fields := strings.Fields(string(line))
+	return len(fields) == 3 && strings.HasPrefix(fields[2], "HTTP/1.")
+}
+
+func isBlankLine(line []byte) bool {
+	return len(bytes.TrimRight(line, "\r\n")) == 0
+}
+
+// parseChunkSize reads the hexadecimal size in front of any chunk extensions.
+func parseChunkSize(line []byte) (int64, bool) {
+	sizeText, _, _ := strings.Cut(strings.TrimSpace(string(line)), ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 16, 64)
+	return size, err == nil && size >= 0
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwardedHeaderConnRewritesPipelinedRequests(t *testing.T) {
+	input := "POST /upload HTTP/1.1\r\nHost: a\r\nContent-Length: 11\r\nX-Forwarded-For: 192.0.2.1\r\nX-Forwarded-Proto: https\r\n\r\nhello\r\n\r\n.." +
+		"PUT /chunks HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nGET /\r\n0\r\nTrailer: x\r\n\r\n" +
+		"\r\nGET /last HTTP/1.1\r\nHost: a\r\n\r\n"
+
+	got := readForwarded(t, nil, input)
+	want := "POST /upload HTTP/1.1\r\nHost: a\r\nContent-Length: 11\r\nX-Forwarded-For: 192.0.2.1, 198.51.100.7\r\nX-Forwarded-Proto: http\r\n\r\nhello\r\n\r\n.." +
+		"PUT /chunks HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nX-Forwarded-For: 198.51.100.7\r\nX-Forwarded-Proto: http\r\n\r\n5\r\nGET /\r\n0\r\nTrailer: x\r\n\r\n" +
+		"\r\nGET /last HTTP/1.1\r\nHost: a\r\nX-Forwarded-For: 198.51.100.7\r\nX-Forwarded-Proto: http\r\n\r\n"
+	if got != want {
+		t.Fatalf("rewritten stream =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestForwardedHeaderConnLeavesNonHTTPUntouched(t *testing.T) {
+	input := "SSH-2.0-OpenSSH_9.6\r\nbinary\x00data"
+	if got := readForwarded(t, nil, input); got != input {
+		t.Fatalf("non-HTTP stream changed: %q", got)
+	}
+}
+
+func TestForwardedHeaderConnClosesOnHeadsTooLargeToRewrite(t *testing.T) {
+	for name, input := range map[string]string{
+		"long line": "GET / HTTP/1.1\r\nX-Forwarded-For: 6.6.6.6\r\nCookie: " + strings.Repeat("a", 17*1024) + "\r\n\r\n",
+		"long head": "GET / HTTP/1.1\r\nX-Forwarded-For: 6.6.6.6\r\n" + strings.Repeat("Cookie: "+strings.Repeat("a", 1024)+"\r\n", 70) + "\r\n",
+		"bad chunk": "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nGET / HTTP/1.1\r\nX-Forwarded-For: 6.6.6.6\r\n\r\n",
+	} {
+		got, err := forwardRequests(nil, input)
+		if err != errForwardedFraming {
+			t.Fatalf("%s: error = %v, want %v", name, err, errForwardedFraming)
+		}
+		if strings.Contains(got, "6.6.6.6") {
+			t.Fatalf("%s: client's own X-Forwarded-For was passed on: %q", name, got)
+		}
+	}
+}
+
+func TestForwardedHeaderConnRewritesPreface(t *testing.T) {
+	got := readForwarded(t, []byte("GET / HT"), "TP/1.1\r\nHost: a\r\n\r\n")
+	if got != "GET / HTTP/1.1\r\nHost: a\r\nX-Forwarded-For: 198.51.100.7\r\nX-Forwarded-Proto: http\r\n\r\n" {
+		t.Fatalf("rewritten stream = %q", got)
+	}
+}
+
+func TestHandleTCPConnectionAddsForwardedForToEveryRequest(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	go http.Serve(backend, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-Forwarded-For")+"|"+r.Header.Get("X-Forwarded-Proto"))
+	}))
+
+	clientConn, _ := startHandledConnection(t, backend.Addr().String(), Options{ForwardedFor: true})
+	defer clientConn.Close()
+
+	// Both requests go out before either response is read, so the second one is pipelined behind the first.
+	io.WriteString(clientConn, "GET /one HTTP/1.1\r\nHost: a\r\n\r\nGET /two HTTP/1.1\r\nHost: a\r\nX-Forwarded-For: 192.0.2.1\r\n\r\n")
+	reader := bufio.NewReader(clientConn)
+	for _, want := range []string{"127.0.0.1|http", "192.0.2.1, 127.0.0.1|http"} {
+		_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		response, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("http.ReadResponse returned error: %v", err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		if string(body) != want {
+			t.Fatalf("backend saw %q, want %q", body, want)
+		}
+	}
+}
+
+func TestHandleTCPConnectionRewritesRequestsBehindARefusedUpgrade(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	// The backend does not speak h2c, so it answers the upgrade request as a plain request.
+	go http.Serve(backend, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-Forwarded-For"))
+	}))
+
+	clientConn, _ := startHandledConnection(t, backend.Addr().String(), Options{ForwardedFor: true})
+	defer clientConn.Close()
+
+	io.WriteString(clientConn, "GET /one HTTP/1.1\r\nHost: a\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"+
+		"GET /two HTTP/1.1\r\nHost: a\r\nX-Forwarded-For: 6.6.6.6\r\n\r\n")
+	reader := bufio.NewReader(clientConn)
+	for _, want := range []string{"127.0.0.1", "6.6.6.6, 127.0.0.1"} {
+		_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		response, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("http.ReadResponse returned error: %v", err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		if string(body) != want {
+			t.Fatalf("backend saw X-Forwarded-For %q, want %q", body, want)
+		}
+	}
+}
+
+func TestHandleTCPConnectionPassesAnAcceptedUpgradeThrough(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		if _, err := http.ReadRequest(reader); err != nil {
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		io.Copy(conn, reader)
+	}()
+
+	clientConn, _ := startHandledConnection(t, backend.Addr().String(), Options{ForwardedFor: true})
+	defer clientConn.Close()
+	_ = clientConn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	io.WriteString(clientConn, "GET / HTTP/1.1\r\nHost: a\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	reader := bufio.NewReader(clientConn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil || response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("http.ReadResponse = %v, %v; want 101", response, err)
+	}
+
+	// Past the 101 the stream belongs to the new protocol, even when its bytes look like a request.
+	tunneled := "GET /inside HTTP/1.1\r\nX-Forwarded-For: 6.6.6.6\r\n\r\n"
+	io.WriteString(clientConn, tunneled)
+	echoed := make([]byte, len(tunneled))
+	if _, err := io.ReadFull(reader, echoed); err != nil {
+		t.Fatalf("io.ReadFull returned error: %v", err)
+	}
+	if string(echoed) != tunneled {
+		t.Fatalf("tunneled bytes = %q, want %q", echoed, tunneled)
+	}
+}
+
+// readForwarded feeds input through the rewriter and returns everything it produced.
+func readForwarded(t *testing.T, preface []byte, input string) string {
+	t.Helper()
+
+	output, err := forwardRequests(preface, input)
+	if err != nil {
+		t.Fatalf("io.ReadAll returned error: %v", err)
+	}
+	return output
+}
+
+// forwardRequests runs input through a rewriter that is not watching responses and returns what it produced and how it ended.
+func forwardRequests(preface []byte, input string) (string, error) {
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	go func() {
+		io.WriteString(clientSide, input)
+		clientSide.Close()
+	}()
+
+	output, err := io.ReadAll(newForwardedHeaderConn(serverSide, preface, "198.51.100.7", "http"))
+	return string(output), err
+}
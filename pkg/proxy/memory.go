@@ -0,0 +1,101 @@
+// The memory guard keeps an overloaded proxy from being killed for running out of memory.
+// Above a high-water mark new TCP clients wait in the kernel's accept backlog and new UDP clients are dropped; below the low-water mark both resume.
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryCheckInterval is how often the guard samples memory; ReadMemStats stops the world briefly, so it is not done per connection.
+const MemoryCheckInterval = time.Second
+
+// MemoryGuard is shared by every route; only its own goroutine samples memory and changes the state.
+// A nil *MemoryGuard never pauses, which is the behavior without -mem-pressure-pause.
+type MemoryGuard struct {
+	high, low uint64
+	read      func() uint64
+	logger    *log.Logger
+	holding   bool                          // holding is only touched by sample.
+	gate      atomic.Pointer[chan struct{}] // gate is closed while clients are admitted and open while paused.
+}
+
+// openGate is the gate of an unpaused guard; it is closed once and shared.
+var openGate = func() chan struct{} {
+	gate := make(chan struct{})
+	close(gate)
+	return gate
+}()
+
+// NewMemoryGuard starts sampling the process's memory every MemoryCheckInterval until the process exits.
+func NewMemoryGuard(high, low uint64, logger *log.Logger) *MemoryGuard {
+	guard := newMemoryGuard(high, low, readMemoryUse, logger)
+	go func() {
+		ticker := time.NewTicker(MemoryCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			guard.sample()
+		}
+	}()
+	return guard
+}
+
+// newMemoryGuard builds a guard around read without sampling, so tests can feed it memory figures.
+func newMemoryGuard(high, low uint64, read func() uint64, logger *log.Logger) *MemoryGuard {
+	guard := &MemoryGuard{high: high, low: low, read: read, logger: logger}
+	gate := openGate
+	guard.gate.Store(&gate)
+	return guard
+}
+
+// readMemoryUse is the memory the runtime holds from the OS, minus heap it has already given back; it tracks RSS closely.
+func readMemoryUse() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.Sys - stats.HeapReleased
+}
+
+// sample reads memory once and pauses or resumes admission when it crosses a mark.
+// The gap between the marks keeps usage hovering near one of them from flapping the state.
+func (guard *MemoryGuard) sample() {
+	used := guard.read()
+	switch {
+	case !guard.holding && used >= guard.high:
+		guard.holding = true
+		gate := make(chan struct{})
+		guard.gate.Store(&gate)
+		guard.logger.Printf("WARNING: memory use %s reached the high-water mark of %s; pausing new TCP connections and UDP sessions", MiB(used), MiB(guard.high))
+	case guard.holding && used <= guard.low:
+		guard.holding = false
+		close(*guard.gate.Load())
+		gate := openGate
+		guard.gate.Store(&gate)
+		guard.logger.Printf("Memory use %s is below the low-water mark of %s; accepting new TCP connections and UDP sessions again", MiB(used), MiB(guard.low))
+	}
+}
+
+// admitted returns a channel that is closed while new clients may start; a TCP accept loop waits on it before accepting.
+func (guard *MemoryGuard) admitted() <-chan struct{} {
+	if guard == nil {
+		return openGate
+	}
+	return *guard.gate.Load()
+}
+
+// MiB renders a byte count in whole mebibytes for log lines.
+func MiB(bytes uint64) string {
+	return fmt.Sprintf("%.0f MiB", float64(bytes)/(1<<20))
+}
+
+// paused reports whether new clients are being held back.
+func (guard *MemoryGuard) paused() bool {
+	select {
+	case <-guard.admitted():
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryGuardPausesAtHighMarkAndResumesAtLowMark(t *testing.T) {
+	used := uint64(500 << 20)
+	lines := make(logLines, 8)
+	guard := newMemoryGuard(1<<30, 768<<20, func() uint64 { return used }, log.New(lines, "", 0))
+
+	guard.sample()
+	if guard.paused() {
+		t.Fatal("guard paused below the high-water mark")
+	}
+	used = 1100 << 20
+	guard.sample()
+	if !guard.paused() {
+		t.Fatal("guard did not pause above the high-water mark")
+	}
+	waitForLogLine(t, lines, "WARNING: memory use 1100 MiB reached the high-water mark of 1024 MiB; pausing new TCP connections and UDP sessions\n")
+	waiting := guard.admitted()
+
+	used = 900 << 20
+	guard.sample()
+	if !guard.paused() {
+		t.Fatal("guard resumed between the marks")
+	}
+	used = 700 << 20
+	guard.sample()
+	if guard.paused() {
+		t.Fatal("guard did not resume below the low-water mark")
+	}
+	waitForLogLine(t, lines, "Memory use 700 MiB is below the low-water mark of 768 MiB; accepting new TCP connections and UDP sessions again\n")
+	select {
+	case <-waiting:
+	default:
+		t.Fatal("an accept loop waiting during the pause was not released")
+	}
+
+	var disabled *MemoryGuard
+	if disabled.paused() {
+		t.Fatal("nil guard paused")
+	}
+}
+
+func TestMemoryPressureHoldsTCPClientsInBacklog(t *testing.T) {
+	backendAddr := startLineBackend(t, "pong\n")
+	used := uint64(2 << 30)
+	guard := newMemoryGuard(1<<30, 512<<20, func() uint64 { return used }, log.New(io.Discard, "", 0))
+	guard.sample()
+
+	proxyAddr := serveTCPForTest(t, backendAddr, Options{MemoryGuard: guard})
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 16)); err == nil {
+		t.Fatal("a client was served while memory pressure paused accepting")
+	}
+
+	used = 256 << 20
+	guard.sample()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply := make([]byte, 16)
+	n, err := conn.Read(reply)
+	if err != nil || !strings.HasPrefix(string(reply[:n]), "pong") {
+		t.Fatalf("reply after resume = %q, %v", reply[:n], err)
+	}
+}
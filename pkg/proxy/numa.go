@@ -0,0 +1,146 @@
+// NUMA awareness gives each NUMA node of a large host its own TCP accept loop and connection workers, on threads pinned to that node's CPUs.
+// Go schedules goroutines on any thread it likes, so this only raises the odds that a connection stays on one node; it guarantees nothing.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/logging"
+)
+
+// NUMANodes returns the CPUs of each NUMA node this process may run on, for Options.NUMANodes.
+// CPUs outside the process affinity, as set by taskset or a cpuset cgroup, are left out, and nodes left without any are dropped.
+func NUMANodes() ([][]int, error) {
+	nodes, err := readNUMANodes()
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := processCPUs()
+	if err != nil {
+		return nil, err
+	}
+	var usable [][]int
+	for _, cpus := range nodes {
+		var kept []int
+		for _, cpu := range cpus {
+			if allowed[cpu] {
+				kept = append(kept, cpu)
+			}
+		}
+		if len(kept) > 0 {
+			usable = append(usable, kept)
+		}
+	}
+	return usable, nil
+}
+
+// DescribeNUMANodes lists each node's CPUs for the startup log, such as "node 0: CPUs 0-15; node 1: CPUs 16-31".
+func DescribeNUMANodes(nodes [][]int) string {
+	parts := make([]string, 0, len(nodes))
+	for node, cpus := range nodes {
+		parts = append(parts, fmt.Sprintf("node %d: CPUs %s", node, formatCPUList(cpus)))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// parseCPUList reads the kernel's cpulist format, such as "0-3,8-11", into CPU numbers.
+func parseCPUList(list string) ([]int, error) {
+	var cpus []int
+	for _, span := range strings.Split(strings.TrimSpace(list), ",") {
+		if span == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(span, "-")
+		if !isRange {
+			last = first
+		}
+		low, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU list '%s'", list)
+		}
+		high, err := strconv.Atoi(last)
+		if err != nil || low < 0 || high < low {
+			return nil, fmt.Errorf("invalid CPU list '%s'", list)
+		}
+		for cpu := low; cpu <= high; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// formatCPUList writes sorted CPU numbers back in cpulist form, folding runs into ranges.
+func formatCPUList(cpus []int) string {
+	var spans []string
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if j == i {
+			spans = append(spans, strconv.Itoa(cpus[i]))
+		} else {
+			spans = append(spans, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(spans, ",")
+}
+
+// pinToNode locks the calling goroutine to its thread and pins that thread to cpus; nil cpus leaves the goroutine alone.
+// The goroutine never unlocks, so once it returns Go discards the pinned thread instead of running other goroutines on it.
+func pinToNode(cpus []int, listenAddr string, logger *log.Logger, limiter *logging.RateLimiter) {
+	if cpus == nil {
+		return
+	}
+	runtime.LockOSThread()
+	if err := pinThread(cpus); err != nil {
+		limiter.Printf(logger, "tcp numa "+listenAddr, "Cannot pin a TCP worker of %s to CPUs %s: %v", listenAddr, formatCPUList(cpus), err)
+	}
+}
+
+// acceptedTCP is what one of the per-node accept loops took from the listener.
+type acceptedTCP struct {
+	conn net.Conn
+	err  error
+	node int
+}
+
+// acceptOnNodes runs one Accept loop per node, each on a thread pinned to that node, and merges what they accept.
+// Admission stays in the one goroutine reading the channel, because it owns the route's quota and single-shot state.
+// The loops stop once done is closed; a connection accepted after that is closed unserved.
+func acceptOnNodes(listener net.Listener, nodes [][]int, guard *MemoryGuard, done <-chan struct{}, logger *log.Logger, limiter *logging.RateLimiter) <-chan acceptedTCP {
+	accepted := make(chan acceptedTCP)
+	listenAddr := listener.Addr().String()
+	for node, cpus := range nodes {
+		go func(node int, cpus []int) {
+			pinToNode(cpus, listenAddr, logger, limiter)
+			for {
+				select {
+				case <-guard.admitted():
+				case <-done:
+					return
+				}
+				conn, err := listener.Accept()
+				select {
+				case accepted <- acceptedTCP{conn: conn, err: err, node: node}:
+				case <-done:
+					if conn != nil {
+						conn.Close()
+					}
+					return
+				}
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+			}
+		}(node, cpus)
+	}
+	return accepted
+}
@@ -0,0 +1,88 @@
+//go:build linux
+// +build linux
+
+// Linux lists the CPUs of each NUMA node in sysfs and pins a thread with sched_setaffinity.
+// Only the calling thread is pinned, which is why callers hold it with runtime.LockOSThread first.
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// NUMASupported reports whether Options.NUMANodes can pin threads on this platform.
+const NUMASupported = true
+
+const (
+	numaNodeDir = "/sys/devices/system/node"
+	// affinityWords sizes the CPU masks for up to 8192 CPUs, more than any kernel build in use allows.
+	affinityWords = 128
+)
+
+// readNUMANodes returns the CPUs of every node that has any, in node number order.
+func readNUMANodes() ([][]int, error) {
+	paths, err := filepath.Glob(filepath.Join(numaNodeDir, "node[0-9]*", "cpulist"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s lists no NUMA nodes", numaNodeDir)
+	}
+	nodeNumber := func(path string) int {
+		number, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(path)), "node"))
+		return number
+	}
+	sort.Slice(paths, func(i, j int) bool { return nodeNumber(paths[i]) < nodeNumber(paths[j]) })
+
+	var nodes [][]int
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cpus, err := parseCPUList(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if len(cpus) > 0 {
+			nodes = append(nodes, cpus)
+		}
+	}
+	return nodes, nil
+}
+
+// processCPUs returns the CPUs the calling thread may run on, which Go threads inherit from the process.
+func processCPUs() (map[int]bool, error) {
+	var mask [affinityWords]uint64
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, 0, uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0]))); errno != 0 {
+		return nil, fmt.Errorf("sched_getaffinity: %v", errno)
+	}
+	allowed := map[int]bool{}
+	for cpu := 0; cpu < affinityWords*64; cpu++ {
+		if mask[cpu/64]&(1<<(cpu%64)) != 0 {
+			allowed[cpu] = true
+		}
+	}
+	return allowed, nil
+}
+
+// pinThread restricts the calling thread to cpus.
+func pinThread(cpus []int) error {
+	var mask [affinityWords]uint64
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= affinityWords*64 {
+			return fmt.Errorf("CPU %d is out of range", cpu)
+		}
+		mask[cpu/64] |= 1 << (cpu % 64)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0]))); errno != 0 {
+		return fmt.Errorf("sched_setaffinity: %v", errno)
+	}
+	return nil
+}
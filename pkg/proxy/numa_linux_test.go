@@ -0,0 +1,35 @@
+//go:build linux
+// +build linux
+
+package proxy
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestPinThreadRestrictsTheCallingThread(t *testing.T) {
+	nodes, err := NUMANodes()
+	if err != nil || len(nodes) == 0 {
+		t.Fatalf("NUMANodes = %v, %v; want at least the node this test runs on", nodes, err)
+	}
+	cpu := nodes[0][0]
+
+	result := make(chan error, 1)
+	go func() {
+		// The goroutine exits still locked, so the pinned thread is discarded rather than reused by the rest of the test binary.
+		runtime.LockOSThread()
+		if err := pinThread([]int{cpu}); err != nil {
+			result <- err
+			return
+		}
+		allowed, err := processCPUs()
+		if err == nil && (len(allowed) != 1 || !allowed[cpu]) {
+			t.Errorf("thread may run on %v after pinning to CPU %d", allowed, cpu)
+		}
+		result <- err
+	}()
+	if err := <-result; err != nil {
+		t.Fatalf("pinning returned error: %v", err)
+	}
+}
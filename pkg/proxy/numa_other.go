@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+// NUMA node discovery and thread pinning are only wired up on Linux.
+// main turns -numa-aware off elsewhere with a warning, since routes work the same unpinned.
+package proxy
+
+import "errors"
+
+// NUMASupported reports whether Options.NUMANodes can pin threads on this platform.
+const NUMASupported = false
+
+var errNUMAUnsupported = errors.New("NUMA pinning needs Linux")
+
+func readNUMANodes() ([][]int, error) {
+	return nil, errNUMAUnsupported
+}
+
+func processCPUs() (map[int]bool, error) {
+	return nil, errNUMAUnsupported
+}
+
+func pinThread([]int) error {
+	return errNUMAUnsupported
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+func TestParseCPUListReadsRangesAndSingleCPUs(t *testing.T) {
+	cpus, err := parseCPUList("0-3,8,10-11\n")
+	if err != nil {
+		t.Fatalf("parseCPUList returned error: %v", err)
+	}
+	if fmt.Sprint(cpus) != "[0 1 2 3 8 10 11]" {
+		t.Fatalf("cpus = %v", cpus)
+	}
+	if got := formatCPUList(cpus); got != "0-3,8,10-11" {
+		t.Fatalf("formatCPUList = %q, want the list folded back into ranges", got)
+	}
+	for _, list := range []string{"3-1", "a", "1-", "-2"} {
+		if _, err := parseCPUList(list); err == nil {
+			t.Fatalf("parseCPUList(%q) accepted an invalid list", list)
+		}
+	}
+}
+
+func TestNUMAAcceptLoopsServeClientsAndStopWithTheListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	returned := make(chan struct{})
+	go func() {
+		// Two nodes on CPU 0 exercise both accept loops on any machine; a failed pin is only logged.
+		ServeTCPProxy(listener, startEchoServer(t), config.AllowList{}, log.New(io.Discard, "", 0), Options{NUMANodes: [][]int{{0}, {0}}})
+		close(returned)
+	}()
+
+	for i := 0; i < 8; i++ {
+		echoThroughProxy(t, listener.Addr().String())
+	}
+	listener.Close()
+	select {
+	case <-returned:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeTCPProxy kept running after its listener closed")
+	}
+}
+
+// BenchmarkTCPConnection opens, echoes through, and closes one proxied connection per iteration, unpinned and with one
+// accept loop per NUMA node. On a single-node host both runs measure the same thing; compare them on the host that matters.
+func BenchmarkTCPConnection(b *testing.B) {
+	nodes, err := NUMANodes()
+	if err != nil {
+		nodes = [][]int{{0}}
+	}
+	for _, run := range []struct {
+		name  string
+		nodes [][]int
+	}{
+		{"unpinned", nil},
+		{fmt.Sprintf("numa-%d-nodes", len(nodes)), nodes},
+	} {
+		b.Run(run.name, func(b *testing.B) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatalf("net.Listen returned error: %v", err)
+			}
+			defer listener.Close()
+			go ServeTCPProxy(listener, startEchoServer(b), config.AllowList{}, log.New(io.Discard, "", 0), Options{NUMANodes: run.nodes, MaxConnections: b.N + 1})
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					echoThroughProxy(b, listener.Addr().String())
+				}
+			})
+		})
+	}
+}
+
+// startEchoServer echoes every connection it accepts until the test ends, unlike startEchoBackend, which serves one.
+func startEchoServer(tb testing.TB) string {
+	tb.Helper()
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("net.Listen returned error: %v", err)
+	}
+	tb.Cleanup(func() { backend.Close() })
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return backend.Addr().String()
+}
+
+func echoThroughProxy(tb testing.TB, addr string) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		tb.Errorf("net.Dial returned error: %v", err)
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	reply := make([]byte, 4)
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		tb.Errorf("Write returned error: %v", err)
+		return
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		tb.Errorf("read %q, %v; want echoed ping", reply, err)
+	}
+}
@@ -0,0 +1,70 @@
+// Internet-facing ports see scanners open and drop connections all day, and each one would log an open and a close line.
+// With a minimum duration the open line waits until the connection has lasted that long, so brief silent ones can go unlogged.
+package proxy
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	openLinePending int32 = iota
+	openLineLogged
+	openLineSkipped
+)
+
+// connectionOpenLine holds back a connection's "New TCP connection" line until it lasts minDuration.
+// A nil *connectionOpenLine logs the line at once, which is the behavior without a minimum.
+type connectionOpenLine struct {
+	minDuration time.Duration
+	started     time.Time
+	state       atomic.Int32
+	print       func()
+	timer       *time.Timer
+}
+
+// startConnectionOpenLine returns nil for a zero minDuration, so connections on routes without one pay nothing.
+func startConnectionOpenLine(minDuration time.Duration) *connectionOpenLine {
+	if minDuration <= 0 {
+		return nil
+	}
+	return &connectionOpenLine{minDuration: minDuration, started: time.Now()}
+}
+
+// ready hands over the open line once its contents are known; it is printed when the connection reaches the minimum duration.
+func (line *connectionOpenLine) ready(print func()) {
+	if line == nil {
+		print()
+		return
+	}
+	line.print = print
+	line.timer = time.AfterFunc(line.minDuration-time.Since(line.started), line.log)
+}
+
+// log prints the open line now unless it was printed or skipped already; later lines about the connection call it so they never precede it.
+func (line *connectionOpenLine) log() {
+	if line == nil || !line.state.CompareAndSwap(openLinePending, openLineLogged) {
+		return
+	}
+	if line.print != nil {
+		line.print()
+	}
+}
+
+// skip reports whether the connection ended early enough to go unlogged, and if so keeps the open line from ever printing.
+// The caller decides whether any bytes moved; skip only judges the time.
+func (line *connectionOpenLine) skip() bool {
+	if line == nil {
+		return false
+	}
+	if line.state.Load() == openLineSkipped {
+		return true
+	}
+	if time.Since(line.started) >= line.minDuration || !line.state.CompareAndSwap(openLinePending, openLineSkipped) {
+		return false
+	}
+	if line.timer != nil {
+		line.timer.Stop()
+	}
+	return true
+}
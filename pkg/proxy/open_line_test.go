@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+)
+
+func TestBriefSilentConnectionIsCountedButNotLogged(t *testing.T) {
+	set := metrics.NewSet("")
+	output, clientConn, finished := startLoggedConnection(t, startEchoBackend(t), set, time.Minute)
+	clientConn.Close()
+	waitForHandledConnection(t, finished)
+
+	if output.Len() != 0 {
+		t.Fatalf("brief silent connection logged %q", output.String())
+	}
+	var text bytes.Buffer
+	if err := set.WriteText(&text); err != nil {
+		t.Fatalf("WriteText returned error: %v", err)
+	}
+	counted := 0
+	for _, line := range strings.Split(text.String(), "\n") {
+		if strings.HasPrefix(line, "chicha_ip_proxy_flows_opened_total{") && strings.HasSuffix(line, "} 1") {
+			counted++
+		}
+		if strings.HasPrefix(line, "chicha_ip_proxy_flows_closed_total{") && strings.HasSuffix(line, `reason="client EOF"} 1`) {
+			counted++
+		}
+	}
+	if counted != 2 {
+		t.Fatalf("metrics do not count the unlogged connection:\n%s", text.String())
+	}
+}
+
+func TestConnectionThatMovedBytesIsLoggedDespiteMinimum(t *testing.T) {
+	output, clientConn, finished := startLoggedConnection(t, startEchoBackend(t), nil, time.Minute)
+	_ = clientConn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := clientConn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if _, err := clientConn.Read(make([]byte, 4)); err != nil {
+		t.Fatalf("Read returned error: %v", err)
+	}
+	clientConn.Close()
+	waitForHandledConnection(t, finished)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "New TCP connection: ") || !strings.HasPrefix(lines[1], "TCP connection closed: ") {
+		t.Fatalf("log = %q, want the open line before the close line", output.String())
+	}
+}
+
+func TestOpenLineIsWrittenOnceTheMinimumPasses(t *testing.T) {
+	line := startConnectionOpenLine(10 * time.Millisecond)
+	printed := make(chan struct{}, 2)
+	line.ready(func() { printed <- struct{}{} })
+
+	select {
+	case <-printed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("open line was not written after the minimum duration")
+	}
+	line.log()
+	if line.skip() {
+		t.Fatal("skip allowed a connection whose open line was already written")
+	}
+	if len(printed) != 0 {
+		t.Fatal("open line was written twice")
+	}
+}
+
+// startLoggedConnection serves one client with handleTCPConnection, logging into the returned buffer.
+// The buffer may only be read once finished is closed.
+func startLoggedConnection(t *testing.T, targetAddr string, set *metrics.Set, minLogDuration time.Duration) (*bytes.Buffer, net.Conn, <-chan struct{}) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var output bytes.Buffer
+	options := Options{MinConnectionLogDuration: minLogDuration}
+	options.stats = set.Route("tcp/8080", "tcp", listener.Addr().String(), targetAddr)
+	release := make(chan struct{}, 1)
+	release <- struct{}{}
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		handleTCPConnection(tcpConnJob{conn: conn, release: release}, listener.Addr().String(), targetAddr, log.New(&output, "", 0), options)
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	return &output, clientConn, finished
+}
+
+func waitForHandledConnection(t *testing.T, finished <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection was not closed")
+	}
+}
@@ -0,0 +1,78 @@
+//go:build linux
+// +build linux
+
+// Linux keeps the pre-NAT destination of a redirected TCP connection in conntrack and exposes it through SO_ORIGINAL_DST.
+// Reading it lets one listener serve every port an iptables REDIRECT or DNAT rule sends to it, like a transparent proxy.
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// OriginalDestinationSupported reports whether Options.OriginalDestination can work on this platform.
+const OriginalDestinationSupported = true
+
+// soOriginalDst is SO_ORIGINAL_DST for IPv4 and IP6T_SO_ORIGINAL_DST for IPv6; the syscall package names neither.
+const soOriginalDst = 80
+
+// originalDestination returns where the client was connecting before NAT rewrote the destination to this proxy.
+// A dual-stack socket answers IPv4 clients at the SOL_IP level too, so the client's family, not the socket's, picks the level.
+func originalDestination(conn net.Conn) (string, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return "", fmt.Errorf("original destination needs a plain TCP connection, got %T", conn)
+	}
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return "", fmt.Errorf("unexpected local address %v", conn.LocalAddr())
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	var destination string
+	var optErr error
+	err = raw.Control(func(fd uintptr) {
+		if local.IP.To4() != nil {
+			var sockaddr *syscall.IPv6Mreq
+			// IPv6Mreq is just large enough for the sockaddr_in the kernel writes, which makes it the usual carrier for this option.
+			sockaddr, optErr = syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst)
+			if optErr == nil {
+				destination = originalIPv4Destination(sockaddr.Multiaddr)
+			}
+			return
+		}
+		var info *syscall.IPv6MTUInfo
+		// IPv6MTUInfo starts with a sockaddr_in6, the structure IP6T_SO_ORIGINAL_DST fills.
+		info, optErr = syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, soOriginalDst)
+		if optErr == nil {
+			destination = originalIPv6Destination(info.Addr)
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if optErr != nil {
+		return "", fmt.Errorf("SO_ORIGINAL_DST: %v (is the connection redirected by iptables and nf_conntrack loaded?)", optErr)
+	}
+	return destination, nil
+}
+
+// originalIPv4Destination decodes a sockaddr_in: family, then port and address in network byte order.
+func originalIPv4Destination(sockaddr [16]byte) string {
+	port := binary.BigEndian.Uint16(sockaddr[2:4])
+	addr := netip.AddrFrom4([4]byte{sockaddr[4], sockaddr[5], sockaddr[6], sockaddr[7]})
+	return netip.AddrPortFrom(addr, port).String()
+}
+
+// originalIPv6Destination decodes a sockaddr_in6, whose port field holds network byte order in host memory.
+func originalIPv6Destination(sockaddr syscall.RawSockaddrInet6) string {
+	var port [2]byte
+	binary.NativeEndian.PutUint16(port[:], sockaddr.Port)
+	return netip.AddrPortFrom(netip.AddrFrom16(sockaddr.Addr), binary.BigEndian.Uint16(port[:])).String()
+}
@@ -0,0 +1,41 @@
+//go:build linux
+// +build linux
+
+package proxy
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestOriginalIPv4DestinationDecodesSockaddr(t *testing.T) {
+	var sockaddr [16]byte
+	binary.NativeEndian.PutUint16(sockaddr[0:2], syscall.AF_INET)
+	binary.BigEndian.PutUint16(sockaddr[2:4], 8443)
+	copy(sockaddr[4:8], []byte{203, 0, 113, 10})
+	if got := originalIPv4Destination(sockaddr); got != "203.0.113.10:8443" {
+		t.Fatalf("originalIPv4Destination = %s, want 203.0.113.10:8443", got)
+	}
+}
+
+func TestOriginalIPv6DestinationDecodesSockaddr(t *testing.T) {
+	sockaddr := syscall.RawSockaddrInet6{Family: syscall.AF_INET6}
+	var port [2]byte
+	binary.BigEndian.PutUint16(port[:], 443)
+	sockaddr.Port = binary.NativeEndian.Uint16(port[:])
+	copy(sockaddr.Addr[:], net.ParseIP("2001:db8::10").To16())
+	if got := originalIPv6Destination(sockaddr); got != "[2001:db8::10]:443" {
+		t.Fatalf("originalIPv6Destination = %s, want [2001:db8::10]:443", got)
+	}
+}
+
+func TestOriginalDestinationNeedsTCPConnection(t *testing.T) {
+	near, far := net.Pipe()
+	defer near.Close()
+	defer far.Close()
+	if _, err := originalDestination(near); err == nil {
+		t.Fatal("originalDestination accepted a connection without a socket")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+// Only Linux records the pre-NAT destination of a redirected connection where a socket option can read it.
+// Elsewhere the lookup fails outright, and main refuses -use-original-dst before any route starts.
+package proxy
+
+import (
+	"errors"
+	"net"
+)
+
+// OriginalDestinationSupported reports whether Options.OriginalDestination can work on this platform.
+const OriginalDestinationSupported = false
+
+func originalDestination(net.Conn) (string, error) {
+	return "", errors.New("SO_ORIGINAL_DST is only available on Linux")
+}
@@ -0,0 +1,67 @@
+// Peek-based features read the start of a connection before anything is forwarded, so the client decides how much is buffered and for how long.
+// peekPreface bounds both for all of them: the expected structure must fit in the peek buffer and arrive within the timeout, or the connection is closed.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultPeekBufferMax fits the largest TLS record, so the default never refuses a valid ClientHello.
+	DefaultPeekBufferMax = tlsRecordHeaderLen + tlsMaxRecordLen
+	// peekTimeout is how long a client may take to send the whole structure, counted from the first peek.
+	peekTimeout = 3 * time.Second
+)
+
+var (
+	errPeekTooLarge = errors.New("preface is larger than the peek buffer")
+	errPeekTimeout  = errors.New("preface was not complete in time")
+)
+
+// prefaceFraming reports how many bytes the structure at the start of buffered needs in total, as far as buffered shows.
+// ok is false once the bytes cannot be that structure, so the client is forwarded without it.
+type prefaceFraming func(buffered []byte) (need int, ok bool)
+
+// peekPreface reads exactly as many bytes as framing asks for, never more than limit and never past the timeout.
+// It returns every byte it consumed, because the caller replays them upstream, and whether they hold the complete structure.
+// A client that sends nothing is not an error, since server-first protocols stay silent until the backend speaks.
+func peekPreface(conn net.Conn, what string, limit int, timeout time.Duration, framing prefaceFraming) ([]byte, bool, error) {
+	if limit <= 0 {
+		limit = DefaultPeekBufferMax
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, false, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	var buffered []byte
+	for {
+		need, ok := framing(buffered)
+		if !ok || need <= len(buffered) {
+			return buffered, ok, nil
+		}
+		if need > limit {
+			return buffered, false, fmt.Errorf("%s needs %d bytes: %w (%d bytes)", what, need, errPeekTooLarge, limit)
+		}
+		if cap(buffered) < need {
+			buffered = append(make([]byte, 0, need), buffered...)
+		}
+		n, err := io.ReadFull(conn, buffered[len(buffered):need])
+		buffered = buffered[:len(buffered)+n]
+		if err == nil {
+			continue
+		}
+		if len(buffered) == 0 {
+			return nil, false, nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return buffered, false, fmt.Errorf("%s: %w after %s (%d of %d bytes)", what, errPeekTimeout, timeout, len(buffered), need)
+		}
+		return buffered, false, fmt.Errorf("%s was cut off after %d of %d bytes: %v", what, len(buffered), need, err)
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+func TestPeekServerNameRefusesRecordLargerThanTheBuffer(t *testing.T) {
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	defer proxySide.Close()
+
+	// The header announces a 1000-byte record; nothing past the header may be read into a 100-byte buffer.
+	go clientSide.Write([]byte{tlsHandshakeRecord, 0x03, 0x01, 0x03, 0xe8})
+
+	preface, serverName, err := peekServerName(proxySide, 100, time.Second)
+	if !errors.Is(err, errPeekTooLarge) {
+		t.Fatalf("peekServerName error = %v, want errPeekTooLarge", err)
+	}
+	if len(preface) != tlsRecordHeaderLen || serverName != "" {
+		t.Fatalf("peek = %x, %q, want only the record header", preface, serverName)
+	}
+}
+
+func TestPeekServerNameGivesUpOnIncompleteRecordAfterTimeout(t *testing.T) {
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	defer proxySide.Close()
+
+	// Ten of the announced hundred bytes arrive, then the client stalls.
+	go clientSide.Write(append([]byte{tlsHandshakeRecord, 0x03, 0x01, 0x00, 0x64}, make([]byte, 10)...))
+
+	started := time.Now()
+	preface, _, err := peekServerName(proxySide, DefaultPeekBufferMax, 50*time.Millisecond)
+	if !errors.Is(err, errPeekTimeout) {
+		t.Fatalf("peekServerName error = %v, want errPeekTimeout", err)
+	}
+	if len(preface) != tlsRecordHeaderLen+10 {
+		t.Fatalf("preface holds %d bytes, want %d", len(preface), tlsRecordHeaderLen+10)
+	}
+	if time.Since(started) > time.Second {
+		t.Fatal("peek was not bounded by the timeout")
+	}
+}
+
+func TestLogSNIClosesConnectionWhosePrefaceOutgrowsThePeekBuffer(t *testing.T) {
+	lines := make(logLines, 16)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer listener.Close()
+	go ServeTCPProxy(listener, startEchoBackend(t), config.AllowList{}, log.New(lines, "", 0), Options{LogSNI: true, PeekBufferMax: 64})
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{tlsHandshakeRecord, 0x03, 0x01, 0x01, 0x00}); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Read after an oversized preface returned %v, want EOF", err)
+	}
+	waitForLogLine(t, lines, "Closing TCP connection from "+conn.LocalAddr().String()+": TLS ClientHello needs 261 bytes: preface is larger than the peek buffer (64 bytes)\n")
+}
@@ -0,0 +1,29 @@
+// A peer that vanishes mid-stream surfaces as EPIPE or ECONNRESET on the next write or read, which on a busy proxy happens all the time.
+// Those errors close the flow with a reset reason instead of an error line, so the log keeps genuine I/O failures readable.
+package proxy
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isPeerReset reports whether err only says the other end went away: a broken pipe or a reset connection.
+func isPeerReset(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// streamResetReason names the peer whose socket reported the reset, the way streamEOFReason names the one that hung up.
+func streamResetReason(peer string) CloseReason {
+	if peer == "server" {
+		return CloseServerReset
+	}
+	return CloseClientReset
+}
+
+// otherPeer turns a copy direction into the peer it writes to: the client stream goes to the server and back.
+func otherPeer(direction string) string {
+	if direction == "server" {
+		return "client"
+	}
+	return "server"
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsPeerResetMatchesOnlyBrokenPipesAndResets(t *testing.T) {
+	wrap := func(err error) error {
+		return &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", err)}
+	}
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"broken pipe":        {wrap(syscall.EPIPE), true},
+		"connection reset":   {wrap(syscall.ECONNRESET), true},
+		"connection refused": {wrap(syscall.ECONNREFUSED), false},
+		"EOF":                {io.EOF, false},
+		"deadline":           {os.ErrDeadlineExceeded, false},
+		"other":              {errors.New("no space left on device"), false},
+	}
+	for name, test := range tests {
+		if got := isPeerReset(test.err); got != test.want {
+			t.Fatalf("%s: isPeerReset(%v) = %v, want %v", name, test.err, got, test.want)
+		}
+	}
+}
+
+// failingConn reads the queued payload once and then fails reads with readErr and every write with writeErr.
+type failingConn struct {
+	net.Conn
+	payload  []byte
+	readErr  error
+	writeErr error
+}
+
+func (conn *failingConn) Read(buffer []byte) (int, error) {
+	if len(conn.payload) > 0 {
+		n := copy(buffer, conn.payload)
+		conn.payload = conn.payload[n:]
+		return n, nil
+	}
+	return 0, conn.readErr
+}
+
+func (conn *failingConn) Write([]byte) (int, error) {
+	return 0, conn.writeErr
+}
+
+func (conn *failingConn) SetReadDeadline(time.Time) error  { return nil }
+func (conn *failingConn) SetWriteDeadline(time.Time) error { return nil }
+
+func TestCopyTCPStreamClassifiesPeerResetsWithoutLogging(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	brokenPipe := &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}
+	tests := map[string]struct {
+		src, dst   *failingConn
+		direction  string
+		wantReason CloseReason
+		wantLog    string
+	}{
+		"client resets":         {&failingConn{readErr: reset}, &failingConn{}, "client", CloseClientReset, ""},
+		"server resets":         {&failingConn{readErr: reset}, &failingConn{}, "server", CloseServerReset, ""},
+		"server pipe breaks":    {&failingConn{payload: []byte("x")}, &failingConn{writeErr: brokenPipe}, "client", CloseServerReset, ""},
+		"client pipe breaks":    {&failingConn{payload: []byte("x")}, &failingConn{writeErr: brokenPipe}, "server", CloseClientReset, ""},
+		"genuine write failure": {&failingConn{payload: []byte("x")}, &failingConn{writeErr: errors.New("no buffer space")}, "client", CloseError, "Error writing TCP client stream"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var output strings.Builder
+			var transferred atomic.Uint64
+			done := make(chan CloseReason, 1)
+			copyTCPStream(test.dst, test.src, test.direction, "198.51.100.7:40000", "10.0.0.1:80", 0, time.Minute, log.New(&output, "", 0), nil, &transferred, done)
+			if reason := <-done; reason != test.wantReason {
+				t.Fatalf("reason = %s, want %s", reason, test.wantReason)
+			}
+			if test.wantLog == "" && output.Len() > 0 || !strings.Contains(output.String(), test.wantLog) {
+				t.Fatalf("log = %q, want %q", output.String(), test.wantLog)
+			}
+		})
+	}
+}
+
+func TestForwardUDPPacketsEndsBridgedSessionOnResetWithoutLogging(t *testing.T) {
+	session := &udpSession{
+		clientAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40010},
+		remoteConn: &failingConn{writeErr: &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}},
+		outbound:   make(chan []byte, 1),
+		id:         "127.0.0.1:40010",
+	}
+	session.outbound <- []byte("payload")
+	close(session.outbound)
+
+	var output strings.Builder
+	events := make(chan sessionEvent, 1)
+	forwardUDPPackets(session, log.New(&output, "", 0), events, nil)
+	if event := <-events; event.reason != CloseServerReset {
+		t.Fatalf("close reason = %s, want %s", event.reason, CloseServerReset)
+	}
+	if output.Len() > 0 {
+		t.Fatalf("a broken backend pipe was logged: %q", output.String())
+	}
+}
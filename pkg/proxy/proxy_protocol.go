@@ -0,0 +1,185 @@
+// The PROXY protocol carries the real client address across a proxy hop, so backends behind chicha-ip-proxy can still tell clients apart.
+// Version 1 is one text line and version 2 a binary header; either comes before any payload, as HAProxy defines them.
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// proxyHeaderTimeout bounds the wait for an inbound header; a load balancer writes it with the connect, so only a stuck peer is slower.
+	proxyHeaderTimeout = 5 * time.Second
+	// maxProxyV1Header is the longest v1 line the specification allows, CRLF included.
+	maxProxyV1Header = 107
+)
+
+// proxyV2Signature opens every v2 header; it is also the shortest read that tells the versions apart, since every v1 line is longer.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyHeader is what an inbound header says about the connection.
+// Nil addresses mean a LOCAL or UNKNOWN header, for which the socket's own addresses stand.
+type proxyHeader struct {
+	source      *net.TCPAddr
+	destination *net.TCPAddr
+}
+
+// readProxyHeader consumes exactly one v1 or v2 header, so the first byte left on conn is the client's own.
+// The header is read through peekPreface, so a v2 header with long TLVs is held to limit like any other preface.
+func readProxyHeader(conn net.Conn, limit int, timeout time.Duration) (proxyHeader, error) {
+	header, complete, err := peekPreface(conn, "PROXY protocol header", limit, timeout, proxyHeaderFraming)
+	switch {
+	case err != nil:
+		return proxyHeader{}, err
+	case len(header) == 0:
+		return proxyHeader{}, errors.New("no PROXY protocol header; the client sent nothing")
+	case bytes.HasPrefix(header, proxyV2Signature) && complete:
+		return parseProxyV2(header[len(proxyV2Signature):])
+	case bytes.HasPrefix(header, []byte("PROXY ")) && complete:
+		return parseProxyV1(string(header[:len(header)-2]))
+	case bytes.HasPrefix(header, []byte("PROXY ")) && len(header) >= maxProxyV1Header:
+		return proxyHeader{}, fmt.Errorf("PROXY v1 header longer than %d bytes", maxProxyV1Header)
+	}
+	return proxyHeader{}, errors.New("no PROXY protocol header; the route has ;accept-proxy but the client spoke first")
+}
+
+// proxyHeaderFraming sizes a header from the bytes read so far. A v1 line is read one byte at a time up to its CRLF,
+// because reading ahead would swallow client bytes; a v2 header states its length after the signature.
+func proxyHeaderFraming(buffered []byte) (int, bool) {
+	switch {
+	case len(buffered) < len(proxyV2Signature):
+		return len(proxyV2Signature), true
+	case bytes.HasPrefix(buffered, proxyV2Signature):
+		if len(buffered) < len(proxyV2Signature)+4 {
+			return len(proxyV2Signature) + 4, true
+		}
+		return len(proxyV2Signature) + 4 + int(binary.BigEndian.Uint16(buffered[len(proxyV2Signature)+2:])), true
+	case bytes.HasPrefix(buffered, []byte("PROXY ")):
+		if bytes.HasSuffix(buffered, []byte("\r\n")) {
+			return len(buffered), true
+		}
+		return len(buffered) + 1, len(buffered) < maxProxyV1Header
+	}
+	return 0, false
+}
+
+// parseProxyV1 reads "PROXY TCP4|TCP6 SRC DST SRCPORT DSTPORT" or "PROXY UNKNOWN ...", without the CRLF.
+func parseProxyV1(line string) (proxyHeader, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) >= 2 && fields[0] == "PROXY" && fields[1] == "UNKNOWN" {
+		return proxyHeader{}, nil
+	}
+	if len(fields) != 6 || fields[0] != "PROXY" || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return proxyHeader{}, fmt.Errorf("malformed PROXY v1 header %q", line)
+	}
+	var addresses [2]*net.TCPAddr
+	for i := range addresses {
+		ip, err := netip.ParseAddr(fields[2+i])
+		if err != nil || ip.Zone() != "" || ip.Is4() != (fields[1] == "TCP4") {
+			return proxyHeader{}, fmt.Errorf("PROXY v1 header has a bad %s address %q", fields[1], fields[2+i])
+		}
+		port, err := strconv.ParseUint(fields[4+i], 10, 16)
+		if err != nil {
+			return proxyHeader{}, fmt.Errorf("PROXY v1 header has a bad port %q", fields[4+i])
+		}
+		addresses[i] = net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port)))
+	}
+	return proxyHeader{source: addresses[0], destination: addresses[1]}, nil
+}
+
+// parseProxyV2 reads a binary header after its signature; TLVs after the addresses are skipped, since nothing here uses them.
+func parseProxyV2(header []byte) (proxyHeader, error) {
+	fixed, body := header[:4], header[4:]
+	if version := fixed[0] >> 4; version != 2 {
+		return proxyHeader{}, fmt.Errorf("PROXY v2 header has version %d", version)
+	}
+	switch command := fixed[0] & 0x0f; command {
+	case 0x0:
+		// LOCAL is the balancer's own connection, such as a health check, so the socket addresses are the true ones.
+		return proxyHeader{}, nil
+	case 0x1:
+	default:
+		return proxyHeader{}, fmt.Errorf("PROXY v2 header has unknown command %d", command)
+	}
+
+	var size int
+	switch fixed[1] {
+	case 0x11: // TCP over IPv4
+		size = 4
+	case 0x21: // TCP over IPv6
+		size = 16
+	default:
+		// The specification lets a receiver ignore address families it does not serve, such as UNIX sockets.
+		return proxyHeader{}, nil
+	}
+	if len(body) < 2*size+4 {
+		return proxyHeader{}, fmt.Errorf("PROXY v2 header too short for its addresses: %d bytes", len(body))
+	}
+	source, _ := netip.AddrFromSlice(body[:size])
+	destination, _ := netip.AddrFromSlice(body[size : 2*size])
+	ports := body[2*size:]
+	return proxyHeader{
+		source:      net.TCPAddrFromAddrPort(netip.AddrPortFrom(source, binary.BigEndian.Uint16(ports))),
+		destination: net.TCPAddrFromAddrPort(netip.AddrPortFrom(destination, binary.BigEndian.Uint16(ports[2:]))),
+	}, nil
+}
+
+// proxyProtocolHeader builds the header sent ahead of a backend connection for the client at source, which reached destination.
+// IPv4 clients of a dual-stack socket are sent as IPv4, and a pair of mixed families is sent as IPv6, since one header holds one family.
+func proxyProtocolHeader(version int, source, destination net.Addr) []byte {
+	sourceAddr, sourceOK := tcpAddrPort(source)
+	destinationAddr, destinationOK := tcpAddrPort(destination)
+	known := sourceOK && destinationOK
+	sourceIP, destinationIP := sourceAddr.Addr(), destinationAddr.Addr()
+	if known && sourceIP.Is4() != destinationIP.Is4() {
+		sourceIP, destinationIP = netip.AddrFrom16(sourceIP.As16()), netip.AddrFrom16(destinationIP.As16())
+	}
+
+	if version == 1 {
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		family := "TCP6"
+		if sourceIP.Is4() {
+			family = "TCP4"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, sourceIP, destinationIP, sourceAddr.Port(), destinationAddr.Port()))
+	}
+
+	header := append([]byte{}, proxyV2Signature...)
+	// An unknown pair is still a PROXY command, with the UNSPEC family; LOCAL would tell the backend the connection is the proxy's own.
+	if !known {
+		return append(header, 0x21, 0x00, 0, 0)
+	}
+	if sourceIP.Is4() {
+		header = append(header, 0x21, 0x11, 0, 12)
+		source4, destination4 := sourceIP.As4(), destinationIP.As4()
+		header = append(append(header, source4[:]...), destination4[:]...)
+	} else {
+		header = append(header, 0x21, 0x21, 0, 36)
+		source16, destination16 := sourceIP.As16(), destinationIP.As16()
+		header = append(append(header, source16[:]...), destination16[:]...)
+	}
+	header = binary.BigEndian.AppendUint16(header, sourceAddr.Port())
+	return binary.BigEndian.AppendUint16(header, destinationAddr.Port())
+}
+
+// tcpAddrPort reads a TCP endpoint with IPv4-mapped addresses unmapped and any zone dropped, as the header has no room for one.
+func tcpAddrPort(addr net.Addr) (netip.AddrPort, bool) {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || tcpAddr == nil {
+		return netip.AddrPort{}, false
+	}
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(ip.Unmap(), uint16(tcpAddr.Port)), true
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+func TestProxyProtocolHeaderWritesBothVersions(t *testing.T) {
+	client := &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 40000}
+	reached := &net.TCPAddr{IP: net.ParseIP("::ffff:203.0.113.10"), Port: 80}
+
+	if got := string(proxyProtocolHeader(1, client, reached)); got != "PROXY TCP4 198.51.100.7 203.0.113.10 40000 80\r\n" {
+		t.Fatalf("v1 header = %q", got)
+	}
+	want := append(append([]byte{}, proxyV2Signature...), 0x21, 0x11, 0, 12, 198, 51, 100, 7, 203, 0, 113, 10, 0x9c, 0x40, 0, 80)
+	if got := proxyProtocolHeader(2, client, reached); !bytes.Equal(got, want) {
+		t.Fatalf("v2 header = %x, want %x", got, want)
+	}
+
+	// One header holds one family, so an IPv4 client that reached an IPv6 address is sent as IPv4-mapped IPv6.
+	mixed := &net.TCPAddr{IP: net.ParseIP("2001:db8::10"), Port: 443}
+	if got := string(proxyProtocolHeader(1, client, mixed)); got != "PROXY TCP6 ::ffff:198.51.100.7 2001:db8::10 40000 443\r\n" {
+		t.Fatalf("mixed v1 header = %q", got)
+	}
+	if got := string(proxyProtocolHeader(1, &net.UnixAddr{Name: "/run/x", Net: "unix"}, reached)); got != "PROXY UNKNOWN\r\n" {
+		t.Fatalf("v1 header for a non-TCP client = %q", got)
+	}
+	unspec := append(append([]byte{}, proxyV2Signature...), 0x21, 0x00, 0, 0)
+	if got := proxyProtocolHeader(2, &net.UnixAddr{Name: "/run/x", Net: "unix"}, reached); !bytes.Equal(got, unspec) {
+		t.Fatalf("v2 header for a non-TCP client = %x, want a PROXY command with the UNSPEC family %x", got, unspec)
+	}
+}
+
+func TestReadProxyHeaderLeavesClientBytesUnread(t *testing.T) {
+	client := &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51000}
+	reached := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+	for _, version := range []int{1, 2} {
+		server, peer := net.Pipe()
+		go func() {
+			peer.Write(append(proxyProtocolHeader(version, client, reached), "hello"...))
+			peer.Close()
+		}()
+
+		header, err := readProxyHeader(server, DefaultPeekBufferMax, time.Second)
+		if err != nil {
+			t.Fatalf("v%d: readProxyHeader returned error: %v", version, err)
+		}
+		if header.source.String() != client.String() || header.destination.String() != reached.String() {
+			t.Fatalf("v%d: header = %v -> %v, want %v -> %v", version, header.source, header.destination, client, reached)
+		}
+		rest, _ := io.ReadAll(server)
+		if string(rest) != "hello" {
+			t.Fatalf("v%d: client bytes after the header = %q, want hello", version, rest)
+		}
+		server.Close()
+	}
+}
+
+func TestReadProxyHeaderRejectsMalformedHeaders(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		want  string
+	}{
+		"client spoke first": {"GET / HTTP/1.1\r\n\r\n", "client spoke first"},
+		"wrong family":       {"PROXY TCP4 2001:db8::7 2001:db8::1 1 2\r\n", "bad TCP4 address"},
+		"bad port":           {"PROXY TCP4 198.51.100.7 203.0.113.10 70000 80\r\n", "bad port"},
+		"missing field":      {"PROXY TCP4 198.51.100.7 203.0.113.10 40000\r\n", "malformed PROXY v1 header"},
+		"endless line":       {"PROXY TCP4 " + strings.Repeat("1", 200), "longer than 107 bytes"},
+		"v2 wrong version":   {string(proxyV2Signature) + "\x11\x11\x00\x00", "version 1"},
+		"v2 short":           {string(proxyV2Signature) + "\x21\x11\x00\x04\x01\x02\x03\x04", "too short"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			server, peer := net.Pipe()
+			defer server.Close()
+			go func() {
+				peer.Write([]byte(test.input))
+				peer.Close()
+			}()
+			if _, err := readProxyHeader(server, DefaultPeekBufferMax, time.Second); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("readProxyHeader error = %v, want it to mention %q", err, test.want)
+			}
+		})
+	}
+
+	// LOCAL and UNKNOWN headers are valid and leave the socket's addresses in place.
+	for _, input := range []string{"PROXY UNKNOWN\r\n", string(proxyV2Signature) + "\x20\x00\x00\x00"} {
+		server, peer := net.Pipe()
+		go func() {
+			peer.Write([]byte(input))
+			peer.Close()
+		}()
+		header, err := readProxyHeader(server, DefaultPeekBufferMax, time.Second)
+		if err != nil || header.source != nil {
+			t.Fatalf("readProxyHeader(%q) = %+v, %v, want no addresses", input, header, err)
+		}
+		server.Close()
+	}
+}
+
+func TestProxyProtocolCarriesTheClientThroughTwoProxies(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	// The far proxy trusts the near one's v2 header and passes the same client on to the backend in v1.
+	farAddr := serveTCPForTest(t, backend.Addr().String(), Options{AcceptProxyProtocol: true, SendProxyProtocol: 1})
+	nearAddr := serveTCPForTest(t, farAddr, Options{SendProxyProtocol: 2})
+
+	conn, err := net.Dial("tcp", nearAddr)
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+
+	select {
+	case line := <-received:
+		// The header names the client's own port and the port it dialed on the near proxy, not the hop between the proxies.
+		_, clientPort, _ := net.SplitHostPort(conn.LocalAddr().String())
+		_, nearPort, _ := net.SplitHostPort(nearAddr)
+		if want := "PROXY TCP4 127.0.0.1 127.0.0.1 " + clientPort + " " + nearPort + "\r\n"; line != want {
+			t.Fatalf("backend read %q, want %q", line, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend received nothing")
+	}
+}
+
+func TestMissingProxyHeaderStillReportsTheClose(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer listener.Close()
+	reasons := make(chan CloseReason, 2)
+	shot := NewSingleShot()
+	go ServeTCPProxy(listener, startEchoBackend(t), config.AllowList{}, log.New(io.Discard, "", 0), Options{
+		AcceptProxyProtocol: true,
+		SingleShot:          shot,
+		Observer:            func(_ ConnectionInfo, reason CloseReason) { reasons <- reason },
+	})
+
+	// A client that speaks first is closed before any target is dialed, and its flow still ends the single shot.
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\n\r\n")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	select {
+	case reason := <-reasons:
+		if reason != CloseError {
+			t.Fatalf("close reason = %s, want %s", reason, CloseError)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the Observer did not hear about the closed connection")
+	}
+	select {
+	case <-shot.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Done was not closed after the header was refused")
+	}
+	select {
+	case reason := <-reasons:
+		t.Fatalf("the Observer heard a second close (%s)", reason)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
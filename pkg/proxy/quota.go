@@ -0,0 +1,56 @@
+// A route quota refuses new clients once the route has forwarded its byte allowance for the current window.
+// It reads the route's metrics byte counters instead of counting again, so the copy loops stay as they are.
+package proxy
+
+import (
+	"log"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+)
+
+// quotaTracker belongs to the goroutine that admits a route's new clients: the TCP accept loop or the UDP session manager.
+// A nil *quotaTracker admits everyone, which is the behavior without a quota.
+type quotaTracker struct {
+	quota       config.Quota
+	stats       *metrics.Route
+	listenAddr  string
+	windowStart time.Time
+	baseline    uint64 // baseline is stats.Bytes() when the window started; the counters themselves never reset.
+	exhausted   bool
+}
+
+// newQuotaTracker returns nil for a zero quota; the route's stats must be set, since they are what it reads.
+func newQuotaTracker(quota config.Quota, stats *metrics.Route, listenAddr string, now time.Time) *quotaTracker {
+	if quota.Bytes == 0 {
+		return nil
+	}
+	return &quotaTracker{quota: quota, stats: stats, listenAddr: listenAddr, windowStart: now.Truncate(quota.Window), baseline: stats.Bytes()}
+}
+
+// admit reports whether a new client may start at now, logging when the quota runs out and when a new window lifts it.
+// Windows are aligned to the Unix epoch, so 24h windows start at 00:00 UTC and 1h windows on the hour.
+// Clients already forwarding are never cut off; their bytes count toward the window they are sent in.
+func (tracker *quotaTracker) admit(now time.Time, logger *log.Logger) bool {
+	if tracker == nil {
+		return true
+	}
+	if start := now.Truncate(tracker.quota.Window); !start.Equal(tracker.windowStart) {
+		if tracker.exhausted {
+			logger.Printf("Quota window of %s started; accepting new clients again", tracker.listenAddr)
+		}
+		tracker.windowStart, tracker.baseline, tracker.exhausted = start, tracker.stats.Bytes(), false
+	}
+	used := tracker.stats.Bytes() - tracker.baseline
+	if used < tracker.quota.Bytes {
+		return true
+	}
+	if !tracker.exhausted {
+		tracker.exhausted = true
+		logger.Printf("Route %s used its quota of %d bytes (%d forwarded); refusing new clients until %s",
+			tracker.listenAddr, tracker.quota.Bytes, used, tracker.windowStart.Add(tracker.quota.Window).UTC().Format(time.RFC3339))
+	}
+	tracker.stats.Dropped(metrics.DropQuota)
+	return false
+}
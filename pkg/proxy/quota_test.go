@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+)
+
+func TestRouteQuotaRefusesNewClientsOnceUsedUp(t *testing.T) {
+	set := metrics.NewSet("")
+	backendAddr := startLineBackend(t, "pong\n")
+	proxyAddr := serveTCPForTest(t, backendAddr, Options{
+		Metrics: set,
+		Quota:   config.Quota{Bytes: 16, Window: 24 * time.Hour},
+	})
+
+	// Each exchange moves 10 bytes, so the first client may finish its second one past the quota while the next is refused.
+	first, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer first.Close()
+	_ = first.SetDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(first)
+	for i := 0; i < 2; i++ {
+		if _, err := first.Write([]byte("ping\n")); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+		if line, err := reader.ReadString('\n'); err != nil || line != "pong\n" {
+			t.Fatalf("exchange %d read %q, %v", i+1, line, err)
+		}
+	}
+
+	// The proxy counts a chunk just after writing it, so the reply can arrive a moment before its bytes are counted.
+	for deadline := time.Now().Add(2 * time.Second); set.Route("tcp/"+listenPort(proxyAddr), "tcp", proxyAddr, backendAddr).Bytes() < 20; {
+		if time.Now().After(deadline) {
+			t.Fatal("route byte counters did not reach the two exchanges")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	second, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer second.Close()
+	_ = second.SetDeadline(time.Now().Add(2 * time.Second))
+	second.Write([]byte("ping\n"))
+	if _, err := second.Read(make([]byte, 8)); err == nil {
+		t.Fatal("client past the route quota was served")
+	}
+
+	// The client that was already connected keeps forwarding.
+	if _, err := first.Write([]byte("ping\n")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if line, err := reader.ReadString('\n'); err != nil || line != "pong\n" {
+		t.Fatalf("open connection read %q, %v after the quota ran out", line, err)
+	}
+	var text strings.Builder
+	if err := set.WriteText(&text); err != nil {
+		t.Fatalf("WriteText returned error: %v", err)
+	}
+	if !strings.Contains(text.String(), `reason="quota"} 1`) {
+		t.Fatalf("metrics do not count the refused client:\n%s", text.String())
+	}
+}
+
+func TestQuotaTrackerResetsAtTheWindowBoundary(t *testing.T) {
+	stats := metrics.NewSet("").Route("tcp/8080", "tcp", "127.0.0.1:8080", "192.0.2.1:80")
+	stats.AddBytes("client", 100)
+	start := time.Date(2026, 3, 6, 10, 15, 0, 0, time.UTC)
+	lines := make(logLines, 8)
+	logger := log.New(lines, "", 0)
+	tracker := newQuotaTracker(config.Quota{Bytes: 50, Window: time.Hour}, stats, "127.0.0.1:8080", start)
+
+	// Bytes from before the route started do not count.
+	if !tracker.admit(start, logger) {
+		t.Fatal("tracker refused a client before the route forwarded anything")
+	}
+	stats.AddBytes("server", 50)
+	if tracker.admit(start.Add(time.Minute), logger) {
+		t.Fatal("tracker admitted a client with the quota used up")
+	}
+	waitForLogLine(t, lines, "Route 127.0.0.1:8080 used its quota of 50 bytes (50 forwarded); refusing new clients until 2026-03-06T11:00:00Z\n")
+	if tracker.admit(start.Add(44*time.Minute), logger) {
+		t.Fatal("tracker admitted a client before the window ended")
+	}
+	if !tracker.admit(start.Add(45*time.Minute), logger) {
+		t.Fatal("tracker refused a client in the next window")
+	}
+	waitForLogLine(t, lines, "Quota window of 127.0.0.1:8080 started; accepting new clients again\n")
+
+	if disabled := newQuotaTracker(config.Quota{}, stats, "127.0.0.1:8080", start); disabled != nil || !disabled.admit(start, logger) {
+		t.Fatal("a zero quota refused a client")
+	}
+}
@@ -0,0 +1,80 @@
+// Readiness tells an orchestrator when the proxy may receive traffic, which is later than when it starts.
+// The proxy is ready once its listeners are bound, every failover route has finished one probe round, and a warm-up delay has passed.
+package proxy
+
+import (
+	"log"
+	"time"
+)
+
+// Readiness turns ready once, after all of its startup conditions are met; it never turns unready again.
+type Readiness struct {
+	bound   chan struct{}
+	checked chan struct{}
+	ready   chan struct{}
+}
+
+// NewReadiness starts waiting for the listeners, for healthChecks first probe rounds, and for delay counted from now.
+func NewReadiness(delay time.Duration, healthChecks int, logger *log.Logger) *Readiness {
+	readiness := &Readiness{
+		bound: make(chan struct{}),
+		// The buffer holds every expected round, so reporting never blocks and rounds after readiness are dropped.
+		checked: make(chan struct{}, healthChecks),
+		ready:   make(chan struct{}),
+	}
+	go readiness.wait(time.Now().Add(delay), healthChecks, logger)
+	return readiness
+}
+
+func (readiness *Readiness) wait(notBefore time.Time, healthChecks int, logger *log.Logger) {
+	<-readiness.bound
+	for i := 0; i < healthChecks; i++ {
+		<-readiness.checked
+	}
+	if remaining := time.Until(notBefore); remaining > 0 {
+		time.Sleep(remaining)
+	}
+	close(readiness.ready)
+	logger.Printf("Proxy is ready: listeners bound, %d failover health check(s) done, readiness delay elapsed", healthChecks)
+}
+
+// Done closes once the proxy is ready; a nil Readiness is always ready.
+func (readiness *Readiness) Done() <-chan struct{} {
+	if readiness == nil {
+		ready := make(chan struct{})
+		close(ready)
+		return ready
+	}
+	return readiness.ready
+}
+
+// Bound records that every route's socket is open; main calls it once after binding them all.
+func (readiness *Readiness) Bound() {
+	if readiness != nil {
+		close(readiness.bound)
+	}
+}
+
+// Ready reports whether every startup condition has been met.
+func (readiness *Readiness) Ready() bool {
+	if readiness == nil {
+		return true
+	}
+	select {
+	case <-readiness.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// healthChecked records one failover route's first completed probe round.
+func (readiness *Readiness) healthChecked() {
+	if readiness == nil {
+		return
+	}
+	select {
+	case readiness.checked <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestReadinessWaitsForBindHealthChecksAndDelay(t *testing.T) {
+	readiness := NewReadiness(100*time.Millisecond, 1, log.New(io.Discard, "", 0))
+	started := time.Now()
+
+	readiness.healthChecked()
+	time.Sleep(150 * time.Millisecond)
+	if readiness.Ready() {
+		t.Fatal("Ready before the listeners were bound")
+	}
+
+	readiness.Bound()
+	deadline := time.Now().Add(2 * time.Second)
+	for !readiness.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("Ready stayed false after every condition was met")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond {
+		t.Fatalf("Ready after %s, want at least the 100ms delay", elapsed)
+	}
+
+	// Rounds reported after readiness, such as from a reloaded route, must not block.
+	readiness.healthChecked()
+	readiness.healthChecked()
+}
+
+func TestReadinessWaitsForEveryFirstHealthCheck(t *testing.T) {
+	readiness := NewReadiness(0, 2, log.New(io.Discard, "", 0))
+	readiness.Bound()
+	readiness.healthChecked()
+	time.Sleep(50 * time.Millisecond)
+	if readiness.Ready() {
+		t.Fatal("Ready with one of two failover routes still unchecked")
+	}
+	readiness.healthChecked()
+	deadline := time.Now().Add(2 * time.Second)
+	for !readiness.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("Ready stayed false after both health checks")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReadinessDoneClosesWhenReady(t *testing.T) {
+	var unset *Readiness
+	select {
+	case <-unset.Done():
+	default:
+		t.Fatal("Done of a nil Readiness is open, want it closed")
+	}
+
+	readiness := NewReadiness(0, 0, log.New(io.Discard, "", 0))
+	select {
+	case <-readiness.Done():
+		t.Fatal("Done closed before the listeners were bound")
+	default:
+	}
+	readiness.Bound()
+	select {
+	case <-readiness.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Done stayed open after the listeners were bound")
+	}
+}
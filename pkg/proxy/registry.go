@@ -0,0 +1,203 @@
+// Registry support lets operators inspect and close individual forwarded flows.
+// A single goroutine owns the connection table so TCP and UDP workers never share a mutex.
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ConnectionInfo describes one live TCP connection or UDP session for the admin API.
+type ConnectionInfo struct {
+	ID       string    `json:"id"`
+	Route    string    `json:"route"`
+	Protocol string    `json:"protocol"`
+	Client   string    `json:"client"`
+	Listen   string    `json:"listen"`
+	Target   string    `json:"target"`
+	Started  time.Time `json:"started"`
+	// The TLS fields stay empty on plain TCP; a passthrough route only knows the SNI it peeked.
+	SNI        string `json:"sni,omitempty"`
+	TLSVersion string `json:"tls_version,omitempty"`
+	TLSCipher  string `json:"tls_cipher,omitempty"`
+	ALPN       string `json:"alpn,omitempty"`
+}
+
+// Registry tracks live flows together with the handle that force-closes them.
+// A nil Registry is valid and ignores every call so tracking stays opt-in.
+type Registry struct {
+	requests chan registryRequest
+}
+
+type registryEntry struct {
+	info  ConnectionInfo
+	close func()
+}
+
+type registryRequest struct {
+	register   *registryEntry
+	unregister string
+	closeID    string
+	closeRoute string
+	list       bool
+	drain      bool
+	protocol   string // protocol limits a drain to one protocol's flows when set.
+	reply      chan registryReply
+}
+
+type registryReply struct {
+	id          string
+	connections []ConnectionInfo
+	closed      *ConnectionInfo
+	closers     []func()
+}
+
+// NewRegistry starts the goroutine that owns the connection table.
+func NewRegistry() *Registry {
+	registry := &Registry{requests: make(chan registryRequest)}
+	go registry.run()
+	return registry
+}
+
+func (registry *Registry) run() {
+	entries := make(map[string]registryEntry)
+	var sequence uint64
+
+	for request := range registry.requests {
+		switch {
+		case request.register != nil:
+			sequence++
+			entry := *request.register
+			entry.info.ID = fmt.Sprintf("%s-%d", entry.info.Protocol, sequence)
+			entries[entry.info.ID] = entry
+			request.reply <- registryReply{id: entry.info.ID}
+
+		case request.unregister != "":
+			delete(entries, request.unregister)
+
+		case request.closeID != "":
+			entry, ok := entries[request.closeID]
+			if !ok {
+				request.reply <- registryReply{}
+				continue
+			}
+			delete(entries, request.closeID)
+			// Closing outside the owner loop keeps a slow close from stalling other registry calls.
+			go entry.close()
+			info := entry.info
+			request.reply <- registryReply{closed: &info}
+
+		case request.closeRoute != "":
+			var closed []ConnectionInfo
+			for id, entry := range entries {
+				if entry.info.Route != request.closeRoute {
+					continue
+				}
+				delete(entries, id)
+				go entry.close()
+				closed = append(closed, entry.info)
+			}
+			sort.Slice(closed, func(i, j int) bool {
+				return closed[i].Started.Before(closed[j].Started)
+			})
+			request.reply <- registryReply{connections: closed}
+
+		case request.list:
+			connections := make([]ConnectionInfo, 0, len(entries))
+			for _, entry := range entries {
+				connections = append(connections, entry.info)
+			}
+			sort.Slice(connections, func(i, j int) bool {
+				return connections[i].Started.Before(connections[j].Started)
+			})
+			request.reply <- registryReply{connections: connections}
+
+		case request.drain:
+			closers := make([]func(), 0, len(entries))
+			for _, entry := range entries {
+				if request.protocol != "" && entry.info.Protocol != request.protocol {
+					continue
+				}
+				closers = append(closers, entry.close)
+			}
+			request.reply <- registryReply{closers: closers}
+		}
+	}
+}
+
+// Register records a live flow and returns its unique ID.
+// The close function must unblock every goroutine serving the flow.
+func (registry *Registry) Register(info ConnectionInfo, close func()) string {
+	if registry == nil {
+		return ""
+	}
+	reply := make(chan registryReply, 1)
+	registry.requests <- registryRequest{register: &registryEntry{info: info, close: close}, reply: reply}
+	return (<-reply).id
+}
+
+// Unregister forgets a flow after it finished on its own.
+func (registry *Registry) Unregister(id string) {
+	if registry == nil || id == "" {
+		return
+	}
+	registry.requests <- registryRequest{unregister: id}
+}
+
+// List returns a snapshot of live flows ordered by start time.
+func (registry *Registry) List() []ConnectionInfo {
+	if registry == nil {
+		return nil
+	}
+	reply := make(chan registryReply, 1)
+	registry.requests <- registryRequest{list: true, reply: reply}
+	return (<-reply).connections
+}
+
+// Close force-closes the flow with the given ID.
+// The boolean reports whether the ID was known so callers can answer 404 for stale IDs.
+func (registry *Registry) Close(id string) (ConnectionInfo, bool) {
+	if registry == nil || id == "" {
+		return ConnectionInfo{}, false
+	}
+	reply := make(chan registryReply, 1)
+	registry.requests <- registryRequest{closeID: id, reply: reply}
+	result := <-reply
+	if result.closed == nil {
+		return ConnectionInfo{}, false
+	}
+	return *result.closed, true
+}
+
+// CloseRoute force-closes every live flow of one route ID and returns them ordered by start time.
+// Listeners stay up, so the route keeps accepting new clients.
+func (registry *Registry) CloseRoute(route string) []ConnectionInfo {
+	if registry == nil || route == "" {
+		return nil
+	}
+	reply := make(chan registryReply, 1)
+	registry.requests <- registryRequest{closeRoute: route, reply: reply}
+	return (<-reply).connections
+}
+
+// Drain schedules every live flow to close after the grace period and returns how many were scheduled.
+// Listeners stay up, so clients reconnect right away and land on the current upstream choice.
+// Flows that finish on their own before the deadline are unaffected by the late close.
+func (registry *Registry) Drain(grace time.Duration) int {
+	if registry == nil {
+		return 0
+	}
+	closers := registry.closers("")
+	for _, closeFlow := range closers {
+		time.AfterFunc(grace, closeFlow)
+	}
+	return len(closers)
+}
+
+// closers returns the close handles of every live flow, or only of one protocol's flows when protocol is set.
+func (registry *Registry) closers(protocol string) []func() {
+	reply := make(chan registryReply, 1)
+	registry.requests <- registryRequest{drain: true, protocol: protocol, reply: reply}
+	return (<-reply).closers
+}
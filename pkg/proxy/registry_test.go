@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRegistryCloseUnblocksTCPConnection(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer listener.Close()
+
+	registry := NewRegistry()
+	release := make(chan struct{}, 1)
+	release <- struct{}{}
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		handleTCPConnection(tcpConnJob{conn: conn, release: release}, listener.Addr().String(), backend.Addr().String(), log.New(io.Discard, "", 0), Options{Registry: registry})
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer clientConn.Close()
+
+	connections := waitForConnections(t, registry, 1)
+	if _, ok := registry.Close(connections[0].ID); !ok {
+		t.Fatalf("Close(%q) reported unknown ID", connections[0].ID)
+	}
+
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handleTCPConnection did not return after Close")
+	}
+	if _, ok := registry.Close(connections[0].ID); ok {
+		t.Fatal("Close succeeded twice for the same ID")
+	}
+}
+
+func waitForConnections(t *testing.T, registry *Registry, want int) []ConnectionInfo {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if connections := registry.List(); len(connections) == want {
+			return connections
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("registry did not reach %d connections", want)
+	return nil
+}
+
+func TestRegistryDrainClosesEveryFlowAfterGrace(t *testing.T) {
+	registry := NewRegistry()
+	closed := make(chan string, 2)
+	registry.Register(ConnectionInfo{Protocol: "tcp", Started: time.Now()}, func() { closed <- "tcp" })
+	registry.Register(ConnectionInfo{Protocol: "udp", Started: time.Now()}, func() { closed <- "udp" })
+
+	started := time.Now()
+	if cycled := registry.Drain(50 * time.Millisecond); cycled != 2 {
+		t.Fatalf("Drain cycled %d flows, want 2", cycled)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-closed:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Drain did not close every flow")
+		}
+	}
+	if elapsed := time.Since(started); elapsed < 50*time.Millisecond {
+		t.Fatalf("flows closed after %v, before the grace period", elapsed)
+	}
+}
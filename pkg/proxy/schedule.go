@@ -0,0 +1,60 @@
+// Scheduled routes accept new clients only inside their time windows, for maintenance windows and business-hours services.
+// Outside them a TCP client is reset or handed a short maintenance reply, and a new UDP client is dropped or answered like any refused one.
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/schedule"
+)
+
+const (
+	// maxMaintenanceReplies bounds the replies in flight per route, so a flood outside the window costs resets, not goroutines.
+	maxMaintenanceReplies = 64
+	// maintenanceReplyTimeout bounds writing the reply and waiting for the client to hang up.
+	maintenanceReplyTimeout = 5 * time.Second
+	// maintenanceDrainLimit is how much of the client's request is read and discarded before the close.
+	maintenanceDrainLimit = 64 << 10
+)
+
+// describeSchedule names the schedule and, when the schedule leaves it out, the default zone it is evaluated in.
+func describeSchedule(routeSchedule *schedule.Schedule) string {
+	zone := routeSchedule.Location().String()
+	if strings.HasSuffix(routeSchedule.String(), " "+zone) {
+		return routeSchedule.String()
+	}
+	return routeSchedule.String() + " (" + zone + " time)"
+}
+
+// refuseOutsideSchedule resets conn, or writes reply and closes it cleanly when a reply is set and a slot is free.
+// The client's request is read and dropped before the close, because closing with unread data would reset the connection
+// and could discard the reply before the client reads it.
+func refuseOutsideSchedule(conn net.Conn, reply []byte, slots chan struct{}, logger *log.Logger) {
+	if len(reply) == 0 {
+		rejectTCPConnectionWithReset(conn, logger)
+		return
+	}
+	select {
+	case slots <- struct{}{}:
+	default:
+		rejectTCPConnectionWithReset(conn, logger)
+		return
+	}
+	go func() {
+		defer func() { <-slots }()
+		defer conn.Close()
+		// The read deadline bounds the drain below; the reply gets its own, like every other reply the proxy writes.
+		conn.SetReadDeadline(time.Now().Add(maintenanceReplyTimeout))
+		if err := writeFullWithDeadline(conn, reply, maintenanceReplyTimeout); err != nil {
+			return
+		}
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		}
+		io.Copy(io.Discard, io.LimitReader(conn, maintenanceDrainLimit))
+	}()
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+	"github.com/matveynator/chicha-ip-proxy/pkg/schedule"
+)
+
+// scheduleAround returns a daily UTC schedule from now+from to now+to, so a test can be inside or outside it whenever it runs.
+func scheduleAround(t *testing.T, from, to time.Duration) *schedule.Schedule {
+	t.Helper()
+	now := time.Now().UTC()
+	start, end := now.Add(from), now.Add(to)
+	parsed, err := schedule.Parse(fmt.Sprintf("%s-%s UTC", start.Format("15:04"), end.Format("15:04")), time.UTC)
+	if err != nil {
+		t.Fatalf("schedule.Parse returned error: %v", err)
+	}
+	return parsed
+}
+
+func TestScheduledTCPRouteServesInsideItsWindow(t *testing.T) {
+	proxyAddr := serveTCPForTest(t, startEchoServer(t), Options{Schedule: scheduleAround(t, -time.Hour, time.Hour)})
+	echoThroughProxy(t, proxyAddr)
+}
+
+func TestScheduledTCPRouteResetsClientsOutsideItsWindow(t *testing.T) {
+	set := metrics.NewSet("")
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	dialed := make(chan struct{}, 1)
+	go func() {
+		if conn, err := backend.Accept(); err == nil {
+			conn.Close()
+			dialed <- struct{}{}
+		}
+	}()
+	proxyAddr := serveTCPForTest(t, backend.Addr().String(), Options{Metrics: set, Schedule: scheduleAround(t, 2*time.Hour, 3*time.Hour)})
+
+	// On loopback the reset can arrive before Dial returns, which refuses the client just as well.
+	if conn, err := net.Dial("tcp", proxyAddr); err == nil {
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if n, err := conn.Read(make([]byte, 8)); err == nil || n > 0 {
+			t.Fatalf("client outside the schedule read %d bytes, err %v; want the connection refused", n, err)
+		}
+	}
+	select {
+	case <-dialed:
+		t.Fatal("the backend was dialed for a client outside the schedule")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if routes := set.Routes(); len(routes) != 1 || routes[0].Drops != 1 {
+		t.Fatalf("route counters = %+v, want one drop", routes)
+	}
+}
+
+func TestScheduledTCPRouteServesTheMaintenanceReplyOutsideItsWindow(t *testing.T) {
+	reply := "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"
+	proxyAddr := serveTCPForTest(t, startEchoServer(t), Options{
+		Schedule:         scheduleAround(t, 2*time.Hour, 3*time.Hour),
+		MaintenanceReply: []byte(reply),
+	})
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: example\r\n\r\n")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("ReadAll returned error: %v", err)
+	}
+	if string(got) != reply {
+		t.Fatalf("client read %q, want the maintenance reply", got)
+	}
+}
+
+func TestManageUDPSessionsStartsSessionsOnlyInsideTheSchedule(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer backend.Close()
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer responder.Close()
+
+	moscow, err := schedule.Parse("mon-fri 09:00-18:00 Europe/Moscow", time.UTC)
+	if err != nil {
+		t.Fatalf("schedule.Parse returned error: %v", err)
+	}
+	// 2026-03-06 is a Friday, and 06:00 UTC is 09:00 in Moscow.
+	opens := time.Date(2026, 3, 6, 6, 0, 0, 0, time.UTC)
+	clock := newFakeClock(opens.Add(-time.Second))
+	registry := NewRegistry()
+	set := metrics.NewSet("")
+	stats := set.Route("udp/5353", "udp", responder.LocalAddr().String(), backend.LocalAddr().String())
+	msgChan := make(chan udpMessage, 4)
+	managerDone := make(chan struct{})
+	defer func() {
+		close(msgChan)
+		<-managerDone
+	}()
+	go func() {
+		defer close(managerDone)
+		manageUDPSessions(responder.LocalAddr().String(), backend.LocalAddr().String(), responder, log.New(io.Discard, "", 0), msgChan, Options{
+			Registry: registry,
+			Schedule: moscow,
+			stats:    stats,
+		}, clock)
+	}()
+	waitForDrops := func(want uint64) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); set.Routes()[0].Drops != want; time.Sleep(5 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("drops = %d, want %d", set.Routes()[0].Drops, want)
+			}
+		}
+	}
+
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40010}
+	msgChan <- udpMessage{data: []byte("early"), addr: client}
+	waitForDrops(1)
+	clock.set(opens)
+	msgChan <- udpMessage{data: []byte("ping"), addr: client}
+	if connections := waitForConnections(t, registry, 1); !connections[0].Started.Equal(opens) {
+		t.Fatalf("session started at %v, want the opening minute %v", connections[0].Started, opens)
+	}
+
+	// At 18:00 Moscow time new clients are refused, while the session already open keeps forwarding.
+	clock.set(opens.Add(9 * time.Hour))
+	msgChan <- udpMessage{data: []byte("late"), addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40011}}
+	waitForDrops(2)
+	msgChan <- udpMessage{data: []byte("still"), addr: client}
+	buffer := make([]byte, 16)
+	_ = backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for _, want := range []string{"ping", "still"} {
+		n, _, err := backend.ReadFrom(buffer)
+		if err != nil || string(buffer[:n]) != want {
+			t.Fatalf("backend read %q, %v; want %q", buffer[:n], err, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Shutdown drains wait for one protocol's flows separately from the other's, so each can get its own deadline.
+// The registry already tracks every live flow, so a drain only watches that count fall and closes what is left.
+package proxy
+
+import "time"
+
+const (
+	drainPollInterval = 50 * time.Millisecond
+	drainCloseWait    = time.Second
+)
+
+// DrainResult reports how one protocol's flows ended during a shutdown drain.
+type DrainResult struct {
+	Protocol string
+	Finished int // Finished counts flows that ended on their own before the deadline.
+	Closed   int // Closed counts flows still open at the deadline, which the drain closed.
+	Elapsed  time.Duration
+}
+
+// DrainProtocol waits up to grace for the live flows of one protocol to end and then closes the rest.
+// A zero grace closes them at once, which is how a protocol is stopped without draining.
+func (registry *Registry) DrainProtocol(protocol string, grace time.Duration) DrainResult {
+	started := time.Now()
+	result := DrainResult{Protocol: protocol}
+	if registry == nil {
+		return result
+	}
+
+	initial := registry.count(protocol)
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	poll := time.NewTicker(drainPollInterval)
+	defer poll.Stop()
+	for waiting := initial > 0 && grace > 0; waiting; {
+		select {
+		case <-deadline.C:
+			waiting = false
+		case <-poll.C:
+			waiting = registry.count(protocol) > 0
+		}
+	}
+
+	closers := registry.closers(protocol)
+	for _, closeFlow := range closers {
+		// A UDP close waits on its route's session manager, so none of them may hold up the rest.
+		go closeFlow()
+	}
+	// Giving the closes a moment lets their close lines reach the log before the process exits.
+	closeDeadline := time.Now().Add(drainCloseWait)
+	for len(closers) > 0 && registry.count(protocol) > 0 && time.Now().Before(closeDeadline) {
+		time.Sleep(drainPollInterval)
+	}
+
+	result.Closed = len(closers)
+	if finished := initial - result.Closed; finished > 0 {
+		result.Finished = finished
+	}
+	result.Elapsed = time.Since(started)
+	return result
+}
+
+// count returns how many flows of one protocol are live.
+func (registry *Registry) count(protocol string) int {
+	live := 0
+	for _, info := range registry.List() {
+		if info.Protocol == protocol {
+			live++
+		}
+	}
+	return live
+}
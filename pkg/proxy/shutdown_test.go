@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDrainProtocolWaitsOnlyForItsOwnFlows(t *testing.T) {
+	registry := NewRegistry()
+	tcpID := registry.Register(ConnectionInfo{Protocol: "tcp", Started: time.Now()}, func() {})
+	udpClosed := make(chan struct{})
+	udpID := ""
+	udpID = registry.Register(ConnectionInfo{Protocol: "udp", Started: time.Now()}, func() {
+		close(udpClosed)
+		registry.Unregister(udpID)
+	})
+	time.AfterFunc(100*time.Millisecond, func() { registry.Unregister(tcpID) })
+
+	udp := registry.DrainProtocol("udp", 0)
+	if udp.Closed != 1 || udp.Finished != 0 {
+		t.Fatalf("udp drain = %+v, want one closed flow", udp)
+	}
+	select {
+	case <-udpClosed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("DrainProtocol did not close the UDP flow")
+	}
+
+	tcp := registry.DrainProtocol("tcp", 5*time.Second)
+	if tcp.Finished != 1 || tcp.Closed != 0 {
+		t.Fatalf("tcp drain = %+v, want one finished flow", tcp)
+	}
+	if tcp.Elapsed >= 5*time.Second {
+		t.Fatalf("tcp drain waited the whole grace period although the flow ended after 100ms")
+	}
+}
@@ -0,0 +1,50 @@
+// Single-shot mode serves exactly one TCP client across every route that shares a SingleShot, then reports it finished.
+// It suits inetd-style launchers, one-off port forwards, and tests that need the process to end on its own.
+package proxy
+
+// SingleShot hands its one pass to the first allowed TCP client of any route, so the first to accept wins.
+// Claimed and Done are closed once each, which lets any number of goroutines wait on them.
+type SingleShot struct {
+	pass     chan struct{}
+	claimed  chan struct{}
+	finished chan struct{}
+}
+
+// NewSingleShot returns a SingleShot whose pass is still available.
+func NewSingleShot() *SingleShot {
+	shot := &SingleShot{
+		pass:     make(chan struct{}, 1),
+		claimed:  make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	shot.pass <- struct{}{}
+	return shot
+}
+
+// Claimed is closed once a client has taken the pass; every later client is refused.
+func (shot *SingleShot) Claimed() <-chan struct{} {
+	return shot.claimed
+}
+
+// Done is closed once the client that took the pass has been fully proxied and closed.
+func (shot *SingleShot) Done() <-chan struct{} {
+	return shot.finished
+}
+
+// claim takes the pass without waiting; only the first caller ever gets true.
+func (shot *SingleShot) claim() bool {
+	select {
+	case <-shot.pass:
+		close(shot.claimed)
+		return true
+	default:
+		return false
+	}
+}
+
+// finish marks the claimed client as done; a nil SingleShot keeps the hook opt-in.
+func (shot *SingleShot) finish() {
+	if shot != nil {
+		close(shot.finished)
+	}
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+func TestSingleShotServesOnlyTheFirstClient(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer listener.Close()
+
+	shot := NewSingleShot()
+	go ServeTCPProxy(listener, startEchoBackend(t), config.AllowList{}, log.New(io.Discard, "", 0), Options{SingleShot: shot})
+
+	first, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer first.Close()
+	if _, err := first.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(first, reply); err != nil || string(reply) != "ping" {
+		t.Fatalf("first client read %q, %v; want echoed ping", reply, err)
+	}
+
+	second, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer second.Close()
+	_ = second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := second.Read(make([]byte, 1)); err == nil {
+		t.Fatal("second client was served, want it refused")
+	}
+
+	select {
+	case <-shot.Done():
+		t.Fatal("Done closed while the first client was still connected")
+	default:
+	}
+	first.Close()
+	select {
+	case <-shot.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Done was not closed after the first client disconnected")
+	}
+}
@@ -0,0 +1,119 @@
+// SNI peeking reads the TLS ClientHello in passthrough mode so logs can name the requested host.
+// Every byte consumed here is replayed to the backend, so forwarding stays byte-for-byte identical.
+package proxy
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+const (
+	tlsRecordHeaderLen   = 5
+	tlsMaxRecordLen      = 16 * 1024
+	tlsHandshakeRecord   = 0x16
+	tlsClientHelloType   = 0x01
+	tlsServerNameExt     = 0x0000
+	tlsServerNameHostDNS = 0x00
+)
+
+// peekServerName reads at most one TLS record within the peek bounds and returns the consumed bytes plus the SNI host, if any.
+// Non-TLS clients and clients that stay silent past the timeout simply yield no name; a record that breaks the bounds is an error.
+func peekServerName(conn net.Conn, limit int, timeout time.Duration) ([]byte, string, error) {
+	preface, complete, err := peekPreface(conn, "TLS ClientHello", limit, timeout, tlsRecordFraming)
+	if err != nil || !complete {
+		return preface, "", err
+	}
+	return preface, parseClientHelloServerName(preface[tlsRecordHeaderLen:]), nil
+}
+
+// tlsRecordFraming asks for the record header first and then for the length it announces.
+func tlsRecordFraming(buffered []byte) (int, bool) {
+	if len(buffered) < tlsRecordHeaderLen {
+		return tlsRecordHeaderLen, true
+	}
+	recordLen := int(binary.BigEndian.Uint16(buffered[3:5]))
+	if buffered[0] != tlsHandshakeRecord || recordLen > tlsMaxRecordLen {
+		return 0, false
+	}
+	return tlsRecordHeaderLen + recordLen, true
+}
+
+// parseClientHelloServerName walks the ClientHello structure from RFC 8446 section 4.1.2.
+// Any truncation or malformed length returns an empty name instead of an error because SNI is only informative here.
+func parseClientHelloServerName(handshake []byte) string {
+	reader := byteReader{data: handshake}
+	if reader.uint8() != tlsClientHelloType {
+		return ""
+	}
+	reader.skip(3)                    // handshake length
+	reader.skip(2)                    // legacy_version
+	reader.skip(32)                   // random
+	reader.skip(int(reader.uint8()))  // legacy_session_id
+	reader.skip(int(reader.uint16())) // cipher_suites
+	reader.skip(int(reader.uint8()))  // legacy_compression_methods
+
+	extensions := byteReader{data: reader.bytes(int(reader.uint16()))}
+	for !extensions.failed && len(extensions.data) > 0 {
+		extensionType := extensions.uint16()
+		extension := byteReader{data: extensions.bytes(int(extensions.uint16()))}
+		if extensionType != tlsServerNameExt {
+			continue
+		}
+
+		names := byteReader{data: extension.bytes(int(extension.uint16()))}
+		for !names.failed && len(names.data) > 0 {
+			nameType := names.uint8()
+			name := names.bytes(int(names.uint16()))
+			if nameType == tlsServerNameHostDNS && !names.failed {
+				return string(name)
+			}
+		}
+	}
+	return ""
+}
+
+// byteReader consumes big-endian fields and latches the first out-of-bounds access.
+type byteReader struct {
+	data   []byte
+	failed bool
+}
+
+func (reader *byteReader) bytes(n int) []byte {
+	if reader.failed || n > len(reader.data) {
+		reader.failed = true
+		reader.data = nil
+		return nil
+	}
+	value := reader.data[:n]
+	reader.data = reader.data[n:]
+	return value
+}
+
+func (reader *byteReader) skip(n int) {
+	reader.bytes(n)
+}
+
+func (reader *byteReader) uint8() uint8 {
+	value := reader.bytes(1)
+	if value == nil {
+		return 0
+	}
+	return value[0]
+}
+
+func (reader *byteReader) uint16() uint16 {
+	value := reader.bytes(2)
+	if value == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(value)
+}
+
+// sniLogSuffix keeps plain TCP log lines unchanged when no name was seen.
+func sniLogSuffix(serverName string) string {
+	if serverName == "" {
+		return ""
+	}
+	return " sni=" + serverName
+}
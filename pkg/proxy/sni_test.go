@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPeekServerNameReadsClientHello(t *testing.T) {
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	defer proxySide.Close()
+
+	go func() {
+		tlsClient := tls.Client(clientSide, &tls.Config{ServerName: "app.example.com", InsecureSkipVerify: true})
+		tlsClient.Handshake()
+	}()
+
+	preface, serverName, err := peekServerName(proxySide, DefaultPeekBufferMax, time.Second)
+	if err != nil {
+		t.Fatalf("peekServerName returned error: %v", err)
+	}
+	if serverName != "app.example.com" {
+		t.Fatalf("serverName = %q, want app.example.com", serverName)
+	}
+	if len(preface) < tlsRecordHeaderLen || preface[0] != tlsHandshakeRecord {
+		t.Fatalf("preface does not hold the ClientHello record: %x", preface)
+	}
+}
+
+func TestPeekServerNameReturnsNonTLSBytesForReplay(t *testing.T) {
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	defer proxySide.Close()
+
+	go clientSide.Write([]byte("GET / HTTP/1.1\r\n"))
+
+	preface, serverName, err := peekServerName(proxySide, DefaultPeekBufferMax, time.Second)
+	if err != nil {
+		t.Fatalf("peekServerName returned error: %v", err)
+	}
+	if serverName != "" {
+		t.Fatalf("serverName = %q for plain HTTP", serverName)
+	}
+	if string(preface) != "GET /" {
+		t.Fatalf("preface = %q, want the bytes consumed by the peek", preface)
+	}
+}
+
+func TestPeekServerNameGivesUpOnSilentClient(t *testing.T) {
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	defer proxySide.Close()
+
+	started := time.Now()
+	preface, serverName, err := peekServerName(proxySide, DefaultPeekBufferMax, 50*time.Millisecond)
+	if err != nil || serverName != "" || len(preface) != 0 {
+		t.Fatalf("peek of silent client = %q, %q, %v", preface, serverName, err)
+	}
+	if time.Since(started) > time.Second {
+		t.Fatal("peek was not bounded by the timeout")
+	}
+}
+
+func TestParseClientHelloServerNameRejectsTruncatedInput(t *testing.T) {
+	if name := parseClientHelloServerName([]byte{tlsClientHelloType, 0, 0, 10, 3, 3}); name != "" {
+		t.Fatalf("truncated ClientHello produced %q", name)
+	}
+}
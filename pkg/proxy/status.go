@@ -0,0 +1,192 @@
+// Route status answers "why is this route not working?" without reading the log: listener state, target in use, and last upstream error.
+// Each route's record is swapped atomically by the workers; the table of routes belongs to one goroutine, like the metrics set.
+package proxy
+
+import (
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// RouteStatus is one route's state as the admin API reports it.
+type RouteStatus struct {
+	Route      string `json:"route"`
+	Protocol   string `json:"protocol"`
+	Listen     string `json:"listen"`
+	Target     string `json:"target"`
+	ListenerUp bool   `json:"listener_up"`
+	// CurrentTarget is where new flows go; it differs from Target while failover uses a backup.
+	CurrentTarget string `json:"current_target"`
+	// Healthy is whether CurrentTarget passed its last health check; it is absent on routes without backups or a synthetic check.
+	Healthy *bool `json:"healthy,omitempty"`
+	// Maintenance is true while the route's ;schedule= refuses new clients; it is rechecked as each new client arrives.
+	Maintenance bool       `json:"maintenance"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// StatusBoard is the table of routes started since launch; a route removed by a reload stays listed with its listener down.
+// A nil StatusBoard hands out nil route states, which keeps status tracking opt-in.
+type StatusBoard struct {
+	requests chan statusRequest
+}
+
+type statusRequest struct {
+	id, protocol, listen, target string
+	snapshot                     bool
+	reply                        chan statusReply
+}
+
+type statusReply struct {
+	state  *routeState
+	states []*routeState
+}
+
+// routeState holds the latest record of one route; every update swaps in a new copy, so readers never see half of one.
+type routeState struct {
+	current atomic.Pointer[RouteStatus]
+}
+
+// NewStatusBoard starts the goroutine that owns the route table.
+func NewStatusBoard() *StatusBoard {
+	board := &StatusBoard{requests: make(chan statusRequest)}
+	go board.run()
+	return board
+}
+
+func (board *StatusBoard) run() {
+	states := make(map[string]*routeState)
+	for request := range board.requests {
+		if request.snapshot {
+			list := make([]*routeState, 0, len(states))
+			for _, state := range states {
+				list = append(list, state)
+			}
+			request.reply <- statusReply{states: list}
+			continue
+		}
+
+		// The port, not the full listen address, names the route, so a failed bind on ":8080" and the later listener on "[::]:8080" share a record.
+		key := request.protocol + " " + listenPort(request.listen) + " " + request.target
+		state, ok := states[key]
+		if !ok {
+			state = &routeState{}
+			state.current.Store(&RouteStatus{Route: request.id, Protocol: request.protocol, Listen: request.listen, Target: request.target, CurrentTarget: request.target})
+			states[key] = state
+		}
+		request.reply <- statusReply{state: state}
+	}
+}
+
+// route returns the state of a route, creating it on first use; a route started again after a reload gets its old record back.
+func (board *StatusBoard) route(id, protocol, listen, target string) *routeState {
+	if board == nil {
+		return nil
+	}
+	reply := make(chan statusReply, 1)
+	board.requests <- statusRequest{id: id, protocol: protocol, listen: listen, target: target, reply: reply}
+	return (<-reply).state
+}
+
+// Routes returns a copy of every route's latest record, ordered by protocol and listen address.
+func (board *StatusBoard) Routes() []RouteStatus {
+	if board == nil {
+		return nil
+	}
+	reply := make(chan statusReply, 1)
+	board.requests <- statusRequest{snapshot: true, reply: reply}
+
+	states := (<-reply).states
+	routes := make([]RouteStatus, 0, len(states))
+	for _, state := range states {
+		routes = append(routes, *state.current.Load())
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Protocol != routes[j].Protocol {
+			return routes[i].Protocol < routes[j].Protocol
+		}
+		return routes[i].Listen < routes[j].Listen
+	})
+	return routes
+}
+
+// update applies change to a fresh copy of the record and swaps it in, retrying if another worker swapped first.
+func (state *routeState) update(change func(*RouteStatus)) {
+	if state == nil {
+		return
+	}
+	for {
+		old := state.current.Load()
+		next := *old
+		change(&next)
+		if state.current.CompareAndSwap(old, &next) {
+			return
+		}
+	}
+}
+
+// listening records the bound address and marks the listener up.
+func (state *routeState) listening(listen string) {
+	state.update(func(status *RouteStatus) {
+		status.Listen = listen
+		status.ListenerUp = true
+	})
+}
+
+// stopped marks the listener down once the route stops serving.
+func (state *routeState) stopped() {
+	state.update(func(status *RouteStatus) {
+		status.ListenerUp = false
+	})
+}
+
+// failed records an error from binding the route or reaching its backend.
+func (state *routeState) failed(err error) {
+	now := time.Now()
+	state.update(func(status *RouteStatus) {
+		status.LastError = err.Error()
+		status.LastErrorAt = &now
+	})
+}
+
+// checked records the latest health of the target in use, skipping the swap while it is unchanged as it usually is.
+func (state *routeState) checked(up bool) {
+	if state == nil {
+		return
+	}
+	if healthy := state.current.Load().Healthy; healthy != nil && *healthy == up {
+		return
+	}
+	state.update(func(status *RouteStatus) {
+		status.Healthy = &up
+	})
+}
+
+// maintenance records whether the schedule is refusing new clients; like checked, it skips the swap while nothing changed.
+func (state *routeState) maintenance(closed bool) {
+	if state == nil || state.current.Load().Maintenance == closed {
+		return
+	}
+	state.update(func(status *RouteStatus) {
+		status.Maintenance = closed
+	})
+}
+
+// using records where new flows are sent; the common case of no change costs one load.
+func (state *routeState) using(target string) {
+	if state == nil || state.current.Load().CurrentTarget == target {
+		return
+	}
+	state.update(func(status *RouteStatus) {
+		status.CurrentTarget = target
+	})
+}
+
+func listenPort(listen string) string {
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return listen
+	}
+	return port
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+	"github.com/matveynator/chicha-ip-proxy/pkg/schedule"
+)
+
+func TestStatusBoardRecordsListenerAndLastDialError(t *testing.T) {
+	board := NewStatusBoard()
+	targetAddr := closedTCPAddress(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	served := make(chan struct{})
+	go func() {
+		ServeTCPProxy(listener, targetAddr, config.AllowList{}, log.New(io.Discard, "", 0), Options{Status: board})
+		close(served)
+	}()
+
+	// The failed backend dial resets the client, on loopback sometimes before its own connect returns.
+	if conn, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+
+	status := waitForRouteStatus(t, board, func(status RouteStatus) bool { return status.LastError != "" })
+	if !status.ListenerUp || status.Listen != listener.Addr().String() || status.CurrentTarget != targetAddr {
+		t.Fatalf("status = %#v", status)
+	}
+	if !strings.Contains(status.LastError, "refused") || status.LastErrorAt == nil {
+		t.Fatalf("last error = %q at %v, want the refused dial", status.LastError, status.LastErrorAt)
+	}
+
+	listener.Close()
+	<-served
+	if routes := board.Routes(); len(routes) != 1 || routes[0].ListenerUp {
+		t.Fatalf("routes after close = %#v, want one route with its listener down", routes)
+	}
+}
+
+func TestStatusBoardKeepsBindFailureWithLaterListener(t *testing.T) {
+	occupied, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(occupied.Addr().String())
+	board := NewStatusBoard()
+	supervisor := NewSupervisor(config.AllowList{}, log.New(io.Discard, "", 0), func(config.Route) Options { return Options{Status: board} })
+	route := config.Route{LocalPort: port, RemoteIP: "127.0.0.1", RemotePort: "9"}
+
+	if _, err := supervisor.Apply([]config.Route{route}, nil); err == nil {
+		t.Fatal("Apply did not report a port that is already in use")
+	}
+	routes := board.Routes()
+	if len(routes) != 1 || routes[0].ListenerUp || !strings.Contains(routes[0].LastError, "in use") {
+		t.Fatalf("routes after bind failure = %#v", routes)
+	}
+
+	occupied.Close()
+	if _, err := supervisor.Apply([]config.Route{route}, nil); err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	defer supervisor.Stop("tcp")
+	status := waitForRouteStatus(t, board, func(status RouteStatus) bool { return status.ListenerUp })
+	if !strings.Contains(status.LastError, "in use") {
+		t.Fatalf("status = %#v, want the bind error kept on the same route", status)
+	}
+}
+
+func TestRouteStateTracksFailoverTarget(t *testing.T) {
+	board := NewStatusBoard()
+	state := board.route("tcp/8080", "tcp", "[::]:8080", "192.0.2.1:80")
+	state.using("192.0.2.2:80")
+	state.failed(errors.New("health check of 192.0.2.1:80 failed: connection refused"))
+
+	routes := board.Routes()
+	if len(routes) != 1 || routes[0].Target != "192.0.2.1:80" || routes[0].CurrentTarget != "192.0.2.2:80" {
+		t.Fatalf("routes = %#v", routes)
+	}
+
+	var missing *routeState
+	missing.using("192.0.2.2:80")
+	missing.failed(errors.New("ignored"))
+	if routes := (*StatusBoard)(nil).Routes(); routes != nil {
+		t.Fatalf("nil board routes = %#v", routes)
+	}
+}
+
+func TestStatusBoardShowsMaintenanceWhileTheScheduleIsClosed(t *testing.T) {
+	board := NewStatusBoard()
+	proxyAddr := serveTCPForTest(t, startEchoServer(t), Options{Status: board, Schedule: scheduleAround(t, 2*time.Hour, 3*time.Hour)})
+	if conn, err := net.Dial("tcp", proxyAddr); err == nil {
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	waitForRouteStatus(t, board, func(status RouteStatus) bool { return status.Maintenance })
+
+	// The UDP session path sets and clears it as the schedule closes and opens.
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer responder.Close()
+	window, err := schedule.Parse("06:00-18:00 UTC", time.UTC)
+	if err != nil {
+		t.Fatalf("schedule.Parse returned error: %v", err)
+	}
+	closed := time.Date(2026, 3, 6, 5, 0, 0, 0, time.UTC)
+	clock := newFakeClock(closed)
+	udpBoard := NewStatusBoard()
+	msgChan := make(chan udpMessage, 2)
+	managerDone := make(chan struct{})
+	defer func() {
+		close(msgChan)
+		<-managerDone
+	}()
+	go func() {
+		defer close(managerDone)
+		manageUDPSessions(responder.LocalAddr().String(), "127.0.0.1:9", responder, log.New(io.Discard, "", 0), msgChan, Options{
+			Registry: NewRegistry(),
+			Schedule: window,
+			state:    udpBoard.route("udp/5353", "udp", responder.LocalAddr().String(), "127.0.0.1:9"),
+		}, clock)
+	}()
+	waitForRouteStatus(t, udpBoard, func(status RouteStatus) bool { return status.Maintenance })
+	clock.set(closed.Add(time.Hour))
+	msgChan <- udpMessage{data: []byte("ping"), addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40020}}
+	waitForRouteStatus(t, udpBoard, func(status RouteStatus) bool { return !status.Maintenance })
+}
+
+func waitForRouteStatus(t *testing.T, board *StatusBoard, ready func(RouteStatus) bool) RouteStatus {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		routes := board.Routes()
+		if len(routes) == 1 && ready(routes[0]) {
+			return routes[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("routes = %#v", routes)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,180 @@
+// The supervisor starts and stops route listeners at runtime so config changes apply without a restart.
+// One goroutine owns the table of running routes, matching how the registry and UDP sessions are managed.
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+	"github.com/matveynator/chicha-ip-proxy/pkg/logging"
+)
+
+// Supervisor reconciles the running listeners with a desired set of TCP and UDP routes.
+type Supervisor struct {
+	requests chan supervisorRequest
+}
+
+type supervisorRequest struct {
+	tcpRoutes    []config.Route
+	udpRoutes    []config.Route
+	stopProtocol string
+	reply        chan supervisorReply
+}
+
+type supervisorReply struct {
+	changes []string
+	err     error
+}
+
+// runningRoute remembers what a listener serves and how to stop it.
+type runningRoute struct {
+	protocol string
+	route    config.Route
+	stop     func()
+}
+
+// NewSupervisor starts the goroutine that owns running routes.
+// optionsFor resolves per-route options so reloaded routes pick up the same defaults as startup routes.
+func NewSupervisor(allowList config.AllowList, logger *log.Logger, optionsFor func(config.Route) Options) *Supervisor {
+	supervisor := &Supervisor{requests: make(chan supervisorRequest)}
+	go supervisor.run(allowList, logger, optionsFor)
+	return supervisor
+}
+
+// Apply makes the running routes match the given lists and returns one line per change for logging.
+// Unchanged routes keep their listener, so their connections are untouched; a changed TCP route swaps
+// its listener while accepted connections finish against the old target. Routes that fail to bind are
+// reported in the error while every other change still applies.
+func (supervisor *Supervisor) Apply(tcpRoutes, udpRoutes []config.Route) ([]string, error) {
+	reply := make(chan supervisorReply, 1)
+	supervisor.requests <- supervisorRequest{tcpRoutes: tcpRoutes, udpRoutes: udpRoutes, reply: reply}
+	result := <-reply
+	return result.changes, result.err
+}
+
+// Stop closes every listener of one protocol and keeps later Apply calls from starting that protocol again.
+// Shutdown uses it so a config reload that races the drain cannot reopen ports.
+func (supervisor *Supervisor) Stop(protocol string) []string {
+	reply := make(chan supervisorReply, 1)
+	supervisor.requests <- supervisorRequest{stopProtocol: protocol, reply: reply}
+	return (<-reply).changes
+}
+
+func (supervisor *Supervisor) run(allowList config.AllowList, logger *log.Logger, optionsFor func(config.Route) Options) {
+	running := make(map[string]runningRoute)
+	stopped := make(map[string]bool)
+
+	for request := range supervisor.requests {
+		if request.stopProtocol != "" {
+			stopped[request.stopProtocol] = true
+			var changes []string
+			for key, current := range running {
+				if current.protocol == request.stopProtocol {
+					current.stop()
+					delete(running, key)
+					changes = append(changes, "- "+describeRoute(current))
+				}
+			}
+			sort.Strings(changes)
+			request.reply <- supervisorReply{changes: changes}
+			continue
+		}
+
+		desired := make(map[string]runningRoute)
+		for _, route := range request.tcpRoutes {
+			if !stopped["tcp"] {
+				desired[routeKey("tcp", route)] = runningRoute{protocol: "tcp", route: route}
+			}
+		}
+		for _, route := range request.udpRoutes {
+			if !stopped["udp"] {
+				desired[routeKey("udp", route)] = runningRoute{protocol: "udp", route: route}
+			}
+		}
+
+		var changes, failures []string
+		replaced := make(map[string]runningRoute)
+		// Stopping first frees ports that a changed route is about to bind again.
+		for key, current := range running {
+			next, keep := desired[key]
+			if keep && next.route == current.route {
+				continue
+			}
+			current.stop()
+			delete(running, key)
+			if keep {
+				replaced[key] = current
+			} else {
+				changes = append(changes, "- "+describeRoute(current))
+			}
+		}
+
+		for key, next := range desired {
+			if _, ok := running[key]; ok {
+				continue
+			}
+			stop, err := startRoute(next, allowList, logger, optionsFor(next.route))
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", describeRoute(next), err))
+				continue
+			}
+			next.stop = stop
+			running[key] = next
+			if previous, ok := replaced[key]; ok {
+				was := "options changed"
+				if previous.route.Targets() != next.route.Targets() {
+					was = "was " + previous.route.Targets()
+				}
+				changes = append(changes, fmt.Sprintf("~ %s (%s)", describeRoute(next), was))
+				continue
+			}
+			changes = append(changes, "+ "+describeRoute(next))
+		}
+
+		sort.Strings(changes)
+		var err error
+		if len(failures) > 0 {
+			sort.Strings(failures)
+			err = fmt.Errorf("failed to start %d route(s): %v", len(failures), failures)
+		}
+		request.reply <- supervisorReply{changes: changes, err: err}
+	}
+}
+
+// routeKey identifies a route by what it binds, so a new target on the same port counts as a change.
+func routeKey(protocol string, route config.Route) string {
+	return protocol + "/" + route.LocalPort
+}
+
+func describeRoute(running runningRoute) string {
+	return fmt.Sprintf("%s :%s -> %s", running.protocol, running.route.LocalPort, running.route.Targets())
+}
+
+// startRoute binds the route's port before serving so bind errors reach the caller instead of killing the process.
+func startRoute(running runningRoute, allowList config.AllowList, logger *log.Logger, options Options) (func(), error) {
+	listenAddr := net.JoinHostPort(options.ListenHost, running.route.LocalPort)
+	targetAddr := running.route.RemoteAddress()
+	options.RouteID = running.route.ID(running.protocol)
+	logger = logging.RouteLogger(logger, options.RouteID)
+
+	if running.protocol == "udp" {
+		conn, err := net.ListenPacket("udp", listenAddr)
+		if err != nil {
+			options.Status.route(options.RouteID, "udp", listenAddr, targetAddr).failed(err)
+			return nil, err
+		}
+		go ServeUDPProxy(conn, targetAddr, allowList, logger, options)
+		return func() { conn.Close() }, nil
+	}
+
+	listener, err := ListenTCP(listenAddr, options.TCPFastOpen)
+	if err != nil {
+		options.Status.route(options.RouteID, "tcp", listenAddr, targetAddr).failed(err)
+		return nil, err
+	}
+	go ServeTCPProxy(listener, targetAddr, allowList, logger, options)
+	return func() { listener.Close() }, nil
+}
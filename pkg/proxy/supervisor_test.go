@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+func TestSupervisorAppliesRouteChanges(t *testing.T) {
+	localPort := freeTCPPort(t)
+	route := config.Route{LocalPort: localPort, RemoteIP: "127.0.0.1", RemotePort: "9"}
+	supervisor := NewSupervisor(config.AllowList{}, log.New(io.Discard, "", 0), func(config.Route) Options { return Options{} })
+
+	changes, err := supervisor.Apply([]config.Route{route}, nil)
+	if err != nil || len(changes) != 1 || !strings.HasPrefix(changes[0], "+ tcp :"+localPort) {
+		t.Fatalf("initial Apply = %v, %v", changes, err)
+	}
+	waitForTCPListener(t, localPort, true)
+
+	changes, err = supervisor.Apply([]config.Route{route}, nil)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("unchanged Apply = %v, %v", changes, err)
+	}
+
+	moved := route
+	moved.RemotePort = "10"
+	changes, err = supervisor.Apply([]config.Route{moved}, nil)
+	if err != nil || len(changes) != 1 || !strings.Contains(changes[0], "was 127.0.0.1:9") {
+		t.Fatalf("changed Apply = %v, %v", changes, err)
+	}
+	waitForTCPListener(t, localPort, true)
+
+	changes, err = supervisor.Apply(nil, nil)
+	if err != nil || len(changes) != 1 || !strings.HasPrefix(changes[0], "- tcp :"+localPort) {
+		t.Fatalf("removing Apply = %v, %v", changes, err)
+	}
+	waitForTCPListener(t, localPort, false)
+}
+
+func TestSupervisorBindsListenHost(t *testing.T) {
+	board := NewStatusBoard()
+	route := config.Route{LocalPort: freeTCPPort(t), RemoteIP: "127.0.0.1", RemotePort: "9"}
+	supervisor := NewSupervisor(config.AllowList{}, log.New(io.Discard, "", 0), func(config.Route) Options {
+		return Options{ListenHost: "127.0.0.1", Status: board}
+	})
+	if _, err := supervisor.Apply([]config.Route{route}, nil); err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	defer supervisor.Stop("tcp")
+
+	status := waitForRouteStatus(t, board, func(status RouteStatus) bool { return status.ListenerUp })
+	if status.Listen != "127.0.0.1:"+route.LocalPort {
+		t.Fatalf("listener address = %s, want 127.0.0.1:%s", status.Listen, route.LocalPort)
+	}
+}
+
+func TestSupervisorReportsBindFailures(t *testing.T) {
+	occupied, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer occupied.Close()
+	_, port, _ := net.SplitHostPort(occupied.Addr().String())
+
+	supervisor := NewSupervisor(config.AllowList{}, log.New(io.Discard, "", 0), func(config.Route) Options { return Options{} })
+	if _, err := supervisor.Apply([]config.Route{{LocalPort: port, RemoteIP: "127.0.0.1", RemotePort: "9"}}, nil); err == nil {
+		t.Fatal("Apply did not report a port that is already in use")
+	}
+}
+
+func TestSupervisorStopKeepsProtocolDown(t *testing.T) {
+	localPort := freeTCPPort(t)
+	route := config.Route{LocalPort: localPort, RemoteIP: "127.0.0.1", RemotePort: "9"}
+	supervisor := NewSupervisor(config.AllowList{}, log.New(io.Discard, "", 0), func(config.Route) Options { return Options{} })
+	if _, err := supervisor.Apply([]config.Route{route}, nil); err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	waitForTCPListener(t, localPort, true)
+
+	if changes := supervisor.Stop("tcp"); len(changes) != 1 {
+		t.Fatalf("Stop = %v, want one removed route", changes)
+	}
+	waitForTCPListener(t, localPort, false)
+
+	// A reload racing the shutdown must not reopen the port.
+	if changes, err := supervisor.Apply([]config.Route{route}, nil); err != nil || len(changes) != 0 {
+		t.Fatalf("Apply after Stop = %v, %v", changes, err)
+	}
+}
+
+func freeTCPPort(t *testing.T) string {
+	t.Helper()
+
+	_, port, err := net.SplitHostPort(closedTCPAddress(t))
+	if err != nil {
+		t.Fatalf("net.SplitHostPort returned error: %v", err)
+	}
+	return port
+}
+
+// waitForTCPListener polls because listeners are closed and bound asynchronously to the caller.
+func waitForTCPListener(t *testing.T, port string, want bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+		if err == nil {
+			conn.Close()
+		}
+		if (err == nil) == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("listener on port %s: want listening=%v", port, want)
+}
@@ -0,0 +1,80 @@
+// Synthetic checks send a request down the same backend path a client's flow takes and look for the expected reply.
+// They catch backends that accept connections but no longer answer, which a bare connect probe reports as healthy.
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DefaultSyntheticCheckTimeout bounds one synthetic probe, from the dial to the expected reply, unless Options says otherwise.
+const DefaultSyntheticCheckTimeout = 5 * time.Second
+
+// syntheticReplyLimit caps how much of a reply is searched, so a backend streaming data cannot hold a probe open.
+const syntheticReplyLimit = 64 * 1024
+
+// SyntheticCheck is a request and the bytes its reply must contain; an empty Send waits for a greeting, as SMTP backends send.
+type SyntheticCheck struct {
+	Send   []byte
+	Expect []byte
+}
+
+// syntheticProbe returns the probe a route's health checker runs against each target.
+// It dials like a client flow does, through the egress pool, dial limiter, bridge, and compression, so breakage on any of them shows.
+func syntheticProbe(check SyntheticCheck, options Options) func(string) error {
+	timeout := options.SyntheticCheckTimeout
+	if timeout <= 0 {
+		timeout = DefaultSyntheticCheckTimeout
+	}
+	return func(address string) error {
+		deadline := time.Now().Add(timeout)
+		conn, err := dialStreamTarget(address, nil, options)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+		if options.CompressBackend {
+			if conn, err = startCompressedBackend(conn); err != nil {
+				return err
+			}
+		}
+		if len(check.Send) > 0 {
+			if _, err := conn.Write(check.Send); err != nil {
+				return fmt.Errorf("sending synthetic check: %v", err)
+			}
+		}
+		return expectReply(conn, check.Expect)
+	}
+}
+
+// expectReply reads until the reply contains expect; a timeout or close before that reports what did arrive.
+func expectReply(conn io.Reader, expect []byte) error {
+	var reply []byte
+	// A whole datagram fits, so a bridged UDP backend's reply is never cut short.
+	buffer := make([]byte, maxBridgeFrame)
+	for len(reply) < syntheticReplyLimit {
+		n, err := conn.Read(buffer)
+		reply = append(reply, buffer[:n]...)
+		if bytes.Contains(reply, expect) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("synthetic check expected %q, got %s: %v", expect, quotePrefix(reply), err)
+		}
+	}
+	return fmt.Errorf("synthetic check expected %q, got %s", expect, quotePrefix(reply))
+}
+
+// quotePrefix keeps log lines and /status readable when a backend answers with a large or binary reply.
+func quotePrefix(reply []byte) string {
+	const shown = 64
+	if len(reply) > shown {
+		return fmt.Sprintf("%q...", reply[:shown])
+	}
+	return fmt.Sprintf("%q", reply)
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+// startLineBackend answers every line it reads with reply, on as many connections as the probes open.
+func startLineBackend(t *testing.T, reply string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					if _, err := reader.ReadString('\n'); err != nil {
+						return
+					}
+					conn.Write([]byte(reply))
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestSyntheticProbeMatchesExpectedReply(t *testing.T) {
+	probe := syntheticProbe(SyntheticCheck{Send: []byte("PING\r\n"), Expect: []byte("PONG")}, Options{})
+	if err := probe(startLineBackend(t, "+PONG\r\n")); err != nil {
+		t.Fatalf("probe returned error: %v", err)
+	}
+}
+
+func TestSyntheticProbeReportsMismatch(t *testing.T) {
+	probe := syntheticProbe(SyntheticCheck{Send: []byte("PING\r\n"), Expect: []byte("PONG")}, Options{SyntheticCheckTimeout: 200 * time.Millisecond})
+	err := probe(startLineBackend(t, "-ERR loading\r\n"))
+	if err == nil || !strings.Contains(err.Error(), `expected "PONG"`) || !strings.Contains(err.Error(), "-ERR loading") {
+		t.Fatalf("probe error = %v, want the expected and received replies", err)
+	}
+}
+
+func TestSyntheticCheckMarksRouteUnhealthy(t *testing.T) {
+	board := NewStatusBoard()
+	target := startLineBackend(t, "-ERR loading\r\n")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer listener.Close()
+	go ServeTCPProxy(listener, target, config.AllowList{}, log.New(io.Discard, "", 0), Options{
+		Status:                board,
+		SyntheticCheck:        &SyntheticCheck{Send: []byte("PING\r\n"), Expect: []byte("PONG")},
+		SyntheticCheckTimeout: 200 * time.Millisecond,
+	})
+
+	status := waitForRouteStatus(t, board, func(status RouteStatus) bool { return status.Healthy != nil && !*status.Healthy })
+	if !strings.Contains(status.LastError, `expected "PONG"`) {
+		t.Fatalf("last error = %q, want the synthetic check mismatch", status.LastError)
+	}
+}
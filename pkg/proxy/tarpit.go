@@ -0,0 +1,42 @@
+// Tarpitting holds denied and shed TCP clients open without answering instead of resetting them at once.
+// Scanners that shrug off a reset have to wait out the full duration, while the proxy spends only an idle socket.
+package proxy
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+// MaxTarpittedConnectionsPerRoute bounds held sockets so a flood cannot turn the tarpit into a file descriptor leak.
+const MaxTarpittedConnectionsPerRoute = 256
+
+// tarpitOrReset holds conn for the tarpit duration when a tarpit slot is free and resets it right away otherwise.
+// Tarpit slots are separate from the route's connection limit, so held sockets never crowd out legitimate clients.
+func tarpitOrReset(conn net.Conn, duration time.Duration, slots chan struct{}, logger *log.Logger, finished func()) {
+	if duration <= 0 {
+		rejectTCPConnectionWithReset(conn, logger)
+		finished()
+		return
+	}
+
+	select {
+	case slots <- struct{}{}:
+	default:
+		logger.Printf("Tarpit full on %s; resetting %s immediately", conn.LocalAddr().String(), conn.RemoteAddr().String())
+		rejectTCPConnectionWithReset(conn, logger)
+		finished()
+		return
+	}
+
+	logger.Printf("Holding rejected TCP connection from %s in the tarpit for %s", conn.RemoteAddr().String(), duration)
+	go func() {
+		defer func() { <-slots }()
+		// Nothing is read or written, so the client gets no answer and the backend is never dialed.
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		<-timer.C
+		rejectTCPConnectionWithReset(conn, logger)
+		finished()
+	}()
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTarpitOrResetHoldsConnectionSilently(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	finished := make(chan struct{})
+	slots := make(chan struct{}, 1)
+	started := time.Now()
+	tarpitOrReset(serverConn, 150*time.Millisecond, slots, log.New(io.Discard, "", 0), func() { close(finished) })
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := clientConn.Read(make([]byte, 1))
+	if n != 0 || err != io.EOF {
+		t.Fatalf("client read = %d, %v; want EOF once the tarpit ends", n, err)
+	}
+	if held := time.Since(started); held < 100*time.Millisecond {
+		t.Fatalf("tarpit released the client after %s", held)
+	}
+	<-finished
+	if len(slots) != 0 {
+		t.Fatal("tarpit slot was not returned")
+	}
+}
+
+func TestTarpitOrResetResetsWhenTarpitIsFull(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	slots := make(chan struct{}, 1)
+	slots <- struct{}{}
+	finished := false
+	tarpitOrReset(serverConn, time.Hour, slots, log.New(io.Discard, "", 0), func() { finished = true })
+
+	if !finished {
+		t.Fatal("full tarpit did not finish the connection immediately")
+	}
+	_ = clientConn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := clientConn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("client read error = %v, want EOF", err)
+	}
+}
@@ -3,69 +3,363 @@
 package proxy
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/netip"
+	"os"
 	"runtime"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+	"github.com/matveynator/chicha-ip-proxy/pkg/logging"
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+	"github.com/matveynator/chicha-ip-proxy/pkg/rules"
+	"github.com/matveynator/chicha-ip-proxy/pkg/schedule"
 )
 
+// DefaultMaxTCPConnectionsPerRoute is how many TCP clients a route serves at once unless Options.MaxConnections says otherwise.
+const DefaultMaxTCPConnectionsPerRoute = 1024
+
+// DefaultTCPIdleTimeout closes a TCP connection once either direction has been silent this long.
+const DefaultTCPIdleTimeout = 5 * time.Minute
+
 const (
-	defaultMaxTCPConnectionsPerRoute = 1024
-	tcpDialTimeout                   = 10 * time.Second
-	tcpIdleTimeout                   = 5 * time.Minute
-	tcpWriteTimeout                  = 30 * time.Second
+	tcpDialTimeout      = 10 * time.Second
+	tcpWriteTimeout     = 30 * time.Second
+	tlsHandshakeTimeout = 10 * time.Second
 )
 
+// Options carries optional per-route hooks shared by the TCP and UDP workers.
+// The zero value keeps the plain forwarding behavior.
+type Options struct {
+	// RouteID names the route in metrics, status, and connection records; empty derives PROTOCOL/PORT from the listener.
+	RouteID   string
+	Registry  *Registry   // Registry exposes live flows to the admin API when set.
+	TLSConfig *tls.Config // TLSConfig terminates client TLS before forwarding plaintext when set.
+	// HandshakeTimeout requires the client's first bytes, and then the backend's first reply, within this window.
+	// Zero disables the check because server-speaks-first protocols would otherwise be cut off.
+	HandshakeTimeout time.Duration
+	// ServerFirst marks a backend that greets before the client speaks; the client preface wait is skipped for it.
+	ServerFirst bool
+	// BackendFirstByteTimeout closes a ServerFirst connection when the backend sends nothing this long after the dial; zero disables it.
+	// It catches backends that accept connections but never greet.
+	BackendFirstByteTimeout time.Duration
+	// OriginalDestination forwards each TCP connection to where it was headed before an iptables REDIRECT or DNAT, instead of the route target.
+	// Only Linux supports it; clients that were not redirected still go to the route target.
+	OriginalDestination bool
+	// AcceptProxyProtocol reads a PROXY protocol header of either version from each client before anything else
+	// and treats the address it names as the client; the allow list and connection limits still see the connecting peer.
+	AcceptProxyProtocol bool
+	// SendProxyProtocol writes a PROXY protocol header of this version, 1 or 2, to each backend connection first; zero sends none.
+	SendProxyProtocol int
+	// CompressBackend deflates the stream to the backend, which must be a paired chicha-ip-proxy with CompressClients set.
+	CompressBackend bool
+	// CompressClients inflates streams from clients, which must be a paired chicha-ip-proxy with CompressBackend set.
+	CompressClients bool
+	// TargetUDP bridges a TCP route to a UDP backend: each length-prefixed client frame becomes one datagram and each reply one frame.
+	TargetUDP bool
+	// TargetTCP bridges a UDP route to a TCP backend: each client datagram becomes one length-prefixed frame and each reply frame one datagram.
+	TargetTCP bool
+	// LogSNI peeks the TLS ClientHello in passthrough mode and adds the requested server name to the connection log.
+	LogSNI bool
+	// PeekBufferMax caps the bytes a peek-based feature such as LogSNI buffers before forwarding; zero means DefaultPeekBufferMax.
+	PeekBufferMax int
+	// MinConnectionLogDuration leaves out the open and close lines of TCP connections that end sooner than this without moving a byte.
+	// Port scans and bare connect probes look like that; metrics still count them. Zero logs every connection.
+	MinConnectionLogDuration time.Duration
+	// Observer receives the close reason of every flow, including shed ones, when set.
+	Observer Observer
+	// EgressPool picks the source IP of backend TCP dials when set; otherwise the kernel chooses.
+	EgressPool *EgressPool
+	// UDPEgressPool picks the source IP of each backend UDP socket when set; a session keeps its IP until it closes.
+	UDPEgressPool *EgressPool
+	// DialLimiter queues backend TCP dials beyond its per-backend limit when set; queued dials still end at the dial timeout.
+	DialLimiter *DialLimiter
+	// AccessLog receives a Combined Log Format line per HTTP/1.x request when set; only HTTP routes should set it.
+	AccessLog *log.Logger
+	// ForwardedFor adds X-Forwarded-For and X-Forwarded-Proto to every HTTP/1.x request; only HTTP routes should set it.
+	ForwardedFor bool
+	// TarpitDuration holds denied and shed TCP clients silently for this long before resetting them; zero resets at once.
+	TarpitDuration time.Duration
+	// ClientIdleTimeout closes a TCP connection when the client sends nothing for this long; zero means DefaultTCPIdleTimeout.
+	ClientIdleTimeout time.Duration
+	// ServerIdleTimeout closes a TCP connection when the backend sends nothing for this long; zero means DefaultTCPIdleTimeout.
+	// Separate thresholds keep push-style backends with quiet clients, or the reverse, from being cut off.
+	ServerIdleTimeout time.Duration
+	// TCPUserTimeout drops a TCP connection, on the client or backend side, once data it sent has gone unacknowledged this long.
+	// Only Linux supports it (TCPUserTimeoutSupported); zero leaves the kernel default.
+	TCPUserTimeout time.Duration
+	// TCPFastOpen enables TCP Fast Open on listeners bound by the proxy and on backend dials.
+	// Only Linux supports it (TCPFastOpenSupported), and only where net.ipv4.tcp_fastopen allows each side.
+	TCPFastOpen bool
+	// NUMANodes lists the CPUs of each NUMA node, from NUMANodes; each node then gets its own TCP accept loop and connection workers,
+	// pinned to its CPUs. Goroutines a connection starts may still run anywhere, so it is best effort. Nil keeps one unpinned accept loop.
+	NUMANodes [][]int
+	// MaxConnections caps concurrent TCP clients per route; zero means DefaultMaxTCPConnectionsPerRoute.
+	MaxConnections int
+	// MaxConnectionsPerIP caps concurrent TCP connections from one client IP on each route; zero means no per-IP cap.
+	MaxConnectionsPerIP int
+	// UDPDialRetries redials a UDP backend this many times before a new client's queued packets are dropped; zero drops at once.
+	UDPDialRetries int
+	// UDPReject answers a new UDP client whose first packets are dropped, by policy or for lack of capacity; nil drops silently.
+	UDPReject *UDPRejecter
+	// UDPSessionRate caps new UDP sessions per second across all routes; packets of clients over the rate are dropped before any dial.
+	UDPSessionRate *UDPSessionRate
+	// EgressPolicy refuses backend dials whose kernel route leaves through the wrong interface; nil dials without a route lookup.
+	EgressPolicy *EgressPolicy
+	// UDPPMTUD sets the don't-fragment bit on UDP backend sockets, so datagrams above the path MTU are logged and dropped instead of fragmented.
+	// Only Linux supports it (UDPPMTUDSupported).
+	UDPPMTUD bool
+	// UDPBatchReplies sends up to this many replies of one UDP session with a single sendmmsg call, waiting at most
+	// udpReplyBatchWindow for a batch to fill. Only Linux supports it (UDPBatchRepliesSupported); zero or one sends each reply on its own.
+	UDPBatchReplies int
+	// UDPBatchRecv reads up to this many client datagrams of a UDP route with a single recvmmsg call, each slot a 64 KiB buffer.
+	// Only Linux supports it (UDPBatchRecvSupported); zero or one reads each datagram with its own ReadFrom.
+	UDPBatchRecv int
+	// UDPDialBackoff is the first UDP redial delay, doubled per retry; zero means DefaultUDPDialBackoff.
+	UDPDialBackoff time.Duration
+	// UDPResolveInterval re-resolves a hostname UDP target this often and moves live sessions to a changed address; zero never does.
+	UDPResolveInterval time.Duration
+	// UDPMaxSessionLifetime closes a UDP session this long after it started even while its client keeps sending; zero never does.
+	// The client's next packet opens a fresh session with a new backend socket.
+	UDPMaxSessionLifetime time.Duration
+	// Metrics counts bytes, flows, and drops per route when set.
+	// Quota needs it too: the byte counters it keeps are what a quota reads.
+	Metrics *metrics.Set
+	// Quota refuses new TCP clients and UDP sessions once the route forwarded this many bytes, both directions together, in the current window.
+	// Open flows keep running. Counting lives in memory only, so a restart, or a reload that restarts the route, counts the current window from zero.
+	Quota config.Quota
+	// MemoryGuard holds back new TCP clients and drops packets of new UDP clients while the process is over its memory high-water mark.
+	MemoryGuard *MemoryGuard
+	// Balance lists more targets that take new TCP connections and UDP sessions in turn with the route target, round-robin.
+	// They are health checked like Backups, and one found down sits out its turns until it passes a probe again; rule upstreams still win over them.
+	Balance []string
+	// Backups are standby targets in priority order; new connections and UDP sessions use the first healthy one
+	// once the route target, and any Balance targets, are down.
+	Backups []string
+	// FailoverRedial moves a connection whose chosen target fails before the live copy starts to the route's next target,
+	// replaying the client bytes already read for peeking or the handshake timeout, so the new upstream sees the whole stream.
+	FailoverRedial bool
+	// HealthCheckInterval is how often the primary, Balance, and Backups are probed; zero means DefaultHealthCheckInterval.
+	HealthCheckInterval time.Duration
+	// SyntheticCheck replaces the connect probe of the route's targets with a request and expected reply when set.
+	// A route with no Backups is still checked, so its health shows in Status even with nowhere to fail over to.
+	SyntheticCheck *SyntheticCheck
+	// Rules send TCP clients whose IP, port, or connect time match to another upstream, first match first; the rest use the route target.
+	// Rule upstreams are not health checked, so Backups only stand in for the route target.
+	Rules []rules.Rule
+	// Schedule admits new TCP clients and UDP sessions only inside its time windows; nil serves at all times.
+	// Flows already open when a window closes keep running, like they do when a quota runs out.
+	Schedule *schedule.Schedule
+	// SessionState records the upstream of each UDP session and hands restored mappings back to returning clients, when set.
+	SessionState *UDPSessionState
+	// MaintenanceReply is written to TCP clients refused outside Schedule before their connection closes, such as an HTTP 503 page; empty resets them.
+	MaintenanceReply []byte
+	// SyntheticCheckTimeout bounds one synthetic probe from dial to reply; zero means DefaultSyntheticCheckTimeout.
+	SyntheticCheckTimeout time.Duration
+	// PoolEmptyPolicy is PoolEmptyRetryAny or PoolEmptyReject, for when every target of a health-checked route is down; empty means PoolEmptyRetryAny.
+	// Retrying keeps a wrong health check from black-holing all traffic, while rejecting lets clients fail over on their own.
+	PoolEmptyPolicy string
+	// HealthLogProbes logs every health probe result; otherwise only targets going down or coming back up are logged.
+	HealthLogProbes bool
+	// Readiness learns when each health-checked route has finished its first probe round, when set.
+	Readiness *Readiness
+	// ClientFamily serves only "ipv4" or only "ipv6" clients; empty or "any" serves both.
+	ClientFamily string
+	// Status keeps each route's listener state, target in use, and last upstream error for the admin API when set.
+	Status *StatusBoard
+	// ListenHost is the local IP that routes started by a Supervisor bind; empty binds every interface.
+	ListenHost string
+	// LogLimiter coalesces repeated per-connection and per-packet errors, such as failed dials to a flapping backend, when set.
+	LogLimiter *logging.RateLimiter
+	// SingleShot lets only the first allowed TCP client through, across every route sharing it, when set.
+	SingleShot *SingleShot
+
+	// stats is this route's share of Metrics, resolved once when the route starts serving.
+	stats *metrics.Route
+	// state is this route's record on Status, resolved once like stats.
+	state *routeState
+	// perIP counts each client IP's open connections on this route when MaxConnectionsPerIP is set.
+	perIP *clientLimiter
+	// failover tracks target health, and takes balanced targets in turn, for routes with Balance, Backups, or a SyntheticCheck while the listener is open.
+	failover *failover
+}
+
 type tcpConnJob struct {
-	conn    net.Conn
-	release <-chan struct{}
+	conn     net.Conn
+	clientIP netip.Addr
+	release  <-chan struct{}
 }
 
 // StartTCPProxy listens on the provided address and forwards connections to the target.
 // Using a channel for accepted connections keeps synchronization explicit without mutexes.
-func StartTCPProxy(listenAddr, targetAddr string, allowList config.AllowList, logger *log.Logger) {
-	listener, err := net.Listen("tcp", listenAddr)
+func StartTCPProxy(listenAddr, targetAddr string, allowList config.AllowList, logger *log.Logger, options Options) {
+	listener, err := ListenTCP(listenAddr, options.TCPFastOpen)
 	if err != nil {
 		logger.Fatalf("Failed to start proxy on %s: %v", listenAddr, err)
 	}
+	ServeTCPProxy(listener, targetAddr, allowList, logger, options)
+}
+
+// ServeTCPProxy forwards connections from an already bound listener, such as one passed by systemd.
+// It owns the listener and returns once the listener is closed; connections already handed off keep running.
+func ServeTCPProxy(listener net.Listener, targetAddr string, allowList config.AllowList, logger *log.Logger, options Options) {
 	defer listener.Close()
 
+	listenAddr := listener.Addr().String()
 	logger.Printf("TCP proxy started on %s forwarding to %s", listenAddr, targetAddr)
+	if options.RouteID == "" {
+		options.RouteID = config.Route{LocalPort: listenPort(listenAddr)}.ID("tcp")
+	}
+	options.stats = options.Metrics.Route(options.RouteID, "tcp", listenAddr, targetAddr)
+	options.state = options.Status.route(options.RouteID, "tcp", listenAddr, targetAddr)
+	options.state.listening(listenAddr)
+	defer options.state.stopped()
+	if len(options.Balance) > 0 {
+		logger.Printf("TCP proxy on %s balances new connections round-robin over %s", listenAddr, strings.Join(append([]string{targetAddr}, options.Balance...), ", "))
+	}
+	probe := healthCheckDial
+	if options.SyntheticCheck != nil {
+		probe = syntheticProbe(*options.SyntheticCheck, options)
+	}
+	options.failover = startRouteFailover(targetAddr, "TCP connections", probe, logger, options)
+	defer options.failover.close()
 
-	connChan := make(chan tcpConnJob)
-	activeConnections := make(chan struct{}, defaultMaxTCPConnectionsPerRoute)
+	options.perIP = newClientLimiter(options.MaxConnectionsPerIP)
+	defer options.perIP.close()
+	quota := newQuotaTracker(options.Quota, options.stats, listenAddr, time.Now())
+	if options.Schedule != nil {
+		logger.Printf("TCP proxy on %s accepts new clients only during %s", listenAddr, describeSchedule(options.Schedule))
+		options.state.maintenance(!options.Schedule.Open(time.Now()))
+	}
+	maintenanceSlots := make(chan struct{}, maxMaintenanceReplies)
 
-	for i := 0; i < runtime.NumCPU(); i++ {
-		go handleTCPConnections(connChan, targetAddr, logger)
+	// Each node's accept loop hands its clients to that node's workers; without NUMANodes one unpinned group serves everything.
+	nodes := options.NUMANodes
+	if len(nodes) == 0 {
+		nodes = [][]int{nil}
 	}
+	connChans := make([]chan tcpConnJob, len(nodes))
+	for node, cpus := range nodes {
+		connChans[node] = make(chan tcpConnJob)
+		defer close(connChans[node])
+		workers := len(cpus)
+		if cpus == nil {
+			workers = runtime.NumCPU()
+		}
+		for i := 0; i < workers; i++ {
+			go handleTCPConnections(connChans[node], cpus, listenAddr, targetAddr, logger, options)
+		}
+	}
+	var accepted <-chan acceptedTCP
+	if len(options.NUMANodes) > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		accepted = acceptOnNodes(listener, options.NUMANodes, options.MemoryGuard, done, logger, options.LogLimiter)
+	}
+	maxConnections := options.MaxConnections
+	if maxConnections <= 0 {
+		maxConnections = DefaultMaxTCPConnectionsPerRoute
+	}
+	activeConnections := make(chan struct{}, maxConnections)
+	tarpitSlots := make(chan struct{}, MaxTarpittedConnectionsPerRoute)
 
 	for {
-		clientConn, err := listener.Accept()
+		var clientConn net.Conn
+		var err error
+		node := 0
+		if accepted != nil {
+			result := <-accepted
+			clientConn, err, node = result.conn, result.err, result.node
+		} else {
+			// Clients arriving under memory pressure wait in the kernel's backlog instead of costing buffers here.
+			// A route stopped meanwhile notices its closed listener once the pause ends.
+			<-options.MemoryGuard.admitted()
+			clientConn, err = listener.Accept()
+		}
+		if errors.Is(err, net.ErrClosed) {
+			logger.Printf("TCP proxy on %s stopped", listenAddr)
+			return
+		}
 		if err != nil {
-			logger.Printf("Error accepting TCP connection on %s: %v", listenAddr, err)
+			options.LogLimiter.Printf(logger, "tcp accept "+listenAddr, "Error accepting TCP connection on %s: %v", listenAddr, err)
 			continue
 		}
 
 		clientIP, ok := remoteAddrIP(clientConn.RemoteAddr())
 		if !ok || !allowList.Allows(clientIP) {
-			logger.Printf("Rejected TCP connection from %s on %s: source IP is not allowed", clientConn.RemoteAddr().String(), listenAddr)
+			options.LogLimiter.Printf(logger, "tcp denied "+listenAddr, "Rejected TCP connection from %s on %s: source IP is not allowed", clientConn.RemoteAddr().String(), listenAddr)
+			options.stats.Dropped(metrics.DropNotAllowed)
+			tarpitOrReset(clientConn, options.TarpitDuration, tarpitSlots, logger, func() {})
+			continue
+		}
+		if !clientFamilyAllows(options.ClientFamily, clientIP) {
+			options.LogLimiter.Printf(logger, "tcp family "+listenAddr, "Rejected TCP connection from %s on %s: only %s clients are served", clientConn.RemoteAddr().String(), listenAddr, options.ClientFamily)
+			options.stats.Dropped(metrics.DropNotAllowed)
 			rejectTCPConnectionWithReset(clientConn, logger)
 			continue
 		}
 
+		open := options.Schedule.Open(time.Now())
+		options.state.maintenance(!open)
+		if !open {
+			options.LogLimiter.Printf(logger, "tcp schedule "+listenAddr, "Rejected TCP connection from %s on %s: outside the route schedule", clientConn.RemoteAddr().String(), listenAddr)
+			options.stats.Dropped(metrics.DropSchedule)
+			refuseOutsideSchedule(clientConn, options.MaintenanceReply, maintenanceSlots, logger)
+			continue
+		}
+
+		if !quota.admit(time.Now(), logger) {
+			options.LogLimiter.Printf(logger, "tcp quota "+listenAddr, "Rejected TCP connection from %s on %s: route quota used up", clientConn.RemoteAddr().String(), listenAddr)
+			rejectTCPConnectionWithReset(clientConn, logger)
+			continue
+		}
+
+		if options.SingleShot != nil && !options.SingleShot.claim() {
+			logger.Printf("Rejected TCP connection from %s on %s: single-shot client already served", clientConn.RemoteAddr().String(), listenAddr)
+			rejectTCPConnectionWithReset(clientConn, logger)
+			continue
+		}
+
+		if !options.perIP.acquire(clientIP) {
+			shedTCPConnection(clientConn, listenAddr, targetAddr, fmt.Sprintf("limit of %d connections per client IP reached", options.MaxConnectionsPerIP), logger, options, tarpitSlots)
+			continue
+		}
 		select {
 		case activeConnections <- struct{}{}:
 		default:
-			logger.Printf("Rejected TCP connection from %s on %s: connection limit reached", clientConn.RemoteAddr().String(), listenAddr)
-			rejectTCPConnectionWithReset(clientConn, logger)
+			options.perIP.release(clientIP)
+			shedTCPConnection(clientConn, listenAddr, targetAddr, "connection limit reached", logger, options, tarpitSlots)
 			continue
 		}
 
-		connChan <- tcpConnJob{conn: clientConn, release: activeConnections}
+		connChans[node] <- tcpConnJob{conn: clientConn, clientIP: clientIP, release: activeConnections}
+	}
+}
+
+// shedTCPConnection resets, or tarpits, a client that arrived while the route, or its own IP, was at its connection limit.
+// Shed clients still reach the Observer so overload shows up next to regular closes.
+func shedTCPConnection(conn net.Conn, listenAddr, targetAddr, why string, logger *log.Logger, options Options, tarpitSlots chan struct{}) {
+	clientAddr := conn.RemoteAddr().String()
+	info := ConnectionInfo{
+		Route:    options.RouteID,
+		Protocol: "tcp",
+		Client:   clientAddr,
+		Listen:   listenAddr,
+		Target:   targetAddr,
+		Started:  time.Now(),
 	}
+	options.LogLimiter.Printf(logger, "tcp limit "+listenAddr, "Rejected TCP connection from %s on %s: %s", clientAddr, listenAddr, why)
+	options.stats.Dropped(metrics.DropLimit)
+	tarpitOrReset(conn, options.TarpitDuration, tarpitSlots, logger, func() {
+		options.Observer.closed(info, CloseLimitShed)
+	})
 }
 
 // remoteAddrIP extracts the host IP from network addresses before allowlist checks.
@@ -82,6 +376,31 @@ func remoteAddrIP(addr net.Addr) (netip.Addr, bool) {
 	return parsed, true
 }
 
+// pickRuleTarget evaluates the route's rules against the client once, as its connection starts.
+func pickRuleTarget(routeRules []rules.Rule, clientAddr net.Addr) (string, bool) {
+	if len(routeRules) == 0 {
+		return "", false
+	}
+	ctx := rules.Context{Time: time.Now()}
+	ctx.ClientIP, _ = remoteAddrIP(clientAddr)
+	if tcpAddr, ok := clientAddr.(*net.TCPAddr); ok {
+		ctx.ClientPort = tcpAddr.Port
+	}
+	return rules.Pick(routeRules, ctx)
+}
+
+// clientFamilyAllows checks a client against the family filter; IPv4-mapped IPv6 clients of a dual-stack socket count as IPv4.
+func clientFamilyAllows(family string, clientIP netip.Addr) bool {
+	switch family {
+	case "ipv4":
+		return clientIP.Unmap().Is4()
+	case "ipv6":
+		return !clientIP.Unmap().Is4()
+	default:
+		return true
+	}
+}
+
 // rejectTCPConnectionWithReset sends TCP RST when the platform exposes a TCP connection.
 // Resetting denied clients makes allowlist failures immediate and avoids a graceful half-open flow.
 func rejectTCPConnectionWithReset(conn net.Conn, logger *log.Logger) {
@@ -104,7 +423,9 @@ func resetTCPConnection(conn net.Conn, logger *log.Logger) {
 
 // handleTCPConnections establishes bidirectional copy pipelines for every TCP client.
 // Each direction gets its own goroutine so that slow receivers do not block senders.
-func handleTCPConnections(connChan <-chan tcpConnJob, targetAddr string, logger *log.Logger) {
+// With cpus set the worker runs pinned to them, and the goroutines it starts begin on that node until Go moves them.
+func handleTCPConnections(connChan <-chan tcpConnJob, cpus []int, listenAddr, targetAddr string, logger *log.Logger, options Options) {
+	pinToNode(cpus, listenAddr, logger, options.LogLimiter)
 	for {
 		select {
 		case job, ok := <-connChan:
@@ -112,74 +433,468 @@ func handleTCPConnections(connChan <-chan tcpConnJob, targetAddr string, logger
 				return
 			}
 
-			go handleTCPConnection(job, targetAddr, logger)
+			go handleTCPConnection(job, listenAddr, targetAddr, logger, options)
 		}
 	}
 }
 
-func handleTCPConnection(job tcpConnJob, targetAddr string, logger *log.Logger) {
+func handleTCPConnection(job tcpConnJob, listenAddr, targetAddr string, logger *log.Logger, options Options) {
 	conn := job.conn
+	// The client is the socket's peer unless a load balancer in front names the real one in a PROXY header,
+	// which is read before a target is picked so rules, logs, and the header sent on all see that client.
+	client, reached := conn.RemoteAddr(), conn.LocalAddr()
+	clientAddr := client.String()
+	info := ConnectionInfo{
+		Route:    options.RouteID,
+		Protocol: "tcp",
+		Client:   clientAddr,
+		Listen:   listenAddr,
+		Target:   targetAddr,
+		Started:  time.Now(),
+	}
+	// Every exit path below sets the reason before returning, so the close line and Observer always agree.
+	reason := CloseError
+	options.stats.Opened()
+	var preface []byte
+	var transferred atomic.Uint64
+	openLine := startConnectionOpenLine(options.MinConnectionLogDuration)
+	defer func() {
+		quiet := len(preface) == 0 && transferred.Load() == 0 && openLine.skip()
+		if !quiet {
+			openLine.log()
+			logger.Printf("TCP connection closed: %s -> %s (%s)%s", clientAddr, targetAddr, reason, tlsLogSuffix(info))
+		}
+		options.Observer.closed(info, reason)
+		options.stats.Closed(string(reason))
+		options.SingleShot.finish()
+	}()
 	defer func() {
 		<-job.release
+		options.perIP.release(job.clientIP)
 	}()
 	defer conn.Close()
+	if options.AcceptProxyProtocol {
+		header, err := readProxyHeader(conn, options.PeekBufferMax, proxyHeaderTimeout)
+		if err != nil {
+			if errors.Is(err, errPeekTimeout) {
+				reason = CloseIdleTimeout
+			}
+			openLine.log()
+			options.LogLimiter.Printf(logger, "proxy header "+listenAddr, "Closing TCP connection from %s on %s: %v", clientAddr, listenAddr, err)
+			resetTCPConnection(conn, logger)
+			return
+		}
+		if header.source != nil {
+			client, reached = header.source, header.destination
+			clientAddr = client.String()
+			info.Client = clientAddr
+		}
+	}
+	var proxyHeader []byte
+	if options.SendProxyProtocol > 0 {
+		proxyHeader = proxyProtocolHeader(options.SendProxyProtocol, client, reached)
+	}
+	poolDown := false
+	// redial holds the targets left to try, in priority order, when the chosen one fails before the live copy starts.
+	var redial []string
+	if upstream, ok := pickRuleTarget(options.Rules, client); ok {
+		targetAddr = upstream
+	} else if target, ok := options.failover.target(targetAddr); ok {
+		if options.FailoverRedial {
+			redial = otherTargets(routeTargets(targetAddr, options), target)
+		}
+		targetAddr = target
+	} else {
+		poolDown = true
+	}
+	info.Target = targetAddr
+	if poolDown {
+		reason = CloseHealthEjection
+		options.LogLimiter.Printf(logger, "pool down "+listenAddr, "Rejecting TCP connection from %s: every target of %s is down", clientAddr, targetAddr)
+		resetTCPConnection(conn, logger)
+		return
+	}
+	if err := setTCPUserTimeout(conn, options.TCPUserTimeout); err != nil {
+		options.LogLimiter.Printf(logger, "tcp user timeout "+listenAddr, "Failed to set TCP_USER_TIMEOUT for %s: %v", clientAddr, err)
+	}
 
-	clientAddr := conn.RemoteAddr().String()
-	logger.Printf("New TCP connection: %s -> %s", clientAddr, targetAddr)
+	if options.OriginalDestination {
+		destination, err := originalDestination(conn)
+		if err != nil {
+			logger.Printf("Closing TCP connection from %s: cannot read its original destination: %v", clientAddr, err)
+			resetTCPConnection(conn, logger)
+			return
+		}
+		// A client that reached the listener without NAT names the listener itself; dialing that would loop, so the route target serves it.
+		if !sameEndpoint(destination, conn.LocalAddr().String()) {
+			targetAddr = destination
+			info.Target = destination
+			redial = nil
+		}
+	}
+
+	// A paired proxy's stream is inflated first, so TLS, SNI peeking, and the handshake timeout all see the original bytes.
+	if options.CompressClients {
+		compressed, err := acceptCompressedClient(conn)
+		if err != nil {
+			logger.Printf("Closing TCP connection from %s: %v", clientAddr, err)
+			resetTCPConnection(job.conn, logger)
+			return
+		}
+		conn = compressed
+	}
+	if options.TargetUDP {
+		conn = newFramedConn(conn)
+	}
 
-	dialer := net.Dialer{Timeout: tcpDialTimeout}
-	serverConn, err := dialer.Dial("tcp", targetAddr)
+	// Passthrough SNI logging peeks before anything else reads; the peeked bytes become the preface replayed upstream.
+	serverName := ""
+	// A ClientHello that outgrows the peek buffer or trickles in past the timeout closes the connection instead of holding memory.
+	// A server-first client sends no ClientHello before the greeting, so peeking would only hold it for the timeout.
+	if options.LogSNI && options.TLSConfig == nil && !options.ServerFirst {
+		var err error
+		preface, serverName, err = peekServerName(conn, options.PeekBufferMax, peekTimeout)
+		if err != nil {
+			if errors.Is(err, errPeekTimeout) {
+				reason = CloseIdleTimeout
+			}
+			openLine.log()
+			logger.Printf("Closing TCP connection from %s: %v", clientAddr, err)
+			return
+		}
+		info.SNI = serverName
+	}
+	openLine.ready(func() {
+		logger.Printf("New TCP connection: %s -> %s%s", clientAddr, targetAddr, sniLogSuffix(serverName))
+	})
+
+	if options.TLSConfig != nil {
+		tlsConn, err := terminateTLS(conn, options.TLSConfig)
+		if err != nil {
+			if errors.Is(err, io.EOF) && openLine.skip() {
+				return
+			}
+			openLine.log()
+			logger.Printf("TLS handshake with %s failed: %v", clientAddr, err)
+			return
+		}
+		conn = tlsConn
+		recordTLSState(&info, tlsConn.ConnectionState())
+		if options.LogSNI {
+			logger.Printf("TLS handshake with %s completed%s", clientAddr, tlsLogSuffix(info))
+		}
+	}
+
+	// Waiting for the client before dialing keeps slow-loris clients from holding backend connections too.
+	// A server-first client waits for the greeting instead, so it is never asked to speak first.
+	if options.HandshakeTimeout > 0 && len(preface) == 0 && !options.ServerFirst {
+		var err error
+		preface, err = readClientPreface(conn, options.HandshakeTimeout)
+		if err != nil {
+			reason = CloseIdleTimeout
+			if err == io.EOF {
+				reason = CloseClientEOF
+				if openLine.skip() {
+					return
+				}
+			}
+			openLine.log()
+			logger.Printf("Closing TCP connection from %s: no client data within %s (%v)", clientAddr, options.HandshakeTimeout, err)
+			return
+		}
+	}
+
+	serverConn, err := connectTCPTarget(targetAddr, conn.RemoteAddr(), proxyHeader, preface, logger, options)
+	for _, next := range redial {
+		if err == nil {
+			break
+		}
+		options.state.failed(err)
+		options.stats.DialFailed()
+		options.LogLimiter.Printf(logger, "tcp redial "+targetAddr, "Redialing %s for %s after %s failed (%v); replaying %d buffered bytes", next, clientAddr, targetAddr, err, len(preface))
+		targetAddr, info.Target = next, next
+		serverConn, err = connectTCPTarget(targetAddr, conn.RemoteAddr(), proxyHeader, preface, logger, options)
+	}
 	if err != nil {
-		logger.Printf("Failed to connect to TCP server %s: %v", targetAddr, err)
-		resetTCPConnection(conn, logger)
+		openLine.log()
+		options.LogLimiter.Printf(logger, "tcp dial "+targetAddr, "Failed to connect to %s server %s: %v", bridgeTargetProtocol(options), targetAddr, err)
+		options.state.failed(err)
+		options.stats.DialFailed()
+		resetTCPConnection(job.conn, logger)
 		return
 	}
 	defer serverConn.Close()
 
-	done := make(chan struct{}, 2)
-	go copyTCPStream(serverConn, conn, "client", clientAddr, targetAddr, logger, done)
-	go copyTCPStream(conn, serverConn, "server", clientAddr, targetAddr, logger, done)
+	// Closing both sockets unblocks both copy goroutines, which is all a forced kill needs.
+	// The kill is flagged before closing so the copy errors it causes are not mistaken for the reason.
+	killed := make(chan struct{}, 1)
+	info.ID = options.Registry.Register(info, func() {
+		select {
+		case killed <- struct{}{}:
+		default:
+		}
+		conn.Close()
+		serverConn.Close()
+	})
+	defer options.Registry.Unregister(info.ID)
+
+	// Access logging reads through taps on both sources; the sockets themselves stay untouched for kills and closes.
+	var clientSource, serverSource net.Conn = conn, serverConn
+	if options.AccessLog != nil {
+		tap := startHTTPAccessTap(clientAddr, options.AccessLog)
+		defer tap.close()
+		if len(preface) > 0 {
+			_, _ = tap.client.Write(preface)
+		}
+		clientSource = tappedConn{Conn: conn, tap: tap.client}
+		serverSource = tappedConn{Conn: serverConn, tap: tap.server}
+	}
+	// The rewriter also follows the backend's responses, because only an upgrade the backend accepted may end the rewriting.
+	var forwarded *forwardedHeaderConn
+	if options.ForwardedFor {
+		forwarded = newForwardedHeaderConn(clientSource, preface, remoteHost(clientAddr), forwardedProto(options))
+		defer forwarded.close()
+		clientSource = forwarded
+		serverSource = forwarded.watchResponses(serverSource)
+	}
 
-	<-done
+	done := make(chan CloseReason, 2)
+	go copyTCPStream(serverConn, clientSource, "client", clientAddr, targetAddr, 0, idleTimeoutOrDefault(options.ClientIdleTimeout), logger, options.stats, &transferred, done)
+	go copyTCPStream(conn, serverSource, "server", clientAddr, targetAddr, serverFirstReadTimeout(options), idleTimeoutOrDefault(options.ServerIdleTimeout), logger, options.stats, &transferred, done)
+
+	// The first direction to finish explains the close; the second only follows from the sockets closing.
+	reason = <-done
 	conn.Close()
 	serverConn.Close()
+	forwarded.stop()
 	<-done
 
-	logger.Printf("TCP connection closed: %s -> %s", clientAddr, targetAddr)
+	select {
+	case <-killed:
+		reason = CloseManualKill
+	default:
+	}
+}
+
+// connectTCPTarget dials the backend and brings it to where the live copy starts: PROXY header sent, compression negotiated, and the client preface written.
+// Every step happens before any backend byte reaches the client, so on failure the caller may hand the same preface to another target.
+func connectTCPTarget(targetAddr string, client net.Addr, proxyHeader, preface []byte, logger *log.Logger, options Options) (net.Conn, error) {
+	serverConn, err := dialStreamTarget(targetAddr, client, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := setTCPUserTimeout(serverConn, options.TCPUserTimeout); err != nil {
+		options.LogLimiter.Printf(logger, "tcp user timeout "+targetAddr, "Failed to set TCP_USER_TIMEOUT for %s: %v", targetAddr, err)
+	}
+
+	// The header must be the first byte the backend reads, so it even precedes a paired proxy's compression handshake.
+	if len(proxyHeader) > 0 {
+		if err := writeFullWithDeadline(serverConn, proxyHeader, tcpWriteTimeout); err != nil {
+			serverConn.Close()
+			return nil, fmt.Errorf("write PROXY protocol header: %v", err)
+		}
+	}
+
+	if options.CompressBackend {
+		compressed, err := startCompressedBackend(serverConn)
+		if err != nil {
+			serverConn.Close()
+			return nil, fmt.Errorf("start compression: %v", err)
+		}
+		serverConn = compressed
+	}
+
+	// With X-Forwarded-For the preface is the start of the first request head, so it goes through the rewriter instead.
+	if len(preface) > 0 && !options.ForwardedFor {
+		if err := writeFullWithDeadline(serverConn, preface, tcpWriteTimeout); err != nil {
+			serverConn.Close()
+			return nil, fmt.Errorf("write client preface: %v", err)
+		}
+	}
+	return serverConn, nil
+}
+
+// otherTargets lists targets in priority order without the one that was already tried.
+func otherTargets(targets []string, tried string) []string {
+	var others []string
+	for _, target := range targets {
+		if target != tried {
+			others = append(others, target)
+		}
+	}
+	return others
+}
+
+// terminateTLS completes the server handshake under a deadline so silent clients cannot hold a worker.
+// The certificate comes from the config callback, which lets reloaded certificates apply to new handshakes only.
+func terminateTLS(conn net.Conn, config *tls.Config) (*tls.Conn, error) {
+	tlsConn := tls.Server(conn, config)
+	if err := tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout)); err != nil {
+		return nil, err
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	if err := tlsConn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
 }
 
-func copyTCPStream(dst net.Conn, src net.Conn, direction, clientAddr, targetAddr string, logger *log.Logger, done chan<- struct{}) {
+// readClientPreface waits for the first client bytes so the backend is only dialed for clients that speak.
+func readClientPreface(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	size := 4 * 1024
+	if _, framed := conn.(*framedConn); framed {
+		// A frame is read whole or not at all, so the preface buffer must fit the largest one.
+		size = maxBridgeFrame
+	}
+	buffer := make([]byte, size)
+	n, err := conn.Read(buffer)
+	if n == 0 {
+		if err == nil {
+			err = io.ErrNoProgress
+		}
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return buffer[:n], nil
+}
+
+// copyTCPStream relays one direction until either side fails or goes idle and reports why it stopped.
+// A positive firstReadTimeout bounds only the first read, which catches backends that accept but never answer.
+// transferred counts the bytes of both directions of one connection, which decides whether its log lines may be left out.
+func copyTCPStream(dst net.Conn, src net.Conn, direction, clientAddr, targetAddr string, firstReadTimeout, idleTimeout time.Duration, logger *log.Logger, stats *metrics.Route, transferred *atomic.Uint64, done chan<- CloseReason) {
+	reason := CloseError
 	defer func() {
-		done <- struct{}{}
+		done <- reason
 	}()
 
-	buffer := make([]byte, 32*1024)
+	buffer := make([]byte, copyBufferSize(src))
+	firstRead := firstReadTimeout > 0
 	for {
-		_ = src.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		readTimeout := idleTimeout
+		if firstRead {
+			readTimeout = firstReadTimeout
+		}
+		_ = src.SetReadDeadline(time.Now().Add(readTimeout))
 		n, readErr := src.Read(buffer)
+		if firstRead {
+			if errors.Is(readErr, os.ErrDeadlineExceeded) && n == 0 {
+				logger.Printf("Closing TCP connection %s -> %s: %s sent nothing within %s", clientAddr, targetAddr, direction, firstReadTimeout)
+				reason = CloseIdleTimeout
+				return
+			}
+			firstRead = false
+		}
 		if n > 0 {
 			_ = dst.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
 			if writeErr := writeFull(dst, buffer[:n]); writeErr != nil {
+				if isPeerReset(writeErr) {
+					reason = streamResetReason(otherPeer(direction))
+					return
+				}
 				logger.Printf("Error writing TCP %s stream for %s -> %s: %v", direction, clientAddr, targetAddr, writeErr)
 				return
 			}
+			stats.AddBytes(direction, n)
+			transferred.Add(uint64(n))
 		}
 		if readErr != nil {
-			if netErr, ok := readErr.(net.Error); ok && netErr.Timeout() {
-				logger.Printf("Closing idle TCP %s stream for %s -> %s", direction, clientAddr, targetAddr)
+			// Only our own read deadline means idle; ETIMEDOUT from TCP_USER_TIMEOUT or keepalive also reports Timeout() but is a dead peer.
+			if errors.Is(readErr, os.ErrDeadlineExceeded) {
+				logger.Printf("Closing idle TCP %s stream for %s -> %s: nothing sent within %s", direction, clientAddr, targetAddr, idleTimeout)
+				reason = CloseIdleTimeout
+			} else if readErr == io.EOF {
+				reason = streamEOFReason(direction)
+			} else if isPeerReset(readErr) {
+				reason = streamResetReason(direction)
 			}
 			return
 		}
 	}
 }
 
-func writeFull(conn net.Conn, payload []byte) error {
+// serverFirstReadTimeout bounds the backend's first bytes: its greeting on server-first routes, otherwise its reply to the handshake.
+func serverFirstReadTimeout(options Options) time.Duration {
+	if options.ServerFirst && options.BackendFirstByteTimeout > 0 {
+		return options.BackendFirstByteTimeout
+	}
+	return options.HandshakeTimeout
+}
+
+// idleTimeoutOrDefault applies DefaultTCPIdleTimeout to directions without their own threshold.
+func idleTimeoutOrDefault(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return DefaultTCPIdleTimeout
+	}
+	return timeout
+}
+
+// streamEOFReason tells which peer hung up based on the direction that saw the end of stream.
+func streamEOFReason(direction string) CloseReason {
+	if direction == "server" {
+		return CloseServerEOF
+	}
+	return CloseClientEOF
+}
+
+// writeFull loops until every byte is written because raw Write calls may return short counts.
+// A zero-byte write without an error is reported instead of spinning forever.
+func writeFull(dst io.Writer, payload []byte) error {
 	for len(payload) > 0 {
-		n, err := conn.Write(payload)
+		n, err := dst.Write(payload)
 		if err != nil {
 			return err
 		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
 		payload = payload[n:]
 	}
 	return nil
 }
+
+// writeFullWithDeadline flushes pre-copy payloads such as protocol headers or banners.
+// The deadline keeps a stuck peer from hanging the handler, and clearing it afterwards leaves the copy loop in charge.
+func writeFullWithDeadline(conn net.Conn, payload []byte, timeout time.Duration) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	defer conn.SetWriteDeadline(time.Time{})
+	return writeFull(conn, payload)
+}
+
+// sameEndpoint compares two host:port strings, treating an IPv4-mapped IPv6 address as the IPv4 address it carries.
+func sameEndpoint(a, b string) bool {
+	first, err := netip.ParseAddrPort(a)
+	if err != nil {
+		return a == b
+	}
+	second, err := netip.ParseAddrPort(b)
+	if err != nil {
+		return a == b
+	}
+	return first.Addr().Unmap() == second.Addr().Unmap() && first.Port() == second.Port()
+}
+
+// remoteHost strips the port from a peer address for headers and logs that carry only the IP.
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// forwardedProto reports the scheme the client used, which is https only when the proxy terminated TLS itself.
+func forwardedProto(options Options) string {
+	if options.TLSConfig != nil {
+		return "https"
+	}
+	return "http"
+}
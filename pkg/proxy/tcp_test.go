@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"bufio"
 	"errors"
 	"io"
 	"log"
@@ -9,6 +10,9 @@ import (
 	"syscall"
 	"testing"
 	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
+	"github.com/matveynator/chicha-ip-proxy/pkg/rules"
 )
 
 func TestRejectTCPConnectionWithResetDoesNotCloseGracefully(t *testing.T) {
@@ -69,8 +73,10 @@ func TestHandleTCPConnectionResetsClientWhenTargetDialFails(t *testing.T) {
 	release := make(chan struct{}, 1)
 	release <- struct{}{}
 
+	set := metrics.NewSet("")
 	resetAccepted := make(chan struct{})
 	accepted := make(chan error, 1)
+	reasons := make(chan CloseReason, 1)
 	go func() {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -82,7 +88,10 @@ func TestHandleTCPConnectionResetsClientWhenTargetDialFails(t *testing.T) {
 		handleTCPConnection(tcpConnJob{
 			conn:    conn,
 			release: release,
-		}, targetAddr, log.New(io.Discard, "", 0))
+		}, listener.Addr().String(), targetAddr, log.New(io.Discard, "", 0), Options{
+			Observer: func(_ ConnectionInfo, reason CloseReason) { reasons <- reason },
+			stats:    set.Route("tcp/test", "tcp", listener.Addr().String(), targetAddr),
+		})
 		accepted <- nil
 	}()
 
@@ -111,6 +120,12 @@ func TestHandleTCPConnectionResetsClientWhenTargetDialFails(t *testing.T) {
 	if err := <-accepted; err != nil {
 		t.Fatalf("listener.Accept returned error: %v", err)
 	}
+	if got := waitForCloseReason(t, reasons); got != CloseError {
+		t.Fatalf("close reason = %q, want %q", got, CloseError)
+	}
+	if routes := set.Routes(); len(routes) != 1 || routes[0].DialErrors != 1 {
+		t.Fatalf("route counters = %+v, want one dial error", routes)
+	}
 }
 
 func closedTCPAddress(t *testing.T) string {
@@ -138,3 +153,318 @@ func TestRemoteAddrIPAcceptsIPv6SocketAddress(t *testing.T) {
 		t.Fatalf("remoteAddrIP = %s", got)
 	}
 }
+
+func TestSameEndpointTreatsMappedIPv4AsIPv4(t *testing.T) {
+	if !sameEndpoint("203.0.113.10:8080", "[::ffff:203.0.113.10]:8080") {
+		t.Fatal("mapped listener address did not match its IPv4 form")
+	}
+	if sameEndpoint("203.0.113.10:8080", "203.0.113.10:8081") {
+		t.Fatal("different ports matched")
+	}
+}
+
+func TestClientFamilyAllowsTreatsMappedIPv4AsIPv4(t *testing.T) {
+	for _, test := range []struct {
+		family, client string
+		want           bool
+	}{
+		{"ipv4", "198.51.100.7", true},
+		{"ipv4", "::ffff:198.51.100.7", true},
+		{"ipv4", "2001:db8::7", false},
+		{"ipv6", "2001:db8::7", true},
+		{"ipv6", "::ffff:198.51.100.7", false},
+		{"ipv6", "198.51.100.7", false},
+		{"any", "::ffff:198.51.100.7", true},
+		{"", "2001:db8::7", true},
+	} {
+		if got := clientFamilyAllows(test.family, netip.MustParseAddr(test.client)); got != test.want {
+			t.Fatalf("clientFamilyAllows(%q, %s) = %v, want %v", test.family, test.client, got, test.want)
+		}
+	}
+}
+
+// oneByteConn accepts a single byte per Write call to exercise short-write handling.
+type oneByteConn struct {
+	net.Conn
+	written []byte
+}
+
+func (conn *oneByteConn) Write(payload []byte) (int, error) {
+	if len(payload) == 0 {
+		return 0, nil
+	}
+	conn.written = append(conn.written, payload[0])
+	return 1, nil
+}
+
+func (conn *oneByteConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func TestWriteFullWithDeadlineFlushesShortWrites(t *testing.T) {
+	conn := &oneByteConn{}
+	payload := []byte("PROXY TCP4 198.51.100.7 203.0.113.10 40000 80\r\n")
+
+	if err := writeFullWithDeadline(conn, payload, time.Second); err != nil {
+		t.Fatalf("writeFullWithDeadline returned error: %v", err)
+	}
+	if string(conn.written) != string(payload) {
+		t.Fatalf("written = %q, want %q", conn.written, payload)
+	}
+}
+
+func TestWriteFullWithDeadlineTimesOutOnStuckPeer(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	err := writeFullWithDeadline(local, []byte("banner"), 50*time.Millisecond)
+	if err == nil {
+		t.Fatal("writeFullWithDeadline succeeded without a reader")
+	}
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("writeFullWithDeadline returned %v, want timeout", err)
+	}
+}
+
+func TestHandleTCPConnectionClosesSilentClientBeforeDialing(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	backendAccepted := make(chan struct{}, 1)
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+		backendAccepted <- struct{}{}
+	}()
+
+	clientConn, finished := startHandledConnection(t, backend.Addr().String(), Options{HandshakeTimeout: 50 * time.Millisecond})
+	defer clientConn.Close()
+
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("silent client was not closed after the handshake timeout")
+	}
+	select {
+	case <-backendAccepted:
+		t.Fatal("backend was dialed for a client that never spoke")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHandleTCPConnectionClosesWhenBackendNeverAnswers(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buffer := make([]byte, 16)
+		n, _ := conn.Read(buffer)
+		received <- string(buffer[:n])
+		io.Copy(io.Discard, conn)
+	}()
+
+	clientConn, finished := startHandledConnection(t, backend.Addr().String(), Options{HandshakeTimeout: 100 * time.Millisecond})
+	defer clientConn.Close()
+	if _, err := clientConn.Write([]byte("HELLO")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+
+	if got := <-received; got != "HELLO" {
+		t.Fatalf("backend received %q, want the client preface", got)
+	}
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection stayed open although the backend never answered")
+	}
+}
+
+func TestHandleTCPConnectionClosesServerFirstRouteWithoutGreeting(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	reasons := make(chan CloseReason, 1)
+	// The handshake timeout would close a silent client first if server-first routes still waited for the client.
+	clientConn, finished := startHandledConnection(t, backend.Addr().String(), Options{
+		ServerFirst:             true,
+		HandshakeTimeout:        time.Second,
+		BackendFirstByteTimeout: 100 * time.Millisecond,
+		Observer:                func(_ ConnectionInfo, reason CloseReason) { reasons <- reason },
+	})
+	defer clientConn.Close()
+
+	started := time.Now()
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection stayed open although the backend never greeted")
+	}
+	if waited := time.Since(started); waited >= time.Second {
+		t.Fatalf("connection closed after %s, want the backend first-byte timeout", waited)
+	}
+	if reason := <-reasons; reason != CloseIdleTimeout {
+		t.Fatalf("close reason = %s, want %s", reason, CloseIdleTimeout)
+	}
+}
+
+func TestServerFirstRouteGreetsWithoutWaitingForTheSNIPeek(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("220 ready\r\n"))
+		io.Copy(io.Discard, conn)
+	}()
+
+	// An SMTP client waits for the greeting, so a ClientHello peek would stall it for the whole peek timeout.
+	clientConn, _ := startHandledConnection(t, backend.Addr().String(), Options{ServerFirst: true, LogSNI: true})
+	defer clientConn.Close()
+	_ = clientConn.SetReadDeadline(time.Now().Add(peekTimeout / 2))
+	greeting := make([]byte, len("220 ready\r\n"))
+	if _, err := io.ReadFull(clientConn, greeting); err != nil {
+		t.Fatalf("client read %q, %v; want the greeting before the peek timeout", greeting, err)
+	}
+}
+
+func TestHandleTCPConnectionTimesOutEachDirectionSeparately(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	stopPushing := make(chan struct{})
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			select {
+			case <-stopPushing:
+				io.Copy(io.Discard, conn)
+				return
+			case <-time.After(20 * time.Millisecond):
+				if _, err := conn.Write([]byte("tick")); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	// The client never sends, yet the long client limit keeps the pushing backend connected well past the server limit.
+	options := Options{ClientIdleTimeout: time.Hour, ServerIdleTimeout: 150 * time.Millisecond}
+	clientConn, finished := startHandledConnection(t, backend.Addr().String(), options)
+	defer clientConn.Close()
+	go io.Copy(io.Discard, clientConn)
+
+	select {
+	case <-finished:
+		t.Fatal("connection closed while the backend was still sending")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	close(stopPushing)
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection stayed open after the backend went idle past its limit")
+	}
+}
+
+// startHandledConnection runs handleTCPConnection for one client and reports when it returns.
+func startHandledConnection(t *testing.T, targetAddr string, options Options) (net.Conn, <-chan struct{}) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	release := make(chan struct{}, 1)
+	release <- struct{}{}
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		handleTCPConnection(tcpConnJob{conn: conn, release: release}, listener.Addr().String(), targetAddr, log.New(io.Discard, "", 0), options)
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	return clientConn, finished
+}
+
+func TestRulesSendMatchingClientsToTheirUpstream(t *testing.T) {
+	routeTarget := startLineBackend(t, "route\n")
+	loopbackOnly, err := rules.Parse("ip in 127.0.0.0/8")
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	otherClients, err := rules.Parse("not ip in 127.0.0.0/8")
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	tests := []struct {
+		rules []rules.Rule
+		want  string
+	}{
+		{[]rules.Rule{{Expr: otherClients, Target: closedTCPAddress(t)}, {Expr: loopbackOnly, Target: startLineBackend(t, "rule\n")}}, "rule\n"},
+		{[]rules.Rule{{Expr: otherClients, Target: closedTCPAddress(t)}}, "route\n"},
+	}
+	for _, test := range tests {
+		proxyAddr := serveTCPForTest(t, routeTarget, Options{Rules: test.rules})
+		conn, err := net.Dial("tcp", proxyAddr)
+		if err != nil {
+			t.Fatalf("net.Dial returned error: %v", err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Write([]byte("hello\n")); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+		reply, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil || reply != test.want {
+			t.Fatalf("reply = %q, %v; want %q", reply, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+//go:build linux
+// +build linux
+
+// TCP_USER_TIMEOUT drops a connection once sent data, keepalive probes included, has gone unacknowledged this long.
+// It finds dead peers on a fixed clock, where retransmission backoff alone can take a quarter of an hour.
+package proxy
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// TCPUserTimeoutSupported reports whether Options.TCPUserTimeout takes effect on this platform.
+const TCPUserTimeoutSupported = true
+
+// tcpUserTimeoutOption is TCP_USER_TIMEOUT, which the syscall package names only on some architectures; the value is the same on all.
+const tcpUserTimeoutOption = 0x12
+
+// setTCPUserTimeout applies timeout to a TCP socket; other connections, such as a bridged UDP backend, are left alone.
+func setTCPUserTimeout(conn net.Conn, timeout time.Duration) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok || timeout <= 0 {
+		return nil
+	}
+	// The kernel counts whole milliseconds, so anything shorter is rounded up rather than switching the option off with 0.
+	milliseconds := int(timeout.Milliseconds())
+	if milliseconds == 0 {
+		milliseconds = 1
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var optErr error
+	if err := raw.Control(func(fd uintptr) {
+		optErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeoutOption, milliseconds)
+	}); err != nil {
+		return err
+	}
+	return optErr
+}
+
+// tcpUserTimeout reads the option back, so tests can see what the kernel holds.
+func tcpUserTimeout(conn *net.TCPConn) (time.Duration, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var milliseconds int
+	var optErr error
+	if err := raw.Control(func(fd uintptr) {
+		milliseconds, optErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeoutOption)
+	}); err != nil {
+		return 0, err
+	}
+	return time.Duration(milliseconds) * time.Millisecond, optErr
+}
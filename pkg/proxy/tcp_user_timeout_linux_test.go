@@ -0,0 +1,94 @@
+//go:build linux
+// +build linux
+
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHandleTCPConnectionSetsUserTimeoutOnBothSockets(t *testing.T) {
+	backendAddr := startEchoBackend(t)
+	dialed := make(chan net.Conn, 1)
+	originalDial := tcpDial
+	tcpDial = func(dialer *net.Dialer, address string) (net.Conn, error) {
+		conn, err := originalDial(dialer, address)
+		if err == nil {
+			dialed <- conn
+		}
+		return conn, err
+	}
+	defer func() { tcpDial = originalDial }()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn
+		release := make(chan struct{}, 1)
+		release <- struct{}{}
+		handleTCPConnection(tcpConnJob{conn: conn, release: release}, listener.Addr().String(), backendAddr, log.New(io.Discard, "", 0), Options{TCPUserTimeout: 1500 * time.Millisecond})
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer clientConn.Close()
+	_ = clientConn.SetDeadline(time.Now().Add(2 * time.Second))
+	// The echo proves both sockets are set up, so reading the options back cannot race with handleTCPConnection.
+	if _, err := clientConn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if _, err := io.ReadFull(clientConn, make([]byte, 4)); err != nil {
+		t.Fatalf("ReadFull returned error: %v", err)
+	}
+
+	for side, conns := range map[string]chan net.Conn{"client": accepted, "backend": dialed} {
+		timeout, err := tcpUserTimeout((<-conns).(*net.TCPConn))
+		if err != nil {
+			t.Fatalf("reading %s TCP_USER_TIMEOUT returned error: %v", side, err)
+		}
+		if timeout != 1500*time.Millisecond {
+			t.Fatalf("%s TCP_USER_TIMEOUT = %v, want 1.5s", side, timeout)
+		}
+	}
+}
+
+func TestSetTCPUserTimeoutRoundsUpAndSkipsOtherConns(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer conn.Close()
+
+	if err := setTCPUserTimeout(conn, time.Microsecond); err != nil {
+		t.Fatalf("setTCPUserTimeout returned error: %v", err)
+	}
+	if timeout, err := tcpUserTimeout(conn.(*net.TCPConn)); err != nil || timeout != time.Millisecond {
+		t.Fatalf("TCP_USER_TIMEOUT = %v, %v; want 1ms", timeout, err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if err := setTCPUserTimeout(client, time.Second); err != nil {
+		t.Fatalf("setTCPUserTimeout on a pipe returned error: %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+// TCP_USER_TIMEOUT is a Linux socket option; elsewhere Options.TCPUserTimeout does nothing.
+// main warns about that at startup, so connections here skip the option without logging each time.
+package proxy
+
+import (
+	"net"
+	"time"
+)
+
+// TCPUserTimeoutSupported reports whether Options.TCPUserTimeout takes effect on this platform.
+const TCPUserTimeoutSupported = false
+
+func setTCPUserTimeout(net.Conn, time.Duration) error {
+	return nil
+}
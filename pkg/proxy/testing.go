@@ -0,0 +1,58 @@
+// Test helpers let integration tests run a real route without copying the startup and teardown plumbing.
+// They live in their own file and avoid the testing package so production builds do not link it.
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+// TestingT is the subset of *testing.T and *testing.B that StartForTest needs.
+type TestingT interface {
+	Helper()
+	Fatalf(format string, args ...interface{})
+	Cleanup(func())
+}
+
+// StartForTest runs a TCP route on an ephemeral loopback port that forwards to backendAddr.
+// The returned address accepts connections immediately; stop closes the listener and every live connection,
+// waits for them to finish, and also runs automatically when the test ends.
+func StartForTest(t TestingT, backendAddr string) (localAddr string, stop func()) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("proxy.StartForTest: listen failed: %v", err)
+	}
+
+	registry := NewRegistry()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		ServeTCPProxy(listener, backendAddr, config.AllowList{}, log.New(io.Discard, "", 0), Options{Registry: registry})
+	}()
+
+	// A one-slot token makes stop idempotent without a mutex, so tests may call it before Cleanup does.
+	token := make(chan struct{}, 1)
+	token <- struct{}{}
+	stop = func() {
+		select {
+		case <-token:
+		default:
+			return
+		}
+		listener.Close()
+		<-served
+		registry.Drain(0)
+		deadline := time.Now().Add(5 * time.Second)
+		for len(registry.List()) > 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	t.Cleanup(stop)
+	return listener.Addr().String(), stop
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStartForTestForwardsUntilStopped(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	localAddr, stop := StartForTest(t, backend.Addr().String())
+	clientConn, err := net.Dial("tcp", localAddr)
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer clientConn.Close()
+
+	if _, err := clientConn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(clientConn, reply); err != nil || string(reply) != "ping" {
+		t.Fatalf("echo through proxy = %q, %v", reply, err)
+	}
+
+	stop()
+	stop()
+
+	if err := clientConn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline returned error: %v", err)
+	}
+	if _, err := clientConn.Read(reply); err == nil {
+		t.Fatal("live connection survived stop")
+	}
+	if conn, err := net.Dial("tcp", localAddr); err == nil {
+		conn.Close()
+		t.Fatal("proxy still accepts connections after stop")
+	}
+}
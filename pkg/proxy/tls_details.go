@@ -0,0 +1,28 @@
+// Connections that involve TLS carry what was negotiated into their close line, the Observer, and the admin connection list.
+// Terminated handshakes report version, cipher suite, ALPN, and SNI; passthrough routes only know the SNI peeked from the ClientHello.
+package proxy
+
+import (
+	"crypto/tls"
+	"strings"
+)
+
+// recordTLSState copies what a terminated handshake negotiated into info, so the close line, the Observer, and /connections show it.
+func recordTLSState(info *ConnectionInfo, state tls.ConnectionState) {
+	info.SNI = state.ServerName
+	info.TLSVersion = strings.TrimPrefix(tls.VersionName(state.Version), "TLS ")
+	info.TLSCipher = tls.CipherSuiteName(state.CipherSuite)
+	info.ALPN = state.NegotiatedProtocol
+}
+
+// tlsLogSuffix renders the TLS fields of info as key=value pairs for a log line; plain TCP connections get nothing.
+func tlsLogSuffix(info ConnectionInfo) string {
+	suffix := ""
+	if info.TLSVersion != "" {
+		suffix += " tls=" + info.TLSVersion + " cipher=" + info.TLSCipher
+	}
+	if info.ALPN != "" {
+		suffix += " alpn=" + info.ALPN
+	}
+	return suffix + sniLogSuffix(info.SNI)
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+// selfSignedTLSConfig terminates TLS with a throwaway certificate and offers h2 over ALPN.
+func selfSignedTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey returned error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "app.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate returned error: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}, NextProtos: []string{"h2"}}
+}
+
+// closedInfo serves one TCP route, runs client against it, and returns the Observer's view and the close line.
+func closedInfo(t *testing.T, options Options, client func(net.Conn)) (ConnectionInfo, string) {
+	t.Helper()
+	infos := make(chan ConnectionInfo, 1)
+	options.Observer = func(info ConnectionInfo, _ CloseReason) { infos <- info }
+	lines := make(logLines, 16)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen returned error: %v", err)
+	}
+	defer listener.Close()
+	go ServeTCPProxy(listener, startEchoBackend(t), config.AllowList{}, log.New(lines, "", 0), options)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	client(conn)
+	conn.Close()
+
+	var info ConnectionInfo
+	select {
+	case info = <-infos:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the connection never closed")
+	}
+	for {
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, "TCP connection closed: ") {
+				return info, line
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no close line was logged")
+		}
+	}
+}
+
+func echoOnce(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatalf("reading the echo returned error: %v", err)
+	}
+}
+
+func TestTerminatedTLSDetailsReachCloseLineAndObserver(t *testing.T) {
+	info, line := closedInfo(t, Options{TLSConfig: selfSignedTLSConfig(t)}, func(conn net.Conn) {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: "app.example.com", InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}, MinVersion: tls.VersionTLS13})
+		echoOnce(t, tlsConn)
+	})
+
+	if info.TLSVersion != "1.3" || info.TLSCipher == "" || info.ALPN != "h2" || info.SNI != "app.example.com" {
+		t.Fatalf("observed info = %+v, want TLS 1.3, a cipher, ALPN h2, and the SNI", info)
+	}
+	want := " tls=1.3 cipher=" + info.TLSCipher + " alpn=h2 sni=app.example.com\n"
+	if !strings.HasSuffix(line, want) {
+		t.Fatalf("close line = %q, want it to end with %q", line, want)
+	}
+}
+
+func TestPassthroughTLSDetailsOnlyCarryPeekedSNI(t *testing.T) {
+	info, line := closedInfo(t, Options{LogSNI: true}, func(conn net.Conn) {
+		// The echo backend answers the ClientHello with itself, which fails the handshake after the SNI was peeked.
+		tls.Client(conn, &tls.Config{ServerName: "app.example.com", InsecureSkipVerify: true}).Handshake()
+	})
+
+	if info.SNI != "app.example.com" || info.TLSVersion != "" || info.TLSCipher != "" || info.ALPN != "" {
+		t.Fatalf("observed info = %+v, want only the SNI", info)
+	}
+	if !strings.HasSuffix(line, ") sni=app.example.com\n") {
+		t.Fatalf("close line = %q, want only the SNI appended", line)
+	}
+}
+
+func TestPlainTCPCloseLineHasNoTLSDetails(t *testing.T) {
+	info, line := closedInfo(t, Options{}, func(conn net.Conn) { echoOnce(t, conn) })
+
+	if info.SNI != "" || info.TLSVersion != "" {
+		t.Fatalf("observed info = %+v, want no TLS fields", info)
+	}
+	if !strings.HasSuffix(line, ")\n") {
+		t.Fatalf("close line = %q, want it to end with the close reason", line)
+	}
+}
@@ -3,15 +3,29 @@
 package proxy
 
 import (
+	"errors"
+	"io"
 	"log"
 	"net"
 	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+	"github.com/matveynator/chicha-ip-proxy/pkg/logging"
+	"github.com/matveynator/chicha-ip-proxy/pkg/metrics"
 )
 
-const defaultMaxUDPSessionsPerRoute = 4096
+// MaxUDPSessionsPerRoute caps live UDP sessions per route; each session holds one backend socket open.
+const MaxUDPSessionsPerRoute = 4096
+
+const (
+	udpSessionIdleTimeout     = 60 * time.Second // udpSessionIdleTimeout retires a session once its client has been quiet longer than this.
+	udpSessionCleanupInterval = 30 * time.Second // udpSessionCleanupInterval is how often the manager looks for idle sessions.
+	udpReplyReadTimeout       = 5 * time.Second  // udpReplyReadTimeout bounds each backend read so the relay can notice idleness.
+)
 
 // udpMessage represents a single datagram from a client.
 // Keeping the payload in a dedicated struct makes it easy to fan out with channels.
@@ -24,180 +38,523 @@ type udpMessage struct {
 // This avoids dialing on every packet and keeps source ports stable for servers like WireGuard.
 type udpSession struct {
 	clientAddr net.Addr
-	remoteConn *net.UDPConn
+	remoteConn net.Conn // remoteConn is a datagram socket, or a framed TCP stream on a bridged route.
 	outbound   chan []byte
-	lastActive time.Time
+	lastActive atomic.Int64 // lastActive is the client's latest packet in Unix nanoseconds; the reply relay reads it concurrently.
+	createdAt  time.Time    // createdAt is when the session started; only the manager reads it, for UDPMaxSessionLifetime.
+	clock      clock
 	id         string
+	info       ConnectionInfo
+	stats      *metrics.Route
 }
 
 // sessionEvent notifies the session manager that a session must be removed.
 // Using a channel keeps synchronization lock-free while still allowing order.
 type sessionEvent struct {
-	key    string
-	reason string
+	key     string
+	reason  CloseReason
+	session *udpSession // session pins manual kills to one session so a reused client address survives a stale request.
 }
 
 // StartUDPProxy listens for UDP datagrams and forwards them to the target endpoint.
 // Work is coordinated by a session manager goroutine so there are no mutexes and no busy dialing.
-func StartUDPProxy(listenAddr, targetAddr string, allowList config.AllowList, logger *log.Logger) {
+func StartUDPProxy(listenAddr, targetAddr string, allowList config.AllowList, logger *log.Logger, options Options) {
 	conn, err := net.ListenPacket("udp", listenAddr)
 	if err != nil {
 		logger.Fatalf("Failed to start UDP proxy on %s: %v", listenAddr, err)
 	}
+	ServeUDPProxy(conn, targetAddr, allowList, logger, options)
+}
+
+// ServeUDPProxy forwards datagrams from an already bound socket, such as one passed by systemd.
+// It owns the socket and returns once the socket is closed, taking its sessions down with it.
+func ServeUDPProxy(conn net.PacketConn, targetAddr string, allowList config.AllowList, logger *log.Logger, options Options) {
 	defer conn.Close()
 
+	listenAddr := conn.LocalAddr().String()
 	logger.Printf("UDP proxy started on %s forwarding to %s", listenAddr, targetAddr)
+	if options.RouteID == "" {
+		options.RouteID = config.Route{LocalPort: listenPort(listenAddr)}.ID("udp")
+	}
+	options.stats = options.Metrics.Route(options.RouteID, "udp", listenAddr, targetAddr)
+	options.state = options.Status.route(options.RouteID, "udp", listenAddr, targetAddr)
+	options.state.listening(listenAddr)
+	defer options.state.stopped()
+	if len(options.Balance) > 0 {
+		logger.Printf("UDP proxy on %s balances new sessions round-robin over %s", listenAddr, strings.Join(append([]string{targetAddr}, options.Balance...), ", "))
+	}
+	options.failover = startRouteFailover(targetAddr, "UDP sessions", udpRouteProbe(options.SyntheticCheck, options), logger, options)
+	defer options.failover.close()
 
 	msgChan := make(chan udpMessage, runtime.NumCPU()*16)
-	go manageUDPSessions(targetAddr, conn, logger, msgChan)
-
-	buffer := make([]byte, 64*1024)
-	for {
-		n, addr, err := conn.ReadFrom(buffer)
-		if err != nil {
-			logger.Printf("Error reading UDP packet on %s: %v", listenAddr, err)
-			continue
-		}
+	defer close(msgChan)
+	go manageUDPSessions(listenAddr, targetAddr, conn, logger, msgChan, options, realClock{})
 
+	// dispatch admits one datagram and queues a copy of it, because the read buffers are reused for the next datagram.
+	dispatch := func(payload []byte, addr net.Addr) {
 		clientIP, ok := remoteAddrIP(addr)
 		if !ok || !allowList.Allows(clientIP) {
-			logger.Printf("Rejected UDP packet from %s on %s: source IP is not allowed", addr.String(), listenAddr)
-			continue
+			options.LogLimiter.Printf(logger, "udp denied "+listenAddr, "Rejected UDP packet from %s on %s: source IP is not allowed", addr.String(), listenAddr)
+			options.stats.Dropped(metrics.DropNotAllowed)
+			return
 		}
 
-		payloadCopy := make([]byte, n)
-		copy(payloadCopy, buffer[:n])
+		payloadCopy := make([]byte, len(payload))
+		copy(payloadCopy, payload)
 
 		select {
 		case msgChan <- udpMessage{data: payloadCopy, addr: addr}:
 		default:
-			logger.Printf("Dropping UDP packet from %s on %s: input queue full", addr.String(), listenAddr)
+			options.LogLimiter.Printf(logger, "udp input queue "+listenAddr, "Dropping UDP packet from %s on %s: input queue full", addr.String(), listenAddr)
+			options.stats.Dropped(metrics.DropQueueFull)
+		}
+	}
+
+	receiver := newUDPBatchReceiver(conn, options.UDPBatchRecv)
+	buffer := make([]byte, 64*1024)
+	for {
+		if receiver != nil {
+			received, err := receiver.receive()
+			if errors.Is(err, net.ErrClosed) {
+				logger.Printf("UDP proxy on %s stopped", listenAddr)
+				return
+			}
+			if err != nil {
+				options.LogLimiter.Printf(logger, "udp read "+listenAddr, "Error reading UDP packet on %s: %v", listenAddr, err)
+				continue
+			}
+			for i := 0; i < received; i++ {
+				if payload, addr := receiver.datagram(i); addr != nil {
+					dispatch(payload, addr)
+				}
+			}
+			continue
+		}
+
+		n, addr, err := conn.ReadFrom(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			logger.Printf("UDP proxy on %s stopped", listenAddr)
+			return
+		}
+		if err != nil {
+			options.LogLimiter.Printf(logger, "udp read "+listenAddr, "Error reading UDP packet on %s: %v", listenAddr, err)
+			continue
 		}
+		dispatch(buffer[:n], addr)
 	}
 }
 
 // manageUDPSessions multiplexes incoming datagrams to per-client sessions.
-// A ticker retires idle sessions so resources stay bounded without manual cleanup.
-func manageUDPSessions(targetAddr string, responder net.PacketConn, logger *log.Logger, msgChan <-chan udpMessage) {
+// A ticker retires idle sessions so resources stay bounded without manual cleanup; clock is injected so tests control both.
+func manageUDPSessions(listenAddr, targetAddr string, responder net.PacketConn, logger *log.Logger, msgChan <-chan udpMessage, options Options, clock clock) {
 	sessions := make(map[string]*udpSession)
-	cleanupTicker := time.NewTicker(30 * time.Second)
+	cleanupTicker := clock.NewTicker(udpSessionCleanupInterval)
 	defer cleanupTicker.Stop()
 
 	sessionEvents := make(chan sessionEvent, 128)
+	// pendingDials holds the datagrams of clients whose backend dial is being retried.
+	pendingDials := make(map[string][][]byte)
+	dialResults := make(chan udpDialResult)
+	// stopDials also ends the target watcher, so nothing the manager started outlives the route.
+	stopDials := make(chan struct{})
+	defer close(stopDials)
+	targetChanges := make(chan *net.UDPAddr)
+	quota := newQuotaTracker(options.Quota, options.stats, listenAddr, clock.Now())
+	if options.Schedule != nil {
+		logger.Printf("UDP proxy on %s starts new sessions only during %s", listenAddr, describeSchedule(options.Schedule))
+		options.state.maintenance(!options.Schedule.Open(clock.Now()))
+	}
+	// refuse answers a new client whose packet is dropped below, when -udp-reject-mode asks for it; existing sessions are never refused.
+	var rejects rejectBudget
+	refuse := func(client net.Addr, size int) {
+		if options.UDPReject == nil || !rejects.allow(clock.Now()) {
+			return
+		}
+		if err := options.UDPReject.reject(responder, client, size); err != nil {
+			options.LogLimiter.Printf(logger, "udp reject "+listenAddr, "Failed to answer refused UDP packet from %s on %s: %v", client, listenAddr, err)
+		}
+	}
+	// Mappings restored from -session-state-file are claimed as their clients return; the rest are balanced as usual.
+	restoredUpstreams := options.SessionState.restored(options.RouteID, targetAddr)
+	// A bridged route's TCP backend is dialed per session like any TCP target, so there are no datagram sockets to move,
+	// and sessions of a route with more targets may be on any of them, which one resolved address cannot stand for.
+	if options.UDPResolveInterval > 0 && !options.TargetTCP && len(options.Balance) == 0 && len(options.Backups) == 0 {
+		go watchUDPTarget(targetAddr, options.UDPResolveInterval, clock, logger, targetChanges, stopDials)
+	}
 
 	for {
 		select {
-		case msg := <-msgChan:
+		case msg, ok := <-msgChan:
+			if !ok {
+				// The listening socket closed because the operator stopped or removed the route.
+				for addr, session := range sessions {
+					closeUDPSession(sessions, addr, session, CloseManualKill, logger, options)
+				}
+				return
+			}
 			sessionKey := msg.addr.String()
+			if queued, ok := pendingDials[sessionKey]; ok {
+				if len(queued) < maxPendingDialDatagrams {
+					pendingDials[sessionKey] = append(queued, msg.data)
+				} else {
+					options.LogLimiter.Printf(logger, "udp dial pending "+listenAddr, "Dropping UDP packet for %s: backend dial still pending", sessionKey)
+					options.stats.Dropped(metrics.DropQueueFull)
+				}
+				continue
+			}
 			session, ok := sessions[sessionKey]
 			if !ok {
-				if len(sessions) >= defaultMaxUDPSessionsPerRoute {
-					logger.Printf("Dropping UDP packet for %s: session limit reached", sessionKey)
+				// The family is checked once per client, when its session would start, so established sessions pay nothing.
+				if clientIP, _ := remoteAddrIP(msg.addr); !clientFamilyAllows(options.ClientFamily, clientIP) {
+					options.LogLimiter.Printf(logger, "udp family "+listenAddr, "Dropping UDP packet from %s on %s: only %s clients are served", sessionKey, listenAddr, options.ClientFamily)
+					options.stats.Dropped(metrics.DropNotAllowed)
+					refuse(msg.addr, len(msg.data))
 					continue
 				}
-
-				resolvedTarget, err := net.ResolveUDPAddr("udp", targetAddr)
-				if err != nil {
-					logger.Printf("Failed to resolve UDP target %s: %v", targetAddr, err)
+				if options.MemoryGuard.paused() {
+					options.LogLimiter.Printf(logger, "udp memory "+listenAddr, "Dropping UDP packet from %s on %s: new sessions are paused under memory pressure", sessionKey, listenAddr)
+					options.stats.Dropped(metrics.DropMemory)
+					refuse(msg.addr, len(msg.data))
+					continue
+				}
+				open := options.Schedule.Open(clock.Now())
+				options.state.maintenance(!open)
+				if !open {
+					options.LogLimiter.Printf(logger, "udp schedule "+listenAddr, "Dropping UDP packet from %s on %s: outside the route schedule", sessionKey, listenAddr)
+					options.stats.Dropped(metrics.DropSchedule)
+					refuse(msg.addr, len(msg.data))
+					continue
+				}
+				if !quota.admit(clock.Now(), logger) {
+					options.LogLimiter.Printf(logger, "udp quota "+listenAddr, "Dropping UDP packet from %s on %s: route quota used up", sessionKey, listenAddr)
+					refuse(msg.addr, len(msg.data))
+					continue
+				}
+				if len(sessions)+len(pendingDials) >= MaxUDPSessionsPerRoute {
+					shedUDPSession(sessionKey, listenAddr, targetAddr, logger, options)
+					refuse(msg.addr, len(msg.data))
+					continue
+				}
+				if !options.UDPSessionRate.admit(clock.Now()) {
+					options.LogLimiter.Printf(logger, "udp session rate "+listenAddr, "Dropping UDP packet from %s on %s: new UDP session rate exceeded", sessionKey, listenAddr)
+					options.stats.Dropped(metrics.DropSessionRate)
+					refuse(msg.addr, len(msg.data))
 					continue
 				}
 
-				remoteConn, err := net.DialUDP("udp", nil, resolvedTarget)
+				// The session's target is picked once, by health and turn; retries stay on it so the client's packets keep one backend.
+				sessionTarget, ok := options.failover.target(targetAddr)
+				if !ok {
+					options.LogLimiter.Printf(logger, "udp pool down "+listenAddr, "Dropping UDP packet from %s on %s: every target is down", sessionKey, listenAddr)
+					options.stats.Dropped(metrics.DropDialFailed)
+					refuse(msg.addr, len(msg.data))
+					continue
+				}
+				remoteConn, err := dialRestoredUpstream(restoredUpstreams, sessionKey, sessionTarget, msg.addr, logger, options)
+				if err != nil {
+					remoteConn, err = dialSessionTarget(sessionTarget, msg.addr, options)
+				}
 				if err != nil {
-					logger.Printf("Failed to dial UDP target %s: %v", targetAddr, err)
+					options.state.failed(err)
+					options.stats.DialFailed()
+					if options.UDPDialRetries <= 0 {
+						options.LogLimiter.Printf(logger, "udp dial "+sessionTarget, "Failed to dial UDP target %s: %v", sessionTarget, err)
+						options.stats.Dropped(metrics.DropDialFailed)
+						refuse(msg.addr, len(msg.data))
+						continue
+					}
+					// Retrying off the loop keeps every other client flowing while this one waits for the backend.
+					options.LogLimiter.Printf(logger, "udp dial "+sessionTarget, "Failed to dial UDP target %s for %s: %v; retrying up to %d times", sessionTarget, sessionKey, err, options.UDPDialRetries)
+					pendingDials[sessionKey] = [][]byte{msg.data}
+					go retryUDPDial(sessionKey, msg.addr, sessionTarget, options, dialResults, stopDials)
 					continue
 				}
+				session = startUDPSession(sessions, msg.addr, remoteConn, listenAddr, targetAddr, sessionTarget, responder, logger, sessionEvents, options, clock)
+			}
 
-				session = &udpSession{
-					clientAddr: msg.addr,
-					remoteConn: remoteConn,
-					outbound:   make(chan []byte, 32),
-					lastActive: time.Now(),
-					id:         sessionKey,
-				}
-				sessions[sessionKey] = session
+			session.touch()
+			queueUDPPayload(session, msg.data, logger, options.LogLimiter)
 
-				go forwardUDPPackets(session, logger, sessionEvents)
-				go relayUDPReplies(session, responder, logger, sessionEvents)
+		case result := <-dialResults:
+			queued := pendingDials[result.key]
+			delete(pendingDials, result.key)
+			if result.err != nil {
+				options.LogLimiter.Printf(logger, "udp dial gave up "+result.target, "Giving up on UDP target %s for %s: %v; dropped %d queued packets", result.target, result.key, result.err, len(queued))
+				options.state.failed(result.err)
+				for range queued {
+					options.stats.Dropped(metrics.DropDialFailed)
+				}
+				if len(queued) > 0 {
+					refuse(result.clientAddr, len(queued[0]))
+				}
+				continue
+			}
+			session := startUDPSession(sessions, result.clientAddr, result.remoteConn, listenAddr, targetAddr, result.target, responder, logger, sessionEvents, options, clock)
+			for _, data := range queued {
+				queueUDPPayload(session, data, logger, options.LogLimiter)
 			}
 
-			session.lastActive = time.Now()
-
-			select {
-			case session.outbound <- msg.data:
-			default:
-				logger.Printf("Dropping UDP packet for %s due to full queue", session.clientAddr.String())
+		case <-cleanupTicker.C():
+			now := clock.Now()
+			for addr, session := range sessions {
+				if session.idleExpired(now) {
+					closeUDPSession(sessions, addr, session, CloseIdleTimeout, logger, options)
+				} else if session.lifetimeExpired(now, options.UDPMaxSessionLifetime) {
+					logger.Printf("Closing UDP session %s -> %s: max session lifetime reached", addr, targetAddr)
+					closeUDPSession(sessions, addr, session, CloseMaxLifetime, logger, options)
+				}
 			}
 
-		case <-cleanupTicker.C:
+		case resolved := <-targetChanges:
 			for addr, session := range sessions {
-				if time.Since(session.lastActive) > 60*time.Second {
-					close(session.outbound)
-					session.remoteConn.Close()
-					delete(sessions, addr)
-					logger.Printf("Closed idle UDP session for %s", addr)
+				if session.remoteConn.RemoteAddr().String() != resolved.String() {
+					migrateUDPSession(sessions, addr, session, resolved, targetAddr, responder, logger, sessionEvents, options)
 				}
 			}
 
 		case event := <-sessionEvents:
 			if session, ok := sessions[event.key]; ok {
-				close(session.outbound)
-				session.remoteConn.Close()
-				delete(sessions, event.key)
-				logger.Printf("Closed UDP session for %s due to %s", event.key, event.reason)
+				if event.session != nil && event.session != session {
+					continue
+				}
+				closeUDPSession(sessions, event.key, session, event.reason, logger, options)
 			}
 		}
 	}
 }
 
+// startUDPSession tracks a client whose backend socket is dialed and starts both relay goroutines.
+// sessionTarget is the target the session was dialed for, which differs from the route's targetAddr on a balanced route.
+func startUDPSession(sessions map[string]*udpSession, clientAddr net.Addr, remoteConn net.Conn, listenAddr, targetAddr, sessionTarget string, responder net.PacketConn, logger *log.Logger, sessionEvents chan sessionEvent, options Options, clock clock) *udpSession {
+	sessionKey := clientAddr.String()
+	session := &udpSession{
+		clientAddr: clientAddr,
+		remoteConn: remoteConn,
+		outbound:   make(chan []byte, 32),
+		createdAt:  clock.Now(),
+		clock:      clock,
+		id:         sessionKey,
+		stats:      options.stats,
+	}
+	session.lastActive.Store(session.createdAt.UnixNano())
+	sessions[sessionKey] = session
+	session.info = ConnectionInfo{
+		Route:    options.RouteID,
+		Protocol: "udp",
+		Client:   sessionKey,
+		Listen:   listenAddr,
+		Target:   sessionTarget,
+		Started:  session.createdAt,
+	}
+	session.stats.Opened()
+	if options.UDPEgressPool != nil {
+		if local, ok := remoteConn.LocalAddr().(*net.UDPAddr); ok {
+			logger.Printf("UDP session for %s egresses from %s to %s", sessionKey, local.IP, remoteConn.RemoteAddr())
+		}
+	}
+	options.SessionState.opened(options.RouteID, targetAddr, sessionKey, remoteConn.RemoteAddr().String())
+	runUDPSession(session, responder, logger, sessionEvents, options)
+	return session
+}
+
+// dialRestoredUpstream dials the upstream a returning client used before the restart, once; an error means there was none
+// or it failed, and the caller dials the route target as for any new client.
+func dialRestoredUpstream(restored map[string]string, key, targetAddr string, client net.Addr, logger *log.Logger, options Options) (net.Conn, error) {
+	upstream, ok := restored[key]
+	if !ok {
+		return nil, errNoRestoredUpstream
+	}
+	delete(restored, key)
+	remoteConn, err := dialSessionTarget(upstream, client, options)
+	if err != nil {
+		logger.Printf("Dialing restored upstream %s for UDP client %s failed: %v; dialing %s afresh", upstream, key, err, targetAddr)
+		return nil, err
+	}
+	logger.Printf("Resuming UDP client %s on its upstream from before the restart, %s", key, upstream)
+	return remoteConn, nil
+}
+
+// runUDPSession registers a tracked session and starts its relay goroutines.
+func runUDPSession(session *udpSession, responder net.PacketConn, logger *log.Logger, sessionEvents chan sessionEvent, options Options) {
+	session.info.ID = options.Registry.Register(session.info, killUDPSession(session, sessionEvents))
+	go forwardUDPPackets(session, logger, sessionEvents, options.LogLimiter)
+	go relayUDPReplies(session, responder, logger, sessionEvents, udpReplyReadTimeout, options.UDPBatchReplies)
+}
+
+// touch records client activity at the clock's current time.
+func (session *udpSession) touch() {
+	session.lastActive.Store(session.clock.Now().UnixNano())
+}
+
+// idleExpired reports whether the client has been quiet for longer than udpSessionIdleTimeout at now.
+func (session *udpSession) idleExpired(now time.Time) bool {
+	return udpClientIdle(time.Unix(0, session.lastActive.Load()), now, udpSessionIdleTimeout)
+}
+
+// lifetimeExpired reports whether the session has existed for maxLifetime at now, however busy it is; zero never expires.
+func (session *udpSession) lifetimeExpired(now time.Time, maxLifetime time.Duration) bool {
+	return maxLifetime > 0 && now.Sub(session.createdAt) >= maxLifetime
+}
+
+// udpClientIdle is the one idle rule for UDP sessions, used by the manager's reaper and by the reply relay alike.
+// Only the client's packets count: a backend may stay silent for any length of time while its client keeps sending,
+// and backend replies alone never keep a session open. Exactly idleTimeout still counts as active, so a client sending on the boundary stays.
+func udpClientIdle(lastActive, now time.Time, idleTimeout time.Duration) bool {
+	return now.Sub(lastActive) > idleTimeout
+}
+
+// queueUDPPayload hands a datagram to the session's sender, dropping it when the sender is backed up.
+func queueUDPPayload(session *udpSession, data []byte, logger *log.Logger, limiter *logging.RateLimiter) {
+	select {
+	case session.outbound <- data:
+	default:
+		limiter.Printf(logger, "udp session queue "+session.info.Listen, "Dropping UDP packet for %s due to full queue", session.clientAddr.String())
+		session.stats.Dropped(metrics.DropQueueFull)
+	}
+}
+
+// closeUDPSession releases the session sockets and forgets it everywhere it was tracked.
+// Closing the outbound channel and remote socket ends both relay goroutines.
+func closeUDPSession(sessions map[string]*udpSession, key string, session *udpSession, reason CloseReason, logger *log.Logger, options Options) {
+	close(session.outbound)
+	session.remoteConn.Close()
+	delete(sessions, key)
+	options.Registry.Unregister(session.info.ID)
+	options.SessionState.closed(options.RouteID, key)
+	logger.Printf("Closed UDP session for %s (%s)", key, reason)
+	options.Observer.closed(session.info, reason)
+	session.stats.Closed(string(reason))
+}
+
+// shedUDPSession drops the first packet of a client that arrived while the route was at its session limit.
+func shedUDPSession(key, listenAddr, targetAddr string, logger *log.Logger, options Options) {
+	options.LogLimiter.Printf(logger, "udp limit "+listenAddr, "Dropping UDP packet for %s: session limit reached", key)
+	options.stats.Dropped(metrics.DropLimit)
+	options.Observer.closed(ConnectionInfo{
+		Route:    options.RouteID,
+		Protocol: "udp",
+		Client:   key,
+		Listen:   listenAddr,
+		Target:   targetAddr,
+		Started:  time.Now(),
+	}, CloseLimitShed)
+}
+
+// killUDPSession builds the registry close handle for a session.
+// The manager still performs the teardown so the session map keeps a single owner.
+func killUDPSession(session *udpSession, sessionEvents chan<- sessionEvent) func() {
+	return func() {
+		sessionEvents <- sessionEvent{key: session.id, reason: CloseManualKill, session: session}
+	}
+}
+
 // forwardUDPPackets pushes outbound payloads to the remote endpoint.
 // Using a buffered channel keeps the hot path non-blocking when bursts happen.
-func forwardUDPPackets(session *udpSession, logger *log.Logger, sessionEvents chan<- sessionEvent) {
+// A datagram above the path MTU is dropped on its own, because smaller ones from the same client still fit.
+func forwardUDPPackets(session *udpSession, logger *log.Logger, sessionEvents chan<- sessionEvent, limiter *logging.RateLimiter) {
 	for data := range session.outbound {
 		_ = session.remoteConn.SetWriteDeadline(time.Now().Add(2 * time.Second))
-		if _, err := session.remoteConn.Write(data); err != nil {
+		_, err := session.remoteConn.Write(data)
+		if errors.Is(err, syscall.EMSGSIZE) {
+			target := session.remoteConn.RemoteAddr().String()
+			limiter.Printf(logger, "udp mtu "+target, "Dropping %d-byte UDP payload from %s: it exceeds the path MTU%s to %s", len(data), session.clientAddr, describePathMTU(session.remoteConn), target)
+			continue
+		}
+		if isPeerReset(err) {
+			// Only a bridged TCP backend breaks or resets; the session ends without an error line like a TCP flow would.
+			notifyUDPSessionFailure(session, CloseServerReset, sessionEvents, logger)
+			return
+		}
+		if err != nil {
 			logger.Printf("Error sending UDP payload for %s: %v", session.clientAddr.String(), err)
-			notifyUDPSessionFailure(session, "write failure", sessionEvents, logger)
+			notifyUDPSessionFailure(session, CloseError, sessionEvents, logger)
 			return
 		}
+		session.stats.AddBytes("client", len(data))
 	}
 }
 
 // relayUDPReplies reads replies from the remote server and writes them back to the originating client.
-// A read deadline prevents stuck goroutines when remotes stay silent.
-func relayUDPReplies(session *udpSession, responder net.PacketConn, logger *log.Logger, sessionEvents chan<- sessionEvent) {
+// Each read gives up after readTimeout so a silent remote cannot hold the goroutine past the session's idle limit.
+// With batchSize above one, replies are gathered and sent batchSize at a time, or once the oldest has waited udpReplyBatchWindow.
+func relayUDPReplies(session *udpSession, responder net.PacketConn, logger *log.Logger, sessionEvents chan<- sessionEvent, readTimeout time.Duration, batchSize int) {
 	replyBuf := make([]byte, 64*1024)
+	batch := newUDPReplyBatch(newUDPBatchSender(responder, session.clientAddr), batchSize)
+	// sendFailed ends the session after a failed reply write; a client that went away is a reset, not an error line.
+	sendFailed := func(err error) {
+		if isPeerReset(err) {
+			notifyUDPSessionFailure(session, CloseClientReset, sessionEvents, logger)
+			return
+		}
+		logger.Printf("Error writing UDP reply to %s: %v", session.clientAddr.String(), err)
+		notifyUDPSessionFailure(session, CloseError, sessionEvents, logger)
+	}
+	// flush sends the pending batch, reporting false once the session has failed.
+	flush := func() bool {
+		bytes, err := batch.flush()
+		session.stats.AddBytes("server", bytes)
+		if err != nil {
+			sendFailed(err)
+			return false
+		}
+		return true
+	}
 	for {
-		_ = session.remoteConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		deadline := time.Now().Add(readTimeout)
+		if batch.pending() {
+			deadline = batch.deadline()
+		}
+		_ = session.remoteConn.SetReadDeadline(deadline)
 		n, err := session.remoteConn.Read(replyBuf)
+		if err != nil && batch.pending() {
+			// Replies already read still reach the client, whether the window ran out or the backend went away.
+			if !flush() {
+				return
+			}
+		}
 		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			// The remote can stay silent for a while, but the client may still be active.
-			// Keep listening as long as the session shows recent activity so replies are not dropped.
-			if time.Since(session.lastActive) < 60*time.Second {
+			// A silent remote is not a reason to stop: while the client keeps sending, replies may still come.
+			if !session.idleExpired(session.clock.Now()) {
 				continue
 			}
-			notifyUDPSessionFailure(session, "remote idle timeout", sessionEvents, logger)
+			notifyUDPSessionFailure(session, CloseIdleTimeout, sessionEvents, logger)
+			return
+		}
+		if err == io.EOF {
+			// Only a bridged TCP backend can end its stream; datagram sockets never report EOF.
+			notifyUDPSessionFailure(session, CloseServerEOF, sessionEvents, logger)
+			return
+		}
+		if isPeerReset(err) {
+			notifyUDPSessionFailure(session, CloseServerReset, sessionEvents, logger)
 			return
 		}
 		if err != nil {
 			logger.Printf("Error reading UDP reply for %s: %v", session.clientAddr.String(), err)
-			notifyUDPSessionFailure(session, "read failure", sessionEvents, logger)
+			notifyUDPSessionFailure(session, CloseError, sessionEvents, logger)
 			return
 		}
 
+		if batch != nil {
+			if batch.add(replyBuf[:n], time.Now()) && !flush() {
+				return
+			}
+			continue
+		}
 		if _, writeErr := responder.WriteTo(replyBuf[:n], session.clientAddr); writeErr != nil {
-			logger.Printf("Error writing UDP reply to %s: %v", session.clientAddr.String(), writeErr)
-			notifyUDPSessionFailure(session, "respond failure", sessionEvents, logger)
+			sendFailed(writeErr)
 			return
 		}
+		session.stats.AddBytes("server", n)
 	}
 }
 
 // notifyUDPSessionFailure reports a session failure without blocking the failing goroutine.
 // A buffered event channel ensures the manager can clean up even under bursty conditions.
-func notifyUDPSessionFailure(session *udpSession, reason string, sessionEvents chan<- sessionEvent, logger *log.Logger) {
+// The event is pinned to the session, so the relays of a migrated or replaced session cannot close its successor.
+func notifyUDPSessionFailure(session *udpSession, reason CloseReason, sessionEvents chan<- sessionEvent, logger *log.Logger) {
 	select {
-	case sessionEvents <- sessionEvent{key: session.id, reason: reason}:
+	case sessionEvents <- sessionEvent{key: session.id, reason: reason, session: session}:
 	default:
 		logger.Printf("Session event queue full; leaking UDP session %s due to %s", session.clientAddr.String(), reason)
 	}
@@ -0,0 +1,61 @@
+// Reply batching collects the replies a UDP session gets in quick succession and sends them to the client with one sendmmsg call.
+// Telemetry reflectors answer with bursts of tiny datagrams, where the syscall per reply costs more than the bytes.
+package proxy
+
+import "time"
+
+const (
+	// MaxUDPReplyBatch caps Options.UDPBatchReplies at the most datagrams one sendmmsg call takes (the kernel's UIO_MAXIOV).
+	MaxUDPReplyBatch = 1024
+	// udpReplyBatchWindow is the longest a reply waits for the rest of its batch, which bounds the latency batching adds.
+	udpReplyBatchWindow = 500 * time.Microsecond
+)
+
+// udpReplyBatch holds replies for one client until the batch is full or its window has passed; only the session's reply relay touches it.
+type udpReplyBatch struct {
+	sender   *udpBatchSender
+	max      int
+	arena    []byte
+	payloads [][]byte
+	// started is when the oldest pending reply arrived; the batch is flushed udpReplyBatchWindow after it.
+	started time.Time
+}
+
+// newUDPReplyBatch returns nil, so replies go out one WriteTo each, when size asks for no batching or the responder cannot send batches.
+func newUDPReplyBatch(sender *udpBatchSender, size int) *udpReplyBatch {
+	if size < 2 || sender == nil {
+		return nil
+	}
+	return &udpReplyBatch{sender: sender, max: size, arena: make([]byte, 0, 64*1024)}
+}
+
+func (batch *udpReplyBatch) pending() bool {
+	return batch != nil && len(batch.payloads) > 0
+}
+
+// add copies a reply into the batch and reports whether the batch is now full.
+func (batch *udpReplyBatch) add(payload []byte, now time.Time) bool {
+	if len(batch.payloads) == 0 {
+		batch.started = now
+	}
+	start := len(batch.arena)
+	batch.arena = append(batch.arena, payload...)
+	batch.payloads = append(batch.payloads, batch.arena[start:len(batch.arena):len(batch.arena)])
+	return len(batch.payloads) >= batch.max
+}
+
+// deadline is when the pending replies must go out even if no more arrive.
+func (batch *udpReplyBatch) deadline() time.Time {
+	return batch.started.Add(udpReplyBatchWindow)
+}
+
+// flush sends the pending replies and empties the batch; bytes is the payload size of the replies that were sent.
+func (batch *udpReplyBatch) flush() (int, error) {
+	sent, err := batch.sender.send(batch.payloads)
+	bytes := 0
+	for _, payload := range batch.payloads[:sent] {
+		bytes += len(payload)
+	}
+	batch.arena, batch.payloads = batch.arena[:0], batch.payloads[:0]
+	return bytes, err
+}
@@ -0,0 +1,131 @@
+//go:build linux
+// +build linux
+
+// sendmmsg hands the kernel several datagrams in one call, each with its own length, all to the same client here.
+// The listening socket is non-blocking under Go's poller, so a full send buffer waits for writability instead of failing.
+package proxy
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// UDPBatchRepliesSupported reports whether Options.UDPBatchReplies takes effect on this platform.
+const UDPBatchRepliesSupported = true
+
+// mmsghdr mirrors struct mmsghdr; Go pads it to the C size on every architecture.
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+// udpBatchSender sends datagrams from one listening socket to one client with sendmmsg.
+type udpBatchSender struct {
+	raw     syscall.RawConn
+	inet4   syscall.RawSockaddrInet4
+	inet6   syscall.RawSockaddrInet6
+	is6     bool
+	headers []mmsghdr
+	iovecs  []syscall.Iovec
+	// calls counts sendmmsg calls, so the benchmark can report syscalls per reply.
+	calls int
+}
+
+// newUDPBatchSender returns nil when the responder or client address is not a plain UDP socket and address,
+// for example in tests or for a client with an IPv6 zone, and the relay then writes replies one at a time.
+func newUDPBatchSender(responder net.PacketConn, client net.Addr) *udpBatchSender {
+	udpConn, ok := responder.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	addr, ok := client.(*net.UDPAddr)
+	if !ok || addr.Zone != "" {
+		return nil
+	}
+	raw, err := udpConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var local syscall.Sockaddr
+	var nameErr error
+	if err := raw.Control(func(fd uintptr) {
+		local, nameErr = syscall.Getsockname(int(fd))
+	}); err != nil || nameErr != nil {
+		return nil
+	}
+
+	sender := &udpBatchSender{raw: raw}
+	switch local.(type) {
+	case *syscall.SockaddrInet4:
+		ip := addr.IP.To4()
+		if ip == nil {
+			return nil
+		}
+		sender.inet4.Family = syscall.AF_INET
+		copy(sender.inet4.Addr[:], ip)
+		binary.BigEndian.PutUint16((*[2]byte)(unsafe.Pointer(&sender.inet4.Port))[:], uint16(addr.Port))
+	case *syscall.SockaddrInet6:
+		// A dual-stack socket reaches IPv4 clients through their IPv4-mapped address, as WriteTo does.
+		sender.is6 = true
+		sender.inet6.Family = syscall.AF_INET6
+		copy(sender.inet6.Addr[:], addr.IP.To16())
+		binary.BigEndian.PutUint16((*[2]byte)(unsafe.Pointer(&sender.inet6.Port))[:], uint16(addr.Port))
+	default:
+		return nil
+	}
+	return sender
+}
+
+// send passes payloads to the kernel in as few sendmmsg calls as it accepts and returns how many went out.
+func (sender *udpBatchSender) send(payloads [][]byte) (int, error) {
+	if len(payloads) == 0 {
+		return 0, nil
+	}
+	if cap(sender.headers) < len(payloads) {
+		sender.headers = make([]mmsghdr, len(payloads))
+		sender.iovecs = make([]syscall.Iovec, len(payloads))
+	}
+	headers, iovecs := sender.headers[:len(payloads)], sender.iovecs[:len(payloads)]
+	name, nameLen := (*byte)(unsafe.Pointer(&sender.inet4)), uint32(syscall.SizeofSockaddrInet4)
+	if sender.is6 {
+		name, nameLen = (*byte)(unsafe.Pointer(&sender.inet6)), uint32(syscall.SizeofSockaddrInet6)
+	}
+	for i, payload := range payloads {
+		iovecs[i] = syscall.Iovec{}
+		if len(payload) > 0 {
+			iovecs[i].Base = &payload[0]
+		}
+		iovecs[i].SetLen(len(payload))
+		headers[i] = mmsghdr{}
+		headers[i].hdr.Name, headers[i].hdr.Namelen = name, nameLen
+		headers[i].hdr.Iov = &iovecs[i]
+		headers[i].hdr.Iovlen = 1
+	}
+
+	sent := 0
+	var sendErr error
+	err := sender.raw.Write(func(fd uintptr) bool {
+		for sent < len(headers) {
+			n, _, errno := syscall.Syscall6(sysSendmmsg, fd, uintptr(unsafe.Pointer(&headers[sent])), uintptr(len(headers)-sent), 0, 0, 0)
+			sender.calls++
+			switch errno {
+			case 0:
+				sent += int(n)
+			case syscall.EINTR:
+			case syscall.EAGAIN:
+				return false
+			default:
+				sendErr = os.NewSyscallError("sendmmsg", errno)
+				return true
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return sent, err
+	}
+	return sent, sendErr
+}
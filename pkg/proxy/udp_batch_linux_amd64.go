@@ -0,0 +1,4 @@
+// The syscall package leaves out sendmmsg on amd64, so its number is spelled out here.
+package proxy
+
+const sysSendmmsg = 307
@@ -0,0 +1,9 @@
+//go:build linux && !amd64 && !386
+// +build linux,!amd64,!386
+
+// Every other Linux architecture gets the sendmmsg number from the syscall package.
+package proxy
+
+import "syscall"
+
+const sysSendmmsg = syscall.SYS_SENDMMSG
@@ -0,0 +1,121 @@
+//go:build linux
+// +build linux
+
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRelayUDPRepliesSendsBatchedRepliesToTheClient(t *testing.T) {
+	// The dual-stack socket reaches the IPv4 client through its IPv4-mapped address.
+	for name, listen := range map[string]string{"ipv4": "127.0.0.1:0", "dual-stack": "[::]:0"} {
+		t.Run(name, func(t *testing.T) {
+			responder, err := net.ListenPacket("udp", listen)
+			if err != nil {
+				t.Fatalf("net.ListenPacket returned error: %v", err)
+			}
+			defer responder.Close()
+			client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("net.ListenPacket returned error: %v", err)
+			}
+			defer client.Close()
+			backend, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("net.ListenPacket returned error: %v", err)
+			}
+			defer backend.Close()
+			remoteConn, err := net.Dial("udp4", backend.LocalAddr().String())
+			if err != nil {
+				t.Fatalf("net.Dial returned error: %v", err)
+			}
+			defer remoteConn.Close()
+
+			if newUDPBatchSender(responder, client.LocalAddr()) == nil {
+				t.Fatal("no sendmmsg sender for a plain UDP socket")
+			}
+			session := &udpSession{clientAddr: client.LocalAddr(), remoteConn: remoteConn, clock: realClock{}}
+			session.touch()
+			go relayUDPReplies(session, responder, log.New(io.Discard, "", 0), make(chan sessionEvent, 1), time.Second, 4)
+
+			// Five replies fill one batch of four, and the fifth goes out when the window ends.
+			for i := 0; i < 5; i++ {
+				if _, err := backend.WriteTo([]byte(fmt.Sprintf("reply %d", i)), remoteConn.LocalAddr()); err != nil {
+					t.Fatalf("WriteTo returned error: %v", err)
+				}
+			}
+			buffer := make([]byte, 64)
+			for i := 0; i < 5; i++ {
+				_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+				n, _, err := client.ReadFrom(buffer)
+				if err != nil {
+					t.Fatalf("client read %d returned error: %v", i, err)
+				}
+				if want := fmt.Sprintf("reply %d", i); string(buffer[:n]) != want {
+					t.Fatalf("client read %q, want %q", buffer[:n], want)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkUDPReplies sends small replies from a listening socket to one client, one WriteTo each and in sendmmsg batches,
+// and reports how many syscalls each reply cost.
+func BenchmarkUDPReplies(b *testing.B) {
+	for _, size := range []int{1, 16, 64} {
+		name := "single"
+		if size > 1 {
+			name = fmt.Sprintf("batch-%d", size)
+		}
+		b.Run(name, func(b *testing.B) {
+			responder, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				b.Fatalf("net.ListenPacket returned error: %v", err)
+			}
+			defer responder.Close()
+			client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				b.Fatalf("net.ListenPacket returned error: %v", err)
+			}
+			defer client.Close()
+			go func() {
+				buffer := make([]byte, 64)
+				for {
+					if _, _, err := client.ReadFrom(buffer); err != nil {
+						return
+					}
+				}
+			}()
+
+			payload := []byte("telemetry reply")
+			sender := newUDPBatchSender(responder, client.LocalAddr())
+			batch := newUDPReplyBatch(sender, size)
+			calls := 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if batch == nil {
+					if _, err := responder.WriteTo(payload, client.LocalAddr()); err != nil {
+						b.Fatalf("WriteTo returned error: %v", err)
+					}
+					calls++
+					continue
+				}
+				if batch.add(payload, time.Now()) || i == b.N-1 {
+					if _, err := batch.flush(); err != nil {
+						b.Fatalf("flush returned error: %v", err)
+					}
+				}
+			}
+			if sender != nil && batch != nil {
+				calls = sender.calls
+			}
+			b.ReportMetric(float64(calls)/float64(b.N), "syscalls/reply")
+		})
+	}
+}
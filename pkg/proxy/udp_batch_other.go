@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+// sendmmsg is Linux only, so elsewhere every UDP reply goes out with its own WriteTo.
+// main warns that -udp-batch-replies is ignored here.
+package proxy
+
+import (
+	"errors"
+	"net"
+)
+
+// UDPBatchRepliesSupported reports whether Options.UDPBatchReplies takes effect on this platform.
+const UDPBatchRepliesSupported = false
+
+// udpBatchSender cannot be built on this platform; the type exists so the relay compiles the same everywhere.
+type udpBatchSender struct {
+	calls int
+}
+
+func newUDPBatchSender(net.PacketConn, net.Addr) *udpBatchSender {
+	return nil
+}
+
+func (*udpBatchSender) send([][]byte) (int, error) {
+	return 0, errors.New("batched UDP replies need Linux")
+}
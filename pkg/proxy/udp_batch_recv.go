@@ -0,0 +1,9 @@
+// Receive batching reads several client datagrams from a UDP listener with one recvmmsg call, before the usual per-datagram checks.
+// Each datagram is still copied out of the batch buffers and dispatched on its own, because those buffers are reused by the next call.
+package proxy
+
+// MaxUDPRecvBatch caps Options.UDPBatchRecv at the most datagrams one recvmmsg call fills (the kernel's UIO_MAXIOV).
+const MaxUDPRecvBatch = 1024
+
+// udpRecvBufferSize fits the largest UDP payload, so a batched read never truncates a datagram that ReadFrom would have read whole.
+const udpRecvBufferSize = 64 * 1024
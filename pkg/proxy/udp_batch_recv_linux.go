@@ -0,0 +1,112 @@
+//go:build linux
+// +build linux
+
+// recvmmsg fills several buffers from a UDP socket in one call and reports each datagram's length and source address.
+// The socket is non-blocking under Go's poller, so an empty queue parks the reader until the socket is readable again.
+package proxy
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// UDPBatchRecvSupported reports whether Options.UDPBatchRecv takes effect on this platform.
+const UDPBatchRecvSupported = true
+
+// udpBatchReceiver reads datagrams from one listening socket with recvmmsg; only the route's read loop uses it.
+type udpBatchReceiver struct {
+	raw     syscall.RawConn
+	buffers [][]byte
+	names   []syscall.RawSockaddrAny
+	headers []mmsghdr
+	iovecs  []syscall.Iovec
+	// calls counts recvmmsg calls, so the benchmark can report syscalls per datagram.
+	calls int
+}
+
+// newUDPBatchReceiver returns nil when size asks for no batching or conn is not a plain UDP socket, and the read loop then uses ReadFrom.
+// Every slot holds a full 64 KiB buffer, so a receiver costs size times that much memory for as long as the route runs.
+func newUDPBatchReceiver(conn net.PacketConn, size int) *udpBatchReceiver {
+	udpConn, ok := conn.(*net.UDPConn)
+	if size < 2 || !ok {
+		return nil
+	}
+	raw, err := udpConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	receiver := &udpBatchReceiver{
+		raw:     raw,
+		buffers: make([][]byte, size),
+		names:   make([]syscall.RawSockaddrAny, size),
+		headers: make([]mmsghdr, size),
+		iovecs:  make([]syscall.Iovec, size),
+	}
+	for i := range receiver.buffers {
+		receiver.buffers[i] = make([]byte, udpRecvBufferSize)
+		receiver.iovecs[i].Base = &receiver.buffers[i][0]
+		receiver.iovecs[i].SetLen(udpRecvBufferSize)
+	}
+	return receiver
+}
+
+// receive waits for at least one datagram and returns how many slots it filled; datagram reads them until the next call.
+func (receiver *udpBatchReceiver) receive() (int, error) {
+	for i := range receiver.headers {
+		receiver.headers[i] = mmsghdr{}
+		receiver.headers[i].hdr.Name = (*byte)(unsafe.Pointer(&receiver.names[i]))
+		receiver.headers[i].hdr.Namelen = syscall.SizeofSockaddrAny
+		receiver.headers[i].hdr.Iov = &receiver.iovecs[i]
+		receiver.headers[i].hdr.Iovlen = 1
+	}
+	received := 0
+	var recvErr error
+	err := receiver.raw.Read(func(fd uintptr) bool {
+		for {
+			n, _, errno := syscall.Syscall6(syscall.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&receiver.headers[0])), uintptr(len(receiver.headers)), 0, 0, 0)
+			receiver.calls++
+			switch errno {
+			case 0:
+				received = int(n)
+				return true
+			case syscall.EINTR:
+			case syscall.EAGAIN:
+				return false
+			default:
+				recvErr = os.NewSyscallError("recvmmsg", errno)
+				return true
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return received, recvErr
+}
+
+// datagram returns the payload and source of slot i from the last receive; the payload is only valid until the next receive.
+func (receiver *udpBatchReceiver) datagram(i int) ([]byte, net.Addr) {
+	payload := receiver.buffers[i][:receiver.headers[i].len]
+	name := &receiver.names[i]
+	switch name.Addr.Family {
+	case syscall.AF_INET:
+		inet4 := (*syscall.RawSockaddrInet4)(unsafe.Pointer(name))
+		port := binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&inet4.Port))[:])
+		return payload, &net.UDPAddr{IP: net.IPv4(inet4.Addr[0], inet4.Addr[1], inet4.Addr[2], inet4.Addr[3]), Port: int(port)}
+	case syscall.AF_INET6:
+		inet6 := (*syscall.RawSockaddrInet6)(unsafe.Pointer(name))
+		port := binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&inet6.Port))[:])
+		addr := &net.UDPAddr{IP: make(net.IP, net.IPv6len), Port: int(port)}
+		copy(addr.IP, inet6.Addr[:])
+		if inet6.Scope_id != 0 {
+			if iface, err := net.InterfaceByIndex(int(inet6.Scope_id)); err == nil {
+				addr.Zone = iface.Name
+			}
+		}
+		return payload, addr
+	}
+	return payload, nil
+}
@@ -0,0 +1,181 @@
+//go:build linux
+// +build linux
+
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+func TestUDPBatchReceiverReportsEachDatagramAndSource(t *testing.T) {
+	for name, listen := range map[string]string{"ipv4": "127.0.0.1:0", "dual-stack": "[::]:0"} {
+		t.Run(name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", listen)
+			if err != nil {
+				t.Fatalf("net.ListenPacket returned error: %v", err)
+			}
+			defer conn.Close()
+			receiver := newUDPBatchReceiver(conn, 8)
+			if receiver == nil {
+				t.Fatal("no recvmmsg receiver for a plain UDP socket")
+			}
+			client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("net.ListenPacket returned error: %v", err)
+			}
+			defer client.Close()
+			target := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: conn.LocalAddr().(*net.UDPAddr).Port}
+			for i := 0; i < 3; i++ {
+				if _, err := client.WriteTo([]byte(fmt.Sprintf("datagram %d", i)), target); err != nil {
+					t.Fatalf("WriteTo returned error: %v", err)
+				}
+			}
+			// Loopback queues all three before the first read returns, but a slow kernel may split them over two calls.
+			time.Sleep(10 * time.Millisecond)
+
+			var got []string
+			for len(got) < 3 {
+				received, err := receiver.receive()
+				if err != nil {
+					t.Fatalf("receive returned error: %v", err)
+				}
+				for i := 0; i < received; i++ {
+					payload, addr := receiver.datagram(i)
+					if addr.String() != client.LocalAddr().String() {
+						t.Fatalf("source = %s, want %s, the key ReadFrom would give the session", addr, client.LocalAddr())
+					}
+					got = append(got, string(payload))
+				}
+			}
+			if fmt.Sprint(got) != "[datagram 0 datagram 1 datagram 2]" {
+				t.Fatalf("datagrams = %q", got)
+			}
+		})
+	}
+}
+
+func TestServeUDPProxyForwardsBatchedReads(t *testing.T) {
+	backend, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		buffer := make([]byte, 64)
+		for {
+			n, addr, err := backend.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(buffer[:n], addr)
+		}
+	}()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	go ServeUDPProxy(conn, backend.LocalAddr().String(), config.AllowList{}, log.New(io.Discard, "", 0), Options{UDPBatchRecv: 4})
+	defer conn.Close()
+
+	client, err := net.Dial("udp4", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer client.Close()
+	// The burst lands in shared batch buffers that the next recvmmsg call overwrites, so every echo checks that its datagram was copied first.
+	for i := 0; i < 10; i++ {
+		if _, err := client.Write([]byte(fmt.Sprintf("ping %d", i))); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+	}
+	echoes := map[string]bool{}
+	buffer := make([]byte, 64)
+	for len(echoes) < 10 {
+		_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := client.Read(buffer)
+		if err != nil {
+			t.Fatalf("echo read returned error after %d distinct echoes: %v", len(echoes), err)
+		}
+		echoes[string(buffer[:n])] = true
+	}
+	for i := 0; i < 10; i++ {
+		if !echoes[fmt.Sprintf("ping %d", i)] {
+			t.Fatalf("echoes = %v, missing ping %d", echoes, i)
+		}
+	}
+}
+
+// BenchmarkUDPIngress reads small datagrams from a listening socket, one ReadFrom each and in recvmmsg batches,
+// and reports packets per second and syscalls per datagram. Only the reads are timed; the sender fills the queue in between.
+func BenchmarkUDPIngress(b *testing.B) {
+	const burst = 64
+	for _, size := range []int{1, 32} {
+		name := "single"
+		if size > 1 {
+			name = fmt.Sprintf("batch-%d", size)
+		}
+		b.Run(name, func(b *testing.B) {
+			conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				b.Fatalf("net.ListenPacket returned error: %v", err)
+			}
+			defer conn.Close()
+			client, err := net.Dial("udp4", conn.LocalAddr().String())
+			if err != nil {
+				b.Fatalf("net.Dial returned error: %v", err)
+			}
+			defer client.Close()
+			receiver := newUDPBatchReceiver(conn, size)
+			buffer := make([]byte, udpRecvBufferSize)
+			payload := []byte("telemetry sample")
+
+			calls := 0
+			var elapsed time.Duration
+			b.ResetTimer()
+			b.StopTimer()
+			for read := 0; read < b.N; {
+				queued := burst
+				if b.N-read < queued {
+					queued = b.N - read
+				}
+				for i := 0; i < queued; i++ {
+					if _, err := client.Write(payload); err != nil {
+						b.Fatalf("Write returned error: %v", err)
+					}
+				}
+				start := time.Now()
+				b.StartTimer()
+				for drained := 0; drained < queued; {
+					if receiver == nil {
+						if _, _, err := conn.ReadFrom(buffer); err != nil {
+							b.Fatalf("ReadFrom returned error: %v", err)
+						}
+						drained++
+						calls++
+						continue
+					}
+					received, err := receiver.receive()
+					if err != nil {
+						b.Fatalf("receive returned error: %v", err)
+					}
+					drained += received
+				}
+				b.StopTimer()
+				elapsed += time.Since(start)
+				read += queued
+			}
+			if receiver != nil {
+				calls = receiver.calls
+			}
+			b.ReportMetric(float64(b.N)/elapsed.Seconds(), "packets/s")
+			b.ReportMetric(float64(calls)/float64(b.N), "syscalls/packet")
+		})
+	}
+}
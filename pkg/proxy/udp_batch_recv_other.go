@@ -0,0 +1,31 @@
+//go:build !linux
+// +build !linux
+
+// recvmmsg is Linux only, so elsewhere the UDP read loop reads one datagram per ReadFrom.
+// main warns that -udp-batch-recv is ignored here.
+package proxy
+
+import (
+	"errors"
+	"net"
+)
+
+// UDPBatchRecvSupported reports whether Options.UDPBatchRecv takes effect on this platform.
+const UDPBatchRecvSupported = false
+
+// udpBatchReceiver cannot be built on this platform; the type exists so the read loop compiles the same everywhere.
+type udpBatchReceiver struct {
+	calls int
+}
+
+func newUDPBatchReceiver(net.PacketConn, int) *udpBatchReceiver {
+	return nil
+}
+
+func (*udpBatchReceiver) receive() (int, error) {
+	return 0, errors.New("batched UDP reads need Linux")
+}
+
+func (*udpBatchReceiver) datagram(int) ([]byte, net.Addr) {
+	return nil, nil
+}
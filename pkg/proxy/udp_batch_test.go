@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUDPReplyBatchNeedsASenderAndRoomForTwo(t *testing.T) {
+	if newUDPReplyBatch(&udpBatchSender{}, 1) != nil {
+		t.Fatal("a batch of one was built, want replies written one at a time")
+	}
+	if newUDPReplyBatch(nil, 16) != nil {
+		t.Fatal("a batch without a sender was built")
+	}
+}
+
+func TestUDPReplyBatchFillsAndKeepsEachReplyIntact(t *testing.T) {
+	batch := newUDPReplyBatch(&udpBatchSender{}, 3)
+	now := time.Unix(1700000000, 0)
+	buffer := []byte("one")
+	if batch.add(buffer, now) {
+		t.Fatal("batch reported full after one reply")
+	}
+	// The relay reuses its read buffer, so the batch must have copied the first reply.
+	copy(buffer, "two")
+	batch.add(buffer, now.Add(time.Millisecond))
+	if !batch.add([]byte("three"), now.Add(2*time.Millisecond)) {
+		t.Fatal("batch did not report full at its size")
+	}
+	if got := string(batch.payloads[0]) + "," + string(batch.payloads[1]) + "," + string(batch.payloads[2]); got != "one,two,three" {
+		t.Fatalf("payloads = %s", got)
+	}
+	if !batch.deadline().Equal(now.Add(udpReplyBatchWindow)) {
+		t.Fatalf("deadline = %s, want the window after the first reply", batch.deadline())
+	}
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when the test says so, and its ticker fires only when the test sends a tick.
+type fakeClock struct {
+	now   atomic.Int64
+	ticks chan time.Time
+}
+
+func newFakeClock(start time.Time) *fakeClock {
+	clock := &fakeClock{ticks: make(chan time.Time)}
+	clock.now.Store(start.UnixNano())
+	return clock
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return time.Unix(0, clock.now.Load())
+}
+
+func (clock *fakeClock) NewTicker(time.Duration) ticker {
+	return fakeTicker{clock.ticks}
+}
+
+func (clock *fakeClock) set(now time.Time) {
+	clock.now.Store(now.UnixNano())
+}
+
+// tick fires the ticker twice; the ticks channel is unbuffered, so the first tick is fully handled once the second is received.
+func (clock *fakeClock) tick() {
+	clock.ticks <- clock.Now()
+	clock.ticks <- clock.Now()
+}
+
+type fakeTicker struct {
+	ticks chan time.Time
+}
+
+func (ticker fakeTicker) C() <-chan time.Time {
+	return ticker.ticks
+}
+
+func (fakeTicker) Stop() {}
+
+func TestManageUDPSessionsReapsIdleSessionOnlyAfterTimeout(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer backend.Close()
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer responder.Close()
+
+	start := time.Unix(1700000000, 0)
+	clock := newFakeClock(start)
+	registry := NewRegistry()
+	reasons := make(chan CloseReason, 1)
+	msgChan := make(chan udpMessage, 1)
+	managerDone := make(chan struct{})
+	defer func() {
+		close(msgChan)
+		<-managerDone
+	}()
+	go func() {
+		defer close(managerDone)
+		manageUDPSessions(responder.LocalAddr().String(), backend.LocalAddr().String(), responder, log.New(io.Discard, "", 0), msgChan, Options{
+			Registry: registry,
+			Observer: func(_ ConnectionInfo, reason CloseReason) { reasons <- reason },
+		}, clock)
+	}()
+
+	msgChan <- udpMessage{data: []byte("ping"), addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40002}}
+	connections := waitForConnections(t, registry, 1)
+	if !connections[0].Started.Equal(start) {
+		t.Fatalf("session started at %v, want the clock's %v", connections[0].Started, start)
+	}
+
+	clock.set(start.Add(udpSessionIdleTimeout))
+	clock.tick()
+	select {
+	case reason := <-reasons:
+		t.Fatalf("session closed (%s) exactly at the idle timeout, want it kept", reason)
+	default:
+	}
+
+	clock.set(start.Add(udpSessionIdleTimeout + time.Nanosecond))
+	clock.tick()
+	select {
+	case reason := <-reasons:
+		if reason != CloseIdleTimeout {
+			t.Fatalf("session closed with %s, want %s", reason, CloseIdleTimeout)
+		}
+	default:
+		t.Fatal("session was not reaped after the idle timeout")
+	}
+}
+
+func TestUDPSessionIdleExpired(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := newFakeClock(start)
+	session := &udpSession{clock: clock}
+	session.touch()
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want bool
+	}{
+		{name: "just touched", now: start, want: false},
+		{name: "one reply timeout later", now: start.Add(udpReplyReadTimeout), want: false},
+		{name: "exactly at the idle timeout", now: start.Add(udpSessionIdleTimeout), want: false},
+		{name: "past the idle timeout", now: start.Add(udpSessionIdleTimeout + time.Nanosecond), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := session.idleExpired(tt.now); got != tt.want {
+				t.Fatalf("idleExpired(%v) = %v, want %v", tt.now.Sub(start), got, tt.want)
+			}
+		})
+	}
+
+	// A packet from the client moves the boundary forward, so the reply relay keeps reading.
+	clock.set(start.Add(udpSessionIdleTimeout))
+	session.touch()
+	if session.idleExpired(start.Add(udpSessionIdleTimeout + time.Nanosecond)) {
+		t.Fatal("idleExpired reported a freshly touched session as idle")
+	}
+}
+
+func TestManageUDPSessionsReapsBusySessionAtMaxLifetime(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer backend.Close()
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer responder.Close()
+
+	start := time.Unix(1700000000, 0)
+	clock := newFakeClock(start)
+	registry := NewRegistry()
+	reasons := make(chan CloseReason, 1)
+	lines := make(accessLines, 16)
+	msgChan := make(chan udpMessage)
+	managerDone := make(chan struct{})
+	defer func() {
+		close(msgChan)
+		<-managerDone
+	}()
+	go func() {
+		defer close(managerDone)
+		manageUDPSessions(responder.LocalAddr().String(), backend.LocalAddr().String(), responder, log.New(lines, "", 0), msgChan, Options{
+			Registry:              registry,
+			Observer:              func(_ ConnectionInfo, reason CloseReason) { reasons <- reason },
+			UDPMaxSessionLifetime: 2 * time.Minute,
+		}, clock)
+	}()
+
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40012}
+	msgChan <- udpMessage{data: []byte("ping"), addr: client}
+	waitForConnections(t, registry, 1)
+
+	// The client never goes idle, so only the lifetime can end the session.
+	for elapsed := 30 * time.Second; elapsed < 2*time.Minute; elapsed += 30 * time.Second {
+		clock.set(start.Add(elapsed))
+		msgChan <- udpMessage{data: []byte("ping"), addr: client}
+		clock.tick()
+		select {
+		case reason := <-reasons:
+			t.Fatalf("session closed (%s) after %s, before its lifetime", reason, elapsed)
+		default:
+		}
+	}
+
+	clock.set(start.Add(2 * time.Minute))
+	clock.tick()
+	select {
+	case reason := <-reasons:
+		if reason != CloseMaxLifetime {
+			t.Fatalf("session closed with %s, want %s", reason, CloseMaxLifetime)
+		}
+	default:
+		t.Fatal("session was not reaped at its max lifetime")
+	}
+	for {
+		if line := lines.wait(t); strings.Contains(line, "max session lifetime reached") {
+			break
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// UDP dial retries give a briefly unavailable backend a few more chances before a new client's packets are dropped.
+// The retries run in their own goroutine and report back to the session manager, which alone creates sessions.
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultUDPDialBackoff is the first retry delay; each further retry waits twice as long.
+	DefaultUDPDialBackoff = 100 * time.Millisecond
+	// maxPendingDialDatagrams matches the session send queue, so queued packets fit once the dial succeeds.
+	maxPendingDialDatagrams = 32
+)
+
+// udpDialResult carries a retried dial back to the session manager.
+type udpDialResult struct {
+	key        string
+	target     string // target is where the dial went, which on a balanced route is one of several.
+	clientAddr net.Addr
+	remoteConn net.Conn
+	err        error
+}
+
+// dialUDPTarget resolves and dials the backend from the next pool IP, if any; tests replace it to simulate a backend that comes up late.
+var dialUDPTarget = func(targetAddr string, pool *EgressPool) (*net.UDPConn, error) {
+	resolved, err := resolveUDPTarget(targetAddr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve: %v", err)
+	}
+	var local *net.UDPAddr
+	if source, ok := pool.pick(resolved.AddrPort().Addr().Unmap()); ok {
+		local = &net.UDPAddr{IP: source.AsSlice()}
+	}
+	return net.DialUDP("udp", local, resolved)
+}
+
+// retryUDPDial redials the backend with doubling delays and hands the outcome to the manager.
+// stop closes when the manager exits, so neither the wait nor the hand-off can outlive the route.
+func retryUDPDial(key string, clientAddr net.Addr, targetAddr string, options Options, results chan<- udpDialResult, stop <-chan struct{}) {
+	delay := options.UDPDialBackoff
+	if delay <= 0 {
+		delay = DefaultUDPDialBackoff
+	}
+
+	result := udpDialResult{key: key, target: targetAddr, clientAddr: clientAddr}
+	for attempt := 1; attempt <= options.UDPDialRetries; attempt++ {
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return
+		}
+		result.remoteConn, result.err = dialSessionTarget(targetAddr, clientAddr, options)
+		if result.err == nil {
+			break
+		}
+		options.stats.DialFailed()
+		result.err = fmt.Errorf("%d retries failed, last: %v", attempt, result.err)
+		delay *= 2
+	}
+
+	select {
+	case results <- result:
+	case <-stop:
+		if result.remoteConn != nil {
+			result.remoteConn.Close()
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestManageUDPSessionsDeliversQueuedPacketsAfterDelayedDial(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer backend.Close()
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer responder.Close()
+
+	// The first two dials fail as if the backend were still coming up.
+	realDial := dialUDPTarget
+	defer func() { dialUDPTarget = realDial }()
+	dials := make(chan struct{}, 2)
+	dials <- struct{}{}
+	dials <- struct{}{}
+	dialUDPTarget = func(targetAddr string, pool *EgressPool) (*net.UDPConn, error) {
+		select {
+		case <-dials:
+			return nil, errors.New("connection refused")
+		default:
+			return realDial(targetAddr, pool)
+		}
+	}
+
+	msgChan := make(chan udpMessage, 2)
+	managerDone := make(chan struct{})
+	// The manager must be gone before the deferred restore of dialUDPTarget runs.
+	defer func() {
+		close(msgChan)
+		<-managerDone
+	}()
+	go func() {
+		defer close(managerDone)
+		manageUDPSessions(responder.LocalAddr().String(), backend.LocalAddr().String(), responder, log.New(io.Discard, "", 0), msgChan, Options{
+			UDPDialRetries: 3,
+			UDPDialBackoff: 10 * time.Millisecond,
+		}, realClock{})
+	}()
+
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40001}
+	msgChan <- udpMessage{data: []byte("first"), addr: client}
+	msgChan <- udpMessage{data: []byte("second"), addr: client}
+
+	buffer := make([]byte, 64)
+	for _, want := range []string{"first", "second"} {
+		_ = backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := backend.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("backend did not receive %q: %v", want, err)
+		}
+		if got := string(buffer[:n]); got != want {
+			t.Fatalf("backend received %q, want %q", got, want)
+		}
+	}
+}
+
+func TestRetryUDPDialGivesUpAfterRetries(t *testing.T) {
+	realDial := dialUDPTarget
+	defer func() { dialUDPTarget = realDial }()
+	attempts := 0
+	dialUDPTarget = func(string, *EgressPool) (*net.UDPConn, error) {
+		attempts++
+		return nil, errors.New("connection refused")
+	}
+
+	results := make(chan udpDialResult, 1)
+	retryUDPDial("client", nil, "192.0.2.1:53", Options{UDPDialRetries: 2, UDPDialBackoff: time.Millisecond}, results, make(chan struct{}))
+	result := <-results
+	if result.err == nil || attempts != 2 {
+		t.Fatalf("retryUDPDial = %v after %d attempts, want an error after 2", result.err, attempts)
+	}
+}
@@ -0,0 +1,47 @@
+// UDP health checks let a UDP route leave dead targets out of its rotation and fail over to its backups, as TCP routes do.
+// UDP has no handshake to probe, so a target is down when it refuses datagrams or, with a synthetic check, stops answering as expected.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// udpRouteProbe returns the probe for a UDP route's targets, dialed like a session so egress pools, policies, and bridges are covered.
+// A synthetic check sends its request and waits for the reply. Without one an empty datagram is sent, and only a refusal fails it:
+// the ICMP port unreachable a host returns when nothing listens, which a silent or firewalled backend never sends.
+func udpRouteProbe(check *SyntheticCheck, options Options) func(string) error {
+	timeout := healthCheckTimeout
+	if check != nil {
+		timeout = options.SyntheticCheckTimeout
+		if timeout <= 0 {
+			timeout = DefaultSyntheticCheckTimeout
+		}
+	}
+	return func(address string) error {
+		conn, err := dialSessionTarget(address, nil, options)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+		if check == nil {
+			if _, err := conn.Write(nil); err != nil {
+				return err
+			}
+			// A reply, or silence until the deadline, both mean nothing refused the datagram.
+			if _, err := conn.Read(make([]byte, maxBridgeFrame)); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+				return err
+			}
+			return nil
+		}
+		if _, err := conn.Write(check.Send); err != nil {
+			return fmt.Errorf("sending synthetic check: %v", err)
+		}
+		return expectReply(conn, check.Expect)
+	}
+}
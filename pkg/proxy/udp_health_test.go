@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startUDPResponder answers every datagram with reply, so probes of a live target return without waiting out their deadline.
+func startUDPResponder(t *testing.T, reply func([]byte) []byte) string {
+	t.Helper()
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			n, addr, err := backend.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(reply(buffer[:n]), addr)
+		}
+	}()
+	return backend.LocalAddr().String()
+}
+
+func TestUDPRouteProbeFailsOnlyWhenTheTargetRefuses(t *testing.T) {
+	live := startUDPResponder(t, func([]byte) []byte { return []byte("ok") })
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	deadAddr := closed.LocalAddr().String()
+	closed.Close()
+
+	probe := udpRouteProbe(nil, Options{})
+	if err := probe(live); err != nil {
+		t.Fatalf("probe of a listening target returned error: %v", err)
+	}
+	if err := probe(deadAddr); err == nil {
+		t.Fatal("probe of a closed port passed, want the refusal reported")
+	}
+}
+
+func TestUDPRouteProbeRunsTheSyntheticCheck(t *testing.T) {
+	backend := startUDPResponder(t, func(request []byte) []byte {
+		if string(request) == "PING" {
+			return []byte("PONG")
+		}
+		return []byte("ERR")
+	})
+
+	if err := udpRouteProbe(&SyntheticCheck{Send: []byte("PING"), Expect: []byte("PONG")}, Options{})(backend); err != nil {
+		t.Fatalf("synthetic probe returned error: %v", err)
+	}
+	err := udpRouteProbe(&SyntheticCheck{Send: []byte("STATUS"), Expect: []byte("PONG")}, Options{SyntheticCheckTimeout: time.Second})(backend)
+	if err == nil || !strings.Contains(err.Error(), `expected "PONG", got "ERR"`) {
+		t.Fatalf("synthetic probe with the wrong reply returned %v", err)
+	}
+}
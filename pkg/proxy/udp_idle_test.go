@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+// silentUDPSession builds a session whose backend socket is connected to a peer that never answers.
+func silentUDPSession(t *testing.T, clock clock) *udpSession {
+	t.Helper()
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	remoteConn, err := net.Dial("udp", backend.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	t.Cleanup(func() { remoteConn.Close() })
+
+	session := &udpSession{
+		clientAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40010},
+		remoteConn: remoteConn,
+		clock:      clock,
+		id:         "127.0.0.1:40010",
+	}
+	session.touch()
+	return session
+}
+
+func TestUDPClientIdleCountsOnlyTheQuietTime(t *testing.T) {
+	lastActive := time.Unix(1700000000, 0)
+	if udpClientIdle(lastActive, lastActive.Add(time.Minute), time.Minute) {
+		t.Fatal("client quiet for exactly the idle timeout counted as idle")
+	}
+	if !udpClientIdle(lastActive, lastActive.Add(time.Minute+time.Nanosecond), time.Minute) {
+		t.Fatal("client quiet past the idle timeout counted as active")
+	}
+}
+
+func TestRelayUDPRepliesOutlastsSilentRemoteWhileClientSends(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := newFakeClock(start)
+	session := silentUDPSession(t, clock)
+	events := make(chan sessionEvent, 1)
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer responder.Close()
+	go relayUDPReplies(session, responder, log.New(io.Discard, "", 0), events, 2*time.Millisecond, 0)
+
+	// Ten minutes of backend silence, with a client packet every 30 seconds and several read timeouts in each step.
+	for elapsed := 30 * time.Second; elapsed <= 10*time.Minute; elapsed += 30 * time.Second {
+		clock.set(start.Add(elapsed))
+		session.touch()
+		time.Sleep(10 * time.Millisecond)
+		select {
+		case event := <-events:
+			t.Fatalf("relay ended the session (%s) after %s of remote silence while the client was sending", event.reason, elapsed)
+		default:
+		}
+	}
+}
+
+func TestRelayUDPRepliesEndsSessionOnceClientGoesQuiet(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := newFakeClock(start)
+	session := silentUDPSession(t, clock)
+	events := make(chan sessionEvent, 1)
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer responder.Close()
+	go relayUDPReplies(session, responder, log.New(io.Discard, "", 0), events, 2*time.Millisecond, 0)
+
+	clock.set(start.Add(udpSessionIdleTimeout))
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case event := <-events:
+		t.Fatalf("relay ended the session (%s) exactly at the idle timeout", event.reason)
+	default:
+	}
+
+	clock.set(start.Add(udpSessionIdleTimeout + time.Nanosecond))
+	select {
+	case event := <-events:
+		if event.reason != CloseIdleTimeout || event.session != session {
+			t.Fatalf("relay event = %+v, want %s for this session", event, CloseIdleTimeout)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay kept a session whose client went quiet past the idle timeout")
+	}
+}
+
+func TestManageUDPSessionsKeepsClientWithSilentRemote(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer backend.Close()
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer responder.Close()
+
+	start := time.Unix(1700000000, 0)
+	clock := newFakeClock(start)
+	registry := NewRegistry()
+	reasons := make(chan CloseReason, 1)
+	msgChan := make(chan udpMessage)
+	managerDone := make(chan struct{})
+	defer func() {
+		close(msgChan)
+		<-managerDone
+	}()
+	go func() {
+		defer close(managerDone)
+		manageUDPSessions(responder.LocalAddr().String(), backend.LocalAddr().String(), responder, log.New(io.Discard, "", 0), msgChan, Options{
+			Registry: registry,
+			Observer: func(_ ConnectionInfo, reason CloseReason) { reasons <- reason },
+		}, clock)
+	}()
+
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40011}
+	msgChan <- udpMessage{data: []byte("ping"), addr: client}
+	waitForConnections(t, registry, 1)
+
+	// The backend never answers; the client sends every 45 seconds across many cleanup rounds.
+	for elapsed := 45 * time.Second; elapsed <= 10*time.Minute; elapsed += 45 * time.Second {
+		clock.set(start.Add(elapsed))
+		msgChan <- udpMessage{data: []byte("ping"), addr: client}
+		clock.tick()
+		select {
+		case reason := <-reasons:
+			t.Fatalf("session closed (%s) after %s while its client kept sending", reason, elapsed)
+		default:
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// With -udp-pmtud a datagram too large for the path to the backend fails with EMSGSIZE instead of leaving in fragments a middlebox may drop.
+// The write path logs that failure with the MTU the kernel discovered, which turns silently lost large datagrams into a line to act on.
+package proxy
+
+import (
+	"net"
+	"strconv"
+)
+
+// describePathMTU spells " of N bytes" for the log line, or nothing where the kernel cannot report the MTU.
+func describePathMTU(conn net.Conn) string {
+	if mtu := udpPathMTU(conn); mtu > 0 {
+		return " of " + strconv.Itoa(mtu) + " bytes"
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+//go:build linux
+// +build linux
+
+// IP_MTU_DISCOVER with IP_PMTUDISC_DO sets the don't-fragment bit on every datagram of a backend socket.
+// The kernel then refuses a datagram larger than the known path MTU with EMSGSIZE, and IP_MTU reads that MTU back.
+package proxy
+
+import (
+	"net"
+	"syscall"
+)
+
+// UDPPMTUDSupported reports whether Options.UDPPMTUD takes effect on this platform.
+const UDPPMTUDSupported = true
+
+// setUDPDontFragment turns on path MTU discovery for a UDP backend socket; other connections, such as a bridged TCP backend, are left alone.
+func setUDPDontFragment(conn net.Conn) error {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	raw, err := udpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	level, option, value := syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO
+	if udpIs6(udpConn) {
+		level, option, value = syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO
+	}
+	var optErr error
+	if err := raw.Control(func(fd uintptr) {
+		optErr = syscall.SetsockoptInt(int(fd), level, option, value)
+	}); err != nil {
+		return err
+	}
+	return optErr
+}
+
+// udpPathMTU returns the path MTU the kernel holds for the socket's backend, or 0 when it cannot tell.
+func udpPathMTU(conn net.Conn) int {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		return 0
+	}
+	raw, err := udpConn.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	level, option := syscall.IPPROTO_IP, syscall.IP_MTU
+	if udpIs6(udpConn) {
+		level, option = syscall.IPPROTO_IPV6, syscall.IPV6_MTU
+	}
+	var mtu int
+	var optErr error
+	if err := raw.Control(func(fd uintptr) {
+		mtu, optErr = syscall.GetsockoptInt(int(fd), level, option)
+	}); err != nil || optErr != nil {
+		return 0
+	}
+	return mtu
+}
+
+// udpIs6 tells an IPv6 socket from an IPv4 one by its local address, which net.DialUDP picks to match the backend's family.
+func udpIs6(conn *net.UDPConn) bool {
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	return ok && local.IP.To4() == nil
+}
@@ -0,0 +1,44 @@
+//go:build linux
+// +build linux
+
+package proxy
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestSetUDPDontFragmentTurnsOnPathMTUDiscovery(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer backend.Close()
+	conn, err := net.DialUDP("udp", nil, backend.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("net.DialUDP returned error: %v", err)
+	}
+	defer conn.Close()
+
+	if err := setUDPDontFragment(conn); err != nil {
+		t.Fatalf("setUDPDontFragment returned error: %v", err)
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn returned error: %v", err)
+	}
+	var mode int
+	var optErr error
+	if err := raw.Control(func(fd uintptr) {
+		mode, optErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER)
+	}); err != nil || optErr != nil {
+		t.Fatalf("reading IP_MTU_DISCOVER failed: %v %v", err, optErr)
+	}
+	if mode != syscall.IP_PMTUDISC_DO {
+		t.Fatalf("IP_MTU_DISCOVER = %d, want IP_PMTUDISC_DO", mode)
+	}
+	if mtu := udpPathMTU(conn); mtu <= 0 {
+		t.Fatalf("udpPathMTU = %d, want the loopback MTU", mtu)
+	}
+}
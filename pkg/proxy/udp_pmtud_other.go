@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+// Setting the don't-fragment bit and reading the path MTU back is only wired up on Linux; elsewhere Options.UDPPMTUD does nothing.
+// main warns about that at startup, and the kernel keeps fragmenting large datagrams as before.
+package proxy
+
+import "net"
+
+// UDPPMTUDSupported reports whether Options.UDPPMTUD takes effect on this platform.
+const UDPPMTUDSupported = false
+
+func setUDPDontFragment(net.Conn) error {
+	return nil
+}
+
+func udpPathMTU(net.Conn) int {
+	return 0
+}
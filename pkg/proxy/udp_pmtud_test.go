@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// mtuLimitedConn refuses writes above limit the way a don't-fragment socket does.
+type mtuLimitedConn struct {
+	net.Conn
+	limit int
+}
+
+func (conn *mtuLimitedConn) Write(payload []byte) (int, error) {
+	if len(payload) > conn.limit {
+		return 0, &net.OpError{Op: "write", Net: "udp", Err: os.NewSyscallError("write", syscall.EMSGSIZE)}
+	}
+	return conn.Conn.Write(payload)
+}
+
+func TestForwardUDPPacketsDropsOnlyDatagramsAboveThePathMTU(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer backend.Close()
+	remoteConn, err := net.Dial("udp", backend.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer remoteConn.Close()
+
+	session := &udpSession{
+		clientAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40010},
+		remoteConn: &mtuLimitedConn{Conn: remoteConn, limit: 1000},
+		outbound:   make(chan []byte, 2),
+		id:         "127.0.0.1:40010",
+	}
+	session.outbound <- make([]byte, 2000)
+	session.outbound <- []byte("small")
+	close(session.outbound)
+
+	var output strings.Builder
+	events := make(chan sessionEvent, 1)
+	forwardUDPPackets(session, log.New(&output, "", 0), events, nil)
+
+	line := output.String()
+	if !strings.HasPrefix(line, "Dropping 2000-byte UDP payload from 127.0.0.1:40010: it exceeds the path MTU") || !strings.HasSuffix(line, " to "+backend.LocalAddr().String()+"\n") {
+		t.Fatalf("log line = %q", line)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("an oversized datagram ended the session (%s)", event.reason)
+	default:
+	}
+	buf := make([]byte, 64)
+	_ = backend.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := backend.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "small" {
+		t.Fatalf("backend read %q, %v; want the small datagram after the dropped one", buf[:n], err)
+	}
+}
@@ -0,0 +1,179 @@
+// UDP has no reset, so a client whose datagram the proxy refuses normally waits for its own timeout.
+// A UDPRejecter answers instead, with an ICMP port unreachable like a closed port would or with a short datagram from the route socket.
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// UDP reject modes accepted by NewUDPRejecter.
+const (
+	UDPRejectSilent  = "silent"
+	UDPRejectICMP    = "icmp"
+	UDPRejectPayload = "payload"
+)
+
+const (
+	// udpRejectsPerSecond caps answers per route, because clients can spoof their source and aim the answers at someone else.
+	udpRejectsPerSecond = 100
+	icmpv4Unreachable   = 3
+	icmpv4PortCode      = 3
+	icmpv6Unreachable   = 1
+	icmpv6PortCode      = 4
+	udpProtocolNumber   = 17
+)
+
+// UDPRejecter sends the answer for refused UDP packets; a nil UDPRejecter stays silent.
+// Its raw sockets are shared by every route and written concurrently, which packet sockets allow.
+type UDPRejecter struct {
+	mode    string
+	payload []byte
+	icmp4   net.PacketConn
+	icmp6   net.PacketConn
+}
+
+// NewUDPRejecter prepares mode; silent returns nil. ICMP needs raw sockets, so it fails where the process may not open them
+// and the caller falls back to silent.
+func NewUDPRejecter(mode string, payload []byte) (*UDPRejecter, error) {
+	switch mode {
+	case "", UDPRejectSilent:
+		return nil, nil
+	case UDPRejectPayload:
+		return &UDPRejecter{mode: mode, payload: payload}, nil
+	case UDPRejectICMP:
+		icmp4, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+		if err != nil {
+			return nil, fmt.Errorf("cannot open a raw ICMP socket: %v", err)
+		}
+		// IPv6 is optional: a host without it simply has no IPv6 clients to answer.
+		icmp6, err := net.ListenPacket("ip6:ipv6-icmp", "::")
+		if err != nil {
+			icmp6 = nil
+		}
+		return &UDPRejecter{mode: mode, icmp4: icmp4, icmp6: icmp6}, nil
+	default:
+		return nil, fmt.Errorf("invalid UDP reject mode '%s' (expected silent, icmp, or payload)", mode)
+	}
+}
+
+// reject answers the client whose datagram of size bytes arrived on responder and was refused.
+func (rejecter *UDPRejecter) reject(responder net.PacketConn, client net.Addr, size int) error {
+	if rejecter == nil {
+		return nil
+	}
+	if rejecter.mode == UDPRejectPayload {
+		_, err := responder.WriteTo(rejecter.payload, client)
+		return err
+	}
+	clientAddr, ok := client.(*net.UDPAddr)
+	if !ok {
+		return fmt.Errorf("client %s is not a UDP address", client)
+	}
+	local := localUDPAddr(responder, clientAddr)
+	if local == nil {
+		return fmt.Errorf("no local address toward %s", client)
+	}
+	if clientIP := clientAddr.IP.To4(); clientIP != nil {
+		message := icmpv4PortUnreachable(clientIP, clientAddr.Port, local.IP.To4(), local.Port, size)
+		_, err := rejecter.icmp4.WriteTo(message, &net.IPAddr{IP: clientIP})
+		return err
+	}
+	if rejecter.icmp6 == nil {
+		return fmt.Errorf("no raw ICMPv6 socket for %s", client)
+	}
+	message := icmpv6PortUnreachable(clientAddr.IP, clientAddr.Port, local.IP.To16(), local.Port, size)
+	_, err := rejecter.icmp6.WriteTo(message, &net.IPAddr{IP: clientAddr.IP, Zone: clientAddr.Zone})
+	return err
+}
+
+// localUDPAddr is the address the client sent to, which its stack matches the ICMP error against.
+// A wildcard listener does not know it, so the source address the kernel would route a reply from stands in.
+func localUDPAddr(responder net.PacketConn, client *net.UDPAddr) *net.UDPAddr {
+	listen, ok := responder.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	clientIs4 := client.IP.To4() != nil
+	if !listen.IP.IsUnspecified() && (listen.IP.To4() != nil) == clientIs4 {
+		return listen
+	}
+	// Connecting a UDP socket only picks a route; nothing is sent.
+	probe, err := net.DialUDP("udp", nil, client)
+	if err != nil {
+		return nil
+	}
+	defer probe.Close()
+	return &net.UDPAddr{IP: probe.LocalAddr().(*net.UDPAddr).IP, Port: listen.Port}
+}
+
+// icmpv4PortUnreachable builds the ICMP message RFC 792 prescribes: the refused datagram's IP header and first 8 bytes follow the type and code.
+// Only that much of the datagram is quoted, rebuilt from the addresses, because the proxy never sees the original header.
+func icmpv4PortUnreachable(clientIP net.IP, clientPort int, localIP net.IP, localPort, size int) []byte {
+	message := make([]byte, 8+20+8)
+	message[0], message[1] = icmpv4Unreachable, icmpv4PortCode
+	header := message[8:28]
+	header[0] = 0x45 // version 4, 20-byte header
+	binary.BigEndian.PutUint16(header[2:4], uint16(min(20+8+size, 0xffff)))
+	header[8], header[9] = 64, udpProtocolNumber
+	copy(header[12:16], clientIP)
+	copy(header[16:20], localIP)
+	binary.BigEndian.PutUint16(header[10:12], internetChecksum(header))
+	putQuotedUDPHeader(message[28:], clientPort, localPort, size)
+	binary.BigEndian.PutUint16(message[2:4], internetChecksum(message))
+	return message
+}
+
+// icmpv6PortUnreachable builds the RFC 4443 message; the kernel fills in the ICMPv6 checksum, which covers a pseudo-header only it knows.
+func icmpv6PortUnreachable(clientIP net.IP, clientPort int, localIP net.IP, localPort, size int) []byte {
+	message := make([]byte, 8+40+8)
+	message[0], message[1] = icmpv6Unreachable, icmpv6PortCode
+	header := message[8:48]
+	header[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(header[4:6], uint16(min(8+size, 0xffff)))
+	header[6], header[7] = udpProtocolNumber, 64
+	copy(header[8:24], clientIP.To16())
+	copy(header[24:40], localIP)
+	putQuotedUDPHeader(message[48:], clientPort, localPort, size)
+	return message
+}
+
+func putQuotedUDPHeader(header []byte, clientPort, localPort, size int) {
+	binary.BigEndian.PutUint16(header[0:2], uint16(clientPort))
+	binary.BigEndian.PutUint16(header[2:4], uint16(localPort))
+	binary.BigEndian.PutUint16(header[4:6], uint16(min(8+size, 0xffff)))
+}
+
+// internetChecksum is the RFC 1071 one's complement sum used by IPv4 and ICMPv4.
+func internetChecksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// rejectBudget limits one route's answers to udpRejectsPerSecond; only the route's manager goroutine uses it.
+type rejectBudget struct {
+	window time.Time
+	sent   int
+}
+
+func (budget *rejectBudget) allow(now time.Time) bool {
+	if now.Sub(budget.window) >= time.Second {
+		budget.window, budget.sent = now, 0
+	}
+	if budget.sent >= udpRejectsPerSecond {
+		return false
+	}
+	budget.sent++
+	return true
+}
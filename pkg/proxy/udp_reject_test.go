@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
+)
+
+func TestUDPRejectPayloadAnswersRefusedClient(t *testing.T) {
+	rejecter, err := NewUDPRejecter(UDPRejectPayload, []byte("go away"))
+	if err != nil {
+		t.Fatalf("NewUDPRejecter returned error: %v", err)
+	}
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	// Serving only IPv6 clients refuses this IPv4 client before any backend is dialed.
+	go ServeUDPProxy(listener, "127.0.0.1:9", config.AllowList{}, log.New(io.Discard, "", 0), Options{ClientFamily: "ipv6", UDPReject: rejecter})
+	defer listener.Close()
+
+	client, err := net.Dial("udp", listener.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("net.Dial returned error: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply := make([]byte, 64)
+	n, err := client.Read(reply)
+	if err != nil || string(reply[:n]) != "go away" {
+		t.Fatalf("reply = %q, %v, want the rejection payload", reply[:n], err)
+	}
+}
+
+func TestICMPv4PortUnreachableQuotesTheRefusedDatagram(t *testing.T) {
+	message := icmpv4PortUnreachable(net.IPv4(198, 51, 100, 7).To4(), 50000, net.IPv4(192, 0, 2, 1).To4(), 53, 30)
+
+	if message[0] != icmpv4Unreachable || message[1] != icmpv4PortCode {
+		t.Fatalf("type and code = %d/%d, want 3/3", message[0], message[1])
+	}
+	if internetChecksum(message) != 0 || internetChecksum(message[8:28]) != 0 {
+		t.Fatal("ICMP or quoted IP header checksum does not verify")
+	}
+	header := message[8:28]
+	if !net.IP(header[12:16]).Equal(net.IPv4(198, 51, 100, 7)) || !net.IP(header[16:20]).Equal(net.IPv4(192, 0, 2, 1)) || header[9] != udpProtocolNumber {
+		t.Fatalf("quoted IP header = %x", header)
+	}
+	udp := message[28:]
+	if binary.BigEndian.Uint16(udp[0:2]) != 50000 || binary.BigEndian.Uint16(udp[2:4]) != 53 || binary.BigEndian.Uint16(udp[4:6]) != 38 {
+		t.Fatalf("quoted UDP header = %x", udp)
+	}
+}
+
+func TestICMPv6PortUnreachableQuotesTheRefusedDatagram(t *testing.T) {
+	message := icmpv6PortUnreachable(net.ParseIP("2001:db8::7"), 50000, net.ParseIP("2001:db8::1"), 53, 30)
+
+	if len(message) != 56 || message[0] != icmpv6Unreachable || message[1] != icmpv6PortCode || message[8]>>4 != 6 {
+		t.Fatalf("message = %x", message)
+	}
+	if !net.IP(message[16:32]).Equal(net.ParseIP("2001:db8::7")) || binary.BigEndian.Uint16(message[50:52]) != 53 {
+		t.Fatalf("quoted headers = %x", message[8:])
+	}
+}
+
+func TestRejectBudgetCapsAnswersPerSecond(t *testing.T) {
+	var budget rejectBudget
+	now := time.Unix(1000, 0)
+	for i := 0; i < udpRejectsPerSecond; i++ {
+		if !budget.allow(now) {
+			t.Fatalf("answer %d was refused inside the budget", i+1)
+		}
+	}
+	if budget.allow(now.Add(500 * time.Millisecond)) {
+		t.Fatal("answer over the budget was allowed")
+	}
+	if !budget.allow(now.Add(time.Second)) {
+		t.Fatal("budget did not refill after a second")
+	}
+}
+
+func TestNewUDPRejecterModes(t *testing.T) {
+	if rejecter, err := NewUDPRejecter(UDPRejectSilent, nil); rejecter != nil || err != nil {
+		t.Fatalf("silent mode = %v, %v, want nil and no error", rejecter, err)
+	}
+	if _, err := NewUDPRejecter("tcp-reset", nil); err == nil {
+		t.Fatal("NewUDPRejecter accepted an unknown mode")
+	}
+}
@@ -0,0 +1,98 @@
+// A UDP session dials its backend once, so a hostname target that moves to a new IP would keep old sessions on the old one.
+// The target watcher re-resolves the hostname and the session manager moves live sessions over, keeping each client's mapping.
+package proxy
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+// resolveUDPTarget looks up the backend address; tests replace it to move a hostname between backends.
+var resolveUDPTarget = func(targetAddr string) (*net.UDPAddr, error) {
+	return net.ResolveUDPAddr("udp", targetAddr)
+}
+
+// watchUDPTarget resolves targetAddr every interval and reports each new address, the first one included.
+// Lookups run here rather than in the manager, so a slow resolver never stalls packet forwarding.
+func watchUDPTarget(targetAddr string, interval time.Duration, clock clock, logger *log.Logger, changes chan<- *net.UDPAddr, stop <-chan struct{}) {
+	// The resolver is captured once, so a test restoring resolveUDPTarget cannot race a watcher still winding down.
+	resolve := resolveUDPTarget
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	current := ""
+	for {
+		resolved, err := resolve(targetAddr)
+		if err != nil {
+			logger.Printf("Re-resolving UDP target %s failed: %v; live sessions keep their address", targetAddr, err)
+		} else if resolved.String() != current {
+			if current != "" {
+				logger.Printf("UDP target %s moved from %s to %s", targetAddr, current, resolved)
+			}
+			current = resolved.String()
+			select {
+			case changes <- resolved:
+			case <-stop:
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C():
+		case <-stop:
+			return
+		}
+	}
+}
+
+// migrateUDPSession replaces a session's backend socket with one dialed to resolved, keeping the client, its start time, and its flow count.
+// Closing the old outbound channel and socket ends the old relays; their failure events name the old session, so the manager ignores them.
+func migrateUDPSession(sessions map[string]*udpSession, key string, old *udpSession, resolved *net.UDPAddr, targetAddr string, responder net.PacketConn, logger *log.Logger, sessionEvents chan sessionEvent, options Options) {
+	if _, err := options.EgressPolicy.check(resolved.String(), old.clientAddr); err != nil {
+		logger.Printf("Keeping UDP session for %s on %s: %v", key, old.remoteConn.RemoteAddr(), err)
+		return
+	}
+	// A migrating session keeps its egress IP unless the backend moved to the other address family.
+	var local *net.UDPAddr
+	if options.UDPEgressPool != nil {
+		target := resolved.AddrPort().Addr().Unmap()
+		if previous, ok := old.remoteConn.LocalAddr().(*net.UDPAddr); ok && previous.AddrPort().Addr().Unmap().Is4() == target.Is4() {
+			local = &net.UDPAddr{IP: previous.IP}
+		} else if source, ok := options.UDPEgressPool.pick(target); ok {
+			local = &net.UDPAddr{IP: source.AsSlice()}
+		}
+	}
+	remoteConn, err := net.DialUDP("udp", local, resolved)
+	if err != nil {
+		logger.Printf("Keeping UDP session for %s on %s: dialing %s (%s) failed: %v", key, old.remoteConn.RemoteAddr(), resolved, targetAddr, err)
+		return
+	}
+	if options.UDPPMTUD {
+		if err := setUDPDontFragment(remoteConn); err != nil {
+			remoteConn.Close()
+			logger.Printf("Keeping UDP session for %s on %s: setting don't-fragment on %s failed: %v", key, old.remoteConn.RemoteAddr(), resolved, err)
+			return
+		}
+	}
+	logger.Printf("Migrating UDP session for %s from %s to %s (%s re-resolved)", key, old.remoteConn.RemoteAddr(), resolved, targetAddr)
+
+	close(old.outbound)
+	old.remoteConn.Close()
+	options.Registry.Unregister(old.info.ID)
+
+	session := &udpSession{
+		clientAddr: old.clientAddr,
+		remoteConn: remoteConn,
+		outbound:   make(chan []byte, 32),
+		createdAt:  old.createdAt,
+		clock:      old.clock,
+		id:         old.id,
+		info:       old.info,
+		stats:      old.stats,
+	}
+	session.lastActive.Store(old.lastActive.Load())
+	sessions[key] = session
+	options.SessionState.opened(options.RouteID, targetAddr, key, remoteConn.RemoteAddr().String())
+	runUDPSession(session, responder, logger, sessionEvents, options)
+}
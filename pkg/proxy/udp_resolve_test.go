@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestManageUDPSessionsMigratesSessionWhenTargetMoves(t *testing.T) {
+	listen := func() net.PacketConn {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("net.ListenPacket returned error: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	oldBackend, newBackend, responder, client := listen(), listen(), listen(), listen()
+
+	var current atomic.Pointer[net.UDPAddr]
+	current.Store(oldBackend.LocalAddr().(*net.UDPAddr))
+	originalResolve := resolveUDPTarget
+	resolveUDPTarget = func(string) (*net.UDPAddr, error) {
+		return current.Load(), nil
+	}
+
+	registry := NewRegistry()
+	reasons := make(chan CloseReason, 4)
+	msgChan := make(chan udpMessage, 1)
+	managerDone := make(chan struct{})
+	// The manager, and with it the watcher, must be gone before the deferred restore of resolveUDPTarget runs.
+	defer func() {
+		close(msgChan)
+		<-managerDone
+		resolveUDPTarget = originalResolve
+	}()
+	go func() {
+		defer close(managerDone)
+		manageUDPSessions(responder.LocalAddr().String(), "backend.test:53", responder, log.New(io.Discard, "", 0), msgChan, Options{
+			Registry:           registry,
+			Observer:           func(_ ConnectionInfo, reason CloseReason) { reasons <- reason },
+			UDPResolveInterval: 10 * time.Millisecond,
+		}, realClock{})
+	}()
+
+	msgChan <- udpMessage{data: []byte("one"), addr: client.LocalAddr()}
+	buf := make([]byte, 16)
+	_ = oldBackend.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, _, err := oldBackend.ReadFrom(buf); err != nil || string(buf[:n]) != "one" {
+		t.Fatalf("old backend read %q, %v; want one", buf[:n], err)
+	}
+
+	current.Store(newBackend.LocalAddr().(*net.UDPAddr))
+	var session net.Addr
+	deadline := time.Now().Add(2 * time.Second)
+	for session == nil && time.Now().Before(deadline) {
+		msgChan <- udpMessage{data: []byte("two"), addr: client.LocalAddr()}
+		_ = newBackend.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if n, addr, err := newBackend.ReadFrom(buf); err == nil && string(buf[:n]) == "two" {
+			session = addr
+		}
+	}
+	if session == nil {
+		t.Fatal("session did not move to the new backend after the target re-resolved")
+	}
+
+	if _, err := newBackend.WriteTo([]byte("pong"), session); err != nil {
+		t.Fatalf("WriteTo returned error: %v", err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, _, err := client.ReadFrom(buf); err != nil || string(buf[:n]) != "pong" {
+		t.Fatalf("client read %q, %v; want the new backend's pong", buf[:n], err)
+	}
+
+	if connections := registry.List(); len(connections) != 1 || connections[0].Client != client.LocalAddr().String() {
+		t.Fatalf("registry lists %v, want the one migrated session for the client", connections)
+	}
+	select {
+	case reason := <-reasons:
+		t.Fatalf("session closed (%s) during migration, want it kept", reason)
+	default:
+	}
+}
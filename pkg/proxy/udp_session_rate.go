@@ -0,0 +1,54 @@
+// A session rate caps how many UDP sessions all routes together may start per second, because every new session costs a dial and two goroutines.
+// Spoofed floods create sessions from endless source addresses, so a packet limit inside a session never sees them; this limit is checked before the dial.
+package proxy
+
+import "time"
+
+// UDPSessionRate is a token bucket for new UDP sessions shared by every route; a nil *UDPSessionRate admits all of them.
+// One goroutine owns the bucket, so route managers only exchange messages with it.
+type UDPSessionRate struct {
+	perSecond float64
+	requests  chan sessionRateRequest
+}
+
+// sessionRateRequest asks for one token at the caller's clock reading.
+type sessionRateRequest struct {
+	now   time.Time
+	reply chan bool
+}
+
+// NewUDPSessionRate allows perSecond new sessions per second, with bursts of the same size.
+func NewUDPSessionRate(perSecond int) *UDPSessionRate {
+	rate := &UDPSessionRate{perSecond: float64(perSecond), requests: make(chan sessionRateRequest)}
+	go rate.run()
+	return rate
+}
+
+// run refills the bucket by the time elapsed since the previous request; a request stamped earlier than that adds nothing.
+func (rate *UDPSessionRate) run() {
+	tokens := rate.perSecond
+	var last time.Time
+	for request := range rate.requests {
+		if !last.IsZero() && request.now.After(last) {
+			tokens = min(rate.perSecond, tokens+request.now.Sub(last).Seconds()*rate.perSecond)
+		}
+		if request.now.After(last) {
+			last = request.now
+		}
+		admitted := tokens >= 1
+		if admitted {
+			tokens--
+		}
+		request.reply <- admitted
+	}
+}
+
+// admit takes a token for one new session, reporting false once the rate is used up.
+func (rate *UDPSessionRate) admit(now time.Time) bool {
+	if rate == nil {
+		return true
+	}
+	reply := make(chan bool, 1)
+	rate.requests <- sessionRateRequest{now: now, reply: reply}
+	return <-reply
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPSessionRateThrottlesNewSessionsOnly(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer backend.Close()
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer responder.Close()
+
+	start := time.Unix(1700000000, 0)
+	clock := newFakeClock(start)
+	registry := NewRegistry()
+	lines := make(logLines, 64)
+	msgChan := make(chan udpMessage, 1)
+	managerDone := make(chan struct{})
+	defer func() {
+		close(msgChan)
+		<-managerDone
+	}()
+	listenAddr := responder.LocalAddr().String()
+	go func() {
+		defer close(managerDone)
+		manageUDPSessions(listenAddr, backend.LocalAddr().String(), responder, log.New(lines, "", 0), msgChan, Options{
+			Registry:       registry,
+			UDPSessionRate: NewUDPSessionRate(2),
+		}, clock)
+	}()
+	client := func(port int) *net.UDPAddr { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port} }
+
+	msgChan <- udpMessage{data: []byte("one"), addr: client(40001)}
+	msgChan <- udpMessage{data: []byte("two"), addr: client(40002)}
+	waitForConnections(t, registry, 2)
+
+	msgChan <- udpMessage{data: []byte("three"), addr: client(40003)}
+	waitForLogLine(t, lines, "Dropping UDP packet from 127.0.0.1:40003 on "+listenAddr+": new UDP session rate exceeded\n")
+
+	// The first client already has a session, so its packets pass while new clients are throttled.
+	msgChan <- udpMessage{data: []byte("again"), addr: client(40001)}
+	backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, 64)
+	for {
+		n, _, err := backend.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("backend never received the existing session's packet: %v", err)
+		}
+		if string(buffer[:n]) == "again" {
+			break
+		}
+	}
+
+	clock.set(start.Add(500 * time.Millisecond))
+	msgChan <- udpMessage{data: []byte("three"), addr: client(40003)}
+	waitForConnections(t, registry, 3)
+}
+
+func TestUDPSessionRateRefillsWithElapsedTime(t *testing.T) {
+	rate := NewUDPSessionRate(4)
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 4; i++ {
+		if !rate.admit(start) {
+			t.Fatalf("session %d was refused inside the burst", i+1)
+		}
+	}
+	if rate.admit(start) {
+		t.Fatal("session over the burst was admitted")
+	}
+	if rate.admit(start.Add(-time.Second)) {
+		t.Fatal("a request stamped in the past refilled the bucket")
+	}
+	if !rate.admit(start.Add(250*time.Millisecond)) || rate.admit(start.Add(250*time.Millisecond)) {
+		t.Fatal("a quarter second should refill exactly one token at 4 per second")
+	}
+	if !(*UDPSessionRate)(nil).admit(start) {
+		t.Fatal("a nil rate refused a session")
+	}
+}
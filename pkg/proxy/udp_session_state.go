@@ -0,0 +1,184 @@
+// Session state carries each UDP client's upstream address across a restart, so a hostname target that resolves
+// to several backends sends a returning client to the one it used before. Sockets and queued payloads do not survive.
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// MaxUDPSessionStateAge is how long a saved mapping stays useful; a client quiet for longer is balanced afresh.
+const MaxUDPSessionStateAge = 10 * time.Minute
+
+// errNoRestoredUpstream tells the session manager that a new client has no mapping from before the restart.
+var errNoRestoredUpstream = errors.New("no restored upstream")
+
+// SavedUDPSession is one client's mapping in a -session-state-file.
+type SavedUDPSession struct {
+	Route    string `json:"route"`
+	Target   string `json:"target"` // Target is the route's configured target, so a mapping is dropped once the route points elsewhere.
+	Client   string `json:"client"`
+	Upstream string `json:"upstream"` // Upstream is the address the session's backend socket was dialed to.
+	// LastActive is when the mapping was last known to be in use: the save time for live sessions.
+	LastActive time.Time `json:"last_active"`
+}
+
+// udpSessionStateFile is the layout of -session-state-file.
+type udpSessionStateFile struct {
+	Sessions []SavedUDPSession `json:"sessions"`
+}
+
+// UDPSessionState tracks the upstream of every live UDP session and the restored mappings no client has claimed yet.
+// A nil UDPSessionState ignores every call, so persistence stays opt-in.
+type UDPSessionState struct {
+	requests chan udpSessionStateRequest
+}
+
+type udpSessionStateRequest struct {
+	open     *SavedUDPSession
+	close    *SavedUDPSession
+	take     *SavedUDPSession // take matches Route and Target and returns the unclaimed restored mappings.
+	snapshot bool
+	reply    chan []SavedUDPSession
+}
+
+// NewUDPSessionState starts the goroutine that owns the table, seeded with mappings restored from a previous run.
+func NewUDPSessionState(restored []SavedUDPSession) *UDPSessionState {
+	state := &UDPSessionState{requests: make(chan udpSessionStateRequest)}
+	go state.run(restored)
+	return state
+}
+
+func (state *UDPSessionState) run(restored []SavedUDPSession) {
+	key := func(session SavedUDPSession) string { return session.Route + " " + session.Client }
+	live := make(map[string]SavedUDPSession)
+	waiting := make(map[string]SavedUDPSession)
+	for _, session := range restored {
+		waiting[key(session)] = session
+	}
+
+	for request := range state.requests {
+		switch {
+		case request.open != nil:
+			// A client that came back, or started afresh, no longer needs its restored mapping.
+			delete(waiting, key(*request.open))
+			live[key(*request.open)] = *request.open
+		case request.close != nil:
+			delete(live, key(*request.close))
+		case request.take != nil:
+			var matches []SavedUDPSession
+			for _, session := range waiting {
+				if session.Route == request.take.Route && session.Target == request.take.Target {
+					matches = append(matches, session)
+				}
+			}
+			request.reply <- matches
+		case request.snapshot:
+			now := time.Now()
+			sessions := make([]SavedUDPSession, 0, len(live)+len(waiting))
+			for _, session := range live {
+				session.LastActive = now
+				sessions = append(sessions, session)
+			}
+			for _, session := range waiting {
+				sessions = append(sessions, session)
+			}
+			sort.Slice(sessions, func(i, j int) bool { return key(sessions[i]) < key(sessions[j]) })
+			request.reply <- sessions
+		}
+	}
+}
+
+// opened records the upstream a session's backend socket was dialed to; a migrated session records its new one.
+func (state *UDPSessionState) opened(route, target, client, upstream string) {
+	if state == nil {
+		return
+	}
+	state.requests <- udpSessionStateRequest{open: &SavedUDPSession{Route: route, Target: target, Client: client, Upstream: upstream}}
+}
+
+// closed forgets a session that ended, so only sessions live at shutdown are saved.
+func (state *UDPSessionState) closed(route, client string) {
+	if state == nil {
+		return
+	}
+	state.requests <- udpSessionStateRequest{close: &SavedUDPSession{Route: route, Client: client}}
+}
+
+// restored returns, by client address, the upstreams restored for a route that still has the same target.
+func (state *UDPSessionState) restored(route, target string) map[string]string {
+	if state == nil {
+		return nil
+	}
+	reply := make(chan []SavedUDPSession, 1)
+	state.requests <- udpSessionStateRequest{take: &SavedUDPSession{Route: route, Target: target}, reply: reply}
+	upstreams := make(map[string]string)
+	for _, session := range <-reply {
+		upstreams[session.Client] = session.Upstream
+	}
+	return upstreams
+}
+
+// Snapshot lists the live sessions, stamped with the current time, and the restored mappings still unclaimed.
+func (state *UDPSessionState) Snapshot() []SavedUDPSession {
+	if state == nil {
+		return nil
+	}
+	reply := make(chan []SavedUDPSession, 1)
+	state.requests <- udpSessionStateRequest{snapshot: true, reply: reply}
+	return <-reply
+}
+
+// SaveUDPSessionState writes sessions to path through a temporary file, so a crash mid-write never leaves half a table.
+// Client addresses are personal data in many places, so only the owner may read the file.
+func SaveUDPSessionState(path string, sessions []SavedUDPSession) error {
+	content, err := json.MarshalIndent(udpSessionStateFile{Sessions: sessions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("session state file '%s': %v", path, err)
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(append(content, '\n')); err != nil {
+		temp.Close()
+		return fmt.Errorf("session state file '%s': %v", path, err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("session state file '%s': %v", path, err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("session state file '%s': %v", path, err)
+	}
+	return nil
+}
+
+// LoadUDPSessionState reads the mappings saved at path and drops those last active before now minus MaxUDPSessionStateAge.
+// A missing file is an empty table, because the first start of a proxy has nothing to restore.
+func LoadUDPSessionState(path string, now time.Time) ([]SavedUDPSession, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("session state file '%s': %v", path, err)
+	}
+	var file udpSessionStateFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("session state file '%s': %v", path, err)
+	}
+	var sessions []SavedUDPSession
+	for _, session := range file.Sessions {
+		if session.Route == "" || session.Client == "" || session.Upstream == "" || now.Sub(session.LastActive) > MaxUDPSessionStateAge {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runUDPManager serves one route for a test and returns its message channel; the manager stops before the test ends.
+func runUDPManager(t *testing.T, targetAddr string, responder net.PacketConn, options Options) chan<- udpMessage {
+	t.Helper()
+	msgChan := make(chan udpMessage, 4)
+	managerDone := make(chan struct{})
+	t.Cleanup(func() {
+		close(msgChan)
+		<-managerDone
+	})
+	go func() {
+		defer close(managerDone)
+		manageUDPSessions(responder.LocalAddr().String(), targetAddr, responder, log.New(io.Discard, "", 0), msgChan, options, realClock{})
+	}()
+	return msgChan
+}
+
+// readDatagram waits for one datagram on backend and returns it.
+func readDatagram(t *testing.T, backend net.PacketConn) string {
+	t.Helper()
+	buffer := make([]byte, 64)
+	_ = backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := backend.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("backend ReadFrom returned error: %v", err)
+	}
+	return string(buffer[:n])
+}
+
+func TestUDPSessionStateSurvivesARestart(t *testing.T) {
+	listen := func() net.PacketConn {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("net.ListenPacket returned error: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	first, second, responder := listen(), listen(), listen()
+
+	// backend.test resolves to the first backend before the restart and to the second after it, as round-robin DNS may.
+	var current atomic.Pointer[net.UDPAddr]
+	current.Store(first.LocalAddr().(*net.UDPAddr))
+	originalResolve := resolveUDPTarget
+	resolveUDPTarget = func(targetAddr string) (*net.UDPAddr, error) {
+		if strings.HasPrefix(targetAddr, "backend.test:") {
+			return current.Load(), nil
+		}
+		return net.ResolveUDPAddr("udp", targetAddr)
+	}
+	t.Cleanup(func() { resolveUDPTarget = originalResolve })
+
+	returning := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40020}
+	state := NewUDPSessionState(nil)
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	t.Run("before", func(t *testing.T) {
+		msgChan := runUDPManager(t, "backend.test:53", responder, Options{RouteID: "dns", SessionState: state})
+		msgChan <- udpMessage{data: []byte("hello"), addr: returning}
+		if got := readDatagram(t, first); got != "hello" {
+			t.Fatalf("first backend read %q", got)
+		}
+		// main saves on SIGTERM while the routes still run, before any drain closes their sessions.
+		if err := SaveUDPSessionState(path, state.Snapshot()); err != nil {
+			t.Fatalf("SaveUDPSessionState returned error: %v", err)
+		}
+	})
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("state file stat = %v, %v; want mode 0600", info, err)
+	}
+
+	restored, err := LoadUDPSessionState(path, time.Now())
+	if err != nil {
+		t.Fatalf("LoadUDPSessionState returned error: %v", err)
+	}
+	if len(restored) != 1 || restored[0].Route != "dns" || restored[0].Target != "backend.test:53" ||
+		restored[0].Client != returning.String() || restored[0].Upstream != first.LocalAddr().String() {
+		t.Fatalf("restored %+v, want the returning client on the first backend", restored)
+	}
+
+	current.Store(second.LocalAddr().(*net.UDPAddr))
+	state = NewUDPSessionState(restored)
+	msgChan := runUDPManager(t, "backend.test:53", responder, Options{RouteID: "dns", SessionState: state})
+	msgChan <- udpMessage{data: []byte("again"), addr: returning}
+	if got := readDatagram(t, first); got != "again" {
+		t.Fatalf("first backend read %q; the returning client lost its upstream", got)
+	}
+	msgChan <- udpMessage{data: []byte("new"), addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40021}}
+	if got := readDatagram(t, second); got != "new" {
+		t.Fatalf("second backend read %q; a new client must use the current resolution", got)
+	}
+}
+
+func TestUDPSessionStateRestoresOnlyFreshMappingsOfAnUnchangedTarget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	if sessions, err := LoadUDPSessionState(path, time.Now()); err != nil || sessions != nil {
+		t.Fatalf("missing file loaded as %v, %v; want nothing", sessions, err)
+	}
+
+	now := time.Now()
+	err := SaveUDPSessionState(path, []SavedUDPSession{
+		{Route: "dns", Target: "backend.test:53", Client: "192.0.2.1:5000", Upstream: "198.51.100.1:53", LastActive: now.Add(-time.Minute)},
+		{Route: "dns", Target: "backend.test:53", Client: "192.0.2.2:5000", Upstream: "198.51.100.2:53", LastActive: now.Add(-MaxUDPSessionStateAge - time.Second)},
+	})
+	if err != nil {
+		t.Fatalf("SaveUDPSessionState returned error: %v", err)
+	}
+	restored, err := LoadUDPSessionState(path, now)
+	if err != nil || len(restored) != 1 || restored[0].Client != "192.0.2.1:5000" {
+		t.Fatalf("LoadUDPSessionState = %+v, %v; want only the fresh mapping", restored, err)
+	}
+
+	state := NewUDPSessionState(restored)
+	if upstreams := state.restored("dns", "other.test:53"); len(upstreams) != 0 {
+		t.Fatalf("route with a new target got %v", upstreams)
+	}
+	if upstreams := state.restored("dns", "backend.test:53"); upstreams["192.0.2.1:5000"] != "198.51.100.1:53" {
+		t.Fatalf("restored upstreams = %v", upstreams)
+	}
+	// An unclaimed mapping is saved again with its old activity time, so it still expires on schedule.
+	if snapshot := state.Snapshot(); len(snapshot) != 1 || !snapshot[0].LastActive.Equal(restored[0].LastActive) {
+		t.Fatalf("snapshot = %+v, want the unclaimed mapping unchanged", snapshot)
+	}
+	state.opened("dns", "backend.test:53", "192.0.2.1:5000", "198.51.100.3:53")
+	state.closed("dns", "192.0.2.1:5000")
+	if snapshot := state.Snapshot(); len(snapshot) != 0 {
+		t.Fatalf("snapshot = %+v after the client's session ended, want none", snapshot)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	if _, err := LoadUDPSessionState(path, now); err == nil {
+		t.Fatal("corrupt state file loaded")
+	}
+}
+
+func TestBalancedUDPRouteKeepsEachSessionOnItsTarget(t *testing.T) {
+	first, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer first.Close()
+	second, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer second.Close()
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket returned error: %v", err)
+	}
+	defer responder.Close()
+
+	targetAddr := first.LocalAddr().String()
+	checker := startFailover([]string{targetAddr, second.LocalAddr().String()}, 2, "UDP sessions", time.Hour, nil, false, false, log.New(io.Discard, "", 0), nil, nil)
+	defer checker.close()
+	msgChan := runUDPManager(t, targetAddr, responder, Options{failover: checker})
+	send := func(port int, payload string) {
+		msgChan <- udpMessage{data: []byte(payload), addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}}
+	}
+
+	// New clients alternate between the targets, and a client's later packets follow its first one.
+	send(40001, "one")
+	if got := readDatagram(t, first); got != "one" {
+		t.Fatalf("first target read %q, want one", got)
+	}
+	send(40002, "two")
+	if got := readDatagram(t, second); got != "two" {
+		t.Fatalf("second target read %q, want two", got)
+	}
+	send(40001, "one again")
+	if got := readDatagram(t, first); got != "one again" {
+		t.Fatalf("first target read %q, want the first client's second packet", got)
+	}
+	send(40003, "three")
+	if got := readDatagram(t, first); got != "three" {
+		t.Fatalf("first target read %q, want the third client back on it", got)
+	}
+}
@@ -0,0 +1,24 @@
+// A route marked wait-for-upstream binds only once its backend accepts connections, so clients never reach a listener with nothing behind it.
+// The wait is bounded: after the timeout the route binds anyway and failover or the backend's own recovery take over.
+package proxy
+
+import "time"
+
+// upstreamWaitRetry spaces the probes while a backend is still down.
+const upstreamWaitRetry = time.Second
+
+// WaitForUpstream probes target until it accepts a TCP connection or timeout passes, and reports how long that took and whether it answered.
+func WaitForUpstream(target string, timeout time.Duration) (time.Duration, bool) {
+	started := time.Now()
+	deadline := started.Add(timeout)
+	for {
+		if healthCheckDial(target) == nil {
+			return time.Since(started), true
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return time.Since(started), false
+		}
+		time.Sleep(min(upstreamWaitRetry, remaining))
+	}
+}
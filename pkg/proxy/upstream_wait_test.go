@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForUpstreamReturnsOnceTheBackendAnswers(t *testing.T) {
+	if _, ok := WaitForUpstream(startEchoBackend(t), time.Second); !ok {
+		t.Fatal("WaitForUpstream gave up on a listening backend")
+	}
+}
+
+func TestWaitForUpstreamGivesUpAfterTimeout(t *testing.T) {
+	originalDial := healthCheckDial
+	t.Cleanup(func() { healthCheckDial = originalDial })
+	probes := 0
+	healthCheckDial = func(string) error {
+		probes++
+		return errors.New("connection refused")
+	}
+
+	waited, ok := WaitForUpstream("192.0.2.1:80", 50*time.Millisecond)
+	if ok {
+		t.Fatal("WaitForUpstream reported a refusing backend as up")
+	}
+	if waited < 50*time.Millisecond || waited > time.Second || probes != 2 {
+		t.Fatalf("waited %v with %d probes, want the 50ms timeout and a probe at each end", waited, probes)
+	}
+}
@@ -0,0 +1,417 @@
+// Package rules evaluates the small expressions a route uses to pick a different upstream for some clients.
+// Expressions only compare a fixed set of client facts with literals, so a rule can never run code, loop, or call out.
+package rules
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxLength bounds an expression's text, and with it the work one evaluation can take.
+const MaxLength = 1024
+
+// maxDepth bounds nesting of not and parentheses, so a crafted expression cannot exhaust the parser's stack.
+const maxDepth = 32
+
+// Context holds the client facts an expression can look at; the proxy fills it once per connection.
+type Context struct {
+	ClientIP   netip.Addr
+	ClientPort int
+	Time       time.Time // Time gives hour and weekday in its own location, which is local time in the proxy.
+}
+
+// Rule sends clients whose facts match Expr to Target instead of the route's own target.
+type Rule struct {
+	Expr   *Expr
+	Target string
+}
+
+// Pick returns the target of the first rule that matches, so rules are written from the most specific to the most general.
+func Pick(rules []Rule, ctx Context) (string, bool) {
+	for _, rule := range rules {
+		if rule.Expr.Match(ctx) {
+			return rule.Target, true
+		}
+	}
+	return "", false
+}
+
+// Expr is a parsed expression; String returns its canonical text, which parses back to the same expression.
+type Expr struct {
+	root node
+}
+
+// Match reports whether the client facts satisfy the expression.
+func (expr *Expr) Match(ctx Context) bool {
+	return expr.root.match(ctx)
+}
+
+func (expr *Expr) String() string {
+	return expr.root.String()
+}
+
+// Parse reads an expression such as "ip in 10.1.0.0/16 and hour in 9-17" or "not weekday in sat-sun".
+// Fields are ip, port, hour, and weekday; conditions combine with and, or, not, and parentheses.
+func Parse(text string) (*Expr, error) {
+	if len(text) > MaxLength {
+		return nil, fmt.Errorf("rule is longer than %d characters", MaxLength)
+	}
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("rule is empty")
+	}
+	parser := &parser{tokens: tokens}
+	root, err := parser.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, fmt.Errorf("unexpected '%s' in rule", parser.tokens[parser.pos])
+	}
+	return &Expr{root: root}, nil
+}
+
+// operatorChars start the comparison operators, which also end the word before them, so "hour>=9" reads like "hour >= 9".
+const operatorChars = "=!<>"
+
+// tokenize splits on spaces, parentheses, and comparison operators; everything else, such as CIDRs and ranges, is one word.
+func tokenize(text string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(text); {
+		char := text[i]
+		switch {
+		case char == ' ' || char == '\t':
+			i++
+		case char == '(' || char == ')':
+			tokens = append(tokens, string(char))
+			i++
+		case strings.IndexByte(operatorChars, char) >= 0:
+			operator := string(char)
+			if i+1 < len(text) && text[i+1] == '=' {
+				operator += "="
+			}
+			switch operator {
+			case "==", "!=", "<", "<=", ">", ">=":
+			default:
+				return nil, fmt.Errorf("unknown operator '%s' in rule", operator)
+			}
+			tokens = append(tokens, operator)
+			i += len(operator)
+		default:
+			start := i
+			for i < len(text) && !strings.ContainsRune(" \t()"+operatorChars, rune(text[i])) {
+				i++
+			}
+			tokens = append(tokens, text[start:i])
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (parser *parser) peek() string {
+	if parser.pos < len(parser.tokens) {
+		return parser.tokens[parser.pos]
+	}
+	return ""
+}
+
+func (parser *parser) next() string {
+	token := parser.peek()
+	parser.pos++
+	return token
+}
+
+func (parser *parser) parseOr(depth int) (node, error) {
+	left, err := parser.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(parser.peek(), "or") {
+		parser.next()
+		right, err := parser.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (parser *parser) parseAnd(depth int) (node, error) {
+	left, err := parser.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(parser.peek(), "and") {
+		parser.next()
+		right, err := parser.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (parser *parser) parseUnary(depth int) (node, error) {
+	if depth >= maxDepth {
+		return nil, fmt.Errorf("rule nests deeper than %d levels", maxDepth)
+	}
+	switch token := parser.peek(); {
+	case strings.EqualFold(token, "not"):
+		parser.next()
+		inner, err := parser.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner}, nil
+	case token == "(":
+		parser.next()
+		inner, err := parser.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if parser.next() != ")" {
+			return nil, fmt.Errorf("missing ')' in rule")
+		}
+		return inner, nil
+	}
+	return parser.parseComparison()
+}
+
+func (parser *parser) parseComparison() (node, error) {
+	field := strings.ToLower(parser.next())
+	operator := strings.ToLower(parser.next())
+	value := parser.next()
+	if field == "" || operator == "" || value == "" {
+		return nil, fmt.Errorf("incomplete condition in rule; expected FIELD OPERATOR VALUE")
+	}
+	switch field {
+	case "ip":
+		return parseIPCondition(operator, value)
+	case "port":
+		return parseNumberCondition(field, operator, value, 0, 65535, false, strconv.Atoi)
+	case "hour":
+		return parseNumberCondition(field, operator, value, 0, 23, true, strconv.Atoi)
+	case "weekday":
+		if operator != "==" && operator != "!=" && operator != "in" {
+			return nil, fmt.Errorf("weekday only supports ==, !=, and in")
+		}
+		return parseNumberCondition(field, operator, value, 0, 6, true, parseWeekday)
+	default:
+		return nil, fmt.Errorf("unknown rule field '%s' (expected ip, port, hour, or weekday)", field)
+	}
+}
+
+// parseIPCondition accepts an IP for == and !=, and a |-separated list of IPs and CIDRs for in.
+func parseIPCondition(operator, value string) (node, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(value, "|") {
+		prefix, err := parseIPPrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	switch operator {
+	case "in":
+		return ipNode{prefixes: prefixes}, nil
+	case "==", "!=":
+		if len(prefixes) != 1 || !prefixes[0].IsSingleIP() {
+			return nil, fmt.Errorf("ip %s takes one address; use in for networks and lists", operator)
+		}
+		return ipNode{prefixes: prefixes, negate: operator == "!="}, nil
+	default:
+		return nil, fmt.Errorf("ip only supports ==, !=, and in")
+	}
+}
+
+func parseIPPrefix(item string) (netip.Prefix, error) {
+	if strings.Contains(item, "/") {
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid network '%s' in rule", item)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(item)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP '%s' in rule", item)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parseNumberCondition turns every operator into a set of ranges, so evaluation is one loop for all of them.
+// Cyclic fields, hour and weekday, allow ranges that wrap, such as 22-6 for the night or fri-mon for a long weekend.
+func parseNumberCondition(field, operator, value string, min, max int, cyclic bool, parse func(string) (int, error)) (node, error) {
+	number := func(text string) (int, error) {
+		n, err := parse(text)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("invalid %s '%s' in rule", field, text)
+		}
+		return n, nil
+	}
+
+	condition := numberNode{field: field, operator: operator, value: value, cyclic: cyclic}
+	if operator == "in" {
+		for _, item := range strings.Split(value, "|") {
+			low, high, isRange := strings.Cut(item, "-")
+			from, err := number(low)
+			if err != nil {
+				return nil, err
+			}
+			to := from
+			if isRange {
+				if to, err = number(high); err != nil {
+					return nil, err
+				}
+			}
+			if to < from && !cyclic {
+				return nil, fmt.Errorf("%s range '%s' ends before it starts", field, item)
+			}
+			condition.spans = append(condition.spans, span{from, to})
+		}
+		return condition, nil
+	}
+
+	n, err := number(value)
+	if err != nil {
+		return nil, err
+	}
+	switch operator {
+	case "==":
+		condition.spans = []span{{n, n}}
+	case "!=":
+		condition.spans = []span{{n, n}}
+		condition.negate = true
+	case "<":
+		condition.spans = []span{{min, n - 1}}
+	case "<=":
+		condition.spans = []span{{min, n}}
+	case ">":
+		condition.spans = []span{{n + 1, max}}
+	case ">=":
+		condition.spans = []span{{n, max}}
+	default:
+		return nil, fmt.Errorf("unknown operator '%s' for %s", operator, field)
+	}
+	condition.cyclic = false
+	return condition, nil
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseWeekday numbers days like time.Weekday, Sunday first.
+func parseWeekday(text string) (int, error) {
+	for i, day := range weekdays {
+		if strings.EqualFold(text, day) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday '%s'", text)
+}
+
+type node interface {
+	match(ctx Context) bool
+	String() string
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) match(ctx Context) bool { return n.left.match(ctx) && n.right.match(ctx) }
+func (n andNode) String() string         { return "(" + n.left.String() + " and " + n.right.String() + ")" }
+
+type orNode struct{ left, right node }
+
+func (n orNode) match(ctx Context) bool { return n.left.match(ctx) || n.right.match(ctx) }
+func (n orNode) String() string         { return "(" + n.left.String() + " or " + n.right.String() + ")" }
+
+type notNode struct{ inner node }
+
+func (n notNode) match(ctx Context) bool { return !n.inner.match(ctx) }
+func (n notNode) String() string         { return "not " + n.inner.String() }
+
+// ipNode matches the client IP against prefixes; IPv4 clients of a dual-stack socket compare in their plain IPv4 form.
+type ipNode struct {
+	prefixes []netip.Prefix
+	negate   bool
+}
+
+func (n ipNode) match(ctx Context) bool {
+	ip := ctx.ClientIP.Unmap()
+	for _, prefix := range n.prefixes {
+		if prefix.Contains(ip) {
+			return !n.negate
+		}
+	}
+	return n.negate
+}
+
+func (n ipNode) String() string {
+	if len(n.prefixes) == 1 && n.prefixes[0].IsSingleIP() {
+		operator := "=="
+		if n.negate {
+			operator = "!="
+		}
+		return "ip " + operator + " " + n.prefixes[0].Addr().String()
+	}
+	items := make([]string, len(n.prefixes))
+	for i, prefix := range n.prefixes {
+		items[i] = prefix.String()
+		if prefix.IsSingleIP() {
+			items[i] = prefix.Addr().String()
+		}
+	}
+	return "ip in " + strings.Join(items, "|")
+}
+
+// span is an inclusive range; on a cyclic field a span whose end is below its start wraps around.
+type span struct{ from, to int }
+
+type numberNode struct {
+	field    string
+	operator string
+	value    string
+	spans    []span
+	cyclic   bool
+	negate   bool
+}
+
+func (n numberNode) match(ctx Context) bool {
+	var value int
+	switch n.field {
+	case "port":
+		value = ctx.ClientPort
+	case "hour":
+		value = ctx.Time.Hour()
+	case "weekday":
+		value = int(ctx.Time.Weekday())
+	}
+	for _, s := range n.spans {
+		inside := value >= s.from && value <= s.to
+		if s.to < s.from {
+			// Outside cyclic ranges an inverted span is empty, as hour < 0 yields.
+			inside = n.cyclic && (value >= s.from || value <= s.to)
+		}
+		if inside {
+			return !n.negate
+		}
+	}
+	return n.negate
+}
+
+func (n numberNode) String() string {
+	return n.field + " " + n.operator + " " + strings.ToLower(n.value)
+}
@@ -0,0 +1,121 @@
+package rules
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAndMatchConditions(t *testing.T) {
+	// 2026-03-06 is a Friday.
+	friday := func(hour int) time.Time { return time.Date(2026, 3, 6, hour, 30, 0, 0, time.UTC) }
+	client := func(ip string, port int, at time.Time) Context {
+		return Context{ClientIP: netip.MustParseAddr(ip), ClientPort: port, Time: at}
+	}
+
+	tests := []struct {
+		rule string
+		ctx  Context
+		want bool
+	}{
+		{"ip in 10.1.0.0/16", client("10.1.2.3", 40000, friday(12)), true},
+		{"ip in 10.1.0.0/16", client("10.2.2.3", 40000, friday(12)), false},
+		{"ip in 10.1.0.0/16", client("::ffff:10.1.2.3", 40000, friday(12)), true},
+		{"ip in 192.0.2.7|2001:db8::/32", client("2001:db8::5", 40000, friday(12)), true},
+		{"ip == 192.0.2.7", client("192.0.2.7", 40000, friday(12)), true},
+		{"ip != 192.0.2.7", client("192.0.2.7", 40000, friday(12)), false},
+		{"port >= 1024", client("192.0.2.7", 1023, friday(12)), false},
+		{"port in 1000-2000|3000", client("192.0.2.7", 3000, friday(12)), true},
+		{"hour in 9-17", client("192.0.2.7", 40000, friday(17)), true},
+		{"hour in 9-17", client("192.0.2.7", 40000, friday(18)), false},
+		{"hour in 22-6", client("192.0.2.7", 40000, friday(23)), true},
+		{"hour in 22-6", client("192.0.2.7", 40000, friday(3)), true},
+		{"hour in 22-6", client("192.0.2.7", 40000, friday(12)), false},
+		{"hour < 0", client("192.0.2.7", 40000, friday(0)), false},
+		{"hour>=9", client("192.0.2.7", 40000, friday(9)), true},
+		{"weekday == fri", client("192.0.2.7", 40000, friday(12)), true},
+		{"weekday in sat-sun", client("192.0.2.7", 40000, friday(12)), false},
+		{"weekday in fri-mon", client("192.0.2.7", 40000, friday(12)), true},
+		{"ip in 10.0.0.0/8 and hour in 9-17", client("10.0.0.1", 40000, friday(20)), false},
+		{"ip in 10.0.0.0/8 or hour in 9-17", client("10.0.0.1", 40000, friday(20)), true},
+		{"not (ip in 10.0.0.0/8 or port == 53)", client("192.0.2.7", 53, friday(12)), false},
+		{"not ip in 10.0.0.0/8 and port == 53", client("192.0.2.7", 53, friday(12)), true},
+		{"ip in 10.0.0.0/8 or ip in 172.16.0.0/12 and port == 1", client("10.0.0.1", 2, friday(12)), true},
+		{"IP IN 10.0.0.0/8 AND WEEKDAY == FRI", client("10.0.0.1", 2, friday(12)), true},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.rule)
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error: %v", test.rule, err)
+		}
+		if got := expr.Match(test.ctx); got != test.want {
+			t.Fatalf("%q on %v port %d at %s = %v, want %v", test.rule, test.ctx.ClientIP, test.ctx.ClientPort, test.ctx.Time, got, test.want)
+		}
+	}
+}
+
+func TestStringParsesBackToTheSameExpression(t *testing.T) {
+	for _, rule := range []string{
+		"ip in 10.1.0.0/16 and hour in 9-17",
+		"not (weekday in sat-sun or port < 1024)",
+		"ip == ::ffff:192.0.2.7",
+		"ip in 10.1.2.3/16|192.0.2.7",
+	} {
+		expr, err := Parse(rule)
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error: %v", rule, err)
+		}
+		again, err := Parse(expr.String())
+		if err != nil {
+			t.Fatalf("Parse(%q) of the canonical form returned error: %v", expr.String(), err)
+		}
+		if again.String() != expr.String() {
+			t.Fatalf("canonical form of %q changed from %q to %q", rule, expr.String(), again.String())
+		}
+	}
+}
+
+func TestParseRejectsInvalidRules(t *testing.T) {
+	for _, rule := range []string{
+		"",
+		"ip",
+		"ip in",
+		"ip in not-an-ip",
+		"ip == 10.0.0.0/8",
+		"ip < 10.0.0.1",
+		"port == 70000",
+		"port in 2000-1000",
+		"hour == 24",
+		"weekday == funday",
+		"weekday < fri",
+		"country == nl",
+		"ip in 10.0.0.0/8 and",
+		"(ip in 10.0.0.0/8",
+		"ip in 10.0.0.0/8)",
+		"ip => 10.0.0.1",
+		"ip in 10.0.0.0/8 xor port == 1",
+		strings.Repeat("not ", maxDepth) + "port == 1",
+		"port in " + strings.Repeat("1|", MaxLength),
+	} {
+		if _, err := Parse(rule); err == nil {
+			t.Fatalf("Parse(%q) accepted an invalid rule", rule)
+		}
+	}
+}
+
+func TestPickUsesTheFirstMatchingRule(t *testing.T) {
+	office, _ := Parse("ip in 10.0.0.0/8")
+	anyone, _ := Parse("port >= 0")
+	rules := []Rule{{Expr: office, Target: "10.9.0.1:80"}, {Expr: anyone, Target: "10.9.0.2:80"}}
+
+	if target, ok := Pick(rules, Context{ClientIP: netip.MustParseAddr("10.1.1.1")}); !ok || target != "10.9.0.1:80" {
+		t.Fatalf("Pick = %q, %v; want the office target", target, ok)
+	}
+	if target, ok := Pick(rules, Context{ClientIP: netip.MustParseAddr("192.0.2.1")}); !ok || target != "10.9.0.2:80" {
+		t.Fatalf("Pick = %q, %v; want the catch-all target", target, ok)
+	}
+	if target, ok := Pick(rules[:1], Context{ClientIP: netip.MustParseAddr("192.0.2.1")}); ok {
+		t.Fatalf("Pick = %q, want no match", target)
+	}
+}
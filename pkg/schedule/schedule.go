@@ -0,0 +1,235 @@
+// Package schedule evaluates the time windows a route serves in, such as "mon-fri 09:00-18:00 Europe/Moscow".
+// Windows are wall-clock times in one zone, so business hours stay business hours across daylight saving changes.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// The embedded zone database resolves zone names on hosts without /usr/share/zoneinfo, such as scratch containers and routers.
+	_ "time/tzdata"
+)
+
+// MaxLength bounds a schedule's text, like rules.MaxLength bounds an expression.
+const MaxLength = 1024
+
+// minutesPerDay is where a window may end at the latest, written 24:00.
+const minutesPerDay = 24 * 60
+
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Schedule is a parsed schedule; a nil *Schedule is always open.
+type Schedule struct {
+	windows  []window
+	location *time.Location
+	text     string
+}
+
+// window opens at start and closes at end, in minutes after midnight, on each of days.
+// A window whose end is not after its start runs past midnight and belongs to the day it opens on.
+type window struct {
+	days       [7]bool
+	start, end int
+}
+
+// Parse reads whitespace-separated days and HH:MM-HH:MM ranges, optionally ending with a zone name such as Europe/Moscow or UTC.
+// Days, like mon-fri, sat, or mon-wed+fri, apply to the ranges after them until the next days; ranges before any days apply daily.
+// A schedule without a zone is evaluated in defaultLocation.
+func Parse(spec string, defaultLocation *time.Location) (*Schedule, error) {
+	if len(spec) > MaxLength {
+		return nil, fmt.Errorf("schedule is longer than %d bytes", MaxLength)
+	}
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("schedule is empty")
+	}
+	schedule := &Schedule{location: defaultLocation}
+	// Zone names are case sensitive, so only the days and ranges before the zone are lowered.
+	zone := ""
+	if last := strings.ToLower(fields[len(fields)-1]); !isRange(last) && !isDays(last) && hasLetter(last) {
+		zone = fields[len(fields)-1]
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown time zone '%s' in schedule '%s'", zone, spec)
+		}
+		schedule.location = location
+		fields = fields[:len(fields)-1]
+	}
+	for i := range fields {
+		fields[i] = strings.ToLower(fields[i])
+	}
+	schedule.text = strings.TrimSpace(strings.Join(fields, " ") + " " + zone)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("schedule '%s' has no HH:MM-HH:MM range", spec)
+	}
+
+	days := allDays()
+	rangesForDays := 0
+	for i, field := range fields {
+		if isDays(field) {
+			if i > 0 && rangesForDays == 0 {
+				return nil, fmt.Errorf("days '%s' in schedule '%s' have no HH:MM-HH:MM range", fields[i-1], spec)
+			}
+			parsed, err := parseDays(field)
+			if err != nil {
+				return nil, fmt.Errorf("schedule '%s': %v", spec, err)
+			}
+			days, rangesForDays = parsed, 0
+			continue
+		}
+		if !isRange(field) && hasLetter(field) {
+			return nil, fmt.Errorf("schedule '%s': invalid days '%s' (expected names like mon, mon-fri, or sat+sun)", spec, field)
+		}
+		start, end, err := parseRange(field)
+		if err != nil {
+			return nil, fmt.Errorf("schedule '%s': %v", spec, err)
+		}
+		schedule.windows = append(schedule.windows, window{days: days, start: start, end: end})
+		rangesForDays++
+	}
+	if rangesForDays == 0 {
+		return nil, fmt.Errorf("days '%s' in schedule '%s' have no HH:MM-HH:MM range", fields[len(fields)-1], spec)
+	}
+	return schedule, nil
+}
+
+// Open reports whether the schedule serves at t: a window includes its start minute and excludes its end minute.
+func (schedule *Schedule) Open(t time.Time) bool {
+	if schedule == nil {
+		return true
+	}
+	t = t.In(schedule.location)
+	minute := t.Hour()*60 + t.Minute()
+	today := int(t.Weekday())
+	yesterday := (today + 6) % 7
+	for _, w := range schedule.windows {
+		if w.start < w.end {
+			if w.days[today] && minute >= w.start && minute < w.end {
+				return true
+			}
+			continue
+		}
+		// The evening part belongs to today's window, the early morning part to yesterday's.
+		if w.days[today] && minute >= w.start || w.days[yesterday] && minute < w.end {
+			return true
+		}
+	}
+	return false
+}
+
+// Location is the zone the schedule is evaluated in.
+func (schedule *Schedule) Location() *time.Location {
+	return schedule.location
+}
+
+// String spells the schedule the way Parse reads it, lower case except for the zone.
+func (schedule *Schedule) String() string {
+	return schedule.text
+}
+
+func allDays() [7]bool {
+	return [7]bool{true, true, true, true, true, true, true}
+}
+
+// hasLetter tells day names and zones from malformed ranges such as 9-10.
+func hasLetter(field string) bool {
+	return strings.IndexFunc(field, func(r rune) bool { return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' }) >= 0
+}
+
+func isRange(field string) bool {
+	return strings.Contains(field, ":")
+}
+
+// isDays accepts anything made of day names, so a misspelled day is reported as a day and not as an unknown zone.
+func isDays(field string) bool {
+	if field == "daily" {
+		return true
+	}
+	for _, part := range strings.FieldsFunc(field, func(r rune) bool { return r == '+' || r == '-' }) {
+		if dayIndex(part) < 0 {
+			return false
+		}
+	}
+	return field != ""
+}
+
+// parseDays reads "daily", a day, or a range like fri-mon that may wrap past Sunday; + joins several.
+func parseDays(field string) ([7]bool, error) {
+	if field == "daily" {
+		return allDays(), nil
+	}
+	var days [7]bool
+	for _, part := range strings.Split(field, "+") {
+		first, last, isSpan := strings.Cut(part, "-")
+		from, to := dayIndex(first), dayIndex(first)
+		if isSpan {
+			to = dayIndex(last)
+		}
+		if from < 0 || to < 0 {
+			return days, fmt.Errorf("invalid days '%s' (expected names like mon, mon-fri, or sat+sun)", field)
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func dayIndex(name string) int {
+	for i, day := range dayNames {
+		if name == day {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseRange reads HH:MM-HH:MM; 24:00 may only end a range, and a range that ends where it starts is refused as ambiguous.
+func parseRange(field string) (int, int, error) {
+	first, last, ok := strings.Cut(field, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range '%s' (expected HH:MM-HH:MM)", field)
+	}
+	start, err := parseClock(first)
+	if err != nil || start == minutesPerDay {
+		return 0, 0, fmt.Errorf("invalid range '%s': start must be 00:00 to 23:59", field)
+	}
+	end, err := parseClock(last)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range '%s': end must be 00:00 to 24:00", field)
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("range '%s' is empty; use 00:00-24:00 for a whole day", field)
+	}
+	if end == minutesPerDay {
+		end = 0
+		if start == 0 {
+			end = minutesPerDay
+		}
+	}
+	return start, end, nil
+}
+
+func parseClock(text string) (int, error) {
+	hours, minutes, ok := strings.Cut(text, ":")
+	if !ok || len(hours) == 0 || len(hours) > 2 || len(minutes) != 2 {
+		return 0, fmt.Errorf("invalid time '%s'", text)
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil {
+		return 0, err
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || h == 24 && m != 0 {
+		return 0, fmt.Errorf("invalid time '%s'", text)
+	}
+	return h*60 + m, nil
+}
@@ -0,0 +1,140 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenAtWindowBoundaries(t *testing.T) {
+	// 2026-03-06 is a Friday; UTC keeps the wall clock equal to the times below.
+	at := func(day, hour, minute, second int) time.Time {
+		return time.Date(2026, 3, day, hour, minute, second, 0, time.UTC)
+	}
+	tests := []struct {
+		spec string
+		at   time.Time
+		want bool
+	}{
+		{"mon-fri 09:00-18:00", at(6, 8, 59, 59), false},
+		{"mon-fri 09:00-18:00", at(6, 9, 0, 0), true},
+		{"mon-fri 09:00-18:00", at(6, 17, 59, 59), true},
+		{"mon-fri 09:00-18:00", at(6, 18, 0, 0), false},
+		{"mon-fri 09:00-18:00", at(7, 12, 0, 0), false},
+		{"09:00-18:00", at(7, 12, 0, 0), true},
+		{"daily 00:00-24:00", at(8, 23, 59, 59), true},
+		{"sat 18:00-24:00", at(7, 23, 59, 0), true},
+		{"sat 18:00-24:00", at(8, 0, 0, 0), false},
+		// A window past midnight belongs to the day it opens on: Friday night runs into Saturday, Sunday night does not start.
+		{"mon-fri 22:00-06:00", at(6, 22, 0, 0), true},
+		{"mon-fri 22:00-06:00", at(7, 5, 59, 0), true},
+		{"mon-fri 22:00-06:00", at(7, 6, 0, 0), false},
+		{"mon-fri 22:00-06:00", at(8, 22, 30, 0), false},
+		{"mon-fri 22:00-06:00", at(9, 3, 0, 0), false},
+		{"mon-fri 22:00-06:00", at(10, 3, 0, 0), true},
+		{"fri-mon 10:00-11:00", at(9, 10, 30, 0), true},
+		{"fri-mon 10:00-11:00", at(10, 10, 30, 0), false},
+		{"mon-wed+fri 10:00-11:00", at(6, 10, 30, 0), true},
+		{"mon-fri 09:00-12:00 13:00-18:00 sat 10:00-14:00", at(6, 12, 30, 0), false},
+		{"mon-fri 09:00-12:00 13:00-18:00 sat 10:00-14:00", at(6, 13, 0, 0), true},
+		{"mon-fri 09:00-12:00 13:00-18:00 sat 10:00-14:00", at(7, 13, 59, 0), true},
+	}
+	for _, test := range tests {
+		schedule, err := Parse(test.spec, time.UTC)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", test.spec, err)
+		}
+		if got := schedule.Open(test.at); got != test.want {
+			t.Errorf("%q open at %s = %v, want %v", test.spec, test.at.Format("Mon 15:04:05"), got, test.want)
+		}
+	}
+}
+
+func TestOpenUsesTheScheduleZone(t *testing.T) {
+	schedule, err := Parse("mon-fri 09:00-18:00 Europe/Moscow", time.UTC)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	// Moscow is UTC+3 all year: 06:00 UTC is 09:00 there, and 15:00 UTC is 18:00.
+	if !schedule.Open(time.Date(2026, 3, 6, 6, 0, 0, 0, time.UTC)) {
+		t.Fatal("closed at 09:00 Moscow time")
+	}
+	if schedule.Open(time.Date(2026, 3, 6, 15, 0, 0, 0, time.UTC)) {
+		t.Fatal("open at 18:00 Moscow time")
+	}
+	// Late Sunday UTC is already Monday morning in Moscow.
+	if !schedule.Open(time.Date(2026, 3, 8, 23, 30, 0, 0, time.UTC).Add(7 * time.Hour)) {
+		t.Fatal("closed on Monday 09:30 Moscow time")
+	}
+	if got := schedule.String(); got != "mon-fri 09:00-18:00 Europe/Moscow" {
+		t.Fatalf("String = %q", got)
+	}
+}
+
+func TestOpenUsesTheDefaultZoneWithoutOne(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	schedule, err := Parse("09:00-18:00", tokyo)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	// 01:00 UTC is 10:00 in Tokyo, and 10:00 UTC is 19:00 there.
+	if !schedule.Open(time.Date(2026, 3, 6, 1, 0, 0, 0, time.UTC)) || schedule.Open(time.Date(2026, 3, 6, 10, 0, 0, 0, time.UTC)) {
+		t.Fatal("the schedule was not evaluated in the default zone")
+	}
+	if schedule.Location() != tokyo {
+		t.Fatalf("Location = %v, want Asia/Tokyo", schedule.Location())
+	}
+}
+
+func TestOpenFollowsWallClockAcrossDaylightSaving(t *testing.T) {
+	schedule, err := Parse("daily 09:00-10:00 Europe/Berlin", time.UTC)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	// Berlin is UTC+1 before 2026-03-29 and UTC+2 after it, so 09:30 local moves from 08:30 to 07:30 UTC.
+	if !schedule.Open(time.Date(2026, 3, 28, 8, 30, 0, 0, time.UTC)) {
+		t.Fatal("closed at 09:30 Berlin winter time")
+	}
+	if !schedule.Open(time.Date(2026, 3, 30, 7, 30, 0, 0, time.UTC)) || schedule.Open(time.Date(2026, 3, 30, 8, 30, 0, 0, time.UTC)) {
+		t.Fatal("the window did not follow Berlin summer time")
+	}
+}
+
+func TestNilScheduleIsAlwaysOpen(t *testing.T) {
+	var schedule *Schedule
+	if !schedule.Open(time.Now()) {
+		t.Fatal("nil schedule is closed")
+	}
+}
+
+func TestParseRejectsBadSchedules(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"", "empty"},
+		{"mon-fri", "no HH:MM-HH:MM range"},
+		{"mon 09:00-10:00 sat", "no HH:MM-HH:MM range"},
+		{"mon sat 09:00-10:00", "no HH:MM-HH:MM range"},
+		{"Europe/Moscow", "no HH:MM-HH:MM range"},
+		{"mon-fri 09:00-18:00 Mars/Olympus", "unknown time zone 'Mars/Olympus'"},
+		{"mon-fry 09:00-18:00", "invalid days 'mon-fry'"},
+		{"mon-+fri 09:00-18:00", "invalid days"},
+		{"09:00", "invalid range"},
+		{"mon 9-10", "invalid range '9-10'"},
+		{"09:00-09:00", "is empty"},
+		{"24:00-06:00", "start must be"},
+		{"09:00-24:30", "end must be"},
+		{"9:60-10:00", "start must be"},
+		{"09:00-18:00 " + strings.Repeat("x", MaxLength), "longer than"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.spec, time.UTC)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Parse(%q) error = %v, want it to mention %q", test.spec, err, test.want)
+		}
+	}
+}
@@ -24,58 +24,59 @@ type linuxInfo struct {
 
 // OfferAutostartSetup selects the appropriate init system and guides the operator through setup.
 // The function keeps user prompts sequential while delegating long-running work to helpers.
-func OfferAutostartSetup(appName string, interactive *InteractiveResult, rotation time.Duration) (*SystemdResult, error) {
-	reader := bufio.NewReader(os.Stdin)
+func OfferAutostartSetup(appName string, interactive *InteractiveResult, rotation time.Duration, prompts *Prompter) (*SystemdResult, error) {
 	if err := validateAutostartName(interactive.ServiceName); err != nil {
 		return nil, err
 	}
+	if interactive.UnitOutput != "" || interactive.InitOutput != "" {
+		return writeServiceOutputs(appName, interactive, rotation, os.Stdout)
+	}
 
 	switch runtime.GOOS {
 	case "linux":
-		return offerLinuxAutostartSetup(appName, interactive, rotation, reader)
+		return offerLinuxAutostartSetup(appName, interactive, rotation, prompts)
 	case "darwin":
-		return OfferLaunchdSetup(appName, interactive, rotation, reader)
+		return OfferLaunchdSetup(appName, interactive, rotation, prompts)
 	case "freebsd":
-		return OfferBSDRCSetup(appName, interactive, rotation, reader, "freebsd")
+		return OfferBSDRCSetup(appName, interactive, rotation, prompts, "freebsd")
 	case "openbsd":
-		return OfferBSDRCSetup(appName, interactive, rotation, reader, "openbsd")
+		return OfferBSDRCSetup(appName, interactive, rotation, prompts, "openbsd")
 	case "windows":
-		return OfferWindowsTaskSetup(appName, interactive, rotation, reader)
+		return OfferWindowsTaskSetup(appName, interactive, rotation, prompts)
 	default:
 		fmt.Printf("No supported autostart integration for %s; skipping autostart configuration.\n", runtime.GOOS)
 		return &SystemdResult{FollowLogs: false}, nil
 	}
 }
 
-func offerLinuxAutostartSetup(appName string, interactive *InteractiveResult, rotation time.Duration, reader *bufio.Reader) (*SystemdResult, error) {
+func offerLinuxAutostartSetup(appName string, interactive *InteractiveResult, rotation time.Duration, prompts *Prompter) (*SystemdResult, error) {
 	info := readLinuxInfo()
 	if info.ID != "" || info.VersionID != "" {
 		fmt.Printf("Detected Linux distribution: %s %s\n", info.ID, info.VersionID)
 	}
 
-	systemdAvailable := isSystemdAvailable()
-	initAvailable := isInitAvailable()
-
-	if systemdAvailable {
+	switch kind := detectLinuxServiceKind(); kind {
+	case serviceSystemd:
 		fmt.Println("Systemd detected, offering systemd autostart setup.")
-		return OfferSystemdSetup(appName, interactive, rotation)
-	}
-
-	if initAvailable {
+		return OfferSystemdSetup(appName, interactive, rotation, prompts)
+	case serviceRunit, serviceS6:
+		fmt.Printf("%s supervision detected, offering a %s service directory.\n", kind, kind)
+		return OfferSuperviseSetup(appName, interactive, rotation, prompts, kind)
+	case serviceInit:
 		fmt.Println("Systemd not found, using legacy init script setup.")
-		return OfferInitSetup(appName, interactive, rotation, reader)
+		return OfferInitSetup(appName, interactive, rotation, prompts)
+	default:
+		fmt.Println("No supported init system detected; skipping autostart configuration.")
+		return &SystemdResult{FollowLogs: false}, nil
 	}
-
-	fmt.Println("No supported init system detected; skipping autostart configuration.")
-	return &SystemdResult{FollowLogs: false}, nil
 }
 
 // ----- Legacy init workflow -----
 
 // OfferInitSetup creates a SysV-style init script and optionally enables and starts it.
 // Using a shared reader keeps the input flow consistent with systemd setup.
-func OfferInitSetup(appName string, interactive *InteractiveResult, rotation time.Duration, reader *bufio.Reader) (*SystemdResult, error) {
-	createInit, err := askYesDefault(reader, fmt.Sprintf("Create a legacy init script for '%s'?", interactive.ServiceName))
+func OfferInitSetup(appName string, interactive *InteractiveResult, rotation time.Duration, prompts *Prompter) (*SystemdResult, error) {
+	createInit, err := askYesDefault(prompts, "create_service", fmt.Sprintf("Create a legacy init script for '%s'?", interactive.ServiceName))
 	if err != nil {
 		return nil, err
 	}
@@ -88,14 +89,13 @@ func OfferInitSetup(appName string, interactive *InteractiveResult, rotation tim
 		return nil, fmt.Errorf("failed to resolve executable path: %v", err)
 	}
 
-	initName := initServiceName(interactive.ServiceName)
-	scriptContent := buildInitScript(appName, interactive, rotation, executable, initName)
-	scriptPath := filepath.Join("/etc/init.d", initName)
-	if err := os.WriteFile(scriptPath, []byte(scriptContent), 0755); err != nil {
+	script := buildInitFile(appName, interactive, rotation, executable)
+	initName := script.name
+	if err := os.WriteFile(filepath.Join("/etc/init.d", initName), []byte(script.content), 0755); err != nil {
 		return nil, fmt.Errorf("failed to write init script: %v", err)
 	}
 
-	enableInit, err := askYesDefault(reader, "Enable the init script so it starts on boot?")
+	enableInit, err := askYesDefault(prompts, "enable_service", "Enable the init script so it starts on boot?")
 	if err != nil {
 		return nil, err
 	}
@@ -106,7 +106,7 @@ func OfferInitSetup(appName string, interactive *InteractiveResult, rotation tim
 		}
 	}
 
-	startInit, err := askYesDefault(reader, "Start the service now?")
+	startInit, err := askYesDefault(prompts, "start_service", "Start the service now?")
 	if err != nil {
 		return nil, err
 	}
@@ -117,7 +117,7 @@ func OfferInitSetup(appName string, interactive *InteractiveResult, rotation tim
 		}
 	}
 
-	followLogs, err := askYesDefault(reader, "Follow the log file now?")
+	followLogs, err := askYesDefault(prompts, "follow_logs", "Follow the log file now?")
 	if err != nil {
 		return nil, err
 	}
@@ -128,8 +128,8 @@ func OfferInitSetup(appName string, interactive *InteractiveResult, rotation tim
 // ----- macOS launchd workflow -----
 
 // OfferLaunchdSetup creates a LaunchDaemon plist and optionally bootstraps it.
-func OfferLaunchdSetup(appName string, interactive *InteractiveResult, rotation time.Duration, reader *bufio.Reader) (*SystemdResult, error) {
-	createLaunchd, err := askYesDefault(reader, fmt.Sprintf("Create a macOS launchd daemon '%s'?", interactive.ServiceName))
+func OfferLaunchdSetup(appName string, interactive *InteractiveResult, rotation time.Duration, prompts *Prompter) (*SystemdResult, error) {
+	createLaunchd, err := askYesDefault(prompts, "create_service", fmt.Sprintf("Create a macOS launchd daemon '%s'?", interactive.ServiceName))
 	if err != nil {
 		return nil, err
 	}
@@ -148,7 +148,7 @@ func OfferLaunchdSetup(appName string, interactive *InteractiveResult, rotation
 		return nil, fmt.Errorf("failed to write launchd plist: %v", err)
 	}
 
-	enableLaunchd, err := askYesDefault(reader, "Enable the launchd daemon so it starts on boot?")
+	enableLaunchd, err := askYesDefault(prompts, "enable_service", "Enable the launchd daemon so it starts on boot?")
 	if err != nil {
 		return nil, err
 	}
@@ -158,7 +158,7 @@ func OfferLaunchdSetup(appName string, interactive *InteractiveResult, rotation
 		}
 	}
 
-	startLaunchd, err := askYesDefault(reader, "Start the daemon now?")
+	startLaunchd, err := askYesDefault(prompts, "start_service", "Start the daemon now?")
 	if err != nil {
 		return nil, err
 	}
@@ -168,7 +168,7 @@ func OfferLaunchdSetup(appName string, interactive *InteractiveResult, rotation
 		}
 	}
 
-	followLogs, err := askYesDefault(reader, "Follow the log file now?")
+	followLogs, err := askYesDefault(prompts, "follow_logs", "Follow the log file now?")
 	if err != nil {
 		return nil, err
 	}
@@ -178,8 +178,8 @@ func OfferLaunchdSetup(appName string, interactive *InteractiveResult, rotation
 // ----- BSD rc.d workflow -----
 
 // OfferBSDRCSetup creates an rc.d script for FreeBSD or OpenBSD and optionally enables it.
-func OfferBSDRCSetup(appName string, interactive *InteractiveResult, rotation time.Duration, reader *bufio.Reader, osName string) (*SystemdResult, error) {
-	createRC, err := askYesDefault(reader, fmt.Sprintf("Create a %s rc.d service '%s'?", osName, interactive.ServiceName))
+func OfferBSDRCSetup(appName string, interactive *InteractiveResult, rotation time.Duration, prompts *Prompter, osName string) (*SystemdResult, error) {
+	createRC, err := askYesDefault(prompts, "create_service", fmt.Sprintf("Create a %s rc.d service '%s'?", osName, interactive.ServiceName))
 	if err != nil {
 		return nil, err
 	}
@@ -198,7 +198,7 @@ func OfferBSDRCSetup(appName string, interactive *InteractiveResult, rotation ti
 		return nil, fmt.Errorf("failed to write rc.d script: %v", err)
 	}
 
-	enableRC, err := askYesDefault(reader, "Enable the rc.d service so it starts on boot?")
+	enableRC, err := askYesDefault(prompts, "enable_service", "Enable the rc.d service so it starts on boot?")
 	if err != nil {
 		return nil, err
 	}
@@ -208,7 +208,7 @@ func OfferBSDRCSetup(appName string, interactive *InteractiveResult, rotation ti
 		}
 	}
 
-	startRC, err := askYesDefault(reader, "Start the service now?")
+	startRC, err := askYesDefault(prompts, "start_service", "Start the service now?")
 	if err != nil {
 		return nil, err
 	}
@@ -218,7 +218,7 @@ func OfferBSDRCSetup(appName string, interactive *InteractiveResult, rotation ti
 		}
 	}
 
-	followLogs, err := askYesDefault(reader, "Follow the log file now?")
+	followLogs, err := askYesDefault(prompts, "follow_logs", "Follow the log file now?")
 	if err != nil {
 		return nil, err
 	}
@@ -228,8 +228,8 @@ func OfferBSDRCSetup(appName string, interactive *InteractiveResult, rotation ti
 // ----- Windows Task Scheduler workflow -----
 
 // OfferWindowsTaskSetup uses Task Scheduler because console binaries are not native Windows services.
-func OfferWindowsTaskSetup(appName string, interactive *InteractiveResult, rotation time.Duration, reader *bufio.Reader) (*SystemdResult, error) {
-	createTask, err := askYesDefault(reader, fmt.Sprintf("Create a Windows startup task '%s'?", interactive.ServiceName))
+func OfferWindowsTaskSetup(appName string, interactive *InteractiveResult, rotation time.Duration, prompts *Prompter) (*SystemdResult, error) {
+	createTask, err := askYesDefault(prompts, "create_service", fmt.Sprintf("Create a Windows startup task '%s'?", interactive.ServiceName))
 	if err != nil {
 		return nil, err
 	}
@@ -247,7 +247,7 @@ func OfferWindowsTaskSetup(appName string, interactive *InteractiveResult, rotat
 		return nil, err
 	}
 
-	startTask, err := askYesDefault(reader, "Start the task now?")
+	startTask, err := askYesDefault(prompts, "start_service", "Start the task now?")
 	if err != nil {
 		return nil, err
 	}
@@ -257,7 +257,7 @@ func OfferWindowsTaskSetup(appName string, interactive *InteractiveResult, rotat
 		}
 	}
 
-	followLogs, err := askYesDefault(reader, "Follow the log file now?")
+	followLogs, err := askYesDefault(prompts, "follow_logs", "Follow the log file now?")
 	if err != nil {
 		return nil, err
 	}
@@ -327,6 +327,12 @@ func initServiceName(serviceName string) string {
 	return strings.TrimSuffix(serviceName, ".service")
 }
 
+// buildInitFile renders the init script under the name it is installed as in /etc/init.d.
+func buildInitFile(appName string, interactive *InteractiveResult, rotation time.Duration, executable string) serviceFile {
+	initName := initServiceName(interactive.ServiceName)
+	return serviceFile{name: initName, content: buildInitScript(appName, interactive, rotation, executable, initName)}
+}
+
 // buildInitScript renders a SysV-style init script with start/stop commands.
 // Using a pidfile keeps lifecycle management simple without extra dependencies.
 func buildInitScript(appName string, interactive *InteractiveResult, rotation time.Duration, executable, initName string) string {
@@ -643,15 +649,15 @@ func buildArgs(interactive *InteractiveResult, rotation time.Duration) []string
 	for _, allowValue := range interactive.AllowFlags {
 		args = append(args, fmt.Sprintf("-allow=%s", allowValue))
 	}
-	args = append(args, fmt.Sprintf("-log=%s", interactive.LogFile))
+	// Services start long after setup, when a cleaned or per-boot log tree may lack the directory again.
+	args = append(args, fmt.Sprintf("-log=%s", interactive.LogFile), "-log-mkdir")
 	args = append(args, fmt.Sprintf("-rotation=%s", rotation.String()))
 	return args
 }
 
 // askYesDefault keeps destructive-looking setup prompts explicit while matching the installer's happy path.
-func askYesDefault(reader *bufio.Reader, prompt string) (bool, error) {
-	fmt.Print(colorize(greenText, prompt+" (Y/n): "))
-	answer, err := readTrimmed(reader)
+func askYesDefault(prompts *Prompter, key, prompt string) (bool, error) {
+	answer, err := prompts.ask(key, prompt+" (Y/n)", "")
 	if err != nil {
 		return false, err
 	}
@@ -1,9 +1,13 @@
 package setup
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
 )
 
 func TestSystemdUnitNameAddsSuffix(t *testing.T) {
@@ -96,6 +100,32 @@ func TestBuildUnitFileQuotesExecStart(t *testing.T) {
 	}
 }
 
+func TestBuildSocketUnitsNamesDescriptorsPerRoute(t *testing.T) {
+	result := &InteractiveResult{
+		ServiceName:      "chicha-ip-proxy",
+		TCPRoutes:        []config.Route{{LocalPort: "443", RemoteIP: "203.0.113.10", RemotePort: "443"}},
+		UDPRoutes:        []config.Route{{LocalPort: "53", RemoteIP: "203.0.113.20", RemotePort: "53"}},
+		LogFile:          "/var/log/chicha-ip-proxy.log",
+		SocketActivation: true,
+	}
+
+	units := buildSocketUnits("chicha-ip-proxy", result)
+	if len(units) != 2 {
+		t.Fatalf("buildSocketUnits returned %d units, want 2", len(units))
+	}
+	if units[0].name != "chicha-ip-proxy-tcp-443.socket" || !strings.Contains(units[0].content, "ListenStream=443\n") || !strings.Contains(units[0].content, "FileDescriptorName=tcp-443\n") {
+		t.Fatalf("unexpected TCP socket unit %s:\n%s", units[0].name, units[0].content)
+	}
+	if !strings.Contains(units[1].content, "ListenDatagram=53\n") || !strings.Contains(units[1].content, "Service=chicha-ip-proxy.service\n") {
+		t.Fatalf("unexpected UDP socket unit %s:\n%s", units[1].name, units[1].content)
+	}
+
+	unit := buildUnitFile("chicha-ip-proxy", result, time.Hour, "/usr/local/bin/chicha-ip-proxy")
+	if !strings.Contains(unit, "Requires=chicha-ip-proxy-tcp-443.socket chicha-ip-proxy-udp-53.socket\n") {
+		t.Fatalf("service unit does not require its sockets:\n%s", unit)
+	}
+}
+
 func TestValidateAutostartNameRejectsUnsafeCharacters(t *testing.T) {
 	if err := validateAutostartName("chicha-ip-proxy.tcp_8080"); err != nil {
 		t.Fatalf("validateAutostartName rejected safe name: %v", err)
@@ -117,3 +147,104 @@ func TestWindowsTaskCommandQuotesExecutableAndArgs(t *testing.T) {
 		t.Fatalf("windowsTaskCommand = %q, want %q", command, want)
 	}
 }
+
+func TestBuildSuperviseScriptsExecTheProxy(t *testing.T) {
+	result := &InteractiveResult{
+		ServiceName: "chicha-ip-proxy-tcp-8080",
+		LocalFlag:   "8080",
+		RemoteFlag:  "203.0.113.20",
+		LogFile:     "/var/log/chicha ip proxy.log",
+	}
+
+	run := buildSuperviseRunScript(result, time.Hour, "/usr/local/bin/chicha-ip-proxy")
+	if !strings.Contains(run, "exec '/usr/local/bin/chicha-ip-proxy' '-local=8080' '-remote=203.0.113.20' '-log=/var/log/chicha ip proxy.log'") {
+		t.Fatalf("run script should exec the quoted command line:\n%s", run)
+	}
+	if strings.Contains(run, "nohup") || strings.Contains(run, "&\n") {
+		t.Fatalf("run script must stay in the foreground:\n%s", run)
+	}
+
+	logRun := buildSuperviseLogScript(detectSuperviseLayout(serviceRunit), "/var/log/chicha-ip-proxy-tcp-8080")
+	if !strings.Contains(logRun, "exec svlogd -tt '/var/log/chicha-ip-proxy-tcp-8080'") {
+		t.Fatalf("runit log script should exec svlogd:\n%s", logRun)
+	}
+}
+
+func TestWriteServiceFilesPlacesSocketUnitsBesideOutput(t *testing.T) {
+	files := []serviceFile{
+		{name: "chicha-ip-proxy.service", content: "[Service]\n"},
+		{name: "chicha-ip-proxy-tcp-443.socket", content: "[Socket]\n"},
+	}
+
+	dir := t.TempDir()
+	var stdout strings.Builder
+	if err := writeServiceFiles(filepath.Join(dir, "review.service"), files, 0644, &stdout); err != nil {
+		t.Fatalf("writeServiceFiles returned error: %v", err)
+	}
+	for name, want := range map[string]string{"review.service": "[Service]\n", "chicha-ip-proxy-tcp-443.socket": "[Socket]\n"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(got) != want {
+			t.Fatalf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+
+	stdout.Reset()
+	if err := writeServiceFiles("-", files, 0644, &stdout); err != nil {
+		t.Fatalf("writeServiceFiles returned error: %v", err)
+	}
+	want := "# chicha-ip-proxy.service\n[Service]\n# chicha-ip-proxy-tcp-443.socket\n[Socket]\n"
+	if stdout.String() != want {
+		t.Fatalf("stdout = %q, want %q", stdout.String(), want)
+	}
+}
+
+func TestBuildUnitFileRunsAsServiceUser(t *testing.T) {
+	result := &InteractiveResult{
+		ServiceName: "chicha-ip-proxy-tcp-443",
+		TCPRoutes:   []config.Route{{LocalPort: "443", RemoteIP: "203.0.113.10", RemotePort: "443"}},
+		LogFile:     serviceLogFile("chicha-ip-proxy", "/var/log/chicha-ip-proxy-tcp-443.log"),
+		User:        "alice",
+		Group:       "staff",
+	}
+	if result.LogFile != "/var/log/chicha-ip-proxy/chicha-ip-proxy-tcp-443.log" {
+		t.Fatalf("service log file = %s, want it in a directory the user can own", result.LogFile)
+	}
+
+	unit := buildUnitFile("chicha-ip-proxy", result, time.Hour, "/usr/local/bin/chicha-ip-proxy")
+	for _, want := range []string{"User=alice\n", "Group=staff\n", "AmbientCapabilities=CAP_NET_BIND_SERVICE\n", "LogsDirectory=chicha-ip-proxy\n"} {
+		if !strings.Contains(unit, want) {
+			t.Fatalf("unit file missing %q:\n%s", want, unit)
+		}
+	}
+
+	result.User, result.TCPRoutes[0].LocalPort = "", "8443"
+	if unit := buildUnitFile("chicha-ip-proxy", result, time.Hour, "/usr/local/bin/chicha-ip-proxy"); strings.Contains(unit, "User=") || strings.Contains(unit, "AmbientCapabilities") {
+		t.Fatalf("unit file without a service user changed:\n%s", unit)
+	}
+}
+
+func TestBuildUnitFileUsesTypeNotifyWithWatchdog(t *testing.T) {
+	result := &InteractiveResult{
+		TCPRoutes: []config.Route{{LocalPort: "8080", RemoteIP: "203.0.113.10", RemotePort: "80"}},
+		LogFile:   "/var/log/chicha-ip-proxy.log",
+	}
+	if unit := buildUnitFile("chicha-ip-proxy", result, time.Hour, "/usr/local/bin/chicha-ip-proxy"); !strings.Contains(unit, "Type=simple\n") || strings.Contains(unit, "WatchdogSec") {
+		t.Fatalf("default unit changed:\n%s", unit)
+	}
+
+	result.Notify, result.Watchdog = true, 30*time.Second
+	unit := buildUnitFile("chicha-ip-proxy", result, time.Hour, "/usr/local/bin/chicha-ip-proxy")
+	if !strings.Contains(unit, "[Service]\nType=notify\nWatchdogSec=30\nExecStart=") {
+		t.Fatalf("notify unit lacks Type=notify and WatchdogSec=30:\n%s", unit)
+	}
+	result.Watchdog = 1500 * time.Millisecond
+	if unit := buildUnitFile("chicha-ip-proxy", result, time.Hour, "/usr/local/bin/chicha-ip-proxy"); !strings.Contains(unit, "WatchdogSec=1500ms\n") {
+		t.Fatalf("fractional watchdog not written in milliseconds:\n%s", unit)
+	}
+}
+
+func TestLookupServiceAccountRejectsUnknownUser(t *testing.T) {
+	if _, err := lookupServiceAccount("chicha-no-such-user"); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("lookupServiceAccount error = %v, want a missing user", err)
+	}
+}
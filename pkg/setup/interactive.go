@@ -13,6 +13,7 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/matveynator/chicha-ip-proxy/pkg/branding"
 	"github.com/matveynator/chicha-ip-proxy/pkg/config"
@@ -43,6 +44,18 @@ type InteractiveResult struct {
 	RoutesFlag    string
 	UDPRoutesFlag string
 	AllowFlags    []string
+	// SocketActivation makes systemd setup emit one .socket unit per route so systemd binds the ports.
+	SocketActivation bool
+	// UnitOutput and InitOutput write the systemd unit or init script to this path, or stdout for "-", instead of installing it.
+	UnitOutput string
+	InitOutput string
+	// User and Group are the account the systemd service runs as; empty leaves it to root.
+	User  string
+	Group string
+	// Notify makes the systemd service Type=notify, so units ordered after it wait until the proxy reports ready.
+	Notify bool
+	// Watchdog becomes WatchdogSec= of a Notify service; zero leaves the watchdog off.
+	Watchdog time.Duration
 }
 
 type setupDraft struct {
@@ -55,14 +68,13 @@ type setupDraft struct {
 
 // RunInteractiveSetup asks the operator for one route and source restrictions when flags are absent.
 // The final review loop makes the generated service explicit before any system files are changed.
-func RunInteractiveSetup(appName string) (*InteractiveResult, error) {
-	reader := bufio.NewReader(os.Stdin)
+func RunInteractiveSetup(appName string, prompts *Prompter) (*InteractiveResult, error) {
 	draft := setupDraft{Protocol: "tcp"}
 
-	printSetupHeader()
+	printSetupHeader(prompts)
 
 	for {
-		if err := askInitialSetup(reader, &draft); err != nil {
+		if err := askInitialSetup(prompts, &draft); err != nil {
 			return nil, err
 		}
 
@@ -72,8 +84,8 @@ func RunInteractiveSetup(appName string) (*InteractiveResult, error) {
 				return nil, err
 			}
 
-			printConfigReview(result)
-			choice, err := askReviewChoice(reader)
+			printConfigReview(prompts, result)
+			choice, err := askReviewChoice(prompts)
 			if err != nil {
 				return nil, err
 			}
@@ -84,39 +96,41 @@ func RunInteractiveSetup(appName string) (*InteractiveResult, error) {
 				return nil, ErrSetupCancelled
 			}
 
-			if err := applyReviewEdit(reader, appName, &draft, choice); err != nil {
+			if err := applyReviewEdit(prompts, appName, &draft, choice); err != nil {
 				return nil, err
 			}
 		}
 	}
 }
 
-func printSetupHeader() {
+func printSetupHeader(prompts *Prompter) {
+	if prompts.plain {
+		return
+	}
 	fmt.Print(branding.Banner)
 	fmt.Printf(colorize(cyanText, "Operating system: %s\n"), runtime.GOOS)
 	fmt.Println(colorize(yellowText, "Ctrl+C exits without saving."))
 	fmt.Println()
 }
 
-func askInitialSetup(reader *bufio.Reader, draft *setupDraft) error {
-	if err := askTargetIP(reader, draft); err != nil {
+func askInitialSetup(prompts *Prompter, draft *setupDraft) error {
+	if err := askTargetIP(prompts, draft); err != nil {
 		return err
 	}
-	if err := askRemotePort(reader, draft); err != nil {
+	if err := askRemotePort(prompts, draft); err != nil {
 		return err
 	}
-	if err := askLocalPort(reader, draft); err != nil {
+	if err := askLocalPort(prompts, draft); err != nil {
 		return err
 	}
-	if err := askProtocol(reader, draft); err != nil {
+	if err := askProtocol(prompts, draft); err != nil {
 		return err
 	}
-	return askAllowedClients(reader, draft)
+	return askAllowedClients(prompts, draft)
 }
 
-func askTargetIP(reader *bufio.Reader, draft *setupDraft) error {
-	fmt.Print(colorize(greenText, promptWithDefault("1) Target IP", draft.TargetIP)))
-	targetIP, err := readTrimmed(reader)
+func askTargetIP(prompts *Prompter, draft *setupDraft) error {
+	targetIP, err := prompts.ask("target_ip", "1) Target IP", draft.TargetIP)
 	if err != nil {
 		return err
 	}
@@ -130,9 +144,8 @@ func askTargetIP(reader *bufio.Reader, draft *setupDraft) error {
 	return nil
 }
 
-func askRemotePort(reader *bufio.Reader, draft *setupDraft) error {
-	fmt.Print(colorize(greenText, promptWithDefault("2) Remote port", draft.RemotePort)))
-	port, err := readTrimmed(reader)
+func askRemotePort(prompts *Prompter, draft *setupDraft) error {
+	port, err := prompts.ask("remote_port", "2) Remote port", draft.RemotePort)
 	if err != nil {
 		return err
 	}
@@ -146,13 +159,12 @@ func askRemotePort(reader *bufio.Reader, draft *setupDraft) error {
 	return nil
 }
 
-func askLocalPort(reader *bufio.Reader, draft *setupDraft) error {
+func askLocalPort(prompts *Prompter, draft *setupDraft) error {
 	defaultLocalPort := draft.LocalPort
 	if defaultLocalPort == "" {
 		defaultLocalPort = draft.RemotePort
 	}
-	fmt.Print(colorize(greenText, promptWithDefault("3) Local port", defaultLocalPort)))
-	localPort, err := readTrimmed(reader)
+	localPort, err := prompts.ask("local_port", "3) Local port", defaultLocalPort)
 	if err != nil {
 		return err
 	}
@@ -163,13 +175,12 @@ func askLocalPort(reader *bufio.Reader, draft *setupDraft) error {
 		return err
 	}
 	draft.LocalPort = localPort
-	printLocalPortStatuses(localPort)
+	printLocalPortStatuses(prompts, localPort)
 	return nil
 }
 
-func askProtocol(reader *bufio.Reader, draft *setupDraft) error {
-	fmt.Print(colorize(greenText, promptWithDefault("4) Protocol", draft.Protocol)))
-	protocol, err := readTrimmed(reader)
+func askProtocol(prompts *Prompter, draft *setupDraft) error {
+	protocol, err := prompts.ask("protocol", "4) Protocol", draft.Protocol)
 	if err != nil {
 		return err
 	}
@@ -182,18 +193,17 @@ func askProtocol(reader *bufio.Reader, draft *setupDraft) error {
 	}
 	draft.Protocol = protocol
 	if draft.LocalPort != "" {
-		printProtocolPortStatus(draft.LocalPort, protocol)
+		printProtocolPortStatus(prompts, draft.LocalPort, protocol)
 	}
 	return nil
 }
 
-func askAllowedClients(reader *bufio.Reader, draft *setupDraft) error {
+func askAllowedClients(prompts *Prompter, draft *setupDraft) error {
 	defaultAllowRaw := draft.AllowRaw
 	if defaultAllowRaw == "" {
 		defaultAllowRaw = "all"
 	}
-	fmt.Print(colorize(greenText, promptWithDefault("5) Allowed client IPs/CIDRs", defaultAllowRaw)))
-	allowRaw, err := readTrimmed(reader)
+	allowRaw, err := prompts.ask("allowed_clients", "5) Allowed client IPs/CIDRs", defaultAllowRaw)
 	if err != nil {
 		return err
 	}
@@ -210,18 +220,17 @@ func askAllowedClients(reader *bufio.Reader, draft *setupDraft) error {
 	return nil
 }
 
-func askReviewChoice(reader *bufio.Reader) (string, error) {
-	fmt.Println(colorize(yellowText, "Change something before writing the service?"))
-	fmt.Println(colorize(cyanText, "  1) Change target IP"))
-	fmt.Println(colorize(cyanText, "  2) Change remote port"))
-	fmt.Println(colorize(cyanText, "  3) Change local port"))
-	fmt.Println(colorize(cyanText, "  4) Change protocol"))
-	fmt.Println(colorize(cyanText, "  5) Change allowed clients"))
-	fmt.Println(colorize(cyanText, "  6) Save and continue"))
-	fmt.Println(colorize(cyanText, "  7) Exit without saving"))
-	fmt.Print(colorize(greenText, "Choose [6]: "))
+func askReviewChoice(prompts *Prompter) (string, error) {
+	prompts.say(yellowText, "Change something before writing the service?")
+	prompts.say(cyanText, "  1) Change target IP")
+	prompts.say(cyanText, "  2) Change remote port")
+	prompts.say(cyanText, "  3) Change local port")
+	prompts.say(cyanText, "  4) Change protocol")
+	prompts.say(cyanText, "  5) Change allowed clients")
+	prompts.say(cyanText, "  6) Save and continue")
+	prompts.say(cyanText, "  7) Exit without saving")
 
-	choice, err := readTrimmed(reader)
+	choice, err := prompts.ask("review", "Choose", "6")
 	if err != nil {
 		return "", err
 	}
@@ -236,19 +245,19 @@ func askReviewChoice(reader *bufio.Reader) (string, error) {
 	}
 }
 
-func applyReviewEdit(reader *bufio.Reader, appName string, draft *setupDraft, choice string) error {
+func applyReviewEdit(prompts *Prompter, appName string, draft *setupDraft, choice string) error {
 	var err error
 	switch choice {
 	case "1":
-		err = askTargetIP(reader, draft)
+		err = askTargetIP(prompts, draft)
 	case "2":
-		err = askRemotePort(reader, draft)
+		err = askRemotePort(prompts, draft)
 	case "3":
-		err = askLocalPort(reader, draft)
+		err = askLocalPort(prompts, draft)
 	case "4":
-		err = askProtocol(reader, draft)
+		err = askProtocol(prompts, draft)
 	case "5":
-		err = askAllowedClients(reader, draft)
+		err = askAllowedClients(prompts, draft)
 	default:
 		return nil
 	}
@@ -259,7 +268,7 @@ func applyReviewEdit(reader *bufio.Reader, appName string, draft *setupDraft, ch
 	if err != nil {
 		return err
 	}
-	printConfigReview(result)
+	printConfigReview(prompts, result)
 	return nil
 }
 
@@ -294,26 +303,23 @@ func buildInteractiveResult(appName string, draft setupDraft) (*InteractiveResul
 	}, nil
 }
 
-func printConfigReview(result *InteractiveResult) {
-	fmt.Println()
-	fmt.Println(colorize(purpleText, "Connection"))
-	printRoutes("TCP", result.TCPRoutes)
-	printRoutes("UDP", result.UDPRoutes)
-	fmt.Printf(colorize(cyanText, "     protocol %s carries the traffic\n"), strings.ToUpper(result.ProtoFlag))
-	fmt.Printf(colorize(cyanText, "     clients  %s\n"), allowListText(result.AllowFlags))
-	fmt.Printf(colorize(cyanText, "     log      %s\n"), result.LogFile)
-	fmt.Printf(colorize(cyanText, "     service  %s\n"), result.ServiceName)
-	fmt.Printf(colorize(cyanText, "     command  %s\n"), setupCommandText(result))
-	fmt.Println()
+// printConfigReview shows the generated setup; plain mode reports it as REVIEW:key=value lines.
+func printConfigReview(prompts *Prompter, result *InteractiveResult) {
+	prompts.say("", "")
+	prompts.say(purpleText, "Connection")
+	printRoutes(prompts, result.TCPRoutes)
+	printRoutes(prompts, result.UDPRoutes)
+	prompts.report("REVIEW", "protocol", result.ProtoFlag, cyanText, fmt.Sprintf("     protocol %s carries the traffic", strings.ToUpper(result.ProtoFlag)))
+	prompts.report("REVIEW", "allowed_clients", strings.Join(result.AllowFlags, ","), cyanText, "     clients  "+allowListText(result.AllowFlags))
+	prompts.report("REVIEW", "log", result.LogFile, cyanText, "     log      "+result.LogFile)
+	prompts.report("REVIEW", "service", result.ServiceName, cyanText, "     service  "+result.ServiceName)
+	prompts.report("REVIEW", "command", setupCommandText(result), cyanText, "     command  "+setupCommandText(result))
+	prompts.say("", "")
 }
 
-func printRoutes(label string, routes []config.Route) {
-	if len(routes) == 0 {
-		return
-	}
-
+func printRoutes(prompts *Prompter, routes []config.Route) {
 	for _, route := range routes {
-		fmt.Printf(colorize(cyanText, "     :%s on this machine  ->  %s\n"), route.LocalPort, route.RemoteAddress())
+		prompts.report("REVIEW", "route", route.LocalPort+">"+route.RemoteAddress(), cyanText, fmt.Sprintf("     :%s on this machine  ->  %s", route.LocalPort, route.RemoteAddress()))
 	}
 }
 
@@ -353,15 +359,27 @@ type localPortStatus struct {
 	Err       error
 }
 
-func printLocalPortStatuses(port string) {
-	fmt.Printf(colorize(yellowText, "Local port %s status now:\n"), port)
+func printLocalPortStatuses(prompts *Prompter, port string) {
+	prompts.say(yellowText, fmt.Sprintf("Local port %s status now:", port))
 	for _, status := range checkLocalPortStatuses(port) {
-		fmt.Println(formatLocalPortStatus(status))
+		printPortStatus(prompts, status)
 	}
 }
 
-func printProtocolPortStatus(port, protocol string) {
-	status := checkLocalPortStatus(port, protocol)
+func printProtocolPortStatus(prompts *Prompter, port, protocol string) {
+	printPortStatus(prompts, checkLocalPortStatus(port, protocol))
+}
+
+// printPortStatus reports STATUS:local_port_tcp=free or =busy in plain mode.
+func printPortStatus(prompts *Prompter, status localPortStatus) {
+	if prompts.plain {
+		state := "busy"
+		if status.Available {
+			state = "free"
+		}
+		fmt.Printf("STATUS:local_port_%s=%s\n", status.Protocol, state)
+		return
+	}
 	fmt.Println(formatLocalPortStatus(status))
 }
 
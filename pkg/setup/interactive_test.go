@@ -3,6 +3,8 @@ package setup
 import (
 	"bufio"
 	"errors"
+	"io"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
@@ -78,6 +80,7 @@ func TestBuildArgsIncludesAllowFlags(t *testing.T) {
 		"-remote=203.0.113.20",
 		"-allow=198.51.100.7",
 		"-log=" + defaultLogFile("chicha-ip-proxy", "tcp-8080"),
+		"-log-mkdir",
 		"-rotation=1h0m0s",
 	}
 	if !reflect.DeepEqual(args, want) {
@@ -139,7 +142,7 @@ func TestPromptWithDefaultShowsCurrentValue(t *testing.T) {
 }
 
 func TestAskReviewChoiceAcceptsExitWithoutSaving(t *testing.T) {
-	choice, err := askReviewChoice(bufio.NewReader(strings.NewReader("7\n")))
+	choice, err := askReviewChoice(&Prompter{reader: bufio.NewReader(strings.NewReader("7\n"))})
 	if err != nil {
 		t.Fatalf("askReviewChoice returned error: %v", err)
 	}
@@ -149,7 +152,7 @@ func TestAskReviewChoiceAcceptsExitWithoutSaving(t *testing.T) {
 }
 
 func TestAskReviewChoiceDefaultsToSaveAndContinue(t *testing.T) {
-	choice, err := askReviewChoice(bufio.NewReader(strings.NewReader("\n")))
+	choice, err := askReviewChoice(&Prompter{reader: bufio.NewReader(strings.NewReader("\n"))})
 	if err != nil {
 		t.Fatalf("askReviewChoice returned error: %v", err)
 	}
@@ -189,3 +192,72 @@ func TestFormatLocalPortStatus(t *testing.T) {
 		t.Fatalf("busy status text = %q", busyStatus)
 	}
 }
+
+func TestRunInteractiveSetupPlainFormatIsScriptable(t *testing.T) {
+	prompts, err := NewPrompter(strings.NewReader("203.0.113.10\n443\n\nudp\n198.51.100.0/24\n\n"), FormatPlain)
+	if err != nil {
+		t.Fatalf("NewPrompter returned error: %v", err)
+	}
+
+	var result *InteractiveResult
+	output := captureSetupOutput(t, func() {
+		result, err = RunInteractiveSetup("chicha-ip-proxy", prompts)
+	})
+	if err != nil {
+		t.Fatalf("RunInteractiveSetup returned error: %v", err)
+	}
+	if len(result.UDPRoutes) != 1 || result.UDPRoutes[0].LocalPort != "443" {
+		t.Fatalf("UDP routes = %#v", result.UDPRoutes)
+	}
+	if strings.Contains(output, "\033") {
+		t.Fatalf("plain output contains ANSI escapes:\n%s", output)
+	}
+
+	var prompted []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "PROMPT:") {
+			prompted = append(prompted, line)
+		}
+	}
+	want := []string{
+		"PROMPT:target_ip",
+		"PROMPT:remote_port",
+		"PROMPT:local_port default=443",
+		"PROMPT:protocol default=tcp",
+		"PROMPT:allowed_clients default=all",
+		"PROMPT:review default=6",
+	}
+	if !reflect.DeepEqual(prompted, want) {
+		t.Fatalf("prompts = %q, want %q", prompted, want)
+	}
+	if !strings.Contains(output, "REVIEW:allowed_clients=198.51.100.0/24\n") {
+		t.Fatalf("review lines missing from output:\n%s", output)
+	}
+}
+
+func TestNewPrompterRejectsUnknownFormat(t *testing.T) {
+	if _, err := NewPrompter(strings.NewReader(""), "fancy"); err == nil {
+		t.Fatal("NewPrompter accepted an unknown format")
+	}
+}
+
+func captureSetupOutput(t *testing.T, fn func()) string {
+	t.Helper()
+
+	originalStdout := os.Stdout
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe returned error: %v", err)
+	}
+	output := make(chan string, 1)
+	go func() {
+		content, _ := io.ReadAll(reader)
+		output <- string(content)
+	}()
+
+	os.Stdout = writer
+	fn()
+	os.Stdout = originalStdout
+	writer.Close()
+	return <-output
+}
@@ -0,0 +1,58 @@
+// Service files can be written to a chosen path, or stdout, instead of being installed.
+// Generating without installing needs no root and lets operators review a unit, or drop it into ~/.config/systemd/user, themselves.
+package setup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// writeServiceOutputs renders the systemd unit and/or init script requested by UnitOutput and InitOutput.
+// Nothing is enabled or started and systemctl is never called, so the proxy's own run ends here too.
+func writeServiceOutputs(appName string, interactive *InteractiveResult, rotation time.Duration, stdout io.Writer) (*SystemdResult, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve executable path: %v", err)
+	}
+
+	if interactive.UnitOutput != "" {
+		if err := writeServiceFiles(interactive.UnitOutput, buildSystemdFiles(appName, interactive, rotation, executable), 0644, stdout); err != nil {
+			return nil, err
+		}
+	}
+	if interactive.InitOutput != "" {
+		if err := writeServiceFiles(interactive.InitOutput, []serviceFile{buildInitFile(appName, interactive, rotation, executable)}, 0755, stdout); err != nil {
+			return nil, err
+		}
+	}
+	return &SystemdResult{FollowLogs: false}, nil
+}
+
+// writeServiceFiles writes the first file to output and any socket units beside it under their unit names.
+// For "-" every file goes to stdout, each behind a comment naming it when there is more than one.
+func writeServiceFiles(output string, files []serviceFile, mode os.FileMode, stdout io.Writer) error {
+	if output == "-" {
+		for _, file := range files {
+			if len(files) > 1 {
+				fmt.Fprintf(stdout, "# %s\n", file.name)
+			}
+			fmt.Fprint(stdout, file.content)
+		}
+		return nil
+	}
+
+	for i, file := range files {
+		path := output
+		if i > 0 {
+			path = filepath.Join(filepath.Dir(output), file.name)
+		}
+		if err := os.WriteFile(path, []byte(file.content), mode); err != nil {
+			return fmt.Errorf("failed to write %s: %v", file.name, err)
+		}
+		fmt.Fprintf(stdout, "Wrote %s to %s\n", file.name, path)
+	}
+	return nil
+}
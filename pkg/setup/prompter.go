@@ -0,0 +1,70 @@
+// The prompter owns the setup conversation: one input reader and one output style for every question.
+// The plain style trades the friendly colors for PROMPT:key lines so scripts can drive first-run setup reliably.
+package setup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Supported values for the -setup-format flag.
+const (
+	FormatColor = "color"
+	FormatPlain = "plain"
+)
+
+// Prompter asks setup questions and reads the answers.
+// Sharing one Prompter between the route wizard and autostart setup keeps a single buffered reader,
+// so answers piped in ahead of time are never swallowed by a second reader.
+type Prompter struct {
+	reader *bufio.Reader
+	plain  bool
+}
+
+// NewPrompter reads answers from input and renders prompts in the given format.
+func NewPrompter(input io.Reader, format string) (*Prompter, error) {
+	switch format {
+	case "", FormatColor:
+		return &Prompter{reader: bufio.NewReader(input)}, nil
+	case FormatPlain:
+		return &Prompter{reader: bufio.NewReader(input), plain: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown setup format '%s' (expected color or plain)", format)
+	}
+}
+
+// ask shows one question and returns the trimmed answer.
+// Plain prompts are whole lines, "PROMPT:key" or "PROMPT:key default=value", so expect-style tools can match them exactly.
+func (prompts *Prompter) ask(key, label, currentValue string) (string, error) {
+	if prompts.plain {
+		if currentValue == "" {
+			fmt.Printf("PROMPT:%s\n", key)
+		} else {
+			fmt.Printf("PROMPT:%s default=%s\n", key, currentValue)
+		}
+	} else {
+		fmt.Print(colorize(greenText, promptWithDefault(label, currentValue)))
+	}
+	return readTrimmed(prompts.reader)
+}
+
+// say prints a line for people; plain mode leaves it out because scripts only need prompts and reports.
+func (prompts *Prompter) say(color, message string) {
+	switch {
+	case prompts.plain:
+	case message == "":
+		fmt.Println()
+	default:
+		fmt.Println(colorize(color, message))
+	}
+}
+
+// report prints a fact a script may want, as "KIND:key=value" in plain mode or a colored line otherwise.
+func (prompts *Prompter) report(kind, key, value, color, message string) {
+	if prompts.plain {
+		fmt.Printf("%s:%s=%s\n", kind, key, value)
+		return
+	}
+	fmt.Println(colorize(color, message))
+}
@@ -0,0 +1,111 @@
+// Setup usually runs under sudo, so everything it creates would belong to root and the service would run as root.
+// When sudo reports who invoked it, systemd setup offers to run the service as that user and hands the log over to them.
+package setup
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// serviceAccount is an existing user and primary group a generated service runs as.
+type serviceAccount struct {
+	user  string
+	group string
+	uid   int
+	gid   int
+}
+
+// sudoUser returns the account that started setup through sudo, or "" when there is none or it was root itself.
+func sudoUser() string {
+	name := os.Getenv("SUDO_USER")
+	if name == "root" {
+		return ""
+	}
+	return name
+}
+
+// offerServiceUser asks whether the systemd service should run as the sudo user and, if so, prepares the log for that user.
+// It changes interactive in place, so the unit and the caller see the new User, Group and LogFile.
+func offerServiceUser(appName string, interactive *InteractiveResult, prompts *Prompter) error {
+	name := sudoUser()
+	if name == "" || interactive.User != "" {
+		return nil
+	}
+	runAsUser, err := askYesDefault(prompts, "service_user", fmt.Sprintf("Run the service as '%s', who started setup with sudo, instead of root?", name))
+	if err != nil || !runAsUser {
+		return err
+	}
+	account, err := lookupServiceAccount(name)
+	if err != nil {
+		return err
+	}
+	interactive.User, interactive.Group = account.user, account.group
+	interactive.LogFile = serviceLogFile(appName, interactive.LogFile)
+	if err := prepareServiceLog(interactive.LogFile, account); err != nil {
+		return err
+	}
+	prompts.report("SERVICE", "user", account.user, cyanText, fmt.Sprintf("The service will run as %s:%s and log to %s", account.user, account.group, interactive.LogFile))
+	return nil
+}
+
+// lookupServiceAccount checks that name is a real user, because systemd would only fail at start with a vague error.
+func lookupServiceAccount(name string) (serviceAccount, error) {
+	found, err := user.Lookup(name)
+	if err != nil {
+		return serviceAccount{}, fmt.Errorf("service user '%s' does not exist: %v", name, err)
+	}
+	group, err := user.LookupGroupId(found.Gid)
+	if err != nil {
+		return serviceAccount{}, fmt.Errorf("primary group %s of service user '%s' does not exist: %v", found.Gid, name, err)
+	}
+	uid, uidErr := strconv.Atoi(found.Uid)
+	gid, gidErr := strconv.Atoi(found.Gid)
+	if uidErr != nil || gidErr != nil {
+		return serviceAccount{}, fmt.Errorf("service user '%s' has no numeric uid and gid", name)
+	}
+	return serviceAccount{user: found.Username, group: group.Name, uid: uid, gid: gid}, nil
+}
+
+// serviceLogFile moves a log out of /var/log into /var/log/APP, because rotation renames the file and only root may do that in /var/log itself.
+func serviceLogFile(appName, logFile string) string {
+	if filepath.Dir(logFile) != "/var/log" {
+		return logFile
+	}
+	return filepath.Join("/var/log", appName, filepath.Base(logFile))
+}
+
+// serviceLogsDirectory names the /var/log subdirectory systemd should create for the service user, or "" for other log paths.
+// Letting systemd own it keeps the directory writable even where /var/log is rebuilt on every boot.
+func serviceLogsDirectory(logFile string) string {
+	dir := filepath.Dir(logFile)
+	if filepath.Dir(dir) != "/var/log" {
+		return ""
+	}
+	return filepath.Base(dir)
+}
+
+// prepareServiceLog creates the log and its directory and hands the log, and a /var/log subdirectory, to account.
+// A log that root created or appended to before would otherwise be unwritable for the service.
+func prepareServiceLog(logFile string, account serviceAccount) error {
+	dir := filepath.Dir(logFile)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create log directory '%s': %v", dir, err)
+	}
+	if serviceLogsDirectory(logFile) != "" {
+		if err := os.Chown(dir, account.uid, account.gid); err != nil {
+			return fmt.Errorf("failed to hand log directory '%s' to %s: %v", dir, account.user, err)
+		}
+	}
+	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create log file '%s': %v", logFile, err)
+	}
+	file.Close()
+	if err := os.Chown(logFile, account.uid, account.gid); err != nil {
+		return fmt.Errorf("failed to hand log file '%s' to %s: %v", logFile, account.user, err)
+	}
+	return nil
+}
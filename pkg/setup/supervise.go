@@ -0,0 +1,166 @@
+// Package setup also writes runit and s6 service directories for minimalist Linux systems.
+// Both supervisors restart a `run` script that execs the proxy, so signals reach it directly and its output goes to the supervisor's logger.
+package setup
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// serviceKind names the service manager the wizard writes autostart files for.
+type serviceKind string
+
+const (
+	serviceNone    serviceKind = ""
+	serviceSystemd serviceKind = "systemd"
+	serviceRunit   serviceKind = "runit"
+	serviceS6      serviceKind = "s6"
+	serviceInit    serviceKind = "init"
+)
+
+// superviseLayout says where a supervisor keeps service definitions and which directory it scans for enabled ones.
+type superviseLayout struct {
+	kind          serviceKind
+	definitionDir string
+	scanDir       string
+	logCommand    string
+}
+
+// Candidate scan directories per supervisor, in the order distributions commonly use them.
+var (
+	runitScanDirs = []string{"/var/service", "/etc/service", "/run/runit/service"}
+	s6ScanDirs    = []string{"/run/service", "/service", "/etc/s6/service"}
+)
+
+// detectLinuxServiceKind prefers systemd, then a running supervisor, and falls back to SysV scripts.
+// A supervisor counts only when its tools and a scan directory exist, so a stray binary does not redirect setup.
+func detectLinuxServiceKind() serviceKind {
+	switch {
+	case isSystemdAvailable():
+		return serviceSystemd
+	case detectSuperviseLayout(serviceRunit).scanDir != "":
+		return serviceRunit
+	case detectSuperviseLayout(serviceS6).scanDir != "":
+		return serviceS6
+	case isInitAvailable():
+		return serviceInit
+	default:
+		return serviceNone
+	}
+}
+
+// detectSuperviseLayout returns the layout for kind, with an empty scanDir when that supervisor is not installed.
+func detectSuperviseLayout(kind serviceKind) superviseLayout {
+	layout := superviseLayout{kind: kind}
+	var tools, scanDirs []string
+	switch kind {
+	case serviceRunit:
+		tools, scanDirs = []string{"sv", "runsvdir"}, runitScanDirs
+		layout.definitionDir, layout.logCommand = "/etc/sv", "svlogd -tt"
+	case serviceS6:
+		tools, scanDirs = []string{"s6-svscan", "s6-svc"}, s6ScanDirs
+		layout.definitionDir, layout.logCommand = "/etc/s6/sv", "s6-log T"
+	default:
+		return layout
+	}
+
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			return layout
+		}
+	}
+	for _, dir := range scanDirs {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			layout.scanDir = dir
+			break
+		}
+	}
+	return layout
+}
+
+// OfferSuperviseSetup writes a runit or s6 service directory and links it into the scan directory.
+// Linking is what enables and starts the service there, so there is no separate start step.
+func OfferSuperviseSetup(appName string, interactive *InteractiveResult, rotation time.Duration, prompts *Prompter, kind serviceKind) (*SystemdResult, error) {
+	layout := detectSuperviseLayout(kind)
+	if layout.scanDir == "" {
+		return nil, fmt.Errorf("no %s scan directory found", kind)
+	}
+
+	name := initServiceName(interactive.ServiceName)
+	createService, err := askYesDefault(prompts, "create_service", fmt.Sprintf("Create a %s service '%s'?", kind, name))
+	if err != nil {
+		return nil, err
+	}
+	if !createService {
+		return &SystemdResult{FollowLogs: false}, nil
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve executable path: %v", err)
+	}
+
+	serviceDir := filepath.Join(layout.definitionDir, name)
+	if err := os.MkdirAll(filepath.Join(serviceDir, "log"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create service directory: %v", err)
+	}
+	runScript := buildSuperviseRunScript(interactive, rotation, executable)
+	if err := os.WriteFile(filepath.Join(serviceDir, "run"), []byte(runScript), 0755); err != nil {
+		return nil, fmt.Errorf("failed to write run script: %v", err)
+	}
+	logScript := buildSuperviseLogScript(layout, filepath.Join("/var/log", name))
+	if err := os.WriteFile(filepath.Join(serviceDir, "log", "run"), []byte(logScript), 0755); err != nil {
+		return nil, fmt.Errorf("failed to write log run script: %v", err)
+	}
+
+	enableService, err := askYesDefault(prompts, "enable_service", fmt.Sprintf("Link it into %s so it starts now and on boot?", layout.scanDir))
+	if err != nil {
+		return nil, err
+	}
+	if enableService {
+		if err := enableSupervisedService(layout, serviceDir); err != nil {
+			return nil, err
+		}
+	}
+
+	followLogs, err := askYesDefault(prompts, "follow_logs", "Follow the log file now?")
+	if err != nil {
+		return nil, err
+	}
+
+	return &SystemdResult{FollowLogs: followLogs}, nil
+}
+
+// buildSuperviseRunScript renders the `run` script; exec replaces the shell so the supervisor signals the proxy itself.
+func buildSuperviseRunScript(interactive *InteractiveResult, rotation time.Duration, executable string) string {
+	return fmt.Sprintf(`#!/bin/sh
+exec 2>&1
+exec %s %s
+`, shellQuote(executable), shellJoin(buildArgs(interactive, rotation)))
+}
+
+// buildSuperviseLogScript renders `log/run`, which keeps the proxy's stdout and stderr in logDir.
+func buildSuperviseLogScript(layout superviseLayout, logDir string) string {
+	return fmt.Sprintf(`#!/bin/sh
+mkdir -p %s
+exec %s %s
+`, shellQuote(logDir), layout.logCommand, shellQuote(logDir))
+}
+
+// enableSupervisedService links the service into the scan directory; s6 is also told to rescan instead of waiting.
+func enableSupervisedService(layout superviseLayout, serviceDir string) error {
+	link := filepath.Join(layout.scanDir, filepath.Base(serviceDir))
+	if target, err := os.Readlink(link); err == nil && target == serviceDir {
+		return nil
+	}
+	if err := os.Symlink(serviceDir, link); err != nil {
+		return fmt.Errorf("failed to link service into %s: %v", layout.scanDir, err)
+	}
+	if layout.kind == serviceS6 {
+		return runCommand("s6-svscanctl", "-a", layout.scanDir)
+	}
+	return nil
+}
@@ -9,8 +9,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/matveynator/chicha-ip-proxy/pkg/activation"
+	"github.com/matveynator/chicha-ip-proxy/pkg/config"
 )
 
 // SystemdResult captures whether the operator asked to stream logs immediately.
@@ -21,11 +25,10 @@ type SystemdResult struct {
 
 // OfferSystemdSetup proposes creating, enabling, and starting a systemd unit.
 // The function keeps user prompts sequential while delegating long-running work to goroutines where useful.
-func OfferSystemdSetup(appName string, interactive *InteractiveResult, rotation time.Duration) (*SystemdResult, error) {
-	reader := bufio.NewReader(os.Stdin)
+func OfferSystemdSetup(appName string, interactive *InteractiveResult, rotation time.Duration, prompts *Prompter) (*SystemdResult, error) {
 	unitName := systemdUnitName(interactive.ServiceName)
 
-	createSystemd, err := askYesDefault(reader, fmt.Sprintf("Create a systemd service '%s'?", unitName))
+	createSystemd, err := askYesDefault(prompts, "create_service", fmt.Sprintf("Create a systemd service '%s'?", unitName))
 	if err != nil {
 		return nil, err
 	}
@@ -33,33 +36,40 @@ func OfferSystemdSetup(appName string, interactive *InteractiveResult, rotation
 		return &SystemdResult{FollowLogs: false}, nil
 	}
 
+	if err := offerServiceUser(appName, interactive, prompts); err != nil {
+		return nil, err
+	}
+
 	executable, err := os.Executable()
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve executable path: %v", err)
 	}
 
-	unitContent := buildUnitFile(appName, interactive, rotation, executable)
-	unitPath := filepath.Join("/etc/systemd/system", unitName)
-	if err := os.WriteFile(unitPath, []byte(unitContent), 0644); err != nil {
-		return nil, fmt.Errorf("failed to write systemd unit: %v", err)
+	units := buildSystemdFiles(appName, interactive, rotation, executable)
+	for _, unit := range units {
+		if err := os.WriteFile(filepath.Join("/etc/systemd/system", unit.name), []byte(unit.content), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write systemd unit %s: %v", unit.name, err)
+		}
 	}
+	socketUnits := units[1:]
 
 	if err := reloadSystemd(); err != nil {
 		return nil, err
 	}
 
-	enableSystemd, err := askYesDefault(reader, "Enable the service so it starts on boot?")
+	enableSystemd, err := askYesDefault(prompts, "enable_service", "Enable the service so it starts on boot?")
 	if err != nil {
 		return nil, err
 	}
 
 	if enableSystemd {
-		if err := runSystemctl("enable", unitName); err != nil {
+		// Enabling the sockets too lets systemd bind the ports at boot even before the service starts.
+		if err := runSystemctl(append([]string{"enable", unitName}, socketUnitNames(socketUnits)...)...); err != nil {
 			return nil, err
 		}
 	}
 
-	startSystemd, err := askYesDefault(reader, "Start the service now?")
+	startSystemd, err := askYesDefault(prompts, "start_service", "Start the service now?")
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +80,7 @@ func OfferSystemdSetup(appName string, interactive *InteractiveResult, rotation
 		}
 	}
 
-	followLogs, err := askYesDefault(reader, "Follow the log file now?")
+	followLogs, err := askYesDefault(prompts, "follow_logs", "Follow the log file now?")
 	if err != nil {
 		return nil, err
 	}
@@ -110,24 +120,128 @@ func StreamLogs(logFile string, stop <-chan struct{}) {
 	}
 }
 
+// buildSystemdFiles renders the service unit followed by its socket units, ready to be installed or written for review.
+func buildSystemdFiles(appName string, interactive *InteractiveResult, rotation time.Duration, executable string) []serviceFile {
+	service := serviceFile{name: systemdUnitName(interactive.ServiceName), content: buildUnitFile(appName, interactive, rotation, executable)}
+	return append([]serviceFile{service}, buildSocketUnits(appName, interactive)...)
+}
+
 // buildUnitFile composes a systemd unit with explicit log file arguments and rotation schedule.
 // Embedding the rotation flag keeps the service aligned with interactive defaults.
 func buildUnitFile(appName string, interactive *InteractiveResult, rotation time.Duration, executable string) string {
 	args := buildArgs(interactive, rotation)
 	execArgs := append([]string{executable}, args...)
 
+	after := "network.target"
+	requires := ""
+	if sockets := socketUnitNames(buildSocketUnits(appName, interactive)); len(sockets) > 0 {
+		after += " " + strings.Join(sockets, " ")
+		requires = "Requires=" + strings.Join(sockets, " ") + "\n"
+	}
+
 	return fmt.Sprintf(`[Unit]
 Description=%s proxy service
-After=network.target
-
+After=%s
+%s
 [Service]
-Type=simple
-ExecStart=%s
+%s%sExecStart=%s
 Restart=on-failure
 
 [Install]
 WantedBy=multi-user.target
-`, appName, systemdJoin(execArgs))
+`, appName, after, requires, serviceTypeDirectives(interactive), serviceUserDirectives(appName, interactive), systemdJoin(execArgs))
+}
+
+// serviceTypeDirectives picks Type=notify when asked, which the proxy answers with READY=1 and, under WatchdogSec=, watchdog pings.
+func serviceTypeDirectives(interactive *InteractiveResult) string {
+	if !interactive.Notify {
+		return "Type=simple\n"
+	}
+	directives := "Type=notify\n"
+	if interactive.Watchdog > 0 {
+		// systemd reads a bare number as seconds, so shorter or fractional timeouts are written in milliseconds.
+		if interactive.Watchdog%time.Second == 0 {
+			directives += fmt.Sprintf("WatchdogSec=%d\n", interactive.Watchdog/time.Second)
+		} else {
+			directives += fmt.Sprintf("WatchdogSec=%dms\n", interactive.Watchdog.Milliseconds())
+		}
+	}
+	return directives
+}
+
+// serviceUserDirectives runs the service as interactive.User, if set, and lets it still bind ports below 1024 as root could.
+func serviceUserDirectives(appName string, interactive *InteractiveResult) string {
+	if interactive.User == "" {
+		return ""
+	}
+	directives := fmt.Sprintf("User=%s\nGroup=%s\n", interactive.User, interactive.Group)
+	if needsPrivilegedPort(interactive) {
+		directives += "AmbientCapabilities=CAP_NET_BIND_SERVICE\n"
+	}
+	if dir := serviceLogsDirectory(interactive.LogFile); dir != "" {
+		directives += "LogsDirectory=" + dir + "\n"
+	}
+	return directives
+}
+
+func needsPrivilegedPort(interactive *InteractiveResult) bool {
+	for _, route := range append(append([]config.Route{}, interactive.TCPRoutes...), interactive.UDPRoutes...) {
+		if port, err := strconv.Atoi(route.LocalPort); err == nil && port < 1024 {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceFile is one generated unit or script together with the file name it is installed under.
+type serviceFile struct {
+	name    string
+	content string
+}
+
+// buildSocketUnits renders one .socket unit per route when the operator opted into socket activation.
+// FileDescriptorName carries the route name the proxy matches on, so unit order never matters.
+func buildSocketUnits(appName string, interactive *InteractiveResult) []serviceFile {
+	if !interactive.SocketActivation {
+		return nil
+	}
+
+	serviceUnit := systemdUnitName(interactive.ServiceName)
+	baseName := strings.TrimSuffix(serviceUnit, ".service")
+	units := make([]serviceFile, 0, len(interactive.TCPRoutes)+len(interactive.UDPRoutes))
+	add := func(protocol, listenDirective, localPort string) {
+		fdName := activation.RouteName(protocol, localPort)
+		units = append(units, serviceFile{
+			name: baseName + "-" + fdName + ".socket",
+			content: fmt.Sprintf(`[Unit]
+Description=%s %s/%s listener
+
+[Socket]
+%s=%s
+BindIPv6Only=both
+FileDescriptorName=%s
+Service=%s
+
+[Install]
+WantedBy=sockets.target
+`, appName, protocol, localPort, listenDirective, localPort, fdName, serviceUnit),
+		})
+	}
+	for _, route := range interactive.TCPRoutes {
+		add("tcp", "ListenStream", route.LocalPort)
+	}
+	for _, route := range interactive.UDPRoutes {
+		add("udp", "ListenDatagram", route.LocalPort)
+	}
+	return units
+}
+
+func socketUnitNames(units []serviceFile) []string {
+	names := make([]string, 0, len(units))
+	for _, unit := range units {
+		names = append(names, unit.name)
+	}
+	return names
 }
 
 func systemdUnitName(serviceName string) string {